@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+	"github.com/xo/usql/text"
+)
+
+// buildProfile is the build profile the binary was built with (see the
+// Makefile), selecting the drivers included by build tags.
+var buildProfile = "base"
+
+// driverNames returns the sorted names of the drivers built in.
+func driverNames() []string {
+	var names []string
+	for name := range drivers.Available() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeDrivers writes the build profile and the drivers built in, with their
+// URL scheme aliases.
+func writeDrivers(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Build profile: %s\n%s\n", buildProfile, text.AvailableDrivers); err != nil {
+		return err
+	}
+	for _, name := range driverNames() {
+		s := "  " + name
+		driver, aliases := dburl.SchemeDriverAndAliases(name)
+		if driver != name {
+			s += " (" + driver + ")"
+		}
+		if len(aliases) > 0 {
+			s += " [" + strings.Join(aliases, ", ") + "]"
+		}
+		if _, err := fmt.Fprintln(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
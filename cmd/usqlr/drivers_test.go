@@ -0,0 +1,71 @@
+package main
+
+import (
+	"go/build/constraint"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestWriteDrivers(t *testing.T) {
+	tags := buildTags(t)
+	var buf strings.Builder
+	if err := writeDrivers(&buf); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if exp := "Build profile: " + buildProfile; lines[0] != exp {
+		t.Errorf("expected %q, got: %q", exp, lines[0])
+	}
+	// the build constraints of the driver imports in internal
+	tests := []struct {
+		driver string
+		expr   string
+	}{
+		{"sqlite3", "(!no_base || sqlite3) && !no_sqlite3"},
+		{"postgres", "(!no_base || postgres) && !no_postgres"},
+		{"mysql", "(!no_base || mysql) && !no_mysql"},
+		{"sqlserver", "(!no_base || sqlserver) && !no_sqlserver"},
+		{"clickhouse", "(!no_base || clickhouse) && !no_clickhouse"},
+		{"csvq", "(!no_base || csvq) && !no_csvq"},
+		{"moderncsqlite", "(all || most || moderncsqlite) && !no_moderncsqlite"},
+		{"pgx", "(all || most || pgx) && !no_pgx"},
+		{"duckdb", "(all || most || duckdb) && !no_duckdb"},
+		{"ql", "(all || most || ql) && !no_ql"},
+	}
+	for _, test := range tests {
+		expr, err := constraint.Parse("//go:build " + test.expr)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %v", test.driver, err)
+		}
+		exp := expr.Eval(func(tag string) bool { return tags[tag] })
+		listed := false
+		for _, line := range lines[2:] {
+			if name, _, _ := strings.Cut(strings.TrimPrefix(line, "  "), " "); name == test.driver {
+				listed = true
+			}
+		}
+		if listed != exp {
+			t.Errorf("%s: expected listed %t, got: %t", test.driver, exp, listed)
+		}
+	}
+}
+
+// buildTags returns the build tags the test was built with, and those of its
+// platform.
+func buildTags(t *testing.T) map[string]bool {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		t.Fatalf("expected build info")
+	}
+	tags := map[string]bool{runtime.GOOS: true, runtime.GOARCH: true}
+	for _, s := range info.Settings {
+		if s.Key == "-tags" {
+			for _, tag := range strings.Split(s.Value, ",") {
+				tags[tag] = true
+			}
+		}
+	}
+	return tags
+}
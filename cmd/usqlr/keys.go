@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xo/usql/server"
+)
+
+// keysTarget is where the keys commands manage keys: the admin API of a
+// running server, or the keys file.
+type keysTarget struct {
+	serverURL  string
+	file       string
+	configFile string
+}
+
+// flags adds the target's flags to the command.
+func (t *keysTarget) flags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&t.serverURL, "server", "", "URL of a running server to manage keys with its admin API")
+	cmd.Flags().StringVar(&t.file, "file", "", "keys file to manage directly (default the config's auth.keys_file)")
+	cmd.Flags().StringVarP(&t.configFile, "config", "c", "", "config file path")
+}
+
+// store opens the keys file, when keys are not managed with the admin API.
+func (t *keysTarget) store() (*server.KeyStore, error) {
+	file := t.file
+	if file == "" {
+		config, err := loadConfig(t.configFile, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		file = config.Auth.KeysFile
+	}
+	if file == "" {
+		return nil, fmt.Errorf("no keys file specified")
+	}
+	return server.OpenKeyStore(file)
+}
+
+// newKeysCommand creates the keys command.
+func newKeysCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage API keys",
+		Long:  "Manages the API keys authenticating requests, with the admin API of a running server (--server, authenticated with the admin key in $" + apiKeyEnv + ") or directly in the keys file, such as to create the first admin key. A running server reads keys changed in the file once its config is reloaded.",
+	}
+	cmd.AddCommand(newKeysCreateCommand())
+	cmd.AddCommand(newKeysListCommand())
+	cmd.AddCommand(newKeysRotateCommand())
+	cmd.AddCommand(newKeysRevokeCommand())
+	return cmd
+}
+
+// newKeysCreateCommand creates the keys create command.
+func newKeysCreateCommand() *cobra.Command {
+	var target keysTarget
+	var req server.KeyRequest
+	var attrs []string
+
+	cmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create an API key",
+		Long:  "Creates an API key authenticating requests as NAME, printing its secret, which is not shown again.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req.Name = args[0]
+			for _, attr := range attrs {
+				name, value, ok := strings.Cut(attr, "=")
+				if !ok {
+					return fmt.Errorf("invalid attribute %q: must be name=value", attr)
+				}
+				if req.Attributes == nil {
+					req.Attributes = make(map[string]string)
+				}
+				req.Attributes[name] = value
+			}
+			var key *server.CreatedKey
+			var err error
+			if target.serverURL != "" {
+				key = new(server.CreatedKey)
+				err = adminCall(http.MethodPost, target.serverURL, "/admin/keys", req, key)
+			} else {
+				var ks *server.KeyStore
+				if ks, err = target.store(); err == nil {
+					key, err = ks.Create(req)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			return writeCreatedKey(cmd.OutOrStdout(), key)
+		},
+	}
+
+	target.flags(cmd)
+	cmd.Flags().StringSliceVar(&req.Scopes, "scope", []string{server.ScopeQuery}, "scopes granted to the key (admin, query, global, *, tool:<pattern> or connection:<pattern>)")
+	cmd.Flags().StringVar(&req.TTL, "ttl", "", "how long until the key expires, such as 720h (default never)")
+	cmd.Flags().StringArrayVar(&attrs, "attr", nil, "principal attribute of the key, as name=value")
+
+	return cmd
+}
+
+// newKeysListCommand creates the keys list command.
+func newKeysListCommand() *cobra.Command {
+	var target keysTarget
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List API keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var keys []server.APIKey
+			if target.serverURL != "" {
+				if err := adminCall(http.MethodGet, target.serverURL, "/admin/keys", nil, &keys); err != nil {
+					return err
+				}
+			} else {
+				ks, err := target.store()
+				if err != nil {
+					return err
+				}
+				keys = ks.List()
+			}
+			return writeKeys(cmd.OutOrStdout(), keys)
+		},
+	}
+
+	target.flags(cmd)
+
+	return cmd
+}
+
+// newKeysRotateCommand creates the keys rotate command.
+func newKeysRotateCommand() *cobra.Command {
+	var target keysTarget
+	var grace time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "rotate ID",
+		Short: "Rotate an API key's secret",
+		Long:  "Replaces the secret of the API key with the ID, printing the new secret. The previous secret remains valid for the grace period, so that clients can be updated.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key *server.CreatedKey
+			var err error
+			if target.serverURL != "" {
+				key = new(server.CreatedKey)
+				req := map[string]string{"grace": grace.String()}
+				err = adminCall(http.MethodPost, target.serverURL, "/admin/keys/"+args[0]+"/rotate", req, key)
+			} else {
+				var ks *server.KeyStore
+				if ks, err = target.store(); err == nil {
+					key, err = ks.Rotate(args[0], grace)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			return writeCreatedKey(cmd.OutOrStdout(), key)
+		},
+	}
+
+	target.flags(cmd)
+	cmd.Flags().DurationVar(&grace, "grace", 0, "how long the previous secret remains valid")
+
+	return cmd
+}
+
+// newKeysRevokeCommand creates the keys revoke command.
+func newKeysRevokeCommand() *cobra.Command {
+	var target keysTarget
+
+	cmd := &cobra.Command{
+		Use:   "revoke ID",
+		Short: "Revoke an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if target.serverURL != "" {
+				var res map[string]string
+				if err := adminCall(http.MethodDelete, target.serverURL, "/admin/keys/"+args[0], nil, &res); err != nil {
+					return err
+				}
+			} else {
+				ks, err := target.store()
+				if err != nil {
+					return err
+				}
+				if err := ks.Revoke(args[0]); err != nil {
+					return err
+				}
+			}
+			_, err := fmt.Fprintf(cmd.OutOrStdout(), "revoked key %s\n", args[0])
+			return err
+		},
+	}
+
+	target.flags(cmd)
+
+	return cmd
+}
+
+// writeCreatedKey writes the key that was created or rotated, with its
+// secret.
+func writeCreatedKey(w io.Writer, key *server.CreatedKey) error {
+	fmt.Fprintf(w, "id:      %s\n", key.ID)
+	fmt.Fprintf(w, "name:    %s\n", key.Name)
+	fmt.Fprintf(w, "scopes:  %s\n", strings.Join(key.Scopes, ", "))
+	if !key.ExpiresAt.IsZero() {
+		fmt.Fprintf(w, "expires: %s\n", key.ExpiresAt.Format(time.RFC3339))
+	}
+	_, err := fmt.Fprintf(w, "secret:  %s\n\nThe secret is not shown again.\n", key.Secret)
+	return err
+}
+
+// writeKeys writes the keys as a table.
+func writeKeys(w io.Writer, keys []server.APIKey) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tPREFIX\tCREATED\tEXPIRES")
+	now := time.Now()
+	for _, k := range keys {
+		expires := "never"
+		switch {
+		case k.Expired(now):
+			expires = "expired"
+		case !k.ExpiresAt.IsZero():
+			expires = k.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), k.Prefix, k.CreatedAt.Format(time.RFC3339), expires)
+	}
+	return tw.Flush()
+}
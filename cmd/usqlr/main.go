@@ -5,16 +5,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/xo/dburl"
 	"github.com/xo/usql/server"
-	
+	"github.com/xo/usql/server/logging"
+
 	// Import all database drivers (same as usql)
 	_ "github.com/xo/usql/internal"
 )
@@ -31,14 +35,23 @@ func New() *cobra.Command {
 	var configFile string
 	var addr string
 	var port int
+	var listDrivers bool
+	var local string
 
 	cmd := &cobra.Command{
 		Use:           "usqlr",
 		Short:         "usqlr is the server version of usql with MCP support",
 		Long:          "usqlr transforms usql from a CLI tool into a server that supports multiple database connections and exposes MCP capabilities for AI integration.",
+		Version:       buildInfo().Version,
 		SilenceErrors: true,
 		SilenceUsage:  true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if listDrivers {
+				return writeDrivers(cmd.OutOrStdout())
+			}
+			if local != "" {
+				return runLocal(configFile, local)
+			}
 			return run(configFile, addr, port)
 		},
 	}
@@ -47,59 +60,249 @@ func New() *cobra.Command {
 	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file path")
 	cmd.Flags().StringVarP(&addr, "addr", "a", "0.0.0.0", "server listening address")
 	cmd.Flags().IntVarP(&port, "port", "p", 8080, "server listening port")
+	cmd.Flags().BoolVar(&listDrivers, "drivers", false, "list the database drivers built in, and exit")
+	cmd.Flags().StringVar(&local, "local", "", "serve MCP over stdio with a single connection to a local SQLite or DuckDB database file")
+
+	cmd.AddCommand(newPolicyCommand())
+	cmd.AddCommand(newExportStateCommand())
+	cmd.AddCommand(newImportStateCommand())
+	cmd.AddCommand(newKeysCommand())
 
 	return cmd
 }
 
+// shutdownTimeout bounds shutting the server down once drained.
+const shutdownTimeout = 10 * time.Second
+
 func run(configFile, addr string, port int) error {
 
 	// Load configuration
-	config, err := loadConfig(configFile)
+	config, err := loadConfig(configFile, false)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := logging.Setup(os.Stderr, config.Logging); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
 
 	// Create server
 	srv, err := server.New(config)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
+	srv.SetBuildInfo(buildInfo())
 
 	// Set up graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
+	// Handle shutdown signals, and upgrades once the new process took over,
+	// draining the work in progress before the server stops listening
+	upgraded := make(chan struct{})
+	go watchUpgrade(ctx, srv, upgraded)
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-
-		log.Println("Shutting down server...")
-		cancel()
+		select {
+		case <-sigChan:
+		case <-upgraded:
+		}
+		defer cancel()
 
-		// Give server 30 seconds to shutdown gracefully
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		drainTimeout := config.Server.DrainTimeout
+		slog.Info("shutting down server", "drain_timeout", drainTimeout)
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), drainTimeout+shutdownTimeout)
 		defer shutdownCancel()
 
+		// a second signal shuts down at once
+		go func() {
+			select {
+			case <-sigChan:
+				slog.Warn("shutting down server without draining")
+				shutdownCancel()
+			case <-shutdownCtx.Done():
+			}
+		}()
+
 		if err := srv.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
+			slog.Error("server shutdown error", "error", err)
 		}
 	}()
 
+	srv.ConnectPredefined(ctx)
+	if err := srv.RestoreConnections(ctx); err != nil {
+		return err
+	}
+	go watchConfig(ctx, srv, configFile, func() (*server.Config, error) {
+		return loadConfig(configFile, false)
+	})
+
 	// Start server
-	log.Printf("Starting usqlr server on %s:%d", addr, port)
+	slog.Info("starting usqlr server", "address", fmt.Sprintf("%s:%d", addr, port), "version", buildInfo().Version, "build_profile", buildProfile, "drivers", driverNames())
 	return srv.Listen(ctx, fmt.Sprintf("%s:%d", addr, port))
 }
 
-func loadConfig(configFile string) (*server.Config, error) {
+// runLocal runs the server in local mode, serving MCP over stdio with a
+// single connection to the SQLite or DuckDB database file at path.
+func runLocal(configFile, path string) error {
+	typ, err := localDriver(path)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadConfig(configFile, true)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	// stdio has no credentials to authenticate
+	config.Auth = server.AuthConfig{}
+	// stdout carries the MCP messages, and the log stderr
+	if err := logging.Setup(os.Stderr, config.Logging); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+
+	srv, err := server.New(config)
+	if err != nil {
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+	srv.SetBuildInfo(buildInfo())
+	defer func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			slog.Error("server shutdown error", "error", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := srv.CreateConnection(ctx, localConnectionID, typ+":"+path); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", path, err)
+	}
+
+	srv.ConnectPredefined(ctx)
+	if err := srv.RestoreConnections(ctx); err != nil {
+		return err
+	}
+	go watchConfig(ctx, srv, configFile, func() (*server.Config, error) {
+		config, err := loadConfig(configFile, true)
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = server.AuthConfig{}
+		return config, nil
+	})
+
+	slog.Info("serving MCP over stdio", "connection", localConnectionID, "path", path, "driver", typ)
+	return srv.ServeStdio(ctx, os.Stdin, os.Stdout)
+}
+
+// localDriver returns the driver for the local database file at path,
+// detected from its header, or for a new file, from its extension (SQLite
+// unless .duckdb).
+func localDriver(path string) (string, error) {
+	fi, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if strings.EqualFold(filepath.Ext(path), ".duckdb") {
+			return "duckdb", nil
+		}
+		return "sqlite3", nil
+	case err != nil:
+		return "", err
+	case !fi.Mode().IsRegular():
+		return "", fmt.Errorf("%s is not a database file", path)
+	}
+	typ, err := dburl.SchemeType(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the database type of %s: %w", path, err)
+	}
+	if typ != "sqlite3" && typ != "duckdb" {
+		return "", fmt.Errorf("%s is not a SQLite or DuckDB database", path)
+	}
+	return typ, nil
+}
+
+// localConnectionID is the ID of the connection to the database in local
+// mode.
+const localConnectionID = "local"
+
+// loadConfig loads the configuration from the config file, if any, the
+// environment and the defaults, which are the local mode guardrails when
+// local is true.
+func loadConfig(configFile string, local bool) (*server.Config, error) {
 	v := viper.New()
-	
+
 	// Set defaults
 	v.SetDefault("server.max_connections", 100)
 	v.SetDefault("server.request_timeout", "30s")
+	v.SetDefault("server.drain_timeout", "30s")
 	v.SetDefault("server.enable_mcp", true)
 	v.SetDefault("server.enable_cors", true)
+	v.SetDefault("server.enable_admin", false)
+	v.SetDefault("server.cursor_ttl", "5m")
+	v.SetDefault("server.max_cursors", 100)
+	v.SetDefault("server.session_idle_timeout", "30m")
+	v.SetDefault("server.max_sessions", 1000)
+	v.SetDefault("server.transaction_timeout", "5m")
+	v.SetDefault("server.connection_idle_timeout", 0)
+	v.SetDefault("server.connection_max_lifetime", 0)
+	v.SetDefault("server.pool_limit_policy", "strict")
+	v.SetDefault("server.health_check_interval", "30s")
+	v.SetDefault("server.reconnect_max_backoff", "5m")
+	v.SetDefault("server.failover_after", 3)
+	v.SetDefault("server.slow_query_threshold", 0)
+	v.SetDefault("server.query_stats_window", "1h")
+	v.SetDefault("server.max_query_fingerprints", 1000)
+	v.SetDefault("server.max_rows", 10000)
+	v.SetDefault("server.propagate_timeouts", true)
+	v.SetDefault("server.max_result_bytes", 64<<20)
+	v.SetDefault("server.spill_max_bytes", 1<<30)
+	v.SetDefault("server.time_format", "rfc3339")
+	v.SetDefault("server.time_zone", "UTC")
+	v.SetDefault("server.nulls", "null")
+	v.SetDefault("server.null_sentinel", "NULL")
+	v.SetDefault("server.compression.enabled", true)
+	v.SetDefault("server.compression.min_size", 1024)
+	v.SetDefault("server.max_concurrent_queries", 0)
+	v.SetDefault("server.max_prepared_statements", 100)
+	v.SetDefault("server.max_concurrent_per_host", 0)
+	v.SetDefault("faults.slow_delay", "2s")
+	v.SetDefault("jobs.workers", 4)
+	v.SetDefault("jobs.max_queued", 100)
+	v.SetDefault("jobs.timeout", "1h")
+	v.SetDefault("jobs.result_ttl", "1h")
+	v.SetDefault("jobs.max_result_rows", 100000)
+	v.SetDefault("auth.api_key_header", "X-API-Key")
+	v.SetDefault("auth.jwt.principal_claim", "sub")
+	v.SetDefault("auth.jwt.scopes_claim", "scope")
+	v.SetDefault("auth.oauth.scopes", []string{"query"})
+	v.SetDefault("auth.oauth.principal_claim", "sub")
+	v.SetDefault("auth.oauth.scopes_claim", "scope")
+	v.SetDefault("policy.default", "allow")
+	v.SetDefault("cache.max_entries", 1000)
+	v.SetDefault("cache.max_bytes", 64<<20)
+	v.SetDefault("storage.hot_records", 10000)
+	v.SetDefault("storage.hot_max_age", "1h")
+	v.SetDefault("storage.cold_max_age", "720h")
+	v.SetDefault("storage.cold_max_bytes", 1<<30)
+	v.SetDefault("history.enabled", true)
+	v.SetDefault("metrics.path", "/metrics")
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.format", "text")
+	v.SetDefault("persistence.passphrase_env", "USQLR_STATE_PASSPHRASE")
+	v.SetDefault("secrets.exec_timeout", "10s")
+	v.SetDefault("secrets.vault.token_env", "VAULT_TOKEN")
+	v.SetDefault("redis.prefix", "usqlr:")
+	if local {
+		// a single user's client, limited to a few local connections and
+		// results sized for its context
+		v.SetDefault("server.max_connections", 4)
+		v.SetDefault("server.max_rows", 1000)
+		v.SetDefault("server.max_result_bytes", 16<<20)
+		v.SetDefault("server.max_concurrent_queries", 4)
+		v.SetDefault("server.enable_cors", false)
+		v.SetDefault("jobs.workers", 1)
+	}
 
 	if configFile != "" {
 		v.SetConfigFile(configFile)
@@ -120,4 +323,4 @@ func loadConfig(configFile string) (*server.Config, error) {
 	}
 
 	return &config, nil
-}
\ No newline at end of file
+}
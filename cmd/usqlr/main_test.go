@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalDriver(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"data.bin":  "SQLite format 3\000",
+		"app.db":    "SQLite format 3\000",
+		"events.db": "\000\000\000\000\000\000\000\000DUCK\000\000\000\000\000\000\000\000",
+		"empty.db":  "",
+		"notes.txt": "not a database",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.db"), 0o700); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		name string
+		exp  string
+	}{
+		// new files are SQLite, unless .duckdb
+		{"new.duckdb", "duckdb"},
+		{"new.DuckDB", "duckdb"},
+		{"new.db", "sqlite3"},
+		{"new.csv", "sqlite3"},
+		{"new", "sqlite3"},
+		{"missing/new.duckdb", "duckdb"},
+		// existing files are detected from their header
+		{"data.bin", "sqlite3"},
+		{"app.db", "sqlite3"},
+		{"events.db", "duckdb"},
+		{"empty.db", "sqlite3"},
+		// directories and other files are not databases
+		{"dir.db", ""},
+		{"notes.txt", ""},
+	}
+	for _, test := range tests {
+		driver, err := localDriver(filepath.Join(dir, test.name))
+		switch {
+		case test.exp == "" && err == nil:
+			t.Errorf("%s: expected an error, got: %s", test.name, driver)
+		case test.exp != "" && err != nil:
+			t.Errorf("%s: expected no error, got: %v", test.name, err)
+		case driver != test.exp:
+			t.Errorf("%s: expected %q, got: %q", test.name, test.exp, driver)
+		}
+	}
+}
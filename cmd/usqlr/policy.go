@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/xo/usql/server"
+	"github.com/xo/usql/server/policy"
+)
+
+// newPolicyCommand creates the policy command.
+func newPolicyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Manage statement policies",
+	}
+	cmd.AddCommand(newPolicyTestCommand())
+	return cmd
+}
+
+// newPolicyTestCommand creates the policy test command.
+func newPolicyTestCommand() *cobra.Command {
+	var configFile, dir, defaultAction, connection, principal string
+	var files []string
+
+	cmd := &cobra.Command{
+		Use:   "test [statement...]",
+		Short: "Evaluate sample statements against the policies",
+		Long:  "Evaluates sample SQL statements against the policy documents, printing the allow, deny or require_approval decision for each statement. Statements are read from the arguments, from --file, or from stdin when neither is given.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadConfig(configFile, false)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if cmd.Flags().Changed("dir") {
+				config.Policy.Dir = dir
+			}
+			if cmd.Flags().Changed("default") {
+				config.Policy.Default = defaultAction
+			}
+			if config.Policy.Dir == "" {
+				return fmt.Errorf("no policy directory specified")
+			}
+			engine, err := server.NewPolicyEngine(config.Policy)
+			if err != nil {
+				return fmt.Errorf("failed to load policies: %w", err)
+			}
+
+			sql := strings.Join(args, ";\n")
+			for _, name := range files {
+				buf, err := os.ReadFile(name)
+				if err != nil {
+					return err
+				}
+				sql += ";\n" + string(buf)
+			}
+			if len(args) == 0 && len(files) == 0 {
+				buf, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return err
+				}
+				sql = string(buf)
+			}
+			return printDecisions(cmd.OutOrStdout(), engine.EvaluateAll(connection, principal, sql))
+		},
+	}
+
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file path")
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "policy directory (overrides policy.dir)")
+	cmd.Flags().StringVar(&defaultAction, "default", "", "default action for unmatched statements (allow or deny)")
+	cmd.Flags().StringVar(&connection, "connection", "", "connection ID the statements are evaluated for")
+	cmd.Flags().StringVar(&principal, "principal", "", "principal the statements are evaluated for")
+	cmd.Flags().StringArrayVarP(&files, "file", "f", nil, "file containing statements")
+
+	return cmd
+}
+
+// printDecisions prints the policy decisions as a table.
+func printDecisions(w io.Writer, decisions []policy.Decision) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DECISION\tCATEGORY\tTYPE\tPOLICY\tSTATEMENT\tMESSAGE")
+	for _, d := range decisions {
+		source := "(default)"
+		if d.Policy != "" {
+			source = fmt.Sprintf("%s#%d", d.Policy, d.Rule)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", strings.ToUpper(string(d.Action)), d.Category, d.Type, source, strings.Join(strings.Fields(d.Statement), " "), d.Message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/xo/usql/server"
+)
+
+// reloadDelay is how long config file changes settle before the config is
+// reloaded, as editors write files in several steps.
+const reloadDelay = 250 * time.Millisecond
+
+// watchConfig reloads the server's config with load on SIGHUP, and once the
+// config file changed, until ctx is done. The directory of the config file is
+// watched, so that files replaced rather than written in place are reloaded.
+func watchConfig(ctx context.Context, srv *server.Server, configFile string, load func() (*server.Config, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if configFile != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			slog.Error("failed to watch config file", "error", err)
+		} else {
+			defer watcher.Close()
+			if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+				slog.Error("failed to watch config file", "error", err)
+			} else {
+				events, errs = watcher.Events, watcher.Errors
+			}
+		}
+	}
+
+	path := filepath.Clean(configFile)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reloadConfig(ctx, srv, load)
+		case event := <-events:
+			if filepath.Clean(event.Name) == path && event.Has(fsnotify.Write|fsnotify.Create) {
+				settled = time.After(reloadDelay)
+			}
+		case err := <-errs:
+			slog.Error("config file watcher error", "error", err)
+		case <-settled:
+			settled = nil
+			reloadConfig(ctx, srv, load)
+		}
+	}
+}
+
+// reloadConfig loads the config and applies it to the server, keeping the
+// current config when it is invalid.
+func reloadConfig(ctx context.Context, srv *server.Server, load func() (*server.Config, error)) {
+	config, err := load()
+	if err == nil {
+		err = srv.Reload(ctx, config)
+	}
+	if err != nil {
+		slog.Error("failed to reload config", "error", err)
+		return
+	}
+	slog.Info("reloaded config")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xo/usql/server"
+)
+
+// passphraseEnv is the environment variable holding the passphrase used to
+// encrypt connection definitions in state bundles.
+const passphraseEnv = "USQLR_STATE_PASSPHRASE"
+
+// apiKeyEnv is the environment variable holding the API key admin API
+// requests are authenticated with, and apiKeyHeaderEnv the one holding the
+// header it is sent in, as configured for the server.
+const (
+	apiKeyEnv       = "USQLR_API_KEY"
+	apiKeyHeaderEnv = "USQLR_AUTH_API_KEY_HEADER"
+)
+
+// newExportStateCommand creates the export-state command.
+func newExportStateCommand() *cobra.Command {
+	var serverURL, output string
+
+	cmd := &cobra.Command{
+		Use:   "export-state",
+		Short: "Export a running server's state to a bundle",
+		Long:  "Exports the connection definitions, saved queries and policies of a running server (with the admin API enabled) to a portable JSON bundle. Connection definitions are encrypted with the passphrase in $" + passphraseEnv + ", and omitted when it is not set.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var bundle json.RawMessage
+			req := map[string]string{"passphrase": os.Getenv(passphraseEnv)}
+			if err := adminRequest(serverURL, "/admin/state/export", req, &bundle); err != nil {
+				return err
+			}
+			if output == "" || output == "-" {
+				_, err := fmt.Fprintln(cmd.OutOrStdout(), string(bundle))
+				return err
+			}
+			return os.WriteFile(output, append(bundle, '\n'), 0o600)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "http://localhost:8080", "server URL")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "bundle file to write (default stdout)")
+
+	return cmd
+}
+
+// newImportStateCommand creates the import-state command.
+func newImportStateCommand() *cobra.Command {
+	var serverURL, input string
+
+	cmd := &cobra.Command{
+		Use:   "import-state",
+		Short: "Import a state bundle into a running server",
+		Long:  "Imports a bundle written by export-state into a running server (with the admin API enabled), replacing its saved queries and policies and creating the bundle's connections. Encrypted connection definitions are decrypted with the passphrase in $" + passphraseEnv + ".",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var buf []byte
+			var err error
+			if input == "" || input == "-" {
+				buf, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				buf, err = os.ReadFile(input)
+			}
+			if err != nil {
+				return err
+			}
+			var bundle server.StateBundle
+			if err := json.Unmarshal(buf, &bundle); err != nil {
+				return fmt.Errorf("invalid state bundle: %w", err)
+			}
+
+			var report server.ImportReport
+			req := map[string]interface{}{
+				"passphrase": os.Getenv(passphraseEnv),
+				"bundle":     &bundle,
+			}
+			if err := adminRequest(serverURL, "/admin/state/import", req, &report); err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "imported %d saved queries and %d policies\n", report.Queries, report.Policies)
+			if len(report.Created) != 0 {
+				fmt.Fprintf(out, "created connections: %s\n", strings.Join(report.Created, ", "))
+			}
+			if len(report.Skipped) != 0 {
+				fmt.Fprintf(out, "skipped existing connections: %s\n", strings.Join(report.Skipped, ", "))
+			}
+			for id, msg := range report.Failed {
+				fmt.Fprintf(out, "failed to create connection %s: %s\n", id, msg)
+			}
+			if len(report.Failed) != 0 {
+				return fmt.Errorf("%d connections could not be created", len(report.Failed))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "http://localhost:8080", "server URL")
+	cmd.Flags().StringVarP(&input, "input", "i", "", "bundle file to read (default stdin)")
+
+	return cmd
+}
+
+// adminRequest posts req to the admin API endpoint, decoding the response
+// into v.
+func adminRequest(serverURL, path string, req, v interface{}) error {
+	return adminCall(http.MethodPost, serverURL, path, req, v)
+}
+
+// adminCall makes a request with the method to the admin API endpoint,
+// sending req (if any) and decoding the response into v. Requests are
+// authenticated with the API key in $USQLR_API_KEY, when set.
+func adminCall(method, serverURL, path string, req, v interface{}) error {
+	var body io.Reader
+	if req != nil {
+		buf, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+	r, err := http.NewRequest(method, strings.TrimSuffix(serverURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if key := os.Getenv(apiKeyEnv); key != "" {
+		header := os.Getenv(apiKeyHeaderEnv)
+		if header == "" {
+			header = "X-API-Key"
+		}
+		r.Header.Set(header, key)
+	}
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("server error: %s", e.Error)
+		}
+		return fmt.Errorf("server error: %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
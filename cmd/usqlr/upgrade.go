@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/xo/usql/server"
+)
+
+// upgradeTimeout bounds waiting for the process started on upgrades to be
+// ready to take over.
+const upgradeTimeout = time.Minute
+
+// watchUpgrade starts a new process to take over the server's listeners on
+// SIGUSR2, closing upgraded once it took over, until ctx is done. Upgrades
+// failing leave the server serving on.
+func watchUpgrade(ctx context.Context, srv *server.Server, upgraded chan<- struct{}) {
+	usr2 := make(chan os.Signal, 1)
+	if !notifyUpgrade(usr2) {
+		return
+	}
+	defer signal.Stop(usr2)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr2:
+			slog.Info("upgrading server")
+			upgradeCtx, cancel := context.WithTimeout(ctx, upgradeTimeout)
+			err := srv.Upgrade(upgradeCtx)
+			cancel()
+			if err != nil {
+				slog.Error("failed to upgrade server", "error", err)
+				continue
+			}
+			close(upgraded)
+			return
+		}
+	}
+}
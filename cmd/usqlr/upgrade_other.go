@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyUpgrade reports upgrades are not supported, as the listeners cannot
+// be passed to a new process.
+func notifyUpgrade(c chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/xo/usql/server"
+)
+
+// version, commit and buildDate identify the build, set with -ldflags -X
+// (see the Makefile). When not set, they are read from the build info Go
+// embeds in the binary.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// buildInfo returns the build info of the binary.
+func buildInfo() server.BuildInfo {
+	info := server.ReadBuildInfo()
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Commit = commit
+	}
+	if buildDate != "" {
+		info.BuildDate = buildDate
+	}
+	info.Profile = buildProfile
+	return info
+}
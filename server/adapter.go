@@ -2,8 +2,11 @@ package server
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/xo/usql/server/mcp"
+	"github.com/xo/usql/server/policy"
 )
 
 // PoolAdapter adapts ConnectionPool to implement the mcp.ConnectionPool interface.
@@ -17,27 +20,81 @@ func NewPoolAdapter(pool *ConnectionPool) *PoolAdapter {
 }
 
 // CreateConnection implements mcp.ConnectionPool interface.
-func (pa *PoolAdapter) CreateConnection(ctx context.Context, id, dsn string) (mcp.Connection, error) {
-	conn, err := pa.pool.CreateConnection(ctx, id, dsn)
+func (pa *PoolAdapter) CreateConnection(ctx context.Context, id, dsn string, tls *mcp.ConnectionTLS) (mcp.Connection, error) {
+	conn, err := pa.pool.CreateConnection(ctx, id, dsn, databaseTLS(tls))
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// Return an adapter that implements mcp.Connection
 	return &ConnectionAdapter{conn: conn.(*Connection)}, nil
 }
 
+// RegisterConnection implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) RegisterConnection(ctx context.Context, id, dsn string, tls *mcp.ConnectionTLS) error {
+	return pa.pool.RegisterConnection(ctx, id, dsn, databaseTLS(tls))
+}
+
+// databaseTLS converts the MCP TLS settings.
+func databaseTLS(tls *mcp.ConnectionTLS) *DatabaseTLS {
+	if tls == nil {
+		return nil
+	}
+	return &DatabaseTLS{
+		Mode:       tls.Mode,
+		CA:         tls.CA,
+		Cert:       tls.Cert,
+		Key:        tls.Key,
+		ServerName: tls.ServerName,
+	}
+}
+
+// RenameConnection implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) RenameConnection(id, newID string) error {
+	return pa.pool.RenameConnection(id, newID)
+}
+
+// AddAlias implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) AddAlias(id, alias string) error {
+	return pa.pool.AddAlias(id, alias)
+}
+
+// RemoveAlias implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) RemoveAlias(alias string) error {
+	return pa.pool.RemoveAlias(alias)
+}
+
+// SetTags implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) SetTags(id string, tags map[string]string) error {
+	return pa.pool.SetTags(id, tags)
+}
+
+// SetFailover implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) SetFailover(id string, dsns []string) error {
+	return pa.pool.SetFailover(id, dsns)
+}
+
+// AddReplica implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) AddReplica(ctx context.Context, id, dsn string) error {
+	return pa.pool.AddReplica(ctx, id, dsn)
+}
+
 // GetConnection implements mcp.ConnectionPool interface.
 func (pa *PoolAdapter) GetConnection(id string) (mcp.Connection, error) {
 	conn, err := pa.pool.GetConnection(id)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	// Return an adapter that implements mcp.Connection
 	return &ConnectionAdapter{conn: conn.(*Connection)}, nil
 }
 
+// IsGroup implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) IsGroup(id string) bool {
+	return pa.pool.IsGroup(id)
+}
+
 // CloseConnection implements mcp.ConnectionPool interface.
 func (pa *PoolAdapter) CloseConnection(id string) error {
 	return pa.pool.CloseConnection(id)
@@ -47,16 +104,35 @@ func (pa *PoolAdapter) CloseConnection(id string) error {
 func (pa *PoolAdapter) ListConnections() map[string]mcp.ConnectionInfo {
 	connections := pa.pool.ListConnections()
 	result := make(map[string]mcp.ConnectionInfo, len(connections))
-	
+
 	for id, conn := range connections {
 		result[id] = mcp.ConnectionInfo{
 			ID:       conn.ID,
 			Driver:   conn.Driver,
 			Host:     conn.Host,
 			Database: conn.Database,
+			Suspect:  conn.Suspect,
+			Created:  conn.Created,
+			LastUsed: conn.LastUsed,
+			Aliases:  conn.Aliases,
+			Tags:     conn.Tags,
+			Pending:  conn.Pending,
+		}
+		if h := conn.Health; h != nil {
+			info := result[id]
+			info.Health = &mcp.ConnectionHealth{
+				Healthy:     h.Healthy,
+				CheckedAt:   h.CheckedAt,
+				Error:       h.Error,
+				Failures:    h.Failures,
+				Checks:      h.Checks,
+				Reconnects:  h.Reconnects,
+				NextAttempt: h.NextAttempt,
+			}
+			result[id] = info
 		}
 	}
-	
+
 	return result
 }
 
@@ -65,6 +141,24 @@ func (pa *PoolAdapter) CheckConnection(ctx context.Context, id string) error {
 	return pa.pool.CheckConnection(ctx, id)
 }
 
+// ValidateDSN implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) ValidateDSN(dsn string) *mcp.DSNInfo {
+	info := ValidateDSN(dsn)
+	return &mcp.DSNInfo{
+		Valid:      info.Valid,
+		Error:      info.Error,
+		Driver:     info.Driver,
+		Available:  info.Available,
+		URL:        info.URL,
+		Transport:  info.Transport,
+		Host:       info.Host,
+		Port:       info.Port,
+		Database:   info.Database,
+		User:       info.User,
+		SecretRefs: info.SecretRefs,
+	}
+}
+
 // ConnectionAdapter adapts Connection to implement the mcp.Connection interface.
 type ConnectionAdapter struct {
 	conn *Connection
@@ -76,12 +170,27 @@ func (ca *ConnectionAdapter) ExecuteQuery(ctx context.Context, query string, arg
 	if err != nil {
 		return nil, err
 	}
-	
-	return &mcp.QueryResult{
-		Columns:     result.Columns,
-		ColumnTypes: result.ColumnTypes,
-		Rows:        result.Rows,
-	}, nil
+
+	return convertQueryResult(result), nil
+}
+
+// QueryRows implements mcp.Connection interface.
+func (ca *ConnectionAdapter) QueryRows(ctx context.Context, query string, args ...interface{}) (mcp.Rows, error) {
+	it, err := ca.conn.QueryRows(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rowsAdapter{it}, nil
+}
+
+// rowsAdapter adapts a row iterator to the mcp.Rows interface.
+type rowsAdapter struct {
+	*RowIterator
+}
+
+// Columns implements mcp.Rows interface.
+func (ra rowsAdapter) Columns() ([]string, error) {
+	return ra.RowIterator.Columns, nil
 }
 
 // ExecuteStatement implements mcp.Connection interface.
@@ -90,9 +199,390 @@ func (ca *ConnectionAdapter) ExecuteStatement(ctx context.Context, query string,
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return &mcp.StatementResult{
 		RowsAffected: result.RowsAffected,
 		LastInsertId: result.LastInsertId,
+		Provenance:   convertProvenance(result.Provenance),
+		Risk:         convertRisk(result.Risk),
 	}, nil
-}
\ No newline at end of file
+}
+
+// AdviseIndexes implements mcp.Connection interface.
+func (ca *ConnectionAdapter) AdviseIndexes(ctx context.Context, query string, args ...interface{}) (*mcp.IndexAdvice, error) {
+	advice, err := ca.conn.AdviseIndexes(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &mcp.IndexAdvice{
+		Note:        advice.Note,
+		Plan:        advice.Plan,
+		Suggestions: make([]mcp.IndexSuggestion, len(advice.Suggestions)),
+	}
+	for i, s := range advice.Suggestions {
+		result.Suggestions[i] = mcp.IndexSuggestion(s)
+	}
+	return result, nil
+}
+
+// CallProcedure implements mcp.Connection interface.
+func (ca *ConnectionAdapter) CallProcedure(ctx context.Context, name string, params []mcp.ProcedureParam) (*mcp.ProcedureResult, error) {
+	procParams := make([]ProcedureParam, len(params))
+	for i, p := range params {
+		procParams[i] = ProcedureParam(p)
+	}
+
+	result, err := ca.conn.CallProcedure(ctx, name, procParams)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]*mcp.QueryResult, len(result.ResultSets))
+	for i, set := range result.ResultSets {
+		sets[i] = convertQueryResult(set)
+	}
+	return &mcp.ProcedureResult{
+		ResultSets: sets,
+		Out:        result.Out,
+		Provenance: convertProvenance(result.Provenance),
+	}, nil
+}
+
+// QueryPage implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) QueryPage(ctx context.Context, connectionID, query string, maxRows int, limits mcp.ResultLimits, args ...interface{}) (*mcp.QueryResult, error) {
+	result, err := pa.pool.QueryPage(ctx, connectionID, query, maxRows, ResultLimits(limits), args...)
+	if err != nil {
+		return nil, err
+	}
+	return convertQueryResult(result), nil
+}
+
+// ContinueQuery implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) ContinueQuery(ctx context.Context, connectionID, token string, maxRows int, limits mcp.ResultLimits) (*mcp.QueryResult, error) {
+	result, err := pa.pool.ContinueQuery(ctx, connectionID, token, maxRows, ResultLimits(limits))
+	if err != nil {
+		return nil, err
+	}
+	return convertQueryResult(result), nil
+}
+
+// OpenCursor implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) OpenCursor(ctx context.Context, connectionID, query string, args ...interface{}) (*mcp.CursorInfo, error) {
+	cursor, err := pa.pool.OpenCursor(ctx, connectionID, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.CursorInfo{
+		CursorID:     cursor.ID,
+		ConnectionID: cursor.ConnectionID,
+		Columns:      cursor.Columns,
+		ColumnTypes:  cursor.ColumnTypes,
+		JSONTypes:    cursor.JSONTypes,
+		ExpiresAt:    cursor.ExpiresAt(),
+		Provenance:   convertProvenance(cursor.Provenance),
+	}, nil
+}
+
+// FetchCursor implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) FetchCursor(ctx context.Context, cursorID string, count int) (*mcp.CursorPage, error) {
+	page, err := pa.pool.FetchCursor(ctx, cursorID, count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.CursorPage{
+		CursorID:  page.CursorID,
+		Rows:      page.Rows,
+		Done:      page.Done,
+		ExpiresAt: page.ExpiresAt,
+		PII:       convertPII(page.PII),
+	}, nil
+}
+
+// InvalidateCache implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) InvalidateCache(connectionID string) int {
+	if connectionID != "" {
+		connectionID = pa.pool.Resolve(connectionID)
+	}
+	return pa.pool.Cache().Invalidate(connectionID)
+}
+
+// CloseCursor implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) CloseCursor(ctx context.Context, cursorID string) error {
+	return pa.pool.CloseCursor(ctx, cursorID)
+}
+
+// CreateSession implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) CreateSession(ctx context.Context) mcp.Session {
+	return sessionAdapter{pa.pool.sessions.Create(ctx), pa.pool.sessions.txTTL}
+}
+
+// GetSession implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) GetSession(ctx context.Context, id string) (mcp.Session, bool) {
+	session, ok := pa.pool.sessions.Get(ctx, id)
+	if !ok {
+		return nil, false
+	}
+	return sessionAdapter{session, pa.pool.sessions.txTTL}, true
+}
+
+// BeginTransaction implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) BeginTransaction(ctx context.Context, connectionID, isolation string, readOnly bool) (*mcp.TransactionInfo, error) {
+	level, err := ParseIsolation(isolation)
+	if err != nil {
+		return nil, err
+	}
+	info, err := pa.pool.BeginTransaction(ctx, connectionID, sql.TxOptions{Isolation: level, ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	return (*mcp.TransactionInfo)(info), nil
+}
+
+// EndTransaction implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) EndTransaction(ctx context.Context, connectionID string, commit bool) (*mcp.TransactionInfo, error) {
+	info, err := pa.pool.EndTransaction(ctx, connectionID, commit)
+	if err != nil {
+		return nil, err
+	}
+	return (*mcp.TransactionInfo)(info), nil
+}
+
+// SearchHistory implements mcp.ConnectionPool interface, searching the
+// history of the connections in the principal's tenant it may use.
+func (pa *PoolAdapter) SearchHistory(ctx context.Context, filter mcp.HistoryFilter) ([]mcp.HistoryEntry, error) {
+	entries, err := pa.pool.history.search(HistoryFilter(filter), func(e HistoryEntry) bool {
+		return policy.InTenant(ctx, e.ConnectionID) && policy.AllowsConnection(ctx, e.ConnectionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	converted := make([]mcp.HistoryEntry, len(entries))
+	for i, e := range entries {
+		converted[i] = mcp.HistoryEntry{
+			Time:         e.Time,
+			Type:         e.Type,
+			ConnectionID: e.ConnectionID,
+			Principal:    e.Principal,
+			Session:      e.Session,
+			SQL:          e.SQL,
+			Rows:         e.Rows,
+			DurationMS:   e.DurationMS,
+			Outcome:      e.Outcome,
+			Error:        e.Error,
+		}
+	}
+	return converted, nil
+}
+
+// QueryStats implements mcp.ConnectionPool interface, returning the stats of
+// the connections in the principal's tenant it may use.
+func (pa *PoolAdapter) QueryStats(ctx context.Context, connectionID, sort string, limit int) ([]mcp.QueryStats, error) {
+	stats, err := pa.pool.QueryStats(connectionID, sort, 0)
+	if err != nil {
+		return nil, err
+	}
+	converted := []mcp.QueryStats{}
+	for _, s := range stats {
+		if !policy.InTenant(ctx, s.ConnectionID) || !policy.AllowsConnection(ctx, s.ConnectionID) {
+			continue
+		}
+		if converted = append(converted, mcp.QueryStats(s)); limit > 0 && len(converted) == limit {
+			break
+		}
+	}
+	return converted, nil
+}
+
+// sessionAdapter adapts a Session to the mcp.Session interface.
+type sessionAdapter struct {
+	*Session
+
+	// ttl is the transaction timeout
+	ttl time.Duration
+}
+
+// ID implements mcp.Session interface.
+func (sa sessionAdapter) ID() string {
+	return sa.Session.ID
+}
+
+// Transactions implements mcp.Session interface.
+func (sa sessionAdapter) Transactions() []mcp.TransactionInfo {
+	infos := sa.Session.Transactions(sa.ttl)
+	converted := make([]mcp.TransactionInfo, len(infos))
+	for i, info := range infos {
+		converted[i] = mcp.TransactionInfo(info)
+	}
+	return converted
+}
+
+// DryRun implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) DryRun(ctx context.Context, connectionID, query string, statement bool, args ...interface{}) (*mcp.DryRunResult, error) {
+	result, err := pa.pool.DryRun(ctx, connectionID, query, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.DryRunResult{
+		Valid:       result.Valid,
+		Error:       result.Error,
+		Type:        result.Type,
+		Category:    result.Category,
+		Parameters:  result.Parameters,
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+	}, nil
+}
+
+// SubmitJob implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) SubmitJob(ctx context.Context, connectionID, query string, args ...interface{}) (*mcp.JobInfo, error) {
+	info, err := pa.pool.SubmitJob(ctx, connectionID, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return convertJobInfo(info), nil
+}
+
+// JobStatus implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) JobStatus(ctx context.Context, jobID string, wait time.Duration) (*mcp.JobInfo, error) {
+	info, err := pa.pool.JobStatus(ctx, jobID, wait)
+	if err != nil {
+		return nil, err
+	}
+	return convertJobInfo(info), nil
+}
+
+// JobResult implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) JobResult(ctx context.Context, jobID string) (*mcp.JobInfo, *mcp.QueryResult, error) {
+	info, result, err := pa.pool.JobResult(ctx, jobID)
+	if info == nil {
+		return nil, nil, err
+	}
+	if err != nil {
+		return convertJobInfo(info), nil, err
+	}
+	return convertJobInfo(info), convertQueryResult(result), nil
+}
+
+// CancelJob implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) CancelJob(ctx context.Context, jobID string) (*mcp.JobInfo, error) {
+	info, err := pa.pool.CancelJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return convertJobInfo(info), nil
+}
+
+// Export implements mcp.ConnectionPool interface.
+func (pa *PoolAdapter) Export(ctx context.Context, connectionID, query, format, path string, args ...interface{}) (*mcp.ExportInfo, error) {
+	info, err := pa.pool.Export(ctx, connectionID, query, format, path, args...)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]mcp.ExportColumn, len(info.Columns))
+	for i, c := range info.Columns {
+		columns[i] = mcp.ExportColumn{Name: c.Name, PhysicalType: c.PhysicalType, LogicalType: c.LogicalType}
+	}
+	return &mcp.ExportInfo{
+		Path:     info.Path,
+		Format:   info.Format,
+		RowCount: info.RowCount,
+		Bytes:    info.Bytes,
+		Columns:  columns,
+		Data:     info.Data,
+	}, nil
+}
+
+// convertJobInfo converts a job status to its MCP representation.
+func convertJobInfo(info *JobInfo) *mcp.JobInfo {
+	return &mcp.JobInfo{
+		JobID:        info.ID,
+		ConnectionID: info.ConnectionID,
+		State:        string(info.State),
+		Error:        info.Error,
+		SubmittedAt:  info.SubmittedAt,
+		StartedAt:    info.StartedAt,
+		FinishedAt:   info.FinishedAt,
+		ExpiresAt:    info.ExpiresAt,
+		RowCount:     info.RowCount,
+		Truncated:    info.Truncated,
+	}
+}
+
+// convertQueryResult converts a query result, including any additional
+// result sets, to its MCP representation.
+func convertQueryResult(result *QueryResult) *mcp.QueryResult {
+	r := &mcp.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		JSONTypes:   result.JSONTypes,
+		Rows:        result.Rows,
+		Provenance:  convertProvenance(result.Provenance),
+		Risk:        convertRisk(result.Risk),
+		PII:         convertPII(result.PII),
+
+		ContinuationToken: result.ContinuationToken,
+		Truncated:         result.Truncated,
+		Cached:            result.Cached,
+	}
+	for _, set := range result.MoreResultSets {
+		r.MoreResultSets = append(r.MoreResultSets, convertQueryResult(set))
+	}
+	return r
+}
+
+// convertProvenance converts a result's provenance to its MCP
+// representation.
+func convertProvenance(p *Provenance) *mcp.Provenance {
+	if p == nil {
+		return nil
+	}
+	v := mcp.Provenance(*p)
+	return &v
+}
+
+// convertPII converts PII findings to their MCP representation.
+func convertPII(findings []policy.PIIFinding) []mcp.PIIFinding {
+	var v []mcp.PIIFinding
+	for _, f := range findings {
+		v = append(v, mcp.PIIFinding(f))
+	}
+	return v
+}
+
+// convertRisk converts a risk classification to its MCP representation.
+func convertRisk(r *policy.Risk) *mcp.Risk {
+	if r == nil {
+		return nil
+	}
+	v := mcp.Risk(*r)
+	return &v
+}
+
+// convertSavedQueries converts the configured saved queries to their MCP
+// representation.
+func convertSavedQueries(queries []SavedQuery) []mcp.SavedQuery {
+	result := make([]mcp.SavedQuery, len(queries))
+	for i, q := range queries {
+		result[i] = mcp.SavedQuery{
+			Name:        q.Name,
+			Description: q.Description,
+			Connection:  q.Connection,
+			SQL:         q.SQL,
+			Statement:   q.Statement,
+			Params:      make([]mcp.QueryParam, len(q.Params)),
+		}
+		for j, p := range q.Params {
+			result[i].Params[j] = mcp.QueryParam{
+				Name:        p.Name,
+				Type:        p.Type,
+				Description: p.Description,
+				Required:    p.Required,
+				Default:     p.Default,
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// registerAdmin registers the admin API endpoints on the mux.
+func (s *Server) registerAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/connections/{id}/faults", s.handleConnectionFaults)
+	mux.HandleFunc("/admin/connections/{id}/diagnostics", s.handleConnectionDiagnostics)
+	mux.HandleFunc("GET /admin/connections/{id}/cost", s.handleConnectionCost)
+	mux.HandleFunc("GET /admin/connections/{id}/instances", s.handleConnectionInstances)
+	mux.HandleFunc("GET /admin/connections/{id}/statements", s.handleConnectionStatements)
+	mux.HandleFunc("/admin/cache", s.handleCache)
+	mux.HandleFunc("POST /admin/state/export", s.handleStateExport)
+	mux.HandleFunc("POST /admin/state/import", s.handleStateImport)
+	mux.HandleFunc("GET /admin/approvals", s.handleApprovals)
+	mux.HandleFunc("POST /admin/approvals/{id}/approve", s.handleApprove)
+	mux.HandleFunc("POST /admin/approvals/{id}/reject", s.handleReject)
+	mux.HandleFunc("GET /admin/quotas", s.handleQuotas)
+	mux.HandleFunc("GET /admin/sessions", s.handleSessions)
+	mux.HandleFunc("DELETE /admin/sessions/{id}", s.handleCloseSession)
+	mux.HandleFunc("GET /admin/reaper", s.handleReaper)
+	mux.HandleFunc("POST /admin/reaper", s.handleRunReaper)
+	mux.HandleFunc("GET /admin/queries/slow", s.handleSlowQueries)
+	mux.HandleFunc("GET /admin/queries/stats", s.handleQueryStats)
+	mux.HandleFunc("GET /admin/history", s.handleHistory)
+	mux.HandleFunc("GET /admin/logging", s.handleLogLevels)
+	mux.HandleFunc("PUT /admin/logging", s.handleSetLogLevels)
+	mux.HandleFunc("GET /admin/storage", s.handleStorage)
+	mux.HandleFunc("GET /admin/storage/{name}/records", s.handleStorageRecords)
+	mux.HandleFunc("POST /admin/storage/{name}/purge", s.handleStoragePurge)
+	if s.keys != nil {
+		mux.HandleFunc("/admin/keys", s.handleKeys)
+		mux.HandleFunc("POST /admin/keys/{id}/rotate", s.handleRotateKey)
+		mux.HandleFunc("DELETE /admin/keys/{id}", s.handleRevokeKey)
+	}
+}
+
+// stateRequest is the admin API request to export or import server state.
+type stateRequest struct {
+	Passphrase string       `json:"passphrase"`
+	Bundle     *StateBundle `json:"bundle,omitempty"`
+}
+
+// handleStateExport handles exporting the server state as a bundle.
+func (s *Server) handleStateExport(w http.ResponseWriter, r *http.Request) {
+	var req stateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	bundle, err := s.ExportState(req.Passphrase)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, bundle)
+}
+
+// handleStateImport handles importing a state bundle.
+func (s *Server) handleStateImport(w http.ResponseWriter, r *http.Request) {
+	var req stateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Bundle == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bundle is required"))
+		return
+	}
+	report, err := s.ImportState(r.Context(), req.Bundle, req.Passphrase)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleConnectionDiagnostics handles reading and clearing a connection's
+// recorded driver panics.
+func (s *Server) handleConnectionDiagnostics(w http.ResponseWriter, r *http.Request) {
+	c, err := s.pool.GetConnection(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	conn := c.(*Connection)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		conn.ClearSuspect()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suspect, panics := conn.Diagnostics()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"suspect": suspect,
+		"panics":  panics,
+	})
+}
+
+// handleConnectionCost handles reading a connection's bytes scanned usage
+// for the current day.
+func (s *Server) handleConnectionCost(w http.ResponseWriter, r *http.Request) {
+	c, err := s.pool.GetConnection(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.pool.Cost().Usage(c.(*Connection).ID))
+}
+
+// handleConnectionInstances handles reading the health and latency of a
+// connection's instances.
+func (s *Server) handleConnectionInstances(w http.ResponseWriter, r *http.Request) {
+	instances, err := s.pool.Instances(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, instances)
+}
+
+// handleConnectionStatements handles reading the size and hit rate of a
+// connection's prepared statement cache.
+func (s *Server) handleConnectionStatements(w http.ResponseWriter, r *http.Request) {
+	c, err := s.pool.GetConnection(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.(*Connection).StatementCacheStats())
+}
+
+// handleCache handles reading the result cache's statistics, and
+// invalidating the cached results of a connection (by its connection_id
+// query parameter) or all cached results.
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	cache := s.pool.Cache()
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, cache.Stats())
+	case http.MethodDelete:
+		id := r.URL.Query().Get("connection_id")
+		if id != "" {
+			id = s.pool.Resolve(id)
+		}
+		n := cache.Invalidate(id)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"invalidated": n,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// faultSettings is the admin API representation of a fault configuration.
+type faultSettings struct {
+	Enabled     bool    `json:"enabled"`
+	TimeoutRate float64 `json:"timeout_rate"`
+	DropRate    float64 `json:"drop_rate"`
+	SlowRate    float64 `json:"slow_rate"`
+	SlowDelay   string  `json:"slow_delay"`
+}
+
+// handleConnectionFaults handles reading and toggling fault injection for a
+// connection.
+func (s *Server) handleConnectionFaults(w http.ResponseWriter, r *http.Request) {
+	c, err := s.pool.GetConnection(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	id := c.(*Connection).ID
+
+	faults := s.pool.Faults()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings faultSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid fault settings: %w", err))
+			return
+		}
+		config, err := settings.config()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		faults.Set(id, config)
+	case http.MethodDelete:
+		faults.Reset(id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := faults.Get(id)
+	writeJSON(w, http.StatusOK, faultSettings{
+		Enabled:     config.Enabled,
+		TimeoutRate: config.TimeoutRate,
+		DropRate:    config.DropRate,
+		SlowRate:    config.SlowRate,
+		SlowDelay:   config.SlowDelay.String(),
+	})
+}
+
+// config converts the settings to a fault configuration.
+func (settings faultSettings) config() (FaultConfig, error) {
+	config := FaultConfig{
+		Enabled:     settings.Enabled,
+		TimeoutRate: settings.TimeoutRate,
+		DropRate:    settings.DropRate,
+		SlowRate:    settings.SlowRate,
+	}
+	if settings.SlowDelay != "" {
+		d, err := time.ParseDuration(settings.SlowDelay)
+		if err != nil {
+			return FaultConfig{}, fmt.Errorf("invalid slow_delay: %w", err)
+		}
+		config.SlowDelay = d
+	}
+	for _, rate := range []float64{config.TimeoutRate, config.DropRate, config.SlowRate} {
+		if rate < 0 || rate > 1 {
+			return FaultConfig{}, fmt.Errorf("fault rates must be between 0 and 1")
+		}
+	}
+	if config.TimeoutRate+config.DropRate+config.SlowRate > 1 {
+		return FaultConfig{}, fmt.Errorf("sum of fault rates cannot exceed 1")
+	}
+	return config, nil
+}
+
+// writeJSON writes v as a JSON response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response with the status code.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// writeQueryError writes the error of a failed query as a JSON error
+// response, with the violation of statement policies denying the query, or
+// the quota it exceeded.
+func writeQueryError(w http.ResponseWriter, err error) {
+	var v *policy.Violation
+	var q *QuotaError
+	switch {
+	case errors.As(err, &v):
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{"error": err.Error(), "policy_violation": v})
+	case errors.As(err, &q):
+		if !q.ResetAt.IsZero() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(q.ResetAt).Seconds()))))
+		}
+		writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{"error": err.Error(), "quota": q})
+	default:
+		writeError(w, http.StatusUnprocessableEntity, err)
+	}
+}
@@ -0,0 +1,585 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// indexAdviceNote is included with all index advice.
+const indexAdviceNote = "These are suggestions only, derived heuristically from the query plan and table statistics. Validate each candidate (e.g. by comparing EXPLAIN output) before creating it, and weigh the write and storage overhead of new indexes."
+
+// IndexAdvice is the result of analyzing a query for candidate indexes.
+type IndexAdvice struct {
+	Note        string            `json:"note"`
+	Plan        interface{}       `json:"plan"`
+	Suggestions []IndexSuggestion `json:"suggestions"`
+}
+
+// IndexSuggestion is a candidate index.
+type IndexSuggestion struct {
+	Table            string   `json:"table"`
+	Columns          []string `json:"columns"`
+	Reason           string   `json:"reason"`
+	EstimatedRows    int64    `json:"estimated_rows,omitempty"`
+	EstimatedBenefit string   `json:"estimated_benefit"`
+	DDL              string   `json:"ddl"`
+}
+
+// tableScan is a full table scan found in a query plan.
+type tableScan struct {
+	table  string
+	alias  string
+	rows   int64
+	filter string
+}
+
+// AdviseIndexes analyzes the query plan of query, suggesting candidate
+// indexes for the tables it fully scans.
+func (conn *Connection) AdviseIndexes(ctx context.Context, query string, args ...interface{}) (*IndexAdvice, error) {
+	var plan interface{}
+	var scans []tableScan
+	var err error
+	switch conn.URL.Driver {
+	case "postgres", "pgx":
+		plan, scans, err = conn.explainPostgres(ctx, query, args)
+	case "mysql":
+		plan, scans, err = conn.explainMySQL(ctx, query, args)
+	case "sqlite3", "moderncsqlite":
+		plan, scans, err = conn.explainSQLite(ctx, query, args)
+	default:
+		return nil, fmt.Errorf("index advice is not supported for driver %s", conn.URL.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refs := columnRefs(query)
+	advice := &IndexAdvice{
+		Note:        indexAdviceNote,
+		Plan:        plan,
+		Suggestions: []IndexSuggestion{},
+	}
+	for _, scan := range scans {
+		// columns of the plan's filter belong to the scanned table
+		cols := refs.forTable(scan.table, scan.alias, len(scans) == 1)
+		if scan.filter != "" {
+			cols = cols.merge(columnRefs(scan.filter).forTable(scan.table, scan.alias, true))
+		}
+		columns, reason := cols.candidate()
+		if len(columns) == 0 {
+			continue
+		}
+		indexed, err := conn.indexedColumns(ctx, scan.table)
+		if err != nil {
+			return nil, err
+		}
+		if indexed[strings.ToLower(columns[0])] {
+			continue
+		}
+		if scan.rows == 0 {
+			scan.rows = conn.tableRows(ctx, scan.table)
+		}
+		advice.Suggestions = append(advice.Suggestions, IndexSuggestion{
+			Table:            scan.table,
+			Columns:          columns,
+			Reason:           fmt.Sprintf("full scan of %s %s", scan.table, reason),
+			EstimatedRows:    scan.rows,
+			EstimatedBenefit: benefit(scan.rows),
+			DDL:              fmt.Sprintf("CREATE INDEX %s_%s_idx ON %s (%s)", scan.table, strings.Join(columns, "_"), scan.table, strings.Join(columns, ", ")),
+		})
+	}
+	return advice, nil
+}
+
+// benefit estimates the benefit of indexing a scan of rows.
+func benefit(rows int64) string {
+	switch {
+	case rows <= 0:
+		return "unknown"
+	case rows >= 100000:
+		return "high"
+	case rows >= 1000:
+		return "medium"
+	}
+	return "low"
+}
+
+// explainPostgres explains a PostgreSQL query, returning its JSON plan and
+// sequential scans.
+func (conn *Connection) explainPostgres(ctx context.Context, query string, args []interface{}) (interface{}, []tableScan, error) {
+	result, err := conn.ExecuteQuery(ctx, "EXPLAIN (FORMAT JSON) "+query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result.Rows) == 0 || len(result.Rows[0]) == 0 {
+		return nil, nil, fmt.Errorf("empty query plan")
+	}
+	raw := []byte(fmt.Sprint(result.Rows[0][0]))
+	var plan interface{}
+	var plans []struct {
+		Plan pgPlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse query plan: %w", err)
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse query plan: %w", err)
+	}
+
+	var scans []tableScan
+	var walk func(pgPlanNode)
+	walk = func(node pgPlanNode) {
+		if node.NodeType == "Seq Scan" {
+			scans = append(scans, tableScan{
+				table:  node.RelationName,
+				alias:  node.Alias,
+				filter: node.Filter,
+			})
+		}
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+	for _, p := range plans {
+		walk(p.Plan)
+	}
+	return plan, scans, nil
+}
+
+// pgPlanNode is a PostgreSQL JSON query plan node.
+type pgPlanNode struct {
+	NodeType     string       `json:"Node Type"`
+	RelationName string       `json:"Relation Name"`
+	Alias        string       `json:"Alias"`
+	Filter       string       `json:"Filter"`
+	Plans        []pgPlanNode `json:"Plans"`
+}
+
+// explainMySQL explains a MySQL query, returning its plan and full table
+// scans.
+func (conn *Connection) explainMySQL(ctx context.Context, query string, args []interface{}) (interface{}, []tableScan, error) {
+	result, err := conn.ExecuteQuery(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var scans []tableScan
+	for _, row := range result.Rows {
+		m := make(map[string]string, len(row))
+		for i, col := range result.Columns {
+			if row[i] != nil {
+				m[strings.ToLower(col)] = fmt.Sprint(row[i])
+			}
+		}
+		if m["type"] != "ALL" || m["table"] == "" || strings.HasPrefix(m["table"], "<") {
+			continue
+		}
+		rows, _ := strconv.ParseInt(m["rows"], 10, 64)
+		scans = append(scans, tableScan{table: m["table"], alias: m["table"], rows: rows})
+	}
+	// MySQL reports the alias as the table, so resolve it from the query
+	aliases := tableAliases(query)
+	for i, scan := range scans {
+		if table, ok := aliases[strings.ToLower(scan.alias)]; ok {
+			scans[i].table = table
+		}
+	}
+	return result, scans, nil
+}
+
+// explainSQLite explains a SQLite query, returning its plan and full table
+// scans.
+func (conn *Connection) explainSQLite(ctx context.Context, query string, args []interface{}) (interface{}, []tableScan, error) {
+	result, err := conn.ExecuteQuery(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	aliases := tableAliases(query)
+	var plan []string
+	var scans []tableScan
+	for _, row := range result.Rows {
+		detail := fmt.Sprint(row[len(row)-1])
+		plan = append(plan, detail)
+		// SCAN [TABLE] name [AS alias], without USING [COVERING] INDEX
+		fields := strings.Fields(detail)
+		if len(fields) < 2 || fields[0] != "SCAN" || strings.Contains(detail, " INDEX ") {
+			continue
+		}
+		if fields = fields[1:]; fields[0] == "TABLE" && len(fields) > 1 {
+			fields = fields[1:]
+		}
+		scan := tableScan{table: fields[0], alias: fields[0]}
+		if len(fields) > 2 && fields[1] == "AS" {
+			scan.alias = fields[2]
+		}
+		if table, ok := aliases[strings.ToLower(scan.alias)]; ok {
+			scan.table = table
+		}
+		scans = append(scans, scan)
+	}
+	return plan, scans, nil
+}
+
+// indexedColumns returns the (lower cased) leading columns of the table's
+// existing indexes.
+func (conn *Connection) indexedColumns(ctx context.Context, table string) (map[string]bool, error) {
+	var query string
+	switch conn.URL.Driver {
+	case "postgres", "pgx":
+		query = `SELECT a.attname FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0] WHERE i.indrelid = $1::regclass`
+	case "mysql":
+		query = `SELECT column_name FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND seq_in_index = 1`
+	default:
+		query = `SELECT ii.name FROM pragma_index_list(?) il, pragma_index_info(il.name) ii WHERE ii.seqno = 0`
+	}
+	result, err := conn.ExecuteQuery(ctx, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes of %s: %w", table, err)
+	}
+	indexed := make(map[string]bool, len(result.Rows))
+	for _, row := range result.Rows {
+		indexed[strings.ToLower(fmt.Sprint(row[0]))] = true
+	}
+	return indexed, nil
+}
+
+// tableRows returns the estimated number of rows in the table, or 0 when
+// unknown.
+func (conn *Connection) tableRows(ctx context.Context, table string) int64 {
+	var query string
+	switch conn.URL.Driver {
+	case "postgres", "pgx":
+		query = `SELECT reltuples::bigint FROM pg_class WHERE oid = $1::regclass`
+	case "mysql":
+		query = `SELECT table_rows FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`
+	default:
+		// SQLite keeps no row estimates, unless ANALYZE was run
+		query = `SELECT CAST(stat AS INTEGER) FROM sqlite_stat1 WHERE tbl = ? LIMIT 1`
+	}
+	result, err := conn.ExecuteQuery(ctx, query, table)
+	if err != nil || len(result.Rows) == 0 {
+		return 0
+	}
+	n, _ := strconv.ParseInt(fmt.Sprint(result.Rows[0][0]), 10, 64)
+	return max(n, 0)
+}
+
+// Column reference kinds, in the order they are best placed in an index
+// (equality, then sort, then range).
+const (
+	refEq = iota
+	refJoin
+	refSort
+	refRange
+)
+
+// columnRef is a reference to a column in a predicate or ORDER BY.
+type columnRef struct {
+	qualifier string
+	column    string
+	kind      int
+}
+
+// columnRefList is a list of column references.
+type columnRefList []columnRef
+
+// forTable returns the references to columns of the table. Unqualified
+// references are included when unqualified is true.
+func (refs columnRefList) forTable(table, alias string, unqualified bool) columnRefList {
+	var res columnRefList
+	for _, ref := range refs {
+		q := strings.ToLower(ref.qualifier)
+		if (q == "" && unqualified) || (q != "" && (q == strings.ToLower(table) || q == strings.ToLower(alias))) {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+// merge merges the references in other not already in refs.
+func (refs columnRefList) merge(other columnRefList) columnRefList {
+	for _, ref := range other {
+		if !refs.has(ref.column) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// has reports whether column is referenced.
+func (refs columnRefList) has(column string) bool {
+	for _, ref := range refs {
+		if strings.EqualFold(ref.column, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidate returns the candidate index columns for the references, and a
+// description of why they were chosen.
+func (refs columnRefList) candidate() ([]string, string) {
+	sorted := append(columnRefList(nil), refs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].kind < sorted[j].kind
+	})
+	var columns []string
+	reasons := make(map[int][]string)
+	for _, ref := range sorted {
+		if !containsFold(columns, ref.column) {
+			columns = append(columns, ref.column)
+			reasons[ref.kind] = append(reasons[ref.kind], ref.column)
+		}
+		// nothing after a range column can use the index
+		if ref.kind == refRange || len(columns) == 3 {
+			break
+		}
+	}
+	var desc []string
+	for _, r := range []struct {
+		kind int
+		desc string
+	}{
+		{refEq, "filtering on"},
+		{refJoin, "joining on"},
+		{refSort, "sorting by"},
+		{refRange, "with a range condition on"},
+	} {
+		if len(reasons[r.kind]) != 0 {
+			desc = append(desc, r.desc+" "+strings.Join(reasons[r.kind], ", "))
+		}
+	}
+	return columns, strings.Join(desc, ", ")
+}
+
+// containsFold reports whether v contains s, compared case-insensitively.
+func containsFold(v []string, s string) bool {
+	for _, x := range v {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ident is a possibly qualified identifier found while scanning.
+type ident struct {
+	qualifier, name string
+	end             int
+}
+
+// readIdent reads a possibly qualified identifier starting at tokens[i].
+func readIdent(tokens []sqlscan.Token, i int) (ident, bool) {
+	if !isIdent(tokens[i]) {
+		return ident{}, false
+	}
+	id := ident{name: unquote(tokens[i].Text), end: i + 1}
+	for id.end+1 < len(tokens) && tokens[id.end].Text == "." && isIdent(tokens[id.end+1]) {
+		id.qualifier, id.name = id.name, unquote(tokens[id.end+1].Text)
+		id.end += 2
+	}
+	return id, true
+}
+
+// isIdent reports whether t can be an identifier.
+func isIdent(t sqlscan.Token) bool {
+	return t.Kind == sqlscan.QuotedIdent || (t.Kind == sqlscan.Word && !keywords[strings.ToUpper(t.Text)])
+}
+
+// unquote removes identifier quotes.
+func unquote(s string) string {
+	if len(s) > 1 && (s[0] == '"' || s[0] == '`') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// keywords are SQL keywords that are not identifiers.
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true,
+	"CROSS": true, "ON": true, "USING": true, "AS": true, "GROUP": true, "ORDER": true,
+	"BY": true, "HAVING": true, "LIMIT": true, "OFFSET": true, "UNION": true, "NULL": true,
+	"IS": true, "IN": true, "LIKE": true, "BETWEEN": true, "ASC": true, "DESC": true,
+	"CASE": true, "WHEN": true, "THEN": true, "ELSE": true, "END": true, "EXISTS": true,
+	"TRUE": true, "FALSE": true, "DISTINCT": true, "ALL": true, "ANY": true, "WITH": true,
+	"UPDATE": true, "DELETE": true, "SET": true, "INTO": true, "VALUES": true, "FETCH": true,
+	"NATURAL": true, "LATERAL": true, "WINDOW": true, "RETURNING": true, "FOR": true,
+}
+
+// rangeOps and eqOps are comparison operators.
+var (
+	eqOps    = map[string]bool{"=": true, "IN": true, "IS": true}
+	rangeOps = map[string]bool{"<": true, ">": true, "BETWEEN": true, "LIKE": true}
+)
+
+// operator returns the upper cased comparison operator at tokens[i], and
+// whether the other operand is an identifier. The tokens are read forwards
+// or backwards depending on dir.
+func operator(tokens []sqlscan.Token, i, dir int) (string, bool) {
+	if i < 0 || i >= len(tokens) {
+		return "", false
+	}
+	op := strings.ToUpper(tokens[i].Text)
+	next := func(n int) string {
+		if k := i + n*dir; k >= 0 && k < len(tokens) {
+			return strings.ToUpper(tokens[k].Text)
+		}
+		return ""
+	}
+	if op == "NOT" && dir > 0 {
+		op = next(1)
+	}
+	switch {
+	case (op == "<" || op == ">") && next(1) == "=",
+		op == "=" && dir < 0 && (next(1) == "<" || next(1) == ">"):
+		return "<", false
+	case op == "<" && next(1) == ">", op == ">" && next(1) == "<", op == "!", op == "=" && next(1) == "!":
+		return "", false
+	case op == "=":
+		k := i + dir
+		if dir < 0 {
+			// walk back to the start of a qualified identifier
+			for k > 1 && tokens[k-1].Text == "." {
+				k -= 2
+			}
+		}
+		_, other := readIdentAt(tokens, k)
+		return op, other
+	case eqOps[op], rangeOps[op]:
+		return op, false
+	}
+	return "", false
+}
+
+// readIdentAt reports whether tokens[i] starts an identifier.
+func readIdentAt(tokens []sqlscan.Token, i int) (ident, bool) {
+	if i < 0 || i >= len(tokens) {
+		return ident{}, false
+	}
+	return readIdent(tokens, i)
+}
+
+// columnRefs returns the columns referenced by predicates and ORDER BY
+// clauses in the SQL expression or query.
+func columnRefs(sql string) columnRefList {
+	tokens := sqlscan.Words(sql)
+	var refs columnRefList
+	sorting := false
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.Is("ORDER") && i+1 < len(tokens) && tokens[i+1].Is("BY"):
+			sorting, i = true, i+1
+			continue
+		case t.Is("LIMIT"), t.Is("OFFSET"), t.Is("FETCH"), t.Text == ")", t.Text == ";":
+			sorting = false
+		}
+		id, ok := readIdent(tokens, i)
+		if !ok || (i > 0 && (tokens[i-1].Text == "." || tokens[i-1].Text == "::")) {
+			continue
+		}
+		// skip closing parentheses and casts, as in PostgreSQL plan
+		// filters like ((email)::text = 'x'::text)
+		j := id.end
+		for j < len(tokens) && (tokens[j].Text == ")" || (tokens[j].Text == "::" && j+1 < len(tokens))) {
+			if tokens[j].Text == "::" {
+				j++
+			}
+			j++
+		}
+		if j < len(tokens) && tokens[j].Text == "(" {
+			// function call
+			continue
+		}
+		if inCall(tokens, i) {
+			// only an expression index could be used, as with lower(email)
+			i = id.end - 1
+			continue
+		}
+		ref := columnRef{qualifier: id.qualifier, column: id.name, kind: -1}
+		op, other := operator(tokens, j, 1)
+		if op == "" && i > 1 {
+			// reversed comparisons, as in 10 < id or a.id = b.id
+			op, other = operator(tokens, i-1, -1)
+		}
+		switch {
+		case sorting:
+			ref.kind = refSort
+		case op == "=" && other:
+			ref.kind = refJoin
+		case eqOps[op]:
+			ref.kind = refEq
+		case rangeOps[op]:
+			ref.kind = refRange
+		}
+		if ref.kind != -1 {
+			refs = append(refs, ref)
+		}
+		i = id.end - 1
+	}
+	return refs
+}
+
+// inCall reports whether tokens[i] is within the arguments of a function
+// call, in the same query as the call rather than in a subquery.
+func inCall(tokens []sqlscan.Token, i int) bool {
+	depth := 0
+	for k := i - 1; k >= 0; k-- {
+		switch tokens[k].Text {
+		case ")":
+			depth++
+		case "(":
+			switch {
+			case depth > 0:
+				depth--
+			case tokens[k+1].Is("SELECT"):
+				return false
+			case k > 0 && isIdent(tokens[k-1]):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tableAliases returns the tables referenced in FROM and JOIN clauses of a
+// query, keyed by their lower cased alias (or name when not aliased).
+func tableAliases(query string) map[string]string {
+	tokens := sqlscan.Words(query)
+	aliases := make(map[string]string)
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].Is("FROM") && !tokens[i].Is("JOIN") && !tokens[i].Is("UPDATE") && !(tokens[i].Is("INTO") && i > 0 && tokens[i-1].Is("DELETE")) {
+			continue
+		}
+		for i+1 < len(tokens) {
+			id, ok := readIdent(tokens, i+1)
+			if !ok {
+				break
+			}
+			table, j := id.name, id.end
+			alias := table
+			if j < len(tokens) && tokens[j].Is("AS") {
+				j++
+			}
+			if j < len(tokens) && isIdent(tokens[j]) {
+				alias, j = unquote(tokens[j].Text), j+1
+			}
+			aliases[strings.ToLower(alias)] = table
+			aliases[strings.ToLower(table)] = table
+			i = j - 1
+			// comma separated FROM lists
+			if j < len(tokens) && tokens[j].Text == "," {
+				i = j
+				continue
+			}
+			break
+		}
+	}
+	return aliases
+}
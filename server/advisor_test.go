@@ -0,0 +1,41 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumnRefsCandidate(t *testing.T) {
+	tests := []struct {
+		query  string
+		table  string
+		alias  string
+		exp    []string
+		single bool
+	}{
+		{"SELECT * FROM users WHERE email = ?", "users", "users", []string{"email"}, true},
+		{"SELECT * FROM users WHERE created_at > $1 AND status = 'active' ORDER BY created_at", "users", "users", []string{"status", "created_at"}, true},
+		{"SELECT * FROM orders o JOIN users u ON u.id = o.user_id WHERE o.total >= 100", "orders", "o", []string{"user_id", "total"}, false},
+		{"SELECT * FROM t WHERE 10 < a", "t", "t", []string{"a"}, true},
+		{"SELECT * FROM t WHERE a <> 1 AND lower(b) LIKE 'x%'", "t", "t", nil, true},
+		{"SELECT * FROM t WHERE lower(b) = 'x' AND c = 1 ORDER BY upper(trim(d))", "t", "t", []string{"c"}, true},
+		{"SELECT * FROM t WHERE coalesce(a, b) = 1 AND b = lower($1)", "t", "t", []string{"b"}, true},
+		{"SELECT * FROM t WHERE lower(b) = 'x' AND EXISTS (SELECT 1 FROM u WHERE u.id = t.a)", "t", "t", []string{"a"}, true},
+		{"(lower((email)::text) = 'x'::text)", "users", "users", nil, true},
+		{"((email)::text = 'x'::text)", "users", "users", []string{"email"}, true},
+	}
+	for _, test := range tests {
+		columns, _ := columnRefs(test.query).forTable(test.table, test.alias, test.single).candidate()
+		if !reflect.DeepEqual(columns, test.exp) {
+			t.Errorf("%q expected %v, got: %v", test.query, test.exp, columns)
+		}
+	}
+}
+
+func TestTableAliases(t *testing.T) {
+	aliases := tableAliases(`SELECT * FROM orders o, items JOIN "users" AS u ON u.id = o.user_id WHERE 1 = 1`)
+	exp := map[string]string{"o": "orders", "orders": "orders", "u": "users", "users": "users", "items": "items"}
+	if !reflect.DeepEqual(aliases, exp) {
+		t.Errorf("expected %v, got: %v", exp, aliases)
+	}
+}
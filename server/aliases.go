@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+)
+
+// lookup returns the connection with the ID, or the connection the ID is an
+// alias of. The lock must be held.
+func (cp *ConnectionPool) lookup(id string) (*Connection, bool) {
+	if conn, ok := cp.connections[id]; ok {
+		return conn, true
+	}
+	if target, ok := cp.aliases[id]; ok {
+		conn, ok := cp.connections[target]
+		return conn, ok
+	}
+	return nil, false
+}
+
+// taken returns whether the name is the ID or an alias of a connection, or
+// the ID of a group. The lock must be held.
+func (cp *ConnectionPool) taken(name string) bool {
+	_, isID := cp.connections[name]
+	_, isAlias := cp.aliases[name]
+	_, isGroup := cp.group(name)
+	return isID || isAlias || isGroup
+}
+
+// Resolve returns the ID of the connection with the ID or alias, or the ID
+// unchanged when there is no such connection.
+func (cp *ConnectionPool) Resolve(id string) string {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	if target, ok := cp.aliases[id]; ok {
+		return target
+	}
+	return id
+}
+
+// AddAlias adds an alias referring to the connection with the ID (or
+// alias), usable in place of its ID.
+func (cp *ConnectionPool) AddAlias(id, alias string) error {
+	if err := cp.addAlias(id, alias); err != nil {
+		return err
+	}
+	cp.redefined()
+	return nil
+}
+
+// addAlias adds an alias referring to the connection with the ID.
+func (cp *ConnectionPool) addAlias(id, alias string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	conn, ok := cp.lookup(id)
+	switch {
+	case !ok:
+		return fmt.Errorf("connection with ID %s not found", id)
+	case alias == "":
+		return fmt.Errorf("alias is empty")
+	case cp.taken(alias):
+		return fmt.Errorf("connection with ID %s already exists", alias)
+	}
+	if cp.aliases == nil {
+		cp.aliases = make(map[string]string)
+	}
+	cp.aliases[alias] = conn.ID
+	return nil
+}
+
+// RemoveAlias removes an alias.
+func (cp *ConnectionPool) RemoveAlias(alias string) error {
+	cp.mu.Lock()
+	if _, ok := cp.aliases[alias]; !ok {
+		cp.mu.Unlock()
+		return fmt.Errorf("alias %s not found", alias)
+	}
+	delete(cp.aliases, alias)
+	cp.mu.Unlock()
+
+	cp.redefined()
+	return nil
+}
+
+// RenameConnection changes the ID of the connection with the ID (or alias)
+// to newID, keeping its aliases. The connection's cached results are
+// invalidated, and its faults reset, as they are kept by ID. Connections
+// running a query or holding open cursors can't be renamed.
+func (cp *ConnectionPool) RenameConnection(id, newID string) error {
+	cp.mu.Lock()
+	conn, ok := cp.lookup(id)
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("connection with ID %s not found", id)
+	case newID == "":
+		err = fmt.Errorf("new ID is empty")
+	case cp.taken(newID):
+		err = fmt.Errorf("connection with ID %s already exists", newID)
+	case conn.busy() || cp.cursors.count(conn.ID) != 0:
+		err = fmt.Errorf("connection %s is in use", conn.ID)
+	}
+	if err != nil {
+		cp.mu.Unlock()
+		return err
+	}
+
+	oldID := conn.ID
+	for _, inst := range append([]*Connection{conn}, conn.replicaList()...) {
+		inst.mu.Lock()
+		inst.ID = newID
+		inst.mu.Unlock()
+	}
+	delete(cp.connections, oldID)
+	cp.connections[newID] = conn
+	for alias, target := range cp.aliases {
+		if target == oldID {
+			cp.aliases[alias] = newID
+		}
+	}
+	cp.faults.Reset(oldID)
+	cp.cache.Invalidate(oldID)
+	cp.mu.Unlock()
+
+	cp.changed()
+	return nil
+}
+
+// aliasesOf returns the sorted aliases of the connection with the ID. The
+// lock must be held.
+func (cp *ConnectionPool) aliasesOf(id string) []string {
+	var aliases []string
+	for alias, target := range cp.aliases {
+		if target == id {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
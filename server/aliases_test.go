@@ -0,0 +1,68 @@
+package server
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestAliases(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	for _, id := range []string{"a", "b"} {
+		cp.connections[id] = &Connection{ID: id, URL: u, DB: sql.OpenDB(multiConnector{})}
+	}
+
+	for _, alias := range []string{"prod", "primary"} {
+		if err := cp.AddAlias("a", alias); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	// names are unique across IDs and aliases
+	for _, alias := range []string{"b", "prod", ""} {
+		if err := cp.AddAlias("a", alias); err == nil {
+			t.Errorf("expected an error adding alias %q", alias)
+		}
+	}
+	if c, err := cp.GetConnection("prod"); err != nil || c.(*Connection).ID != "a" {
+		t.Fatalf("expected connection a, got: %v %v", c, err)
+	}
+
+	// renaming keeps the aliases, and is refused while in use
+	release := cp.connections["a"].use(func() {})
+	if err := cp.RenameConnection("prod", "c"); err == nil {
+		t.Errorf("expected an error renaming a busy connection")
+	}
+	release()
+	if err := cp.RenameConnection("b", "prod"); err == nil {
+		t.Errorf("expected an error renaming to an alias")
+	}
+	if err := cp.RenameConnection("prod", "c"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := cp.GetConnection("a"); err == nil {
+		t.Errorf("expected connection a to be renamed")
+	}
+	info := cp.ListConnections()["c"]
+	switch {
+	case info.ID != "c":
+		t.Errorf("expected connection c, got: %+v", info)
+	case !slices.Equal(info.Aliases, []string{"primary", "prod"}):
+		t.Errorf("expected the aliases to be kept, got: %v", info.Aliases)
+	case cp.Resolve("primary") != "c" || cp.Resolve("b") != "b":
+		t.Errorf("expected primary to resolve to c, got: %s", cp.Resolve("primary"))
+	}
+
+	if err := cp.RemoveAlias("primary"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := cp.CloseConnection("prod"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(cp.aliases) != 0 || len(cp.connections) != 1 {
+		t.Errorf("expected the connection and its aliases to be removed, got: %v %d", cp.aliases, len(cp.connections))
+	}
+}
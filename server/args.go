@@ -0,0 +1,251 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+// argTypes are the argument type hints, with the functions converting
+// values to them.
+var argTypes = map[string]func(interface{}) (interface{}, error){
+	"string":    toString,
+	"integer":   toInteger,
+	"number":    toNumber,
+	"decimal":   toDecimal,
+	"boolean":   toBoolean,
+	"null":      func(interface{}) (interface{}, error) { return nil, nil },
+	"timestamp": toTimestamp,
+	"date":      toDate,
+	"bytes":     toBytes,
+	"json":      toJSON,
+}
+
+// timestampLayouts are the accepted timestamp formats.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// bindArgs converts the query arguments for the driver and binds named
+// parameters, returning descriptions of the rewrites applied.
+func bindArgs(u *dburl.URL, query string, args []interface{}) (string, []interface{}, []string, error) {
+	var rewrites []string
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			arg = named.Value
+		}
+		if _, ok := arg.(map[string]interface{}); ok {
+			rewrites = append(rewrites, "typed arguments converted")
+			break
+		}
+	}
+	args, err := convertArgs(u, args)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	bound, args, err := bindNamed(u, query, args)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if bound != query {
+		rewrites = append(rewrites, "named parameters bound to positional placeholders")
+	}
+	return bound, args, rewrites, nil
+}
+
+// convertArgs converts JSON decoded query arguments to values suitable for
+// the driver. Integral numbers are passed as integers, and objects of the
+// form {"value": v, "type": t} are converted to the hinted type.
+func convertArgs(u *dburl.URL, args []interface{}) ([]interface{}, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		var err error
+		if named, ok := arg.(sql.NamedArg); ok {
+			named.Value, err = convertArg(u, named.Value)
+			converted[i] = named
+		} else {
+			converted[i], err = convertArg(u, arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+	}
+	return converted, nil
+}
+
+// convertArg converts a single argument.
+func convertArg(u *dburl.URL, v interface{}) (interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		typ, ok := m["type"].(string)
+		if !ok {
+			return nil, fmt.Errorf(`object arguments must be of the form {"value": ..., "type": ...}`)
+		}
+		conv, ok := argTypes[typ]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q", typ)
+		}
+		var err error
+		if v, err = conv(m["value"]); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", typ, err)
+		}
+	}
+	switch x := v.(type) {
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return int64(x), nil
+		}
+	case bool:
+		// Oracle has no boolean bind type before 23ai
+		if u.Driver == "godror" || u.Driver == "oracle" {
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	}
+	return v, nil
+}
+
+// toString converts v to a string.
+func toString(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return x, nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// toInteger converts v to an int64.
+func toInteger(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		if x != math.Trunc(x) {
+			return nil, fmt.Errorf("%v is not an integer", x)
+		}
+		return int64(x), nil
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toNumber converts v to a float64.
+func toNumber(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return x, nil
+	case string:
+		return strconv.ParseFloat(x, 64)
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toDecimal converts v to a numeric string, preserving the precision of
+// string values.
+func toDecimal(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case string:
+		if _, err := strconv.ParseFloat(x, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", x)
+		}
+		return x, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toBoolean converts v to a bool.
+func toBoolean(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return x, nil
+	case float64:
+		return x != 0, nil
+	case string:
+		return strconv.ParseBool(x)
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toTimestamp converts v, an RFC 3339 (or SQL style) string or Unix time in
+// seconds, to a time.Time.
+func toTimestamp(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		sec, frac := math.Modf(x)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	case string:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, x); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot parse %q", x)
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toDate converts v, a YYYY-MM-DD string, to a time.Time.
+func toDate(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return time.Parse("2006-01-02", x)
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toBytes converts v, a base64 encoded string, to a []byte.
+func toBytes(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return base64.StdEncoding.DecodeString(x)
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// toJSON converts v to JSON text. Strings are assumed to already be JSON.
+func toJSON(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		if !json.Valid([]byte(s)) {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+		return s, nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestConvertArgs(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		dsn  string
+		args []interface{}
+		exp  []interface{}
+		err  bool
+	}{
+		{"postgres://localhost/db", []interface{}{"a", float64(1), 1.5, true, nil}, []interface{}{"a", int64(1), 1.5, true, nil}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "2024-01-02T03:04:05Z", "type": "timestamp"}}, []interface{}{ts}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "2024-01-02 03:04:05", "type": "timestamp"}}, []interface{}{ts}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "42", "type": "integer"}}, []interface{}{int64(42)}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "12.50", "type": "decimal"}}, []interface{}{"12.50"}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "true", "type": "boolean"}}, []interface{}{true}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "x", "type": "null"}}, []interface{}{nil}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "aGk=", "type": "bytes"}}, []interface{}{[]byte("hi")}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": map[string]interface{}{"a": 1.0}, "type": "json"}}, []interface{}{`{"a":1}`}, false},
+		{"postgres://localhost/db", []interface{}{sql.Named("n", float64(2))}, []interface{}{sql.Named("n", int64(2))}, false},
+		{"oracle://localhost/db", []interface{}{true, map[string]interface{}{"value": false, "type": "boolean"}}, []interface{}{int64(1), int64(0)}, false},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "x"}}, nil, true},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "x", "type": "uuid"}}, nil, true},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "1.5", "type": "integer"}}, nil, true},
+		{"postgres://localhost/db", []interface{}{map[string]interface{}{"value": "yesterday", "type": "timestamp"}}, nil, true},
+	}
+	for i, test := range tests {
+		u, err := dburl.Parse(test.dsn)
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		args, err := convertArgs(u, test.args)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("test %d: expected error", i)
+		case !test.err && err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case !test.err && !reflect.DeepEqual(args, test.exp):
+			t.Errorf("test %d: expected %#v, got: %#v", i, test.exp, args)
+		}
+	}
+}
+
+func TestBindArgsRewrites(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	tests := []struct {
+		query string
+		args  []interface{}
+		exp   []string
+	}{
+		{"SELECT $1", []interface{}{"a"}, nil},
+		{"SELECT :a", []interface{}{sql.Named("a", 1)}, []string{"named parameters bound to positional placeholders"}},
+		{"SELECT $1", []interface{}{map[string]interface{}{"value": "1", "type": "integer"}}, []string{"typed arguments converted"}},
+	}
+	for i, test := range tests {
+		_, _, rewrites, err := bindArgs(u, test.query, test.args)
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		if !reflect.DeepEqual(rewrites, test.exp) {
+			t.Errorf("test %d: expected rewrites %v, got: %v", i, test.exp, rewrites)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+// Package arrowipc writes rows as Apache Arrow IPC streams.
+//
+// Columns are typed as 64-bit integers, 64-bit floats, booleans, UTC
+// timestamps (microseconds), or UTF-8 strings (with binary data base64
+// encoded), inferred from the values of the first batch of rows.
+package arrowipc
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of Arrow IPC streams.
+const ContentType = "application/vnd.apache.arrow.stream"
+
+// Type is an Arrow column type.
+type Type int
+
+// Column types.
+const (
+	Utf8 Type = iota
+	Int64
+	Float64
+	Bool
+	Timestamp
+)
+
+// String satisfies the fmt.Stringer interface.
+func (t Type) String() string {
+	return [...]string{"utf8", "int64", "float64", "bool", "timestamp[us, UTC]"}[t]
+}
+
+// Arrow flatbuffers enum values.
+const (
+	metadataV5          = 4
+	headerSchema        = 1
+	headerRecordBatch   = 3
+	typeInt             = 2
+	typeFloatingPoint   = 3
+	typeUtf8            = 5
+	typeBool            = 6
+	typeTimestamp       = 10
+	precisionDouble     = 2
+	timeUnitMicrosecond = 2
+)
+
+// Writer writes batches of rows as an Arrow IPC stream.
+type Writer struct {
+	w       io.Writer
+	columns []string
+	dbTypes []string
+	types   []Type
+}
+
+// NewWriter creates a writer of rows of the columns. The database type
+// names of the columns are used to keep exact numeric values scanned as
+// strings (e.g. DECIMAL) as strings.
+func NewWriter(w io.Writer, columns, dbTypes []string) *Writer {
+	return &Writer{
+		w:       w,
+		columns: columns,
+		dbTypes: dbTypes,
+	}
+}
+
+// Types returns the column types, once the first batch has been written.
+func (w *Writer) Types() []Type {
+	return w.types
+}
+
+// WriteBatch writes the rows as a record batch. The schema is written
+// before the first batch, with the column types inferred from its rows.
+func (w *Writer) WriteBatch(rows [][]interface{}) error {
+	if w.types == nil {
+		w.types = inferTypes(w.columns, w.dbTypes, rows)
+		if err := w.writeSchema(); err != nil {
+			return err
+		}
+	}
+
+	var nodes, buffers []byte
+	var body []byte
+	addBuffer := func(buf []byte) {
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(buf)))
+		body = append(body, buf...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for i, typ := range w.types {
+		validity := make([]byte, (len(rows)+7)/8)
+		nulls := 0
+		var data, offsets []byte
+		if typ == Utf8 {
+			offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+		}
+		if typ == Bool {
+			data = make([]byte, len(validity))
+		}
+		for j, row := range rows {
+			v := row[i]
+			if v != nil {
+				validity[j/8] |= 1 << (j % 8)
+			} else {
+				nulls++
+			}
+			switch typ {
+			case Utf8:
+				if v != nil {
+					data = append(data, toString(v)...)
+				}
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+			case Bool:
+				b, ok := v.(bool)
+				if v != nil && !ok {
+					return fmt.Errorf("column %s: cannot convert %T to bool", w.columns[i], v)
+				}
+				if b {
+					data[j/8] |= 1 << (j % 8)
+				}
+			default:
+				var x uint64
+				if v != nil {
+					var err error
+					if x, err = toUint64(typ, v); err != nil {
+						return fmt.Errorf("column %s: %w", w.columns[i], err)
+					}
+				}
+				data = binary.LittleEndian.AppendUint64(data, x)
+			}
+		}
+		nodes = binary.LittleEndian.AppendUint64(nodes, uint64(len(rows)))
+		nodes = binary.LittleEndian.AppendUint64(nodes, uint64(nulls))
+		addBuffer(validity)
+		if typ == Utf8 {
+			addBuffer(offsets)
+		}
+		addBuffer(data)
+	}
+
+	batch := fbTable{
+		scalar(0, 8, uint64(len(rows))),
+		ref(1, fbStructs{n: len(w.types), data: nodes}),
+		ref(2, fbStructs{n: len(buffers) / 16, data: buffers}),
+	}
+	return w.writeMessage(headerRecordBatch, batch, body)
+}
+
+// Close ends the stream, writing the schema first if no batch was written.
+func (w *Writer) Close() error {
+	if w.types == nil {
+		w.types = inferTypes(w.columns, w.dbTypes, nil)
+		if err := w.writeSchema(); err != nil {
+			return err
+		}
+	}
+	_, err := w.w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	return err
+}
+
+// writeSchema writes the schema message.
+func (w *Writer) writeSchema() error {
+	fields := make(fbTables, len(w.columns))
+	for i, name := range w.columns {
+		var typeType int
+		var typ fbTable
+		switch w.types[i] {
+		case Int64:
+			typeType, typ = typeInt, fbTable{scalar(0, 4, 64), scalar(1, 1, 1)}
+		case Float64:
+			typeType, typ = typeFloatingPoint, fbTable{scalar(0, 2, precisionDouble)}
+		case Bool:
+			typeType, typ = typeBool, fbTable{}
+		case Timestamp:
+			typeType, typ = typeTimestamp, fbTable{scalar(0, 2, timeUnitMicrosecond), ref(1, fbString("UTC"))}
+		default:
+			typeType, typ = typeUtf8, fbTable{}
+		}
+		fields[i] = fbTable{
+			ref(0, fbString(name)),
+			scalar(1, 1, 1),
+			scalar(2, 1, uint64(typeType)),
+			ref(3, typ),
+			ref(5, fbTables{}),
+		}
+	}
+	return w.writeMessage(headerSchema, fbTable{scalar(0, 2, 0), ref(1, fields)}, nil)
+}
+
+// writeMessage writes an encapsulated message with the header and body.
+func (w *Writer) writeMessage(headerType int, header fbTable, body []byte) error {
+	metadata := finish(fbTable{
+		scalar(0, 2, metadataV5),
+		scalar(1, 1, uint64(headerType)),
+		ref(2, header),
+		scalar(3, 8, uint64(len(body))),
+	})
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix, 0xffffffff)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(metadata)))
+	for _, buf := range [][]byte{prefix, metadata, body} {
+		if _, err := w.w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inferTypes infers the column types from the values of the rows, typing
+// columns with mixed or no values as strings.
+func inferTypes(columns, dbTypes []string, rows [][]interface{}) []Type {
+	types := make([]Type, len(columns))
+	for i := range columns {
+		var seen []Type
+		for _, row := range rows {
+			if t, ok := valueType(row[i]); ok && (len(seen) == 0 || seen[len(seen)-1] != t) {
+				seen = append(seen, t)
+			}
+		}
+		types[i] = Utf8
+		for j, t := range seen {
+			switch {
+			case j == 0:
+				types[i] = t
+			case types[i] == t:
+			case (types[i] == Int64 && t == Float64) || (types[i] == Float64 && t == Int64):
+				types[i] = Float64
+			default:
+				types[i] = Utf8
+			}
+			if types[i] == Utf8 {
+				break
+			}
+		}
+		if i < len(dbTypes) && types[i] != Utf8 && isDecimal(dbTypes[i]) {
+			types[i] = Utf8
+		}
+	}
+	return types
+}
+
+// valueType returns the column type of a value.
+func valueType(v interface{}) (Type, bool) {
+	switch v.(type) {
+	case nil:
+		return 0, false
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return Int64, true
+	case float32, float64:
+		return Float64, true
+	case bool:
+		return Bool, true
+	case time.Time:
+		return Timestamp, true
+	}
+	return Utf8, true
+}
+
+// isDecimal returns whether a database type name is an exact numeric type.
+func isDecimal(dbType string) bool {
+	s := strings.ToUpper(dbType)
+	return strings.Contains(s, "DECIMAL") || strings.Contains(s, "NUMERIC") || s == "MONEY"
+}
+
+// toUint64 converts v to the bits of a value of a fixed width type.
+func toUint64(typ Type, v interface{}) (uint64, error) {
+	var i int64
+	switch x := v.(type) {
+	case int:
+		i = int64(x)
+	case int8:
+		i = int64(x)
+	case int16:
+		i = int64(x)
+	case int32:
+		i = int64(x)
+	case int64:
+		i = x
+	case uint8:
+		i = int64(x)
+	case uint16:
+		i = int64(x)
+	case uint32:
+		i = int64(x)
+	case float32:
+		if typ == Float64 {
+			return math.Float64bits(float64(x)), nil
+		}
+		return 0, fmt.Errorf("cannot convert %T to %s", v, typ)
+	case float64:
+		if typ == Float64 {
+			return math.Float64bits(x), nil
+		}
+		return 0, fmt.Errorf("cannot convert %T to %s", v, typ)
+	case time.Time:
+		if typ == Timestamp {
+			return uint64(x.UnixMicro()), nil
+		}
+		return 0, fmt.Errorf("cannot convert %T to %s", v, typ)
+	default:
+		return 0, fmt.Errorf("cannot convert %T to %s", v, typ)
+	}
+	switch typ {
+	case Int64:
+		return uint64(i), nil
+	case Float64:
+		return math.Float64bits(float64(i)), nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to %s", v, typ)
+}
+
+// toString converts v to a string, base64 encoding binary data.
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
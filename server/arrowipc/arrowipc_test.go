@@ -0,0 +1,84 @@
+package arrowipc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInferTypes(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	dbTypes := []string{"INT", "", "", "", "", "", "NUMERIC", ""}
+	rows := [][]interface{}{
+		{int64(1), 1.5, true, ts, "x", nil, 1.5, int64(1)},
+		{nil, int64(2), false, nil, "y", nil, 2.5, "z"},
+	}
+	exp := []Type{Int64, Float64, Bool, Timestamp, Utf8, Utf8, Utf8, Utf8}
+	if types := inferTypes(columns, dbTypes, rows); !reflect.DeepEqual(types, exp) {
+		t.Errorf("expected %v, got: %v", exp, types)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []string{"id", "name"}, nil)
+	for _, rows := range [][][]interface{}{
+		{{int64(1), "a"}, {nil, "b"}},
+		{{int64(3), nil}},
+	} {
+		if err := w.WriteBatch(rows); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if err := w.WriteBatch([][]interface{}{{"x", "y"}}); err == nil {
+		t.Errorf("expected error writing a string to an int64 column")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// walk the encapsulated messages: the schema, two record batches, and
+	// the end of stream marker
+	b := buf.Bytes()
+	var headers []byte
+	for {
+		if len(b) < 8 || binary.LittleEndian.Uint32(b) != 0xffffffff {
+			t.Fatalf("expected continuation marker, got: %x", b)
+		}
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if n == 0 {
+			b = b[8:]
+			break
+		}
+		if n%8 != 0 {
+			t.Errorf("expected metadata padded to 8 bytes, got: %d", n)
+		}
+		msg := b[8 : 8+n]
+		headerType, bodyLength := messageHeader(msg)
+		headers = append(headers, headerType)
+		if bodyLength%8 != 0 {
+			t.Errorf("expected body padded to 8 bytes, got: %d", bodyLength)
+		}
+		b = b[8+n+bodyLength:]
+	}
+	if exp := []byte{headerSchema, headerRecordBatch, headerRecordBatch}; !bytes.Equal(headers, exp) {
+		t.Errorf("expected messages %v, got: %v", exp, headers)
+	}
+	if len(b) != 0 {
+		t.Errorf("expected no data after the end of stream, got: %d bytes", len(b))
+	}
+}
+
+// messageHeader returns the header type and body length of a flatbuffers
+// encoded message.
+func messageHeader(msg []byte) (byte, int) {
+	table := int(binary.LittleEndian.Uint32(msg))
+	vtable := table - int(int32(binary.LittleEndian.Uint32(msg[table:])))
+	field := func(slot int) int {
+		return table + int(binary.LittleEndian.Uint16(msg[vtable+4+2*slot:]))
+	}
+	return msg[field(1)], int(binary.LittleEndian.Uint64(msg[field(3):]))
+}
@@ -0,0 +1,170 @@
+package arrowipc
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// The Arrow IPC message metadata is encoded as flatbuffers. As only a few
+// message types are written, they are encoded with the minimal flatbuffers
+// encoder below rather than generated code.
+//
+// Objects are written front to back, with each table or vector followed by
+// the objects it references, so that all offsets point forward as required.
+
+// fbObject is a flatbuffers object referenced by an offset.
+type fbObject interface {
+	// encode writes the object, returning the position offsets to it point
+	// to.
+	encode(b *fbBuilder) int
+}
+
+// fbTable is a flatbuffers table.
+type fbTable []fbField
+
+// fbField is a table field, either an inline scalar of size bytes, or an
+// offset to an object.
+type fbField struct {
+	slot  int
+	size  int
+	value uint64
+	ref   fbObject
+}
+
+// fbString is a flatbuffers string.
+type fbString string
+
+// fbTables is a flatbuffers vector of tables.
+type fbTables []fbTable
+
+// fbStructs is a flatbuffers vector of n 8 byte aligned structs.
+type fbStructs struct {
+	n    int
+	data []byte
+}
+
+// scalar returns a scalar field.
+func scalar(slot, size int, value uint64) fbField {
+	return fbField{slot: slot, size: size, value: value}
+}
+
+// ref returns an offset field.
+func ref(slot int, obj fbObject) fbField {
+	return fbField{slot: slot, size: 4, ref: obj}
+}
+
+// fbBuilder builds a flatbuffer.
+type fbBuilder struct {
+	buf []byte
+}
+
+// finish encodes the root table, returning the flatbuffer padded to 8
+// bytes.
+func finish(root fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	b.patch(0, root.encode(b))
+	b.align(8, 0)
+	return b.buf
+}
+
+// align pads the buffer so that its length plus extra is a multiple of n.
+func (b *fbBuilder) align(n, extra int) {
+	for (len(b.buf)+extra)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch sets the offset at position at to point to position target.
+func (b *fbBuilder) patch(at, target int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(target-at))
+}
+
+// encode satisfies the fbObject interface.
+func (t fbTable) encode(b *fbBuilder) int {
+	// lay out the fields after the vtable offset, largest first
+	fields := append(fbTable(nil), t...)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].size > fields[j].size
+	})
+	offsets := make([]int, len(fields))
+	size, slots := 4, 0
+	for i, f := range fields {
+		for size%f.size != 0 {
+			size++
+		}
+		offsets[i] = size
+		size += f.size
+		if f.slot >= slots {
+			slots = f.slot + 1
+		}
+	}
+	for size%4 != 0 {
+		size++
+	}
+
+	// write the vtable, immediately followed by the 8 byte aligned table
+	vtsize := 4 + 2*slots
+	b.align(8, vtsize)
+	vtable := make([]byte, vtsize)
+	binary.LittleEndian.PutUint16(vtable[0:], uint16(vtsize))
+	binary.LittleEndian.PutUint16(vtable[2:], uint16(size))
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(vtable[4+2*f.slot:], uint16(offsets[i]))
+	}
+	b.buf = append(b.buf, vtable...)
+
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(vtsize))
+	for i, f := range fields {
+		p := b.buf[pos+offsets[i]:]
+		switch f.size {
+		case 1:
+			p[0] = byte(f.value)
+		case 2:
+			binary.LittleEndian.PutUint16(p, uint16(f.value))
+		case 4:
+			binary.LittleEndian.PutUint32(p, uint32(f.value))
+		case 8:
+			binary.LittleEndian.PutUint64(p, f.value)
+		}
+	}
+
+	// write the referenced objects
+	for i, f := range fields {
+		if f.ref != nil {
+			b.patch(pos+offsets[i], f.ref.encode(b))
+		}
+	}
+	return pos
+}
+
+// encode satisfies the fbObject interface.
+func (s fbString) encode(b *fbBuilder) int {
+	b.align(4, 0)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(append(b.buf, s...), 0)
+	return pos
+}
+
+// encode satisfies the fbObject interface.
+func (v fbTables) encode(b *fbBuilder) int {
+	b.align(4, 0)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+	b.buf = append(b.buf, make([]byte, 4*len(v))...)
+	for i, t := range v {
+		b.patch(pos+4+4*i, t.encode(b))
+	}
+	return pos
+}
+
+// encode satisfies the fbObject interface.
+func (v fbStructs) encode(b *fbBuilder) int {
+	b.align(8, 4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(v.n))
+	b.buf = append(b.buf, v.data...)
+	return pos
+}
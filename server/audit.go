@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/policy"
+)
+
+// auditOutcome returns the audit outcome of an operation failing with err,
+// and its error message: denied when a policy denied it.
+func auditOutcome(err error) (string, string) {
+	var violation *policy.Violation
+	var filter *policy.FilterError
+	switch {
+	case err == nil:
+		return audit.Success, ""
+	case errors.As(err, &violation), errors.As(err, &filter):
+		return audit.Denied, err.Error()
+	}
+	return audit.Failure, err.Error()
+}
+
+// auditEvent returns an event of the type for the connection with the ID,
+// started at started and failing with err, attributed to the principal,
+// tenant and session of ctx.
+func auditEvent(ctx context.Context, typ, id string, started time.Time, err error) audit.Event {
+	outcome, msg := auditOutcome(err)
+	event := audit.Event{
+		Time:         started,
+		Type:         typ,
+		Principal:    policy.PrincipalFrom(ctx),
+		Tenant:       policy.TenantFrom(ctx),
+		ConnectionID: id,
+		DurationMS:   float64(time.Since(started)) / float64(time.Millisecond),
+		Outcome:      outcome,
+		Error:        msg,
+	}
+	if s := sessionFrom(ctx); s != nil {
+		event.Session = s.ID
+	}
+	return event
+}
+
+// recordQuery counts the execution of the query (or statement) with the
+// args on the connection, and records it in the audit log, the query
+// history and the query stats, with the rows read or affected.
+func (conn *Connection) recordQuery(ctx context.Context, typ, query string, args []interface{}, started time.Time, rows int64, err error) {
+	conn.queries.Add(1)
+	if err != nil {
+		conn.queryErrors.Add(1)
+	}
+	if conn.audit == nil && conn.history == nil && conn.stats == nil {
+		return
+	}
+	event := auditEvent(ctx, typ, conn.ID, started, err)
+	event.SQL, event.Rows = query, rows
+	conn.history.record(event)
+	conn.stats.record(event)
+	if conn.audit != nil {
+		event.ParamsHash = audit.HashParams(args)
+		conn.audit.Log(event)
+	}
+}
+
+// auditConnection records a lifecycle event of the connection with the ID
+// in the audit log.
+func (cp *ConnectionPool) auditConnection(ctx context.Context, typ, id string, started time.Time, err error, detail string) {
+	if cp.audit == nil {
+		return
+	}
+	event := auditEvent(ctx, typ, id, started, err)
+	event.Detail = detail
+	cp.audit.Log(event)
+}
+
+// resultRows returns the number of rows of the result and its further
+// result sets.
+func resultRows(result *QueryResult) int64 {
+	if result == nil {
+		return 0
+	}
+	n := int64(len(result.Rows))
+	for _, set := range result.MoreResultSets {
+		n += resultRows(set)
+	}
+	return n
+}
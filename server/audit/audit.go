@@ -0,0 +1,170 @@
+// Package audit provides the audit log of connection lifecycle events and
+// executed queries and statements, written to a pluggable sink.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xo/usql/server/logging"
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// logger is the logger of the audit subsystem.
+var logger = logging.Logger(logging.Audit)
+
+// Config is the configuration of the audit log.
+type Config struct {
+	// Sink is where events are written: file, sqlite, stdout, syslog or
+	// webhook. Without a sink, no events are recorded.
+	Sink string `mapstructure:"sink" yaml:"sink" json:"sink"`
+	// Path is the path of the file or SQLite database events are written to.
+	Path string `mapstructure:"path" yaml:"path" json:"path"`
+	// RedactSQL replaces the string and number literals of the SQL recorded
+	// with ?.
+	RedactSQL bool `mapstructure:"redact_sql" yaml:"redact_sql" json:"redact_sql"`
+
+	Syslog  SyslogConfig  `mapstructure:"syslog" yaml:"syslog" json:"syslog"`
+	Webhook WebhookConfig `mapstructure:"webhook" yaml:"webhook" json:"webhook"`
+}
+
+// Event types.
+const (
+	ConnectionOpen     = "connection_open"
+	ConnectionClose    = "connection_close"
+	ConnectionFailover = "connection_failover"
+	Query              = "query"
+	Statement          = "statement"
+	Stream             = "stream"
+	Cursor             = "cursor"
+)
+
+// Outcomes of events.
+const (
+	Success = "success"
+	Denied  = "denied"
+	Failure = "error"
+)
+
+// Event is an audited event.
+type Event struct {
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	Principal    string    `json:"principal,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Session      string    `json:"session,omitempty"`
+	ConnectionID string    `json:"connection_id"`
+	SQL          string    `json:"sql,omitempty"`
+	ParamsHash   string    `json:"params_hash,omitempty"`
+	Rows         int64     `json:"rows"`
+	DurationMS   float64   `json:"duration_ms"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+// Sink is a destination of audit events.
+type Sink interface {
+	Write(event Event) error
+	Close() error
+}
+
+// Logger records audit events to its sink. A nil logger records nothing.
+type Logger struct {
+	config Config
+
+	mu   sync.Mutex
+	sink Sink
+}
+
+// New creates the audit logger writing to the configured sink, or returns
+// nil when no sink is configured.
+func New(config Config) (*Logger, error) {
+	var sink Sink
+	var err error
+	switch strings.ToLower(config.Sink) {
+	case "":
+		return nil, nil
+	case "stdout":
+		sink = newWriterSink(stdout{})
+	case "file":
+		sink, err = newFileSink(config.Path)
+	case "sqlite":
+		sink, err = newSQLiteSink(config.Path)
+	case "syslog":
+		sink, err = newSyslogSink(config.Syslog)
+	case "webhook":
+		sink, err = newWebhookSink(config.Webhook)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", config.Sink)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s audit sink: %w", config.Sink, err)
+	}
+	return &Logger{config: config, sink: sink}, nil
+}
+
+// Log records the event, at the current time when its time is not set.
+// Events failing to be written are logged.
+func (l *Logger) Log(event Event) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+	if l.config.RedactSQL {
+		event.SQL = Redact(event.SQL)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.sink.Write(event); err != nil {
+		logger.Error("failed to write audit event", "type", event.Type, "connection", event.ConnectionID, "error", err)
+	}
+}
+
+// Close closes the logger's sink.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sink.Close()
+}
+
+// Redact returns the SQL with its string and number literals replaced with
+// ?.
+func Redact(sql string) string {
+	var b strings.Builder
+	for _, t := range sqlscan.Scan(sql) {
+		switch t.Kind {
+		case sqlscan.String, sqlscan.Number:
+			b.WriteByte('?')
+		default:
+			b.WriteString(t.Text)
+		}
+	}
+	return b.String()
+}
+
+// HashParams returns the hex encoded SHA-256 hash of the JSON encoding of the
+// parameters, or an empty string when there are none, so that executions
+// with the same parameters are recognized without recording their values.
+func HashParams(params []interface{}) string {
+	if len(params) == 0 {
+		return ""
+	}
+	buf, err := json.Marshal(params)
+	if err != nil {
+		buf = fmt.Appendf(nil, "%v", params)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
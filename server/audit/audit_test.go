@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+	_ "github.com/xo/usql/drivers/sqlite3"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := New(Config{Sink: "file", Path: path, RedactSQL: true})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	l.Log(Event{Type: Query, Principal: "alice", ConnectionID: "db", SQL: "SELECT * FROM t WHERE name = 'bob' AND id > 10", Rows: 3, Outcome: Success})
+	l.Log(Event{Type: ConnectionClose, ConnectionID: "db", Outcome: Success, Detail: "idle"})
+	if err := l.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer f.Close()
+	var events []Event
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var event Event
+		if err := json.Unmarshal(s.Bytes(), &event); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		events = append(events, event)
+	}
+	switch {
+	case len(events) != 2:
+		t.Fatalf("expected 2 events, got: %d", len(events))
+	case events[0].SQL != "SELECT * FROM t WHERE name = ? AND id > ?" || events[0].Principal != "alice" || events[0].Rows != 3:
+		t.Errorf("expected the query event with its SQL redacted, got: %+v", events[0])
+	case events[0].Time.IsZero() || events[1].Detail != "idle":
+		t.Errorf("expected the events to be timed, got: %+v", events)
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	l, err := New(Config{Sink: "sqlite", Path: path})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	l.Log(Event{Type: Statement, ConnectionID: "db", SQL: "DELETE FROM t", ParamsHash: HashParams([]interface{}{1}), Rows: 7, Outcome: Success})
+	l.Log(Event{Type: Statement, ConnectionID: "db", SQL: "DROP TABLE t", Outcome: Denied, Error: "denied"})
+	if err := l.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	u, _ := dburl.Parse("sqlite:" + path)
+	db, err := drivers.Open(context.Background(), u, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer db.Close()
+	var rows, denied int
+	if err := db.QueryRow(`SELECT SUM(rows), SUM(outcome = 'denied') FROM audit_log`).Scan(&rows, &denied); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if rows != 7 || denied != 1 {
+		t.Errorf("expected 7 rows and 1 denied statement, got: %d %d", rows, denied)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer pc.Close()
+	l, err := New(Config{Sink: "syslog", Syslog: SyslogConfig{Address: pc.LocalAddr().String(), Facility: "auth"}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer l.Close()
+	l.Log(Event{Type: Statement, ConnectionID: "db", SQL: "DROP TABLE t", Outcome: Denied})
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// auth (4) * 8 + warning (4)
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<36>1 ") || !strings.Contains(msg, " usqlr ") || !strings.Contains(msg, `"sql":"DROP TABLE t"`) {
+		t.Errorf("expected a syslog message of the event, got: %q", msg)
+	}
+	if _, err := New(Config{Sink: "syslog", Syslog: SyslogConfig{Address: pc.LocalAddr().String(), Facility: "nope"}}); err == nil {
+		t.Errorf("expected an error with an unknown facility")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var batches [][]Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests == 1 || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []Event
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches = append(batches, batch)
+	}))
+	defer srv.Close()
+
+	l, err := New(Config{Sink: "webhook", Webhook: WebhookConfig{
+		URL:          srv.URL,
+		Headers:      map[string]string{"Authorization": "Bearer token"},
+		BatchSize:    2,
+		RetryBackoff: time.Millisecond,
+	}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		l.Log(Event{Type: Query, ConnectionID: id, Outcome: Success})
+	}
+	// the last, partial batch is posted on close
+	if err := l.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 || len(batches) != 2 || len(batches[0]) != 2 || batches[1][0].ConnectionID != "c" {
+		t.Errorf("expected the first batch retried, and 2 batches posted, got: %d requests, %+v", requests, batches)
+	}
+}
+
+func TestHashParams(t *testing.T) {
+	if h := HashParams(nil); h != "" {
+		t.Errorf("expected no hash without params, got: %q", h)
+	}
+	a, b := HashParams([]interface{}{1, "a"}), HashParams([]interface{}{1, "b"})
+	if len(a) != 64 || a == b || a != HashParams([]interface{}{1, "a"}) {
+		t.Errorf("expected hashes to identify the params, got: %q %q", a, b)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	l, err := New(Config{})
+	if err != nil || l != nil {
+		t.Fatalf("expected no logger without a sink, got: %v %v", l, err)
+	}
+	l.Log(Event{Time: time.Now(), Type: Query})
+	if err := l.Close(); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if _, err := New(Config{Sink: "tape"}); err == nil {
+		t.Errorf("expected an error with an unknown sink")
+	}
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+// writerSink writes events as JSON lines to a writer, closed with the sink
+// when it is a closer.
+type writerSink struct {
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// newWriterSink creates a sink writing events as JSON lines to w.
+func newWriterSink(w io.Writer) *writerSink {
+	return &writerSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// Write satisfies the Sink interface.
+func (s *writerSink) Write(event Event) error {
+	return s.enc.Encode(event)
+}
+
+// Close satisfies the Sink interface.
+func (s *writerSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// stdout writes to the standard output, which is never closed.
+type stdout struct{}
+
+// Write satisfies the io.Writer interface.
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// newFileSink creates a sink appending events as JSON lines to the file at
+// path, created readable only by the owner.
+func newFileSink(path string) (*writerSink, error) {
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return newWriterSink(f), nil
+}
+
+// sqliteSink inserts events into the audit_log table of a SQLite database.
+type sqliteSink struct {
+	db   *sql.DB
+	stmt *sql.Stmt
+}
+
+// sqliteSchema creates the audit_log table.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS audit_log (
+  time TEXT NOT NULL,
+  type TEXT NOT NULL,
+  principal TEXT,
+  tenant TEXT,
+  session TEXT,
+  connection_id TEXT,
+  sql TEXT,
+  params_hash TEXT,
+  rows INTEGER,
+  duration_ms REAL,
+  outcome TEXT,
+  error TEXT,
+  detail TEXT
+)`
+
+// newSQLiteSink creates a sink inserting events into the SQLite database at
+// path, creating its audit_log table if needed. The binary must be built
+// with a SQLite driver.
+func newSQLiteSink(path string) (*sqliteSink, error) {
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+	u, err := dburl.Parse("sqlite:" + path)
+	if err != nil {
+		return nil, err
+	}
+	if !drivers.Registered(u.Driver) {
+		return nil, fmt.Errorf("driver %s is not available", u.Driver)
+	}
+	ctx := context.Background()
+	db, err := drivers.Open(ctx, u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	stmt, err := db.PrepareContext(ctx, `INSERT INTO audit_log (time, type, principal, tenant, session, connection_id, sql, params_hash, rows, duration_ms, outcome, error, detail) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteSink{db: db, stmt: stmt}, nil
+}
+
+// Write satisfies the Sink interface.
+func (s *sqliteSink) Write(e Event) error {
+	_, err := s.stmt.Exec(e.Time.Format(time.RFC3339Nano), e.Type, e.Principal, e.Tenant, e.Session, e.ConnectionID, e.SQL, e.ParamsHash, e.Rows, e.DurationMS, e.Outcome, e.Error, e.Detail)
+	return err
+}
+
+// Close satisfies the Sink interface.
+func (s *sqliteSink) Close() error {
+	s.stmt.Close()
+	return s.db.Close()
+}
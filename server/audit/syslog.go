@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// SyslogConfig is the configuration of the syslog sink.
+type SyslogConfig struct {
+	// Network is the network of the syslog server: udp (the default), tcp,
+	// unix or unixgram.
+	Network string `mapstructure:"network" yaml:"network" json:"network"`
+	// Address is the address of the syslog server, or the path of its
+	// socket (e.g. /dev/log, a unixgram socket).
+	Address string `mapstructure:"address" yaml:"address" json:"address"`
+	// Facility is the facility of the messages (local0 by default).
+	Facility string `mapstructure:"facility" yaml:"facility" json:"facility"`
+	// Tag is the app name of the messages (usqlr by default).
+	Tag string `mapstructure:"tag" yaml:"tag" json:"tag"`
+}
+
+// facilities are the syslog facility codes, by name.
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities of events.
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// syslogSink sends events as JSON messages to a syslog server, in the RFC
+// 5424 format, with the octet counting framing of RFC 6587 over streams.
+type syslogSink struct {
+	config   SyslogConfig
+	facility int
+	hostname string
+	conn     net.Conn
+}
+
+// newSyslogSink creates a sink sending events to the configured syslog
+// server.
+func newSyslogSink(config SyslogConfig) (*syslogSink, error) {
+	if config.Address == "" {
+		return nil, errors.New("address is required")
+	}
+	if config.Network == "" {
+		config.Network = "udp"
+	}
+	if config.Tag == "" {
+		config.Tag = "usqlr"
+	}
+	name := strings.ToLower(config.Facility)
+	if name == "" {
+		name = "local0"
+	}
+	facility, ok := facilities[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown facility %q", config.Facility)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	s := &syslogSink{config: config, facility: facility, hostname: hostname}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dial connects to the syslog server.
+func (s *syslogSink) dial() error {
+	conn, err := net.DialTimeout(s.config.Network, s.config.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// Write satisfies the Sink interface, reconnecting once when the message
+// fails to be sent.
+func (s *syslogSink) Write(event Event) error {
+	msg, err := s.format(event)
+	if err != nil {
+		return err
+	}
+	if s.conn != nil {
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+// format formats the event as a syslog message.
+func (s *syslogSink) format(event Event) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	severity := severityInfo
+	switch event.Outcome {
+	case Denied:
+		severity = severityWarning
+	case Failure:
+		severity = severityError
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", s.facility*8+severity, event.Time.Format(time.RFC3339Nano), s.hostname, s.config.Tag, os.Getpid(), event.Type, data)
+	if strings.HasPrefix(s.config.Network, "tcp") || s.config.Network == "unix" {
+		// stream messages are framed by their length
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return []byte(msg), nil
+}
+
+// Close satisfies the Sink interface.
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig is the configuration of the webhook sink.
+type WebhookConfig struct {
+	// URL is the URL events are posted to, as JSON arrays of events.
+	URL string `mapstructure:"url" yaml:"url" json:"url"`
+	// Headers are the headers of the requests, such as Authorization.
+	Headers map[string]string `mapstructure:"headers" yaml:"headers" json:"headers"`
+	// BatchSize is the maximum number of events posted at once (100 by
+	// default).
+	BatchSize int `mapstructure:"batch_size" yaml:"batch_size" json:"batch_size"`
+	// FlushInterval is the maximum time events are held before being
+	// posted (5s by default).
+	FlushInterval time.Duration `mapstructure:"flush_interval" yaml:"flush_interval" json:"flush_interval"`
+	// MaxRetries is the number of times a failed batch is retried (3 by
+	// default, -1 for none), backing off exponentially from RetryBackoff
+	// (1s by default).
+	MaxRetries   int           `mapstructure:"max_retries" yaml:"max_retries" json:"max_retries"`
+	RetryBackoff time.Duration `mapstructure:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`
+	// Timeout bounds each request (10s by default).
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout" json:"timeout"`
+	// MaxQueued is the maximum number of events waiting to be posted
+	// (10000 by default), beyond which events are dropped.
+	MaxQueued int `mapstructure:"max_queued" yaml:"max_queued" json:"max_queued"`
+}
+
+// webhookSink posts events in batches to a webhook, in the background.
+type webhookSink struct {
+	config WebhookConfig
+	client *http.Client
+	queue  chan Event
+	done   chan struct{}
+}
+
+// newWebhookSink creates a sink posting events to the configured webhook,
+// starting its sender.
+func newWebhookSink(config WebhookConfig) (*webhookSink, error) {
+	if config.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	} else if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxQueued <= 0 {
+		config.MaxQueued = 10000
+	}
+	s := &webhookSink{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		queue:  make(chan Event, config.MaxQueued),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write satisfies the Sink interface, queueing the event to be posted.
+func (s *webhookSink) Write(event Event) error {
+	select {
+	case s.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full (max: %d), event dropped", s.config.MaxQueued)
+	}
+}
+
+// Close satisfies the Sink interface, posting the queued events.
+func (s *webhookSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+// run posts the queued events once a batch is full, or the flush interval
+// passed, until the sink is closed.
+func (s *webhookSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	var batch []Event
+	for {
+		select {
+		case event, ok := <-s.queue:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			if batch = append(batch, event); len(batch) >= s.config.BatchSize {
+				s.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			s.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush posts the batch, retrying failed requests, and logging the batch as
+// dropped once all retries failed.
+func (s *webhookSink) flush(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logger.Error("failed to encode audit events", "events", len(batch), "error", err)
+		return
+	}
+	delay := s.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == s.config.MaxRetries {
+			logger.Error("failed to post audit events to webhook, dropped", "events", len(batch), "error", err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post posts the body, returning whether a failed request may be retried:
+// when it failed to be sent, or with a server error or a 429 status.
+func (s *webhookSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return false, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+}
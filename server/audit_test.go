@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestAuditLog(t *testing.T) {
+	policies, err := policy.Parse(strings.NewReader("name: no-drop\nrules:\n  - action: deny\n    statements: [drop]\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	engine, _ := policy.NewEngine(policies, policy.Allow)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cp := NewConnectionPool(&Config{}, engine, nil)
+	if cp.audit, err = audit.New(audit.Config{Sink: "file", Path: path}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	u, _ := dburl.Parse("postgres://localhost/db")
+	conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), policy: engine, audit: cp.audit}
+	cp.connections["c"] = conn
+
+	ctx := policy.WithPrincipal(context.Background(), "alice")
+	if _, err := conn.ExecuteStatement(ctx, "UPDATE t SET a = $1", 5); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := conn.ExecuteStatement(ctx, "DROP TABLE t"); err == nil {
+		t.Fatalf("expected the statement to be denied")
+	}
+	if err := cp.CloseConnection("c"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	cp.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer f.Close()
+	var events []audit.Event
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var event audit.Event
+		if err := json.Unmarshal(s.Bytes(), &event); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got: %+v", events)
+	}
+	if e := events[0]; e.Type != audit.Statement || e.Principal != "alice" || e.SQL != "UPDATE t SET a = $1" || e.ParamsHash != audit.HashParams([]interface{}{5}) || e.Rows != 1 || e.Outcome != audit.Success {
+		t.Errorf("expected the update to be audited, got: %+v", e)
+	}
+	if e := events[1]; e.Type != audit.Statement || e.Outcome != audit.Denied || e.Error == "" {
+		t.Errorf("expected the drop to be audited as denied, got: %+v", e)
+	}
+	if e := events[2]; e.Type != audit.ConnectionClose || e.ConnectionID != "c" || e.Detail != "closed" {
+		t.Errorf("expected the connection's close to be audited, got: %+v", e)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+// authParam is the DSN parameter selecting how a connection authenticates
+// when it uses short-lived tokens rather than a password.
+const authParam = "auth"
+
+// TokenSource generates the short-lived tokens a connection authenticates
+// with as its password, such as cloud IAM tokens.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// authMode is a way of authenticating with short-lived tokens.
+type authMode struct {
+	// params are the DSN parameters of the mode, not passed to the driver
+	params []string
+	// source creates the token source of a DSN
+	source func(context.Context, *dburl.URL) (TokenSource, error)
+}
+
+// authModes are the auth modes, by the value of the DSN's auth parameter.
+var authModes = map[string]authMode{
+	"rds-iam":  {params: []string{"aws_region"}, source: newRDSTokenSource},
+	"azure-ad": {params: []string{"azure_tenant_id", "azure_client_id", "azure_client_secret"}, source: newAzureTokenSource},
+}
+
+// connectorSource is a token source of drivers taking tokens other than as
+// the password, returning the connector of the driver, or nil for drivers
+// taking the token as the password.
+type connectorSource interface {
+	connector(u *dburl.URL) (driver.Connector, error)
+}
+
+// parseAuth returns the DSN without its auth parameters, and the token
+// source of its auth mode, or a nil source when the DSN has no auth mode.
+// Auth parameters of other values are left for the driver.
+func parseAuth(ctx context.Context, u *dburl.URL) (*dburl.URL, TokenSource, error) {
+	query := u.Query()
+	mode, ok := authModes[query.Get(authParam)]
+	if !ok {
+		return u, nil, nil
+	}
+	src, err := mode.source(ctx, u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s auth: %w", query.Get(authParam), err)
+	}
+	query.Del(authParam)
+	for _, param := range mode.params {
+		query.Del(param)
+	}
+	v := u.URL
+	v.RawQuery = query.Encode()
+	if u, err = dburl.Parse(v.String()); err != nil {
+		return nil, nil, err
+	}
+	return u, src, nil
+}
+
+// withToken returns the DSN with the token as its password.
+func withToken(u *dburl.URL, token string) (*dburl.URL, error) {
+	v := u.URL
+	v.User = url.UserPassword(u.User.Username(), token)
+	return dburl.Parse(v.String())
+}
+
+// openTokenDB opens the database at the DSN, authenticating with a token
+// generated by the source each time a database connection is opened, so
+// that tokens are refreshed as the pool dials new connections.
+func openTokenDB(ctx context.Context, u *dburl.URL, src TokenSource) (*sql.DB, error) {
+	if cs, ok := src.(connectorSource); ok {
+		connector, err := cs.connector(u)
+		if err != nil {
+			return nil, err
+		}
+		if connector != nil {
+			return sql.OpenDB(connector), nil
+		}
+	}
+	token, err := src.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate auth token: %w", err)
+	}
+	tu, err := withToken(u, token)
+	if err != nil {
+		return nil, err
+	}
+	// the database is opened by the driver to find the driver's
+	// underlying database/sql driver
+	db, err := drivers.Open(ctx, tu, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close()
+	return sql.OpenDB(&tokenConnector{url: u, src: src, driver: d}), nil
+}
+
+// tokenConnector is a database/sql connector opening database connections
+// authenticated with a fresh token.
+type tokenConnector struct {
+	url    *dburl.URL
+	src    TokenSource
+	driver driver.Driver
+}
+
+// Connect satisfies the driver.Connector interface.
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.src.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate auth token: %w", err)
+	}
+	u, err := withToken(c.url, token)
+	if err != nil {
+		return nil, err
+	}
+	if dc, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(u.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(u.DSN)
+}
+
+// Driver satisfies the driver.Connector interface.
+func (c *tokenConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/xo/dburl"
+)
+
+func TestParseAuth(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	u, _ := dburl.Parse("postgres://app@db.123456789012.us-east-1.rds.amazonaws.com/app?auth=rds-iam&sslmode=require")
+	u, src, err := parseAuth(context.Background(), u)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case u.RawQuery != "sslmode=require":
+		t.Errorf("expected the auth parameters to be removed, got: %q", u.RawQuery)
+	case src.(*rdsTokenSource).region != "us-east-1" || src.(*rdsTokenSource).endpoint != "db.123456789012.us-east-1.rds.amazonaws.com:5432":
+		t.Errorf("expected the endpoint's region and port, got: %+v", src)
+	}
+
+	// other auth parameters are left for the driver
+	u, _ = dburl.Parse("postgres://app@localhost/app?auth=other")
+	if v, src, err := parseAuth(context.Background(), u); err != nil || src != nil || v.RawQuery != "auth=other" {
+		t.Errorf("expected the DSN as is, got: %v %v %v", v, src, err)
+	}
+	for _, dsn := range []string{"sqlite3:/tmp/db?auth=rds-iam", "postgres://db.example.com/app?auth=rds-iam"} {
+		u, _ := dburl.Parse(dsn)
+		if _, _, err := parseAuth(context.Background(), u); err == nil {
+			t.Errorf("expected an error for %s", dsn)
+		}
+	}
+}
+
+func TestRDSToken(t *testing.T) {
+	src := &rdsTokenSource{
+		endpoint: "db.example.com:3306",
+		user:     "app",
+		region:   "eu-west-1",
+		credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+		}),
+		signer: v4.NewSigner(),
+	}
+	token, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for _, s := range []string{"db.example.com:3306/?", "Action=connect", "DBUser=app", "X-Amz-Expires=900", "X-Amz-Credential=AKID%2F", "%2Feu-west-1%2Frds-db%2Faws4_request", "X-Amz-Signature="} {
+		if !strings.Contains(token, s) {
+			t.Errorf("expected the token to contain %q, got: %s", s, token)
+		}
+	}
+}
+
+func TestTokenConnector(t *testing.T) {
+	u, _ := dburl.Parse("postgres://app@localhost/app")
+	d := new(tokenDriver)
+	n := 0
+	c := &tokenConnector{url: u, driver: d, src: tokenFunc(func(context.Context) (string, error) {
+		n++
+		return fmt.Sprintf("token%d", n), nil
+	})}
+	for i := 1; i <= 2; i++ {
+		if _, err := c.Connect(context.Background()); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if !strings.Contains(d.dsn, fmt.Sprintf("token%d", i)) {
+			t.Errorf("expected a fresh token, got: %s", d.dsn)
+		}
+	}
+}
+
+// tokenFunc is a token source func.
+type tokenFunc func(context.Context) (string, error)
+
+func (f tokenFunc) Token(ctx context.Context) (string, error) { return f(ctx) }
+
+// tokenDriver is a driver recording the DSN of the last connection opened.
+type tokenDriver struct {
+	dsn string
+}
+
+func (d *tokenDriver) Open(dsn string) (driver.Conn, error) {
+	d.dsn = dsn
+	return multiConn{}, nil
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// Scopes of authenticated principals. Scopes with the policy package's tool
+// and connection prefixes further restrict the tools and connections they
+// can use.
+const (
+	// ScopeAdmin grants the admin API.
+	ScopeAdmin = "admin"
+	// ScopeQuery grants the MCP endpoint and the REST API.
+	ScopeQuery = "query"
+	// ScopeGlobal grants a principal in no tenant the connections of every
+	// tenant, when tenants are configured.
+	ScopeGlobal = "global"
+	// ScopeAll grants everything.
+	ScopeAll = "*"
+)
+
+// validateScope validates a scope granted to a principal.
+func validateScope(scope string) error {
+	switch {
+	case scope == ScopeAdmin || scope == ScopeQuery || scope == ScopeGlobal || scope == ScopeAll:
+		return nil
+	case strings.HasPrefix(scope, policy.ToolScopePrefix), strings.HasPrefix(scope, policy.ConnectionScopePrefix):
+		pattern := scope[strings.Index(scope, ":")+1:]
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+		return nil
+	case strings.HasPrefix(scope, roleScopePrefix) && scope != roleScopePrefix:
+		return nil
+	}
+	return fmt.Errorf("invalid scope %q", scope)
+}
+
+// principal is the principal a request is authenticated as.
+type principal struct {
+	Name       string
+	Scopes     []string
+	Attributes map[string]string
+
+	// Tenant is the tenant the principal's connections are isolated in, if
+	// any.
+	Tenant string
+}
+
+// hasScope reports whether the principal is granted the scope.
+func (p *principal) hasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAll)
+}
+
+// adminOnly reports whether the request is to the admin API, the metrics,
+// the runtime diagnostics or the detailed health check, which span all
+// tenants.
+func (s *Server) adminOnly(r *http.Request) bool {
+	switch path := r.URL.Path; {
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/debug/"):
+		return true
+	case path == "/health":
+		return healthDetail(r)
+	}
+	return s.metrics != nil && r.URL.Path == s.metrics.path
+}
+
+// authMiddleware authenticates requests, other than bare health checks and
+// to the OAuth endpoints, with an API key in the configured header, or a
+// bearer token (a JWT, an OAuth access token, or an API key), requiring the
+// admin scope for the admin API (and other admin only endpoints) and the
+// query scope otherwise. Requests are made
+// as the principal, with its attributes, scopes and tenant, and the access of
+// its roles when roles are configured. With OAuth, failures
+// challenge clients to authorize with the resource metadata's server.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.URL.Path == "/health" && !healthDetail(r)) || (s.oauth != nil && s.oauth.public(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := s.authenticate(r)
+		if err != nil {
+			if s.oauth != nil {
+				w.Header().Set("WWW-Authenticate", s.oauth.challenge(r, ""))
+			}
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		scope := ScopeQuery
+		if s.adminOnly(r) {
+			scope = ScopeAdmin
+		}
+		if !p.hasScope(scope) {
+			if s.oauth != nil {
+				w.Header().Set("WWW-Authenticate", s.oauth.challenge(r, fmt.Sprintf(`error="insufficient_scope", scope=%q`, scope)))
+			}
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s is not granted the %s scope", p.Name, scope))
+			return
+		}
+		if scope == ScopeAdmin && p.Tenant != "" {
+			// the admin API spans all tenants
+			writeError(w, http.StatusForbidden, fmt.Errorf("the admin API is not available to tenant %s", p.Tenant))
+			return
+		}
+		ctx := policy.WithPrincipal(r.Context(), p.Name)
+		ctx = policy.WithScopes(ctx, p.Scopes)
+		if roles := s.config().Roles; len(roles) != 0 {
+			ctx = policy.WithAccess(ctx, s.access(p, roles))
+		}
+		if p.Attributes != nil {
+			ctx = policy.WithAttributes(ctx, p.Attributes)
+		}
+		if p.Tenant != "" {
+			ctx = policy.WithTenant(ctx, p.Tenant)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate returns the principal the request is authenticated as, in
+// the tenant named by its tenant attribute, when configured. Principals
+// without the attribute are then refused, unless granted the admin or
+// global scope, as they would see every tenant's connections.
+func (s *Server) authenticate(r *http.Request) (*principal, error) {
+	p, err := s.credentialsPrincipal(r)
+	if err != nil {
+		return nil, err
+	}
+	if attr := s.config().Auth.TenantAttribute; attr != "" {
+		p.Tenant = p.Attributes[attr]
+		switch {
+		case strings.Contains(p.Tenant, policy.TenantSeparator):
+			return nil, fmt.Errorf("invalid tenant %q", p.Tenant)
+		case p.Tenant == "" && !p.hasScope(ScopeAdmin) && !p.hasScope(ScopeGlobal):
+			return nil, fmt.Errorf("%s is in no tenant", p.Name)
+		}
+	}
+	return p, nil
+}
+
+// credentialsPrincipal returns the principal of the request's credentials.
+func (s *Server) credentialsPrincipal(r *http.Request) (*principal, error) {
+	auth := s.config().Auth
+	if s.keys != nil {
+		if secret := r.Header.Get(auth.APIKeyHeader); secret != "" {
+			return s.keyPrincipal(secret)
+		}
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	switch {
+	case !ok || token == "":
+		return nil, errors.New("missing credentials")
+	case s.keys != nil && strings.HasPrefix(token, keyPrefix):
+		return s.keyPrincipal(token)
+	case s.jwt == nil && s.oauth == nil:
+		return nil, errors.New("invalid credentials")
+	}
+	var err error
+	if s.jwt != nil {
+		var p *principal
+		if p, err = s.jwt.verify(r.Context(), token); err == nil {
+			return p, nil
+		}
+	}
+	if s.oauth != nil {
+		var p *principal
+		if p, err = s.oauth.verify(r.Context(), token); err == nil {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid token: %w", err)
+}
+
+// keyPrincipal returns the principal of the API key with the secret.
+func (s *Server) keyPrincipal(secret string) (*principal, error) {
+	key := s.keys.Authenticate(secret)
+	if key == nil {
+		return nil, errors.New("invalid API key")
+	}
+	return &principal{Name: key.Name, Scopes: key.Scopes, Attributes: key.Attributes}, nil
+}
+
+// pathConnection returns the ID of the connection of the request's path, in
+// the namespace of the principal's tenant.
+func pathConnection(r *http.Request) string {
+	return policy.QualifyConnection(r.Context(), r.PathValue("id"))
+}
+
+// allowConnection reports whether the request's principal may use the
+// connection with the ID, writing a forbidden response when it may not.
+func allowConnection(w http.ResponseWriter, r *http.Request, id string) bool {
+	if policy.AllowsConnection(r.Context(), id) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, fmt.Errorf("connection %s is not granted", id))
+	return false
+}
+
+// allowTool reports whether the request's principal may call the tool of
+// the class, writing a forbidden response when it may not. Endpoints doing
+// what a tool does are granted as the tool.
+func allowTool(w http.ResponseWriter, r *http.Request, name, class string) bool {
+	if policy.AllowsTool(r.Context(), name, class) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, fmt.Errorf("tool %s is not granted", name))
+	return false
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"github.com/xo/dburl"
+)
+
+// azureScopes are the token scopes of the drivers Azure AD auth supports.
+var azureScopes = map[string]string{
+	"sqlserver": "https://database.windows.net/.default",
+	"postgres":  "https://ossrdbms-aad.database.windows.net/.default",
+	"pgx":       "https://ossrdbms-aad.database.windows.net/.default",
+}
+
+// azureTokenSource generates Azure AD (Entra ID) access tokens. Tokens are
+// cached by the credential until shortly before they expire.
+type azureTokenSource struct {
+	credential azcore.TokenCredential
+	scope      string
+}
+
+// newAzureTokenSource creates an Azure AD token source for the DSN. With
+// the azure_tenant_id, azure_client_id and azure_client_secret parameters,
+// it authenticates with client credentials, with only azure_client_id, with
+// that user-assigned managed identity, and otherwise with the environment's
+// default credential (environment variables, workload identity, managed
+// identity, or the Azure CLI).
+func newAzureTokenSource(_ context.Context, u *dburl.URL) (TokenSource, error) {
+	scope, ok := azureScopes[u.Driver]
+	if !ok {
+		return nil, fmt.Errorf("driver %s is not supported", u.Driver)
+	}
+	query := u.Query()
+	tenant, client, secret := query.Get("azure_tenant_id"), query.Get("azure_client_id"), query.Get("azure_client_secret")
+	var credential azcore.TokenCredential
+	var err error
+	switch {
+	case secret != "":
+		if tenant == "" || client == "" {
+			return nil, errors.New("azure_tenant_id and azure_client_id are required with azure_client_secret")
+		}
+		credential, err = azidentity.NewClientSecretCredential(tenant, client, secret, nil)
+	case client != "":
+		credential, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(client),
+		})
+	default:
+		credential, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			TenantID: tenant,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+	}
+	return &azureTokenSource{credential: credential, scope: scope}, nil
+}
+
+// Token satisfies the TokenSource interface.
+func (s *azureTokenSource) Token(ctx context.Context) (string, error) {
+	token, err := s.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{s.scope}})
+	if err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// connector returns the connector of SQL Server databases, which take the
+// token as a federated authentication token rather than as the password.
+func (s *azureTokenSource) connector(u *dburl.URL) (driver.Connector, error) {
+	if u.Driver != "sqlserver" {
+		return nil, nil
+	}
+	config, err := msdsn.Parse(u.DSN)
+	if err != nil {
+		return nil, err
+	}
+	return mssql.NewSecurityTokenConnector(config, s.Token)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/xo/dburl"
+)
+
+func TestAzureAuth(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://db.database.windows.net/app?auth=azure-ad&azure_tenant_id=tenant&azure_client_id=client&azure_client_secret=secret")
+	u, src, err := parseAuth(context.Background(), u)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case u.RawQuery != "":
+		t.Errorf("expected the auth parameters to be removed, got: %q", u.RawQuery)
+	case src.(*azureTokenSource).scope != "https://database.windows.net/.default":
+		t.Errorf("expected the SQL Server scope, got: %s", src.(*azureTokenSource).scope)
+	}
+
+	// SQL Server takes the token through its connector, and Postgres as the
+	// password
+	if c, err := src.(connectorSource).connector(u); c == nil || err != nil {
+		t.Errorf("expected a SQL Server connector, got: %v %v", c, err)
+	}
+	pg, _ := dburl.Parse("postgres://app@db.postgres.database.azure.com/app")
+	if c, err := src.(connectorSource).connector(pg); c != nil || err != nil {
+		t.Errorf("expected no connector, got: %v %v", c, err)
+	}
+
+	s := &azureTokenSource{credential: azureCredential{}, scope: azureScopes["postgres"]}
+	if token, err := s.Token(context.Background()); err != nil || token != "https://ossrdbms-aad.database.windows.net/.default" {
+		t.Errorf("expected a token of the Postgres scope, got: %q %v", token, err)
+	}
+
+	for _, dsn := range []string{
+		"mysql://app@localhost/app?auth=azure-ad",
+		"postgres://app@localhost/app?auth=azure-ad&azure_client_secret=secret",
+	} {
+		u, _ := dburl.Parse(dsn)
+		if _, _, err := parseAuth(context.Background(), u); err == nil {
+			t.Errorf("expected an error for %s", dsn)
+		}
+	}
+}
+
+// azureCredential is a credential whose tokens are their scopes.
+type azureCredential struct{}
+
+func (azureCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: opts.Scopes[0]}, nil
+}
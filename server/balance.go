@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+const (
+	// instanceDownFor is how long an instance is taken out of rotation
+	// after a connection error.
+	instanceDownFor = 30 * time.Second
+
+	// latencyWeight is the weight of the latest query in an instance's
+	// moving average latency.
+	latencyWeight = 0.2
+)
+
+// instanceHealth tracks an instance's recent health and latency, used to
+// balance read queries across a connection's instances.
+type instanceHealth struct {
+	mu        sync.Mutex
+	latency   time.Duration
+	queries   int64
+	failures  int64
+	downUntil time.Time
+}
+
+// InstanceInfo describes an instance of a connection.
+type InstanceInfo struct {
+	Host      string     `json:"host"`
+	Database  string     `json:"database"`
+	Primary   bool       `json:"primary"`
+	Healthy   bool       `json:"healthy"`
+	LatencyMS float64    `json:"latency_ms"`
+	Queries   int64      `json:"queries"`
+	Failures  int64      `json:"failures"`
+	DownUntil *time.Time `json:"down_until,omitempty"`
+}
+
+// observe records the outcome of a query started at the time. Connection
+// errors take the instance out of rotation for instanceDownFor.
+func (h *instanceHealth) observe(start time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queries++
+	switch {
+	case err == nil:
+		d := time.Since(start)
+		if h.latency == 0 {
+			h.latency = d
+		} else {
+			h.latency += time.Duration(latencyWeight * float64(d-h.latency))
+		}
+	case isConnError(err):
+		h.failures++
+		h.downUntil = time.Now().Add(instanceDownFor)
+	}
+}
+
+// up returns whether the instance is in rotation, and its average latency.
+func (h *instanceHealth) up(now time.Time) (bool, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !now.Before(h.downUntil), h.latency
+}
+
+// isConnError returns whether err indicates a broken connection to the
+// database, rather than an error in the query.
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// AddReplica opens a further instance of a connection (e.g. a read replica
+// of the database), across which the connection's read queries are
+// balanced. The instance must use the same driver as the connection.
+func (cp *ConnectionPool) AddReplica(ctx context.Context, id, dsn string) error {
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("connection with ID %s not found", id)
+	}
+
+	replica, err := cp.open(ctx, id, dsn, connOptions{predefined: conn.predefined, settings: conn.settings, tls: conn.tls})
+	if err != nil {
+		return err
+	}
+	if replica.URL.Driver != conn.URL.Driver {
+		replica.closeDB()
+		return fmt.Errorf("replica driver %s does not match connection driver %s", replica.URL.Driver, conn.URL.Driver)
+	}
+
+	conn.replicaMu.Lock()
+	conn.replicas = append(conn.replicas, replica)
+	conn.replicaMu.Unlock()
+
+	cp.redefined()
+	return nil
+}
+
+// Instances returns the instances of a connection, the connection itself
+// first.
+func (cp *ConnectionPool) Instances(id string) ([]InstanceInfo, error) {
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("connection with ID %s not found", id)
+	}
+
+	now := time.Now()
+	instances := append([]*Connection{conn}, conn.replicaList()...)
+	infos := make([]InstanceInfo, len(instances))
+	for i, inst := range instances {
+		inst.health.mu.Lock()
+		infos[i] = InstanceInfo{
+			Host:      inst.URL.Host,
+			Database:  inst.URL.Path,
+			Primary:   i == 0,
+			Healthy:   !now.Before(inst.health.downUntil),
+			LatencyMS: float64(inst.health.latency) / float64(time.Millisecond),
+			Queries:   inst.health.queries,
+			Failures:  inst.health.failures,
+		}
+		if !infos[i].Healthy {
+			downUntil := inst.health.downUntil
+			infos[i].DownUntil = &downUntil
+		}
+		inst.health.mu.Unlock()
+	}
+	return infos, nil
+}
+
+// replicaList returns the connection's replicas.
+func (conn *Connection) replicaList() []*Connection {
+	conn.replicaMu.RLock()
+	defer conn.replicaMu.RUnlock()
+	return conn.replicas
+}
+
+// closeReplicas closes the connection's replicas.
+func (conn *Connection) closeReplicas() {
+	conn.replicaMu.Lock()
+	defer conn.replicaMu.Unlock()
+	for _, replica := range conn.replicas {
+		replica.closeDB()
+	}
+	conn.replicas = nil
+}
+
+// instance returns the instance to run the query on. Read queries on
+// connections with replicas are balanced across the instances in rotation,
+// chosen at random weighted by the inverse of their recent latency. Other
+// statements run on the connection itself.
+func (conn *Connection) instance(query string) *Connection {
+	replicas := conn.replicaList()
+	if len(replicas) == 0 {
+		return conn
+	}
+	if _, category := policy.Classify(query); category != policy.CategoryRead {
+		return conn
+	}
+
+	now := time.Now()
+	instances := append([]*Connection{conn}, replicas...)
+	var healthy []*Connection
+	var latencies []time.Duration
+	var fastest time.Duration
+	for _, inst := range instances {
+		up, latency := inst.health.up(now)
+		if !up {
+			continue
+		}
+		healthy, latencies = append(healthy, inst), append(latencies, latency)
+		if latency != 0 && (fastest == 0 || latency < fastest) {
+			fastest = latency
+		}
+	}
+	if len(healthy) == 0 {
+		// every instance is down, so try any of them
+		return instances[rand.IntN(len(instances))]
+	}
+
+	// instances without queries yet are weighted as the fastest, so they
+	// are tried
+	if fastest == 0 {
+		fastest = time.Millisecond
+	}
+	weights := make([]float64, len(healthy))
+	total := 0.0
+	for i, latency := range latencies {
+		if latency == 0 {
+			latency = fastest
+		}
+		weights[i] = 1 / latency.Seconds()
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r -= w; r < 0 {
+			return healthy[i]
+		}
+	}
+	return healthy[len(healthy)-1]
+}
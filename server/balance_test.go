@@ -0,0 +1,46 @@
+package server
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestInstance(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	conn := &Connection{ID: "db", URL: u}
+	fast, slow, down := &Connection{ID: "db", URL: u}, &Connection{ID: "db", URL: u}, &Connection{ID: "db", URL: u}
+	conn.replicas = []*Connection{fast, slow, down}
+	conn.health.latency = 10 * time.Millisecond
+	fast.health.latency = time.Millisecond
+	slow.health.latency = 100 * time.Millisecond
+	down.health.observe(time.Now(), fmt.Errorf("query failed: %w", driver.ErrBadConn))
+
+	counts := make(map[*Connection]int)
+	for range 1000 {
+		counts[conn.instance("SELECT 1")]++
+	}
+	switch {
+	case counts[down] != 0:
+		t.Errorf("expected no queries on the instance that is down, got: %d", counts[down])
+	case counts[fast] <= counts[conn] || counts[conn] <= counts[slow]:
+		t.Errorf("expected queries weighted by latency, got: fast %d, primary %d, slow %d", counts[fast], counts[conn], counts[slow])
+	}
+
+	if inst := conn.instance("UPDATE t SET a = 1"); inst != conn {
+		t.Errorf("expected statements to run on the primary")
+	}
+
+	down.health.downUntil = time.Time{}
+	if up, _ := down.health.up(time.Now()); !up {
+		t.Errorf("expected instance to be back in rotation")
+	}
+	down.health.observe(time.Now(), errors.New("syntax error"))
+	if up, _ := down.health.up(time.Now()); !up {
+		t.Errorf("expected query errors to keep the instance in rotation")
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// ResultCache caches the results of read-only queries for a TTL, keyed by
+// connection, normalized SQL, arguments and row limits, so repeated
+// identical queries are not run against the database again. The least
+// recently used results are evicted beyond the cache's size bounds, and a
+// connection's results are invalidated when a statement other than a read
+// is executed on it. A nil cache caches nothing.
+type ResultCache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, most recently used first
+	lru    *list.List
+	size   int64
+	hits   int64
+	misses int64
+}
+
+// cacheEntry is a cached result.
+type cacheEntry struct {
+	key          string
+	connectionID string
+	result       *QueryResult
+	size         int64
+	expires      time.Time
+}
+
+// CacheStats describes the contents and effectiveness of the result cache.
+type CacheStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// NewResultCache creates a new result cache, or returns nil when the
+// configured TTL is not set.
+func NewResultCache(config CacheConfig) *ResultCache {
+	if config.TTL <= 0 {
+		return nil
+	}
+	return &ResultCache{
+		ttl:        config.TTL,
+		maxEntries: config.MaxEntries,
+		maxBytes:   config.MaxBytes,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// key returns the cache key of a query's result, and whether the result can
+// be cached: the cache is enabled, and the query only reads. Results are
+// cached per principal, as their masking depends on it.
+func (c *ResultCache) key(connectionID, principal, query string, args []interface{}, maxRows int, limits ResultLimits) (string, bool) {
+	if c == nil || !readOnly(query) {
+		return "", false
+	}
+	buf, err := json.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	for _, s := range []string{connectionID, principal, normalizeSQL(query), string(buf)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	limitsBuf, _ := json.Marshal([]interface{}{maxRows, limits})
+	h.Write(limitsBuf)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Get returns a copy of the cached result, marked as cached, or nil.
+func (c *ResultCache) Get(key string) *QueryResult {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		c.misses++
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	result := *entry.result
+	result.Cached = true
+	return &result
+}
+
+// Put caches the result of a query on the connection. Results larger than
+// the cache's bytes bound are not cached.
+func (c *ResultCache) Put(connectionID, key string, result *QueryResult) {
+	if c == nil {
+		return
+	}
+	size := resultSize(result)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:          key,
+		connectionID: connectionID,
+		result:       result,
+		size:         size,
+		expires:      time.Now().Add(c.ttl),
+	})
+	c.size += size
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Invalidate removes the cached results of the connection, or all cached
+// results when connectionID is empty, returning the number removed.
+func (c *ResultCache) Invalidate(connectionID string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if connectionID == "" || elem.Value.(*cacheEntry).connectionID == connectionID {
+			c.remove(elem)
+			n++
+		}
+		elem = next
+	}
+	return n
+}
+
+// Stats returns the cache's statistics.
+func (c *ResultCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries: c.lru.Len(),
+		Bytes:   c.size,
+		Hits:    c.hits,
+		Misses:  c.misses,
+	}
+}
+
+// remove removes the entry. The lock must be held.
+func (c *ResultCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// executed invalidates the connection's cached results once the query was
+// executed, when it is not read-only, and its prepared statements when it
+// changes the schema.
+func (conn *Connection) executed(query string) {
+	if conn.cache == nil && conn.stmts == nil {
+		return
+	}
+	stmts := sqlscan.Split(query)
+	for _, stmt := range stmts {
+		switch _, category := policy.Classify(stmt); category {
+		case policy.CategoryRead:
+			continue
+		case policy.CategoryDDL:
+			conn.stmts.Reset()
+		}
+		conn.cache.Invalidate(conn.ID)
+	}
+}
+
+// readOnly returns whether every statement of the query is a read.
+func readOnly(query string) bool {
+	stmts := sqlscan.Split(query)
+	for _, stmt := range stmts {
+		if _, category := policy.Classify(stmt); category != policy.CategoryRead {
+			return false
+		}
+	}
+	return len(stmts) != 0
+}
+
+// normalizeSQL returns the query without comments, and with runs of
+// whitespace collapsed, so queries differing only in formatting share
+// cached results.
+func normalizeSQL(query string) string {
+	var b strings.Builder
+	space := false
+	for _, t := range sqlscan.Scan(query) {
+		switch t.Kind {
+		case sqlscan.Space, sqlscan.Comment:
+			space = b.Len() != 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
+
+// resultSize returns the approximate size of the rows of the result and its
+// further result sets.
+func resultSize(result *QueryResult) int64 {
+	var n int64
+	for _, row := range result.Rows {
+		n += rowSize(row)
+	}
+	for _, set := range result.MoreResultSets {
+		n += resultSize(set)
+	}
+	return n
+}
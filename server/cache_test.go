@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestCacheKey(t *testing.T) {
+	c := NewResultCache(CacheConfig{TTL: time.Minute})
+	key, ok := c.key("db", "", "SELECT a\n  FROM t -- all rows\n WHERE b = ?", []interface{}{int64(1)}, 0, ResultLimits{})
+	if !ok {
+		t.Fatalf("expected the query to be cacheable")
+	}
+	tests := []struct {
+		connectionID, query string
+		args                []interface{}
+		maxRows             int
+		same, cacheable     bool
+	}{
+		{"db", "SELECT a FROM t /* x */ WHERE b = ?", []interface{}{int64(1)}, 0, true, true},
+		{"db", "SELECT a FROM t WHERE b = ?", []interface{}{int64(2)}, 0, false, true},
+		{"db", "select a from t where b = ?", []interface{}{int64(1)}, 0, false, true},
+		{"other", "SELECT a FROM t WHERE b = ?", []interface{}{int64(1)}, 0, false, true},
+		{"db", "SELECT a FROM t WHERE b = ?", []interface{}{int64(1)}, 10, false, true},
+		{"db", "SELECT 'a  b'", nil, 0, false, true},
+		{"db", "SELECT a FROM t; DELETE FROM t", nil, 0, false, false},
+		{"db", "UPDATE t SET a = 1", nil, 0, false, false},
+	}
+	for i, test := range tests {
+		k, ok := c.key(test.connectionID, "", test.query, test.args, test.maxRows, ResultLimits{})
+		if ok != test.cacheable {
+			t.Errorf("test %d expected cacheable %t, got: %t", i, test.cacheable, ok)
+		}
+		if (k == key) != test.same {
+			t.Errorf("test %d expected same key %t", i, test.same)
+		}
+	}
+	if k, _ := c.key("db", "bob", "SELECT a FROM t WHERE b = ?", []interface{}{int64(1)}, 0, ResultLimits{}); k == key {
+		t.Errorf("expected results to be cached per principal")
+	}
+	if _, ok := (*ResultCache)(nil).key("db", "", "SELECT 1", nil, 0, ResultLimits{}); ok {
+		t.Errorf("expected a nil cache to cache nothing")
+	}
+	if s := normalizeSQL("SELECT 'a  b'  -- c"); s != "SELECT 'a  b'" {
+		t.Errorf("expected string literals to be kept, got: %q", s)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := NewResultCache(CacheConfig{TTL: time.Minute, MaxEntries: 2, MaxBytes: 20})
+	result := func(s string) *QueryResult {
+		return &QueryResult{Columns: []string{"a"}, Rows: [][]interface{}{{s}}}
+	}
+	c.Put("db", "1", result("a"))
+	c.Put("db", "2", result("b"))
+	c.Get("1")
+	c.Put("other", "3", result("c"))
+	switch {
+	case c.Get("2") != nil:
+		t.Errorf("expected the least recently used result to be evicted")
+	case c.Get("1") == nil || c.Get("3") == nil:
+		t.Errorf("expected the recently used results to be cached")
+	}
+	c.Put("db", "4", result("this is longer than the bytes bound"))
+	if c.Get("4") != nil {
+		t.Errorf("expected a result over the bytes bound not to be cached")
+	}
+	if n := c.Invalidate("other"); n != 1 || c.Get("3") != nil || c.Get("1") == nil {
+		t.Errorf("expected only the connection's result to be invalidated, got: %d", n)
+	}
+	if n := c.Invalidate(""); n != 1 || c.Stats().Entries != 0 || c.Stats().Bytes != 0 {
+		t.Errorf("expected all results to be invalidated, got: %d %+v", n, c.Stats())
+	}
+
+	c = NewResultCache(CacheConfig{TTL: time.Millisecond})
+	c.Put("db", "1", result("a"))
+	time.Sleep(5 * time.Millisecond)
+	if c.Get("1") != nil {
+		t.Errorf("expected the result to expire")
+	}
+}
+
+func TestCachedQuery(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), cache: NewResultCache(CacheConfig{TTL: time.Minute})}
+	defer conn.DB.Close()
+
+	ctx := context.Background()
+	for i, exp := range []bool{false, true} {
+		result, err := conn.ExecuteQuery(ctx, "SELECT a")
+		switch {
+		case err != nil:
+			t.Fatalf("query %d expected no error, got: %v", i, err)
+		case result.Cached != exp:
+			t.Errorf("query %d expected cached %t, got: %t", i, exp, result.Cached)
+		case len(result.Rows) != 2 || len(result.MoreResultSets) != 1:
+			t.Errorf("query %d expected the full result, got: %v", i, result)
+		}
+	}
+
+	if _, err := conn.ExecuteQuery(ctx, "UPDATE t SET a = 1; SELECT a"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if stats := conn.cache.Stats(); stats.Entries != 0 || stats.Hits != 1 {
+		t.Errorf("expected the write to invalidate the cached result, got: %+v", stats)
+	}
+}
+
+func TestCachedQuota(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	quota := NewQuotaGuard(QuotaConfig{QuotaLimits: QuotaLimits{QueriesPerDay: 2}})
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), cache: NewResultCache(CacheConfig{TTL: time.Minute}), quota: quota}
+	defer conn.DB.Close()
+
+	// cached results count against the quotas as the query would
+	ctx := policy.WithPrincipal(context.Background(), "alice")
+	var rows int64
+	for i, exp := range []bool{false, true} {
+		result, err := conn.ExecuteQuery(ctx, "SELECT a")
+		if err != nil || result.Cached != exp {
+			t.Fatalf("query %d expected cached %t, got: %v %v", i, exp, result, err)
+		}
+		rows += resultRows(result)
+	}
+	if usage := quota.Usage(context.Background()); len(usage) != 1 || usage[0].QueriesToday != 2 || usage[0].RowsToday != rows {
+		t.Errorf("expected 2 queries and their %d rows counted, got: %+v", rows, usage)
+	}
+	var qerr *QuotaError
+	if _, err := conn.ExecuteQuery(ctx, "SELECT a"); !errors.As(err, &qerr) || qerr.Quota != QuotaQueriesPerDay {
+		t.Errorf("expected the queries per day quota to be exceeded, got: %v", err)
+	}
+}
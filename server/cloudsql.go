@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"github.com/xo/dburl"
+)
+
+// cloudsqlAPI is the Cloud SQL Admin API endpoint.
+var cloudsqlAPI = "https://sqladmin.googleapis.com/sql/v1beta4"
+
+// cloudsqlPort is the port Cloud SQL instances accept connector connections
+// on.
+const cloudsqlPort = 3307
+
+// cloudsqlRefreshBefore is how long before the client certificate of a
+// Cloud SQL instance expires that it is refreshed.
+const cloudsqlRefreshBefore = 4 * time.Minute
+
+// cloudsqlParams are the DSN parameters of Cloud SQL connections, not passed
+// to the driver.
+var cloudsqlParams = []string{"cloudsql_instance", "cloudsql_ip_type", "cloudsql_iam"}
+
+// cloudsqlIPTypes are the IP address types of the cloudsql_ip_type
+// parameter, by their type in the Cloud SQL Admin API.
+var cloudsqlIPTypes = map[string]string{
+	"public":  "PRIMARY",
+	"private": "PRIVATE",
+	"psc":     "PSC",
+}
+
+// cloudsqlTLSParams are the DSN parameters disabling the driver's own TLS,
+// as connections are encrypted by the connector.
+var cloudsqlTLSParams = map[string][2]string{
+	"postgres":  {"sslmode", "disable"},
+	"pgx":       {"sslmode", "disable"},
+	"sqlserver": {"encrypt", "disable"},
+}
+
+// cloudsqlDialer dials Cloud SQL instances by their instance connection
+// name, with an ephemeral client certificate issued by the Cloud SQL Admin
+// API, refreshed before it expires. With IAM database authentication, the
+// certificate carries the OAuth2 token the database user logs in with.
+type cloudsqlDialer struct {
+	project  string
+	region   string
+	instance string
+	ipType   string
+	port     int
+	client   *http.Client
+	netDial  netDialFunc
+	// admin authorizes Cloud SQL Admin API requests
+	admin auth.TokenProvider
+	// login is the token provider of IAM database authentication, if used
+	login auth.TokenProvider
+
+	mu      sync.Mutex
+	key     *rsa.PrivateKey
+	addr    string
+	tls     *tls.Config
+	expires time.Time
+}
+
+// cloudsqlSettings are the connect settings of a Cloud SQL instance.
+type cloudsqlSettings struct {
+	ServerCACert struct {
+		Cert string `json:"cert"`
+	} `json:"serverCaCert"`
+	IPAddresses []struct {
+		Type      string `json:"type"`
+		IPAddress string `json:"ipAddress"`
+	} `json:"ipAddresses"`
+	DNSName string `json:"dnsName"`
+	Region  string `json:"region"`
+}
+
+// parseCloudSQL returns the DSN without its Cloud SQL parameters, and the
+// dialer of the Cloud SQL instance of its cloudsql_instance parameter, or a
+// nil dialer when the DSN has none. The instance, and the Cloud SQL Admin
+// API, are dialed with netDial.
+func parseCloudSQL(ctx context.Context, u *dburl.URL, netDial netDialFunc) (*dburl.URL, dialer, error) {
+	query := u.Query()
+	name := query.Get("cloudsql_instance")
+	if name == "" {
+		return u, nil, nil
+	}
+	d, err := newCloudSQLDialer(ctx, name, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cloud sql: %w", err)
+	}
+	d.netDial = netDial
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy, transport.DialContext = nil, netDial
+	d.client.Transport = transport
+	for _, param := range cloudsqlParams {
+		query.Del(param)
+	}
+	if p, ok := cloudsqlTLSParams[u.Driver]; ok && !query.Has(p[0]) {
+		query.Set(p[0], p[1])
+	}
+	v := u.URL
+	v.RawQuery = query.Encode()
+	if u, err = dburl.Parse(v.String()); err != nil {
+		return nil, nil, err
+	}
+	return u, d, nil
+}
+
+// newCloudSQLDialer creates a dialer of the Cloud SQL instance with the
+// instance connection name (project:region:instance), authenticating with
+// the Application Default Credentials of the server's environment.
+func newCloudSQLDialer(ctx context.Context, name string, query url.Values) (*cloudsqlDialer, error) {
+	parts := strings.Split(name, ":")
+	// projects of Google Workspace domains are prefixed by their domain
+	if len(parts) == 4 {
+		parts = []string{parts[0] + ":" + parts[1], parts[2], parts[3]}
+	}
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid instance connection name %q: must be project:region:instance", name)
+	}
+	ipType := "public"
+	if s := query.Get("cloudsql_ip_type"); s != "" {
+		ipType = s
+	}
+	if _, ok := cloudsqlIPTypes[ipType]; !ok {
+		return nil, fmt.Errorf("invalid cloudsql_ip_type %q: must be public, private or psc", ipType)
+	}
+	iam := false
+	if s := query.Get("cloudsql_iam"); s != "" {
+		var err error
+		if iam, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("invalid cloudsql_iam %q", s)
+		}
+	}
+
+	d := &cloudsqlDialer{
+		project:  parts[0],
+		region:   parts[1],
+		instance: parts[2],
+		ipType:   ipType,
+		port:     cloudsqlPort,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+		Scopes: []string{"https://www.googleapis.com/auth/sqlservice.admin"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Google credentials: %w", err)
+	}
+	d.admin = creds
+	if iam {
+		login, err := credentials.DetectDefault(&credentials.DetectOptions{
+			Scopes: []string{"https://www.googleapis.com/auth/sqlservice.login"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Google credentials: %w", err)
+		}
+		d.login = login
+	}
+	return d, nil
+}
+
+// dial dials a TLS connection to the instance.
+func (d *cloudsqlDialer) dial(ctx context.Context) (net.Conn, error) {
+	addr, config, err := d.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.netDial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(c, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cloud sql: TLS handshake with %s failed: %w", d.name(), err)
+	}
+	return conn, nil
+}
+
+// close satisfies the dialer interface. The client certificate is kept for
+// later tunnels.
+func (d *cloudsqlDialer) close() {}
+
+// config returns the address and TLS config of the instance, refreshing
+// them when the client certificate is about to expire.
+func (d *cloudsqlDialer) config(ctx context.Context) (string, *tls.Config, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tls != nil && time.Until(d.expires) > cloudsqlRefreshBefore {
+		return d.addr, d.tls, nil
+	}
+	if err := d.refresh(ctx); err != nil {
+		return "", nil, fmt.Errorf("cloud sql: failed to refresh %s: %w", d.name(), err)
+	}
+	return d.addr, d.tls, nil
+}
+
+// refresh fetches the connect settings of the instance, and a new client
+// certificate. The lock must be held.
+func (d *cloudsqlDialer) refresh(ctx context.Context) error {
+	if d.key == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		d.key = key
+	}
+
+	var settings cloudsqlSettings
+	if err := d.call(ctx, http.MethodGet, "connectSettings", nil, &settings); err != nil {
+		return err
+	}
+	if settings.Region != "" && settings.Region != d.region {
+		return fmt.Errorf("instance is in region %s, not %s", settings.Region, d.region)
+	}
+	addr := ""
+	for _, ip := range settings.IPAddresses {
+		if ip.Type == cloudsqlIPTypes[d.ipType] {
+			addr = ip.IPAddress
+			break
+		}
+	}
+	if d.ipType == "psc" {
+		addr = strings.TrimSuffix(settings.DNSName, ".")
+	}
+	if addr == "" {
+		return fmt.Errorf("instance has no %s IP address", d.ipType)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(settings.ServerCACert.Cert)) {
+		return errors.New("instance has no server CA certificate")
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&d.key.PublicKey)
+	if err != nil {
+		return err
+	}
+	req := map[string]string{
+		"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+	}
+	if d.login != nil {
+		token, err := d.login.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get IAM login token: %w", err)
+		}
+		req["access_token"] = token.Value
+	}
+	var res struct {
+		EphemeralCert struct {
+			Cert string `json:"cert"`
+		} `json:"ephemeralCert"`
+	}
+	if err := d.call(ctx, http.MethodPost, ":generateEphemeralCert", req, &res); err != nil {
+		return err
+	}
+	block, _ := pem.Decode([]byte(res.EphemeralCert.Cert))
+	if block == nil {
+		return errors.New("invalid client certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+
+	d.addr = net.JoinHostPort(addr, strconv.Itoa(d.port))
+	d.expires = cert.NotAfter
+	d.tls = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  d.key,
+			Leaf:        cert,
+		}},
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+		// the server certificate names the instance rather than its
+		// address, so it is verified by verify
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: d.verify(roots, settings.DNSName),
+	}
+	return nil
+}
+
+// verify returns a func verifying that the server certificate is issued by
+// the instance's CA, and names the instance.
+func (d *cloudsqlDialer) verify(roots *x509.CertPool, dnsName string) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if err := verifyChain(raw, roots); err != nil {
+			return err
+		}
+		cert, err := x509.ParseCertificate(raw[0])
+		if err != nil {
+			return err
+		}
+		// instances with a DNS name have it in their certificate, while
+		// others name the instance as project:instance
+		if dnsName != "" && cert.VerifyHostname(strings.TrimSuffix(dnsName, ".")) == nil {
+			return nil
+		}
+		if cn := d.project + ":" + d.instance; cert.Subject.CommonName != cn {
+			return fmt.Errorf("server certificate is for %q, not %q", cert.Subject.CommonName, cn)
+		}
+		return nil
+	}
+}
+
+// call calls a method of the instance in the Cloud SQL Admin API, decoding
+// the response into v.
+func (d *cloudsqlDialer) call(ctx context.Context, method, path string, body, v interface{}) error {
+	token, err := d.admin.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Google access token: %w", err)
+	}
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(path, ":") {
+		path = "/" + path
+	}
+	endpoint := fmt.Sprintf("%s/projects/%s/instances/%s%s", cloudsqlAPI, url.PathEscape(d.project), url.PathEscape(d.instance), path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Value)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("%s: %s", res.Status, e.Error.Message)
+		}
+		return errors.New(res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// name returns the instance connection name of the instance.
+func (d *cloudsqlDialer) name() string {
+	return d.project + ":" + d.region + ":" + d.instance
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/auth"
+)
+
+func TestCloudSQLDialer(t *testing.T) {
+	ca, caKey := testCert(t, nil, nil, "ca", nil)
+	serverCert, serverKey := testCert(t, ca, caKey, "proj:inst", nil)
+
+	// the instance echoes what it reads from clients with a certificate
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	var certs atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/connectSettings"):
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"serverCaCert": map[string]string{"cert": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))},
+				"ipAddresses":  []map[string]string{{"type": "PRIMARY", "ipAddress": "127.0.0.1"}},
+				"region":       "us-central1",
+			})
+		case strings.HasSuffix(r.URL.Path, ":generateEphemeralCert"):
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["access_token"] != "login" {
+				t.Errorf("expected the IAM login token, got: %q", req["access_token"])
+			}
+			block, _ := pem.Decode([]byte(req["public_key"]))
+			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				t.Errorf("expected a public key, got: %v", err)
+				return
+			}
+			certs.Add(1)
+			cert, _ := testCert(t, ca, caKey, "client", pub.(*rsa.PublicKey))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ephemeralCert": map[string]string{"cert": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+	defer func(endpoint string) { cloudsqlAPI = endpoint }(cloudsqlAPI)
+	cloudsqlAPI = api.URL
+
+	d := &cloudsqlDialer{
+		project:  "proj",
+		region:   "us-central1",
+		instance: "inst",
+		ipType:   "public",
+		port:     ln.Addr().(*net.TCPAddr).Port,
+		client:   api.Client(),
+		netDial:  directDial,
+		admin:    testToken("admin"),
+		login:    testToken("login"),
+	}
+	tun, err := startTunnel(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", tun.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 4)
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("expected the instance to echo ping, got: %q %v", buf, err)
+		}
+		c.Close()
+	}
+	if n := certs.Load(); n != 1 {
+		t.Errorf("expected the client certificate to be reused, got %d certificates", n)
+	}
+
+	// the server certificate must name the instance
+	d.tls, d.instance = nil, "other"
+	if _, err := d.dial(context.Background()); err == nil {
+		t.Errorf("expected an error dialing another instance")
+	}
+	d.tls, d.instance, d.region = nil, "inst", "europe-west1"
+	if _, err := d.dial(context.Background()); err == nil {
+		t.Errorf("expected an error for the wrong region")
+	}
+
+	for _, test := range []struct {
+		name  string
+		query url.Values
+	}{
+		{"proj:inst", nil},
+		{"proj:region:inst", url.Values{"cloudsql_ip_type": {"internal"}}},
+		{"proj:region:inst", url.Values{"cloudsql_iam": {"maybe"}}},
+	} {
+		if _, err := newCloudSQLDialer(context.Background(), test.name, test.query); err == nil {
+			t.Errorf("expected an error for %s %v", test.name, test.query)
+		}
+	}
+}
+
+// testToken is a token provider of a static token.
+type testToken string
+
+func (s testToken) Token(context.Context) (*auth.Token, error) {
+	return &auth.Token{Value: string(s), Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+// testCert creates a certificate with the common name, signed by the
+// parent, or self-signed as a CA when the parent is nil, for the public key
+// or a new key returned with it.
+func testCert(t *testing.T, parent *x509.Certificate, parentKey *rsa.PrivateKey, cn string, pub *rsa.PublicKey) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	var key *rsa.PrivateKey
+	if pub == nil {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		pub = &key.PublicKey
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
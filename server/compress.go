@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// incompressibleTypes are the content types of responses that are already
+// compressed.
+var incompressibleTypes = []string{
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.apache.parquet",
+	"application/zip",
+	"application/gzip",
+}
+
+// compressMiddleware compresses responses with gzip or deflate, as
+// negotiated with the client's Accept-Encoding header. Responses smaller
+// than the configured minimum size, and of already compressed content types,
+// are not compressed. Flushing a response (e.g. streamed rows) flushes the
+// compressed data written so far.
+func (s *Server) compressMiddleware(next http.Handler) http.Handler {
+	config := s.config().Server.Compression
+	level := config.Level
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil || level == 0 {
+		if level != 0 {
+			serverLog.Warn("invalid compression level, using the default", "level", level)
+		}
+		level = gzip.DefaultCompression
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(io.Discard, level)
+			return w
+		}},
+		"deflate": {New: func() interface{} {
+			w, _ := flate.NewWriter(io.Discard, level)
+			return w
+		}},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			pool:           pools[encoding],
+			minSize:        config.MinSize,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(cw, r)
+		// not deferred, so aborted responses are not completed
+		if err := cw.Close(); err != nil {
+			serverLog.Error("response compression error", "error", err)
+		}
+	})
+}
+
+// negotiateEncoding returns the preferred of the gzip and deflate content
+// codings accepted in an Accept-Encoding header, or an empty string.
+func negotiateEncoding(header string) string {
+	var best string
+	var bestQ float64
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case q <= 0:
+			continue
+		case name == "*":
+			name = "gzip"
+		case name != "gzip" && name != "deflate":
+			continue
+		}
+		// gzip is preferred at equal quality
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter is a response writer compressing the response once it
+// exceeds the minimum size, or is flushed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	minSize  int
+	status   int
+
+	buf         bytes.Buffer
+	wroteHeader bool
+	decided     bool
+	w           io.Writer
+}
+
+// WriteHeader satisfies the http.ResponseWriter interface, deferring the
+// header until whether to compress the response is decided.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	// informational responses are written immediately
+	if status < http.StatusOK {
+		cw.wroteHeader = false
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write satisfies the io.Writer interface.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.wroteHeader = true
+	if cw.decided {
+		return cw.w.Write(p)
+	}
+	cw.buf.Write(p)
+	if cw.buf.Len() >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide writes the header, compressing the rest of the response when
+// compress is true and the response can be compressed, and the buffered
+// response.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	// sniffed from the uncompressed response, as net/http would
+	if _, ok := h["Content-Type"]; !ok && cw.buf.Len() != 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+	if compress && cw.compressible() {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch zw := cw.pool.Get().(type) {
+		case *gzip.Writer:
+			zw.Reset(cw.ResponseWriter)
+			cw.w = zw
+		case *flate.Writer:
+			zw.Reset(cw.ResponseWriter)
+			cw.w = zw
+		}
+	} else {
+		cw.w = cw.ResponseWriter
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	_, err := cw.w.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// compressible returns whether the response can be compressed.
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	typ := h.Get("Content-Type")
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(typ, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush satisfies the http.Flusher interface, compressing a flushed response
+// regardless of its size.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close writes the rest of the response, returning the compressor to its
+// pool.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			return nil
+		}
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	zw, ok := cw.w.(io.WriteCloser)
+	if !ok {
+		return nil
+	}
+	err := zw.Close()
+	cw.pool.Put(zw)
+	return err
+}
+
+// Unwrap returns the underlying response writer, for
+// http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
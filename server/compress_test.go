@@ -0,0 +1,137 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		exp    string
+	}{
+		{"", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP;q=0.8, br;q=1.0", "gzip"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"identity, deflate;q=bad", ""},
+	}
+	for i, test := range tests {
+		if s := negotiateEncoding(test.header); s != test.exp {
+			t.Errorf("test %d: expected %q for %q, got: %q", i, test.exp, test.header, s)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	s := &Server{}
+	s.conf.Store(&Config{Server: ServerConfig{Compression: CompressionConfig{Enabled: true, MinSize: 100}}})
+	large := strings.Repeat(`{"id":1,"name":"row"}`+"\n", 100)
+	tests := []struct {
+		encoding string
+		typ      string
+		body     string
+		exp      string
+	}{
+		{"gzip", "application/json", large, "gzip"},
+		{"deflate", "application/json", large, "deflate"},
+		{"", "application/json", large, ""},
+		{"gzip", "application/json", `{"id":1}`, ""},
+		{"gzip", "application/vnd.apache.parquet", large, ""},
+	}
+	for i, test := range tests {
+		h := s.compressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.typ)
+			w.WriteHeader(http.StatusCreated)
+			for _, line := range strings.SplitAfter(test.body, "\n") {
+				io.WriteString(w, line)
+			}
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+		r.Header.Set("Accept-Encoding", test.encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Errorf("test %d: expected status %d, got: %d", i, http.StatusCreated, w.Code)
+		}
+		if s := w.Header().Get("Vary"); s != "Accept-Encoding" {
+			t.Errorf("test %d: expected Vary: Accept-Encoding, got: %q", i, s)
+		}
+		if s := w.Header().Get("Content-Encoding"); s != test.exp {
+			t.Fatalf("test %d: expected Content-Encoding %q, got: %q", i, test.exp, s)
+		}
+		if body := decompress(t, test.exp, w.Body); body != test.body {
+			t.Errorf("test %d: expected body %q, got: %q", i, test.body, body)
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	s := &Server{}
+	s.conf.Store(&Config{Server: ServerConfig{Compression: CompressionConfig{Enabled: true, MinSize: 1024}}})
+	flushed := make(chan string)
+	h := s.compressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, "{\"row\":1}\n")
+		w.(http.Flusher).Flush()
+		<-flushed
+		io.WriteString(w, "{\"row\":2}\n")
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer res.Body.Close()
+	if s := res.Header.Get("Content-Encoding"); s != "gzip" {
+		t.Fatalf("expected a flushed response to be compressed, got: %q", s)
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the first row is readable before the handler writes the second
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(zr, buf); err != nil || string(buf) != "{\"row\":1}\n" {
+		t.Fatalf("expected first row, got: %q (%v)", buf, err)
+	}
+	close(flushed)
+	rest, err := io.ReadAll(zr)
+	if err != nil || string(rest) != "{\"row\":2}\n" {
+		t.Errorf("expected second row, got: %q (%v)", rest, err)
+	}
+}
+
+// decompress returns the body decompressed with the content coding.
+func decompress(t *testing.T, encoding string, r io.Reader) string {
+	t.Helper()
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		r = zr
+	case "deflate":
+		r = flate.NewReader(r)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return string(b)
+}
@@ -1,24 +1,407 @@
 package server
 
-import "time"
+import (
+	"time"
+
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/logging"
+	"github.com/xo/usql/server/logstore"
+)
 
 // Config represents the server configuration.
 type Config struct {
 	Server ServerConfig `mapstructure:"server" yaml:"server" json:"server"`
 	Auth   AuthConfig   `mapstructure:"auth" yaml:"auth" json:"auth"`
+	Faults FaultConfig  `mapstructure:"faults" yaml:"faults" json:"faults"`
+	Jobs   JobConfig    `mapstructure:"jobs" yaml:"jobs" json:"jobs"`
+	Policy PolicyConfig `mapstructure:"policy" yaml:"policy" json:"policy"`
+	Cost   CostConfig   `mapstructure:"cost" yaml:"cost" json:"cost"`
+	Quotas QuotaConfig  `mapstructure:"quotas" yaml:"quotas" json:"quotas"`
+	Hooks  HooksConfig  `mapstructure:"hooks" yaml:"hooks" json:"hooks"`
+	Redis  RedisConfig  `mapstructure:"redis" yaml:"redis" json:"redis"`
+	Cache  CacheConfig  `mapstructure:"cache" yaml:"cache" json:"cache"`
+
+	Storage logstore.Config `mapstructure:"storage" yaml:"storage" json:"storage"`
+	Audit   audit.Config    `mapstructure:"audit" yaml:"audit" json:"audit"`
+	History HistoryConfig   `mapstructure:"history" yaml:"history" json:"history"`
+	Metrics MetricsConfig   `mapstructure:"metrics" yaml:"metrics" json:"metrics"`
+	Debug   DebugConfig     `mapstructure:"debug" yaml:"debug" json:"debug"`
+	Logging logging.Config  `mapstructure:"logging" yaml:"logging" json:"logging"`
+
+	Persistence PersistenceConfig `mapstructure:"persistence" yaml:"persistence" json:"persistence"`
+	Secrets     SecretsConfig     `mapstructure:"secrets" yaml:"secrets" json:"secrets"`
+	Proxy       ProxyConfig       `mapstructure:"proxy" yaml:"proxy" json:"proxy"`
+
+	Queries []SavedQuery `mapstructure:"queries" yaml:"queries" json:"queries"`
+
+	Connections []ConnectionConfig `mapstructure:"connections" yaml:"connections" json:"connections"`
+
+	Groups []GroupConfig `mapstructure:"groups" yaml:"groups" json:"groups"`
+
+	Roles []RoleConfig `mapstructure:"roles" yaml:"roles" json:"roles"`
+
+	Deprecations []Deprecation `mapstructure:"deprecations" yaml:"deprecations" json:"deprecations"`
 }
 
 // ServerConfig contains server-specific configuration.
 type ServerConfig struct {
-	MaxConnections int           `mapstructure:"max_connections" yaml:"max_connections" json:"max_connections"`
-	RequestTimeout time.Duration `mapstructure:"request_timeout" yaml:"request_timeout" json:"request_timeout"`
-	EnableMCP      bool          `mapstructure:"enable_mcp" yaml:"enable_mcp" json:"enable_mcp"`
-	EnableCORS     bool          `mapstructure:"enable_cors" yaml:"enable_cors" json:"enable_cors"`
+	MaxConnections     int           `mapstructure:"max_connections" yaml:"max_connections" json:"max_connections"`
+	RequestTimeout     time.Duration `mapstructure:"request_timeout" yaml:"request_timeout" json:"request_timeout"`
+	EnableMCP          bool          `mapstructure:"enable_mcp" yaml:"enable_mcp" json:"enable_mcp"`
+	EnableCORS         bool          `mapstructure:"enable_cors" yaml:"enable_cors" json:"enable_cors"`
+	EnableAdmin        bool          `mapstructure:"enable_admin" yaml:"enable_admin" json:"enable_admin"`
+	CursorTTL          time.Duration `mapstructure:"cursor_ttl" yaml:"cursor_ttl" json:"cursor_ttl"`
+	MaxCursors         int           `mapstructure:"max_cursors" yaml:"max_cursors" json:"max_cursors"`
+	SessionIdleTimeout time.Duration `mapstructure:"session_idle_timeout" yaml:"session_idle_timeout" json:"session_idle_timeout"`
+	MaxSessions        int           `mapstructure:"max_sessions" yaml:"max_sessions" json:"max_sessions"`
+	TransactionTimeout time.Duration `mapstructure:"transaction_timeout" yaml:"transaction_timeout" json:"transaction_timeout"`
+	ReapInterval       time.Duration `mapstructure:"reap_interval" yaml:"reap_interval" json:"reap_interval"`
+	DrainTimeout       time.Duration `mapstructure:"drain_timeout" yaml:"drain_timeout" json:"drain_timeout"`
+	MaxRows            int           `mapstructure:"max_rows" yaml:"max_rows" json:"max_rows"`
+	ExportDir          string        `mapstructure:"export_dir" yaml:"export_dir" json:"export_dir"`
+
+	ConnectionIdleTimeout time.Duration `mapstructure:"connection_idle_timeout" yaml:"connection_idle_timeout" json:"connection_idle_timeout"`
+	ConnectionMaxLifetime time.Duration `mapstructure:"connection_max_lifetime" yaml:"connection_max_lifetime" json:"connection_max_lifetime"`
+	PoolLimitPolicy       string        `mapstructure:"pool_limit_policy" yaml:"pool_limit_policy" json:"pool_limit_policy"`
+
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval" yaml:"health_check_interval" json:"health_check_interval"`
+	ReconnectMaxBackoff time.Duration `mapstructure:"reconnect_max_backoff" yaml:"reconnect_max_backoff" json:"reconnect_max_backoff"`
+	FailoverAfter       int           `mapstructure:"failover_after" yaml:"failover_after" json:"failover_after"`
+
+	SlowQueryThreshold   time.Duration `mapstructure:"slow_query_threshold" yaml:"slow_query_threshold" json:"slow_query_threshold"`
+	QueryStatsWindow     time.Duration `mapstructure:"query_stats_window" yaml:"query_stats_window" json:"query_stats_window"`
+	MaxQueryFingerprints int           `mapstructure:"max_query_fingerprints" yaml:"max_query_fingerprints" json:"max_query_fingerprints"`
+
+	MaxResultRows  int   `mapstructure:"max_result_rows" yaml:"max_result_rows" json:"max_result_rows"`
+	MaxResultBytes int64 `mapstructure:"max_result_bytes" yaml:"max_result_bytes" json:"max_result_bytes"`
+
+	SpillThreshold int64  `mapstructure:"spill_threshold" yaml:"spill_threshold" json:"spill_threshold"`
+	SpillMaxBytes  int64  `mapstructure:"spill_max_bytes" yaml:"spill_max_bytes" json:"spill_max_bytes"`
+	SpillDir       string `mapstructure:"spill_dir" yaml:"spill_dir" json:"spill_dir"`
+
+	TimeFormat string `mapstructure:"time_format" yaml:"time_format" json:"time_format"`
+	TimeZone   string `mapstructure:"time_zone" yaml:"time_zone" json:"time_zone"`
+
+	Nulls        string `mapstructure:"nulls" yaml:"nulls" json:"nulls"`
+	NullSentinel string `mapstructure:"null_sentinel" yaml:"null_sentinel" json:"null_sentinel"`
+
+	MaxPreparedStatements int `mapstructure:"max_prepared_statements" yaml:"max_prepared_statements" json:"max_prepared_statements"`
+
+	PropagateTimeouts bool              `mapstructure:"propagate_timeouts" yaml:"propagate_timeouts" json:"propagate_timeouts"`
+	Compression       CompressionConfig `mapstructure:"compression" yaml:"compression" json:"compression"`
+
+	MaxConcurrentQueries int            `mapstructure:"max_concurrent_queries" yaml:"max_concurrent_queries" json:"max_concurrent_queries"`
+	MaxConcurrentPerHost int            `mapstructure:"max_concurrent_per_host" yaml:"max_concurrent_per_host" json:"max_concurrent_per_host"`
+	HostLimits           map[string]int `mapstructure:"host_limits" yaml:"host_limits" json:"host_limits"`
+
+	TLS ServerTLS `mapstructure:"tls" yaml:"tls" json:"tls"`
+
+	Listeners []ListenerConfig `mapstructure:"listeners" yaml:"listeners" json:"listeners"`
+
+	AllowedCIDRs   []string `mapstructure:"allowed_cidrs" yaml:"allowed_cidrs" json:"allowed_cidrs"`
+	DeniedCIDRs    []string `mapstructure:"denied_cidrs" yaml:"denied_cidrs" json:"denied_cidrs"`
+	TrustedProxies []string `mapstructure:"trusted_proxies" yaml:"trusted_proxies" json:"trusted_proxies"`
 }
 
-// AuthConfig contains authentication configuration.
+// Pool limit policies, applied when a connection is created while the pool
+// is full.
+const (
+	// PoolLimitStrict refuses to create the connection.
+	PoolLimitStrict = "strict"
+	// PoolLimitEvictLRU closes the least recently used idle connection to
+	// make room.
+	PoolLimitEvictLRU = "evict_lru"
+)
+
+// CompressionConfig contains response compression configuration. A level
+// of 0 uses the default compression level.
+type CompressionConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	Level   int  `mapstructure:"level" yaml:"level" json:"level"`
+	MinSize int  `mapstructure:"min_size" yaml:"min_size" json:"min_size"`
+}
+
+// AuthConfig contains authentication configuration. API keys are kept in
+// KeysFile, which is required when they are enabled.
 type AuthConfig struct {
-	EnableOAuth bool   `mapstructure:"enable_oauth" yaml:"enable_oauth" json:"enable_oauth"`
+	EnableOAuth  bool   `mapstructure:"enable_oauth" yaml:"enable_oauth" json:"enable_oauth"`
 	EnableAPIKey bool   `mapstructure:"enable_api_key" yaml:"enable_api_key" json:"enable_api_key"`
 	APIKeyHeader string `mapstructure:"api_key_header" yaml:"api_key_header" json:"api_key_header"`
-}
\ No newline at end of file
+	KeysFile     string `mapstructure:"keys_file" yaml:"keys_file" json:"keys_file"`
+
+	// TenantAttribute is the principal attribute naming the tenant the
+	// principal's connections are isolated in, if any.
+	TenantAttribute string `mapstructure:"tenant_attribute" yaml:"tenant_attribute" json:"tenant_attribute"`
+
+	EnableJWT bool      `mapstructure:"enable_jwt" yaml:"enable_jwt" json:"enable_jwt"`
+	JWT       JWTConfig `mapstructure:"jwt" yaml:"jwt" json:"jwt"`
+
+	OAuth OAuthConfig `mapstructure:"oauth" yaml:"oauth" json:"oauth"`
+}
+
+// OAuthConfig contains the OAuth 2.0 authorization configuration of the MCP
+// endpoint, enabled by EnableOAuth. Access tokens are issued by the OIDC
+// Issuer, for Audience when set, and are verified with the keys it
+// publishes. ResourceURL is the public URL of the MCP endpoint (by default
+// that of the requests), and Scopes are the scopes advertised to clients.
+// With EnableRegistration, dynamic client registration is passed through to
+// RegistrationURL (by default the issuer's registration endpoint),
+// authenticated with the initial access token in the RegistrationTokenEnv
+// environment variable, if any.
+type OAuthConfig struct {
+	Issuer         string   `mapstructure:"issuer" yaml:"issuer" json:"issuer"`
+	Audience       string   `mapstructure:"audience" yaml:"audience" json:"audience"`
+	ResourceURL    string   `mapstructure:"resource_url" yaml:"resource_url" json:"resource_url"`
+	Scopes         []string `mapstructure:"scopes" yaml:"scopes" json:"scopes"`
+	PrincipalClaim string   `mapstructure:"principal_claim" yaml:"principal_claim" json:"principal_claim"`
+	ScopesClaim    string   `mapstructure:"scopes_claim" yaml:"scopes_claim" json:"scopes_claim"`
+
+	EnableRegistration   bool   `mapstructure:"enable_registration" yaml:"enable_registration" json:"enable_registration"`
+	RegistrationURL      string `mapstructure:"registration_url" yaml:"registration_url" json:"registration_url"`
+	RegistrationTokenEnv string `mapstructure:"registration_token_env" yaml:"registration_token_env" json:"registration_token_env"`
+}
+
+// JWTConfig contains JWT bearer token authentication configuration. Tokens
+// are signed with HS256 by the secret in the SecretEnv environment variable,
+// or with RS256 by the PEM encoded public key in PublicKeyFile or the keys
+// at JWKSURL, and must be issued by Issuer for Audience, when set. Their
+// PrincipalClaim names the principal, and their ScopesClaim holds its
+// scopes.
+type JWTConfig struct {
+	SecretEnv      string `mapstructure:"secret_env" yaml:"secret_env" json:"secret_env"`
+	PublicKeyFile  string `mapstructure:"public_key_file" yaml:"public_key_file" json:"public_key_file"`
+	JWKSURL        string `mapstructure:"jwks_url" yaml:"jwks_url" json:"jwks_url"`
+	Issuer         string `mapstructure:"issuer" yaml:"issuer" json:"issuer"`
+	Audience       string `mapstructure:"audience" yaml:"audience" json:"audience"`
+	PrincipalClaim string `mapstructure:"principal_claim" yaml:"principal_claim" json:"principal_claim"`
+	ScopesClaim    string `mapstructure:"scopes_claim" yaml:"scopes_claim" json:"scopes_claim"`
+}
+
+// FaultConfig contains fault injection configuration, used to simulate an
+// unreliable server when testing clients in staging environments.
+type FaultConfig struct {
+	Enabled     bool          `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	TimeoutRate float64       `mapstructure:"timeout_rate" yaml:"timeout_rate" json:"timeout_rate"`
+	DropRate    float64       `mapstructure:"drop_rate" yaml:"drop_rate" json:"drop_rate"`
+	SlowRate    float64       `mapstructure:"slow_rate" yaml:"slow_rate" json:"slow_rate"`
+	SlowDelay   time.Duration `mapstructure:"slow_delay" yaml:"slow_delay" json:"slow_delay"`
+}
+
+// JobConfig contains asynchronous query job configuration.
+type JobConfig struct {
+	Workers       int           `mapstructure:"workers" yaml:"workers" json:"workers"`
+	MaxQueued     int           `mapstructure:"max_queued" yaml:"max_queued" json:"max_queued"`
+	Timeout       time.Duration `mapstructure:"timeout" yaml:"timeout" json:"timeout"`
+	ResultTTL     time.Duration `mapstructure:"result_ttl" yaml:"result_ttl" json:"result_ttl"`
+	MaxResultRows int           `mapstructure:"max_result_rows" yaml:"max_result_rows" json:"max_result_rows"`
+}
+
+// PolicyConfig contains statement policy configuration.
+type PolicyConfig struct {
+	Dir     string `mapstructure:"dir" yaml:"dir" json:"dir"`
+	Default string `mapstructure:"default" yaml:"default" json:"default"`
+}
+
+// HooksConfig contains hook script configuration. MaxSteps limits the
+// execution steps of each hook call (0 for the default).
+type HooksConfig struct {
+	Dir      string `mapstructure:"dir" yaml:"dir" json:"dir"`
+	MaxSteps uint64 `mapstructure:"max_steps" yaml:"max_steps" json:"max_steps"`
+}
+
+// RedisConfig is the Redis server shared by the servers of a cluster, a
+// redis or rediss URL. Limits are counted in it, under keys with the Prefix,
+// so that they apply across the cluster rather than to each server. While
+// Redis is unreachable, queries are not limited by it, unless FailClosed, when
+// they fail instead.
+type RedisConfig struct {
+	URL        string `mapstructure:"url" yaml:"url" json:"url"`
+	Prefix     string `mapstructure:"prefix" yaml:"prefix" json:"prefix"`
+	FailClosed bool   `mapstructure:"fail_closed" yaml:"fail_closed" json:"fail_closed"`
+}
+
+// CacheConfig contains query result cache configuration. Results are only
+// cached when TTL is set, and the cache is bounded by MaxEntries results and
+// MaxBytes of row values (0 for no bound).
+type CacheConfig struct {
+	TTL        time.Duration `mapstructure:"ttl" yaml:"ttl" json:"ttl"`
+	MaxEntries int           `mapstructure:"max_entries" yaml:"max_entries" json:"max_entries"`
+	MaxBytes   int64         `mapstructure:"max_bytes" yaml:"max_bytes" json:"max_bytes"`
+}
+
+// HistoryConfig contains the configuration of the query history, recording
+// the queries executed in the history record store when enabled, with their
+// string and number literals replaced with ? when RedactSQL is set.
+type HistoryConfig struct {
+	Enabled   bool `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	RedactSQL bool `mapstructure:"redact_sql" yaml:"redact_sql" json:"redact_sql"`
+}
+
+// MetricsConfig contains the configuration of the metrics, served at Path
+// in the Prometheus text exposition format when enabled.
+type MetricsConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	Path    string `mapstructure:"path" yaml:"path" json:"path"`
+}
+
+// PersistenceConfig contains the configuration of the file persisting
+// dynamically created connections across restarts. Connections are only
+// persisted when File is set, encrypted with the passphrase in the
+// environment variable PassphraseEnv.
+type PersistenceConfig struct {
+	File          string `mapstructure:"file" yaml:"file" json:"file"`
+	PassphraseEnv string `mapstructure:"passphrase_env" yaml:"passphrase_env" json:"passphrase_env"`
+}
+
+// SecretsConfig contains the configuration of secret references in DSNs.
+// References in the DSNs of predefined connections are always resolved,
+// while those in DSNs given by clients are only resolved when their kind
+// (env, file, exec or vault) is in ClientRefs.
+type SecretsConfig struct {
+	ClientRefs  []string      `mapstructure:"client_refs" yaml:"client_refs" json:"client_refs"`
+	ExecTimeout time.Duration `mapstructure:"exec_timeout" yaml:"exec_timeout" json:"exec_timeout"`
+	Vault       VaultConfig   `mapstructure:"vault" yaml:"vault" json:"vault"`
+}
+
+// VaultConfig contains the configuration of HashiCorp Vault, from which
+// vault secret references are read when Address is set. The token is read
+// from the environment variable TokenEnv, and CACert is the PEM file of the
+// CA certificates verifying Vault's certificate (the system's when empty).
+type VaultConfig struct {
+	Address   string `mapstructure:"address" yaml:"address" json:"address"`
+	TokenEnv  string `mapstructure:"token_env" yaml:"token_env" json:"token_env"`
+	Namespace string `mapstructure:"namespace" yaml:"namespace" json:"namespace"`
+	CACert    string `mapstructure:"ca_cert" yaml:"ca_cert" json:"ca_cert"`
+}
+
+// ConnectionConfig is a connection predefined in the config, created when
+// the server starts. The DSN is read from the environment variable DSNEnv
+// when set, keeping credentials out of the config file. Failover are the
+// DSNs the connection fails over to, in order, when its database fails.
+// SlowQueryThreshold overrides the server's slow query threshold.
+type ConnectionConfig struct {
+	ID       string            `mapstructure:"id" yaml:"id" json:"id"`
+	DSN      string            `mapstructure:"dsn" yaml:"dsn" json:"dsn"`
+	DSNEnv   string            `mapstructure:"dsn_env" yaml:"dsn_env" json:"dsn_env"`
+	Lazy     bool              `mapstructure:"lazy" yaml:"lazy" json:"lazy"`
+	Replicas []string          `mapstructure:"replicas" yaml:"replicas" json:"replicas"`
+	Aliases  []string          `mapstructure:"aliases" yaml:"aliases" json:"aliases"`
+	Tags     map[string]string `mapstructure:"tags" yaml:"tags" json:"tags"`
+	Pool     PoolSettings      `mapstructure:"pool" yaml:"pool" json:"pool"`
+	SSH      *SSHConfig        `mapstructure:"ssh" yaml:"ssh" json:"ssh,omitempty"`
+	Proxy    string            `mapstructure:"proxy" yaml:"proxy" json:"proxy,omitempty"`
+	TLS      *DatabaseTLS      `mapstructure:"tls" yaml:"tls" json:"tls,omitempty"`
+	Failover []string          `mapstructure:"failover" yaml:"failover" json:"failover,omitempty"`
+
+	SlowQueryThreshold time.Duration `mapstructure:"slow_query_threshold" yaml:"slow_query_threshold" json:"slow_query_threshold,omitempty"`
+}
+
+// DatabaseTLS are the TLS settings of a connection's database connections:
+// the mode (disable, require, verify-ca, or verify-full by default), the CA
+// certificates verifying the server, the client certificate and key, and
+// the name verified in the server's certificate, when other than its host.
+// CA, Cert and Key are PEM, secret references resolving to PEM (such as
+// ${file:/etc/ssl/ca.pem} or ${vault:pki/issue/db#certificate}), or, in the
+// config, paths to PEM files.
+type DatabaseTLS struct {
+	Mode       string `mapstructure:"mode" yaml:"mode" json:"mode,omitempty"`
+	CA         string `mapstructure:"ca" yaml:"ca" json:"ca,omitempty"`
+	Cert       string `mapstructure:"cert" yaml:"cert" json:"cert,omitempty"`
+	Key        string `mapstructure:"key" yaml:"key" json:"key,omitempty"`
+	ServerName string `mapstructure:"server_name" yaml:"server_name" json:"server_name,omitempty"`
+}
+
+// ProxyConfig is the proxy database connections are dialed through, a
+// socks5, socks5h, http or https URL. Hosts in NoProxy (names, .domain
+// suffixes, CIDR ranges, or * for all) are dialed directly. Predefined
+// connections may set their own proxy, or "direct" to dial directly.
+type ProxyConfig struct {
+	URL     string   `mapstructure:"url" yaml:"url" json:"url"`
+	NoProxy []string `mapstructure:"no_proxy" yaml:"no_proxy" json:"no_proxy"`
+}
+
+// GroupConfig is a connection group, a logical connection whose queries are
+// routed to one of its member connections (such as read replicas, each its
+// own connection), chosen round-robin or as the member running the fewest
+// queries.
+type GroupConfig struct {
+	ID      string   `mapstructure:"id" yaml:"id" json:"id"`
+	Members []string `mapstructure:"members" yaml:"members" json:"members"`
+	Routing string   `mapstructure:"routing" yaml:"routing" json:"routing,omitempty"`
+}
+
+// SSHConfig is an SSH jump host a connection's database is reached
+// through. Users authenticate with the private key in KeyFile, decrypted
+// with the passphrase in the environment variable PassphraseEnv, or with
+// the SSH agent at $SSH_AUTH_SOCK. The host's key is HostKey (in
+// authorized_keys format), or otherwise in the KnownHosts file
+// (~/.ssh/known_hosts by default).
+type SSHConfig struct {
+	Host          string `mapstructure:"host" yaml:"host" json:"host"`
+	User          string `mapstructure:"user" yaml:"user" json:"user"`
+	KeyFile       string `mapstructure:"key_file" yaml:"key_file" json:"key_file,omitempty"`
+	PassphraseEnv string `mapstructure:"passphrase_env" yaml:"passphrase_env" json:"passphrase_env,omitempty"`
+	Agent         bool   `mapstructure:"agent" yaml:"agent" json:"agent,omitempty"`
+	KnownHosts    string `mapstructure:"known_hosts" yaml:"known_hosts" json:"known_hosts,omitempty"`
+	HostKey       string `mapstructure:"host_key" yaml:"host_key" json:"host_key,omitempty"`
+}
+
+// PoolSettings are the settings of a connection's pool of database
+// connections. Unset (0) settings keep database/sql's defaults.
+type PoolSettings struct {
+	MaxOpen     int           `mapstructure:"max_open" yaml:"max_open" json:"max_open,omitempty"`
+	MaxIdle     int           `mapstructure:"max_idle" yaml:"max_idle" json:"max_idle,omitempty"`
+	MaxLifetime time.Duration `mapstructure:"max_lifetime" yaml:"max_lifetime" json:"max_lifetime,omitempty"`
+	MaxIdleTime time.Duration `mapstructure:"max_idle_time" yaml:"max_idle_time" json:"max_idle_time,omitempty"`
+}
+
+// SavedQuery is an operator defined query, exposed as an MCP tool named
+// after the query. The query's parameters are passed to the SQL as arguments
+// in the order they are declared.
+type SavedQuery struct {
+	Name        string       `mapstructure:"name" yaml:"name" json:"name"`
+	Description string       `mapstructure:"description" yaml:"description" json:"description"`
+	Connection  string       `mapstructure:"connection" yaml:"connection" json:"connection"`
+	SQL         string       `mapstructure:"sql" yaml:"sql" json:"sql"`
+	Statement   bool         `mapstructure:"statement" yaml:"statement" json:"statement"`
+	Params      []QueryParam `mapstructure:"params" yaml:"params" json:"params"`
+}
+
+// QueryParam is a saved query parameter. Type is a JSON schema type (string,
+// number, integer or boolean).
+type QueryParam struct {
+	Name        string      `mapstructure:"name" yaml:"name" json:"name"`
+	Type        string      `mapstructure:"type" yaml:"type" json:"type"`
+	Description string      `mapstructure:"description" yaml:"description" json:"description"`
+	Required    bool        `mapstructure:"required" yaml:"required" json:"required"`
+	Default     interface{} `mapstructure:"default" yaml:"default" json:"default"`
+}
+
+// Deprecation marks a REST endpoint, by its route pattern (such as
+// "POST /v1/connections/{id}/export"), or an MCP tool as deprecated. Dates
+// are either dates (2006-01-02) or RFC 3339 times, and the endpoint or tool
+// is removed from its sunset date.
+type Deprecation struct {
+	Endpoint   string `mapstructure:"endpoint" yaml:"endpoint" json:"endpoint"`
+	Tool       string `mapstructure:"tool" yaml:"tool" json:"tool"`
+	Deprecated string `mapstructure:"deprecated" yaml:"deprecated" json:"deprecated"`
+	Sunset     string `mapstructure:"sunset" yaml:"sunset" json:"sunset"`
+	Link       string `mapstructure:"link" yaml:"link" json:"link"`
+	Message    string `mapstructure:"message" yaml:"message" json:"message"`
+}
+
+// QuotaConfig contains the usage quotas of authenticated principals, with
+// overrides of the default limits per principal and per tenant, whose
+// principals share their tenant's quotas.
+type QuotaConfig struct {
+	QuotaLimits `mapstructure:",squash" yaml:",inline"`
+	Principals  map[string]QuotaLimits `mapstructure:"principals" yaml:"principals" json:"principals"`
+	Tenants     map[string]QuotaLimits `mapstructure:"tenants" yaml:"tenants" json:"tenants"`
+}
+
+// CostConfig contains bytes scanned budgets for connections to analytics
+// engines, with per connection overrides of the default limits.
+type CostConfig struct {
+	CostLimits  `mapstructure:",squash" yaml:",inline"`
+	Connections map[string]CostLimits `mapstructure:"connections" yaml:"connections" json:"connections"`
+}
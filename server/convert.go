@@ -0,0 +1,311 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+// JSON types of column values, recorded in results' JSONTypes. A column's
+// JSON type is empty when its type is not reported by the driver, and its
+// values are passed through as scanned.
+const (
+	jsonInteger  = "integer"
+	jsonNumber   = "number"
+	jsonBoolean  = "boolean"
+	jsonDateTime = "date-time"
+	jsonJSON     = "json"
+	jsonString   = "string"
+	jsonBinary   = "base64"
+)
+
+// timeLayouts are the accepted formats of timestamps scanned as strings,
+// parsed as UTC when without a time zone.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// jsonTypes returns the JSON types of the columns.
+func jsonTypes(columnTypes []*sql.ColumnType) []string {
+	types := make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		types[i] = jsonType(ct.DatabaseTypeName(), ct.ScanType())
+	}
+	return types
+}
+
+// jsonType returns the JSON type of the values of a column of the database
+// type, falling back to the driver's scan type.
+func jsonType(dbType string, scanType reflect.Type) string {
+	typ := strings.ToUpper(dbType)
+	switch {
+	case strings.Contains(typ, "INTERVAL"), typ == "MONEY":
+		return jsonString
+	case strings.Contains(typ, "JSON"):
+		return jsonJSON
+	case strings.Contains(typ, "BLOB"), strings.Contains(typ, "BINARY") && !strings.Contains(typ, "FLOAT") && !strings.Contains(typ, "DOUBLE"),
+		typ == "BYTEA", typ == "IMAGE", typ == "RAW", typ == "LONG RAW":
+		return jsonBinary
+	case typ == "BOOL" || typ == "BOOLEAN":
+		return jsonBoolean
+	case strings.Contains(typ, "INT") && !strings.Contains(typ, "POINT"), strings.Contains(typ, "SERIAL"):
+		return jsonInteger
+	case strings.Contains(typ, "FLOAT"), strings.Contains(typ, "DOUBLE"), typ == "REAL",
+		strings.Contains(typ, "DECIMAL"), strings.Contains(typ, "NUMERIC"), typ == "NUMBER":
+		return jsonNumber
+	case typ == "DATE", strings.Contains(typ, "TIMESTAMP"), strings.Contains(typ, "DATETIME"):
+		return jsonDateTime
+	case typ != "":
+		if t := jsonScanType(scanType); t != "" {
+			return t
+		}
+		return jsonString
+	}
+	return jsonScanType(scanType)
+}
+
+// jsonScanType returns the JSON type of values scanned as the type, or an
+// empty string when the driver scans values of any type.
+func jsonScanType(scanType reflect.Type) string {
+	if scanType == nil {
+		return ""
+	}
+	if scanType.Kind() == reflect.Pointer {
+		scanType = scanType.Elem()
+	}
+	switch scanType {
+	case reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}), reflect.TypeOf(sql.NullInt16{}), reflect.TypeOf(sql.NullByte{}):
+		return jsonInteger
+	case reflect.TypeOf(sql.NullFloat64{}):
+		return jsonNumber
+	case reflect.TypeOf(sql.NullBool{}):
+		return jsonBoolean
+	case reflect.TypeOf(sql.NullTime{}), reflect.TypeOf(time.Time{}):
+		return jsonDateTime
+	case reflect.TypeOf(sql.NullString{}):
+		return jsonString
+	}
+	switch scanType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonInteger
+	case reflect.Float32, reflect.Float64:
+		return jsonNumber
+	case reflect.Bool:
+		return jsonBoolean
+	case reflect.String:
+		return jsonString
+	}
+	return ""
+}
+
+// driverConverter converts values of types without a JSON representation
+// using the conversion funcs of a connection's usql driver, rendering them as
+// usql does (e.g. SQLite timestamps stored as text, or Cassandra collections).
+type driverConverter struct {
+	bytes  func([]byte, string) (string, error)
+	maps   func(map[string]interface{}) (string, error)
+	slices func([]interface{}) (string, error)
+	other  func(interface{}) (string, error)
+}
+
+// newDriverConverter returns a converter using the conversion funcs of the
+// URL's driver.
+func newDriverConverter(u *dburl.URL) *driverConverter {
+	return &driverConverter{
+		bytes:  drivers.ConvertBytes(u),
+		maps:   drivers.ConvertMap(u),
+		slices: drivers.ConvertSlice(u),
+		other:  drivers.ConvertDefault(u),
+	}
+}
+
+// convert converts a value scanned from a column of the JSON type. Values of
+// Go types with a JSON representation, and maps and slices of JSON columns,
+// are passed through, as is binary data, while other []byte values are
+// converted with timestamps formatted as RFC 3339, fmt.Stringer values to their string, and other
+// values with the driver's funcs. A nil converter passes values through.
+func (dc *driverConverter) convert(typ string, v interface{}) (interface{}, error) {
+	if dc == nil {
+		return v, nil
+	}
+	switch x := v.(type) {
+	case nil, string, bool, time.Time, json.Number,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v, nil
+	case []byte:
+		if x == nil {
+			return nil, nil
+		}
+		if isBinary(typ, x) {
+			return v, nil
+		}
+		return dc.bytes(x, time.RFC3339Nano)
+	case fmt.Stringer:
+		return x.String(), nil
+	case map[string]interface{}:
+		if typ == jsonJSON {
+			return v, nil
+		}
+		return dc.maps(x)
+	case []interface{}:
+		if typ == jsonJSON {
+			return v, nil
+		}
+		return dc.slices(x)
+	}
+	return dc.other(v)
+}
+
+// convertColumn converts a value scanned from a column of the JSON type to
+// its JSON representation: integers to int64 (or json.Number when out of
+// range), other numbers to float64 (or json.Number for exact numerics
+// scanned as strings), booleans to bool, and timestamps to time.Time.
+// Values of binary columns are kept as []byte (base64 encoded in JSON), as
+// are values of columns without a type that are not UTF-8 text. Values that
+// cannot be converted are passed through, with other []byte values
+// converted to strings.
+func convertColumn(typ string, v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		if typ == jsonBinary {
+			return []byte(x)
+		}
+	case []byte:
+		switch {
+		case x == nil:
+			// NULL, distinct from an empty string
+			return nil
+		case isBinary(typ, x):
+			return v
+		}
+		v = string(x)
+	}
+	switch typ {
+	case jsonInteger:
+		return asInteger(v)
+	case jsonNumber:
+		return asNumber(v)
+	case jsonBoolean:
+		return asBoolean(v)
+	case jsonDateTime:
+		return asDateTime(v)
+	}
+	return v
+}
+
+// asInteger converts v to an int64.
+func asInteger(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case int8:
+		return int64(x)
+	case int16:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case uint:
+		return asInteger(uint64(x))
+	case uint8:
+		return int64(x)
+	case uint16:
+		return int64(x)
+	case uint32:
+		return int64(x)
+	case uint64:
+		if x > math.MaxInt64 {
+			return json.Number(strconv.FormatUint(x, 10))
+		}
+		return int64(x)
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return int64(x)
+		}
+	case string:
+		if n, err := strconv.ParseInt(x, 10, 64); err == nil {
+			return n
+		}
+		if isJSONNumber(x) {
+			return json.Number(x)
+		}
+	}
+	return v
+}
+
+// asNumber converts v to a float64, or json.Number when scanned as a string.
+func asNumber(v interface{}) interface{} {
+	switch x := v.(type) {
+	case float32:
+		return float64(x)
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			// not representable in JSON
+			return strconv.FormatFloat(x, 'g', -1, 64)
+		}
+	case string:
+		if isJSONNumber(x) {
+			return json.Number(x)
+		}
+	default:
+		return asInteger(v)
+	}
+	return v
+}
+
+// asBoolean converts v to a bool.
+func asBoolean(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int64:
+		if x == 0 || x == 1 {
+			return x == 1
+		}
+	case string:
+		switch strings.ToLower(x) {
+		case "t", "true", "1", "y", "yes", "on":
+			return true
+		case "f", "false", "0", "n", "no", "off":
+			return false
+		}
+	}
+	return v
+}
+
+// asDateTime converts v to a time.Time.
+func asDateTime(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t
+			}
+		}
+	}
+	return v
+}
+
+// isBinary returns whether a []byte value of a column of the JSON type is
+// binary data.
+func isBinary(typ string, b []byte) bool {
+	return typ == jsonBinary || (typ == "" && !utf8.Valid(b))
+}
+
+// isJSONNumber returns whether s is a valid JSON number.
+func isJSONNumber(s string) bool {
+	if s == "" || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+func TestJSONType(t *testing.T) {
+	tests := []struct {
+		dbType   string
+		scanType reflect.Type
+		exp      string
+	}{
+		{"INT4", nil, jsonInteger},
+		{"BIGSERIAL", nil, jsonInteger},
+		{"NUMERIC", nil, jsonNumber},
+		{"DECIMAL(10,2)", nil, jsonNumber},
+		{"DOUBLE PRECISION", nil, jsonNumber},
+		{"BOOL", nil, jsonBoolean},
+		{"TIMESTAMPTZ", nil, jsonDateTime},
+		{"DATE", nil, jsonDateTime},
+		{"JSONB", nil, jsonJSON},
+		{"INTERVAL", nil, jsonString},
+		{"POINT", nil, jsonString},
+		{"MONEY", nil, jsonString},
+		{"BYTEA", nil, jsonBinary},
+		{"VARBINARY", nil, jsonBinary},
+		{"LONGBLOB", nil, jsonBinary},
+		{"BINARY_DOUBLE", nil, jsonNumber},
+		{"VARCHAR", reflect.TypeOf(sql.NullString{}), jsonString},
+		{"UNKNOWN", reflect.TypeOf(sql.NullInt64{}), jsonInteger},
+		{"", reflect.TypeOf(float32(0)), jsonNumber},
+		{"", reflect.TypeOf(new(time.Time)), jsonDateTime},
+		{"", reflect.TypeOf((*interface{})(nil)).Elem(), ""},
+		{"", nil, ""},
+	}
+	for i, test := range tests {
+		if typ := jsonType(test.dbType, test.scanType); typ != test.exp {
+			t.Errorf("test %d: expected %q for %s, got: %q", i, test.exp, test.dbType, typ)
+		}
+	}
+}
+
+func TestConvertColumn(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		typ string
+		v   interface{}
+		exp interface{}
+	}{
+		{jsonInteger, []byte("42"), int64(42)},
+		{jsonInteger, int32(-7), int64(-7)},
+		{jsonInteger, uint64(1 << 63), json.Number("9223372036854775808")},
+		{jsonInteger, "99999999999999999999", json.Number("99999999999999999999")},
+		{jsonInteger, float64(3), int64(3)},
+		{jsonInteger, "x", "x"},
+		{jsonNumber, []byte("1.50"), json.Number("1.50")},
+		{jsonNumber, "NaN", "NaN"},
+		{jsonNumber, float32(0.5), float64(0.5)},
+		{jsonNumber, int64(2), int64(2)},
+		{jsonBoolean, int64(1), true},
+		{jsonBoolean, []byte("f"), false},
+		{jsonBoolean, int64(2), int64(2)},
+		{jsonDateTime, []byte("2024-01-02 03:04:05"), ts},
+		{jsonDateTime, "2024-01-02T03:04:05Z", ts},
+		{jsonDateTime, ts, ts},
+		{jsonDateTime, "infinity", "infinity"},
+		{jsonJSON, []byte(`{"a":1}`), `{"a":1}`},
+		{jsonString, []byte("abc"), "abc"},
+		{jsonString, []byte{}, ""},
+		{jsonString, []byte(nil), nil},
+		{jsonBinary, []byte(nil), nil},
+		{jsonBinary, []byte{0xff, 0x00}, []byte{0xff, 0x00}},
+		{jsonBinary, "abc", []byte("abc")},
+		{"", []byte{0xff, 0x00}, []byte{0xff, 0x00}},
+		{"", []byte("abc"), "abc"},
+		{"", int64(1), int64(1)},
+		{jsonInteger, nil, nil},
+	}
+	for i, test := range tests {
+		if v := convertColumn(test.typ, test.v); !reflect.DeepEqual(v, test.exp) {
+			t.Errorf("test %d: expected %#v, got: %#v", i, test.exp, v)
+		}
+	}
+}
+
+func TestDriverConverter(t *testing.T) {
+	drivers.Register("convert-test", drivers.Driver{
+		ConvertBytes: func(buf []byte, tfmt string) (string, error) {
+			if string(buf) == "now" {
+				return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(tfmt), nil
+			}
+			return strings.ToUpper(string(buf)), nil
+		},
+		ConvertDefault: func(v interface{}) (string, error) {
+			if _, ok := v.(complex128); ok {
+				return "", errors.New("unsupported")
+			}
+			return fmt.Sprintf("<%v>", v), nil
+		},
+	})
+	dc := newDriverConverter(&dburl.URL{Driver: "convert-test"})
+	tests := []struct {
+		typ string
+		v   interface{}
+		exp interface{}
+	}{
+		{jsonDateTime, []byte("now"), "2024-01-02T03:04:05Z"},
+		{jsonString, []byte("abc"), "ABC"},
+		{jsonBinary, []byte("abc"), []byte("abc")},
+		{"", []byte{0xff}, []byte{0xff}},
+		{jsonString, []byte(nil), nil},
+		{jsonInteger, int64(1), int64(1)},
+		{jsonString, net.IPv4(127, 0, 0, 1), "127.0.0.1"},
+		{jsonString, map[string]interface{}{"a": int64(1)}, `{"a":1}`},
+		{jsonJSON, map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(1)}},
+		{"", []interface{}{"a", "b"}, `["a","b"]`},
+		{"", struct{ A int }{1}, "<{1}>"},
+	}
+	for i, test := range tests {
+		v, err := dc.convert(test.typ, test.v)
+		switch {
+		case err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case !reflect.DeepEqual(v, test.exp):
+			t.Errorf("test %d: expected %#v, got: %#v", i, test.exp, v)
+		}
+	}
+	if _, err := dc.convert("", complex(1, 2)); err == nil {
+		t.Errorf("expected error converting unsupported value")
+	}
+	var nilConverter *driverConverter
+	if v, _ := nilConverter.convert("", struct{}{}); v != struct{}{} {
+		t.Errorf("expected a nil converter to pass values through, got: %#v", v)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// CostLimits are bytes scanned limits for queries. A limit of 0 means
+// unlimited.
+type CostLimits struct {
+	MaxQueryBytes int64 `mapstructure:"max_query_bytes" yaml:"max_query_bytes" json:"max_query_bytes"`
+	MaxDailyBytes int64 `mapstructure:"max_daily_bytes" yaml:"max_daily_bytes" json:"max_daily_bytes"`
+}
+
+// CostError is returned when a query's estimated bytes scanned exceeds a
+// connection's cost limits.
+type CostError struct {
+	ConnectionID string
+	Estimated    int64
+	Limit        int64
+	Daily        bool
+}
+
+// Error satisfies the error interface.
+func (e *CostError) Error() string {
+	if e.Daily {
+		return fmt.Sprintf("query would scan an estimated %d bytes, exceeding the remaining daily budget of connection %s (limit %d bytes)", e.Estimated, e.ConnectionID, e.Limit)
+	}
+	return fmt.Sprintf("query would scan an estimated %d bytes, exceeding the per-query limit of connection %s (%d bytes)", e.Estimated, e.ConnectionID, e.Limit)
+}
+
+// CostUsage is a connection's bytes scanned usage for the current day.
+type CostUsage struct {
+	Day          string     `json:"day"`
+	ScannedBytes int64      `json:"scanned_bytes"`
+	Limits       CostLimits `json:"limits"`
+}
+
+// costEstimators estimate the bytes a query will scan, by driver.
+var costEstimators = map[string]func(context.Context, *sql.DB, string, []interface{}) (int64, error){
+	"clickhouse": estimateClickHouse,
+}
+
+// CostGuard enforces per-query and per-day bytes scanned budgets on
+// connections to engines that can estimate the bytes a query will scan.
+// Usage is tracked from the estimates, per UTC day.
+type CostGuard struct {
+	config atomic.Pointer[CostConfig]
+
+	mu    sync.Mutex
+	usage map[string]*CostUsage
+}
+
+// NewCostGuard creates a new cost guard.
+func NewCostGuard(config CostConfig) *CostGuard {
+	g := &CostGuard{
+		usage: make(map[string]*CostUsage),
+	}
+	g.config.Store(&config)
+	return g
+}
+
+// SetConfig replaces the cost limits, applied from the next query on.
+func (g *CostGuard) SetConfig(config CostConfig) {
+	g.config.Store(&config)
+}
+
+// Limits returns the cost limits of the connection.
+func (g *CostGuard) Limits(connectionID string) CostLimits {
+	config := g.config.Load()
+	if limits, ok := config.Connections[connectionID]; ok {
+		return limits
+	}
+	return config.CostLimits
+}
+
+// Usage returns the connection's usage for the current day.
+func (g *CostGuard) Usage(connectionID string) CostUsage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return *g.today(connectionID)
+}
+
+// today returns the connection's usage for the current day, resetting it on
+// a new day. The lock must be held.
+func (g *CostGuard) today(connectionID string) *CostUsage {
+	day := time.Now().UTC().Format(time.DateOnly)
+	u, ok := g.usage[connectionID]
+	if !ok || u.Day != day {
+		u = &CostUsage{Day: day}
+		g.usage[connectionID] = u
+	}
+	u.Limits = g.Limits(connectionID)
+	return u
+}
+
+// Check estimates the bytes the query will scan, returning a *CostError if
+// it exceeds the connection's limits, and otherwise adding the estimate to
+// the connection's usage. Queries on engines without estimates, and
+// statements other than SELECT queries, are not limited.
+func (g *CostGuard) Check(ctx context.Context, conn *Connection, query string, args []interface{}) error {
+	if g == nil {
+		return nil
+	}
+	limits := g.Limits(conn.ID)
+	estimate := costEstimators[conn.URL.Driver]
+	if estimate == nil || (limits.MaxQueryBytes == 0 && limits.MaxDailyBytes == 0) {
+		return nil
+	}
+	if typ, _ := policy.Classify(query); typ != "SELECT" {
+		return nil
+	}
+
+	n, err := estimate(ctx, conn.DB, query, args)
+	if err != nil {
+		return fmt.Errorf("failed to estimate query cost: %w", err)
+	}
+	if limits.MaxQueryBytes > 0 && n > limits.MaxQueryBytes {
+		return &CostError{ConnectionID: conn.ID, Estimated: n, Limit: limits.MaxQueryBytes}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	u := g.today(conn.ID)
+	if limits.MaxDailyBytes > 0 && u.ScannedBytes+n > limits.MaxDailyBytes {
+		return &CostError{ConnectionID: conn.ID, Estimated: n, Limit: limits.MaxDailyBytes, Daily: true}
+	}
+	u.ScannedBytes += n
+	return nil
+}
+
+// estimateClickHouse estimates the bytes a ClickHouse query will scan, from
+// the rows EXPLAIN ESTIMATE reports will be read from each table and the
+// tables' average uncompressed row size.
+func estimateClickHouse(ctx context.Context, db *sql.DB, query string, args []interface{}) (int64, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN ESTIMATE "+query, args...)
+	if err != nil {
+		return 0, err
+	}
+	type tableRows struct {
+		database, table string
+		rows            uint64
+	}
+	var tables []tableRows
+	for rows.Next() {
+		var t tableRows
+		var parts, marks uint64
+		if err := rows.Scan(&t.database, &t.table, &parts, &t.rows, &marks); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, t := range tables {
+		var bytes, count uint64
+		err := db.QueryRowContext(ctx, "SELECT sum(data_uncompressed_bytes), sum(rows) FROM system.parts WHERE active AND database = ? AND table = ?", t.database, t.table).Scan(&bytes, &count)
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			total += int64(t.rows * (bytes / count))
+		}
+	}
+	return total, nil
+}
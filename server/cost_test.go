@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestCostGuard(t *testing.T) {
+	costEstimators["costtest"] = func(context.Context, *sql.DB, string, []interface{}) (int64, error) {
+		return 400, nil
+	}
+	defer delete(costEstimators, "costtest")
+
+	g := NewCostGuard(CostConfig{
+		CostLimits: CostLimits{MaxQueryBytes: 1000, MaxDailyBytes: 1000},
+		Connections: map[string]CostLimits{
+			"small": {MaxQueryBytes: 100},
+		},
+	})
+	conn := &Connection{ID: "db", URL: &dburl.URL{Driver: "costtest"}}
+	small := &Connection{ID: "small", URL: &dburl.URL{Driver: "costtest"}}
+	ctx := context.Background()
+
+	var cerr *CostError
+	if err := g.Check(ctx, small, "SELECT * FROM t", nil); !errors.As(err, &cerr) || cerr.Daily || cerr.Limit != 100 {
+		t.Errorf("expected per-query cost error with limit 100, got: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := g.Check(ctx, conn, "SELECT * FROM t", nil); err != nil {
+			t.Fatalf("query %d: expected no error, got: %v", i+1, err)
+		}
+	}
+	if err := g.Check(ctx, conn, "SELECT * FROM t", nil); !errors.As(err, &cerr) || !cerr.Daily {
+		t.Errorf("expected daily cost error, got: %v", err)
+	}
+	if err := g.Check(ctx, conn, "INSERT INTO t VALUES (1)", nil); err != nil {
+		t.Errorf("expected statements to not be limited, got: %v", err)
+	}
+	if u := g.Usage("db"); u.ScannedBytes != 800 {
+		t.Errorf("expected 800 scanned bytes, got: %d", u.ScannedBytes)
+	}
+
+	// connections to engines without estimates are not limited
+	other := &Connection{ID: "db", URL: &dburl.URL{Driver: "postgres"}}
+	if err := g.Check(ctx, other, "SELECT * FROM t", nil); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/policy"
+)
+
+// Cursor is an open result set held server-side, from which rows are fetched
+// in chunks.
+type Cursor struct {
+	ID           string
+	ConnectionID string
+	Columns      []string
+	ColumnTypes  []string
+	JSONTypes    []string
+	Provenance   *Provenance
+
+	conn    *Connection
+	convert *driverConverter
+	query   string
+	expires atomic.Int64
+	mu      sync.Mutex
+	rows    *sql.Rows
+	spill   *spillFile
+	cancel  context.CancelFunc
+	done    bool
+
+	// release releases the handle the rows are read on, once they are
+	// closed
+	release func()
+
+	// fetched is the number of rows fetched, audited once the cursor is
+	// closed
+	fetched atomic.Int64
+	audit   func(rows int64, err error)
+
+	// timer times the cursor until its first page was fetched, when it is
+	// checked against the slow query threshold
+	timer *queryTimer
+
+	// the rows are scanned as the scan types, and masked by the masks of
+	// their columns
+	scanTypes []string
+	masks     *policy.ColumnMasks
+}
+
+// CursorPage is a chunk of rows fetched from a cursor.
+type CursorPage struct {
+	CursorID  string          `json:"cursor_id"`
+	Rows      [][]interface{} `json:"rows"`
+	Done      bool            `json:"done"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	// PII is the PII found in the rows, when PII detection applies.
+	PII []policy.PIIFinding `json:"pii,omitempty"`
+}
+
+// CursorManager tracks open cursors, closed once they expire by the pool's
+// reaper.
+type CursorManager struct {
+	mu      sync.Mutex
+	cursors map[string]*Cursor
+	ttl     time.Duration
+	max     int
+}
+
+// NewCursorManager creates a new cursor manager, closing cursors that have
+// not been fetched from within ttl.
+func NewCursorManager(ttl time.Duration, max int) *CursorManager {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &CursorManager{
+		cursors: make(map[string]*Cursor),
+		ttl:     ttl,
+		max:     max,
+	}
+}
+
+// expire closes the cursors expired at now, returning their number.
+func (cm *CursorManager) expire(now time.Time) int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	n := 0
+	for id, cursor := range cm.cursors {
+		if now.After(cursor.ExpiresAt()) {
+			delete(cm.cursors, id)
+			cursor.close()
+			n++
+		}
+	}
+	return n
+}
+
+// Open executes query on the connection, and holds the resulting rows open
+// as a new cursor. The database stops executing the query at ctx's deadline,
+// as for other queries, and the connection it executed on is held until the
+// cursor is closed.
+func (cm *CursorManager) Open(ctx context.Context, conn *Connection, query string, args ...interface{}) (_ *Cursor, err error) {
+	conn = conn.instance(query)
+	defer conn.recoverPanic(query, &err)
+
+	cm.mu.Lock()
+	n := len(cm.cursors)
+	cm.mu.Unlock()
+	if cm.max > 0 && n >= cm.max {
+		return nil, fmt.Errorf("cursor limit reached (max: %d)", cm.max)
+	}
+
+	conn.touch()
+	// the cursor is audited once closed, with the rows fetched
+	started, auditQuery, auditArgs := time.Now(), query, args
+	audited := func(rows int64, err error) {
+		conn.recordQuery(ctx, audit.Cursor, auditQuery, auditArgs, started, rows, err)
+	}
+	timer := newQueryTimer(query)
+	defer func() {
+		if err != nil {
+			conn.checkSlow(ctx, timer, 0, err)
+			audited(0, err)
+		}
+	}()
+
+	query, hookRewrite, err := conn.preQuery(ctx, query, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.policy.Check(ctx, conn.ID, query); err != nil {
+		return nil, err
+	}
+	query, restrictRewrite, err := conn.restrict(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.dial(ctx); err != nil {
+		return nil, err
+	}
+
+	query, args, rewrites, err := bindArgs(conn.URL, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if hookRewrite != "" {
+		rewrites = append(rewrites, hookRewrite)
+	}
+	if restrictRewrite != "" {
+		rewrites = append(rewrites, restrictRewrite)
+	}
+
+	// Only opening the cursor is throttled, as the rows may be held open
+	// indefinitely by the client
+	timer.enter(phaseQueue)
+	release, err := conn.acquire(ctx)
+	timer.enter(phasePrepare)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	defer release()
+
+	if err := conn.faults.Inject(ctx, conn.ID); err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	if err := conn.cost.Check(ctx, conn, query, args); err != nil {
+		return nil, err
+	}
+
+	// The rows are read on the deadline's handle until the cursor is closed
+	dq, err := conn.withDeadline(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			dq.release()
+		}
+	}()
+	if dq.rewrite != "" {
+		rewrites = append(rewrites, dq.rewrite)
+	}
+
+	// The rows outlive the request, so they cannot be bound to its context
+	cursorCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+	timer.enter(phaseExecute)
+	executedAt := time.Now()
+	rows, err := dq.stmts(conn).QueryContext(cursorCtx, dq.db, dq.query, args...)
+	conn.health.observe(executedAt, err)
+	timer.enter(phaseFetch)
+	if !stop() {
+		if err == nil {
+			rows.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("query execution failed: %w", ctx.Err())
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	conn.executed(query)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+
+	scanTypes, masks := jsonTypes(columnTypes), conn.masker(ctx).Columns(columns)
+	cursor := &Cursor{
+		ID:           newID(),
+		ConnectionID: conn.ID,
+		Columns:      columns,
+		ColumnTypes:  make([]string, len(columnTypes)),
+		JSONTypes:    masks.JSONTypes(scanTypes),
+		Provenance:   conn.provenance(query, executedAt, rewrites),
+		conn:         conn,
+		convert:      newDriverConverter(conn.URL),
+		query:        query,
+		scanTypes:    scanTypes,
+		masks:        masks,
+		rows:         rows,
+		cancel:       cancel,
+		release:      dq.release,
+		audit:        audited,
+		timer:        timer,
+	}
+	cursor.touch(cm.ttl)
+	for i, ct := range columnTypes {
+		cursor.ColumnTypes[i] = ct.DatabaseTypeName()
+	}
+	timer.stop()
+
+	cm.mu.Lock()
+	cm.cursors[cursor.ID] = cursor
+	cm.mu.Unlock()
+
+	return cursor, nil
+}
+
+// add adds a cursor opened elsewhere, such as on spilled rows.
+func (cm *CursorManager) add(cursor *Cursor) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.max > 0 && len(cm.cursors) >= cm.max {
+		return fmt.Errorf("cursor limit reached (max: %d)", cm.max)
+	}
+	cursor.touch(cm.ttl)
+	cm.cursors[cursor.ID] = cursor
+	return nil
+}
+
+// Fetch fetches up to count rows from the cursor. The cursor is closed once
+// all rows have been fetched.
+func (cm *CursorManager) Fetch(ctx context.Context, id string, count int) (*CursorPage, error) {
+	return cm.fetch(ctx, id, count, ResultLimits{})
+}
+
+// fetch fetches up to count rows from the cursor, ending the page early once
+// it reaches the requested bytes, bounded by the connection's result caps.
+func (cm *CursorManager) fetch(ctx context.Context, id string, count int, limits ResultLimits) (*CursorPage, error) {
+	cm.mu.Lock()
+	cursor, ok := cm.cursors[id]
+	cm.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("cursor with ID %s not found", id)
+	}
+
+	maxBytes := cursor.conn.limits.bound(limits).Bytes
+	if cursor.spill != nil {
+		// pages of spilled rows are no larger than the rows held in memory
+		maxBytes = ResultLimits{Bytes: cursor.spill.threshold}.bound(ResultLimits{Bytes: maxBytes}).Bytes
+	}
+	if err := cursor.conn.quota.CheckRows(ctx); err != nil {
+		return nil, err
+	}
+	timer := cursor.firstFetch()
+	if timer != nil {
+		timer.enter(phaseFetch)
+	}
+	page, err := cursor.fetch(ctx, count, maxBytes, cm.ttl)
+	var rows int64
+	if page != nil {
+		rows = int64(len(page.Rows))
+	}
+	cursor.conn.checkSlow(ctx, timer, rows, err)
+	if err != nil || page.Done {
+		cm.Close(id)
+	}
+	if page != nil {
+		cursor.conn.quota.AddRows(ctx, len(page.Rows))
+	}
+	return page, err
+}
+
+// get returns an open cursor.
+func (cm *CursorManager) get(id string) (*Cursor, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cursor, ok := cm.cursors[id]
+	return cursor, ok
+}
+
+// Close closes and removes a cursor.
+func (cm *CursorManager) Close(id string) error {
+	cm.mu.Lock()
+	cursor, ok := cm.cursors[id]
+	delete(cm.cursors, id)
+	cm.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("cursor with ID %s not found", id)
+	}
+	return cursor.close()
+}
+
+// CloseConnection closes all cursors opened on a connection.
+func (cm *CursorManager) CloseConnection(connectionID string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for id, cursor := range cm.cursors {
+		if cursor.ConnectionID == connectionID {
+			delete(cm.cursors, id)
+			cursor.close()
+		}
+	}
+}
+
+// exists reports whether the cursor with the ID is open.
+func (cm *CursorManager) exists(id string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	_, ok := cm.cursors[id]
+	return ok
+}
+
+// count returns the number of cursors open on a connection.
+func (cm *CursorManager) count(connectionID string) int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	n := 0
+	for _, cursor := range cm.cursors {
+		if cursor.ConnectionID == connectionID {
+			n++
+		}
+	}
+	return n
+}
+
+// size returns the number of open cursors.
+func (cm *CursorManager) size() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return len(cm.cursors)
+}
+
+// Shutdown closes all cursors.
+func (cm *CursorManager) Shutdown() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for id, cursor := range cm.cursors {
+		delete(cm.cursors, id)
+		cursor.close()
+	}
+}
+
+// fetch reads up to count rows from the cursor, or all rows when count is
+// not positive, stopping once the rows read reach maxBytes when greater than
+// 0, extending its expiry.
+func (c *Cursor) fetch(ctx context.Context, count int, maxBytes int64, ttl time.Duration) (_ *CursorPage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.conn.recoverPanic(c.query, &err)
+	c.touch(ttl)
+	c.conn.touch()
+
+	page := &CursorPage{
+		CursorID: c.ID,
+		Rows:     [][]interface{}{},
+	}
+	buf := getScanBuffer()
+	defer buf.release()
+	var size int64
+	for (count <= 0 || len(page.Rows) < count) && !c.done && (maxBytes <= 0 || size < maxBytes) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		values, ok, err := c.next(buf)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			c.done = true
+			break
+		}
+		page.Rows = append(page.Rows, values)
+		size += rowSize(values)
+	}
+	c.fetched.Add(int64(len(page.Rows)))
+
+	c.touch(ttl)
+	page.Done, page.ExpiresAt, page.PII = c.done, c.ExpiresAt(), c.masks.Findings()
+	return page, nil
+}
+
+// next reads the next row from the cursor's rows through the scan buffer, or
+// from its spilled rows (masked when spilled), returning false once all rows
+// were read.
+func (c *Cursor) next(buf *scanBuffer) ([]interface{}, bool, error) {
+	if c.spill != nil {
+		return c.spill.next()
+	}
+	if !c.rows.Next() {
+		if err := c.rows.Err(); err != nil {
+			return nil, false, fmt.Errorf("row iteration error: %w", err)
+		}
+		return nil, false, nil
+	}
+	values, err := buf.scan(c.rows, make([]interface{}, len(c.scanTypes)), c.scanTypes, c.convert)
+	if err != nil {
+		return nil, false, err
+	}
+	c.masks.Row(values)
+	return values, true, nil
+}
+
+// firstFetch returns the cursor's timer on its first fetch, and nil after.
+func (c *Cursor) firstFetch() *queryTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.timer
+	c.timer = nil
+	return t
+}
+
+// touch extends the cursor's expiry to ttl from now.
+func (c *Cursor) touch(ttl time.Duration) {
+	c.expires.Store(time.Now().Add(ttl).UnixNano())
+}
+
+// ExpiresAt returns the time after which the cursor will be closed.
+func (c *Cursor) ExpiresAt() time.Time {
+	return time.Unix(0, c.expires.Load())
+}
+
+// close closes the cursor's rows, releasing the handle they were read on, or
+// removes its spilled rows, and releases its context.
+func (c *Cursor) close() error {
+	defer c.cancel()
+	if c.audit != nil {
+		c.audit(c.fetched.Load(), nil)
+	}
+	if c.spill != nil {
+		return c.spill.close()
+	}
+	err := c.rows.Close()
+	c.release()
+	return err
+}
+
+// newID returns a new random identifier.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestCursorFetch(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	cm := NewCursorManager(time.Minute, 0)
+	defer cm.Shutdown()
+
+	cursor, err := cm.Open(context.Background(), conn, "SELECT a")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !reflect.DeepEqual(cursor.Columns, []string{"a"}) || cursor.ConnectionID != "multi":
+		t.Errorf("expected a cursor on column a, got: %+v", cursor)
+	}
+
+	// rows are fetched in chunks, the cursor being closed once done
+	for i, exp := range []struct {
+		rows [][]interface{}
+		done bool
+	}{
+		{[][]interface{}{{int64(1)}}, false},
+		{[][]interface{}{{int64(2)}}, false},
+		{[][]interface{}{}, true},
+	} {
+		page, err := cm.Fetch(context.Background(), cursor.ID, 1)
+		switch {
+		case err != nil:
+			t.Fatalf("fetch %d: expected no error, got: %v", i, err)
+		case !reflect.DeepEqual(page.Rows, exp.rows) || page.Done != exp.done || page.CursorID != cursor.ID:
+			t.Errorf("fetch %d: expected rows %v, done %t, got: %+v", i, exp.rows, exp.done, page)
+		case page.ExpiresAt.Before(time.Now().Add(59 * time.Second)):
+			t.Errorf("fetch %d: expected the cursor's expiry to be extended, got: %v", i, page.ExpiresAt)
+		}
+	}
+	if cm.exists(cursor.ID) {
+		t.Errorf("expected the cursor to be closed once done")
+	}
+	if _, err := cm.Fetch(context.Background(), cursor.ID, 1); err == nil {
+		t.Errorf("expected an error fetching a closed cursor")
+	}
+
+	// all remaining rows are fetched when count is not positive
+	cursor, err = cm.Open(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if page, err := cm.Fetch(context.Background(), cursor.ID, 0); err != nil || len(page.Rows) != 2 || !page.Done {
+		t.Errorf("expected all rows, got: %+v %v", page, err)
+	}
+}
+
+func TestCursorClose(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	cm := NewCursorManager(time.Minute, 2)
+	defer cm.Shutdown()
+
+	var ids []string
+	for range 2 {
+		cursor, err := cm.Open(context.Background(), conn, "SELECT a")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		ids = append(ids, cursor.ID)
+	}
+	if _, err := cm.Open(context.Background(), conn, "SELECT a"); err == nil {
+		t.Errorf("expected an error opening more cursors than the limit")
+	}
+	if n := cm.count("multi"); n != 2 {
+		t.Errorf("expected 2 cursors on the connection, got: %d", n)
+	}
+
+	if err := cm.Close(ids[0]); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if err := cm.Close(ids[0]); err == nil {
+		t.Errorf("expected an error closing a closed cursor")
+	}
+	if _, err := cm.Fetch(context.Background(), ids[0], 1); err == nil {
+		t.Errorf("expected an error fetching a closed cursor")
+	}
+	if _, err := cm.Open(context.Background(), conn, "SELECT a"); err != nil {
+		t.Errorf("expected a cursor to open once another closed, got: %v", err)
+	}
+
+	cm.CloseConnection("multi")
+	if n := cm.size(); n != 0 {
+		t.Errorf("expected the connection's cursors to be closed, got: %d", n)
+	}
+	if n := conn.DB.Stats().InUse; n != 0 {
+		t.Errorf("expected the cursors' rows to be released, got: %d in use", n)
+	}
+}
+
+func TestCursorExpiry(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	// a single database connection and query slot, and a single cursor
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{MaxConcurrentQueries: 1})}
+	defer conn.DB.Close()
+	conn.DB.SetMaxOpenConns(1)
+	cm := NewCursorManager(time.Minute, 1)
+	defer cm.Shutdown()
+
+	cursor, err := cm.Open(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if n := cm.expire(time.Now()); n != 0 || !cm.exists(cursor.ID) {
+		t.Errorf("expected no cursor to expire, got: %d", n)
+	}
+	if n := conn.DB.Stats().InUse; n != 1 {
+		t.Errorf("expected the cursor's rows to hold a connection, got: %d in use", n)
+	}
+
+	if n := cm.expire(cursor.ExpiresAt().Add(time.Second)); n != 1 || cm.exists(cursor.ID) {
+		t.Errorf("expected the cursor to expire, got: %d", n)
+	}
+	if n := conn.DB.Stats().InUse; n != 0 {
+		t.Errorf("expected the cursor's rows to be released, got: %d in use", n)
+	}
+	if _, err := cm.Fetch(context.Background(), cursor.ID, 1); err == nil {
+		t.Errorf("expected an error fetching an expired cursor")
+	}
+
+	// the database connection, query slot and cursor are available again
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cm.Open(ctx, conn, "SELECT a"); err != nil {
+		t.Errorf("expected a cursor to open once the other expired, got: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xo/dburl"
+)
+
+// TLS modes of database connections.
+const (
+	TLSDisable    = "disable"
+	TLSRequire    = "require"
+	TLSVerifyCA   = "verify-ca"
+	TLSVerifyFull = "verify-full"
+)
+
+// pemMaterial is the TLS material of a connection's TLS settings, in PEM.
+type pemMaterial struct {
+	ca, cert, key string
+}
+
+// validate validates the TLS settings.
+func (c *DatabaseTLS) validate() error {
+	switch c.Mode {
+	case "", TLSDisable, TLSRequire, TLSVerifyCA, TLSVerifyFull:
+	default:
+		return fmt.Errorf("invalid TLS mode %q: must be %s, %s, %s or %s", c.Mode, TLSDisable, TLSRequire, TLSVerifyCA, TLSVerifyFull)
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return errors.New("a TLS client certificate requires its key, and a key its certificate")
+	}
+	return nil
+}
+
+// mode returns the TLS mode, verify-full by default.
+func (c *DatabaseTLS) mode() string {
+	if c.Mode == "" {
+		return TLSVerifyFull
+	}
+	return c.Mode
+}
+
+// applyTLS returns the DSN with the TLS settings applied as the driver's
+// parameters, and a func removing the files and registrations they needed.
+// Settings are PEM, or secret references resolving to PEM (with the kinds
+// allowed in client DSNs when untrusted), or paths to PEM files when
+// trusted. A nil config leaves the DSN as is.
+func (cp *ConnectionPool) applyTLS(ctx context.Context, u *dburl.URL, c *DatabaseTLS, trusted bool) (*dburl.URL, func(), error) {
+	if c == nil {
+		return u, func() {}, nil
+	}
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+	var releases []func()
+	release := func() {
+		for _, f := range releases {
+			f()
+		}
+	}
+	load := func(name, value string) (string, error) {
+		if value == "" {
+			return "", nil
+		}
+		resolved, f, err := cp.secrets.resolve(ctx, value, cp.config().Secrets.ClientRefs, trusted)
+		if err != nil {
+			return "", fmt.Errorf("tls %s: %w", name, err)
+		}
+		releases = append(releases, f)
+		if resolved == value && trusted && !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+			buf, err := os.ReadFile(value)
+			if err != nil {
+				return "", fmt.Errorf("tls %s: %w", name, err)
+			}
+			resolved = string(buf)
+		}
+		if !strings.HasPrefix(strings.TrimSpace(resolved), "-----BEGIN") {
+			return "", fmt.Errorf("tls %s must be PEM, or a secret reference to PEM", name)
+		}
+		return resolved, nil
+	}
+	var m pemMaterial
+	var err error
+	if m.ca, err = load("ca", c.CA); err == nil {
+		if m.cert, err = load("cert", c.Cert); err == nil {
+			m.key, err = load("key", c.Key)
+		}
+	}
+	var cleanup func()
+	if err == nil {
+		u, cleanup, err = m.apply(u, c)
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return u, func() {
+		cleanup()
+		release()
+	}, nil
+}
+
+// apply returns the DSN with the TLS settings applied as the driver's
+// parameters, and a func removing the files or registrations they needed.
+// Postgres takes the material as files, MySQL as a registered TLS config,
+// and SQL Server its CA as a file.
+func (m pemMaterial) apply(u *dburl.URL, c *DatabaseTLS) (*dburl.URL, func(), error) {
+	mode := c.mode()
+	// the material is validated for all drivers, not only those taking
+	// the config
+	config, err := m.tlsConfig(mode, c.ServerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := u.Query()
+	cleanup := func() {}
+	switch u.Driver {
+	case "postgres", "pgx":
+		if c.ServerName != "" {
+			return nil, nil, fmt.Errorf("tls server_name is not supported by driver %s", u.Driver)
+		}
+		query.Set("sslmode", mode)
+		if mode != TLSDisable && (m.ca != "" || m.cert != "") {
+			ca, cert, key, dir, err := m.writeFiles()
+			if err != nil {
+				return nil, nil, err
+			}
+			cleanup = func() { os.RemoveAll(dir) }
+			for param, path := range map[string]string{"sslrootcert": ca, "sslcert": cert, "sslkey": key} {
+				if path != "" {
+					query.Set(param, path)
+				}
+			}
+		}
+	case "mysql":
+		if mode == TLSDisable {
+			query.Set("tls", "false")
+			break
+		}
+		name, err := registerMySQLTLS(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() { mysql.DeregisterTLSConfig(name) }
+		query.Set("tls", name)
+	case "sqlserver":
+		switch {
+		case m.cert != "":
+			return nil, nil, errors.New("tls client certificates are not supported by driver sqlserver")
+		case mode == TLSVerifyCA:
+			return nil, nil, fmt.Errorf("tls mode %s is not supported by driver sqlserver", TLSVerifyCA)
+		}
+		switch mode {
+		case TLSDisable:
+			query.Set("encrypt", "disable")
+		case TLSRequire:
+			query.Set("encrypt", "true")
+			query.Set("TrustServerCertificate", "true")
+		case TLSVerifyFull:
+			query.Set("encrypt", "true")
+			query.Set("TrustServerCertificate", "false")
+			if c.ServerName != "" {
+				query.Set("hostNameInCertificate", c.ServerName)
+			}
+			if m.ca != "" {
+				ca, _, _, dir, err := m.writeFiles()
+				if err != nil {
+					return nil, nil, err
+				}
+				cleanup = func() { os.RemoveAll(dir) }
+				query.Set("certificate", ca)
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("tls settings are not supported by driver %s", u.Driver)
+	}
+	v := u.URL
+	v.RawQuery = query.Encode()
+	parsed, err := dburl.Parse(v.String())
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return parsed, cleanup, nil
+}
+
+// tlsConfig returns the TLS config of the settings, for drivers taking a
+// crypto/tls config.
+func (m pemMaterial) tlsConfig(mode, serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if m.cert != "" {
+		cert, err := tls.X509KeyPair([]byte(m.cert), []byte(m.key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	var roots *x509.CertPool
+	if m.ca != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(m.ca)) {
+			return nil, errors.New("no certificates found in the TLS CA")
+		}
+	}
+	switch mode {
+	case TLSRequire:
+		config.InsecureSkipVerify = true
+	case TLSVerifyCA:
+		// the chain is verified without the host name
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(raw, roots)
+		}
+	case TLSVerifyFull:
+		config.RootCAs = roots
+	}
+	return config, nil
+}
+
+// verifyChain verifies the certificate chain against the roots, or the
+// system roots when nil.
+func verifyChain(raw [][]byte, roots *x509.CertPool) error {
+	if len(raw) == 0 {
+		return errors.New("no server certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, b := range raw {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// writeFiles writes the PEM material to files in a new private directory,
+// returning their paths (empty for missing material) and the directory.
+func (m pemMaterial) writeFiles() (ca, cert, key, dir string, err error) {
+	if dir, err = os.MkdirTemp("", "usqlr-tls-"); err != nil {
+		return
+	}
+	write := func(name, data string) (string, error) {
+		if data == "" {
+			return "", nil
+		}
+		path := filepath.Join(dir, name)
+		return path, os.WriteFile(path, []byte(data), 0o600)
+	}
+	if ca, err = write("ca.pem", m.ca); err == nil {
+		if cert, err = write("cert.pem", m.cert); err == nil {
+			key, err = write("key.pem", m.key)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	return
+}
+
+// registerMySQLTLS registers the TLS config with the MySQL driver under a
+// new name, returning the name.
+func registerMySQLTLS(config *tls.Config) (string, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	name := "usqlr-" + hex.EncodeToString(b)
+	if err := mysql.RegisterTLSConfig(name, config); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestApplyTLS(t *testing.T) {
+	ca, caKey := testCert(t, nil, nil, "ca", nil)
+	leaf, leafKey := testCert(t, ca, caKey, "client", nil)
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(leafKey)}))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte(caPEM), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("USQLR_TEST_TLS_KEY", keyPEM)
+	cp := NewConnectionPool(&Config{Secrets: SecretsConfig{ClientRefs: []string{"env"}}}, nil, nil)
+
+	// postgres takes the material as files, removed by the cleanup
+	u, _ := dburl.Parse("postgres://app@db.example.com/app")
+	v, cleanup, err := cp.applyTLS(t.Context(), u, &DatabaseTLS{Mode: TLSVerifyCA, CA: caFile, Cert: certPEM, Key: "${env:USQLR_TEST_TLS_KEY}"}, true)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	query := v.Query()
+	if query.Get("sslmode") != TLSVerifyCA {
+		t.Errorf("expected sslmode verify-ca, got: %q", v.RawQuery)
+	}
+	for param, exp := range map[string]string{"sslrootcert": caPEM, "sslcert": certPEM, "sslkey": keyPEM} {
+		if buf, err := os.ReadFile(query.Get(param)); err != nil || string(buf) != exp {
+			t.Errorf("expected %s to be written, got: %v", param, err)
+		}
+	}
+	cleanup()
+	if _, err := os.Stat(filepath.Dir(query.Get("sslrootcert"))); !os.IsNotExist(err) {
+		t.Errorf("expected the files to be removed, got: %v", err)
+	}
+
+	// mysql takes a registered config
+	u, _ = dburl.Parse("mysql://app@db.example.com/app")
+	v, cleanup, err = cp.applyTLS(t.Context(), u, &DatabaseTLS{CA: caPEM}, true)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if name := v.Query().Get("tls"); !strings.HasPrefix(name, "usqlr-") {
+		t.Errorf("expected a registered TLS config, got: %q", v.RawQuery)
+	}
+	cleanup()
+
+	// sqlserver verifies with its CA file
+	u, _ = dburl.Parse("sqlserver://app@db.example.com/app")
+	v, cleanup, err = cp.applyTLS(t.Context(), u, &DatabaseTLS{CA: caPEM, ServerName: "db.internal"}, true)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if query := v.Query(); query.Get("encrypt") != "true" || query.Get("TrustServerCertificate") != "false" || query.Get("hostNameInCertificate") != "db.internal" || query.Get("certificate") == "" {
+		t.Errorf("expected sqlserver to verify the server, got: %q", v.RawQuery)
+	}
+	cleanup()
+
+	for i, test := range []struct {
+		dsn     string
+		tls     DatabaseTLS
+		trusted bool
+	}{
+		{"postgres://db/app", DatabaseTLS{Mode: "prefer"}, true},
+		{"postgres://db/app", DatabaseTLS{Cert: certPEM}, true},
+		{"postgres://db/app", DatabaseTLS{CA: "not pem"}, true},
+		{"postgres://db/app", DatabaseTLS{ServerName: "db.internal"}, true},
+		{"postgres://db/app", DatabaseTLS{Cert: certPEM, Key: caPEM}, true},
+		// clients can't read the server's files
+		{"postgres://db/app", DatabaseTLS{CA: caFile}, false},
+		{"postgres://db/app", DatabaseTLS{CA: "${file:" + caFile + "}"}, false},
+		{"sqlserver://db/app", DatabaseTLS{Cert: certPEM, Key: keyPEM}, true},
+		{"sqlserver://db/app", DatabaseTLS{Mode: TLSVerifyCA}, true},
+		{"sqlite3:/tmp/app.db", DatabaseTLS{}, true},
+	} {
+		u, _ := dburl.Parse(test.dsn)
+		if _, _, err := cp.applyTLS(t.Context(), u, &test.tls, test.trusted); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+	// clients can use the allowed kinds of references
+	u, _ = dburl.Parse("postgres://db/app")
+	if _, cleanup, err := cp.applyTLS(t.Context(), u, &DatabaseTLS{Cert: certPEM, Key: "${env:USQLR_TEST_TLS_KEY}"}, false); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	} else {
+		cleanup()
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	ca, caKey := testCert(t, nil, nil, "ca", nil)
+	leaf, _ := testCert(t, ca, caKey, "db.example.com", nil)
+	other, _ := testCert(t, nil, nil, "other", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if err := verifyChain([][]byte{leaf.Raw}, roots); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if err := verifyChain([][]byte{other.Raw}, roots); err == nil {
+		t.Errorf("expected an error for a certificate of another CA")
+	}
+	if err := verifyChain(nil, roots); err == nil {
+		t.Errorf("expected an error without certificates")
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// DebugConfig contains the configuration of the runtime diagnostics: the
+// pprof endpoints under /debug/pprof/, and the goroutine and heap dumps
+// written to DumpDir (the system temporary directory when not set) on
+// request. When Address is set, they are served on an admin listener at the
+// address rather than on the server's.
+type DebugConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	Address string `mapstructure:"address" yaml:"address" json:"address"`
+	DumpDir string `mapstructure:"dump_dir" yaml:"dump_dir" json:"dump_dir"`
+}
+
+// registerDebug registers the runtime diagnostics endpoints.
+func (s *Server) registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("POST /debug/dump/{kind}", s.handleDump)
+}
+
+// Dump is a goroutine or heap dump written to a file.
+type Dump struct {
+	Kind  string    `json:"kind"`
+	File  string    `json:"file"`
+	Bytes int64     `json:"bytes"`
+	Time  time.Time `json:"time"`
+}
+
+// writeDump writes a dump of the kind to a file in dir: the stacks of all
+// goroutines as text, or a heap profile taken after a garbage collection.
+func writeDump(dir, kind string) (*Dump, error) {
+	var ext string
+	var debug int
+	switch kind {
+	case "goroutine":
+		ext, debug = "txt", 2
+	case "heap":
+		ext = "pprof"
+		// collect garbage, so the profile is up to date
+		runtime.GC()
+	default:
+		return nil, fmt.Errorf("invalid dump %q: must be goroutine or heap", kind)
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	now := time.Now().UTC()
+	d := &Dump{
+		Kind: kind,
+		File: filepath.Join(dir, fmt.Sprintf("usqlr-%s-%s.%s", kind, now.Format("20060102T150405.000000000"), ext)),
+		Time: now,
+	}
+	f, err := os.OpenFile(d.File, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s dump: %w", kind, err)
+	}
+	err = rpprof.Lookup(kind).WriteTo(f, debug)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(d.File)
+		return nil, fmt.Errorf("failed to write %s dump: %w", kind, err)
+	}
+	fi, err := os.Stat(d.File)
+	if err != nil {
+		return nil, err
+	}
+	d.Bytes = fi.Size()
+	return d, nil
+}
+
+// handleDump handles writing a goroutine or heap dump to the dump directory.
+func (s *Server) handleDump(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if kind != "goroutine" && kind != "heap" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid dump %q: must be goroutine or heap", kind))
+		return
+	}
+	d, err := writeDump(s.config().Debug.DumpDir, kind)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
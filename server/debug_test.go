@@ -0,0 +1,32 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDump(t *testing.T) {
+	dir := t.TempDir()
+	d, err := writeDump(dir, "goroutine")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	buf, err := os.ReadFile(d.File)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if filepath.Dir(d.File) != dir || int64(len(buf)) != d.Bytes || !strings.Contains(string(buf), "TestWriteDump") {
+		t.Errorf("expected the goroutine stacks dumped in the directory, got: %+v", d)
+	}
+	if d, err = writeDump(dir, "heap"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !strings.HasSuffix(d.File, ".pprof") || d.Bytes == 0 {
+		t.Errorf("expected a heap profile dumped, got: %+v", d)
+	}
+	if _, err := writeDump(dir, "threads"); err == nil {
+		t.Errorf("expected an error with an unknown dump")
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xo/usql/server/mcp"
+)
+
+// endpointDeprecations are the notices of deprecated REST endpoints, matched
+// by their route patterns.
+type endpointDeprecations struct {
+	mux     *http.ServeMux
+	notices map[string]mcp.Deprecation
+}
+
+// newDeprecations returns the configured deprecations of REST endpoints, and
+// of MCP tools by name.
+func newDeprecations(configs []Deprecation) (*endpointDeprecations, map[string]mcp.Deprecation, error) {
+	endpoints := &endpointDeprecations{
+		mux:     http.NewServeMux(),
+		notices: make(map[string]mcp.Deprecation),
+	}
+	tools := make(map[string]mcp.Deprecation)
+	for i, config := range configs {
+		d, err := parseDeprecation(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("deprecation %d: %w", i, err)
+		}
+		switch {
+		case config.Endpoint != "" && config.Tool != "":
+			return nil, nil, fmt.Errorf("deprecation %d: only one of endpoint and tool can be given", i)
+		case config.Endpoint != "":
+			if err := endpoints.add(config.Endpoint, d); err != nil {
+				return nil, nil, fmt.Errorf("deprecation %d: %w", i, err)
+			}
+		case config.Tool != "":
+			if _, ok := tools[config.Tool]; ok {
+				return nil, nil, fmt.Errorf("deprecation %d: tool %s is already deprecated", i, config.Tool)
+			}
+			tools[config.Tool] = d
+		default:
+			return nil, nil, fmt.Errorf("deprecation %d: endpoint or tool is required", i)
+		}
+	}
+	return endpoints, tools, nil
+}
+
+// parseDeprecation parses the deprecation's notice.
+func parseDeprecation(config Deprecation) (mcp.Deprecation, error) {
+	d := mcp.Deprecation{
+		Link:    config.Link,
+		Message: config.Message,
+	}
+	var err error
+	if d.Deprecated, err = parseDate(config.Deprecated); err != nil {
+		return d, fmt.Errorf("invalid deprecated date: %w", err)
+	}
+	if d.Sunset, err = parseDate(config.Sunset); err != nil {
+		return d, fmt.Errorf("invalid sunset date: %w", err)
+	}
+	switch {
+	case d.Deprecated.IsZero() && d.Sunset.IsZero():
+		return d, fmt.Errorf("deprecated or sunset date is required")
+	case !d.Deprecated.IsZero() && !d.Sunset.IsZero() && d.Sunset.Before(d.Deprecated):
+		return d, fmt.Errorf("sunset date is before the deprecated date")
+	}
+	return d, nil
+}
+
+// parseDate parses a date (2006-01-02, in UTC) or an RFC 3339 time. An empty
+// string is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// add adds the notice of the endpoint's route pattern.
+func (e *endpointDeprecations) add(pattern string, d mcp.Deprecation) (err error) {
+	// ServeMux panics on invalid or conflicting patterns
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid endpoint %q: %v", pattern, r)
+		}
+	}()
+	e.mux.Handle(pattern, http.NotFoundHandler())
+	e.notices[pattern] = d
+	return nil
+}
+
+// lookup returns the notice of the endpoint handling the request.
+func (e *endpointDeprecations) lookup(r *http.Request) (mcp.Deprecation, bool) {
+	_, pattern := e.mux.Handler(r)
+	d, ok := e.notices[pattern]
+	return d, ok
+}
+
+// deprecationMiddleware adds the deprecation headers of deprecated endpoints
+// to their responses, and responds with 410 Gone once they are removed.
+func (s *Server) deprecationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := s.deprecations.lookup(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d.SetHeaders(w.Header())
+		if d.Removed(time.Now()) {
+			writeJSON(w, http.StatusGone, map[string]interface{}{
+				"error":       fmt.Sprintf("%s %s was removed", r.Method, r.URL.Path),
+				"deprecation": d,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
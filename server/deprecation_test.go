@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewDeprecations(t *testing.T) {
+	tests := []struct {
+		config Deprecation
+		err    bool
+	}{
+		{Deprecation{Endpoint: "POST /v1/connections/{id}/export", Deprecated: "2024-01-02"}, false},
+		{Deprecation{Tool: "execute_query", Sunset: "2024-01-02T15:04:05Z"}, false},
+		{Deprecation{Deprecated: "2024-01-02"}, true},
+		{Deprecation{Endpoint: "/a", Tool: "b", Deprecated: "2024-01-02"}, true},
+		{Deprecation{Endpoint: "/a"}, true},
+		{Deprecation{Endpoint: "/a", Deprecated: "02/01/2024"}, true},
+		{Deprecation{Endpoint: "/a", Deprecated: "2024-01-02", Sunset: "2024-01-01"}, true},
+		{Deprecation{Endpoint: "/a/{id", Deprecated: "2024-01-02"}, true},
+	}
+	for i, test := range tests {
+		_, _, err := newDeprecations([]Deprecation{test.config})
+		switch {
+		case test.err && err == nil:
+			t.Errorf("test %d: expected error", i)
+		case !test.err && err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		}
+	}
+	if _, _, err := newDeprecations([]Deprecation{
+		{Endpoint: "/a/{id}", Deprecated: "2024-01-02"},
+		{Endpoint: "/a/{name}", Deprecated: "2024-01-02"},
+	}); err == nil {
+		t.Errorf("expected error for conflicting endpoints")
+	}
+}
+
+func TestDeprecationMiddleware(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	deprecations, _, err := newDeprecations([]Deprecation{
+		{Endpoint: "POST /v1/connections/{id}/export", Deprecated: "2024-01-02", Sunset: future, Link: "https://example.com/export"},
+		{Endpoint: "/v1/old", Deprecated: "2024-01-02", Sunset: "2024-06-01", Message: "use /v2/new"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	s := &Server{deprecations: deprecations}
+	h := s.deprecationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// deprecated
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/connections/db/export", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got: %d", http.StatusNoContent, w.Code)
+	}
+	if s := w.Header().Get("Deprecation"); s != "@1704153600" {
+		t.Errorf("expected Deprecation @1704153600, got: %q", s)
+	}
+	if s := w.Header().Get("Sunset"); s == "" {
+		t.Errorf("expected Sunset header")
+	}
+	if s, exp := w.Header().Get("Link"), `<https://example.com/export>; rel="deprecation"`; s != exp {
+		t.Errorf("expected Link %q, got: %q", exp, s)
+	}
+
+	// not deprecated
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/connections/db/query/stream", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Deprecation") != "" {
+		t.Errorf("expected endpoint not to be deprecated, got: %d %v", w.Code, w.Header())
+	}
+
+	// removed
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/old", nil))
+	if w.Code != http.StatusGone {
+		t.Fatalf("expected status %d, got: %d", http.StatusGone, w.Code)
+	}
+	if s, exp := w.Header().Get("Sunset"), "Sat, 01 Jun 2024 00:00:00 GMT"; s != exp {
+		t.Errorf("expected Sunset %q, got: %q", exp, s)
+	}
+	var res struct {
+		Error       string `json:"error"`
+		Deprecation struct {
+			Sunset  time.Time `json:"sunset"`
+			Message string    `json:"message"`
+		} `json:"deprecation"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if res.Error == "" || res.Deprecation.Message != "use /v2/new" || res.Deprecation.Sunset.IsZero() {
+		t.Errorf("expected error with the deprecation notice, got: %+v", res)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/xo/usql/server/mcp"
+)
+
+// drainPollInterval is how often the work in progress is checked while
+// draining.
+const drainPollInterval = 100 * time.Millisecond
+
+// errDraining is the error of requests refused while the server drains.
+var errDraining = errors.New("the server is shutting down")
+
+// Work is the work in progress on the server: the HTTP requests being
+// served, the queries executing, the jobs queued or running, and the
+// transactions held open by sessions.
+type Work struct {
+	Requests     int64 `json:"requests"`
+	Queries      int64 `json:"queries"`
+	Jobs         int   `json:"jobs"`
+	Transactions int   `json:"transactions"`
+}
+
+// inProgress returns the work in progress.
+func (s *Server) inProgress() Work {
+	_, transactions := s.pool.sessions.counts()
+	return Work{
+		Requests:     s.inflight.Load(),
+		Queries:      s.pool.activeQueries(),
+		Jobs:         s.pool.jobs.pending(),
+		Transactions: transactions,
+	}
+}
+
+// drain stops accepting new work, and waits until the work in progress
+// finished, or ctx is done, returning the work left.
+func (s *Server) drain(ctx context.Context) Work {
+	s.draining.Store(true)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		work := s.inProgress()
+		if work == (Work{}) {
+			return work
+		}
+		select {
+		case <-ctx.Done():
+			return work
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainMiddleware counts the requests in flight, and refuses new work while
+// the server drains: requests other than health checks, and than those of
+// sessions holding transactions open, which may still be committed or rolled
+// back.
+func (s *Server) drainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() && r.URL.Path != "/health" && !s.pool.sessions.holdsTransactions(r.Header.Get(mcp.SessionHeader)) {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, errDraining)
+			return
+		}
+		s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// activeQueries returns the number of queries executing on the pool's
+// connections and their replicas.
+func (cp *ConnectionPool) activeQueries() int64 {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	var n int64
+	for _, conn := range cp.connections {
+		n += conn.active.Load()
+		for _, replica := range conn.replicaList() {
+			n += replica.active.Load()
+		}
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/mcp"
+)
+
+func TestDrain(t *testing.T) {
+	s, err := New(&Config{Server: ServerConfig{RequestTimeout: time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	u, _ := dburl.Parse("postgres://localhost/db")
+	s.pool.connections["c"] = &Connection{ID: "c", URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+
+	session := s.pool.sessions.Create(context.Background())
+	ctx := session.Bind(context.Background())
+	if _, err := s.pool.BeginTransaction(ctx, "c", sql.TxOptions{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// the transaction, in use of its connection, is left once the timeout
+	// expires
+	timeout, cancel := context.WithTimeout(context.Background(), 2*drainPollInterval)
+	defer cancel()
+	if work := s.drain(timeout); work.Queries != 1 || work.Transactions != 1 {
+		t.Errorf("expected the transaction left, got: %+v", work)
+	}
+
+	// while draining, only health checks and requests of sessions holding
+	// transactions are served
+	handler := s.drainMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, test := range []struct {
+		path    string
+		session string
+		exp     int
+	}{
+		{"/health", "", http.StatusOK},
+		{"/mcp", "", http.StatusServiceUnavailable},
+		{"/mcp", "unknown", http.StatusServiceUnavailable},
+		{"/mcp", session.ID, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, test.path, nil)
+		if test.session != "" {
+			r.Header.Set(mcp.SessionHeader, test.session)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.exp {
+			t.Errorf("%s %q: expected %d, got: %d", test.path, test.session, test.exp, w.Code)
+		}
+		if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s %q: expected a Retry-After header", test.path, test.session)
+		}
+	}
+
+	// drain returns once the work in progress finished
+	done := make(chan Work)
+	go func() {
+		done <- s.drain(context.Background())
+	}()
+	if _, err := s.pool.EndTransaction(ctx, "c", true); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	select {
+	case work := <-done:
+		if work != (Work{}) {
+			t.Errorf("expected no work left, got: %+v", work)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected drain to return once the work finished")
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// DryRunResult describes a query or statement validated without executing
+// it.
+type DryRunResult struct {
+	// Valid is whether the database accepted the SQL, and Error why not
+	// otherwise.
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+
+	Type     string `json:"type"`
+	Category string `json:"category"`
+
+	// Parameters is the number of parameters of the SQL, when the driver
+	// reports it.
+	Parameters *int `json:"parameters,omitempty"`
+
+	// Columns and ColumnTypes are the result columns of queries, when they
+	// could be inferred.
+	Columns     []string `json:"columns,omitempty"`
+	ColumnTypes []string `json:"column_types,omitempty"`
+}
+
+// DryRun validates the query (or statement) on the connection or group with
+// the ID, by preparing it without executing it, as a cheap check of SQL
+// before running it. Denied SQL fails as when executed.
+func (cp *ConnectionPool) DryRun(ctx context.Context, id, query string, statement bool, args ...interface{}) (*DryRunResult, error) {
+	cp.mu.RLock()
+	conn, exists := cp.route(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("connection with ID %s not found", id)
+	}
+	return conn.dryRun(ctx, query, statement, args)
+}
+
+// dryRun validates the query by preparing it. The result columns of queries
+// are read from the query wrapped in a query returning no rows, which
+// databases answer without running the query.
+func (conn *Connection) dryRun(ctx context.Context, query string, statement bool, args []interface{}) (_ *DryRunResult, err error) {
+	defer conn.recoverPanic(query, &err)
+
+	query, _, err = conn.preQuery(ctx, query, statement)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.policy.CheckDenied(ctx, conn.ID, query); err != nil {
+		return nil, err
+	}
+	if query, _, err = conn.restrict(ctx, query); err != nil {
+		return nil, err
+	}
+	if err := conn.dial(ctx); err != nil {
+		return nil, err
+	}
+	query, args, _, err = bindArgs(conn.URL, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := conn.throttle.Acquire(ctx, hostKey(conn.URL))
+	if err != nil {
+		return nil, fmt.Errorf("dry run failed: %w", err)
+	}
+	defer conn.use(release)()
+
+	result := new(DryRunResult)
+	result.Type, result.Category = policy.Classify(query)
+	c, err := conn.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dry run failed: %w", err)
+	}
+	defer c.Close()
+
+	prepareErr := c.Raw(func(dc interface{}) error {
+		var stmt driver.Stmt
+		var err error
+		if p, ok := dc.(driver.ConnPrepareContext); ok {
+			stmt, err = p.PrepareContext(ctx, query)
+		} else {
+			stmt, err = dc.(driver.Conn).Prepare(query)
+		}
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		if n := stmt.NumInput(); n >= 0 {
+			result.Parameters = &n
+		}
+		return nil
+	})
+	switch {
+	case prepareErr != nil:
+		result.Error = prepareErr.Error()
+		return result, nil
+	case result.Parameters != nil && len(args) != 0 && len(args) != *result.Parameters:
+		result.Error = fmt.Sprintf("expected %d arguments, got %d", *result.Parameters, len(args))
+		return result, nil
+	}
+	result.Valid = true
+
+	switch result.Type {
+	case "SELECT", "WITH", "VALUES", "TABLE":
+	default:
+		return result, nil
+	}
+	if len(args) == 0 && result.Parameters != nil {
+		args = make([]interface{}, *result.Parameters)
+	}
+	wrapped := "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(query), ";") + ") dry_run WHERE 1 = 0"
+	rows, err := c.QueryContext(ctx, wrapped, args...)
+	if err != nil {
+		// the columns are left out of queries that can't be wrapped
+		return result, nil
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return result, nil
+	}
+	result.Columns = make([]string, len(columnTypes))
+	result.ColumnTypes = make([]string, len(columnTypes))
+	for i, ct := range columnTypes {
+		result.Columns[i], result.ColumnTypes[i] = ct.Name(), ct.DatabaseTypeName()
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestDryRun(t *testing.T) {
+	conn := &Connection{
+		ID:       "dry",
+		URL:      &dburl.URL{Driver: "dryrun-test"},
+		DB:       sql.OpenDB(dryRunConnector{}),
+		throttle: NewThrottle(ServerConfig{}),
+	}
+	defer conn.DB.Close()
+
+	result, err := conn.dryRun(context.Background(), "SELECT a FROM t WHERE b = ?", false, nil)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !result.Valid || result.Type != "SELECT" || result.Parameters == nil || *result.Parameters != 1:
+		t.Errorf("expected a valid query with 1 parameter, got: %+v", result)
+	case len(result.Columns) != 1 || result.Columns[0] != "a":
+		t.Errorf("expected the query's columns, got: %v", result.Columns)
+	}
+
+	// statements are prepared, and never executed
+	result, err = conn.dryRun(context.Background(), "DELETE FROM t", true, nil)
+	if err != nil || !result.Valid || result.Category != "dml" || result.Columns != nil {
+		t.Errorf("expected a valid statement, got: %+v %v", result, err)
+	}
+
+	for i, test := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"SELEC a FROM t", nil},
+		{"SELECT a FROM t WHERE b = ?", []interface{}{1, 2}},
+	} {
+		result, err := conn.dryRun(context.Background(), test.query, false, test.args)
+		if err != nil || result.Valid || result.Error == "" {
+			t.Errorf("test %d: expected an invalid result, got: %+v %v", i, result, err)
+		}
+	}
+}
+
+type dryRunConnector struct{}
+
+func (dryRunConnector) Connect(context.Context) (driver.Conn, error) { return dryRunConn{}, nil }
+func (dryRunConnector) Driver() driver.Driver                        { return nil }
+
+// dryRunConn is a connection preparing statements, failing for statements
+// not starting with a known keyword, and only querying wrapped queries
+// returning no rows.
+type dryRunConn struct {
+	multiConn
+}
+
+func (dryRunConn) Prepare(query string) (driver.Stmt, error) {
+	switch strings.Fields(query)[0] {
+	case "SELECT", "DELETE":
+		return dryRunStmt{n: strings.Count(query, "?")}, nil
+	}
+	return nil, errors.New("syntax error")
+}
+
+func (dryRunConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if !strings.HasSuffix(query, "WHERE 1 = 0") {
+		return nil, errors.New("query executed")
+	}
+	return &multiRows{sets: []multiSet{{[]string{"a"}, nil}}}, nil
+}
+
+func (dryRunConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, errors.New("statement executed")
+}
+
+type dryRunStmt struct {
+	n int
+}
+
+func (dryRunStmt) Close() error                               { return nil }
+func (s dryRunStmt) NumInput() int                            { return s.n }
+func (dryRunStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("executed") }
+func (dryRunStmt) Query([]driver.Value) (driver.Rows, error)  { return nil, errors.New("executed") }
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+// secretPlaceholder stands in for the secret references of DSNs validated
+// without resolving them.
+const secretPlaceholder = "secret"
+
+// DSNInfo describes a DSN, as parsed without connecting to its database.
+type DSNInfo struct {
+	// Valid is whether the DSN parsed, and Error why not otherwise.
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+
+	// Driver is the driver the DSN's scheme resolves to, and Available
+	// whether the driver is compiled into the server.
+	Driver    string `json:"driver,omitempty"`
+	Available bool   `json:"available"`
+
+	// URL is the normalized DSN, with its password redacted.
+	URL string `json:"url,omitempty"`
+
+	Transport string `json:"transport,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Port      string `json:"port,omitempty"`
+	Database  string `json:"database,omitempty"`
+	User      string `json:"user,omitempty"`
+
+	// SecretRefs is the count of the DSN's secret references, which are
+	// not resolved.
+	SecretRefs int `json:"secret_refs,omitempty"`
+}
+
+// ValidateDSN parses the DSN, without resolving its secret references or
+// connecting to its database. The port is the driver's default when the DSN
+// has none, and the database of a DSN without a host is its file path.
+func ValidateDSN(dsn string) *DSNInfo {
+	info := new(DSNInfo)
+	refs := secretRef.FindAllStringIndex(dsn, -1)
+	info.SecretRefs = len(refs)
+	if len(refs) == 1 && refs[0][0] == 0 && refs[0][1] == len(dsn) {
+		info.Error = "the DSN is a secret reference, which is only resolved when connecting"
+		return info
+	}
+	u, err := dburl.Parse(secretRef.ReplaceAllString(dsn, secretPlaceholder))
+	if err != nil {
+		// errors of url.Parse contain the DSN, and so its password
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		info.Error = err.Error()
+		return info
+	}
+	info.Valid = true
+	info.Driver = u.Driver
+	info.Available = drivers.Registered(u.Driver)
+	// the scheme is normalized to the driver's, from any of its aliases
+	v := u.URL
+	v.Scheme = u.Driver
+	info.URL = v.Redacted()
+	info.Transport = u.Transport
+	info.Host = u.Hostname()
+	if info.Port = u.Port(); info.Port == "" && info.Host != "" {
+		info.Port = defaultPorts[u.Driver]
+	}
+	if u.Opaque != "" {
+		info.Database = u.Opaque
+	} else {
+		info.Database = strings.TrimPrefix(u.Path, "/")
+	}
+	if u.User != nil {
+		info.User = u.User.Username()
+	}
+	return info
+}
+
+// handleValidateDSN handles requests to validate a DSN without connecting,
+// such as of UIs building DSNs.
+func (s *Server) handleValidateDSN(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DSN string `json:"dsn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.DSN == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("dsn is required"))
+		return
+	}
+	writeJSON(w, http.StatusOK, ValidateDSN(req.DSN))
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/xo/usql/server/parquet"
+	"github.com/xo/usql/server/policy"
+	"github.com/xo/usql/server/xlsx"
+)
+
+// exportFormats are the content types of the export formats.
+var exportFormats = map[string]string{
+	"json":    "application/json",
+	"csv":     "text/csv; charset=utf-8",
+	"xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"parquet": parquet.ContentType,
+}
+
+// queryRequest is a REST API request to run a query.
+type queryRequest struct {
+	Query  string                 `json:"query"`
+	Args   []interface{}          `json:"args"`
+	Params map[string]interface{} `json:"params"`
+	Format string                 `json:"format"`
+
+	// ApprovalID is the ID of the approved approval of a query requiring
+	// approval by the statement policies.
+	ApprovalID string `json:"approval_id"`
+
+	TimeFormat string `json:"time_format"`
+	TimeZone   string `json:"time_zone"`
+
+	Nulls        string `json:"nulls"`
+	NullSentinel string `json:"null_sentinel"`
+	RowFormat    string `json:"row_format"`
+}
+
+// arguments returns the query arguments of the request, with named
+// parameters sorted by name.
+func (req queryRequest) arguments() ([]interface{}, error) {
+	if len(req.Params) == 0 {
+		return req.Args, nil
+	}
+	if len(req.Args) != 0 {
+		return nil, fmt.Errorf("args and params cannot both be specified")
+	}
+	names := make([]string, 0, len(req.Params))
+	for name := range req.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = sql.Named(name, req.Params[name])
+	}
+	return args, nil
+}
+
+// context returns ctx carrying the request's approval, if any.
+func (req queryRequest) context(ctx context.Context) context.Context {
+	if req.ApprovalID == "" {
+		return ctx
+	}
+	return policy.WithApproval(ctx, req.ApprovalID)
+}
+
+// handleExport handles running a query and returning its result as a file
+// in the requested format (json, csv, xlsx or parquet). Parquet files only
+// contain the query's first result set. Times are formatted in JSON and CSV
+// files, and typed in workbooks and Parquet files.
+//
+// JSON and CSV files are written as the rows are read from the database,
+// without holding them in memory, while workbooks and Parquet files are
+// encoded from the whole result, truncated at the server's result caps. As
+// streamed queries, queries of a session run in its transaction, if any.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	id := pathConnection(r)
+	if !allowConnection(w, r, id) {
+		return
+	}
+	conn, err := s.pool.GetConnection(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Format == "" {
+		req.Format = "json"
+	}
+	contentType, ok := exportFormats[req.Format]
+	switch {
+	case req.Query == "":
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query is required"))
+		return
+	case !ok:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q", req.Format))
+		return
+	}
+	// workbooks and Parquet files are granted as their export tools, and
+	// JSON and CSV files as execute_query
+	tool := "execute_query"
+	if req.Format == "xlsx" || req.Format == "parquet" {
+		tool = "export_" + req.Format
+	}
+	if !allowTool(w, r, tool, policy.ToolQuery) {
+		return
+	}
+	args, err := req.arguments()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	times, err := s.times.With(req.TimeFormat, req.TimeZone)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	nullFormat, err := s.nulls.With(req.Nulls, req.NullSentinel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, err := s.sessionContext(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var it *RowIterator
+	var result *QueryResult
+	switch req.Format {
+	case "json", "csv":
+		it, err = conn.QueryRows(req.context(ctx), req.Query, args...)
+	default:
+		result, err = conn.ExecuteQuery(req.context(ctx), req.Query, args...)
+	}
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.%s"`, time.Now().UTC().Format("20060102-150405"), req.Format))
+	format := func(row []interface{}) []interface{} {
+		return nullFormat.Row(times.Row(row))
+	}
+	switch req.Format {
+	case "json":
+		defer it.Close()
+		err = writeJSONRows(w, it, format)
+	case "csv":
+		defer it.Close()
+		err = writeCSV(w, it, format)
+	case "xlsx":
+		err = xlsx.Write(w, xlsxSheets(append([]*QueryResult{result}, result.MoreResultSets...)))
+	case "parquet":
+		err = parquet.Write(w, result.Columns, result.ColumnTypes, result.Rows)
+	}
+	if err != nil {
+		// the response has been started, so the error can only be logged,
+		// and a partially written file aborted
+		serverLog.Error("export error", "error", err)
+		if it != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// ExportInfo describes a query result exported as a file.
+type ExportInfo struct {
+	Path     string           `json:"path,omitempty"`
+	Format   string           `json:"format"`
+	RowCount int              `json:"row_count"`
+	Bytes    int              `json:"bytes"`
+	Columns  []parquet.Column `json:"columns,omitempty"`
+
+	// Data is the content of the file, when it was not written to a path.
+	Data []byte `json:"-"`
+}
+
+// Export executes a SQL query on the specified connection and encodes its
+// result as a file in the format: an Excel workbook (xlsx) with a sheet per
+// result set, or a Parquet file (parquet) of the first result set. The file
+// is written to the path, relative to the server's export directory, or when
+// the path is empty, returned as the Data of the ExportInfo.
+func (cp *ConnectionPool) Export(ctx context.Context, id, query, format, path string, args ...interface{}) (*ExportInfo, error) {
+	conn, err := cp.GetConnection(id)
+	if err != nil {
+		return nil, err
+	}
+	result, err := conn.ExecuteQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ExportInfo{Format: format, RowCount: len(result.Rows)}
+	var buf bytes.Buffer
+	switch format {
+	case "xlsx":
+		sets := append([]*QueryResult{result}, result.MoreResultSets...)
+		for _, set := range sets[1:] {
+			info.RowCount += len(set.Rows)
+		}
+		err = xlsx.Write(&buf, xlsxSheets(sets))
+	case "parquet":
+		info.Columns = parquet.Schema(result.Columns, result.ColumnTypes, result.Rows)
+		err = parquet.Write(&buf, result.Columns, result.ColumnTypes, result.Rows)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Bytes = buf.Len()
+
+	if path == "" {
+		info.Data = buf.Bytes()
+		return info, nil
+	}
+	if info.Path, err = cp.writeExport(path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// writeExport writes an exported file to the path, relative to the export
+// directory, returning the path written. The file is replaced atomically.
+func (cp *ConnectionPool) writeExport(path string, data []byte) (string, error) {
+	dir := cp.config().Server.ExportDir
+	switch {
+	case dir == "":
+		return "", fmt.Errorf("exporting to files is disabled (server.export_dir is not set)")
+	case !filepath.IsLocal(path):
+		return "", fmt.Errorf("export path %q must be a relative path within the export directory", path)
+	}
+	name := filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".export-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write export file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return "", fmt.Errorf("failed to write export file: %w", err)
+	}
+	return name, nil
+}
+
+// xlsxSheets returns the result sets as workbook sheets.
+func xlsxSheets(sets []*QueryResult) []xlsx.Sheet {
+	sheets := make([]xlsx.Sheet, len(sets))
+	for i, set := range sets {
+		sheets[i] = xlsx.Sheet{
+			Name:    fmt.Sprintf("Result %d", i+1),
+			Columns: set.Columns,
+			Types:   set.ColumnTypes,
+			Rows:    set.Rows,
+		}
+	}
+	return sheets
+}
+
+// writeCSV writes the rows of the iterator's result sets, formatted with
+// format, as CSV with a header row, separating result sets with an empty
+// line.
+func writeCSV(w io.Writer, it *RowIterator, format func([]interface{}) []interface{}) error {
+	cw := csv.NewWriter(w)
+	for set := 0; ; set++ {
+		if set != 0 {
+			cw.Flush()
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(it.Columns); err != nil {
+			return err
+		}
+		record := make([]string, len(it.Columns))
+		for it.Next() {
+			for j, v := range format(it.Row()) {
+				record[j] = csvValue(v)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		if !it.NextResultSet() {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonResultHeader is the JSON of a result set in a JSON export, without its
+// rows.
+type jsonResultHeader struct {
+	Columns     []string    `json:"columns"`
+	ColumnTypes []string    `json:"column_types"`
+	JSONTypes   []string    `json:"json_types,omitempty"`
+	Provenance  *Provenance `json:"provenance,omitempty"`
+}
+
+// writeJSONRows writes the rows of the iterator's result sets, formatted
+// with format, as a JSON query result as they are read: the first result
+// set, with the rest in its more_result_sets.
+func writeJSONRows(w io.Writer, it *RowIterator, format func([]interface{}) []interface{}) error {
+	bw := bufio.NewWriter(w)
+	for set := 0; ; set++ {
+		switch set {
+		case 0:
+		case 1:
+			bw.WriteString(`,"more_result_sets":[`)
+		default:
+			bw.WriteString(",")
+		}
+		header := jsonResultHeader{Columns: it.Columns, ColumnTypes: it.ColumnTypes, JSONTypes: it.JSONTypes}
+		if set == 0 {
+			header.Provenance = it.Provenance
+		}
+		buf, err := json.Marshal(header)
+		if err != nil {
+			return err
+		}
+		// the rows are written into the header object
+		bw.Write(buf[:len(buf)-1])
+		bw.WriteString(`,"rows":[`)
+		for n := 0; it.Next(); n++ {
+			if n != 0 {
+				bw.WriteString(",")
+			}
+			buf, err := json.Marshal(format(it.Row()))
+			if err != nil {
+				return err
+			}
+			bw.WriteString("\n")
+			bw.Write(buf)
+		}
+		bw.WriteString("]")
+		if set != 0 {
+			bw.WriteString("}")
+		}
+		if !it.NextResultSet() {
+			if set != 0 {
+				bw.WriteString("]")
+			}
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// csvValue formats a value for CSV, with NULL as an empty field, and binary
+// data base64 encoded.
+func csvValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestExport(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	cp := &ConnectionPool{connections: map[string]*Connection{"multi": conn}}
+	cp.conf.Store(&Config{})
+
+	tests := []struct {
+		format string
+		rows   int
+	}{
+		{"xlsx", 3},
+		{"parquet", 2},
+	}
+	for _, test := range tests {
+		info, err := cp.Export(context.Background(), "multi", "SELECT a; UPDATE t; SELECT b", test.format, "")
+		switch {
+		case err != nil:
+			t.Errorf("%s: expected no error, got: %v", test.format, err)
+		case info.RowCount != test.rows || info.Bytes != len(info.Data) || len(info.Data) == 0:
+			t.Errorf("%s: expected %d rows and data, got: %d rows, %d bytes", test.format, test.rows, info.RowCount, len(info.Data))
+		}
+	}
+	if _, err := cp.Export(context.Background(), "multi", "SELECT a", "yaml", ""); err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
+
+func TestWriteRows(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	format := func(row []interface{}) []interface{} { return row }
+
+	tests := []struct {
+		format string
+		write  func(*bytes.Buffer, *RowIterator) error
+	}{
+		{"csv", func(buf *bytes.Buffer, it *RowIterator) error { return writeCSV(buf, it, format) }},
+		{"json", func(buf *bytes.Buffer, it *RowIterator) error { return writeJSONRows(buf, it, format) }},
+	}
+	for _, test := range tests {
+		it, err := conn.QueryRows(context.Background(), "SELECT a; UPDATE t; SELECT b")
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %v", test.format, err)
+		}
+		var buf bytes.Buffer
+		err = test.write(&buf, it)
+		it.Close()
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %v", test.format, err)
+		}
+		if test.format == "csv" {
+			if exp := "a\n1\n2\n\nb\nx\n"; buf.String() != exp {
+				t.Errorf("csv: expected %q, got: %q", exp, buf.String())
+			}
+			continue
+		}
+		var result QueryResult
+		switch err := json.Unmarshal(buf.Bytes(), &result); {
+		case err != nil:
+			t.Errorf("json: expected valid JSON, got: %v\n%s", err, buf.String())
+		case len(result.Rows) != 2 || len(result.MoreResultSets) != 1 || len(result.MoreResultSets[0].Rows) != 1:
+			t.Errorf("json: expected 2 rows and 1 more result set, got: %s", buf.String())
+		}
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	cp := &ConnectionPool{}
+	cp.conf.Store(&Config{})
+	if _, err := cp.writeExport("a.parquet", []byte("x")); err == nil {
+		t.Errorf("expected error when the export directory is not set")
+	}
+
+	dir := t.TempDir()
+	cp.conf.Store(&Config{Server: ServerConfig{ExportDir: dir}})
+	for _, path := range []string{"../a.parquet", "/tmp/a.parquet", ""} {
+		if _, err := cp.writeExport(path, []byte("x")); err == nil {
+			t.Errorf("expected error for path %q", path)
+		}
+	}
+	name, err := cp.writeExport("sub/a.parquet", []byte("x"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if exp := filepath.Join(dir, "sub", "a.parquet"); name != exp {
+		t.Errorf("expected %s, got: %s", exp, name)
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != "x" {
+		t.Errorf("expected file content x, got: %q (%v)", b, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+		t.Errorf("expected only the exported file, got: %v", entries)
+	}
+}
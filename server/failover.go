@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+)
+
+// failoverTimeout bounds connecting to each failover DSN.
+const failoverTimeout = 30 * time.Second
+
+// FailoverEvent records a connection failing over to another of its DSNs.
+type FailoverEvent struct {
+	Type         string    `json:"type"`
+	Time         time.Time `json:"time"`
+	ConnectionID string    `json:"connection_id"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// OnFailover sets f to be called once a connection failed over to another
+// of its DSNs.
+func (cp *ConnectionPool) OnFailover(f func(FailoverEvent)) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.failedOver = f
+}
+
+// failoverAll fails the connections with failover DSNs over, once their
+// health checks failed failover_after times in a row.
+func (cp *ConnectionPool) failoverAll() {
+	after := cp.config().Server.FailoverAfter
+	if after <= 0 {
+		return
+	}
+	cp.mu.RLock()
+	var failing []*Connection
+	for _, conn := range cp.connections {
+		if len(conn.failoverDSNs()) == 0 {
+			continue
+		}
+		conn.monitor.mu.Lock()
+		if conn.monitor.failures >= after {
+			failing = append(failing, conn)
+		}
+		conn.monitor.mu.Unlock()
+	}
+	cp.mu.RUnlock()
+	for _, conn := range failing {
+		cp.failover(conn)
+	}
+}
+
+// failover connects to the connection's next DSN that accepts connections,
+// in order and wrapping around to its own DSN, and replaces the connection
+// with it under the same ID, keeping its aliases, tags and replicas.
+func (cp *ConnectionPool) failover(conn *Connection) {
+	failover := conn.failoverDSNs()
+	dsns := append([]string{conn.dsn}, failover...)
+	cause := conn.monitor.lastError()
+	for i := 1; i < len(dsns); i++ {
+		next := (conn.dsnIndex + i) % len(dsns)
+		ctx, cancel := context.WithTimeout(context.Background(), failoverTimeout)
+		replacement, err := cp.open(ctx, conn.ID, dsns[next], connOptions{
+			predefined: conn.predefined,
+			settings:   conn.settings,
+			tls:        conn.tls,
+			failover:   failover,
+		})
+		cancel()
+		if err != nil {
+			poolLog.Warn("connection failed to fail over", "connection", conn.ID, "dsn", next+1, "error", err)
+			continue
+		}
+		replacement.dsnIndex = next
+		if !cp.promote(conn, replacement) {
+			replacement.closeDB()
+			return
+		}
+		event := FailoverEvent{
+			Type:         "failover",
+			Time:         time.Now(),
+			ConnectionID: conn.ID,
+			From:         conn.URL.Short(),
+			To:           replacement.URL.Short(),
+			Error:        cause,
+		}
+		poolLog.Warn("connection failed over", "connection", event.ConnectionID, "from", event.From, "to", event.To)
+		detail := fmt.Sprintf("from %s to %s", event.From, event.To)
+		if cause != "" {
+			detail += " after: " + cause
+		}
+		cp.auditConnection(context.Background(), audit.ConnectionFailover, conn.ID, event.Time, nil, detail)
+		cp.mu.RLock()
+		f := cp.failedOver
+		cp.mu.RUnlock()
+		if f != nil {
+			f(event)
+		}
+		return
+	}
+}
+
+// promote replaces the connection in the pool with the replacement, unless
+// it was closed, renamed or replaced meanwhile, returning whether it was
+// replaced. The connection's open cursors and cached results are discarded,
+// and its database closed.
+func (cp *ConnectionPool) promote(conn, replacement *Connection) bool {
+	cp.mu.Lock()
+	if cp.connections[conn.ID] != conn {
+		cp.mu.Unlock()
+		return false
+	}
+	conn.mu.RLock()
+	replacement.dsn = conn.dsn
+	replacement.failover = conn.failover
+	replacement.tags = conn.tags
+	replacement.Created = conn.Created
+	replacement.LastUsed = conn.LastUsed
+	conn.mu.RUnlock()
+	replacement.lazy, replacement.predefined = conn.lazy, conn.predefined
+	conn.replicaMu.Lock()
+	replacement.replicas, conn.replicas = conn.replicas, nil
+	conn.replicaMu.Unlock()
+	cp.connections[conn.ID] = replacement
+	cp.cursors.CloseConnection(conn.ID)
+	cp.cache.Invalidate(conn.ID)
+	cp.mu.Unlock()
+
+	// queries still running on the connection finish before it's closed
+	conn.closeDB()
+	return true
+}
+
+// lastError returns the error of the last failed health check.
+func (h *connHealth) lastError() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// SetFailover sets the DSNs the connection with the ID (or alias) fails over
+// to, in order, which must be of the connection's driver.
+func (cp *ConnectionPool) SetFailover(id string, dsns []string) error {
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("connection with ID %s not found", id)
+	}
+	for i, dsn := range dsns {
+		switch info := ValidateDSN(dsn); {
+		case !info.Valid:
+			return fmt.Errorf("failover DSN %d: %s", i+1, info.Error)
+		case info.Driver != conn.URL.Driver:
+			return fmt.Errorf("failover DSN %d: driver %s does not match connection driver %s", i+1, info.Driver, conn.URL.Driver)
+		}
+	}
+
+	conn.mu.Lock()
+	conn.failover = slices.Clone(dsns)
+	conn.mu.Unlock()
+
+	cp.redefined()
+	return nil
+}
+
+// failoverDSNs returns the DSNs the connection fails over to.
+func (conn *Connection) failoverDSNs() []string {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.failover
+}
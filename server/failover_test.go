@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+func TestFailover(t *testing.T) {
+	hosts := map[string]*pingConnector{"a": new(pingConnector), "b": new(pingConnector)}
+	dburl.Register(dburl.Scheme{Driver: "failover-test", Generator: dburl.GenScheme("failover-test"), Transport: dburl.TransportTCP})
+	drivers.Register("failover-test", drivers.Driver{
+		Open: func(_ context.Context, u *dburl.URL, _, _ func() io.Writer) (func(string, string) (*sql.DB, error), error) {
+			return func(string, string) (*sql.DB, error) {
+				return sql.OpenDB(hosts[u.Hostname()]), nil
+			}, nil
+		},
+		Version: func(context.Context, drivers.DB) (string, error) {
+			return "1.0", nil
+		},
+	})
+	cp := NewConnectionPool(&Config{Server: ServerConfig{MaxConnections: 10, FailoverAfter: 2}}, nil, nil)
+	defer cp.Close()
+	var events []FailoverEvent
+	cp.OnFailover(func(event FailoverEvent) { events = append(events, event) })
+
+	// a connection failing to connect connects to its failover DSNs
+	hosts["a"].down.Store(true)
+	conn, err := cp.create(context.Background(), "db", "failover-test://a/app", connOptions{failover: []string{"failover-test://b/app"}})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case conn.URL.Hostname() != "b" || conn.dsnIndex != 1 || conn.dsn != "failover-test://a/app":
+		t.Fatalf("expected the connection to connect to its failover DSN, got: %s %d %s", conn.URL.Hostname(), conn.dsnIndex, conn.dsn)
+	}
+	if err := cp.SetTags("db", map[string]string{"env": "test"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// the connection fails over once its health checks failed failover_after
+	// times, wrapping around to its own DSN
+	hosts["a"].down.Store(false)
+	hosts["b"].down.Store(true)
+	conn.monitor.mu.Lock()
+	conn.monitor.failures, conn.monitor.err = 1, "connection refused"
+	conn.monitor.mu.Unlock()
+	cp.failoverAll()
+	if cp.connections["db"] != conn {
+		t.Fatalf("expected no failover before failover_after failures")
+	}
+	conn.monitor.mu.Lock()
+	conn.monitor.failures = 2
+	conn.monitor.mu.Unlock()
+	cp.failoverAll()
+	promoted := cp.connections["db"]
+	switch {
+	case promoted == conn:
+		t.Fatalf("expected the connection to fail over")
+	case promoted.URL.Hostname() != "a" || promoted.dsnIndex != 0:
+		t.Errorf("expected the connection to fail over to its own DSN, got: %s %d", promoted.URL.Hostname(), promoted.dsnIndex)
+	case promoted.tags["env"] != "test" || len(promoted.failover) != 1:
+		t.Errorf("expected the connection to keep its tags and failover DSNs, got: %v %v", promoted.tags, promoted.failover)
+	case !conn.closed:
+		t.Errorf("expected the failed connection to be closed")
+	}
+	if len(events) != 1 || events[0].ConnectionID != "db" || events[0].Error != "connection refused" {
+		t.Errorf("expected a failover event, got: %+v", events)
+	}
+
+	// failover DSNs are of the connection's driver
+	if err := cp.SetFailover("db", []string{"postgres://b/app"}); err == nil {
+		t.Errorf("expected an error for a DSN of another driver")
+	}
+	if err := cp.SetFailover("db", []string{"failover-test://b/app", "failover-test://c/app"}); err != nil || len(cp.Definitions()[0].Failover) != 2 {
+		t.Errorf("expected the failover DSNs to be set, got: %v", err)
+	}
+}
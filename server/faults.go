@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the error wrapped by all injected faults.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultInjector simulates timeouts, dropped connections and slow responses
+// on a per-connection basis.
+type FaultInjector struct {
+	mu        sync.RWMutex
+	defaults  FaultConfig
+	overrides map[string]FaultConfig
+
+	// random returns the number in [0, 1) choosing the fault injected
+	random func() float64
+}
+
+// NewFaultInjector creates a new fault injector using the passed config as
+// the default for all connections.
+func NewFaultInjector(config FaultConfig) *FaultInjector {
+	return &FaultInjector{
+		defaults:  config,
+		overrides: make(map[string]FaultConfig),
+		random:    rand.Float64,
+	}
+}
+
+// Get returns the fault configuration for a connection.
+func (fi *FaultInjector) Get(id string) FaultConfig {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	if config, ok := fi.overrides[id]; ok {
+		return config
+	}
+	return fi.defaults
+}
+
+// Set overrides the fault configuration for a connection.
+func (fi *FaultInjector) Set(id string, config FaultConfig) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.overrides[id] = config
+}
+
+// Reset removes any fault configuration override for a connection.
+func (fi *FaultInjector) Reset(id string) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	delete(fi.overrides, id)
+}
+
+// Inject injects a fault for the connection according to its configuration.
+// Slow responses only delay the caller, while timeouts and dropped
+// connections are returned as errors.
+func (fi *FaultInjector) Inject(ctx context.Context, id string) error {
+	config := fi.Get(id)
+	if !config.Enabled {
+		return nil
+	}
+
+	switch n := fi.random(); {
+	case n < config.TimeoutRate:
+		// Hold the request until the client gives up, like a hung database
+		if _, ok := ctx.Deadline(); ok {
+			<-ctx.Done()
+		}
+		return fmt.Errorf("%w: %w", ErrInjectedFault, context.DeadlineExceeded)
+	case n < config.TimeoutRate+config.DropRate:
+		return fmt.Errorf("%w: connection dropped: %w", ErrInjectedFault, driver.ErrBadConn)
+	case n < config.TimeoutRate+config.DropRate+config.SlowRate:
+		select {
+		case <-time.After(config.SlowDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestFaultInjector(t *testing.T) {
+	fi := NewFaultInjector(FaultConfig{Enabled: true, TimeoutRate: 0.2, DropRate: 0.3, SlowRate: 0.1, SlowDelay: 20 * time.Millisecond})
+	tests := []struct {
+		n    float64
+		err  error
+		slow bool
+	}{
+		{0, context.DeadlineExceeded, false},
+		{0.19, context.DeadlineExceeded, false},
+		{0.2, driver.ErrBadConn, false},
+		{0.49, driver.ErrBadConn, false},
+		{0.5, nil, true},
+		{0.59, nil, true},
+		{0.6, nil, false},
+		{0.99, nil, false},
+	}
+	for _, test := range tests {
+		fi.random = func() float64 { return test.n }
+		start := time.Now()
+		err := fi.Inject(context.Background(), "a")
+		switch elapsed := time.Since(start); {
+		case test.err == nil && err != nil:
+			t.Errorf("%v: expected no error, got: %v", test.n, err)
+		case test.err != nil && (!errors.Is(err, ErrInjectedFault) || !errors.Is(err, test.err)):
+			t.Errorf("%v: expected an injected %v, got: %v", test.n, test.err, err)
+		case test.slow != (elapsed >= 20*time.Millisecond):
+			t.Errorf("%v: expected slow %t, took: %v", test.n, test.slow, elapsed)
+		}
+	}
+
+	// timeouts hold the query until the context is done, and slow responses
+	// are cut short by it
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fi.random = func() float64 { return 0 }
+	if err := fi.Inject(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) || ctx.Err() == nil {
+		t.Errorf("expected the timeout to last until the deadline, got: %v", err)
+	}
+	fi.random = func() float64 { return 0.5 }
+	if err := fi.Inject(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrInjectedFault) {
+		t.Errorf("expected the slow response to end with the context, got: %v", err)
+	}
+
+	// faults are injected in the configured proportions
+	fi = NewFaultInjector(FaultConfig{Enabled: true, TimeoutRate: 0.1, DropRate: 0.25})
+	fi.random = rand.New(rand.NewPCG(1, 2)).Float64
+	var timeouts, drops int
+	for range 10000 {
+		switch err := fi.Inject(context.Background(), "a"); {
+		case errors.Is(err, context.DeadlineExceeded):
+			timeouts++
+		case errors.Is(err, driver.ErrBadConn):
+			drops++
+		}
+	}
+	if timeouts < 900 || timeouts > 1100 || drops < 2300 || drops > 2700 {
+		t.Errorf("expected about 1000 timeouts and 2500 drops, got: %d %d", timeouts, drops)
+	}
+
+	// no faults are injected when disabled
+	fi = NewFaultInjector(FaultConfig{TimeoutRate: 1})
+	fi.random = func() float64 { return 0 }
+	if err := fi.Inject(context.Background(), "a"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestFaultOverrides(t *testing.T) {
+	defaults := FaultConfig{Enabled: true, SlowRate: 0.5, SlowDelay: time.Second}
+	fi := NewFaultInjector(defaults)
+	fi.random = func() float64 { return 0 }
+	override := FaultConfig{Enabled: true, DropRate: 1}
+	fi.Set("b", override)
+	if config := fi.Get("a"); config != defaults {
+		t.Errorf("expected the defaults, got: %+v", config)
+	}
+	if config := fi.Get("b"); config != override {
+		t.Errorf("expected the override, got: %+v", config)
+	}
+	if err := fi.Inject(context.Background(), "b"); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected a dropped connection, got: %v", err)
+	}
+
+	// connections can be excluded from faults enabled by default
+	fi.Set("c", FaultConfig{})
+	if err := fi.Inject(context.Background(), "c"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	fi.Reset("b")
+	if config := fi.Get("b"); config != defaults {
+		t.Errorf("expected the defaults once reset, got: %+v", config)
+	}
+}
+
+func TestConnectionFaults(t *testing.T) {
+	s, err := New(&Config{Server: ServerConfig{EnableAdmin: true, RequestTimeout: time.Minute}, Faults: FaultConfig{SlowDelay: 2 * time.Second}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: s.pool.Faults(), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	s.pool.connections[conn.ID] = conn
+	mux := http.NewServeMux()
+	s.registerAdmin(mux)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		exp    faultSettings
+	}{
+		{"GET", "/admin/connections/multi/faults", "", http.StatusOK, faultSettings{SlowDelay: "2s"}},
+		{"PUT", "/admin/connections/multi/faults", `{"enabled":true,"drop_rate":1}`, http.StatusOK, faultSettings{Enabled: true, DropRate: 1, SlowDelay: "0s"}},
+		{"GET", "/admin/connections/multi/faults", "", http.StatusOK, faultSettings{Enabled: true, DropRate: 1, SlowDelay: "0s"}},
+		{"PUT", "/admin/connections/multi/faults", `{"enabled":true,"slow_rate":0.5,"slow_delay":"1s"}`, http.StatusOK, faultSettings{Enabled: true, SlowRate: 0.5, SlowDelay: "1s"}},
+		{"PUT", "/admin/connections/multi/faults", `{"drop_rate":1.5}`, http.StatusBadRequest, faultSettings{}},
+		{"PUT", "/admin/connections/multi/faults", `{"drop_rate":0.6,"timeout_rate":0.6}`, http.StatusBadRequest, faultSettings{}},
+		{"PUT", "/admin/connections/multi/faults", `{"slow_delay":"soon"}`, http.StatusBadRequest, faultSettings{}},
+		{"PUT", "/admin/connections/multi/faults", `{`, http.StatusBadRequest, faultSettings{}},
+		{"POST", "/admin/connections/multi/faults", "", http.StatusMethodNotAllowed, faultSettings{}},
+		{"GET", "/admin/connections/missing/faults", "", http.StatusNotFound, faultSettings{}},
+		{"GET", "/admin/connections/multi/faults", "", http.StatusOK, faultSettings{Enabled: true, SlowRate: 0.5, SlowDelay: "1s"}},
+		{"DELETE", "/admin/connections/multi/faults", "", http.StatusOK, faultSettings{SlowDelay: "2s"}},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+		if w.Code != test.code {
+			t.Errorf("%s %s %s: expected %d, got: %d", test.method, test.path, test.body, test.code, w.Code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var settings faultSettings
+		if err := json.Unmarshal(w.Body.Bytes(), &settings); err != nil || settings != test.exp {
+			t.Errorf("%s %s %s: expected %+v, got: %+v %v", test.method, test.path, test.body, test.exp, settings, err)
+		}
+	}
+
+	// queries fail while the connection drops them
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/admin/connections/multi/faults", strings.NewReader(`{"enabled":true,"drop_rate":1}`)))
+	if _, err := conn.ExecuteQuery(context.Background(), "SELECT a"); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("expected an injected fault, got: %v", err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/connections/multi/faults", nil))
+	if _, err := conn.ExecuteQuery(context.Background(), "SELECT a"); err != nil {
+		t.Errorf("expected no error once the faults are cleared, got: %v", err)
+	}
+}
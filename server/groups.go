@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Routing of connection groups.
+const (
+	GroupRoundRobin  = "round-robin"
+	GroupLeastLoaded = "least-loaded"
+)
+
+// group returns the config of the group with the ID.
+func (cp *ConnectionPool) group(id string) (*GroupConfig, bool) {
+	groups := cp.config().Groups
+	for i := range groups {
+		if groups[i].ID == id {
+			return &groups[i], true
+		}
+	}
+	return nil, false
+}
+
+// IsGroup returns whether the ID is of a connection group.
+func (cp *ConnectionPool) IsGroup(id string) bool {
+	_, ok := cp.group(id)
+	return ok
+}
+
+// route returns the connection with the ID or alias, or the member of the
+// group with the ID a query is routed to. Members in the pool and in
+// rotation are chosen round-robin, or as the member running the fewest
+// queries, in turn when several do. The lock must be held.
+func (cp *ConnectionPool) route(id string) (*Connection, bool) {
+	g, ok := cp.group(id)
+	if !ok {
+		return cp.lookup(id)
+	}
+	now := time.Now()
+	var members, up []*Connection
+	for _, member := range g.Members {
+		conn, ok := cp.lookup(member)
+		if !ok {
+			continue
+		}
+		members = append(members, conn)
+		if ok, _ := conn.health.up(now); ok {
+			up = append(up, conn)
+		}
+	}
+	if len(up) == 0 {
+		// every member is down, so try any of them
+		up = members
+	}
+	if len(up) == 0 {
+		return nil, false
+	}
+
+	v, _ := cp.rotations.LoadOrStore(g.ID, new(atomic.Uint64))
+	n := int(v.(*atomic.Uint64).Add(1) % uint64(len(up)))
+	if g.Routing != GroupLeastLoaded {
+		return up[n], true
+	}
+	best := up[n]
+	for i := range up {
+		if conn := up[(n+i)%len(up)]; conn.active.Load() < best.active.Load() {
+			best = conn
+		}
+	}
+	return best, true
+}
+
+// inGroup returns whether the connection with the ID is a member of the
+// group with the ID.
+func (cp *ConnectionPool) inGroup(groupID, id string) bool {
+	g, ok := cp.group(groupID)
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(g.Members, func(member string) bool {
+		return cp.Resolve(member) == id
+	})
+}
+
+// validateGroups validates the connection groups, whose IDs must be unique
+// and not those of predefined connections, which have members, and whose
+// routing is valid.
+func validateGroups(groups []GroupConfig, connections []ConnectionConfig) error {
+	names := make(map[string]bool)
+	for _, c := range connections {
+		for _, name := range append([]string{c.ID}, c.Aliases...) {
+			names[name] = true
+		}
+	}
+	for _, g := range groups {
+		switch {
+		case g.ID == "":
+			return fmt.Errorf("group id is empty")
+		case names[g.ID]:
+			return fmt.Errorf("group %s: %s is defined more than once", g.ID, g.ID)
+		case len(g.Members) == 0:
+			return fmt.Errorf("group %s: members are empty", g.ID)
+		case slices.Contains(g.Members, g.ID):
+			return fmt.Errorf("group %s: a group can't be its own member", g.ID)
+		}
+		switch g.Routing {
+		case "", GroupRoundRobin, GroupLeastLoaded:
+		default:
+			return fmt.Errorf("group %s: invalid routing %q: must be %s or %s", g.ID, g.Routing, GroupRoundRobin, GroupLeastLoaded)
+		}
+		names[g.ID] = true
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestGroupRouting(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	cp := NewConnectionPool(&Config{Groups: []GroupConfig{
+		{ID: "replicas", Members: []string{"a", "b", "c", "missing"}},
+		{ID: "balanced", Members: []string{"a", "b", "c"}, Routing: GroupLeastLoaded},
+	}}, nil, nil)
+	defer cp.Close()
+	for _, id := range []string{"a", "b", "c"} {
+		cp.connections[id] = &Connection{ID: id, URL: u, DB: sql.OpenDB(multiConnector{})}
+	}
+
+	routed := func(id string, n int) map[string]int {
+		counts := make(map[string]int)
+		for range n {
+			conn, ok := cp.route(id)
+			if !ok {
+				t.Fatalf("expected %s to be routed", id)
+			}
+			counts[conn.ID]++
+		}
+		return counts
+	}
+	if counts := routed("replicas", 6); counts["a"] != 2 || counts["b"] != 2 || counts["c"] != 2 {
+		t.Errorf("expected queries routed round-robin, got: %v", counts)
+	}
+	// members out of rotation are skipped
+	cp.connections["b"].health.downUntil = time.Now().Add(time.Minute)
+	if counts := routed("replicas", 4); counts["b"] != 0 {
+		t.Errorf("expected b to be skipped, got: %v", counts)
+	}
+	cp.connections["b"].health.downUntil = time.Time{}
+
+	// the least loaded member is chosen
+	defer cp.connections["a"].use(func() {})()
+	defer cp.connections["c"].use(func() {})()
+	if counts := routed("balanced", 3); counts["b"] != 3 {
+		t.Errorf("expected queries routed to b, got: %v", counts)
+	}
+
+	if conn, ok := cp.route("a"); !ok || conn.ID != "a" {
+		t.Errorf("expected connection a, got: %v", conn)
+	}
+	if !cp.inGroup("replicas", "c") || cp.inGroup("replicas", "d") {
+		t.Errorf("expected c to be the only member")
+	}
+	if _, err := cp.create(t.Context(), "replicas", "postgres://localhost/db", connOptions{lazy: true}); err == nil {
+		t.Errorf("expected an error creating a connection with a group's ID")
+	}
+
+	for _, groups := range [][]GroupConfig{
+		{{ID: "", Members: []string{"a"}}},
+		{{ID: "g"}},
+		{{ID: "g", Members: []string{"a"}, Routing: "random"}},
+		{{ID: "g", Members: []string{"a"}}, {ID: "g", Members: []string{"b"}}},
+		{{ID: "prod", Members: []string{"a"}}},
+	} {
+		if err := validateGroups(groups, []ConnectionConfig{{ID: "prod"}}); err == nil {
+			t.Errorf("expected an error for %+v", groups)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// healthCheckTimeout bounds each health check's ping.
+	healthCheckTimeout = 5 * time.Second
+
+	// defaultMaxIdleConns is database/sql's default number of idle
+	// connections kept per database.
+	defaultMaxIdleConns = 2
+)
+
+// connHealth is the state of a connection's periodic health checks.
+type connHealth struct {
+	mu sync.Mutex
+	// checked is whether the connection was checked at all
+	checked   bool
+	healthy   bool
+	checkedAt time.Time
+	err       string
+	// failures is the number of consecutive failed checks
+	failures    int
+	checks      int64
+	reconnects  int64
+	nextAttempt time.Time
+	// lastErr is the error of the last failed check, kept once reconnected
+	lastErr   string
+	lastErrAt time.Time
+}
+
+// HealthInfo describes the state of a connection's health checks.
+type HealthInfo struct {
+	Healthy     bool       `json:"healthy"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	Error       string     `json:"error,omitempty"`
+	Failures    int        `json:"failures"`
+	Checks      int64      `json:"checks"`
+	Reconnects  int64      `json:"reconnects"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// monitor periodically checks the health of the pool's connections and
+// their replicas until the pool is closed.
+func (cp *ConnectionPool) monitor() {
+	ticker := time.NewTicker(cp.config().Server.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.stop:
+			return
+		case now := <-ticker.C:
+			cp.checkHealth(now)
+		}
+	}
+}
+
+// checkHealth checks the health of the pool's connections and their
+// replicas concurrently, at now.
+func (cp *ConnectionPool) checkHealth(now time.Time) {
+	cp.mu.RLock()
+	var instances []*Connection
+	for _, conn := range cp.connections {
+		// connections not yet dialed have nothing to check
+		if !conn.pending.Load() {
+			instances = append(instances, conn)
+		}
+		instances = append(instances, conn.replicaList()...)
+	}
+	cp.mu.RUnlock()
+
+	config := cp.config().Server
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inst.checkHealth(now, config.HealthCheckInterval, config.ReconnectMaxBackoff)
+		}()
+	}
+	wg.Wait()
+	cp.failoverAll()
+}
+
+// checkHealth pings the connection, marking it unhealthy when the ping
+// fails. Unhealthy connections are reconnected, discarding their idle
+// database connections, with exponential backoff from interval up to
+// maxBackoff between attempts. Unhealthy replicas are taken out of rotation
+// until reconnected.
+func (conn *Connection) checkHealth(now time.Time, interval, maxBackoff time.Duration) {
+	h := &conn.monitor
+	h.mu.Lock()
+	wasHealthy := !h.checked || h.healthy
+	if !wasHealthy && now.Before(h.nextAttempt) {
+		h.mu.Unlock()
+		return
+	}
+	h.mu.Unlock()
+
+	if !wasHealthy {
+		// idle database connections are likely broken, so are closed rather
+		// than reused by the ping
+		conn.DB.SetMaxIdleConns(0)
+		conn.DB.SetMaxIdleConns(conn.settings.maxIdle())
+		conn.stmts.Reset()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	err := conn.DB.PingContext(ctx)
+	cancel()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checked, h.checkedAt = true, now
+	h.checks++
+	if err == nil {
+		if !wasHealthy {
+			h.reconnects++
+			poolLog.Info("connection reconnected", "connection", conn.ID, "host", conn.URL.Host, "failed_checks", h.failures)
+			conn.health.restore()
+		}
+		h.healthy, h.err, h.failures, h.nextAttempt = true, "", 0, time.Time{}
+		return
+	}
+	if wasHealthy {
+		poolLog.Warn("connection is unhealthy", "connection", conn.ID, "host", conn.URL.Host, "error", err)
+	}
+	h.healthy, h.err = false, err.Error()
+	h.lastErr, h.lastErrAt = h.err, now
+	h.failures++
+	h.nextAttempt = now.Add(backoff(interval, maxBackoff, h.failures))
+	conn.health.down(h.nextAttempt)
+}
+
+// Health returns the state of the connection's health checks, or nil when
+// it was not checked.
+func (conn *Connection) Health() *HealthInfo {
+	h := &conn.monitor
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.checked {
+		return nil
+	}
+	info := &HealthInfo{
+		Healthy:    h.healthy,
+		CheckedAt:  h.checkedAt,
+		Error:      h.err,
+		Failures:   h.failures,
+		Checks:     h.checks,
+		Reconnects: h.reconnects,
+	}
+	if !h.healthy {
+		nextAttempt := h.nextAttempt
+		info.NextAttempt = &nextAttempt
+	}
+	return info
+}
+
+// backoff returns the delay before the next reconnect attempt after the
+// failures, doubling from interval up to max. Reconnects are attempted at
+// every interval when max is not greater than interval.
+func backoff(interval, max time.Duration, failures int) time.Duration {
+	d := interval
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max && max > interval {
+		d = max
+	}
+	return d
+}
+
+// down takes the instance out of rotation until the time.
+func (h *instanceHealth) down(until time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if until.After(h.downUntil) {
+		h.downUntil = until
+	}
+}
+
+// restore puts the instance back in rotation.
+func (h *instanceHealth) restore() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.downUntil = time.Time{}
+}
+
+// Connection statuses of the detailed health check.
+const (
+	StatusHealthy   = "healthy"
+	StatusUnhealthy = "unhealthy"
+	StatusPending   = "pending"
+)
+
+// ConnectionStatus is the status of a connection in the detailed health
+// check: whether it answered a ping, and how fast, the error of its last
+// failed check, and its load. Pending connections are not pinged.
+type ConnectionStatus struct {
+	ID            string     `json:"id"`
+	Driver        string     `json:"driver"`
+	Status        string     `json:"status"`
+	LatencyMS     float64    `json:"latency_ms"`
+	Error         string     `json:"error,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	ActiveQueries int64      `json:"active_queries"`
+	Transactions  int        `json:"transactions"`
+	InUse         int        `json:"in_use"`
+	Idle          int        `json:"idle"`
+	MaxOpen       int        `json:"max_open"`
+	WaitCount     int64      `json:"wait_count"`
+	// Saturation is the share of the maximum open database connections in
+	// use, when limited
+	Saturation *float64 `json:"saturation,omitempty"`
+}
+
+// PoolHealth is the load of the pool in the detailed health check.
+type PoolHealth struct {
+	Connections    int     `json:"connections"`
+	MaxConnections int     `json:"max_connections"`
+	Saturation     float64 `json:"saturation"`
+	Sessions       int     `json:"sessions"`
+	Transactions   int     `json:"transactions"`
+	Cursors        int     `json:"cursors"`
+}
+
+// HealthDetails pings the pool's connections concurrently, and returns the
+// load of the pool and the status of its connections, ordered by ID.
+func (cp *ConnectionPool) HealthDetails(ctx context.Context) (PoolHealth, []ConnectionStatus) {
+	cp.mu.RLock()
+	conns := make([]*Connection, 0, len(cp.connections))
+	for _, conn := range cp.connections {
+		conns = append(conns, conn)
+	}
+	cp.mu.RUnlock()
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+
+	transactions := cp.sessions.transactionsByConnection()
+	statuses := make([]ConnectionStatus, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = conn.status(ctx)
+			statuses[i].Transactions = transactions[conn.ID]
+		}()
+	}
+	wg.Wait()
+
+	sessions, txs := cp.sessions.counts()
+	pool := PoolHealth{
+		Connections:    len(conns),
+		MaxConnections: cp.config().Server.MaxConnections,
+		Sessions:       sessions,
+		Transactions:   txs,
+		Cursors:        cp.cursors.size(),
+	}
+	if pool.MaxConnections > 0 {
+		pool.Saturation = float64(pool.Connections) / float64(pool.MaxConnections)
+	}
+	return pool, statuses
+}
+
+// status pings the connection, unless pending, and returns its status.
+func (conn *Connection) status(ctx context.Context) ConnectionStatus {
+	s := ConnectionStatus{
+		ID:            conn.ID,
+		Driver:        conn.URL.Driver,
+		Status:        StatusPending,
+		ActiveQueries: conn.active.Load(),
+	}
+	h := &conn.monitor
+	h.mu.Lock()
+	if h.lastErr != "" {
+		lastErrAt := h.lastErrAt
+		s.LastError, s.LastErrorAt = h.lastErr, &lastErrAt
+	}
+	h.mu.Unlock()
+	// registered connections are yet to open their database
+	if conn.pending.Load() {
+		return s
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	start := time.Now()
+	err := conn.DB.PingContext(ctx)
+	s.LatencyMS = ms(time.Since(start))
+	cancel()
+	s.Status = StatusHealthy
+	if err != nil {
+		s.Status, s.Error = StatusUnhealthy, err.Error()
+	}
+
+	stats := conn.DB.Stats()
+	s.InUse, s.Idle, s.MaxOpen, s.WaitCount = stats.InUse, stats.Idle, stats.MaxOpenConnections, stats.WaitCount
+	if s.MaxOpen > 0 {
+		saturation := float64(s.InUse) / float64(s.MaxOpen)
+		s.Saturation = &saturation
+	}
+	return s
+}
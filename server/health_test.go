@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestCheckHealth(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	pc := new(pingConnector)
+	conn := &Connection{ID: "db", URL: u, DB: sql.OpenDB(pc)}
+	defer conn.DB.Close()
+
+	start := time.Now()
+	tests := []struct {
+		at      time.Duration
+		down    bool
+		healthy bool
+		checks  int64
+		// failures and reconnects after the check
+		failures   int
+		reconnects int64
+		next       time.Duration
+	}{
+		{0, false, true, 1, 0, 0, 0},
+		{time.Second, true, false, 2, 1, 0, 2 * time.Second},
+		// skipped until the next attempt
+		{1500 * time.Millisecond, true, false, 2, 1, 0, 2 * time.Second},
+		{2 * time.Second, true, false, 3, 2, 0, 4 * time.Second},
+		{4 * time.Second, false, true, 4, 0, 1, 0},
+	}
+	for i, test := range tests {
+		pc.down.Store(test.down)
+		now := start.Add(test.at)
+		conn.checkHealth(now, time.Second, 4*time.Second)
+		h := conn.Health()
+		switch {
+		case h == nil:
+			t.Fatalf("test %d: expected health, got nil", i)
+		case h.Healthy != test.healthy || h.Checks != test.checks || h.Failures != test.failures || h.Reconnects != test.reconnects:
+			t.Errorf("test %d: expected healthy %t after %d checks with %d failures and %d reconnects, got: %+v", i, test.healthy, test.checks, test.failures, test.reconnects, h)
+		case test.healthy != (h.NextAttempt == nil):
+			t.Errorf("test %d: expected a next attempt only when unhealthy, got: %v", i, h.NextAttempt)
+		case h.NextAttempt != nil && !h.NextAttempt.Equal(start.Add(test.next)):
+			t.Errorf("test %d: expected the next attempt at %v, got: %v", i, test.next, h.NextAttempt.Sub(start))
+		}
+		if up, _ := conn.health.up(now); up != test.healthy {
+			t.Errorf("test %d: expected in rotation %t, got: %t", i, test.healthy, up)
+		}
+	}
+}
+
+func TestHealthDetails(t *testing.T) {
+	cp := NewConnectionPool(&Config{Server: ServerConfig{MaxConnections: 4}}, nil, nil)
+	defer cp.Close()
+	u, _ := dburl.Parse("postgres://localhost/db")
+	up, down := new(pingConnector), new(pingConnector)
+	down.down.Store(true)
+	cp.connections["a"] = &Connection{ID: "a", URL: u, DB: sql.OpenDB(up)}
+	cp.connections["b"] = &Connection{ID: "b", URL: u, DB: sql.OpenDB(down)}
+	cp.connections["c"] = &Connection{ID: "c", URL: u, DB: sql.OpenDB(up)}
+	cp.connections["c"].pending.Store(true)
+	cp.connections["b"].checkHealth(time.Now(), time.Second, time.Second)
+	cp.connections["a"].DB.SetMaxOpenConns(2)
+
+	pool, statuses := cp.HealthDetails(context.Background())
+	if pool.Connections != 3 || pool.MaxConnections != 4 || pool.Saturation != 0.75 {
+		t.Errorf("expected the pool's load, got: %+v", pool)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 statuses, got: %+v", statuses)
+	}
+	if a := statuses[0]; a.ID != "a" || a.Status != StatusHealthy || a.Error != "" || a.Idle != 1 || a.MaxOpen != 2 || a.Saturation == nil || *a.Saturation != 0 {
+		t.Errorf("expected a healthy, got: %+v", a)
+	}
+	if b := statuses[1]; b.Status != StatusUnhealthy || b.Error == "" || b.LastError == "" || b.LastErrorAt == nil || b.Saturation != nil {
+		t.Errorf("expected b unhealthy, with its last error, got: %+v", b)
+	}
+	if c := statuses[2]; c.Status != StatusPending || c.LatencyMS != 0 {
+		t.Errorf("expected c pending, and not pinged, got: %+v", c)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		max      time.Duration
+		failures int
+		exp      time.Duration
+	}{
+		{4 * time.Second, 1, time.Second},
+		{4 * time.Second, 2, 2 * time.Second},
+		{4 * time.Second, 3, 4 * time.Second},
+		{4 * time.Second, 10, 4 * time.Second},
+		{3 * time.Second, 3, 3 * time.Second},
+		{0, 10, time.Second},
+	}
+	for i, test := range tests {
+		if d := backoff(time.Second, test.max, test.failures); d != test.exp {
+			t.Errorf("test %d: expected %v, got: %v", i, test.exp, d)
+		}
+	}
+}
+
+// pingConnector is a driver connector whose connections fail while it is
+// down.
+type pingConnector struct {
+	down atomic.Bool
+}
+
+func (pc *pingConnector) Connect(context.Context) (driver.Conn, error) {
+	if pc.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return pingConn{pc: pc}, nil
+}
+func (*pingConnector) Driver() driver.Driver { return nil }
+
+type pingConn struct {
+	multiConn
+	pc *pingConnector
+}
+
+func (c pingConn) Ping(context.Context) error {
+	if c.pc.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
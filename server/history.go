@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/logstore"
+)
+
+// HistoryEntry is a query (or statement) executed on a connection, recorded
+// in the query history.
+type HistoryEntry struct {
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	ConnectionID string    `json:"connection_id"`
+	Principal    string    `json:"principal,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Session      string    `json:"session,omitempty"`
+	SQL          string    `json:"sql"`
+	Rows         int64     `json:"rows"`
+	DurationMS   float64   `json:"duration_ms"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// HistoryFilter selects the entries of the query history: those whose SQL
+// contains Text (ignoring case), executed on the connection, in the session
+// or by the principal, when set, between Since and Until. At most Limit of
+// the latest matching entries are returned, or all of them when 0.
+type HistoryFilter struct {
+	Text         string
+	ConnectionID string
+	Session      string
+	Principal    string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// match reports whether the entry matches the filter.
+func (f HistoryFilter) match(e HistoryEntry) bool {
+	switch {
+	case f.ConnectionID != "" && e.ConnectionID != f.ConnectionID,
+		f.Session != "" && e.Session != f.Session,
+		f.Principal != "" && e.Principal != f.Principal,
+		!f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return f.Text == "" || strings.Contains(strings.ToLower(e.SQL), strings.ToLower(f.Text))
+}
+
+// queryHistory records the queries executed on the pool's connections in
+// the history record store. A nil history records nothing.
+type queryHistory struct {
+	store  *logstore.Store
+	redact bool
+}
+
+// record records the query or statement of the audit event.
+func (h *queryHistory) record(event audit.Event) {
+	if h == nil {
+		return
+	}
+	e := HistoryEntry{
+		Time:         event.Time.UTC(),
+		Type:         event.Type,
+		ConnectionID: event.ConnectionID,
+		Principal:    event.Principal,
+		Tenant:       event.Tenant,
+		Session:      event.Session,
+		SQL:          event.SQL,
+		Rows:         event.Rows,
+		DurationMS:   event.DurationMS,
+		Outcome:      event.Outcome,
+		Error:        event.Error,
+	}
+	if h.redact {
+		e.SQL = audit.Redact(e.SQL)
+	}
+	if err := h.store.Append(e); err != nil {
+		storageLog.Error("failed to record query in the history", "connection", e.ConnectionID, "error", err)
+	}
+}
+
+// search returns the entries matching the filter, oldest first, for which
+// allow returns true, when not nil.
+func (h *queryHistory) search(filter HistoryFilter, allow func(HistoryEntry) bool) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	if h == nil {
+		return entries, nil
+	}
+	records, err := h.store.Records(filter.Since, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0 && (filter.Limit == 0 || len(entries) < filter.Limit); i-- {
+		var e HistoryEntry
+		if err := json.Unmarshal(records[i].Data, &e); err != nil {
+			return nil, err
+		}
+		if filter.match(e) && (allow == nil || allow(e)) {
+			entries = append(entries, e)
+		}
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// SearchHistory returns the entries of the query history matching the
+// filter, oldest first. It returns no entries when the history is disabled.
+func (cp *ConnectionPool) SearchHistory(filter HistoryFilter) ([]HistoryEntry, error) {
+	return cp.history.search(filter, nil)
+}
+
+// openHistory opens the query history in the history record store, when
+// enabled.
+func (s *Server) openHistory() error {
+	config := s.config().History
+	if !config.Enabled {
+		return nil
+	}
+	store, err := s.openStore("history")
+	if err != nil {
+		return fmt.Errorf("failed to open query history: %w", err)
+	}
+	s.pool.history = &queryHistory{store: store, redact: config.RedactSQL}
+	return nil
+}
+
+// parseHistoryFilter parses the history filter of the admin API request's
+// query parameters: text, connection_id, session_id, principal, since and
+// until (RFC 3339 times), and limit (100 by default).
+func parseHistoryFilter(r *http.Request) (HistoryFilter, error) {
+	q := r.URL.Query()
+	filter := HistoryFilter{
+		Text:         q.Get("text"),
+		ConnectionID: q.Get("connection_id"),
+		Session:      q.Get("session_id"),
+		Principal:    q.Get("principal"),
+		Limit:        100,
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return filter, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return filter, nil
+}
+
+// handleHistory handles searching the query history.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries, err := s.pool.SearchHistory(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
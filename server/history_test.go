@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/logstore"
+	"github.com/xo/usql/server/mcp"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestQueryHistory(t *testing.T) {
+	policies, err := policy.Parse(strings.NewReader("name: no-drop\nrules:\n  - action: deny\n    statements: [drop]\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	engine, _ := policy.NewEngine(policies, policy.Allow)
+	store, err := logstore.Open("history", logstore.Config{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer store.Close()
+	cp := NewConnectionPool(&Config{}, engine, nil)
+	defer cp.Close()
+	cp.history = &queryHistory{store: store, redact: true}
+	u, _ := dburl.Parse("postgres://localhost/db")
+	for _, id := range []string{"acme/a", "b"} {
+		cp.connections[id] = &Connection{ID: id, URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), policy: engine, history: cp.history}
+	}
+
+	alice, bob := policy.WithPrincipal(context.Background(), "alice"), policy.WithPrincipal(context.Background(), "bob")
+	start := time.Now()
+	if _, err := cp.connections["acme/a"].ExecuteStatement(alice, "UPDATE orders SET a = 5"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := cp.connections["b"].ExecuteStatement(bob, "DROP TABLE orders"); err == nil {
+		t.Fatalf("expected the statement to be denied")
+	}
+	if _, err := cp.connections["b"].ExecuteStatement(alice, "UPDATE users SET a = 1"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	tests := []struct {
+		filter HistoryFilter
+		exp    []string
+	}{
+		{HistoryFilter{}, []string{"UPDATE orders SET a = ?", "DROP TABLE orders", "UPDATE users SET a = ?"}},
+		{HistoryFilter{Text: "ORDERS"}, []string{"UPDATE orders SET a = ?", "DROP TABLE orders"}},
+		{HistoryFilter{Principal: "alice", ConnectionID: "b"}, []string{"UPDATE users SET a = ?"}},
+		{HistoryFilter{Limit: 1}, []string{"UPDATE users SET a = ?"}},
+		{HistoryFilter{Until: start}, nil},
+	}
+	for i, test := range tests {
+		entries, err := cp.SearchHistory(test.filter)
+		if err != nil {
+			t.Fatalf("test %d expected no error, got: %v", i, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.SQL)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.exp, "\n") {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, got)
+		}
+	}
+	entries, _ := cp.SearchHistory(HistoryFilter{Principal: "bob"})
+	if len(entries) != 1 || entries[0].Outcome != audit.Denied || entries[0].Type != audit.Statement || entries[0].Error == "" {
+		t.Errorf("expected the denied statement to be recorded, got: %+v", entries)
+	}
+
+	// MCP clients search the history of the connections in their tenant
+	ctx := policy.WithTenant(alice, "acme")
+	found, err := NewPoolAdapter(cp).SearchHistory(ctx, mcp.HistoryFilter{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(found) != 1 || found[0].ConnectionID != "acme/a" {
+		t.Errorf("expected the history of the tenant's connection, got: %+v", found)
+	}
+}
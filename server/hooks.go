@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/hooks"
+)
+
+// NewHookEngine creates the hook engine, loading the hook scripts from the
+// configured directory. Without a hook directory, no hooks are run.
+func NewHookEngine(config HooksConfig) (*hooks.Engine, error) {
+	var scripts []*hooks.Script
+	if config.Dir != "" {
+		var err error
+		if scripts, err = hooks.Load(config.Dir, config.MaxSteps); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range scripts {
+		hooksLog.Info("loaded hook script", "script", s.Name, "hooks", s.Hooks())
+	}
+	return hooks.NewEngine(scripts, config.MaxSteps), nil
+}
+
+// preQuery runs the pre_query hooks on a query or statement, returning the
+// SQL to execute, and a description of the rewrite when a hook rewrote it.
+func (conn *Connection) preQuery(ctx context.Context, query string, statement bool) (string, string, error) {
+	sql, err := conn.hooks.PreQuery(ctx, hooks.Query{
+		ConnectionID: conn.ID,
+		Driver:       conn.URL.Driver,
+		SQL:          query,
+		Statement:    statement,
+	})
+	switch {
+	case err != nil:
+		return "", "", err
+	case sql != query:
+		return sql, "rewritten by a pre_query hook", nil
+	}
+	return query, "", nil
+}
+
+// postResult runs the post_result hooks on each of the result's sets, or on
+// a page of a cursor's rows.
+func (conn *Connection) postResult(ctx context.Context, query string, result *QueryResult) error {
+	q := hooks.Query{
+		ConnectionID: conn.ID,
+		Driver:       conn.URL.Driver,
+		SQL:          query,
+	}
+	for _, set := range append([]*QueryResult{result}, result.MoreResultSets...) {
+		r := &hooks.Result{
+			Columns:     set.Columns,
+			ColumnTypes: set.ColumnTypes,
+			Rows:        set.Rows,
+		}
+		if err := conn.hooks.PostResult(ctx, q, r); err != nil {
+			return err
+		}
+		if !slices.Equal(r.Columns, set.Columns) {
+			// the JSON types of the hook's columns are unknown
+			set.JSONTypes = nil
+		}
+		set.Columns, set.ColumnTypes, set.Rows = r.Columns, r.ColumnTypes, r.Rows
+	}
+	return nil
+}
+
+// connectionCreate runs the on_connection_create hooks for a connection to
+// the database URL.
+func connectionCreate(ctx context.Context, engine *hooks.Engine, id string, u *dburl.URL) error {
+	var user string
+	if u.User != nil {
+		user = u.User.Username()
+	}
+	return engine.ConnectionCreate(ctx, hooks.Connection{
+		ID:       id,
+		Driver:   u.Driver,
+		Host:     u.Hostname(),
+		Database: strings.TrimPrefix(u.Path, "/"),
+		User:     user,
+	})
+}
@@ -0,0 +1,299 @@
+// Package hooks runs operator defined Starlark hooks at extension points of
+// request processing.
+//
+// A hook script defines any of the following functions, called with
+// read-only structs describing the request:
+//
+//	pre_query(query)             called before a query or statement is executed,
+//	                             returning None, or the SQL to execute instead
+//	post_result(query, result)   called with a query's result (a dict of
+//	                             columns, column_types and rows), returning
+//	                             None, or the result to return instead
+//	on_connection_create(conn)   called before a connection is created
+//
+// A hook rejects a request by calling fail(message). Scripts are sandboxed:
+// they cannot load modules or access the file system or network, and each
+// call is limited to a number of execution steps.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xo/usql/server/logging"
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// logger is the logger of the hooks subsystem.
+var logger = logging.Logger(logging.Hooks)
+
+// DefaultMaxSteps is the default maximum number of execution steps of a hook
+// call.
+const DefaultMaxSteps = 1_000_000
+
+// Hook function names.
+const (
+	PreQuery           = "pre_query"
+	PostResult         = "post_result"
+	OnConnectionCreate = "on_connection_create"
+)
+
+// Query describes a query or statement passed to hooks.
+type Query struct {
+	ConnectionID string
+	Driver       string
+	SQL          string
+	Statement    bool
+}
+
+// Connection describes a connection being created, passed to hooks.
+type Connection struct {
+	ID       string
+	Driver   string
+	Host     string
+	Database string
+	User     string
+}
+
+// Result is a query result passed to hooks.
+type Result struct {
+	Columns     []string
+	ColumnTypes []string
+	Rows        [][]interface{}
+}
+
+// Rejection is the error returned when a hook rejects a request.
+type Rejection struct {
+	Script  string
+	Hook    string
+	Message string
+}
+
+// Error satisfies the error interface.
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("rejected by %s hook in %s: %s", r.Hook, r.Script, r.Message)
+}
+
+// Script is a loaded hook script.
+type Script struct {
+	Name    string
+	globals starlark.StringDict
+}
+
+// Hooks returns the names of the hooks defined by the script.
+func (s *Script) Hooks() []string {
+	var names []string
+	for _, name := range []string{PreQuery, PostResult, OnConnectionCreate} {
+		if s.hook(name) != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// hook returns the script's hook function, or nil.
+func (s *Script) hook(name string) *starlark.Function {
+	fn, _ := s.globals[name].(*starlark.Function)
+	return fn
+}
+
+// Compile compiles and initializes a hook script.
+func Compile(name string, src []byte, maxSteps uint64) (*Script, error) {
+	thread := newThread(name, maxSteps)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, name, src, predeclared())
+	if err != nil {
+		return nil, fmt.Errorf("hook script %s: %w", name, err)
+	}
+	// frozen globals are safe to share across calls
+	globals.Freeze()
+	return &Script{Name: name, globals: globals}, nil
+}
+
+// Load compiles the hook scripts in the .star files in dir, in lexical
+// order.
+func Load(dir string, maxSteps uint64) ([]*Script, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var scripts []*Script
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		script, err := Compile(filepath.Base(file), src, maxSteps)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// predeclared returns the names predeclared in hook scripts, in addition to
+// the Starlark built-ins.
+func predeclared() starlark.StringDict {
+	return starlark.StringDict{
+		"json":   starjson.Module,
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	}
+}
+
+// newThread returns a thread for running a hook script, without load
+// support, printing to the log.
+func newThread(name string, maxSteps uint64) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			logger.Info(msg, "hook", name)
+		},
+	}
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	return thread
+}
+
+// Engine runs the hooks of a set of scripts. A nil engine runs no hooks.
+type Engine struct {
+	scripts  []*Script
+	maxSteps uint64
+}
+
+// NewEngine creates a new hook engine running the scripts' hooks in order,
+// each call limited to maxSteps execution steps (0 for the default).
+func NewEngine(scripts []*Script, maxSteps uint64) *Engine {
+	return &Engine{scripts: scripts, maxSteps: maxSteps}
+}
+
+// Scripts returns the engine's scripts.
+func (e *Engine) Scripts() []*Script {
+	if e == nil {
+		return nil
+	}
+	return e.scripts
+}
+
+// has returns whether any script defines the hook.
+func (e *Engine) has(name string) bool {
+	if e == nil {
+		return false
+	}
+	for _, s := range e.scripts {
+		if s.hook(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// call calls the hook of each script defining it, passing the result of
+// each call to next.
+func (e *Engine) call(ctx context.Context, name string, args func() starlark.Tuple, next func(*Script, starlark.Value) error) error {
+	if e == nil {
+		return nil
+	}
+	for _, s := range e.scripts {
+		fn := s.hook(name)
+		if fn == nil {
+			continue
+		}
+		thread := newThread(s.Name, e.maxSteps)
+		stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+		v, err := starlark.Call(thread, fn, args(), nil)
+		stop()
+		if err != nil {
+			return callError(s.Name, name, err)
+		}
+		if err := next(s, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callError returns the error of a failed hook call, a Rejection when the
+// hook called fail.
+func callError(script, hook string, err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		if msg, ok := strings.CutPrefix(evalErr.Msg, "fail: "); ok {
+			return &Rejection{Script: script, Hook: hook, Message: msg}
+		}
+	}
+	return fmt.Errorf("%s hook in %s failed: %w", hook, script, err)
+}
+
+// PreQuery runs the pre_query hooks, returning the SQL to execute, which is
+// rewritten when a hook returns a string.
+func (e *Engine) PreQuery(ctx context.Context, q Query) (string, error) {
+	if !e.has(PreQuery) {
+		return q.SQL, nil
+	}
+	err := e.call(ctx, PreQuery, func() starlark.Tuple {
+		return starlark.Tuple{queryStruct(q)}
+	}, func(s *Script, v starlark.Value) error {
+		switch x := v.(type) {
+		case starlark.NoneType:
+		case starlark.String:
+			q.SQL = string(x)
+		default:
+			return fmt.Errorf("%s hook in %s returned %s, expected None or a string", PreQuery, s.Name, v.Type())
+		}
+		return nil
+	})
+	return q.SQL, err
+}
+
+// PostResult runs the post_result hooks, replacing the result's columns and
+// rows when a hook returns a result.
+func (e *Engine) PostResult(ctx context.Context, q Query, result *Result) error {
+	if !e.has(PostResult) {
+		return nil
+	}
+	return e.call(ctx, PostResult, func() starlark.Tuple {
+		return starlark.Tuple{queryStruct(q), resultDict(result)}
+	}, func(s *Script, v starlark.Value) error {
+		if v == starlark.None {
+			return nil
+		}
+		if err := fromResultDict(v, result); err != nil {
+			return fmt.Errorf("%s hook in %s returned an invalid result: %w", PostResult, s.Name, err)
+		}
+		return nil
+	})
+}
+
+// ConnectionCreate runs the on_connection_create hooks.
+func (e *Engine) ConnectionCreate(ctx context.Context, conn Connection) error {
+	return e.call(ctx, OnConnectionCreate, func() starlark.Tuple {
+		return starlark.Tuple{starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"id":       starlark.String(conn.ID),
+			"driver":   starlark.String(conn.Driver),
+			"host":     starlark.String(conn.Host),
+			"database": starlark.String(conn.Database),
+			"user":     starlark.String(conn.User),
+		})}
+	}, func(*Script, starlark.Value) error { return nil })
+}
+
+// queryStruct returns the struct passed to hooks for a query.
+func queryStruct(q Query) starlark.Value {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"connection_id": starlark.String(q.ConnectionID),
+		"driver":        starlark.String(q.Driver),
+		"sql":           starlark.String(q.SQL),
+		"statement":     starlark.Bool(q.Statement),
+	})
+}
@@ -0,0 +1,162 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testScript = `
+def pre_query(query):
+    if "secret" in query.sql:
+        fail("secret tables are off limits")
+    if query.sql.startswith("select") and not query.statement:
+        return query.sql + " -- " + query.connection_id
+
+def post_result(query, result):
+    if "price" not in result["columns"]:
+        return None
+    return {
+        "columns": result["columns"] + ["with_tax"],
+        "column_types": result["column_types"] + ["NUMERIC"],
+        "rows": [row + [row[1] * 1.2] for row in result["rows"]],
+    }
+
+def on_connection_create(conn):
+    if conn.driver != "sqlite3":
+        fail("only sqlite3 is allowed, got " + conn.driver)
+`
+
+func testEngine(t *testing.T, src string, maxSteps uint64) *Engine {
+	t.Helper()
+	s, err := Compile("test.star", []byte(src), maxSteps)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return NewEngine([]*Script{s}, maxSteps)
+}
+
+func TestPreQuery(t *testing.T) {
+	e := testEngine(t, testScript, 0)
+	tests := []struct {
+		q   Query
+		exp string
+		err bool
+	}{
+		{Query{ConnectionID: "c", SQL: "select 1"}, "select 1 -- c", false},
+		{Query{ConnectionID: "c", SQL: "select 1", Statement: true}, "select 1", false},
+		{Query{ConnectionID: "c", SQL: "delete from t"}, "delete from t", false},
+		{Query{ConnectionID: "c", SQL: "select * from secret"}, "", true},
+	}
+	for _, test := range tests {
+		sql, err := e.PreQuery(context.Background(), test.q)
+		switch {
+		case test.err:
+			var r *Rejection
+			if !errors.As(err, &r) || r.Hook != PreQuery || r.Message != "secret tables are off limits" {
+				t.Errorf("%q expected a rejection, got: %v", test.q.SQL, err)
+			}
+		case err != nil:
+			t.Errorf("%q expected no error, got: %v", test.q.SQL, err)
+		case sql != test.exp:
+			t.Errorf("%q expected %q, got: %q", test.q.SQL, test.exp, sql)
+		}
+	}
+}
+
+func TestPostResult(t *testing.T) {
+	e := testEngine(t, testScript, 0)
+	result := &Result{
+		Columns:     []string{"name", "price"},
+		ColumnTypes: []string{"TEXT", "INTEGER"},
+		Rows:        [][]interface{}{{"a", int64(10)}, {"b", int64(5)}},
+	}
+	if err := e.PostResult(context.Background(), Query{}, result); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	exp := &Result{
+		Columns:     []string{"name", "price", "with_tax"},
+		ColumnTypes: []string{"TEXT", "INTEGER", "NUMERIC"},
+		Rows:        [][]interface{}{{"a", int64(10), 12.0}, {"b", int64(5), 6.0}},
+	}
+	if !reflect.DeepEqual(result, exp) {
+		t.Errorf("expected %v, got: %v", exp, result)
+	}
+	// results without a price column are left as is
+	result = &Result{Columns: []string{"a"}, ColumnTypes: []string{"INTEGER"}, Rows: [][]interface{}{{int64(1)}}}
+	if err := e.PostResult(context.Background(), Query{}, result); err != nil || len(result.Columns) != 1 {
+		t.Errorf("expected an unchanged result, got: %v, %v", result, err)
+	}
+}
+
+func TestPostResultInvalid(t *testing.T) {
+	e := testEngine(t, `
+def post_result(query, result):
+    return {"columns": ["a"], "column_types": ["INTEGER"], "rows": [[1, 2]]}
+`, 0)
+	result := &Result{Columns: []string{"a"}, ColumnTypes: []string{"INTEGER"}}
+	if err := e.PostResult(context.Background(), Query{}, result); err == nil || !strings.Contains(err.Error(), "row 0 must be a list of 1 values") {
+		t.Errorf("expected an invalid result error, got: %v", err)
+	}
+}
+
+func TestConnectionCreate(t *testing.T) {
+	e := testEngine(t, testScript, 0)
+	if err := e.ConnectionCreate(context.Background(), Connection{ID: "a", Driver: "sqlite3"}); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	var r *Rejection
+	if err := e.ConnectionCreate(context.Background(), Connection{ID: "b", Driver: "postgres"}); !errors.As(err, &r) || r.Hook != OnConnectionCreate {
+		t.Errorf("expected a rejection, got: %v", err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	if _, err := Compile("load.star", []byte(`load("other.star", "x")`), 0); err == nil {
+		t.Errorf("expected load to fail")
+	}
+	e := testEngine(t, `
+def pre_query(query):
+    n = 0
+    for i in range(1000000):
+        n += i
+`, 1000)
+	if _, err := e.PreQuery(context.Background(), Query{SQL: "select 1"}); err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("expected the step limit to be exceeded, got: %v", err)
+	}
+	e = testEngine(t, `
+def pre_query(query):
+    return 1
+`, 0)
+	if _, err := e.PreQuery(context.Background(), Query{SQL: "select 1"}); err == nil {
+		t.Errorf("expected an invalid return value error")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"b.star":     "def post_result(query, result):\n    pass\n",
+		"a.star":     "def pre_query(query):\n    pass\n",
+		"ignore.txt": "not starlark",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scripts, err := Load(dir, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(scripts) != 2 || scripts[0].Name != "a.star" || !reflect.DeepEqual(scripts[1].Hooks(), []string{PostResult}) {
+		t.Errorf("expected a.star and b.star, got: %v", scripts)
+	}
+	var e *Engine
+	if sql, err := e.PreQuery(context.Background(), Query{SQL: "x"}); err != nil || sql != "x" {
+		t.Errorf("expected a nil engine to run no hooks, got: %q, %v", sql, err)
+	}
+}
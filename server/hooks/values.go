@@ -0,0 +1,177 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// resultDict returns the dict passed to hooks for a result.
+func resultDict(result *Result) starlark.Value {
+	rows := make([]starlark.Value, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = toList(row)
+	}
+	d := starlark.NewDict(3)
+	d.SetKey(starlark.String("columns"), stringList(result.Columns))
+	d.SetKey(starlark.String("column_types"), stringList(result.ColumnTypes))
+	d.SetKey(starlark.String("rows"), starlark.NewList(rows))
+	return d
+}
+
+// fromResultDict sets the result from a result dict returned by a hook.
+func fromResultDict(v starlark.Value, result *Result) error {
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return fmt.Errorf("expected a dict, got %s", v.Type())
+	}
+	var r Result
+	for _, field := range []struct {
+		name string
+		dest *[]string
+	}{
+		{"columns", &r.Columns},
+		{"column_types", &r.ColumnTypes},
+	} {
+		x, found, _ := d.Get(starlark.String(field.name))
+		if !found {
+			return fmt.Errorf("%s is required", field.name)
+		}
+		values, err := fromValue(x)
+		if err != nil {
+			return err
+		}
+		list, ok := values.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a list", field.name)
+		}
+		for _, s := range list {
+			str, ok := s.(string)
+			if !ok {
+				return fmt.Errorf("%s must be a list of strings", field.name)
+			}
+			*field.dest = append(*field.dest, str)
+		}
+	}
+	if len(r.ColumnTypes) != len(r.Columns) {
+		return fmt.Errorf("expected %d column types, got %d", len(r.Columns), len(r.ColumnTypes))
+	}
+	x, found, _ := d.Get(starlark.String("rows"))
+	if !found {
+		return fmt.Errorf("rows is required")
+	}
+	values, err := fromValue(x)
+	if err != nil {
+		return err
+	}
+	rows, ok := values.([]interface{})
+	if !ok {
+		return fmt.Errorf("rows must be a list")
+	}
+	r.Rows = make([][]interface{}, len(rows))
+	for i, row := range rows {
+		if r.Rows[i], ok = row.([]interface{}); !ok || len(r.Rows[i]) != len(r.Columns) {
+			return fmt.Errorf("row %d must be a list of %d values", i, len(r.Columns))
+		}
+	}
+	*result = r
+	return nil
+}
+
+// stringList returns a list of the strings.
+func stringList(strs []string) *starlark.List {
+	values := make([]starlark.Value, len(strs))
+	for i, s := range strs {
+		values[i] = starlark.String(s)
+	}
+	return starlark.NewList(values)
+}
+
+// toList returns a list of the values.
+func toList(values []interface{}) *starlark.List {
+	list := make([]starlark.Value, len(values))
+	for i, v := range values {
+		list[i] = toValue(v)
+	}
+	return starlark.NewList(list)
+}
+
+// toValue converts a result value to a Starlark value. Timestamps are
+// passed as RFC 3339 strings, and exact numerics as strings.
+func toValue(v interface{}) starlark.Value {
+	switch x := v.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(x)
+	case int64:
+		return starlark.MakeInt64(x)
+	case int:
+		return starlark.MakeInt(x)
+	case float64:
+		return starlark.Float(x)
+	case string:
+		return starlark.String(x)
+	case []byte:
+		return starlark.Bytes(x)
+	case json.Number:
+		return starlark.String(x)
+	case time.Time:
+		return starlark.String(x.Format(time.RFC3339Nano))
+	}
+	return starlark.String(fmt.Sprint(v))
+}
+
+// fromValue converts a Starlark value returned by a hook to a result value.
+func fromValue(v starlark.Value) (interface{}, error) {
+	switch x := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(x), nil
+	case starlark.Int:
+		if n, ok := x.Int64(); ok {
+			return n, nil
+		}
+		return json.Number(x.BigInt().Text(10)), nil
+	case starlark.Float:
+		return float64(x), nil
+	case starlark.String:
+		return string(x), nil
+	case starlark.Bytes:
+		return []byte(x), nil
+	case *starlark.List, starlark.Tuple:
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		var values []interface{}
+		var elem starlark.Value
+		for iter.Next(&elem) {
+			value, err := fromValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		if values == nil {
+			values = []interface{}{}
+		}
+		return values, nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, x.Len())
+		for _, item := range x.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			value, err := fromValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %s", v.Type())
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/hooks"
+)
+
+func TestHooks(t *testing.T) {
+	s, err := hooks.Compile("test.star", []byte(`
+def pre_query(query):
+    if query.sql == "DROP":
+        fail("no")
+    return query.sql + "; SELECT b"
+
+def post_result(query, result):
+    return {
+        "columns": result["columns"] + ["n"],
+        "column_types": result["column_types"] + ["INTEGER"],
+        "rows": [row + [len(result["rows"])] for row in result["rows"]],
+    }
+`), 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), hooks: hooks.NewEngine([]*hooks.Script{s}, 0)}
+	defer conn.DB.Close()
+
+	result, err := conn.ExecuteQuery(context.Background(), "SELECT a")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !reflect.DeepEqual(result.Columns, []string{"a", "n"}) || !reflect.DeepEqual(result.Rows, [][]interface{}{{int64(1), int64(2)}, {int64(2), int64(2)}}):
+		t.Errorf("expected rows enriched by the hook, got: %v %v", result.Columns, result.Rows)
+	case len(result.MoreResultSets) != 1 || !reflect.DeepEqual(result.MoreResultSets[0].Columns, []string{"b", "n"}):
+		t.Errorf("expected the hook to apply to each result set, got: %v", result.MoreResultSets)
+	case result.JSONTypes != nil:
+		t.Errorf("expected no JSON types for the hook's columns, got: %v", result.JSONTypes)
+	case result.Provenance == nil || !reflect.DeepEqual(result.Provenance.Rewrites, []string{"rewritten by a pre_query hook"}):
+		t.Errorf("expected the rewrite in the provenance, got: %v", result.Provenance)
+	}
+	if _, err := conn.ExecuteQuery(context.Background(), "DROP"); err == nil {
+		t.Errorf("expected the query to be rejected")
+	}
+}
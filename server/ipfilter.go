@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter restricts the client IPs requests are served to, resolving the
+// client IP of requests through trusted reverse proxies.
+type ipFilter struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+	trusted []netip.Prefix
+}
+
+// newIPFilter creates the IP filter of the allowed and denied CIDRs and
+// trusted proxies of the config, or returns nil when none are configured.
+func newIPFilter(config ServerConfig) (*ipFilter, error) {
+	if len(config.AllowedCIDRs) == 0 && len(config.DeniedCIDRs) == 0 && len(config.TrustedProxies) == 0 {
+		return nil, nil
+	}
+	f := new(ipFilter)
+	for _, list := range []struct {
+		name     string
+		cidrs    []string
+		prefixes *[]netip.Prefix
+	}{
+		{"allowed_cidrs", config.AllowedCIDRs, &f.allowed},
+		{"denied_cidrs", config.DeniedCIDRs, &f.denied},
+		{"trusted_proxies", config.TrustedProxies, &f.trusted},
+	} {
+		for _, cidr := range list.cidrs {
+			prefix, err := parsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", list.name, err)
+			}
+			*list.prefixes = append(*list.prefixes, prefix)
+		}
+	}
+	return f, nil
+}
+
+// parsePrefix parses a CIDR, or an IP as the CIDR of only that IP.
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// contains reports whether any of the prefixes contains the IP.
+func contains(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the request's client: the peer's IP, unless
+// the peer is a trusted proxy, in which case the IP the proxies forwarded
+// the request for, the last in X-Forwarded-For not of a trusted proxy, or
+// X-Real-IP. Returns an invalid IP when it cannot be parsed.
+func (f *ipFilter) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip = ip.Unmap()
+	if !contains(f.trusted, ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		v := strings.TrimSpace(forwarded[i])
+		if v == "" {
+			continue
+		}
+		hop, err := netip.ParseAddr(v)
+		if err != nil {
+			return netip.Addr{}
+		}
+		if ip = hop.Unmap(); !contains(f.trusted, ip) {
+			return ip
+		}
+	}
+	if v := strings.TrimSpace(r.Header.Get("X-Real-IP")); v != "" {
+		hop, err := netip.ParseAddr(v)
+		if err != nil {
+			return netip.Addr{}
+		}
+		return hop.Unmap()
+	}
+	return ip
+}
+
+// allows reports whether requests from the client IP are served: IPs in the
+// denied CIDRs are not, and when there are allowed CIDRs, only IPs in them
+// are.
+func (f *ipFilter) allows(ip netip.Addr) bool {
+	if !ip.IsValid() || contains(f.denied, ip) {
+		return false
+	}
+	return len(f.allowed) == 0 || contains(f.allowed, ip)
+}
+
+// ipFilterMiddleware refuses requests from client IPs the IP filter of the
+// current config does not allow.
+func (s *Server) ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := s.ips.Load()
+		if f == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch ip := f.clientIP(r); {
+		case !ip.IsValid():
+			writeError(w, http.StatusForbidden, fmt.Errorf("client IP of %s cannot be resolved", r.RemoteAddr))
+			return
+		case !f.allows(ip):
+			writeError(w, http.StatusForbidden, fmt.Errorf("client IP %s is not allowed", ip))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	for _, config := range []ServerConfig{
+		{AllowedCIDRs: []string{"10.0.0.0/33"}},
+		{DeniedCIDRs: []string{"not-an-ip"}},
+	} {
+		if _, err := newIPFilter(config); err == nil {
+			t.Errorf("expected an error creating the filter of %+v", config)
+		}
+	}
+	if f, err := newIPFilter(ServerConfig{}); f != nil || err != nil {
+		t.Errorf("expected no filter, got: %v %v", f, err)
+	}
+
+	s := &Server{}
+	f, err := newIPFilter(ServerConfig{
+		AllowedCIDRs:   []string{"10.0.0.0/8", "2001:db8::/32"},
+		DeniedCIDRs:    []string{"10.0.0.66"},
+		TrustedProxies: []string{"192.168.1.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	s.ips.Store(f)
+	handler := s.ipFilterMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		realIP    string
+		exp       int
+	}{
+		{"10.1.2.3:1234", "", "", http.StatusOK},
+		{"[2001:db8::1]:1234", "", "", http.StatusOK},
+		{"[::ffff:10.1.2.3]:1234", "", "", http.StatusOK},
+		{"172.16.0.1:1234", "", "", http.StatusForbidden},
+		{"10.0.0.66:1234", "", "", http.StatusForbidden},
+		// forwarded IPs are only honored from trusted proxies
+		{"172.16.0.1:1234", "10.1.2.3", "", http.StatusForbidden},
+		{"192.168.1.5:1234", "10.1.2.3", "", http.StatusOK},
+		{"192.168.1.5:1234", "172.16.0.1", "", http.StatusForbidden},
+		// the last hop not of a trusted proxy is the client
+		{"192.168.1.5:1234", "10.1.2.3, 172.16.0.1, 192.168.1.9", "", http.StatusForbidden},
+		{"192.168.1.5:1234", "172.16.0.1, 10.1.2.3, 192.168.1.9", "", http.StatusOK},
+		{"192.168.1.5:1234", "", "10.1.2.3", http.StatusOK},
+		{"192.168.1.5:1234", "garbage", "", http.StatusForbidden},
+		// proxies are clients too, when they do not forward
+		{"192.168.1.5:1234", "", "", http.StatusForbidden},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/health", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.exp {
+			t.Errorf("test %d: expected %d, got: %d %s", i, test.exp, w.Code, w.Body.String())
+		}
+	}
+}
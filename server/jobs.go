@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// JobState is the state of an asynchronous query job.
+type JobState string
+
+// Job states.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// ErrJobNotFinished is returned when requesting the result of a job that has
+// not yet finished.
+var ErrJobNotFinished = errors.New("job has not finished")
+
+// Job is a query executed asynchronously by a background worker.
+type Job struct {
+	ID           string
+	ConnectionID string
+	Query        string
+
+	conn   *Connection
+	args   []interface{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	state     JobState
+	err       error
+	submitted time.Time
+	started   time.Time
+	finished  time.Time
+	result    *QueryResult
+	truncated bool
+}
+
+// JobInfo is a snapshot of a job's status.
+type JobInfo struct {
+	ID           string    `json:"job_id"`
+	ConnectionID string    `json:"connection_id"`
+	State        JobState  `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+	StartedAt    time.Time `json:"started_at,omitzero"`
+	FinishedAt   time.Time `json:"finished_at,omitzero"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+	RowCount     int       `json:"row_count"`
+	Truncated    bool      `json:"truncated"`
+}
+
+// JobManager runs queued jobs on a fixed number of workers, and retains
+// finished jobs' results until they expire, when they are collected by the
+// pool's reaper.
+type JobManager struct {
+	config JobConfig
+	queue  chan *Job
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobManager creates a new job manager and starts its workers.
+func NewJobManager(config JobConfig) *JobManager {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.ResultTTL <= 0 {
+		config.ResultTTL = time.Hour
+	}
+	jm := &JobManager{
+		config: config,
+		queue:  make(chan *Job, max(config.MaxQueued, 1)),
+		stop:   make(chan struct{}),
+		jobs:   make(map[string]*Job),
+	}
+	for range config.Workers {
+		jm.wg.Add(1)
+		go jm.work()
+	}
+	return jm
+}
+
+// Submit queues query for execution on the connection.
+func (jm *JobManager) Submit(ctx context.Context, conn *Connection, query string, args ...interface{}) (*JobInfo, error) {
+	// Denied queries are rejected upfront, rather than failing once run,
+	// while approvals are used once the query runs
+	if err := conn.policy.CheckDenied(ctx, conn.ID, query); err != nil {
+		return nil, err
+	}
+
+	// jobs outlive the request, though keep its principal and approval
+	var cancel context.CancelFunc
+	if jm.config.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), jm.config.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	job := &Job{
+		ID:           newID(),
+		ConnectionID: conn.ID,
+		Query:        query,
+		conn:         conn,
+		args:         args,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		state:        JobQueued,
+		submitted:    time.Now(),
+	}
+
+	select {
+	case jm.queue <- job:
+	default:
+		cancel()
+		return nil, fmt.Errorf("job queue is full (max: %d)", cap(jm.queue))
+	}
+
+	jm.mu.Lock()
+	jm.jobs[job.ID] = job
+	jm.mu.Unlock()
+
+	return job.info(jm.config.ResultTTL), nil
+}
+
+// Status returns the status of a job. When wait is greater than 0, Status
+// waits up to wait (or until ctx is done) for the job to finish.
+func (jm *JobManager) Status(ctx context.Context, id string, wait time.Duration) (*JobInfo, error) {
+	job, err := jm.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-job.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return job.info(jm.config.ResultTTL), nil
+}
+
+// Result returns the result of a finished job.
+func (jm *JobManager) Result(id string) (*JobInfo, *QueryResult, error) {
+	job, err := jm.get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := job.info(jm.config.ResultTTL)
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	switch job.state {
+	case JobQueued, JobRunning:
+		return info, nil, ErrJobNotFinished
+	case JobSucceeded:
+		return info, job.result, nil
+	}
+	return info, nil, fmt.Errorf("job %s: %w", job.state, job.err)
+}
+
+// Cancel cancels a queued or running job.
+func (jm *JobManager) Cancel(id string) (*JobInfo, error) {
+	job, err := jm.get(id)
+	if err != nil {
+		return nil, err
+	}
+	job.mu.Lock()
+	if job.state == JobQueued {
+		job.finish(JobCanceled, context.Canceled)
+	}
+	job.mu.Unlock()
+	job.cancel()
+	return job.info(jm.config.ResultTTL), nil
+}
+
+// Shutdown cancels all jobs and waits for the workers to stop.
+func (jm *JobManager) Shutdown() {
+	close(jm.stop)
+	jm.mu.Lock()
+	for _, job := range jm.jobs {
+		job.cancel()
+	}
+	jm.mu.Unlock()
+	jm.wg.Wait()
+}
+
+// pending returns the number of jobs queued or running.
+func (jm *JobManager) pending() int {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	n := 0
+	for _, job := range jm.jobs {
+		job.mu.Lock()
+		if job.state == JobQueued || job.state == JobRunning {
+			n++
+		}
+		job.mu.Unlock()
+	}
+	return n
+}
+
+// get returns the job with the ID.
+func (jm *JobManager) get(id string) (*Job, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	job, ok := jm.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job with ID %s not found", id)
+	}
+	return job, nil
+}
+
+// work runs queued jobs until the manager is shut down.
+func (jm *JobManager) work() {
+	defer jm.wg.Done()
+	for {
+		select {
+		case <-jm.stop:
+			return
+		case job := <-jm.queue:
+			jm.run(job)
+		}
+	}
+}
+
+// run executes a job, storing its result.
+func (jm *JobManager) run(job *Job) {
+	defer job.cancel()
+
+	job.mu.Lock()
+	if job.state != JobQueued {
+		job.mu.Unlock()
+		return
+	}
+	job.state, job.started = JobRunning, time.Now()
+	job.mu.Unlock()
+
+	job.conn.touch()
+	inst := job.conn.instance(job.Query)
+	result, truncated, err := inst.query(job.ctx, inst.limits.bound(ResultLimits{Rows: jm.config.MaxResultRows}), nil, job.Query, job.args...)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	switch {
+	case err == nil:
+		job.result, job.truncated = result, truncated
+		job.finish(JobSucceeded, nil)
+	case errors.Is(job.ctx.Err(), context.Canceled):
+		job.finish(JobCanceled, err)
+	default:
+		job.finish(JobFailed, err)
+	}
+}
+
+// expire removes the finished jobs whose results expired at now, returning
+// their number.
+func (jm *JobManager) expire(now time.Time) int {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	n := 0
+	for id, job := range jm.jobs {
+		if expires := job.info(jm.config.ResultTTL).ExpiresAt; !expires.IsZero() && now.After(expires) {
+			delete(jm.jobs, id)
+			n++
+		}
+	}
+	return n
+}
+
+// exists returns whether the job with the ID exists.
+func (jm *JobManager) exists(id string) bool {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	_, ok := jm.jobs[id]
+	return ok
+}
+
+// discard cancels the job with the ID, if not yet finished, and removes it
+// with its result, returning whether it was found.
+func (jm *JobManager) discard(id string) bool {
+	jm.mu.Lock()
+	job, ok := jm.jobs[id]
+	delete(jm.jobs, id)
+	jm.mu.Unlock()
+	if !ok {
+		return false
+	}
+	job.mu.Lock()
+	if job.state == JobQueued {
+		job.finish(JobCanceled, context.Canceled)
+	}
+	job.mu.Unlock()
+	job.cancel()
+	return true
+}
+
+// finish marks the job as finished. The job's lock must be held.
+func (job *Job) finish(state JobState, err error) {
+	job.state, job.err, job.finished = state, err, time.Now()
+	close(job.done)
+}
+
+// info returns a snapshot of the job's status.
+func (job *Job) info(ttl time.Duration) *JobInfo {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	info := &JobInfo{
+		ID:           job.ID,
+		ConnectionID: job.ConnectionID,
+		State:        job.state,
+		SubmittedAt:  job.submitted,
+		StartedAt:    job.started,
+		FinishedAt:   job.finished,
+		Truncated:    job.truncated,
+	}
+	if job.err != nil {
+		info.Error = job.err.Error()
+	}
+	if job.result != nil {
+		info.RowCount = len(job.result.Rows)
+		for _, set := range job.result.MoreResultSets {
+			info.RowCount += len(set.Rows)
+		}
+	}
+	if !job.finished.IsZero() {
+		info.ExpiresAt = job.finished.Add(ttl)
+	}
+	return info
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestJobs(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	jm := NewJobManager(JobConfig{Workers: 1, MaxQueued: 4, MaxResultRows: 2})
+	defer jm.Shutdown()
+
+	info, err := jm.Submit(context.Background(), conn, "SELECT a; UPDATE t; SELECT b")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case info.ID == "" || info.ConnectionID != "multi" || (info.State != JobQueued && info.State != JobRunning):
+		t.Errorf("expected a queued job, got: %+v", info)
+	}
+	status, err := jm.Status(context.Background(), info.ID, time.Second)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case status.State != JobSucceeded || status.RowCount != 2 || !status.Truncated:
+		t.Errorf("expected the job to succeed with 2 rows, truncated, got: %+v", status)
+	case status.StartedAt.IsZero() || status.FinishedAt.Before(status.StartedAt) || !status.ExpiresAt.Equal(status.FinishedAt.Add(time.Hour)):
+		t.Errorf("expected the job's times, and its result to expire after an hour, got: %+v", status)
+	}
+	status, result, err := jm.Result(info.ID)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case status.State != JobSucceeded || len(result.Rows) != 2 || len(result.MoreResultSets) != 1:
+		t.Errorf("expected the job's result, got: %+v %v", status, result)
+	}
+
+	if _, err := jm.Status(context.Background(), "missing", 0); err == nil {
+		t.Errorf("expected an error getting the status of a missing job")
+	}
+	if _, _, err := jm.Result("missing"); err == nil {
+		t.Errorf("expected an error getting the result of a missing job")
+	}
+	if _, err := jm.Cancel("missing"); err == nil {
+		t.Errorf("expected an error canceling a missing job")
+	}
+}
+
+func TestCancelJobs(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	c := &blockingConnector{started: make(chan struct{}, 2)}
+	conn := &Connection{ID: "blocking", URL: u, DB: sql.OpenDB(c), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	jm := NewJobManager(JobConfig{Workers: 1, MaxQueued: 1})
+	defer jm.Shutdown()
+
+	running, err := jm.Submit(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	<-c.started
+	queued, err := jm.Submit(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the worker is busy and the queue full
+	if _, err := jm.Submit(context.Background(), conn, "SELECT a"); err == nil {
+		t.Errorf("expected an error submitting a job to a full queue")
+	}
+	if n := jm.pending(); n != 2 {
+		t.Errorf("expected 2 pending jobs, got: %d", n)
+	}
+	if info, _, err := jm.Result(running.ID); !errors.Is(err, ErrJobNotFinished) || info.State != JobRunning {
+		t.Errorf("expected the job to be running, got: %+v %v", info, err)
+	}
+
+	// queued jobs are canceled at once, and not run
+	info, err := jm.Cancel(queued.ID)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case info.State != JobCanceled || info.FinishedAt.IsZero():
+		t.Errorf("expected the queued job to be canceled, got: %+v", info)
+	}
+	if _, _, err := jm.Result(queued.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the job to be canceled, got: %v", err)
+	}
+
+	// running jobs are canceled through their context
+	if _, err := jm.Cancel(running.ID); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if info, err := jm.Status(context.Background(), running.ID, time.Second); err != nil || info.State != JobCanceled {
+		t.Errorf("expected the running job to be canceled, got: %+v %v", info, err)
+	}
+	if n := jm.pending(); n != 0 {
+		t.Errorf("expected no pending jobs, got: %d", n)
+	}
+	select {
+	case <-c.started:
+		t.Errorf("expected the canceled queued job not to run")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestExpireJobs(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	c := &blockingConnector{started: make(chan struct{}, 1)}
+	conn := &Connection{ID: "blocking", URL: u, DB: sql.OpenDB(c), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	jm := NewJobManager(JobConfig{Workers: 1, MaxQueued: 1, ResultTTL: time.Minute})
+	defer jm.Shutdown()
+
+	finished, err := jm.Submit(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	<-c.started
+	jm.Cancel(finished.ID)
+	if info, _ := jm.Status(context.Background(), finished.ID, time.Second); info.State != JobCanceled {
+		t.Fatalf("expected the job to be canceled, got: %+v", info)
+	}
+	running, err := jm.Submit(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	<-c.started
+
+	// finished jobs expire once their result TTL passed, unlike running jobs
+	if n := jm.expire(time.Now()); n != 0 {
+		t.Errorf("expected no expired jobs, got: %d", n)
+	}
+	if n := jm.expire(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Errorf("expected 1 expired job, got: %d", n)
+	}
+	if jm.exists(finished.ID) || !jm.exists(running.ID) {
+		t.Errorf("expected only the finished job to expire")
+	}
+	if _, err := jm.Status(context.Background(), finished.ID, 0); err == nil {
+		t.Errorf("expected an error getting the status of an expired job")
+	}
+
+	// discarded jobs are canceled and removed
+	if !jm.discard(running.ID) || jm.exists(running.ID) || jm.discard(running.ID) {
+		t.Errorf("expected the running job to be discarded once")
+	}
+}
+
+// blockingConnector is a driver connector whose queries block until their
+// context is done, signaling started as they start.
+type blockingConnector struct {
+	started chan struct{}
+}
+
+func (c *blockingConnector) Connect(context.Context) (driver.Conn, error) {
+	return blockingConn{multiConn{}, c}, nil
+}
+func (c *blockingConnector) Driver() driver.Driver { return nil }
+
+type blockingConn struct {
+	multiConn
+	c *blockingConnector
+}
+
+func (bc blockingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	bc.c.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
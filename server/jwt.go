@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWKS refresh intervals: keys are fetched again once they are older than
+// jwksMaxAge, or on tokens signed with an unknown key, at most once per
+// jwksMinRefresh.
+const (
+	jwksMaxAge     = time.Hour
+	jwksMinRefresh = time.Minute
+	jwksTimeout    = 10 * time.Second
+)
+
+// jwtVerifier verifies JWT bearer tokens, signed with HS256 by the shared
+// secret, or with RS256 by the public key or the keys of the JWKS URL.
+type jwtVerifier struct {
+	config JWTConfig
+	parser *jwt.Parser
+	secret []byte
+	public *rsa.PublicKey
+	jwks   *jwks
+}
+
+// newJWTVerifier creates a verifier of the tokens configured.
+func newJWTVerifier(config JWTConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{config: config}
+	var methods []string
+	if config.SecretEnv != "" {
+		secret := os.Getenv(config.SecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("a secret is required in $%s to verify tokens", config.SecretEnv)
+		}
+		v.secret = []byte(secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.PublicKeyFile != "" {
+		buf, err := os.ReadFile(config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if v.public, err = jwt.ParseRSAPublicKeyFromPEM(buf); err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+	}
+	if config.JWKSURL != "" {
+		v.jwks = newJWKS(config.JWKSURL)
+	}
+	if v.public != nil || v.jwks != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("a secret, public key or JWKS URL is required to verify tokens")
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute)}
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// verify verifies the token, returning the principal of its claims.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+			return v.secret, nil
+		}
+		kid, _ := t.Header["kid"].(string)
+		switch {
+		case kid != "" && v.jwks != nil:
+			return v.jwks.key(ctx, kid)
+		case v.public != nil:
+			return v.public, nil
+		}
+		return nil, errors.New("token has no key ID")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimsPrincipal(claims, v.config.PrincipalClaim, v.config.ScopesClaim)
+}
+
+// claimsPrincipal returns the principal of the claims: the principal claim
+// names it, the scopes claim (a space separated string or an array) holds
+// its scopes, and the string, number and boolean claims are its attributes.
+func claimsPrincipal(claims map[string]interface{}, principalClaim, scopesClaim string) (*principal, error) {
+	name, _ := claims[principalClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("token has no %s claim", principalClaim)
+	}
+	p := &principal{Name: name, Attributes: make(map[string]string)}
+	switch scopes := claims[scopesClaim].(type) {
+	case string:
+		p.Scopes = strings.Fields(scopes)
+	case []interface{}:
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok {
+				p.Scopes = append(p.Scopes, s)
+			}
+		}
+	}
+	for claim, value := range claims {
+		switch value.(type) {
+		case string, float64, bool, json.Number:
+			p.Attributes[claim] = fmt.Sprint(value)
+		}
+	}
+	return p, nil
+}
+
+// jwks is the RSA keys of a JSON Web Key Set, by their key ID.
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// newJWKS creates the key set at the URL, fetched once a key is needed.
+func newJWKS(url string) *jwks {
+	return &jwks{url: url, client: &http.Client{Timeout: jwksTimeout}}
+}
+
+// key returns the key with the ID, fetching the keys when it is unknown or
+// they are stale. Stale keys are used when they cannot be fetched.
+func (k *jwks) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, ok := k.keys[kid]
+	age := time.Since(k.fetched)
+	if (ok && age < jwksMaxAge) || (!ok && age < jwksMinRefresh) {
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		return key, nil
+	}
+	if err := k.fetch(ctx); err != nil {
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	if key, ok = k.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// jsonWebKey is a key of a JSON Web Key Set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// fetch fetches the keys, keeping the RSA signing keys. The keys' lock must
+// be held.
+func (k *jwks) fetch(ctx context.Context) error {
+	k.fetched = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid key set: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || jwk.Use == "enc" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return fmt.Errorf("invalid key %s: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return fmt.Errorf("invalid key %s: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	k.keys = keys
+	return nil
+}
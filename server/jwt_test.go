@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	t.Setenv("USQLR_TEST_JWT_SECRET", "secret")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	v, err := newJWTVerifier(JWTConfig{
+		SecretEnv:      "USQLR_TEST_JWT_SECRET",
+		JWKSURL:        srv.URL,
+		Issuer:         "https://idp.example.com",
+		Audience:       "usqlr",
+		PrincipalClaim: "sub",
+		ScopesClaim:    "scope",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	claims := func(mod func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":    "alice",
+			"iss":    "https://idp.example.com",
+			"aud":    "usqlr",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"scope":  "query tool:execute_query connection:reporting-*",
+			"tenant": "acme",
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+	hs256 := func(c jwt.MapClaims, secret string) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(secret))
+		return s
+	}
+	rs256 := func(c jwt.MapClaims, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+		token.Header["kid"] = kid
+		s, _ := token.SignedString(key)
+		return s
+	}
+
+	tests := []struct {
+		token string
+		valid bool
+	}{
+		{hs256(claims(nil), "secret"), true},
+		{rs256(claims(nil), "k1"), true},
+		{hs256(claims(nil), "wrong"), false},
+		{rs256(claims(nil), "k2"), false},
+		{rs256(claims(nil), ""), false},
+		{hs256(claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), "secret"), false},
+		{hs256(claims(func(c jwt.MapClaims) { delete(c, "exp") }), "secret"), false},
+		{hs256(claims(func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" }), "secret"), false},
+		{hs256(claims(func(c jwt.MapClaims) { c["aud"] = "other" }), "secret"), false},
+		{hs256(claims(func(c jwt.MapClaims) { delete(c, "sub") }), "secret"), false},
+		{"not a token", false},
+	}
+	for i, test := range tests {
+		p, err := v.verify(context.Background(), test.token)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case !test.valid && err == nil:
+			t.Errorf("test %d: expected an error", i)
+		case test.valid && (p.Name != "alice" || !slices.Equal(p.Scopes, []string{"query", "tool:execute_query", "connection:reporting-*"}) || p.Attributes["tenant"] != "acme"):
+			t.Errorf("test %d: expected alice's principal, got: %+v", i, p)
+		}
+	}
+	// unknown keys are fetched again at most once per jwksMinRefresh
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected the keys to be fetched once, got: %d", n)
+	}
+
+	// scopes can be an array
+	p, err := v.verify(context.Background(), hs256(claims(func(c jwt.MapClaims) { c["scope"] = []string{"admin"} }), "secret"))
+	if err != nil || !slices.Equal(p.Scopes, []string{"admin"}) {
+		t.Errorf("expected the admin scope, got: %+v %v", p, err)
+	}
+
+	if _, err := newJWTVerifier(JWTConfig{}); err == nil {
+		t.Errorf("expected an error without keys")
+	}
+	if _, err := newJWTVerifier(JWTConfig{SecretEnv: "USQLR_TEST_JWT_MISSING"}); err == nil {
+		t.Errorf("expected an error without a secret")
+	}
+}
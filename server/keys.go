@@ -0,0 +1,370 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// keysVersion is the version of the keys file format.
+const keysVersion = 1
+
+// keyPrefix is the prefix of the secrets of API keys, and keyPrefixLen the
+// length of the start of secrets kept to identify keys.
+const (
+	keyPrefix    = "usqlr_"
+	keyPrefixLen = len(keyPrefix) + 6
+)
+
+// APIKey is an API key authenticating requests as its name, the principal
+// policies apply to. Only the SHA-256 hash of its secret is kept, which is
+// shown once when the key is created or rotated.
+type APIKey struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Scopes     []string          `json:"scopes"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Prefix     string            `json:"prefix"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ExpiresAt  time.Time         `json:"expires_at,omitzero"`
+	RotatedAt  time.Time         `json:"rotated_at,omitzero"`
+
+	// Hash is the hash of the key's secret.
+	Hash string `json:"hash,omitempty"`
+	// PreviousHash is the hash of the secret the key was rotated from, valid
+	// until PreviousExpiresAt.
+	PreviousHash      string    `json:"previous_hash,omitempty"`
+	PreviousExpiresAt time.Time `json:"previous_expires_at,omitzero"`
+}
+
+// Expired reports whether the key expired at the time.
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// redacted returns a copy of the key without its hashes.
+func (k APIKey) redacted() APIKey {
+	k.Hash, k.PreviousHash = "", ""
+	return k
+}
+
+// KeyRequest is a request to create an API key, expiring after its TTL
+// (such as 720h), if any.
+type KeyRequest struct {
+	Name       string            `json:"name"`
+	Scopes     []string          `json:"scopes"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	TTL        string            `json:"ttl,omitempty"`
+}
+
+// validate validates the key request, returning its TTL.
+func (req KeyRequest) validate() (time.Duration, error) {
+	if req.Name == "" {
+		return 0, errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return 0, errors.New("scopes are required")
+	}
+	for _, scope := range req.Scopes {
+		if err := validateScope(scope); err != nil {
+			return 0, err
+		}
+	}
+	if req.TTL == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("invalid ttl: %w", err)
+	case ttl <= 0:
+		return 0, errors.New("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+// CreatedKey is an API key that was created or rotated, with its secret.
+type CreatedKey struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+// keysFile is the file API keys are kept in.
+type keysFile struct {
+	Version int      `json:"version"`
+	Keys    []APIKey `json:"keys"`
+}
+
+// KeyStore holds the API keys of a server, saved to a file whenever they
+// change.
+type KeyStore struct {
+	path string
+
+	mu   sync.RWMutex
+	keys []APIKey
+}
+
+// OpenKeyStore opens the keys file at path, creating it once a key is
+// created when it does not exist.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{path: path}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// load reads the keys file, replacing the keys.
+func (ks *KeyStore) load() error {
+	buf, err := os.ReadFile(ks.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	}
+	var file keysFile
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return fmt.Errorf("invalid keys file: %w", err)
+	}
+	if file.Version != keysVersion {
+		return fmt.Errorf("unsupported keys file version %d", file.Version)
+	}
+	ks.mu.Lock()
+	ks.keys = file.Keys
+	ks.mu.Unlock()
+	return nil
+}
+
+// Reload reads the keys file again, such as once keys were changed by the
+// keys command while the server was running.
+func (ks *KeyStore) Reload() error {
+	return ks.load()
+}
+
+// save writes the keys, replacing the file once written. The keys' lock
+// must be held.
+func (ks *KeyStore) save() error {
+	buf, err := json.MarshalIndent(keysFile{Version: keysVersion, Keys: ks.keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), filepath.Base(ks.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(buf, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ks.path)
+}
+
+// List returns the keys, without their hashes.
+func (ks *KeyStore) List() []APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	keys := make([]APIKey, len(ks.keys))
+	for i, k := range ks.keys {
+		keys[i] = k.redacted()
+	}
+	return keys
+}
+
+// Create creates a key.
+func (ks *KeyStore) Create(req KeyRequest) (*CreatedKey, error) {
+	ttl, err := req.validate()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newKeySecret()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	key := APIKey{
+		ID:         newID()[:12],
+		Name:       req.Name,
+		Scopes:     req.Scopes,
+		Attributes: req.Attributes,
+		Prefix:     secret[:keyPrefixLen],
+		CreatedAt:  now,
+		Hash:       hashKey(secret),
+	}
+	if ttl != 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = append(ks.keys, key)
+	if err := ks.save(); err != nil {
+		ks.keys = ks.keys[:len(ks.keys)-1]
+		return nil, fmt.Errorf("failed to save keys: %w", err)
+	}
+	return &CreatedKey{APIKey: key.redacted(), Secret: secret}, nil
+}
+
+// Rotate replaces the secret of the key with the ID, the previous secret
+// remaining valid for the grace period.
+func (ks *KeyStore) Rotate(id string, grace time.Duration) (*CreatedKey, error) {
+	secret, err := newKeySecret()
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	i := slices.IndexFunc(ks.keys, func(k APIKey) bool { return k.ID == id })
+	if i == -1 {
+		return nil, fmt.Errorf("key %s not found", id)
+	}
+	prev := ks.keys[i]
+	key := &ks.keys[i]
+	now := time.Now().UTC()
+	key.Prefix, key.Hash, key.RotatedAt = secret[:keyPrefixLen], hashKey(secret), now
+	key.PreviousHash, key.PreviousExpiresAt = "", time.Time{}
+	if grace > 0 {
+		key.PreviousHash, key.PreviousExpiresAt = prev.Hash, now.Add(grace)
+	}
+	if err := ks.save(); err != nil {
+		ks.keys[i] = prev
+		return nil, fmt.Errorf("failed to save keys: %w", err)
+	}
+	return &CreatedKey{APIKey: key.redacted(), Secret: secret}, nil
+}
+
+// Revoke deletes the key with the ID.
+func (ks *KeyStore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	i := slices.IndexFunc(ks.keys, func(k APIKey) bool { return k.ID == id })
+	if i == -1 {
+		return fmt.Errorf("key %s not found", id)
+	}
+	prev := ks.keys
+	ks.keys = slices.Delete(slices.Clone(ks.keys), i, i+1)
+	if err := ks.save(); err != nil {
+		ks.keys = prev
+		return fmt.Errorf("failed to save keys: %w", err)
+	}
+	return nil
+}
+
+// Authenticate returns the unexpired key whose secret, or previous secret
+// within its grace period, is secret, or nil when there is none.
+func (ks *KeyStore) Authenticate(secret string) *APIKey {
+	if secret == "" {
+		return nil
+	}
+	hash := []byte(hashKey(secret))
+	now := time.Now()
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if k.Expired(now) {
+			continue
+		}
+		if subtle.ConstantTimeCompare(hash, []byte(k.Hash)) == 1 ||
+			(k.PreviousHash != "" && now.Before(k.PreviousExpiresAt) && subtle.ConstantTimeCompare(hash, []byte(k.PreviousHash)) == 1) {
+			key := k.redacted()
+			return &key
+		}
+	}
+	return nil
+}
+
+// newKeySecret returns a new random key secret.
+func newKeySecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashKey returns the hex encoded SHA-256 hash of the key secret.
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleKeys handles listing and creating API keys.
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.keys.List())
+	case http.MethodPost:
+		var req KeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		key, err := s.keys.Create(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		authLog.Info("API key created", "key", key.ID, "name", key.Name)
+		writeJSON(w, http.StatusOK, key)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// rotateRequest is the admin API request to rotate an API key, the previous
+// secret remaining valid for the grace period (such as 24h), if any.
+type rotateRequest struct {
+	Grace string `json:"grace,omitempty"`
+}
+
+// handleRotateKey handles rotating an API key's secret.
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	var req rotateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	var grace time.Duration
+	if req.Grace != "" {
+		var err error
+		if grace, err = time.ParseDuration(req.Grace); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid grace: %w", err))
+			return
+		}
+	}
+	key, err := s.keys.Rotate(r.PathValue("id"), grace)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	authLog.Info("API key rotated", "key", key.ID)
+	writeJSON(w, http.StatusOK, key)
+}
+
+// handleRevokeKey handles revoking an API key.
+func (s *Server) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.keys.Revoke(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	authLog.Info("API key revoked", "key", id)
+	writeJSON(w, http.StatusOK, map[string]string{"revoked": id})
+}
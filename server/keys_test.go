@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ks, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for i, req := range []KeyRequest{
+		{Scopes: []string{ScopeQuery}},
+		{Name: "ops"},
+		{Name: "ops", Scopes: []string{"write"}},
+		{Name: "ops", Scopes: []string{"tool:["}},
+		{Name: "ops", Scopes: []string{ScopeQuery}, TTL: "-1h"},
+	} {
+		if _, err := ks.Create(req); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+
+	key, err := ks.Create(KeyRequest{Name: "ops", Scopes: []string{ScopeAdmin}, Attributes: map[string]string{"tenant": "acme"}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !strings.HasPrefix(key.Secret, key.Prefix) || key.Hash != "" {
+		t.Errorf("expected the secret without its hash, got: %+v", key)
+	}
+	buf, _ := os.ReadFile(path)
+	if strings.Contains(string(buf), key.Secret) || !strings.Contains(string(buf), hashKey(key.Secret)) {
+		t.Errorf("expected only the secret's hash to be saved, got: %s", buf)
+	}
+
+	// keys are read from the file
+	if ks, err = OpenKeyStore(path); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	switch k := ks.Authenticate(key.Secret); {
+	case k == nil:
+		t.Fatalf("expected the key to authenticate")
+	case k.Name != "ops" || !slices.Equal(k.Scopes, []string{ScopeAdmin}) || k.Attributes["tenant"] != "acme":
+		t.Errorf("expected the ops key, got: %+v", k)
+	}
+	if ks.Authenticate("usqlr_wrong") != nil || ks.Authenticate("") != nil {
+		t.Errorf("expected other secrets not to authenticate")
+	}
+
+	// the previous secret is valid for the grace period
+	rotated, err := ks.Rotate(key.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if ks.Authenticate(rotated.Secret) == nil || ks.Authenticate(key.Secret) == nil {
+		t.Errorf("expected both secrets to authenticate during the grace period")
+	}
+	if _, err := ks.Rotate(key.ID, 0); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if ks.Authenticate(rotated.Secret) != nil {
+		t.Errorf("expected the previous secret not to authenticate without a grace period")
+	}
+	if _, err := ks.Rotate("missing", 0); err == nil {
+		t.Errorf("expected an error rotating a missing key")
+	}
+
+	// expired keys do not authenticate
+	expiring, err := ks.Create(KeyRequest{Name: "ci", Scopes: []string{ScopeAll}, TTL: "1h"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if ks.Authenticate(expiring.Secret) == nil {
+		t.Errorf("expected the key to authenticate before it expires")
+	}
+	ks.keys[1].ExpiresAt = time.Now().Add(-time.Minute)
+	if ks.Authenticate(expiring.Secret) != nil {
+		t.Errorf("expected the expired key not to authenticate")
+	}
+
+	if err := ks.Revoke(expiring.ID); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if keys := ks.List(); len(keys) != 1 || keys[0].ID != key.ID || keys[0].Hash != "" {
+		t.Errorf("expected only the ops key without its hash, got: %+v", keys)
+	}
+	if err := ks.Revoke(expiring.ID); err == nil {
+		t.Errorf("expected an error revoking a revoked key")
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	ks, err := OpenKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	admin, _ := ks.Create(KeyRequest{Name: "ops", Scopes: []string{ScopeAdmin}})
+	query, _ := ks.Create(KeyRequest{Name: "reporting", Scopes: []string{ScopeQuery}, Attributes: map[string]string{"tenant": "acme"}})
+	tenantAdmin, _ := ks.Create(KeyRequest{Name: "acme-ops", Scopes: []string{ScopeAll}, Attributes: map[string]string{"tenant": "acme"}})
+	untenanted, _ := ks.Create(KeyRequest{Name: "stray", Scopes: []string{ScopeQuery}})
+	global, _ := ks.Create(KeyRequest{Name: "auditor", Scopes: []string{ScopeQuery, ScopeGlobal}})
+
+	s := &Server{keys: ks}
+	s.conf.Store(&Config{Auth: AuthConfig{EnableAPIKey: true, APIKeyHeader: "X-API-Key", TenantAttribute: "tenant"}})
+	var principal, tenant string
+	var attrs map[string]string
+	handler := s.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, attrs, tenant = policy.PrincipalFrom(r.Context()), policy.AttributesFrom(r.Context()), policy.TenantFrom(r.Context())
+	}))
+
+	tests := []struct {
+		path, key string
+		code      int
+		principal string
+	}{
+		{"/health", "", http.StatusOK, ""},
+		{"/mcp", "", http.StatusUnauthorized, ""},
+		{"/mcp", "usqlr_wrong", http.StatusUnauthorized, ""},
+		{"/mcp", query.Secret, http.StatusOK, "reporting"},
+		{"/mcp", admin.Secret, http.StatusForbidden, ""},
+		{"/admin/keys", admin.Secret, http.StatusOK, "ops"},
+		{"/admin/keys", query.Secret, http.StatusForbidden, ""},
+		{"/admin/keys", tenantAdmin.Secret, http.StatusForbidden, ""},
+		{"/mcp", tenantAdmin.Secret, http.StatusOK, "acme-ops"},
+		// principals in no tenant are refused, unless granted every tenant
+		{"/mcp", untenanted.Secret, http.StatusUnauthorized, ""},
+		{"/mcp", global.Secret, http.StatusOK, "auditor"},
+	}
+	for i, test := range tests {
+		principal = ""
+		r := httptest.NewRequest(http.MethodPost, test.path, nil)
+		if test.key != "" {
+			r.Header.Set("X-API-Key", test.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.code || principal != test.principal {
+			t.Errorf("test %d: expected %d as %q, got: %d as %q", i, test.code, test.principal, w.Code, principal)
+		}
+		if test.principal == "auditor" && tenant != "" {
+			t.Errorf("test %d: expected no tenant, got: %q", i, tenant)
+		}
+		if test.principal == "reporting" && (attrs["tenant"] != "acme" || tenant != "acme") {
+			t.Errorf("test %d: expected the key's attributes and tenant, got: %v %q", i, attrs, tenant)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/xo/usql/drivers"
+)
+
+// connect opens and pings the connection's database.
+func (conn *Connection) connect(ctx context.Context) error {
+	// Databases reached through a tunnel are connected to at the tunnel's
+	// listener
+	u := conn.URL
+	var t *tunnel
+	if conn.route != nil {
+		var err error
+		if t, err = startTunnel(conn.route); err != nil {
+			return fmt.Errorf("failed to start tunnel: %w", err)
+		}
+		if u, err = t.url(u); err != nil {
+			t.Close()
+			return err
+		}
+	}
+
+	// Open database connection using drivers directly, refreshing the
+	// tokens of connections authenticating with tokens
+	var db *sql.DB
+	var err error
+	if conn.auth != nil {
+		db, err = openTokenDB(ctx, u, conn.auth)
+	} else {
+		db, err = drivers.Open(ctx, u, nil, nil)
+	}
+	if err != nil {
+		closeTunnel(t)
+		return fmt.Errorf("failed to open database connection: %w", err)
+	}
+	conn.settings.apply(db)
+
+	// Test connection
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		closeTunnel(t)
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	// The server version is recorded in the provenance of results
+	conn.serverVersion, _ = drivers.Version(ctx, conn.URL, db)
+	conn.DB = db
+	conn.tunnel = t
+	conn.stmts = newStmtCache(db, conn.maxStmts)
+	return nil
+}
+
+// dial opens the database of a connection registered without connecting,
+// on its first use. A connection that failed to connect is dialed again on
+// its next use.
+func (conn *Connection) dial(ctx context.Context) error {
+	if !conn.pending.Load() {
+		return nil
+	}
+	conn.dialMu.Lock()
+	defer conn.dialMu.Unlock()
+	switch {
+	case conn.closed:
+		return fmt.Errorf("connection %s is closed", conn.ID)
+	case !conn.pending.Load():
+		return nil
+	}
+	if err := conn.connect(ctx); err != nil {
+		return err
+	}
+	conn.pending.Store(false)
+	return nil
+}
+
+// closeDB closes the connection's prepared statements and database, once
+// any dial in progress is done. A connection that was never dialed has
+// nothing to close.
+func (conn *Connection) closeDB() error {
+	conn.dialMu.Lock()
+	defer conn.dialMu.Unlock()
+	conn.closed = true
+	conn.stmts.Reset()
+	conn.releaseSecrets()
+	if conn.DB == nil {
+		return nil
+	}
+	err := conn.DB.Close()
+	closeTunnel(conn.tunnel)
+	return err
+}
+
+// closeTunnel closes the tunnel, if any.
+func closeTunnel(t *tunnel) {
+	if t != nil {
+		t.Close()
+	}
+}
+
+// releaseSecrets releases the leases on the secrets resolved in the
+// connection's DSN.
+func (conn *Connection) releaseSecrets() {
+	if conn.secrets != nil {
+		conn.secrets()
+	}
+}
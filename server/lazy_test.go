@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/drivers"
+)
+
+func TestDial(t *testing.T) {
+	pc := new(pingConnector)
+	drivers.Register("lazy-test", drivers.Driver{
+		Open: func(context.Context, *dburl.URL, func() io.Writer, func() io.Writer) (func(string, string) (*sql.DB, error), error) {
+			return func(string, string) (*sql.DB, error) {
+				return sql.OpenDB(pc), nil
+			}, nil
+		},
+		Version: func(context.Context, drivers.DB) (string, error) {
+			return "1.0", nil
+		},
+	})
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	conn := &Connection{ID: "lazy", URL: &dburl.URL{Driver: "lazy-test"}, faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	conn.pending.Store(true)
+	cp.connections[conn.ID] = conn
+
+	if info := cp.ListConnections()["lazy"]; !info.Pending {
+		t.Errorf("expected the connection to be pending, got: %+v", info)
+	}
+
+	// a failed dial is retried on the next use
+	pc.down.Store(true)
+	if _, err := cp.QueryPage(context.Background(), "lazy", "SELECT a", 0, ResultLimits{}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !conn.pending.Load() || conn.DB != nil {
+		t.Fatalf("expected the connection to remain pending")
+	}
+
+	pc.down.Store(false)
+	result, err := cp.QueryPage(context.Background(), "lazy", "SELECT a", 0, ResultLimits{})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case len(result.Rows) != 2:
+		t.Errorf("expected 2 rows, got: %v", result.Rows)
+	case conn.pending.Load() || conn.serverVersion != "1.0":
+		t.Errorf("expected the connection to be dialed, got: %t %q", conn.pending.Load(), conn.serverVersion)
+	}
+
+	// a connection closed before it was dialed is not dialed
+	closed := &Connection{ID: "closed", URL: &dburl.URL{Driver: "lazy-test"}}
+	closed.pending.Store(true)
+	if err := closed.closeDB(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := closed.dial(context.Background()); err == nil || closed.DB != nil {
+		t.Errorf("expected an error, got: %v", err)
+	}
+}
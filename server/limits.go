@@ -0,0 +1,42 @@
+package server
+
+import (
+	"time"
+)
+
+// ResultLimits caps the rows and bytes read into a query result. A cap of 0
+// is no cap.
+type ResultLimits struct {
+	Rows  int   `json:"rows,omitempty"`
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// bound returns the requested limits, bounded by the limits.
+func (l ResultLimits) bound(req ResultLimits) ResultLimits {
+	if req.Rows > 0 && (l.Rows == 0 || req.Rows < l.Rows) {
+		l.Rows = req.Rows
+	}
+	if req.Bytes > 0 && (l.Bytes == 0 || req.Bytes < l.Bytes) {
+		l.Bytes = req.Bytes
+	}
+	return l
+}
+
+// rowSize returns the approximate size of a row's values, as they are held
+// in a result.
+func rowSize(values []interface{}) int64 {
+	var n int64
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			n += int64(len(v))
+		case []byte:
+			n += int64(len(v))
+		case time.Time:
+			n += 24
+		default:
+			n += 8
+		}
+	}
+	return n
+}
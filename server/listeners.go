@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Endpoints served by listeners.
+const (
+	EndpointHealth  = "health"
+	EndpointVersion = "version"
+	EndpointMCP     = "mcp"
+	EndpointOAuth   = "oauth"
+	EndpointREST    = "rest"
+	EndpointMetrics = "metrics"
+	EndpointAdmin   = "admin"
+	EndpointDebug   = "debug"
+)
+
+// endpoints are the endpoints served by listeners.
+var endpoints = []string{EndpointHealth, EndpointVersion, EndpointMCP, EndpointOAuth, EndpointREST, EndpointMetrics, EndpointAdmin, EndpointDebug}
+
+// Authentication requirements of listeners.
+const (
+	// AuthRequired authenticates requests as configured.
+	AuthRequired = "required"
+	// AuthNone serves requests without authentication, for listeners only
+	// reachable by trusted clients.
+	AuthNone = "none"
+)
+
+// Reserved listener names: the listener at the server's address, the admin
+// listener of the runtime diagnostics, and the listener of the ACME HTTP
+// challenges.
+const (
+	listenerHTTP  = "http"
+	listenerAdmin = "admin"
+	listenerACME  = "acme"
+)
+
+// ListenerConfig contains the configuration of a listener at Address, a
+// host and port, or a Unix socket path prefixed with unix:. The listener
+// serves the Endpoints (all of those enabled when not set), authenticating
+// requests unless Auth is none, over TLS with the server's TLS config when
+// TLS is set. The listener named http is the one at the server's address,
+// configured without one.
+type ListenerConfig struct {
+	Name      string   `mapstructure:"name" yaml:"name" json:"name"`
+	Address   string   `mapstructure:"address" yaml:"address" json:"address"`
+	Endpoints []string `mapstructure:"endpoints" yaml:"endpoints" json:"endpoints"`
+	Auth      string   `mapstructure:"auth" yaml:"auth" json:"auth"`
+	TLS       bool     `mapstructure:"tls" yaml:"tls" json:"tls"`
+}
+
+// serves reports whether the listener serves the endpoint.
+func (l ListenerConfig) serves(endpoint string) bool {
+	return len(l.Endpoints) == 0 || slices.Contains(l.Endpoints, endpoint)
+}
+
+// unix reports whether the listener is on a Unix socket.
+func (l ListenerConfig) unix() bool {
+	return strings.HasPrefix(l.Address, "unix:")
+}
+
+// validateListeners validates the listeners' configs.
+func validateListeners(listeners []ListenerConfig, tls bool) error {
+	names := make(map[string]bool)
+	for _, l := range listeners {
+		switch {
+		case l.Name == "" || strings.ContainsAny(l.Name, ",:"):
+			return fmt.Errorf("invalid listener name %q", l.Name)
+		case l.Name == listenerAdmin || l.Name == listenerACME:
+			return fmt.Errorf("listener name %s is reserved", l.Name)
+		case names[l.Name]:
+			return fmt.Errorf("listener %s is defined twice", l.Name)
+		case l.Name == listenerHTTP && (l.Address != "" || l.TLS):
+			return errors.New("the address and TLS of the http listener are the server's")
+		case l.Name != listenerHTTP && (l.Address == "" || l.Address == "unix:"):
+			return fmt.Errorf("listener %s requires an address", l.Name)
+		case l.TLS && !tls:
+			return fmt.Errorf("listener %s is served over TLS without server.tls configured", l.Name)
+		}
+		names[l.Name] = true
+		for _, endpoint := range l.Endpoints {
+			if !slices.Contains(endpoints, endpoint) {
+				return fmt.Errorf("listener %s: invalid endpoint %q: must be one of %s", l.Name, endpoint, strings.Join(endpoints, ", "))
+			}
+		}
+		switch l.Auth {
+		case "", AuthRequired, AuthNone:
+		default:
+			return fmt.Errorf("listener %s: invalid auth %q: must be %s or %s", l.Name, l.Auth, AuthRequired, AuthNone)
+		}
+	}
+	return nil
+}
+
+// listenerConfigs returns the configs of the listener at the address, and of
+// the other listeners: those configured, and the admin listener of the
+// runtime diagnostics when its address is set. The runtime diagnostics are
+// then only served on the admin listener by default.
+func (s *Server) listenerConfigs(addr string) (ListenerConfig, []ListenerConfig) {
+	config := s.config()
+	main := ListenerConfig{Name: listenerHTTP, Address: addr, TLS: s.certs != nil}
+	var others []ListenerConfig
+	for _, l := range config.Server.Listeners {
+		if l.Name == listenerHTTP {
+			main.Endpoints, main.Auth = l.Endpoints, l.Auth
+			continue
+		}
+		others = append(others, l)
+	}
+	if debug := config.Debug; debug.Enabled && debug.Address != "" {
+		if len(main.Endpoints) == 0 {
+			main.Endpoints = slices.DeleteFunc(slices.Clone(endpoints), func(endpoint string) bool {
+				return endpoint == EndpointDebug
+			})
+		}
+		others = append(others, ListenerConfig{Name: listenerAdmin, Address: debug.Address, Endpoints: []string{EndpointDebug}})
+	}
+	return main, others
+}
+
+// routes returns the routes of the endpoints the listener serves, of those
+// enabled.
+func (s *Server) routes(l ListenerConfig) *http.ServeMux {
+	config := s.config()
+	mux := http.NewServeMux()
+
+	// Health check endpoint
+	if l.serves(EndpointHealth) {
+		mux.HandleFunc("/health", s.handleHealth)
+	}
+
+	// Build info endpoint
+	if l.serves(EndpointVersion) {
+		mux.HandleFunc("GET /version", s.handleVersion)
+	}
+
+	// MCP endpoint (JSON-RPC 2.0)
+	if l.serves(EndpointMCP) && config.Server.EnableMCP {
+		mux.HandleFunc("/mcp", s.handleMCP)
+	}
+
+	// OAuth metadata and client registration
+	if l.serves(EndpointOAuth) && s.oauth != nil {
+		mux.HandleFunc("GET "+resourceMetadataPath, s.handleResourceMetadata)
+		mux.HandleFunc("GET "+resourceMetadataPath+"/{path...}", s.handleResourceMetadata)
+		if s.oauth.config.EnableRegistration {
+			mux.HandleFunc("GET "+authServerMetadata, s.handleAuthServerMetadata)
+			mux.HandleFunc("POST "+registrationPath, s.handleRegister)
+		}
+	}
+
+	// REST API
+	if l.serves(EndpointREST) {
+		mux.HandleFunc("POST /v1/connections/{id}/export", s.handleExport)
+		mux.HandleFunc("POST /v1/connections/{id}/query/stream", s.handleQueryStream)
+		mux.HandleFunc("POST /v1/dsn/validate", s.handleValidateDSN)
+	}
+
+	// Metrics endpoint (Prometheus)
+	if l.serves(EndpointMetrics) && s.metrics != nil {
+		mux.HandleFunc("GET "+s.metrics.path, s.handleMetrics)
+	}
+
+	// Admin API
+	if l.serves(EndpointAdmin) && config.Server.EnableAdmin {
+		s.registerAdmin(mux)
+	}
+
+	// Runtime diagnostics
+	if l.serves(EndpointDebug) && config.Debug.Enabled {
+		s.registerDebug(mux)
+	}
+	return mux
+}
+
+// listenAddress listens on the address, a host and port, or a Unix socket
+// path prefixed with unix:. The socket file left by a server not shut down
+// cleanly is removed.
+func listenAddress(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode().Type() == os.ModeSocket {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
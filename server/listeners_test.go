@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListenerRoutes(t *testing.T) {
+	s, err := New(&Config{Server: ServerConfig{EnableMCP: true, EnableAdmin: true, RequestTimeout: time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	s.keys = new(KeyStore)
+
+	public := ListenerConfig{Name: "http", Endpoints: []string{EndpointHealth, EndpointMCP}}
+	local := ListenerConfig{Name: "local", Address: "unix:/run/usqlr.sock", Endpoints: []string{EndpointVersion, EndpointAdmin}, Auth: AuthNone}
+	for _, test := range []struct {
+		l      ListenerConfig
+		method string
+		path   string
+		exp    int
+	}{
+		{public, "GET", "/health", http.StatusOK},
+		{public, "POST", "/mcp", http.StatusUnauthorized},
+		{public, "GET", "/version", http.StatusUnauthorized},
+		{public, "GET", "/admin/approvals", http.StatusUnauthorized},
+		{local, "GET", "/version", http.StatusOK},
+		{local, "GET", "/admin/approvals", http.StatusOK},
+		{local, "GET", "/health", http.StatusNotFound},
+		{local, "POST", "/mcp", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		s.middleware(s.routes(test.l), test.l).ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.exp {
+			t.Errorf("%s %s %s: expected %d, got: %d", test.l.Name, test.method, test.path, test.exp, w.Code)
+		}
+	}
+
+	// the routes not served are not found once authenticated
+	w := httptest.NewRecorder()
+	s.routes(public).ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got: %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestListenerConfigs(t *testing.T) {
+	s, err := New(&Config{
+		Server: ServerConfig{RequestTimeout: time.Minute, Listeners: []ListenerConfig{
+			{Name: "http", Auth: AuthNone},
+			{Name: "internal", Address: "127.0.0.1:9090", Endpoints: []string{EndpointAdmin}},
+		}},
+		Debug: DebugConfig{Enabled: true, Address: "127.0.0.1:6060"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	main, others := s.listenerConfigs(":8080")
+	if main.Address != ":8080" || main.Auth != AuthNone || main.serves(EndpointDebug) || !main.serves(EndpointMCP) {
+		t.Errorf("expected the http listener at :8080 without the runtime diagnostics, got: %+v", main)
+	}
+	names := make([]string, len(others))
+	for i, l := range others {
+		names[i] = l.Name
+	}
+	if !slices.Equal(names, []string{"internal", "admin"}) || !others[1].serves(EndpointDebug) || others[1].serves(EndpointMCP) {
+		t.Errorf("expected the internal and admin listeners, got: %+v", others)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	valid := []ListenerConfig{
+		{Name: "http", Endpoints: []string{EndpointMCP}},
+		{Name: "internal", Address: "127.0.0.1:9090", Endpoints: []string{EndpointAdmin, EndpointMetrics}, Auth: AuthRequired, TLS: true},
+		{Name: "local", Address: "unix:/run/usqlr.sock", Auth: AuthNone},
+	}
+	if err := validateListeners(valid, true); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	for _, listeners := range [][]ListenerConfig{
+		{{Address: ":9090"}},
+		{{Name: "a:b", Address: ":9090"}},
+		{{Name: "admin", Address: ":9090"}},
+		{{Name: "acme", Address: ":9090"}},
+		{{Name: "a", Address: ":9090"}, {Name: "a", Address: ":9091"}},
+		{{Name: "http", Address: ":9090"}},
+		{{Name: "a"}},
+		{{Name: "a", Address: "unix:"}},
+		{{Name: "a", Address: ":9090", Endpoints: []string{"sql"}}},
+		{{Name: "a", Address: ":9090", Auth: "optional"}},
+		{{Name: "a", Address: ":9090", TLS: true}},
+	} {
+		if err := validateListeners(listeners, false); err == nil {
+			t.Errorf("expected an error with %+v", listeners)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usqlr.sock")
+	ln, err := listenAddress("unix:" + path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// a socket file left behind is replaced
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if ln, err = listenAddress("unix:" + path); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return new(net.Dialer).DialContext(ctx, "unix", path)
+	}}}
+	res, err := client.Get("http://usqlr/")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected %d, got: %d", http.StatusNoContent, res.StatusCode)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xo/usql/server/logging"
+)
+
+// Loggers of the server's subsystems.
+var (
+	serverLog  = logging.Logger(logging.Server)
+	poolLog    = logging.Logger(logging.Pool)
+	mcpLog     = logging.Logger(logging.MCP)
+	authLog    = logging.Logger(logging.Auth)
+	policyLog  = logging.Logger(logging.Policy)
+	hooksLog   = logging.Logger(logging.Hooks)
+	storageLog = logging.Logger(logging.Storage)
+)
+
+// logLevelsRequest is the admin API request to set log levels: the level of
+// all subsystems when set, then those of some subsystems.
+type logLevelsRequest struct {
+	Level  string            `json:"level"`
+	Levels map[string]string `json:"levels"`
+}
+
+// handleLogLevels handles listing the log levels of the subsystems.
+func (s *Server) handleLogLevels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logging.Levels())
+}
+
+// handleSetLogLevels handles setting the log levels of the subsystems until
+// the server restarts or its config is reloaded.
+func (s *Server) handleSetLogLevels(w http.ResponseWriter, r *http.Request) {
+	var req logLevelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	levels := logging.Levels()
+	if req.Level != "" {
+		for name := range levels {
+			levels[name] = req.Level
+		}
+	}
+	for name, level := range req.Levels {
+		levels[name] = level
+	}
+	if err := logging.SetLevels(logging.Config{Level: "info", Levels: levels}); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	serverLog.Info("log levels set", "levels", logging.Levels())
+	writeJSON(w, http.StatusOK, logging.Levels())
+}
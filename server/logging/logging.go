@@ -0,0 +1,238 @@
+// Package logging provides the structured loggers of the server's
+// subsystems, writing text or JSON records to a single output, each subsystem
+// at its own level, adjustable at runtime.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Subsystems logging.
+const (
+	Server  = "server"
+	Pool    = "pool"
+	MCP     = "mcp"
+	Auth    = "auth"
+	Audit   = "audit"
+	Policy  = "policy"
+	Hooks   = "hooks"
+	Storage = "storage"
+)
+
+// Subsystems are the subsystems logging, in order.
+var Subsystems = []string{Server, Pool, MCP, Auth, Audit, Policy, Hooks, Storage}
+
+// Output formats.
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Config contains the configuration of the loggers: the level of all
+// subsystems (debug, info, warn or error, info by default), the levels of
+// some subsystems overriding it, and the format of the records (text or
+// JSON, text by default).
+type Config struct {
+	Level  string            `mapstructure:"level" yaml:"level" json:"level"`
+	Format string            `mapstructure:"format" yaml:"format" json:"format"`
+	Levels map[string]string `mapstructure:"levels" yaml:"levels" json:"levels"`
+}
+
+var (
+	// output is the handler records of all subsystems are written to.
+	output atomic.Pointer[slog.Handler]
+
+	// levels are the levels of the subsystems.
+	levels = func() map[string]*slog.LevelVar {
+		m := make(map[string]*slog.LevelVar, len(Subsystems))
+		for _, name := range Subsystems {
+			m[name] = new(slog.LevelVar)
+		}
+		return m
+	}()
+
+	// mu serializes level changes, so that they apply as a whole.
+	mu sync.Mutex
+)
+
+func init() {
+	h := newHandler(os.Stderr, Text)
+	output.Store(&h)
+}
+
+// newHandler returns the handler writing records to w in the format, at all
+// levels, which are filtered by subsystem.
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == JSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// Setup writes the records of all subsystems to w in the config's format,
+// at the config's levels, and makes the server subsystem's logger the
+// default one, to which the log package writes.
+func Setup(w io.Writer, config Config) error {
+	switch config.Format {
+	case "", Text, JSON:
+	default:
+		return fmt.Errorf("invalid log format %q: must be %s or %s", config.Format, Text, JSON)
+	}
+	if err := SetLevels(config); err != nil {
+		return err
+	}
+	h := newHandler(w, config.Format)
+	output.Store(&h)
+	slog.SetDefault(Logger(Server))
+	return nil
+}
+
+// ParseLevel parses the level name: debug, info, warn or error.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	switch strings.ToLower(name) {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return level, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// SetLevels sets the level of all subsystems to the config's level, and those
+// of the subsystems with their own. No level is set when one is invalid.
+func SetLevels(config Config) error {
+	all, err := ParseLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]slog.Level, len(Subsystems))
+	for _, name := range Subsystems {
+		set[name] = all
+	}
+	for name, v := range config.Levels {
+		if _, ok := levels[name]; !ok {
+			return fmt.Errorf("invalid log subsystem %q: must be one of %s", name, strings.Join(Subsystems, ", "))
+		}
+		if set[name], err = ParseLevel(v); err != nil {
+			return fmt.Errorf("subsystem %s: %w", name, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for name, level := range set {
+		levels[name].Set(level)
+	}
+	return nil
+}
+
+// SetLevel sets the level of the subsystem.
+func SetLevel(subsystem, name string) error {
+	v, ok := levels[subsystem]
+	if !ok {
+		return fmt.Errorf("invalid log subsystem %q: must be one of %s", subsystem, strings.Join(Subsystems, ", "))
+	}
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	v.Set(level)
+	return nil
+}
+
+// Levels returns the names of the levels of the subsystems.
+func Levels() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	m := make(map[string]string, len(levels))
+	for name, v := range levels {
+		m[name] = strings.ToLower(v.Level().String())
+	}
+	return m
+}
+
+// Logger returns the logger of the subsystem, writing records with the
+// subsystem at or above its level to the output set up, even once set up
+// again. It panics for an unknown subsystem.
+func Logger(subsystem string) *slog.Logger {
+	level, ok := levels[subsystem]
+	if !ok {
+		panic("unknown log subsystem " + subsystem)
+	}
+	return slog.New(&handler{
+		level: level,
+		attrs: []slog.Attr{slog.String("subsystem", subsystem)},
+	})
+}
+
+// handler filters records by the level of its subsystem, and writes them
+// to the current output with its attributes and groups.
+type handler struct {
+	level  *slog.LevelVar
+	attrs  []slog.Attr
+	groups []handlerGroup
+}
+
+// handlerGroup is a group opened on a handler, with the attributes added
+// within it.
+type handlerGroup struct {
+	name  string
+	attrs []slog.Attr
+}
+
+// Enabled satisfies slog.Handler.
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle satisfies slog.Handler.
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := (*output.Load()).WithAttrs(h.attrs)
+	for _, g := range h.groups {
+		out = out.WithGroup(g.name)
+		if len(g.attrs) != 0 {
+			out = out.WithAttrs(g.attrs)
+		}
+	}
+	return out.Handle(ctx, r)
+}
+
+// WithAttrs satisfies slog.Handler.
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := &handler{level: h.level, attrs: h.attrs, groups: slices.Clone(h.groups)}
+	if n := len(c.groups); n != 0 {
+		c.groups[n-1].attrs = append(slices.Clip(c.groups[n-1].attrs), attrs...)
+	} else {
+		c.attrs = append(slices.Clip(c.attrs), attrs...)
+	}
+	return c
+}
+
+// WithGroup satisfies slog.Handler.
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{
+		level:  h.level,
+		attrs:  h.attrs,
+		groups: append(slices.Clip(h.groups), handlerGroup{name: name}),
+	}
+}
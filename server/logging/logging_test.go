@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Setup(&buf, Config{Format: JSON, Levels: map[string]string{Pool: "debug"}}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer Setup(&bytes.Buffer{}, Config{})
+	pool, mcp := Logger(Pool), Logger(MCP)
+	pool.Debug("checked", "connection", "c")
+	mcp.Debug("dropped")
+	mcp.WithGroup("req").With("id", 1).Info("handled", "method", "tools/list")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got: %q", lines)
+	}
+	var first, second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if first["subsystem"] != Pool || first["level"] != "DEBUG" || first["connection"] != "c" {
+		t.Errorf("expected the pool's debug record, got: %v", first)
+	}
+	if req, _ := second["req"].(map[string]interface{}); second["subsystem"] != MCP || req["id"] != float64(1) || req["method"] != "tools/list" {
+		t.Errorf("expected the mcp record with its group, got: %v", second)
+	}
+
+	// levels apply to loggers already created
+	if err := SetLevel(MCP, "debug"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	buf.Reset()
+	mcp.Debug("kept")
+	if !strings.Contains(buf.String(), `"msg":"kept"`) {
+		t.Errorf("expected the debug record once the level is set, got: %q", buf.String())
+	}
+}
+
+func TestSetLevels(t *testing.T) {
+	defer SetLevels(Config{})
+	if err := SetLevels(Config{Level: "warn", Levels: map[string]string{Auth: "error"}}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if levels := Levels(); levels[Server] != "warn" || levels[Auth] != "error" || len(levels) != len(Subsystems) {
+		t.Errorf("expected the levels set, got: %v", levels)
+	}
+	if err := SetLevels(Config{Levels: map[string]string{Auth: "loud"}}); err == nil {
+		t.Errorf("expected an error with an invalid level")
+	}
+	if err := SetLevels(Config{Level: "debug", Levels: map[string]string{"db": "info"}}); err == nil {
+		t.Errorf("expected an error with an unknown subsystem")
+	}
+	if levels := Levels(); levels[Server] != "warn" {
+		t.Errorf("expected no level set by invalid levels, got: %v", levels)
+	}
+	if err := Setup(&bytes.Buffer{}, Config{Format: "xml"}); err == nil {
+		t.Errorf("expected an error with an invalid format")
+	}
+}
@@ -0,0 +1,424 @@
+// Package logstore provides tiered storage for append-only records, such as
+// query history and audit events.
+//
+// Recent records are held in memory (the hot tier), and rotated to gzip
+// compressed JSON lines segment files (the cold tier) once the hot tier
+// exceeds its size or age limit. Cold segments are deleted once they exceed
+// the retention limits, so a store can run indefinitely without unbounded
+// disk growth.
+package logstore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xo/usql/server/logging"
+)
+
+// logger is the logger of the storage subsystem.
+var logger = logging.Logger(logging.Storage)
+
+// Config is the configuration of a store. Limits of 0 mean unlimited.
+type Config struct {
+	// Dir is the directory of the cold tier, in which each store keeps its
+	// segments in a subdirectory. Without a directory, records rotated out
+	// of the hot tier are discarded.
+	Dir string `mapstructure:"dir" yaml:"dir" json:"dir"`
+	// HotRecords is the maximum number of records held in memory.
+	HotRecords int `mapstructure:"hot_records" yaml:"hot_records" json:"hot_records"`
+	// HotMaxAge is the maximum age of records held in memory.
+	HotMaxAge time.Duration `mapstructure:"hot_max_age" yaml:"hot_max_age" json:"hot_max_age"`
+	// ColdMaxAge is the age after which cold segments are deleted.
+	ColdMaxAge time.Duration `mapstructure:"cold_max_age" yaml:"cold_max_age" json:"cold_max_age"`
+	// ColdMaxBytes is the maximum total size of the cold segments, beyond
+	// which the oldest are deleted.
+	ColdMaxBytes int64 `mapstructure:"cold_max_bytes" yaml:"cold_max_bytes" json:"cold_max_bytes"`
+}
+
+// Record is a stored record.
+type Record struct {
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Stats are a store's tier sizes.
+type Stats struct {
+	HotRecords   int        `json:"hot_records"`
+	ColdSegments int        `json:"cold_segments"`
+	ColdBytes    int64      `json:"cold_bytes"`
+	Oldest       *time.Time `json:"oldest,omitempty"`
+}
+
+// Store is a tiered record store.
+type Store struct {
+	name   string
+	config Config
+	dir    string
+
+	mu   sync.Mutex
+	hot  []Record
+	stop chan struct{}
+	done chan struct{}
+}
+
+// segment is a cold tier segment file, holding the records from start to
+// end.
+type segment struct {
+	path       string
+	start, end time.Time
+	size       int64
+}
+
+// Open opens the named store, starting its rotation loop.
+func Open(name string, config Config) (*Store, error) {
+	s := &Store{
+		name:   name,
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if config.Dir != "" {
+		s.dir = filepath.Join(config.Dir, name)
+		if err := os.MkdirAll(s.dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create %s store directory: %w", name, err)
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// Name returns the store's name.
+func (s *Store) Name() string {
+	return s.name
+}
+
+// run periodically rotates aged records out of the hot tier and enforces
+// the cold tier retention limits until the store is closed.
+func (s *Store) run() {
+	defer close(s.done)
+	interval := time.Minute
+	if d := s.config.HotMaxAge / 2; d > 0 && d < interval {
+		interval = d
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			err := s.rotate(now)
+			s.mu.Unlock()
+			if err != nil {
+				logger.Error("store rotation error", "store", s.name, "error", err)
+			}
+		}
+	}
+}
+
+// Append appends a record of v, encoded as JSON, to the store.
+func (s *Store) Append(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	s.hot = append(s.hot, Record{Time: now, Data: data})
+	if s.config.HotRecords > 0 && len(s.hot) > s.config.HotRecords {
+		return s.rotate(now)
+	}
+	return nil
+}
+
+// Records returns the latest limit records at or after since, oldest first,
+// from both tiers. A limit of 0 returns all records.
+func (s *Store) Records(since time.Time, limit int) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+	for i := len(s.hot) - 1; i >= 0 && (limit == 0 || len(records) < limit); i-- {
+		if s.hot[i].Time.Before(since) {
+			break
+		}
+		records = append(records, s.hot[i])
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(segments) - 1; i >= 0 && (limit == 0 || len(records) < limit); i-- {
+		if segments[i].end.Before(since) {
+			break
+		}
+		recs, err := readSegment(segments[i].path)
+		if err != nil {
+			return nil, err
+		}
+		for j := len(recs) - 1; j >= 0 && (limit == 0 || len(records) < limit); j-- {
+			if recs[j].Time.Before(since) {
+				break
+			}
+			records = append(records, recs[j])
+		}
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+// Rotate moves all records in the hot tier to the cold tier, and enforces
+// the cold tier retention limits.
+func (s *Store) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(len(s.hot)); err != nil {
+		return err
+	}
+	return s.retain(time.Now())
+}
+
+// Purge deletes the records before the time from both tiers, returning the
+// number of records deleted. A zero time deletes all records.
+func (s *Store) Purge(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.hot)
+	if !before.IsZero() {
+		n = s.count(before)
+	}
+	s.hot = append([]Record(nil), s.hot[n:]...)
+
+	segments, err := s.segments()
+	if err != nil {
+		return n, err
+	}
+	for _, seg := range segments {
+		if !before.IsZero() && !seg.start.Before(before) {
+			break
+		}
+		recs, err := readSegment(seg.path)
+		if err != nil {
+			return n, err
+		}
+		var keep []Record
+		if !before.IsZero() {
+			keep = recs[countBefore(recs, before):]
+		}
+		if len(keep) != 0 {
+			if err := s.writeSegment(keep); err != nil {
+				return n, err
+			}
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return n, err
+		}
+		n += len(recs) - len(keep)
+	}
+	return n, nil
+}
+
+// Stats returns the store's tier sizes.
+func (s *Store) Stats() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{HotRecords: len(s.hot)}
+	segments, err := s.segments()
+	if err != nil {
+		return stats, err
+	}
+	stats.ColdSegments = len(segments)
+	for _, seg := range segments {
+		stats.ColdBytes += seg.size
+	}
+	switch {
+	case len(segments) != 0:
+		stats.Oldest = &segments[0].start
+	case len(s.hot) != 0:
+		stats.Oldest = &s.hot[0].Time
+	}
+	return stats, nil
+}
+
+// Close stops the rotation loop, and moves the hot tier records to the cold
+// tier.
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(len(s.hot))
+}
+
+// rotate moves records beyond the hot tier limits to the cold tier, and
+// enforces the cold tier retention limits. The lock must be held.
+func (s *Store) rotate(now time.Time) error {
+	n := 0
+	if s.config.HotMaxAge > 0 {
+		n = s.count(now.Add(-s.config.HotMaxAge))
+	}
+	if s.config.HotRecords > 0 && len(s.hot)-n > s.config.HotRecords {
+		n = len(s.hot) - s.config.HotRecords
+	}
+	if err := s.flush(n); err != nil {
+		return err
+	}
+	return s.retain(now)
+}
+
+// count returns the number of hot tier records before the time. The lock
+// must be held.
+func (s *Store) count(before time.Time) int {
+	return countBefore(s.hot, before)
+}
+
+// flush moves the first n hot tier records to a new cold segment, or
+// discards them when there is no cold tier. The lock must be held.
+func (s *Store) flush(n int) error {
+	if n == 0 {
+		return nil
+	}
+	if s.dir != "" {
+		if err := s.writeSegment(s.hot[:n]); err != nil {
+			return err
+		}
+	}
+	s.hot = append([]Record(nil), s.hot[n:]...)
+	return nil
+}
+
+// retain deletes the cold segments beyond the retention limits. The lock
+// must be held.
+func (s *Store) retain(now time.Time) error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+	for _, seg := range segments {
+		expired := s.config.ColdMaxAge > 0 && seg.end.Before(now.Add(-s.config.ColdMaxAge))
+		if !expired && (s.config.ColdMaxBytes == 0 || total <= s.config.ColdMaxBytes) {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		total -= seg.size
+	}
+	return nil
+}
+
+// segments returns the cold segments, oldest first. The lock must be held.
+func (s *Store) segments() ([]segment, error) {
+	if s.dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []segment
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jsonl.gz")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		start, end, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+		a, err1 := strconv.ParseInt(start, 10, 64)
+		b, err2 := strconv.ParseInt(end, 10, 64)
+		info, err3 := entry.Info()
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		segments = append(segments, segment{
+			path:  filepath.Join(s.dir, entry.Name()),
+			start: time.Unix(0, a).UTC(),
+			end:   time.Unix(0, b).UTC(),
+			size:  info.Size(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+	return segments, nil
+}
+
+// writeSegment writes the records to a new cold segment, named after the
+// time range of the records.
+func (s *Store) writeSegment(records []Record) error {
+	name := fmt.Sprintf("%d-%d.jsonl.gz", records[0].Time.UnixNano(), records[len(records)-1].Time.UnixNano())
+	path := filepath.Join(s.dir, name)
+	f, err := os.CreateTemp(s.dir, ".segment-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readSegment reads the records of a cold segment.
+func readSegment(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	var records []Record
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	for dec.More() {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// countBefore returns the number of records, in time order, before the time.
+func countBefore(records []Record, before time.Time) int {
+	return sort.Search(len(records), func(i int) bool {
+		return !records[i].Time.Before(before)
+	})
+}
@@ -0,0 +1,107 @@
+package logstore
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s, err := Open("history", Config{Dir: t.TempDir(), HotRecords: 2})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Close()
+	for i := 1; i <= 5; i++ {
+		if err := s.Append(i); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stats, err := s.Stats()
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case stats.HotRecords != 2 || stats.ColdSegments == 0 || stats.ColdBytes == 0:
+		t.Errorf("expected 2 hot records and cold segments, got: %+v", stats)
+	}
+
+	records, err := s.Records(time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if exp := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(values(t, records), exp) {
+		t.Fatalf("expected %v, got: %v", exp, values(t, records))
+	}
+	latest, err := s.Records(time.Time{}, 3)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if exp := []int{3, 4, 5}; !reflect.DeepEqual(values(t, latest), exp) {
+		t.Errorf("expected %v, got: %v", exp, values(t, latest))
+	}
+
+	n, err := s.Purge(records[2].Time)
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case n != 2:
+		t.Errorf("expected 2 records purged, got: %d", n)
+	}
+	records, _ = s.Records(time.Time{}, 0)
+	if exp := []int{3, 4, 5}; !reflect.DeepEqual(values(t, records), exp) {
+		t.Errorf("expected %v after purge, got: %v", exp, values(t, records))
+	}
+
+	if n, _ := s.Purge(time.Time{}); n != 3 {
+		t.Errorf("expected 3 records purged, got: %d", n)
+	}
+	if stats, _ := s.Stats(); stats.HotRecords != 0 || stats.ColdSegments != 0 {
+		t.Errorf("expected empty store, got: %+v", stats)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	s, err := Open("audit", Config{Dir: t.TempDir(), HotRecords: 1, ColdMaxBytes: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Close()
+	for i := 1; i <= 4; i++ {
+		s.Append(i)
+	}
+	if stats, _ := s.Stats(); stats.ColdSegments != 0 {
+		t.Errorf("expected cold segments beyond the size limit to be deleted, got: %+v", stats)
+	}
+	records, _ := s.Records(time.Time{}, 0)
+	if exp := []int{4}; !reflect.DeepEqual(values(t, records), exp) {
+		t.Errorf("expected %v, got: %v", exp, values(t, records))
+	}
+
+	hot, err := Open("hot", Config{HotRecords: 2})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer hot.Close()
+	for i := 1; i <= 3; i++ {
+		hot.Append(i)
+	}
+	records, _ = hot.Records(time.Time{}, 0)
+	if exp := []int{2, 3}; !reflect.DeepEqual(values(t, records), exp) {
+		t.Errorf("expected %v without a cold tier, got: %v", exp, values(t, records))
+	}
+}
+
+func values(t *testing.T, records []Record) []int {
+	var v []int
+	for _, rec := range records {
+		var i int
+		if err := json.Unmarshal(rec.Data, &i); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		v = append(v, i)
+	}
+	return v
+}
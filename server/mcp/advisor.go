@@ -0,0 +1,66 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// advisorTools returns the query tuning tools.
+func advisorTools() []Tool {
+	return []Tool{
+		{
+			Name:        "advise_indexes",
+			Description: "Analyze a query's plan and table statistics, suggesting candidate indexes for the tables it fully scans. Results are suggestions only, and should be validated before creating any index. Supports PostgreSQL, MySQL and SQLite",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the database connection to use",
+					},
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "The SQL query to analyze (it is explained, not executed)",
+					},
+					"args": argsProperty,
+					"params": map[string]interface{}{
+						"type":        "object",
+						"description": "Optional named parameter values for queries using :name or @name parameters (instead of args)",
+					},
+				},
+				"required": []string{"connection_id", "query"},
+			},
+		},
+	}
+}
+
+// toolAdviseIndexes implements the advise_indexes tool.
+func (h *Handler) toolAdviseIndexes(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	connectionID, ok := args["connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+
+	query, ok := args["query"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "query is required")
+	}
+
+	conn, err := h.pool.GetConnection(connectionID)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("connection not found: %s", connectionID))
+	}
+
+	queryArgs, err := parseArgs(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	advice, err := conn.AdviseIndexes(ctx, query, queryArgs...)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Index analysis failed", errorData(err))
+	}
+
+	return h.sendToolResult(w, req.ID, advice)
+}
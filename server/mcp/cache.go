@@ -0,0 +1,39 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// cacheTools returns the tools for managing the result cache.
+func cacheTools() []Tool {
+	return []Tool{
+		{
+			Name:        "invalidate_cache",
+			Description: "Invalidate cached query results, so the next execution of a query reads fresh data from the database (e.g. after the database was changed outside the server)",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "Optional ID of the connection to invalidate cached results of. When not given, all cached results are invalidated (required of tenants)",
+					},
+				},
+			},
+		},
+	}
+}
+
+// toolInvalidateCache implements the invalidate_cache tool.
+func (h *Handler) toolInvalidateCache(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	connectionID, _ := args["connection_id"].(string)
+	if connectionID == "" && policy.TenantFrom(ctx) != "" {
+		// the cache of other tenants' connections is left as is
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+	return h.sendToolResult(w, req.ID, map[string]interface{}{
+		"invalidated": h.pool.InvalidateCache(connectionID),
+	})
+}
@@ -0,0 +1,213 @@
+package mcp
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxChartRows is the maximum number of rows of results charted.
+const maxChartRows = 1000
+
+// vegaLiteMimeType is the media type of Vega-Lite specifications.
+const vegaLiteMimeType = "application/vnd.vegalite.v5+json"
+
+// chartProperty is the input schema property requesting a chart of the
+// result.
+var chartProperty = map[string]interface{}{
+	"type":        "boolean",
+	"description": "Also return a Vega-Lite chart of the result, as a resource content block, when it is an aggregate suitable for charting (a category or time column and numeric columns)",
+}
+
+// columnKind is the kind of values in a result column.
+type columnKind int
+
+// Column kinds.
+const (
+	kindNominal columnKind = iota
+	kindNumber
+	kindTemporal
+)
+
+// chartContent returns a resource content block with a Vega-Lite chart of
+// the result, or nil when the result is not suitable for charting.
+func chartContent(result *QueryResult) map[string]interface{} {
+	spec := chartSpec(result)
+	if spec == nil {
+		return nil
+	}
+	buf, err := json.Marshal(spec)
+	if err != nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"type": "resource",
+		"resource": map[string]interface{}{
+			"uri":      "chart://result",
+			"mimeType": vegaLiteMimeType,
+			"text":     string(buf),
+		},
+	}
+}
+
+// chartSpec returns a Vega-Lite specification charting the result's numeric
+// columns against its first time or category column, as a line or bar
+// chart, or a scatter plot of a result of two numeric columns. Returns nil
+// when the result is not suitable for charting.
+func chartSpec(result *QueryResult) map[string]interface{} {
+	if len(result.Rows) == 0 || len(result.Rows) > maxChartRows || len(result.MoreResultSets) != 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range result.Columns {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+	}
+
+	kinds := make([]columnKind, len(result.Columns))
+	x, color := -1, -1
+	var ys []int
+	for i := range result.Columns {
+		kinds[i] = kindOf(result, i)
+		switch {
+		case kinds[i] == kindNumber:
+			ys = append(ys, i)
+		case x == -1 || (kinds[i] == kindTemporal && kinds[x] != kindTemporal):
+			if x != -1 && color == -1 {
+				color = x
+			}
+			x = i
+		case color == -1:
+			color = i
+		}
+	}
+
+	encoding := make(map[string]interface{})
+	mark := "bar"
+	switch {
+	case x == -1 && len(ys) == 2 && len(result.Columns) == 2:
+		mark = "point"
+		encoding["x"] = map[string]interface{}{"field": fieldName(result.Columns[ys[0]]), "type": "quantitative"}
+		encoding["y"] = map[string]interface{}{"field": fieldName(result.Columns[ys[1]]), "type": "quantitative"}
+	case x == -1 || len(ys) == 0:
+		return nil
+	default:
+		typ := "nominal"
+		if kinds[x] == kindTemporal {
+			mark, typ = "line", "temporal"
+		}
+		encoding["x"] = map[string]interface{}{"field": fieldName(result.Columns[x]), "type": typ, "sort": nil}
+		encoding["y"] = map[string]interface{}{"field": fieldName(result.Columns[ys[0]]), "type": "quantitative"}
+		if len(ys) == 1 && color != -1 {
+			encoding["color"] = map[string]interface{}{"field": fieldName(result.Columns[color]), "type": "nominal"}
+		}
+	}
+
+	spec := map[string]interface{}{
+		"$schema":  "https://vega.github.io/schema/vega-lite/v5.json",
+		"data":     map[string]interface{}{"values": chartValues(result, kinds)},
+		"mark":     map[string]interface{}{"type": mark, "tooltip": true},
+		"encoding": encoding,
+	}
+
+	// chart multiple numeric columns as series
+	if x != -1 && len(ys) > 1 {
+		fields := make([]string, len(ys))
+		for i, y := range ys {
+			fields[i] = result.Columns[y]
+		}
+		spec["transform"] = []interface{}{
+			map[string]interface{}{"fold": fields, "as": []string{"series", "value"}},
+		}
+		encoding["y"] = map[string]interface{}{"field": "value", "type": "quantitative"}
+		encoding["color"] = map[string]interface{}{"field": "series", "type": "nominal"}
+		if mark == "bar" {
+			encoding["xOffset"] = map[string]interface{}{"field": "series"}
+		}
+	}
+	return spec
+}
+
+// kindOf returns the kind of values in a result column, from its database
+// type name, or its values.
+func kindOf(result *QueryResult, i int) columnKind {
+	if i < len(result.ColumnTypes) {
+		typ := strings.ToUpper(result.ColumnTypes[i])
+		switch {
+		case strings.Contains(typ, "INTERVAL"):
+			return kindNominal
+		case strings.Contains(typ, "DATE") || strings.Contains(typ, "TIME"):
+			return kindTemporal
+		case strings.Contains(typ, "INT") || strings.Contains(typ, "FLOAT") || strings.Contains(typ, "DOUBLE") ||
+			strings.Contains(typ, "REAL") || strings.Contains(typ, "DECIMAL") || strings.Contains(typ, "NUMERIC") ||
+			strings.Contains(typ, "NUMBER") || typ == "MONEY":
+			return kindNumber
+		}
+	}
+	kind, found := kindNominal, false
+	for _, row := range result.Rows {
+		var k columnKind
+		switch v := row[i].(type) {
+		case nil:
+			continue
+		case int64, float64, int, int32, float32, json.Number:
+			k = kindNumber
+		case time.Time:
+			k = kindTemporal
+		case string:
+			if _, ok := parseTime(v); !ok {
+				return kindNominal
+			}
+			k = kindTemporal
+		default:
+			return kindNominal
+		}
+		if found && k != kind {
+			return kindNominal
+		}
+		kind, found = k, true
+	}
+	return kind
+}
+
+// chartValues returns the rows of the result as Vega-Lite data values, with
+// numbers scanned as strings (e.g. DECIMAL) converted to numbers.
+func chartValues(result *QueryResult, kinds []columnKind) []map[string]interface{} {
+	values := make([]map[string]interface{}, len(result.Rows))
+	for i, row := range result.Rows {
+		values[i] = make(map[string]interface{}, len(row))
+		for j, v := range row {
+			switch x := v.(type) {
+			case string:
+				if kinds[j] == kindNumber {
+					if f, err := strconv.ParseFloat(x, 64); err == nil {
+						v = f
+					}
+				}
+			case time.Time:
+				v = x.Format(time.RFC3339Nano)
+			}
+			values[i][result.Columns[j]] = v
+		}
+	}
+	return values
+}
+
+// parseTime parses a date or timestamp string.
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fieldName escapes the characters Vega-Lite treats as nested field
+// accessors in a column name.
+func fieldName(name string) string {
+	return strings.NewReplacer(`\`, `\\`, ".", `\.`, "[", `\[`, "]", `\]`).Replace(name)
+}
@@ -0,0 +1,59 @@
+package mcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChartSpec(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		result *QueryResult
+		mark   string
+		x, y   string
+		color  string
+	}{
+		{&QueryResult{Columns: []string{"region", "total"}, ColumnTypes: []string{"TEXT", "DECIMAL"}, Rows: [][]interface{}{{"eu", "1.5"}, {"us", "2"}}}, "bar", "region", "total", ""},
+		{&QueryResult{Columns: []string{"day", "n"}, Rows: [][]interface{}{{day, int64(1)}}}, "line", "day", "n", ""},
+		{&QueryResult{Columns: []string{"day", "n"}, Rows: [][]interface{}{{"2024-01-02", int64(1)}}}, "line", "day", "n", ""},
+		{&QueryResult{Columns: []string{"region", "day", "n"}, Rows: [][]interface{}{{"eu", day, int64(1)}}}, "line", "day", "n", "region"},
+		{&QueryResult{Columns: []string{"region", "a", "b"}, Rows: [][]interface{}{{"eu", int64(1), 2.5}}}, "bar", "region", "value", "series"},
+		{&QueryResult{Columns: []string{"a", "b"}, Rows: [][]interface{}{{int64(1), 2.5}}}, "point", "a", "b", ""},
+		{&QueryResult{Columns: []string{"a.b", "n"}, Rows: [][]interface{}{{"x", int64(1)}}}, "bar", `a\.b`, "n", ""},
+		{&QueryResult{Columns: []string{"name"}, Rows: [][]interface{}{{"x"}}}, "", "", "", ""},
+		{&QueryResult{Columns: []string{"name", "n"}}, "", "", "", ""},
+		{&QueryResult{Columns: []string{"n", "n"}, Rows: [][]interface{}{{"x", int64(1)}}}, "", "", "", ""},
+	}
+	for i, test := range tests {
+		spec := chartSpec(test.result)
+		if test.mark == "" {
+			if spec != nil {
+				t.Errorf("test %d: expected no chart, got: %v", i, spec)
+			}
+			continue
+		}
+		if spec == nil {
+			t.Errorf("test %d: expected chart", i)
+			continue
+		}
+		encoding := spec["encoding"].(map[string]interface{})
+		field := func(channel string) string {
+			if v, ok := encoding[channel].(map[string]interface{}); ok {
+				return v["field"].(string)
+			}
+			return ""
+		}
+		mark := spec["mark"].(map[string]interface{})["type"]
+		if mark != test.mark || field("x") != test.x || field("y") != test.y || field("color") != test.color {
+			t.Errorf("test %d: expected %s of %s by %s (color %q), got: %s of %s by %s (color %q)", i, test.mark, test.y, test.x, test.color, mark, field("y"), field("x"), field("color"))
+		}
+	}
+}
+
+func TestChartValues(t *testing.T) {
+	result := &QueryResult{Columns: []string{"region", "total"}, ColumnTypes: []string{"TEXT", "NUMERIC"}, Rows: [][]interface{}{{"eu", "1.50"}}}
+	values := chartValues(result, []columnKind{kindNominal, kindNumber})
+	if v := values[0]["total"]; v != 1.5 {
+		t.Errorf("expected 1.5, got: %#v", v)
+	}
+}
@@ -0,0 +1,112 @@
+package mcp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// connectionFilter selects the connections listed by their tags and driver,
+// and orders them.
+type connectionFilter struct {
+	tags   map[string]string
+	driver string
+	// sort is the field connections are sorted by, descending when desc
+	sort string
+	desc bool
+}
+
+// parseConnectionFilter parses the filter of listed connections from the
+// tags, driver and sort parameters, returning nil when none are given. Sort
+// is id, driver, created or last_used, prefixed by - for descending order.
+func parseConnectionFilter(params map[string]interface{}) (*connectionFilter, error) {
+	var f connectionFilter
+	given := false
+	if v, ok := params["tags"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("tags must be an object of strings")
+		}
+		f.tags = make(map[string]string, len(m))
+		for k, v := range m {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("tags must be an object of strings")
+			}
+			f.tags[k] = s
+		}
+		given = true
+	}
+	if v, ok := params["driver"]; ok {
+		if f.driver, ok = v.(string); !ok {
+			return nil, fmt.Errorf("driver must be a string")
+		}
+		given = true
+	}
+	f.sort = "id"
+	if v, ok := params["sort"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("sort must be a string")
+		}
+		f.sort, f.desc = strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+		switch f.sort {
+		case "id", "driver", "created", "last_used":
+		default:
+			return nil, fmt.Errorf("invalid sort %q: must be id, driver, created or last_used", s)
+		}
+		given = true
+	}
+	if !given {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// apply returns the connections matching the filter, in order.
+func (f *connectionFilter) apply(connections map[string]ConnectionInfo) []ConnectionInfo {
+	list := make([]ConnectionInfo, 0, len(connections))
+	for _, info := range connections {
+		if f.matches(info) {
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if n := f.compare(list[i], list[j]); n != 0 {
+			return n < 0
+		}
+		// ties are ordered by ID
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// compare compares the connections by the filter's sort field and order.
+func (f *connectionFilter) compare(a, b ConnectionInfo) int {
+	if f.desc {
+		a, b = b, a
+	}
+	switch f.sort {
+	case "driver":
+		return strings.Compare(a.Driver, b.Driver)
+	case "created":
+		return a.Created.Compare(b.Created)
+	case "last_used":
+		return a.LastUsed.Compare(b.LastUsed)
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// matches returns whether the connection has all of the filter's tags, and
+// its driver.
+func (f *connectionFilter) matches(info ConnectionInfo) bool {
+	if f.driver != "" && info.Driver != f.driver {
+		return false
+	}
+	for k, v := range f.tags {
+		if tag, ok := info.Tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package mcp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+func TestConnectionFilter(t *testing.T) {
+	now := time.Now()
+	connections := map[string]ConnectionInfo{
+		"a": {ID: "a", Driver: "postgres", Created: now, Tags: map[string]string{"env": "prod", "team": "payments"}},
+		"b": {ID: "b", Driver: "mysql", Created: now.Add(-time.Hour), Tags: map[string]string{"env": "prod"}},
+		"c": {ID: "c", Driver: "postgres", Created: now.Add(-2 * time.Hour), Tags: map[string]string{"env": "dev"}},
+	}
+	tests := []struct {
+		params map[string]interface{}
+		exp    []string
+	}{
+		{map[string]interface{}{"sort": "id"}, []string{"a", "b", "c"}},
+		{map[string]interface{}{"tags": map[string]interface{}{"env": "prod"}}, []string{"a", "b"}},
+		{map[string]interface{}{"tags": map[string]interface{}{"env": "prod", "team": "payments"}}, []string{"a"}},
+		{map[string]interface{}{"tags": map[string]interface{}{"team": "growth"}}, []string{}},
+		{map[string]interface{}{"driver": "postgres", "sort": "-id"}, []string{"c", "a"}},
+		{map[string]interface{}{"sort": "created"}, []string{"c", "b", "a"}},
+		{map[string]interface{}{"sort": "-driver"}, []string{"a", "c", "b"}},
+	}
+	for i, test := range tests {
+		f, err := parseConnectionFilter(test.params)
+		if err != nil || f == nil {
+			t.Fatalf("test %d: expected a filter, got: %v %v", i, f, err)
+		}
+		ids := []string{}
+		for _, info := range f.apply(connections) {
+			ids = append(ids, info.ID)
+		}
+		if len(ids) != len(test.exp) {
+			t.Errorf("test %d: expected %v, got: %v", i, test.exp, ids)
+			continue
+		}
+		for j := range ids {
+			if ids[j] != test.exp[j] {
+				t.Errorf("test %d: expected %v, got: %v", i, test.exp, ids)
+				break
+			}
+		}
+	}
+
+	if f, err := parseConnectionFilter(map[string]interface{}{"uri": "connections://list"}); f != nil || err != nil {
+		t.Errorf("expected no filter, got: %v %v", f, err)
+	}
+	for _, params := range []map[string]interface{}{{"sort": "size"}, {"tags": map[string]interface{}{"env": 1}}, {"driver": true}} {
+		if _, err := parseConnectionFilter(params); err == nil {
+			t.Errorf("expected an error for %v", params)
+		}
+	}
+}
+
+func TestQualifyArguments(t *testing.T) {
+	args := map[string]interface{}{
+		"connection_id": "reporting",
+		"alias":         "payments/primary",
+		"aliases":       []interface{}{"replica"},
+		"query":         "SELECT 1",
+	}
+	qualifyArguments(context.Background(), args)
+	if args["connection_id"] != "reporting" {
+		t.Errorf("expected the arguments to be unchanged without a tenant, got: %v", args)
+	}
+	qualifyArguments(policy.WithTenant(context.Background(), "payments"), args)
+	exp := map[string]interface{}{
+		"connection_id": "payments/reporting",
+		"alias":         "payments/primary",
+		"aliases":       []interface{}{"payments/replica"},
+		"query":         "SELECT 1",
+	}
+	if !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected %v, got: %v", exp, args)
+	}
+}
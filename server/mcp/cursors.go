@@ -0,0 +1,178 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// defaultFetchSize is the number of rows returned by fetch when no count is
+// given.
+const defaultFetchSize = 100
+
+// cursorTools returns the tools for working with server-side cursors.
+func cursorTools() []Tool {
+	return []Tool{
+		{
+			Name:        "open_cursor",
+			Description: "Execute a SQL query and hold its result set open server-side, returning a cursor to fetch rows from in chunks",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the database connection to use",
+					},
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "The SQL query to execute",
+					},
+					"args":        argsProperty,
+					"approval_id": approvalProperty,
+					"params": map[string]interface{}{
+						"type":        "object",
+						"description": "Optional named parameter values for queries using :name or @name parameters (instead of args)",
+					},
+				},
+				"required": []string{"connection_id", "query"},
+			},
+		},
+		{
+			Name:        "fetch",
+			Description: "Fetch the next rows from an open cursor",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"cursor_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the cursor returned by open_cursor",
+					},
+					"count": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("The maximum number of rows to fetch (default %d)", defaultFetchSize),
+					},
+					"filter":        filterProperty,
+					"time_format":   timeFormatProperty,
+					"time_zone":     timeZoneProperty,
+					"nulls":         nullsProperty,
+					"null_sentinel": nullSentinelProperty,
+				},
+				"required": []string{"cursor_id"},
+			},
+		},
+		{
+			Name:        "close_cursor",
+			Description: "Close an open cursor, releasing its result set",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"cursor_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the cursor to close",
+					},
+				},
+				"required": []string{"cursor_id"},
+			},
+		},
+	}
+}
+
+// toolOpenCursor implements the open_cursor tool.
+func (h *Handler) toolOpenCursor(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	connectionID, ok := args["connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+
+	query, ok := args["query"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "query is required")
+	}
+
+	// Parse query arguments if provided
+	queryArgs, err := parseArgs(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	cursor, err := h.pool.OpenCursor(ctx, connectionID, query, queryArgs...)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Cursor open failed", errorData(err))
+	}
+	if session := sessionFrom(ctx); session != nil {
+		session.AddCursor(cursor.CursorID)
+	}
+
+	return h.sendToolResult(w, req.ID, cursor)
+}
+
+// toolFetch implements the fetch tool.
+func (h *Handler) toolFetch(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	cursorID, ok := args["cursor_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "cursor_id is required")
+	}
+
+	count := defaultFetchSize
+	if v, exists := args["count"]; exists {
+		n, ok := v.(float64)
+		if !ok || n < 1 {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "count must be a positive integer")
+		}
+		count = int(n)
+	}
+
+	filter, err := parseFilter(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	times, err := h.parseTimes(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	nullFormat, err := h.parseNulls(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	page, err := h.pool.FetchCursor(ctx, cursorID, count)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Cursor fetch failed", errorData(err))
+	}
+	page.Rows = nullFormat.Rows(times.Rows(page.Rows))
+
+	v, err := applyFilter(filter, page)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	return h.sendToolResult(w, req.ID, v)
+}
+
+// toolCloseCursor implements the close_cursor tool.
+func (h *Handler) toolCloseCursor(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	cursorID, ok := args["cursor_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "cursor_id is required")
+	}
+
+	if err := h.pool.CloseCursor(ctx, cursorID); err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Cursor close failed", err.Error())
+	}
+	if session := sessionFrom(ctx); session != nil {
+		session.RemoveCursor(cursorID)
+	}
+
+	response := map[string]interface{}{
+		"content": []map[string]interface{}{
+			{
+				"type": "text",
+				"text": fmt.Sprintf("Successfully closed cursor: %s", cursorID),
+			},
+		},
+	}
+
+	return h.sendSuccessResponse(w, req.ID, response)
+}
@@ -0,0 +1,78 @@
+package mcp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Deprecation is the notice of a deprecated tool or endpoint, removed from
+// its sunset date.
+type Deprecation struct {
+	Deprecated time.Time `json:"deprecated,omitzero"`
+	Sunset     time.Time `json:"sunset,omitzero"`
+	Link       string    `json:"link,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// Removed returns whether the sunset date has passed at now.
+func (d Deprecation) Removed(now time.Time) bool {
+	return !d.Sunset.IsZero() && !now.Before(d.Sunset)
+}
+
+// SetHeaders sets the Deprecation (RFC 9745), Sunset (RFC 8594) and Link
+// headers of the notice.
+func (d Deprecation) SetHeaders(h http.Header) {
+	if !d.Deprecated.IsZero() {
+		h.Set("Deprecation", fmt.Sprintf("@%d", d.Deprecated.Unix()))
+	}
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		h.Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", d.Link))
+	}
+}
+
+// String satisfies the fmt.Stringer interface.
+func (d Deprecation) String() string {
+	s := "Deprecated"
+	if !d.Deprecated.IsZero() {
+		s += " since " + formatDate(d.Deprecated)
+	}
+	if !d.Sunset.IsZero() {
+		s += ", removed on " + formatDate(d.Sunset)
+	}
+	if d.Message != "" {
+		s += ": " + d.Message
+	}
+	if d.Link != "" {
+		s += " (see " + d.Link + ")"
+	}
+	return s
+}
+
+// formatDate formats t as a date, with its time when not midnight UTC.
+func formatDate(t time.Time) string {
+	t = t.UTC()
+	if h, m, s := t.Clock(); h == 0 && m == 0 && s == 0 {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// deprecateTools returns the tools with the deprecated tools' descriptions
+// prefixed by their notices, without the tools removed at now.
+func (h *Handler) deprecateTools(tools []Tool, now time.Time) []Tool {
+	var result []Tool
+	for _, tool := range tools {
+		if d, ok := h.deprecations[tool.Name]; ok {
+			if d.Removed(now) {
+				continue
+			}
+			tool.Description = fmt.Sprintf("(%s) %s", d, tool.Description)
+		}
+		result = append(result, tool)
+	}
+	return result
+}
@@ -0,0 +1,23 @@
+package mcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeprecateTools(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	h := &Handler{deprecations: map[string]Deprecation{
+		"a": {Deprecated: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Sunset: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Message: "use c"},
+		"b": {Sunset: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}}
+	tools := []Tool{{Name: "a", Description: "A."}, {Name: "b", Description: "B."}, {Name: "c", Description: "C."}}
+	exp := []Tool{
+		{Name: "a", Description: "(Deprecated since 2024-01-02, removed on 2024-06-01T12:00:00Z: use c) A."},
+		{Name: "c", Description: "C."},
+	}
+	if res := h.deprecateTools(tools, now); !reflect.DeepEqual(res, exp) {
+		t.Errorf("expected %v, got: %v", exp, res)
+	}
+}
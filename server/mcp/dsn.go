@@ -0,0 +1,143 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xo/dburl"
+)
+
+// dsnFields are the arguments of create_connection a DSN is composed from,
+// instead of the dsn argument.
+var dsnFields = []string{"driver", "host", "port", "user", "password_ref", "database", "params"}
+
+// passwordRef matches a secret reference, such as ${env:DB_PASSWORD}.
+var passwordRef = regexp.MustCompile(`^\$\{[a-z]+:[^}]*\}$`)
+
+// passwordPlaceholder stands in for the password reference while the DSN is
+// escaped, as the reference is resolved, and its secret escaped, when the
+// connection is created.
+const passwordPlaceholder = "PASSWORDREF"
+
+// buildDSN composes the DSN from the structured fields of the arguments,
+// returning "" when none are given. The fields are escaped, and the DSN
+// validated, so that names and passwords need no URL escaping. A DSN
+// without a host is a file path (e.g. sqlite3:/var/lib/app.db) of the
+// database field.
+func buildDSN(args map[string]interface{}) (string, error) {
+	fields := make(map[string]string)
+	given := false
+	for _, name := range dsnFields {
+		v, ok := args[name]
+		if !ok {
+			continue
+		}
+		given = true
+		switch name {
+		case "port":
+			n, ok := v.(float64)
+			if !ok || n != float64(int(n)) || n < 1 || n > 65535 {
+				return "", fmt.Errorf("port must be an integer between 1 and 65535")
+			}
+			fields[name] = strconv.Itoa(int(n))
+		case "params":
+		default:
+			s, ok := v.(string)
+			if !ok {
+				return "", fmt.Errorf("%s must be a string", name)
+			}
+			fields[name] = s
+		}
+	}
+	if !given {
+		return "", nil
+	}
+
+	query := url.Values{}
+	if v, ok := args["params"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("params must be an object")
+		}
+		for k, v := range m {
+			switch v.(type) {
+			case string, float64, bool:
+				query.Set(k, fmt.Sprint(v))
+			default:
+				return "", fmt.Errorf("param %s must be a string, number or boolean", k)
+			}
+		}
+	}
+	switch ref := fields["password_ref"]; {
+	case fields["driver"] == "":
+		return "", fmt.Errorf("driver is required")
+	case ref != "" && !passwordRef.MatchString(ref):
+		return "", fmt.Errorf("password_ref must be a secret reference, such as ${env:DB_PASSWORD}")
+	case ref != "" && fields["user"] == "":
+		return "", fmt.Errorf("password_ref requires a user")
+	case fields["host"] == "" && (fields["port"] != "" || fields["user"] != ""):
+		return "", fmt.Errorf("port and user require a host")
+	}
+
+	u := &url.URL{Scheme: fields["driver"], RawQuery: query.Encode()}
+	if fields["host"] == "" {
+		u.Opaque = fields["database"]
+	} else {
+		u.Host = fields["host"]
+		if port := fields["port"]; port != "" {
+			u.Host = net.JoinHostPort(fields["host"], port)
+		}
+		if fields["database"] != "" {
+			u.Path = "/" + fields["database"]
+		}
+		switch {
+		case fields["password_ref"] != "":
+			u.User = url.UserPassword(fields["user"], passwordPlaceholder)
+		case fields["user"] != "":
+			u.User = url.User(fields["user"])
+		}
+	}
+	dsn := u.String()
+	if _, err := dburl.Parse(dsn); err != nil {
+		return "", fmt.Errorf("invalid DSN fields: %w", err)
+	}
+	if ref := fields["password_ref"]; ref != "" {
+		dsn = strings.Replace(dsn, ":"+passwordPlaceholder+"@", ":"+ref+"@", 1)
+	}
+	return dsn, nil
+}
+
+// dsnTools returns the tools for building DSNs.
+func dsnTools() []Tool {
+	return []Tool{
+		{
+			Name:        "validate_dsn",
+			Description: "Validate a DSN without connecting to its database, reporting the driver it resolves to, whether the driver is available, and the normalized DSN with its password redacted",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"dsn": map[string]interface{}{
+						"type":        "string",
+						"description": "The DSN to validate. Secret references (e.g. ${env:DB_PASSWORD}) are not resolved",
+					},
+				},
+				"required": []string{"dsn"},
+			},
+		},
+	}
+}
+
+// toolValidateDSN implements the validate_dsn tool.
+func (h *Handler) toolValidateDSN(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	dsn, ok := args["dsn"].(string)
+	if !ok || dsn == "" {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "dsn is required")
+	}
+	return h.sendToolResult(w, req.ID, h.pool.ValidateDSN(dsn))
+}
@@ -0,0 +1,47 @@
+package mcp
+
+import "testing"
+
+func TestBuildDSN(t *testing.T) {
+	tests := []struct {
+		args map[string]interface{}
+		exp  string
+	}{
+		{map[string]interface{}{}, ""},
+		{map[string]interface{}{
+			"driver":       "postgres",
+			"host":         "db.example.com",
+			"port":         float64(6432),
+			"user":         "app@corp",
+			"password_ref": "${env:DB_PASSWORD}",
+			"database":     "my app",
+			"params":       map[string]interface{}{"sslmode": "require", "connect_timeout": float64(5)},
+		}, "postgres://app%40corp:${env:DB_PASSWORD}@db.example.com:6432/my%20app?connect_timeout=5&sslmode=require"},
+		{map[string]interface{}{"driver": "mysql", "host": "db", "user": "app"}, "mysql://app@db"},
+		{map[string]interface{}{"driver": "sqlite3", "database": "/var/lib/app.db"}, "sqlite3:/var/lib/app.db"},
+	}
+	for i, test := range tests {
+		dsn, err := buildDSN(test.args)
+		switch {
+		case err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case dsn != test.exp:
+			t.Errorf("test %d: expected %q, got: %q", i, test.exp, dsn)
+		}
+	}
+
+	for i, args := range []map[string]interface{}{
+		{"host": "db"},
+		{"driver": "nosuchdriver", "host": "db"},
+		{"driver": "postgres", "host": "db", "port": float64(0)},
+		{"driver": "postgres", "host": "db", "port": "5432"},
+		{"driver": "postgres", "host": "db", "user": "app", "password_ref": "secret"},
+		{"driver": "postgres", "host": "db", "password_ref": "${env:DB_PASSWORD}"},
+		{"driver": "postgres", "user": "app", "database": "app"},
+		{"driver": "postgres", "host": "db", "params": map[string]interface{}{"sslmode": []interface{}{}}},
+	} {
+		if _, err := buildDSN(args); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+}
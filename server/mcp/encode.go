@@ -0,0 +1,104 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// prettyProperty is the input schema property for indenting a JSON result.
+var prettyProperty = map[string]interface{}{
+	"type":        "boolean",
+	"description": "Indent the JSON result for readability (defaults to compact JSON, which is smaller)",
+}
+
+// sendToolJSON sends v as JSON text content as a tool result, followed by
+// the further content blocks. The JSON is encoded straight into the
+// response's text content, rather than into a string that is then encoded
+// again, and is indented when pretty.
+func (h *Handler) sendToolJSON(w http.ResponseWriter, id interface{}, v interface{}, pretty bool, content ...map[string]interface{}) error {
+	var rest []byte
+	for _, c := range content {
+		buf, err := json.Marshal(c)
+		if err != nil {
+			return h.sendErrorResponse(w, id, -32603, "Internal error", err.Error())
+		}
+		rest = append(append(rest, ','), buf...)
+	}
+	idJSON, err := json.Marshal(id)
+	if err != nil {
+		return h.sendErrorResponse(w, id, -32603, "Internal error", err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(&jsonStringWriter{
+		w:      bw,
+		prefix: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"`,
+	})
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	// the encoder only writes once v is encoded, so nothing has been written
+	// when it fails
+	if err := enc.Encode(v); err != nil {
+		return h.sendErrorResponse(w, id, -32603, "Internal error", err.Error())
+	}
+	bw.WriteString(`"}`)
+	bw.Write(rest)
+	bw.WriteString(`]}`)
+	if id != nil {
+		bw.WriteString(`,"id":`)
+		bw.Write(idJSON)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// jsonStringWriter writes JSON text as the contents of a JSON string,
+// escaped, after writing the prefix. The newline ending the JSON written by
+// a json.Encoder is dropped.
+type jsonStringWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+// Write satisfies the io.Writer interface.
+func (sw *jsonStringWriter) Write(p []byte) (int, error) {
+	if sw.prefix != "" {
+		if _, err := io.WriteString(sw.w, sw.prefix); err != nil {
+			return 0, err
+		}
+		sw.prefix = ""
+	}
+	n := len(p)
+	if n != 0 && p[n-1] == '\n' {
+		p = p[:n-1]
+	}
+	start := 0
+	for i, c := range p {
+		var esc string
+		switch c {
+		case '"':
+			esc = `\"`
+		case '\\':
+			esc = `\\`
+		case '\n':
+			esc = `\n`
+		default:
+			continue
+		}
+		if _, err := sw.w.Write(p[start:i]); err != nil {
+			return 0, err
+		}
+		if _, err := io.WriteString(sw.w, esc); err != nil {
+			return 0, err
+		}
+		start = i + 1
+	}
+	if _, err := sw.w.Write(p[start:]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,49 @@
+package mcp
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSendToolJSON(t *testing.T) {
+	v := map[string]interface{}{"rows": [][]interface{}{{"a \"quoted\"\nline", `back\slash`, "<b>", 1.5, nil}}}
+	chart := map[string]interface{}{"type": "text", "text": "chart"}
+	for _, pretty := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		if err := new(Handler).sendToolJSON(w, float64(7), v, pretty, chart); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		var resp struct {
+			Result struct {
+				Content []map[string]interface{} `json:"content"`
+			} `json:"result"`
+			ID interface{} `json:"id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("expected a valid response, got: %v\n%s", err, w.Body.String())
+		}
+		exp, _ := json.Marshal(v)
+		if pretty {
+			exp, _ = json.MarshalIndent(v, "", "  ")
+		}
+		switch content := resp.Result.Content; {
+		case resp.ID != float64(7):
+			t.Errorf("expected id 7, got: %v", resp.ID)
+		case len(content) != 2 || !reflect.DeepEqual(content[1], chart):
+			t.Errorf("expected the JSON and chart content, got: %v", content)
+		case content[0]["text"] != string(exp):
+			t.Errorf("pretty %t: expected:\n%s\ngot:\n%s", pretty, exp, content[0]["text"])
+		}
+	}
+
+	// values that cannot be encoded are an error response
+	w := httptest.NewRecorder()
+	new(Handler).sendToolJSON(w, float64(1), math.NaN(), false)
+	var resp JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == nil {
+		t.Errorf("expected an error response, got: %s", w.Body.String())
+	}
+}
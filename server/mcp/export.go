@@ -0,0 +1,101 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// maxExportBytes is the maximum size of an exported file returned to the
+// client, rather than written to a path.
+const maxExportBytes = 10 << 20
+
+// exportMimeTypes are the media types of the export formats.
+var exportMimeTypes = map[string]string{
+	"xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportTools returns the tools for exporting query results as files.
+func exportTools() []Tool {
+	return []Tool{
+		exportTool("export_xlsx", "Execute a SQL query and export its result as an Excel workbook, with a header row, typed cells (numbers, booleans and dates), and a sheet per result set"),
+		exportTool("export_parquet", "Execute a SQL query and export its result as an Apache Parquet file, with columns typed from the database column types, for loading into DuckDB, pandas and other analytics tools"),
+	}
+}
+
+// exportTool returns an export tool.
+func exportTool(name, description string) Tool {
+	return Tool{
+		Name:        name,
+		Description: description,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"connection_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The ID of the database connection to use",
+				},
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "The SQL query to execute",
+				},
+				"args":        argsProperty,
+				"approval_id": approvalProperty,
+				"params": map[string]interface{}{
+					"type":        "object",
+					"description": "Optional named parameter values for queries using :name or @name parameters (instead of args)",
+				},
+				"path": map[string]interface{}{
+					"type":        "string",
+					"description": "Path to write the file to on the server, relative to its export directory. When not given, the file is returned as an embedded resource",
+				},
+			},
+			"required": []string{"connection_id", "query"},
+		},
+	}
+}
+
+// toolExport implements the export tools, exporting the result of a query
+// as a file in the format.
+func (h *Handler) toolExport(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, format string, args map[string]interface{}) error {
+	connectionID, ok := args["connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+
+	query, ok := args["query"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "query is required")
+	}
+
+	path, _ := args["path"].(string)
+
+	// Parse query arguments if provided
+	queryArgs, err := parseArgs(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	info, err := h.pool.Export(ctx, connectionID, query, format, path, queryArgs...)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Export failed", errorData(err))
+	}
+	if path == "" && len(info.Data) > maxExportBytes {
+		return h.sendErrorResponse(w, req.ID, -32603, "Export failed", fmt.Sprintf("exported file is too large to return (%d bytes), export it to a path instead", len(info.Data)))
+	}
+
+	var content []map[string]interface{}
+	if path == "" {
+		content = append(content, map[string]interface{}{
+			"type": "resource",
+			"resource": map[string]interface{}{
+				"uri":      "export://result." + format,
+				"mimeType": exportMimeTypes[format],
+				"blob":     base64.StdEncoding.EncodeToString(info.Data),
+			},
+		})
+	}
+	return h.sendToolJSON(w, req.ID, info, false, content...)
+}
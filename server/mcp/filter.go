@@ -0,0 +1,52 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// filterProperty is the input schema property for a result filter.
+var filterProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "Optional JMESPath expression applied to the JSON result, returning only the matching part (e.g. rows[0][0] or rows[?[1] > `10`])",
+}
+
+// parseFilter compiles the filter argument, if provided.
+func parseFilter(args map[string]interface{}) (*jmespath.JMESPath, error) {
+	v, exists := args["filter"]
+	if !exists || v == nil {
+		return nil, nil
+	}
+	expr, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("filter must be a string")
+	}
+	filter, err := jmespath.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return filter, nil
+}
+
+// applyFilter applies the filter to the JSON representation of v. A nil
+// filter returns v unchanged.
+func applyFilter(filter *jmespath.JMESPath, v interface{}) (interface{}, error) {
+	if filter == nil {
+		return v, nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return nil, err
+	}
+	res, err := filter.Search(data)
+	if err != nil {
+		return nil, fmt.Errorf("filter failed: %w", err)
+	}
+	return res, nil
+}
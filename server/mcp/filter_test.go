@@ -0,0 +1,122 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	result := &QueryResult{
+		Columns: []string{"id", "total"},
+		Rows:    [][]interface{}{{int64(1), 5.5}, {int64(2), 20.0}, {int64(3), 12.0}},
+	}
+	tests := []struct {
+		filter interface{}
+		exp    interface{}
+	}{
+		{nil, result},
+		{"columns", []interface{}{"id", "total"}},
+		{"rows[0][0]", float64(1)},
+		{"rows[*][0]", []interface{}{float64(1), float64(2), float64(3)}},
+		{"rows[?[1] > `10`][0]", []interface{}{float64(2), float64(3)}},
+		{"{ids: rows[*][0], n: length(rows)}", map[string]interface{}{"ids": []interface{}{float64(1), float64(2), float64(3)}, "n": float64(3)}},
+		{"missing", nil},
+	}
+	for i, test := range tests {
+		filter, err := parseFilter(map[string]interface{}{"filter": test.filter})
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		v, err := applyFilter(filter, result)
+		switch {
+		case err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case !reflect.DeepEqual(v, test.exp):
+			t.Errorf("test %d: expected %v, got: %v", i, test.exp, v)
+		}
+	}
+
+	if filter, err := parseFilter(map[string]interface{}{}); filter != nil || err != nil {
+		t.Errorf("expected no filter, got: %v %v", filter, err)
+	}
+	for _, v := range []interface{}{"rows[", "rows[?", 1.0} {
+		if _, err := parseFilter(map[string]interface{}{"filter": v}); err == nil {
+			t.Errorf("expected an error for %v", v)
+		}
+	}
+	// expressions can fail on the result's values
+	filter, _ := parseFilter(map[string]interface{}{"filter": "abs(columns)"})
+	if _, err := applyFilter(filter, result); err == nil {
+		t.Errorf("expected an error applying the filter")
+	}
+}
+
+func TestFetchFilter(t *testing.T) {
+	pool := &filterPool{page: &CursorPage{
+		CursorID:  "c",
+		Rows:      [][]interface{}{{int64(1), "a"}, {int64(2), "b"}},
+		ExpiresAt: time.Now().Add(time.Minute),
+	}}
+	h, err := New(pool, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		filter  string
+		code    int
+		exp     string
+		fetched bool
+	}{
+		{"rows[*][1]", 0, `["a","b"]`, true},
+		{"{done: done, n: length(rows)}", 0, `{"done":false,"n":2}`, true},
+		{"rows[", -32602, "", false},
+		{"abs(rows)", -32602, "", true},
+	}
+	for _, test := range tests {
+		pool.fetched = false
+		w := httptest.NewRecorder()
+		if err := h.toolFetch(context.Background(), w, &JSONRPCRequest{ID: float64(1)}, map[string]interface{}{"cursor_id": "c", "filter": test.filter}); err != nil {
+			t.Fatalf("%s: expected no error, got: %v", test.filter, err)
+		}
+		var resp struct {
+			Result struct {
+				Content []map[string]interface{} `json:"content"`
+			} `json:"result"`
+			Error *JSONRPCError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: expected a valid response, got: %v", test.filter, err)
+		}
+		var text bytes.Buffer
+		if len(resp.Result.Content) == 1 {
+			s, _ := resp.Result.Content[0]["text"].(string)
+			json.Compact(&text, []byte(s))
+		}
+		switch {
+		case pool.fetched != test.fetched:
+			t.Errorf("%s: expected fetched %t", test.filter, test.fetched)
+		case test.code != 0 && (resp.Error == nil || resp.Error.Code != test.code):
+			t.Errorf("%s: expected error %d, got: %s", test.filter, test.code, w.Body.String())
+		case test.code == 0 && (resp.Error != nil || text.String() != test.exp):
+			t.Errorf("%s: expected %s, got: %s", test.filter, test.exp, w.Body.String())
+		}
+	}
+}
+
+// filterPool is a connection pool whose cursors return the page.
+type filterPool struct {
+	ConnectionPool
+	page    *CursorPage
+	fetched bool
+}
+
+func (p *filterPool) FetchCursor(context.Context, string, int) (*CursorPage, error) {
+	p.fetched = true
+	page := *p.page
+	return &page, nil
+}
@@ -0,0 +1,216 @@
+package mcp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/xo/tblfmt"
+)
+
+// resultFormats are the formats query results can be rendered in.
+var resultFormats = []string{"json", "csv", "markdown", "aligned", "html", "vertical"}
+
+// formatProperty is the input schema property for a result format.
+var formatProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "Optional format to render the result in, instead of JSON (markdown is compact for AI clients, aligned matches usql's default table output)",
+	"enum":        resultFormats,
+}
+
+// psetProperty is the input schema property for result format options.
+var psetProperty = map[string]interface{}{
+	"type":        "object",
+	"description": "Optional usql \\pset options applied when rendering the result in a format other than json (e.g. {\"border\": \"2\", \"null\": \"NULL\"})",
+}
+
+// parseFormat returns the format and pset arguments, if provided.
+func parseFormat(args map[string]interface{}) (string, map[string]string, error) {
+	format := "json"
+	if v, exists := args["format"]; exists && v != nil {
+		s, ok := v.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("format must be a string")
+		}
+		format = s
+	}
+	valid := false
+	for _, f := range resultFormats {
+		valid = valid || f == format
+	}
+	if !valid {
+		return "", nil, fmt.Errorf("unknown format %q (expected one of: %s)", format, strings.Join(resultFormats, ", "))
+	}
+
+	pset := make(map[string]string)
+	if v, exists := args["pset"]; exists && v != nil {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", nil, fmt.Errorf("pset must be an object")
+		}
+		for k, v := range m {
+			pset[k] = fmt.Sprint(v)
+		}
+	}
+	return format, pset, nil
+}
+
+// renderResult renders the result, and any further result sets, in the
+// format with usql's table formatting, applying the pset options.
+func renderResult(result *QueryResult, format string, pset map[string]string) (string, error) {
+	return renderRows(&resultSet{sets: append([]*QueryResult{result}, result.MoreResultSets...)}, format, pset)
+}
+
+// renderRows renders the rows of each result set as they are read, in the
+// format with usql's table formatting, applying the pset options.
+func renderRows(rows Rows, format string, pset map[string]string) (string, error) {
+	params := map[string]string{"border": "1"}
+	for k, v := range pset {
+		params[k] = v
+	}
+	params["format"] = format
+
+	var buf bytes.Buffer
+	if format == "markdown" {
+		if err := encodeMarkdown(&buf, rows, params["null"]); err != nil {
+			return "", fmt.Errorf("failed to render result: %w", err)
+		}
+		return buf.String(), nil
+	}
+	if err := tblfmt.EncodeAll(&buf, rows, params); err != nil {
+		return "", fmt.Errorf("failed to render result: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// encodeMarkdown writes the result sets as GitHub flavored markdown tables,
+// formatting values with usql's value formatter. Columns of numbers are
+// right aligned.
+func encodeMarkdown(buf *bytes.Buffer, rs Rows, null string) error {
+	f := tblfmt.NewEscapeFormatter()
+	for i := 0; ; i++ {
+		columns, err := rs.Columns()
+		if err != nil {
+			return err
+		}
+		if i != 0 {
+			buf.WriteByte('\n')
+		}
+		var rows [][]string
+		right := make([]bool, len(columns))
+		for j := range right {
+			right[j] = true
+		}
+		for rs.Next() {
+			row := make([]interface{}, len(columns))
+			ptrs := make([]interface{}, len(row))
+			for k := range row {
+				ptrs[k] = &row[k]
+			}
+			if err := rs.Scan(ptrs...); err != nil {
+				return err
+			}
+			vals, err := f.Format(ptrs)
+			if err != nil {
+				return err
+			}
+			r := make([]string, len(vals))
+			for k, v := range vals {
+				if v == nil {
+					r[k] = null
+					continue
+				}
+				r[k] = v.String()
+				right[k] = right[k] && v.Align == tblfmt.AlignRight
+			}
+			rows = append(rows, r)
+		}
+		if err := rs.Err(); err != nil {
+			return err
+		}
+		markdownRow(buf, columns)
+		for _, r := range right {
+			if r && len(rows) != 0 {
+				buf.WriteString("| --: ")
+			} else {
+				buf.WriteString("| --- ")
+			}
+		}
+		buf.WriteString("|\n")
+		for _, row := range rows {
+			markdownRow(buf, row)
+		}
+		if !rs.NextResultSet() {
+			return nil
+		}
+	}
+}
+
+// markdownRow writes a markdown table row.
+func markdownRow(buf *bytes.Buffer, values []string) {
+	for _, v := range values {
+		buf.WriteString("| ")
+		buf.WriteString(markdownEscaper.Replace(v))
+		buf.WriteByte(' ')
+	}
+	buf.WriteString("|\n")
+}
+
+// markdownEscaper escapes the characters in values that would break a
+// markdown table.
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+// resultSet is Rows over buffered query result sets.
+type resultSet struct {
+	sets     []*QueryResult
+	set, row int
+}
+
+// Next satisfies the Rows interface.
+func (rs *resultSet) Next() bool {
+	if rs.row == len(rs.sets[rs.set].Rows) {
+		return false
+	}
+	rs.row++
+	return true
+}
+
+// Scan satisfies the Rows interface.
+func (rs *resultSet) Scan(dest ...interface{}) error {
+	row := rs.sets[rs.set].Rows[rs.row-1]
+	if len(dest) != len(row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(row), len(dest))
+	}
+	for i, v := range row {
+		d, ok := dest[i].(*interface{})
+		if !ok {
+			return fmt.Errorf("unsupported Scan destination %T", dest[i])
+		}
+		*d = v
+	}
+	return nil
+}
+
+// Columns satisfies the Rows interface.
+func (rs *resultSet) Columns() ([]string, error) {
+	return rs.sets[rs.set].Columns, nil
+}
+
+// Close satisfies the Rows interface.
+func (rs *resultSet) Close() error {
+	return nil
+}
+
+// Err satisfies the Rows interface.
+func (rs *resultSet) Err() error {
+	return nil
+}
+
+// NextResultSet satisfies the Rows interface.
+func (rs *resultSet) NextResultSet() bool {
+	if rs.set == len(rs.sets)-1 {
+		return false
+	}
+	rs.set, rs.row = rs.set+1, 0
+	return true
+}
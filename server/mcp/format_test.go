@@ -0,0 +1,44 @@
+package mcp
+
+import "testing"
+
+func TestRenderResult(t *testing.T) {
+	result := &QueryResult{
+		Columns: []string{"id", "name"},
+		Rows:    [][]interface{}{{int64(1), "a|b"}, {int64(22), "two\nlines"}, {nil, "c"}},
+		MoreResultSets: []*QueryResult{
+			{Columns: []string{"z"}, Rows: [][]interface{}{{true}}},
+		},
+	}
+	tests := []struct {
+		format string
+		pset   map[string]string
+		exp    string
+	}{
+		{"markdown", nil, "| id | name |\n| --: | --- |\n| 1 | a\\|b |\n| 22 | two<br>lines |\n|  | c |\n\n| z |\n| --- |\n| true |\n"},
+		{"markdown", map[string]string{"null": "NULL"}, "| id | name |\n| --: | --- |\n| 1 | a\\|b |\n| 22 | two<br>lines |\n| NULL | c |\n\n| z |\n| --- |\n| true |\n"},
+		{"csv", nil, "id,name\n1,a|b\n22,\"two\nlines\"\n,c\n\nz\ntrue\n"},
+	}
+	for i, test := range tests {
+		s, err := renderResult(result, test.format, test.pset)
+		switch {
+		case err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case s != test.exp:
+			t.Errorf("test %d: expected:\n%q\ngot:\n%q", i, test.exp, s)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if _, _, err := parseFormat(map[string]interface{}{"format": "yaml"}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	format, pset, err := parseFormat(map[string]interface{}{"format": "aligned", "pset": map[string]interface{}{"border": float64(2)}})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case format != "aligned" || pset["border"] != "2":
+		t.Errorf("expected aligned with border 2, got: %s %v", format, pset)
+	}
+}
@@ -0,0 +1,104 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// HistoryEntry is a query (or statement) recorded in the query history.
+type HistoryEntry struct {
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	ConnectionID string    `json:"connection_id"`
+	Principal    string    `json:"principal,omitempty"`
+	Session      string    `json:"session,omitempty"`
+	SQL          string    `json:"sql"`
+	Rows         int64     `json:"rows"`
+	DurationMS   float64   `json:"duration_ms"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// HistoryFilter selects the entries of the query history, by the text of
+// their SQL, connection, session, principal and time range, up to a limit.
+type HistoryFilter struct {
+	Text         string
+	ConnectionID string
+	Session      string
+	Principal    string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// parseHistoryFilter parses the history filter of the resource read params:
+// text, connection_id, session_id, principal, since and until (RFC 3339
+// times), and limit (100 by default).
+func parseHistoryFilter(ctx context.Context, params map[string]interface{}) (HistoryFilter, error) {
+	filter := HistoryFilter{Limit: 100}
+	for name, v := range map[string]*string{
+		"text":          &filter.Text,
+		"connection_id": &filter.ConnectionID,
+		"session_id":    &filter.Session,
+		"principal":     &filter.Principal,
+	} {
+		if p, ok := params[name]; ok {
+			if *v, ok = p.(string); !ok {
+				return filter, fmt.Errorf("%s must be a string", name)
+			}
+		}
+	}
+	if filter.ConnectionID != "" {
+		filter.ConnectionID = policy.QualifyConnection(ctx, filter.ConnectionID)
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if p, ok := params[name]; ok {
+			s, ok := p.(string)
+			if !ok {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			var err error
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time: %w", name, err)
+			}
+		}
+	}
+	if p, ok := params["limit"]; ok {
+		n, ok := p.(float64)
+		if !ok || n < 0 {
+			return filter, fmt.Errorf("limit must be a non-negative number")
+		}
+		filter.Limit = int(n)
+	}
+	return filter, nil
+}
+
+// readQueryHistory returns the entries of the query history matching the
+// filter, on the connections the principal may use.
+func (h *Handler) readQueryHistory(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, filter HistoryFilter) error {
+	entries, err := h.pool.SearchHistory(ctx, filter)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Internal error", err.Error())
+	}
+	historyJSON, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Internal error", err.Error())
+	}
+
+	result := map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{
+				"uri":      "history://queries",
+				"mimeType": "application/json",
+				"text":     string(historyJSON),
+			},
+		},
+	}
+
+	return h.sendSuccessResponse(w, req.ID, result)
+}
@@ -0,0 +1,198 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// maxJobWait is the maximum time job_status will wait for a job to finish.
+const maxJobWait = 60 * time.Second
+
+// jobTools returns the tools for working with asynchronous query jobs.
+func jobTools() []Tool {
+	jobID := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"job_id": map[string]interface{}{
+				"type":        "string",
+				"description": "The ID of the job returned by submit_query",
+			},
+		},
+		"required": []string{"job_id"},
+	}
+	return []Tool{
+		{
+			Name:        "submit_query",
+			Description: "Submit a long-running SQL query for asynchronous execution, returning a job to poll for its result",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the database connection to use",
+					},
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "The SQL query to execute",
+					},
+					"args":        argsProperty,
+					"approval_id": approvalProperty,
+					"params": map[string]interface{}{
+						"type":        "object",
+						"description": "Optional named parameter values for queries using :name or @name parameters (instead of args)",
+					},
+				},
+				"required": []string{"connection_id", "query"},
+			},
+		},
+		{
+			Name:        "job_status",
+			Description: "Get the status of an asynchronous query job, optionally waiting for it to finish",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"job_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the job returned by submit_query",
+					},
+					"wait_seconds": map[string]interface{}{
+						"type":        "number",
+						"description": "Wait up to this many seconds (max 60) for the job to finish before returning",
+					},
+				},
+				"required": []string{"job_id"},
+			},
+		},
+		{
+			Name:        "job_result",
+			Description: "Get the result of a finished asynchronous query job",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"job_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the job returned by submit_query",
+					},
+					"filter":        filterProperty,
+					"time_format":   timeFormatProperty,
+					"time_zone":     timeZoneProperty,
+					"nulls":         nullsProperty,
+					"null_sentinel": nullSentinelProperty,
+				},
+				"required": []string{"job_id"},
+			},
+		},
+		{
+			Name:        "cancel_job",
+			Description: "Cancel a queued or running asynchronous query job",
+			InputSchema: jobID,
+		},
+	}
+}
+
+// toolSubmitQuery implements the submit_query tool.
+func (h *Handler) toolSubmitQuery(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	connectionID, ok := args["connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+
+	query, ok := args["query"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "query is required")
+	}
+
+	// Parse query arguments if provided
+	queryArgs, err := parseArgs(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	info, err := h.pool.SubmitJob(ctx, connectionID, query, queryArgs...)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Job submission failed", errorData(err))
+	}
+
+	return h.sendToolResult(w, req.ID, info)
+}
+
+// toolJobStatus implements the job_status tool.
+func (h *Handler) toolJobStatus(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	jobID, ok := args["job_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "job_id is required")
+	}
+
+	var wait time.Duration
+	if v, exists := args["wait_seconds"]; exists {
+		n, ok := v.(float64)
+		if !ok || n < 0 {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "wait_seconds must be a non-negative number")
+		}
+		wait = min(time.Duration(n*float64(time.Second)), maxJobWait)
+	}
+
+	info, err := h.pool.JobStatus(ctx, jobID, wait)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	return h.sendToolResult(w, req.ID, info)
+}
+
+// toolJobResult implements the job_result tool.
+func (h *Handler) toolJobResult(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	jobID, ok := args["job_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "job_id is required")
+	}
+
+	filter, err := parseFilter(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	times, err := h.parseTimes(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	nullFormat, err := h.parseNulls(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	info, result, err := h.pool.JobResult(ctx, jobID)
+	switch {
+	case info == nil:
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	case err != nil:
+		return h.sendErrorResponse(w, req.ID, -32603, "Job result unavailable", info)
+	}
+
+	v, err := applyFilter(filter, struct {
+		*JobInfo
+		Result interface{} `json:"result"`
+	}{info, formatNulls(nullFormat, formatTimes(times, result), false)})
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	return h.sendToolResult(w, req.ID, v)
+}
+
+// toolCancelJob implements the cancel_job tool.
+func (h *Handler) toolCancelJob(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	jobID, ok := args["job_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "job_id is required")
+	}
+
+	info, err := h.pool.CancelJob(ctx, jobID)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	return h.sendToolResult(w, req.ID, info)
+}
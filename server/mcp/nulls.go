@@ -0,0 +1,102 @@
+package mcp
+
+import (
+	"fmt"
+
+	"github.com/xo/usql/server/nulls"
+)
+
+// nullsProperty is the input schema of the nulls argument.
+var nullsProperty = map[string]interface{}{
+	"type":        "string",
+	"enum":        []string{nulls.Null, nulls.Sentinel, nulls.Omit},
+	"description": "Optional representation of NULL values: JSON null (null), the null_sentinel string (sentinel), or omitted from rows returned as objects (omit). Defaults to the server's NULL representation",
+}
+
+// nullSentinelProperty is the input schema of the null_sentinel argument.
+var nullSentinelProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "Optional string representing NULL values with nulls set to sentinel",
+}
+
+// rowFormatProperty is the input schema of the row_format argument.
+var rowFormatProperty = map[string]interface{}{
+	"type":        "string",
+	"enum":        []string{"array", "object"},
+	"description": "Optional format of rows in JSON results: arrays of values in column order (array, the default), or objects keyed by column name (object)",
+}
+
+// parseNulls returns the representation of NULL values, overriding the
+// handler's default with the nulls and null_sentinel arguments, if provided.
+func (h *Handler) parseNulls(args map[string]interface{}) (*nulls.Format, error) {
+	var names [2]string
+	for i, key := range []string{"nulls", "null_sentinel"} {
+		if v, exists := args[key]; exists {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a string", key)
+			}
+			names[i] = s
+		}
+	}
+	return h.nulls.With(names[0], names[1])
+}
+
+// parseRowFormat parses the row_format argument, returning whether rows are
+// returned as objects.
+func parseRowFormat(args map[string]interface{}) (bool, error) {
+	v, exists := args["row_format"]
+	if !exists {
+		return false, nil
+	}
+	switch s, _ := v.(string); s {
+	case "array":
+		return false, nil
+	case "object":
+		return true, nil
+	}
+	return false, fmt.Errorf("row_format must be array or object")
+}
+
+// objectResult is a query result with its rows as objects.
+type objectResult struct {
+	*QueryResult
+	Rows           []map[string]interface{} `json:"rows"`
+	MoreResultSets []*objectResult          `json:"more_result_sets,omitempty"`
+}
+
+// formatNulls returns the result, and its further result sets, with its
+// NULL values represented by the format, and its rows as objects when
+// objects is true.
+func formatNulls(f *nulls.Format, result *QueryResult, objects bool) interface{} {
+	if result == nil {
+		return result
+	}
+	if objects {
+		return objectRows(f, result)
+	}
+	if f == nil || f.Name != nulls.Sentinel {
+		return result
+	}
+	formatted := *result
+	formatted.Rows = f.Rows(result.Rows)
+	formatted.MoreResultSets = make([]*QueryResult, len(result.MoreResultSets))
+	for i, set := range result.MoreResultSets {
+		formatted.MoreResultSets[i] = formatNulls(f, set, false).(*QueryResult)
+	}
+	return &formatted
+}
+
+// objectRows returns the result, and its further result sets, with its rows
+// as objects.
+func objectRows(f *nulls.Format, result *QueryResult) *objectResult {
+	r := &objectResult{
+		QueryResult:    result,
+		Rows:           f.Objects(result.Columns, result.Rows),
+		MoreResultSets: make([]*objectResult, len(result.MoreResultSets)),
+	}
+	for i, set := range result.MoreResultSets {
+		r.MoreResultSets[i] = objectRows(f, set)
+	}
+	return r
+}
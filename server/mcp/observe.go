@@ -0,0 +1,69 @@
+package mcp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Observer is notified of each request handled, with its method, the tool
+// called by tools/call requests, how long it took, and whether it failed
+// with a JSON-RPC error. Methods and tools other than the handler's are
+// reported as unknown, bounding the values reported.
+type Observer func(method, tool string, d time.Duration, failed bool)
+
+// SetObserver sets the observer notified of the requests handled. It must
+// be set before requests are served.
+func (h *Handler) SetObserver(f Observer) {
+	h.observer = f
+}
+
+// methods are the methods handled.
+var methods = map[string]bool{
+	"initialize":     true,
+	"capabilities":   true,
+	"resources/list": true,
+	"resources/read": true,
+	"tools/list":     true,
+	"tools/call":     true,
+}
+
+// builtinToolNames returns the names of the built-in tools.
+var builtinToolNames = sync.OnceValue(func() map[string]bool {
+	names := make(map[string]bool)
+	for _, tool := range builtinTools() {
+		names[tool.Name] = true
+	}
+	return names
+})
+
+// observe notifies the observer of the request.
+func (h *Handler) observe(req *JSONRPCRequest, d time.Duration, failed bool) {
+	method, tool := req.Method, ""
+	if !methods[method] {
+		method = "unknown"
+	}
+	if method == "tools/call" {
+		tool = "unknown"
+		if params, ok := req.Params.(map[string]interface{}); ok {
+			name, _ := params["name"].(string)
+			if _, saved := h.savedQuery(name); builtinToolNames()[name] || saved {
+				tool = name
+			}
+		}
+	}
+	h.observer(method, tool, d, failed)
+}
+
+// observedWriter records whether a JSON-RPC error was sent for an observed
+// request.
+type observedWriter struct {
+	http.ResponseWriter
+	failed bool
+}
+
+// Unwrap returns the underlying response writer, for
+// http.ResponseController.
+func (ow *observedWriter) Unwrap() http.ResponseWriter {
+	return ow.ResponseWriter
+}
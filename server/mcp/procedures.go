@@ -0,0 +1,111 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// procedureTools returns the tools for calling stored procedures.
+func procedureTools() []Tool {
+	return []Tool{
+		{
+			Name:        "call_procedure",
+			Description: "Call a stored procedure, returning its result sets and the values of OUT/INOUT parameters",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the database connection to use",
+					},
+					"procedure": map[string]interface{}{
+						"type":        "string",
+						"description": "The (optionally schema qualified) name of the procedure",
+					},
+					"params": map[string]interface{}{
+						"type":        "array",
+						"description": "The procedure's parameters, in order",
+						"items": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"name": map[string]interface{}{
+									"type":        "string",
+									"description": "The parameter name (required for SQL Server)",
+								},
+								"mode": map[string]interface{}{
+									"type":        "string",
+									"enum":        []string{"in", "out", "inout"},
+									"description": "The parameter mode (default in)",
+								},
+								"type": map[string]interface{}{
+									"type":        "string",
+									"enum":        []string{"string", "integer", "number", "boolean", "bytes", "time"},
+									"description": "The type of the OUT parameter value (defaults to the type of value, or string)",
+								},
+								"value": map[string]interface{}{
+									"description": "The IN or INOUT parameter value",
+								},
+							},
+						},
+					},
+					"approval_id": approvalProperty,
+				},
+				"required": []string{"connection_id", "procedure"},
+			},
+		},
+	}
+}
+
+// toolCallProcedure implements the call_procedure tool.
+func (h *Handler) toolCallProcedure(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	connectionID, ok := args["connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+
+	procedure, ok := args["procedure"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "procedure is required")
+	}
+
+	var params []ProcedureParam
+	if v, exists := args["params"]; exists {
+		items, ok := v.([]interface{})
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "params must be an array")
+		}
+		for i, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("param %d must be an object", i+1))
+			}
+			p := ProcedureParam{Value: m["value"]}
+			p.Name, _ = m["name"].(string)
+			p.Mode, _ = m["mode"].(string)
+			p.Type, _ = m["type"].(string)
+			params = append(params, p)
+		}
+	}
+
+	conn, err := h.pool.GetConnection(connectionID)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("connection not found: %s", connectionID))
+	}
+
+	result, err := conn.CallProcedure(ctx, procedure, params)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Procedure call failed", errorData(err))
+	}
+	for i, set := range result.ResultSets {
+		result.ResultSets[i] = formatNulls(h.nulls, formatTimes(h.times, set), false).(*QueryResult)
+	}
+	for name, v := range result.Out {
+		if v = h.times.Value(v); v == nil && h.nulls.Text() != "" {
+			v = h.nulls.Text()
+		}
+		result.Out[name] = v
+	}
+
+	return h.sendToolResult(w, req.ID, result)
+}
@@ -3,29 +3,121 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xo/usql/server/nulls"
+	"github.com/xo/usql/server/timefmt"
 )
 
 // Handler handles MCP (Model Context Protocol) requests.
 type Handler struct {
 	pool ConnectionPool
+
+	// deprecations are the deprecated tools' notices, by tool name.
+	deprecations map[string]Deprecation
+
+	// times is the default format of time values in results.
+	times *timefmt.Format
+
+	// nulls is the default representation of NULL values in results.
+	nulls *nulls.Format
+
+	mu      sync.RWMutex
+	queries map[string]SavedQuery
+
+	// listChanged is whether the client is notified when the list of
+	// resources changes.
+	listChanged atomic.Bool
+
+	// observer is notified of the requests handled, if set.
+	observer Observer
+
+	// info identifies the server to clients initializing.
+	info ServerInfo
+}
+
+// ServerInfo identifies the server to clients initializing, with the build
+// it runs.
+type ServerInfo struct {
+	Name      string   `json:"name"`
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"buildDate,omitempty"`
+	GoVersion string   `json:"goVersion,omitempty"`
+	Drivers   []string `json:"drivers,omitempty"`
 }
 
 // ConnectionPool interface for dependency injection.
 type ConnectionPool interface {
-	CreateConnection(ctx context.Context, id, dsn string) (Connection, error)
+	CreateConnection(ctx context.Context, id, dsn string, tls *ConnectionTLS) (Connection, error)
+	RegisterConnection(ctx context.Context, id, dsn string, tls *ConnectionTLS) error
+	RenameConnection(id, newID string) error
+	AddAlias(id, alias string) error
+	RemoveAlias(alias string) error
+	SetTags(id string, tags map[string]string) error
+	SetFailover(id string, dsns []string) error
+	AddReplica(ctx context.Context, id, dsn string) error
 	GetConnection(id string) (Connection, error)
+	IsGroup(id string) bool
 	CloseConnection(id string) error
 	ListConnections() map[string]ConnectionInfo
 	CheckConnection(ctx context.Context, id string) error
+	ValidateDSN(dsn string) *DSNInfo
+	DryRun(ctx context.Context, connectionID, query string, statement bool, args ...interface{}) (*DryRunResult, error)
+	QueryPage(ctx context.Context, connectionID, query string, maxRows int, limits ResultLimits, args ...interface{}) (*QueryResult, error)
+	ContinueQuery(ctx context.Context, connectionID, token string, maxRows int, limits ResultLimits) (*QueryResult, error)
+	OpenCursor(ctx context.Context, connectionID, query string, args ...interface{}) (*CursorInfo, error)
+	FetchCursor(ctx context.Context, cursorID string, count int) (*CursorPage, error)
+	CloseCursor(ctx context.Context, cursorID string) error
+	InvalidateCache(connectionID string) int
+	SubmitJob(ctx context.Context, connectionID, query string, args ...interface{}) (*JobInfo, error)
+	JobStatus(ctx context.Context, jobID string, wait time.Duration) (*JobInfo, error)
+	JobResult(ctx context.Context, jobID string) (*JobInfo, *QueryResult, error)
+	CancelJob(ctx context.Context, jobID string) (*JobInfo, error)
+	Export(ctx context.Context, connectionID, query, format, path string, args ...interface{}) (*ExportInfo, error)
+	CreateSession(ctx context.Context) Session
+	GetSession(ctx context.Context, id string) (Session, bool)
+	BeginTransaction(ctx context.Context, connectionID, isolation string, readOnly bool) (*TransactionInfo, error)
+	EndTransaction(ctx context.Context, connectionID string, commit bool) (*TransactionInfo, error)
+	SearchHistory(ctx context.Context, filter HistoryFilter) ([]HistoryEntry, error)
+	QueryStats(ctx context.Context, connectionID, sort string, limit int) ([]QueryStats, error)
 }
 
 // Connection interface for database connections.
 type Connection interface {
 	ExecuteQuery(ctx context.Context, query string, args ...interface{}) (*QueryResult, error)
 	ExecuteStatement(ctx context.Context, query string, args ...interface{}) (*StatementResult, error)
+	AdviseIndexes(ctx context.Context, query string, args ...interface{}) (*IndexAdvice, error)
+	CallProcedure(ctx context.Context, name string, params []ProcedureParam) (*ProcedureResult, error)
+	QueryRows(ctx context.Context, query string, args ...interface{}) (Rows, error)
+}
+
+// Rows iterates over the rows of a query's result sets as they are read
+// from the database, rather than holding them in memory, the same as usql's
+// table formatting reads result sets. Rows must be closed.
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Columns() ([]string, error)
+	NextResultSet() bool
+	Err() error
+	Close() error
+}
+
+// ConnectionTLS are the TLS settings of a connection's database connections.
+// CA, Cert and Key are PEM, or secret references resolving to PEM.
+type ConnectionTLS struct {
+	Mode       string
+	CA         string
+	Cert       string
+	Key        string
+	ServerName string
 }
 
 // ConnectionInfo provides basic information about a connection.
@@ -34,40 +126,286 @@ type ConnectionInfo struct {
 	Driver   string `json:"driver"`
 	Host     string `json:"host"`
 	Database string `json:"database"`
+	Suspect  bool   `json:"suspect"`
+
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+
+	// Aliases are further names of the connection, usable in place of its
+	// ID.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Tags are the connection's key/value tags, such as env=prod.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Pending is whether the connection was registered without connecting,
+	// and was not used since.
+	Pending bool `json:"pending,omitempty"`
+
+	// Health is the state of the connection's periodic health checks, nil
+	// when it was not checked.
+	Health *ConnectionHealth `json:"health,omitempty"`
 }
 
-// QueryResult represents the result of a SQL query.
+// ConnectionHealth describes the state of a connection's periodic health
+// checks.
+type ConnectionHealth struct {
+	Healthy     bool       `json:"healthy"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	Error       string     `json:"error,omitempty"`
+	Failures    int        `json:"failures"`
+	Checks      int64      `json:"checks"`
+	Reconnects  int64      `json:"reconnects"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// QueryResult represents the result of a SQL query, with any additional
+// result sets in MoreResultSets.
 type QueryResult struct {
-	Columns     []string        `json:"columns"`
-	ColumnTypes []string        `json:"column_types"`
-	Rows        [][]interface{} `json:"rows"`
+	Columns        []string        `json:"columns"`
+	ColumnTypes    []string        `json:"column_types"`
+	Rows           [][]interface{} `json:"rows"`
+	MoreResultSets []*QueryResult  `json:"more_result_sets,omitempty"`
+	Provenance     *Provenance     `json:"provenance,omitempty"`
+
+	// Risk is the risk classification of the query.
+	Risk *Risk `json:"risk,omitempty"`
+	// PII is the PII found in the rows, when PII detection applies.
+	PII []PIIFinding `json:"pii,omitempty"`
+
+	// JSONTypes are the JSON types of the columns' values.
+	JSONTypes []string `json:"json_types,omitempty"`
+
+	// ContinuationToken is set on a page of a paginated query when more
+	// rows remain.
+	ContinuationToken string `json:"continuation_token,omitempty"`
+
+	// Truncated is set when rows were left unread due to the result caps.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Cached is set when the result was returned from the result cache.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// ResultLimits caps the rows and bytes read into a query result. A cap of 0
+// is no cap.
+type ResultLimits struct {
+	Rows  int   `json:"rows,omitempty"`
+	Bytes int64 `json:"bytes,omitempty"`
 }
 
 // StatementResult represents the result of a SQL statement execution.
 type StatementResult struct {
-	RowsAffected int64 `json:"rows_affected"`
-	LastInsertId int64 `json:"last_insert_id"`
+	RowsAffected int64       `json:"rows_affected"`
+	LastInsertId int64       `json:"last_insert_id"`
+	Provenance   *Provenance `json:"provenance,omitempty"`
+	Risk         *Risk       `json:"risk,omitempty"`
+}
+
+// Risk is the risk classification of SQL: its level (read-only,
+// row-mutating, schema-mutating or server-admin) and scope (rows, table,
+// database or server), with warnings about risky statements.
+type Risk struct {
+	Level      string   `json:"level"`
+	Scope      string   `json:"scope"`
+	Statements int      `json:"statements"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// PIIFinding is likely PII (an email, credit card or national ID) found in
+// the values of a result column, redacted or only flagged.
+type PIIFinding struct {
+	Column   string `json:"column"`
+	Type     string `json:"type"`
+	Count    int    `json:"count"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// Provenance describes where and how a result was produced.
+type Provenance struct {
+	ConnectionID  string    `json:"connection_id"`
+	Driver        string    `json:"driver"`
+	Host          string    `json:"host,omitempty"`
+	Database      string    `json:"database,omitempty"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	ExecutedAt    time.Time `json:"executed_at"`
+	SQLHash       string    `json:"sql_hash"`
+	UsqlrVersion  string    `json:"usqlr_version"`
+	Rewrites      []string  `json:"rewrites,omitempty"`
+}
+
+// ProcedureParam is a stored procedure parameter.
+type ProcedureParam struct {
+	Name  string      `json:"name,omitempty"`
+	Mode  string      `json:"mode,omitempty"`
+	Type  string      `json:"type,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ProcedureResult is the result of a stored procedure call.
+type ProcedureResult struct {
+	ResultSets []*QueryResult         `json:"result_sets"`
+	Out        map[string]interface{} `json:"out,omitempty"`
+	Provenance *Provenance            `json:"provenance,omitempty"`
+}
+
+// IndexAdvice contains candidate indexes suggested for a query.
+type IndexAdvice struct {
+	Note        string            `json:"note"`
+	Plan        interface{}       `json:"plan"`
+	Suggestions []IndexSuggestion `json:"suggestions"`
+}
+
+// IndexSuggestion is a candidate index.
+type IndexSuggestion struct {
+	Table            string   `json:"table"`
+	Columns          []string `json:"columns"`
+	Reason           string   `json:"reason"`
+	EstimatedRows    int64    `json:"estimated_rows,omitempty"`
+	EstimatedBenefit string   `json:"estimated_benefit"`
+	DDL              string   `json:"ddl"`
+}
+
+// CursorInfo describes an open server-side cursor.
+type CursorInfo struct {
+	CursorID     string      `json:"cursor_id"`
+	ConnectionID string      `json:"connection_id"`
+	Columns      []string    `json:"columns"`
+	ColumnTypes  []string    `json:"column_types"`
+	JSONTypes    []string    `json:"json_types,omitempty"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+	Provenance   *Provenance `json:"provenance,omitempty"`
+}
+
+// CursorPage is a chunk of rows fetched from a cursor.
+type CursorPage struct {
+	CursorID  string          `json:"cursor_id"`
+	Rows      [][]interface{} `json:"rows"`
+	Done      bool            `json:"done"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	// PII is the PII found in the rows, when PII detection applies.
+	PII []PIIFinding `json:"pii,omitempty"`
+}
+
+// JobInfo describes the status of an asynchronous query job.
+type JobInfo struct {
+	JobID        string    `json:"job_id"`
+	ConnectionID string    `json:"connection_id"`
+	State        string    `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+	StartedAt    time.Time `json:"started_at,omitzero"`
+	FinishedAt   time.Time `json:"finished_at,omitzero"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+	RowCount     int       `json:"row_count"`
+	Truncated    bool      `json:"truncated"`
+}
+
+// DSNInfo describes a DSN, as parsed without connecting to its database.
+type DSNInfo struct {
+	Valid      bool   `json:"valid"`
+	Error      string `json:"error,omitempty"`
+	Driver     string `json:"driver,omitempty"`
+	Available  bool   `json:"available"`
+	URL        string `json:"url,omitempty"`
+	Transport  string `json:"transport,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Port       string `json:"port,omitempty"`
+	Database   string `json:"database,omitempty"`
+	User       string `json:"user,omitempty"`
+	SecretRefs int    `json:"secret_refs,omitempty"`
+}
+
+// DryRunResult describes a query or statement validated without executing
+// it.
+type DryRunResult struct {
+	Valid       bool     `json:"valid"`
+	Error       string   `json:"error,omitempty"`
+	Type        string   `json:"type"`
+	Category    string   `json:"category"`
+	Parameters  *int     `json:"parameters,omitempty"`
+	Columns     []string `json:"columns,omitempty"`
+	ColumnTypes []string `json:"column_types,omitempty"`
+}
+
+// ExportInfo describes a query result exported as a file.
+type ExportInfo struct {
+	Path     string         `json:"path,omitempty"`
+	Format   string         `json:"format"`
+	RowCount int            `json:"row_count"`
+	Bytes    int            `json:"bytes"`
+	Columns  []ExportColumn `json:"columns,omitempty"`
+
+	// Data is the content of the file, when it was not written to a path.
+	Data []byte `json:"-"`
+}
+
+// ExportColumn is a column of an exported file, with its type in the file.
+type ExportColumn struct {
+	Name         string `json:"name"`
+	PhysicalType string `json:"physical_type,omitempty"`
+	LogicalType  string `json:"logical_type,omitempty"`
 }
 
-// New creates a new MCP handler.
-func New(pool ConnectionPool) (*Handler, error) {
+// New creates a new MCP handler, exposing each of the saved queries as a
+// tool.
+func New(pool ConnectionPool, queries []SavedQuery, deprecations map[string]Deprecation, times *timefmt.Format, nullFormat *nulls.Format) (*Handler, error) {
+	m, err := newSavedQueries(queries)
+	if err != nil {
+		return nil, err
+	}
 	return &Handler{
-		pool: pool,
+		pool:         pool,
+		deprecations: deprecations,
+		times:        times,
+		nulls:        nullFormat,
+		queries:      m,
+		info:         ServerInfo{Name: "usqlr", Version: "dev"},
 	}, nil
 }
 
+// SetServerInfo sets the server info reported to clients initializing. It
+// must be set before requests are served.
+func (h *Handler) SetServerInfo(info ServerInfo) {
+	h.info = info
+}
+
+// SetListChanged sets whether the client is notified when the list of
+// resources changes, as advertised on initialization.
+func (h *Handler) SetListChanged(listChanged bool) {
+	h.listChanged.Store(listChanged)
+}
+
 // ServeHTTP handles MCP HTTP requests.
-func (h *Handler) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+func (h *Handler) ServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	var req JSONRPCRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return h.sendErrorResponse(w, nil, -32700, "Parse error", nil)
 	}
+	if h.observer != nil {
+		ow := &observedWriter{ResponseWriter: w}
+		w = ow
+		defer func(started time.Time) {
+			h.observe(&req, time.Since(started), ow.failed || err != nil)
+		}(time.Now())
+	}
 
 	// Validate JSON-RPC request
 	if err := h.validateRequest(&req); err != nil {
 		return h.sendErrorResponse(w, req.ID, -32600, "Invalid Request", err.Error())
 	}
 
+	// Requests after initialization carry the ID of the client's session,
+	// if it keeps one
+	if id := r.Header.Get(SessionHeader); id != "" && req.Method != "initialize" {
+		session, ok := h.pool.GetSession(ctx, id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return h.sendErrorResponse(w, req.ID, -32001, "Session not found", fmt.Sprintf("session %s does not exist or expired: initialize a new session", id))
+		}
+		ctx = withSession(ctx, session)
+	}
+
 	// Route the request based on method
 	switch req.Method {
 	case "initialize":
@@ -92,7 +430,7 @@ func (h *Handler) validateRequest(req *JSONRPCRequest) error {
 	if req.JSONRPC != "2.0" {
 		return fmt.Errorf("invalid JSON-RPC version: %s", req.JSONRPC)
 	}
-	
+
 	if req.Method == "" {
 		return fmt.Errorf("missing method")
 	}
@@ -110,33 +448,55 @@ func (h *Handler) handleInitialize(ctx context.Context, w http.ResponseWriter, r
 		"protocolVersion": "2024-11-05",
 		"capabilities": map[string]interface{}{
 			"resources": map[string]interface{}{
-				"subscribe": false,
-				"listChanged": false,
+				"subscribe":   false,
+				"listChanged": h.listChanged.Load(),
 			},
 			"tools": map[string]interface{}{},
 		},
-		"serverInfo": map[string]interface{}{
-			"name":    "usqlr",
-			"version": "1.0.0",
-		},
+		"serverInfo": h.info,
 	}
+	w.Header().Set(SessionHeader, h.pool.CreateSession(ctx).ID())
 
 	return h.sendSuccessResponse(w, req.ID, result)
 }
 
 // handleCapabilities returns server capabilities.
 func (h *Handler) handleCapabilities(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest) error {
+	tools := []string{
+		"execute_query",
+		"create_connection",
+		"close_connection",
+		"rename_connection",
+		"alias_connection",
+		"open_cursor",
+		"fetch",
+		"close_cursor",
+		"submit_query",
+		"job_status",
+		"job_result",
+		"cancel_job",
+		"advise_indexes",
+		"call_procedure",
+		"use_connection",
+		"set_variable",
+		"session_info",
+		"begin_transaction",
+		"commit_transaction",
+		"rollback_transaction",
+	}
+	for _, tool := range h.savedQueryTools() {
+		tools = append(tools, tool.Name)
+	}
+
 	capabilities := map[string]interface{}{
 		"resources": []string{
 			"list_databases",
 			"schema_info",
 			"connection_status",
+			"query_history",
+			"query_stats",
 		},
-		"tools": []string{
-			"execute_query",
-			"create_connection",
-			"close_connection",
-		},
+		"tools": tools,
 	}
 
 	return h.sendSuccessResponse(w, req.ID, capabilities)
@@ -156,6 +516,9 @@ func (h *Handler) sendSuccessResponse(w http.ResponseWriter, id interface{}, res
 
 // sendErrorResponse sends an error JSON-RPC response.
 func (h *Handler) sendErrorResponse(w http.ResponseWriter, id interface{}, code int, message string, data interface{}) error {
+	if ow, ok := w.(*observedWriter); ok {
+		ow.failed = true
+	}
 	response := JSONRPCResponse{
 		JSONRPC: "2.0",
 		Error: &JSONRPCError{
@@ -170,6 +533,25 @@ func (h *Handler) sendErrorResponse(w http.ResponseWriter, id interface{}, code
 	return json.NewEncoder(w).Encode(response)
 }
 
+// detailedError is implemented by errors carrying details for clients, such
+// as statement policy violations.
+type detailedError interface {
+	error
+	ErrorData() map[string]interface{}
+}
+
+// errorData returns the data of the JSON-RPC error response of err: its
+// message, along with its details when it carries any.
+func errorData(err error) interface{} {
+	var d detailedError
+	if !errors.As(err, &d) {
+		return err.Error()
+	}
+	data := d.ErrorData()
+	data["error"] = err.Error()
+	return data
+}
+
 // JSONRPCRequest represents a JSON-RPC 2.0 request.
 type JSONRPCRequest struct {
 	JSONRPC string      `json:"jsonrpc"`
@@ -191,4 +573,4 @@ type JSONRPCError struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,226 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// SavedQuery is an operator defined query exposed as its own tool.
+//
+// The query's parameters are passed to the SQL as arguments in the order
+// they are declared.
+type SavedQuery struct {
+	Name        string
+	Description string
+	Connection  string
+	SQL         string
+	Statement   bool
+	Params      []QueryParam
+}
+
+// QueryParam is a saved query parameter.
+type QueryParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	Default     interface{}
+}
+
+// paramTypes are the valid saved query parameter types.
+var paramTypes = map[string]bool{
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+}
+
+// newSavedQueries validates the saved queries and indexes them by name.
+func newSavedQueries(queries []SavedQuery) (map[string]SavedQuery, error) {
+	builtin := make(map[string]bool)
+	for _, tool := range builtinTools() {
+		builtin[tool.Name] = true
+	}
+
+	m := make(map[string]SavedQuery, len(queries))
+	for i, q := range queries {
+		switch _, exists := m[q.Name]; {
+		case q.Name == "":
+			return nil, fmt.Errorf("saved query %d: name is required", i+1)
+		case builtin[q.Name]:
+			return nil, fmt.Errorf("saved query %s: name conflicts with a built-in tool", q.Name)
+		case exists:
+			return nil, fmt.Errorf("saved query %s: defined more than once", q.Name)
+		case q.Connection == "":
+			return nil, fmt.Errorf("saved query %s: connection is required", q.Name)
+		case q.SQL == "":
+			return nil, fmt.Errorf("saved query %s: sql is required", q.Name)
+		}
+		for j, p := range q.Params {
+			if p.Type == "" {
+				q.Params[j].Type = "string"
+			}
+			switch {
+			case p.Name == "":
+				return nil, fmt.Errorf("saved query %s: param %d: name is required", q.Name, j+1)
+			case !paramTypes[q.Params[j].Type]:
+				return nil, fmt.Errorf("saved query %s: param %s: invalid type %q", q.Name, p.Name, p.Type)
+			}
+		}
+		m[q.Name] = q
+	}
+	return m, nil
+}
+
+// SetSavedQueries replaces the saved queries exposed as tools.
+func (h *Handler) SetSavedQueries(queries []SavedQuery) error {
+	m, err := newSavedQueries(queries)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queries = m
+	return nil
+}
+
+// savedQuery returns the saved query with the name.
+func (h *Handler) savedQuery(name string) (SavedQuery, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	q, ok := h.queries[name]
+	return q, ok
+}
+
+// savedQueryTools returns the tools for the saved queries, sorted by name.
+func (h *Handler) savedQueryTools() []Tool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	tools := make([]Tool, 0, len(h.queries))
+	for _, q := range h.queries {
+		properties := make(map[string]interface{}, len(q.Params))
+		required := []string{}
+		for _, p := range q.Params {
+			prop := map[string]interface{}{
+				"type": p.Type,
+			}
+			if p.Description != "" {
+				prop["description"] = p.Description
+			}
+			if p.Default != nil {
+				prop["default"] = p.Default
+			}
+			properties[p.Name] = prop
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		}
+
+		description := q.Description
+		if description == "" {
+			description = fmt.Sprintf("Run the saved query %s on connection %s", q.Name, q.Connection)
+		}
+		tools = append(tools, Tool{
+			Name:        q.Name,
+			Description: description,
+			InputSchema: map[string]interface{}{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			},
+		})
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	return tools
+}
+
+// toolSavedQuery runs a saved query.
+func (h *Handler) toolSavedQuery(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, q SavedQuery, args map[string]interface{}) error {
+	queryArgs, err := q.bind(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	connectionID := policy.QualifyConnection(ctx, q.Connection)
+	if !policy.AllowsConnection(ctx, connectionID) {
+		return h.sendErrorResponse(w, req.ID, -32603, "Forbidden", fmt.Sprintf("connection %s is not granted", connectionID))
+	}
+	conn, err := h.pool.GetConnection(connectionID)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Connection not available", fmt.Sprintf("connection not found: %s", connectionID))
+	}
+
+	if q.Statement {
+		result, err := conn.ExecuteStatement(ctx, q.SQL, queryArgs...)
+		if err != nil {
+			return h.sendErrorResponse(w, req.ID, -32603, "Statement execution failed", errorData(err))
+		}
+		return h.sendToolResult(w, req.ID, result)
+	}
+
+	result, err := conn.ExecuteQuery(ctx, q.SQL, queryArgs...)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Query execution failed", errorData(err))
+	}
+	return h.sendToolResult(w, req.ID, formatNulls(h.nulls, formatTimes(h.times, result), false))
+}
+
+// bind validates the tool arguments against the query's parameters,
+// returning the query arguments in parameter order.
+func (q SavedQuery) bind(args map[string]interface{}) ([]interface{}, error) {
+	known := make(map[string]bool, len(q.Params))
+	queryArgs := make([]interface{}, len(q.Params))
+	for i, p := range q.Params {
+		known[p.Name] = true
+		v, ok := args[p.Name]
+		switch {
+		case (!ok || v == nil) && p.Required:
+			return nil, fmt.Errorf("%s is required", p.Name)
+		case !ok || v == nil:
+			queryArgs[i] = p.Default
+			continue
+		}
+		v, err := convertParam(p.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		queryArgs[i] = v
+	}
+	for name := range args {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown parameter: %s", name)
+		}
+	}
+	return queryArgs, nil
+}
+
+// convertParam checks that the JSON value v is of the parameter type,
+// converting integers to int64.
+func convertParam(typ string, v interface{}) (interface{}, error) {
+	switch typ {
+	case "string":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "number":
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+	case "integer":
+		if f, ok := v.(float64); ok && f == math.Trunc(f) {
+			return int64(f), nil
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("must be of type %s", typ)
+}
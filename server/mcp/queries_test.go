@@ -0,0 +1,180 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNewSavedQueries(t *testing.T) {
+	valid := SavedQuery{Name: "orders", Connection: "prod", SQL: "SELECT * FROM orders WHERE id = $1", Params: []QueryParam{{Name: "id", Type: "integer"}}}
+	tests := []struct {
+		queries []SavedQuery
+		err     bool
+	}{
+		{nil, false},
+		{[]SavedQuery{valid}, false},
+		{[]SavedQuery{valid, {Name: "refund", Connection: "prod", SQL: "UPDATE orders SET refunded = true", Statement: true}}, false},
+		{[]SavedQuery{{Connection: "prod", SQL: "SELECT 1"}}, true},
+		{[]SavedQuery{{Name: "execute_query", Connection: "prod", SQL: "SELECT 1"}}, true},
+		{[]SavedQuery{valid, valid}, true},
+		{[]SavedQuery{{Name: "orders", SQL: "SELECT 1"}}, true},
+		{[]SavedQuery{{Name: "orders", Connection: "prod"}}, true},
+		{[]SavedQuery{{Name: "orders", Connection: "prod", SQL: "SELECT 1", Params: []QueryParam{{Type: "string"}}}}, true},
+		{[]SavedQuery{{Name: "orders", Connection: "prod", SQL: "SELECT 1", Params: []QueryParam{{Name: "id", Type: "uuid"}}}}, true},
+	}
+	for i, test := range tests {
+		m, err := newSavedQueries(test.queries)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("test %d: expected an error", i)
+		case !test.err && err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case !test.err && len(m) != len(test.queries):
+			t.Errorf("test %d: expected %d queries, got: %d", i, len(test.queries), len(m))
+		}
+	}
+
+	// params are strings unless typed
+	m, err := newSavedQueries([]SavedQuery{{Name: "orders", Connection: "prod", SQL: "SELECT 1", Params: []QueryParam{{Name: "status"}}}})
+	if err != nil || m["orders"].Params[0].Type != "string" {
+		t.Errorf("expected a string param, got: %v %v", m, err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	q := SavedQuery{Params: []QueryParam{
+		{Name: "customer", Type: "integer", Required: true},
+		{Name: "status", Type: "string", Default: "open"},
+		{Name: "min_total", Type: "number"},
+		{Name: "refunded", Type: "boolean", Default: false},
+	}}
+	tests := []struct {
+		args map[string]interface{}
+		exp  []interface{}
+		err  bool
+	}{
+		{map[string]interface{}{"customer": float64(7)}, []interface{}{int64(7), "open", nil, false}, false},
+		{map[string]interface{}{"customer": float64(7), "status": "paid", "min_total": 9.5, "refunded": true}, []interface{}{int64(7), "paid", 9.5, true}, false},
+		// null arguments are the parameters' defaults
+		{map[string]interface{}{"customer": float64(7), "status": nil}, []interface{}{int64(7), "open", nil, false}, false},
+		// missing required parameters
+		{map[string]interface{}{}, nil, true},
+		{map[string]interface{}{"customer": nil}, nil, true},
+		// extra parameters
+		{map[string]interface{}{"customer": float64(7), "limit": float64(10)}, nil, true},
+		// ill-typed parameters
+		{map[string]interface{}{"customer": "7"}, nil, true},
+		{map[string]interface{}{"customer": 7.5}, nil, true},
+		{map[string]interface{}{"customer": float64(7), "status": float64(1)}, nil, true},
+		{map[string]interface{}{"customer": float64(7), "min_total": "9.5"}, nil, true},
+		{map[string]interface{}{"customer": float64(7), "refunded": "true"}, nil, true},
+	}
+	for i, test := range tests {
+		args, err := q.bind(test.args)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("test %d: expected an error, got: %v", i, args)
+		case !test.err && err != nil:
+			t.Errorf("test %d: expected no error, got: %v", i, err)
+		case !test.err && !reflect.DeepEqual(args, test.exp):
+			t.Errorf("test %d: expected %v, got: %v", i, test.exp, args)
+		}
+	}
+}
+
+func TestConvertParam(t *testing.T) {
+	tests := []struct {
+		typ string
+		v   interface{}
+		exp interface{}
+		err bool
+	}{
+		{"string", "a", "a", false},
+		{"string", float64(1), nil, true},
+		{"number", 1.5, 1.5, false},
+		{"number", "1.5", nil, true},
+		{"integer", float64(3), int64(3), false},
+		{"integer", float64(-2), int64(-2), false},
+		{"integer", 3.1, nil, true},
+		{"integer", true, nil, true},
+		{"boolean", true, true, false},
+		{"boolean", float64(0), nil, true},
+		{"uuid", "a", nil, true},
+	}
+	for i, test := range tests {
+		v, err := convertParam(test.typ, test.v)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("test %d: expected an error, got: %v", i, v)
+		case !test.err && (err != nil || v != test.exp):
+			t.Errorf("test %d: expected %v, got: %v %v", i, test.exp, v, err)
+		}
+	}
+}
+
+func TestSavedQueryStatements(t *testing.T) {
+	conn := new(savedQueryConn)
+	h, err := New(savedQueryPool{conn: conn}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	params := []QueryParam{{Name: "id", Type: "integer", Required: true}}
+	for _, test := range []struct {
+		q   SavedQuery
+		exp string
+	}{
+		{SavedQuery{Name: "order", Connection: "prod", SQL: "SELECT * FROM orders WHERE id = $1", Params: params}, "query"},
+		{SavedQuery{Name: "refund", Connection: "prod", SQL: "UPDATE orders SET refunded = true WHERE id = $1", Statement: true, Params: params}, "statement"},
+	} {
+		conn.called, conn.args = "", nil
+		w := httptest.NewRecorder()
+		if err := h.toolSavedQuery(context.Background(), w, &JSONRPCRequest{ID: float64(1)}, test.q, map[string]interface{}{"id": float64(5)}); err != nil {
+			t.Fatalf("%s: expected no error, got: %v", test.q.Name, err)
+		}
+		var resp JSONRPCResponse
+		switch err := json.Unmarshal(w.Body.Bytes(), &resp); {
+		case err != nil || resp.Error != nil:
+			t.Errorf("%s: expected a result, got: %s", test.q.Name, w.Body.String())
+		case conn.called != test.exp || !reflect.DeepEqual(conn.args, []interface{}{int64(5)}):
+			t.Errorf("%s: expected the %s to be executed with the id, got: %s %v", test.q.Name, test.exp, conn.called, conn.args)
+		}
+	}
+
+	// invalid arguments are invalid params errors, without executing the query
+	conn.called = ""
+	w := httptest.NewRecorder()
+	h.toolSavedQuery(context.Background(), w, &JSONRPCRequest{ID: float64(1)}, SavedQuery{Name: "order", Connection: "prod", SQL: "SELECT 1", Params: params}, map[string]interface{}{})
+	var resp JSONRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Code != -32602 || conn.called != "" {
+		t.Errorf("expected an invalid params error, got: %s", w.Body.String())
+	}
+}
+
+// savedQueryPool is a connection pool of the one connection.
+type savedQueryPool struct {
+	ConnectionPool
+	conn *savedQueryConn
+}
+
+func (p savedQueryPool) GetConnection(string) (Connection, error) { return p.conn, nil }
+
+// savedQueryConn is a connection recording whether a query or a statement
+// was executed, and its arguments.
+type savedQueryConn struct {
+	Connection
+	called string
+	args   []interface{}
+}
+
+func (c *savedQueryConn) ExecuteQuery(_ context.Context, _ string, args ...interface{}) (*QueryResult, error) {
+	c.called, c.args = "query", args
+	return &QueryResult{Columns: []string{"id"}, Rows: [][]interface{}{{int64(5)}}}, nil
+}
+
+func (c *savedQueryConn) ExecuteStatement(_ context.Context, _ string, args ...interface{}) (*StatementResult, error) {
+	c.called, c.args = "statement", args
+	return &StatementResult{RowsAffected: 1}, nil
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xo/usql/server/policy"
 )
 
 // handleResourcesList handles requests to list available resources.
@@ -13,7 +17,7 @@ func (h *Handler) handleResourcesList(ctx context.Context, w http.ResponseWriter
 		{
 			URI:         "connections://list",
 			Name:        "Database Connections",
-			Description: "List all active database connections",
+			Description: "List all active database connections, optionally filtered by tags (an object) and driver, and sorted by sort (id, driver, created or last_used, prefixed by - for descending order) as an array",
 			MimeType:    "application/json",
 		},
 		{
@@ -22,6 +26,18 @@ func (h *Handler) handleResourcesList(ctx context.Context, w http.ResponseWriter
 			Description: "Check the health status of database connections",
 			MimeType:    "application/json",
 		},
+		{
+			URI:         "history://queries",
+			Name:        "Query History",
+			Description: "Search the queries executed on the connections you may use, oldest first, by text (contained in their SQL), connection_id, session_id, principal, since and until (RFC 3339 times), up to limit (100 by default)",
+			MimeType:    "application/json",
+		},
+		{
+			URI:         "stats://queries",
+			Name:        "Query Statistics",
+			Description: "Statistics of the queries executed on the connections you may use, by fingerprint (the query with its literals replaced with ?) and connection, over the stats window: count, errors, rows, and total, mean, p95 and max latency. Optionally of a connection_id, sorted by sort (total, count, mean, p95, errors or rows, from the highest), up to limit (100 by default)",
+			MimeType:    "application/json",
+		},
 		{
 			URI:         "schema://info",
 			Name:        "Schema Information",
@@ -29,6 +45,20 @@ func (h *Handler) handleResourcesList(ctx context.Context, w http.ResponseWriter
 			MimeType:    "application/json",
 		},
 	}
+	connections := h.listConnections(ctx)
+	ids := make([]string, 0, len(connections))
+	for id := range connections {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		resources = append(resources, Resource{
+			URI:         "schema://info/" + id,
+			Name:        "Schema Information (" + id + ")",
+			Description: "Get database schema information for connection " + id,
+			MimeType:    "application/json",
+		})
+	}
 
 	result := map[string]interface{}{
 		"resources": resources,
@@ -53,30 +83,62 @@ func (h *Handler) handleResourcesRead(ctx context.Context, w http.ResponseWriter
 	// Route based on URI
 	switch {
 	case uri == "connections://list":
-		return h.readConnectionsList(ctx, w, req)
+		filter, err := parseConnectionFilter(params)
+		if err != nil {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+		}
+		return h.readConnectionsList(ctx, w, req, filter)
 	case uri == "connections://status":
 		return h.readConnectionsStatus(ctx, w, req)
+	case uri == "history://queries":
+		filter, err := parseHistoryFilter(ctx, params)
+		if err != nil {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+		}
+		return h.readQueryHistory(ctx, w, req, filter)
+	case uri == "stats://queries":
+		return h.readQueryStats(ctx, w, req, params)
 	case uri == "schema://info":
 		connectionID, ok := params["connection_id"].(string)
 		if !ok {
 			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required for schema info")
 		}
-		return h.readSchemaInfo(ctx, w, req, connectionID)
+		return h.readSchemaInfo(ctx, w, req, uri, connectionID)
+	case strings.HasPrefix(uri, "schema://info/"):
+		return h.readSchemaInfo(ctx, w, req, uri, strings.TrimPrefix(uri, "schema://info/"))
 	default:
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("unknown resource URI: %s", uri))
 	}
 }
 
-// readConnectionsList returns the list of active connections.
-func (h *Handler) readConnectionsList(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest) error {
+// listConnections returns the connections in the principal's tenant, or
+// all connections when it has none, by ID.
+func (h *Handler) listConnections(ctx context.Context) map[string]ConnectionInfo {
 	connections := h.pool.ListConnections()
+	for id := range connections {
+		if !policy.InTenant(ctx, id) {
+			delete(connections, id)
+		}
+	}
+	return connections
+}
+
+// readConnectionsList returns the list of active connections, by ID, or
+// as an array of the connections matching the filter, in its order, when
+// the filter is not nil.
+func (h *Handler) readConnectionsList(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, filter *connectionFilter) error {
+	connections := h.listConnections(ctx)
+	var list interface{} = connections
+	if filter != nil {
+		list = filter.apply(connections)
+	}
 
 	result := map[string]interface{}{
 		"contents": []map[string]interface{}{
 			{
 				"uri":      "connections://list",
 				"mimeType": "application/json",
-				"text":     formatConnectionsList(connections),
+				"text":     formatConnectionsList(list),
 			},
 		},
 	}
@@ -84,12 +146,23 @@ func (h *Handler) readConnectionsList(ctx context.Context, w http.ResponseWriter
 	return h.sendSuccessResponse(w, req.ID, result)
 }
 
-// readConnectionsStatus returns the health status of connections.
+// readConnectionsStatus returns the health status of connections, as of
+// their last periodic health check, or checked now when they were not
+// checked.
 func (h *Handler) readConnectionsStatus(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest) error {
-	connections := h.pool.ListConnections()
+	connections := h.listConnections(ctx)
 	status := make(map[string]interface{})
 
-	for id := range connections {
+	for id, info := range connections {
+		switch {
+		case info.Pending:
+			// checking would connect to the database
+			status[id] = map[string]interface{}{"pending": true}
+			continue
+		case info.Health != nil:
+			status[id] = info.Health
+			continue
+		}
 		err := h.pool.CheckConnection(ctx, id)
 		status[id] = map[string]interface{}{
 			"healthy": err == nil,
@@ -118,8 +191,13 @@ func (h *Handler) readConnectionsStatus(ctx context.Context, w http.ResponseWrit
 	return h.sendSuccessResponse(w, req.ID, result)
 }
 
-// readSchemaInfo returns schema information for a specific connection.
-func (h *Handler) readSchemaInfo(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, connectionID string) error {
+// readSchemaInfo returns schema information for a specific connection, as
+// the resource at uri.
+func (h *Handler) readSchemaInfo(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, uri, connectionID string) error {
+	connectionID = policy.QualifyConnection(ctx, connectionID)
+	if !policy.AllowsConnection(ctx, connectionID) {
+		return h.sendErrorResponse(w, req.ID, -32603, "Forbidden", fmt.Sprintf("connection %s is not granted", connectionID))
+	}
 	conn, err := h.pool.GetConnection(connectionID)
 	if err != nil {
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("connection not found: %s", connectionID))
@@ -127,7 +205,7 @@ func (h *Handler) readSchemaInfo(ctx context.Context, w http.ResponseWriter, req
 
 	// Get schema information using a basic query
 	// This is a simplified approach - in production, you'd want to use the metadata package
-	result, err := conn.ExecuteQuery(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema NOT IN ('information_schema', 'performance_schema', 'mysql', 'sys') LIMIT 100")
+	result, err := readTables(ctx, conn)
 	if err != nil {
 		// Fallback for databases that don't support information_schema
 		result = &QueryResult{
@@ -145,7 +223,7 @@ func (h *Handler) readSchemaInfo(ctx context.Context, w http.ResponseWriter, req
 	response := map[string]interface{}{
 		"contents": []map[string]interface{}{
 			{
-				"uri":      "schema://info",
+				"uri":      uri,
 				"mimeType": "application/json",
 				"text":     string(schemaJSON),
 			},
@@ -155,8 +233,31 @@ func (h *Handler) readSchemaInfo(ctx context.Context, w http.ResponseWriter, req
 	return h.sendSuccessResponse(w, req.ID, response)
 }
 
+// readTables returns the names of the connection's tables, reading them as
+// rows from the database.
+func readTables(ctx context.Context, conn Connection) (*QueryResult, error) {
+	rows, err := conn.QueryRows(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema NOT IN ('information_schema', 'performance_schema', 'mysql', 'sys') LIMIT 100")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := &QueryResult{
+		Columns:     []string{"table_name"},
+		ColumnTypes: []string{"text"},
+		Rows:        [][]interface{}{},
+	}
+	for rows.Next() {
+		var name interface{}
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, []interface{}{name})
+	}
+	return result, rows.Err()
+}
+
 // formatConnectionsList formats the connections list as a JSON string.
-func formatConnectionsList(connections map[string]ConnectionInfo) string {
+func formatConnectionsList(connections interface{}) string {
 	data, err := json.MarshalIndent(connections, "", "  ")
 	if err != nil {
 		return "{\"error\": \"failed to format connections list\"}"
@@ -170,4 +271,4 @@ type Resource struct {
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
 	MimeType    string `json:"mimeType,omitempty"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,222 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// SessionHeader is the header carrying the ID of a client's session, issued
+// on initialization.
+const SessionHeader = "Mcp-Session-Id"
+
+// Session is the state a client holds on the server across requests.
+type Session interface {
+	ID() string
+	Connection() string
+	SetConnection(id string)
+	Variables() map[string]interface{}
+	SetVariable(name string, value interface{})
+	AddCursor(id string)
+	RemoveCursor(id string)
+	Transactions() []TransactionInfo
+
+	// Bind returns a copy of ctx bound to the session, so that queries
+	// and statements executed with it run in the session's transactions.
+	Bind(ctx context.Context) context.Context
+}
+
+// sessionKey is the context key of the request's session.
+type sessionKey struct{}
+
+// withSession returns a copy of ctx carrying the session.
+func withSession(ctx context.Context, session Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// sessionFrom returns the session of ctx, or nil when the request is not
+// part of a session.
+func sessionFrom(ctx context.Context) Session {
+	session, _ := ctx.Value(sessionKey{}).(Session)
+	return session
+}
+
+// sessionConnectionTools are the tools defaulting to the session's
+// connection when no connection_id is given.
+var sessionConnectionTools = map[string]bool{
+	"execute_query":        true,
+	"execute_statement":    true,
+	"open_cursor":          true,
+	"submit_query":         true,
+	"advise_indexes":       true,
+	"call_procedure":       true,
+	"export_xlsx":          true,
+	"export_parquet":       true,
+	"begin_transaction":    true,
+	"commit_transaction":   true,
+	"rollback_transaction": true,
+}
+
+// sessionVariableTools are the tools binding the session's variables to
+// the named parameters of their queries.
+var sessionVariableTools = map[string]bool{
+	"execute_query":     true,
+	"execute_statement": true,
+	"open_cursor":       true,
+	"submit_query":      true,
+	"advise_indexes":    true,
+	"export_xlsx":       true,
+	"export_parquet":    true,
+}
+
+// variableName matches the names of session variables.
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// applySession fills in the arguments of the tool from the session: the
+// session's connection when no connection_id is given, and its variables
+// as the values of the query's named parameters not given in params, unless
+// positional args are.
+func applySession(session Session, name string, arguments map[string]interface{}) {
+	if id, _ := arguments["connection_id"].(string); id == "" && sessionConnectionTools[name] {
+		if id = session.Connection(); id != "" {
+			arguments["connection_id"] = id
+		}
+	}
+	if _, ok := arguments["args"]; ok || !sessionVariableTools[name] {
+		return
+	}
+	params, ok := arguments["params"].(map[string]interface{})
+	if !ok && arguments["params"] != nil {
+		// left to fail validation
+		return
+	}
+	// only the variables the query refers to are bound, as params no
+	// placeholder refers to are errors
+	query, _ := arguments["query"].(string)
+	used := queryParams(query)
+	variables := make(map[string]interface{})
+	for name, value := range session.Variables() {
+		if used[name] {
+			variables[name] = value
+		}
+	}
+	if len(variables) == 0 {
+		return
+	}
+	for name, value := range params {
+		variables[name] = value
+	}
+	arguments["params"] = variables
+}
+
+// queryParams returns the names of the named parameters (:name, @name) of
+// the query.
+func queryParams(query string) map[string]bool {
+	names := make(map[string]bool)
+	for _, t := range sqlscan.Scan(query) {
+		if t.Kind == sqlscan.Placeholder {
+			names[strings.TrimLeft(t.Text, ":@")] = true
+		}
+	}
+	return names
+}
+
+// sessionTools returns the tools for managing the state of the client's
+// session.
+func sessionTools() []Tool {
+	return []Tool{
+		{
+			Name:        "use_connection",
+			Description: "Set the default connection of the session, used by tools called without a connection_id",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID of the connection to use by default. When not given, the default connection is cleared",
+					},
+				},
+			},
+		},
+		{
+			Name:        "set_variable",
+			Description: "Set a variable of the session, bound to the :name and @name parameters of queries not given in params (when no positional args are)",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{
+						"type":        "string",
+						"description": "The name of the variable",
+					},
+					"value": map[string]interface{}{
+						"description": "The value of the variable. When not given, or null, the variable is unset",
+					},
+				},
+				"required": []string{"name"},
+			},
+		},
+		{
+			Name:        "session_info",
+			Description: "Show the state of the session: its ID, default connection, variables and open transactions",
+			InputSchema: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{},
+			},
+		},
+	}
+}
+
+// errNoSession is the message of session tools called outside a session.
+const errNoSession = "no session: send the " + SessionHeader + " header issued on initialization"
+
+// toolUseConnection implements the use_connection tool.
+func (h *Handler) toolUseConnection(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	session := sessionFrom(ctx)
+	if session == nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", errNoSession)
+	}
+	connectionID, _ := args["connection_id"].(string)
+	if connectionID != "" {
+		if _, err := h.pool.GetConnection(connectionID); err != nil && !h.pool.IsGroup(connectionID) {
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection not found", err.Error())
+		}
+	}
+	session.SetConnection(connectionID)
+	return h.sendToolResult(w, req.ID, map[string]interface{}{
+		"connection_id": connectionID,
+	})
+}
+
+// toolSetVariable implements the set_variable tool.
+func (h *Handler) toolSetVariable(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	session := sessionFrom(ctx)
+	if session == nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", errNoSession)
+	}
+	name, _ := args["name"].(string)
+	if !variableName.MatchString(name) {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("invalid variable name %q", name))
+	}
+	session.SetVariable(name, args["value"])
+	return h.sendToolResult(w, req.ID, map[string]interface{}{
+		"variables": session.Variables(),
+	})
+}
+
+// toolSessionInfo implements the session_info tool.
+func (h *Handler) toolSessionInfo(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	session := sessionFrom(ctx)
+	if session == nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", errNoSession)
+	}
+	return h.sendToolResult(w, req.ID, map[string]interface{}{
+		"session_id":    session.ID(),
+		"connection_id": session.Connection(),
+		"variables":     session.Variables(),
+		"transactions":  session.Transactions(),
+	})
+}
@@ -0,0 +1,47 @@
+package mcp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// testSession is a session holding variables.
+type testSession struct {
+	Session
+	variables map[string]interface{}
+}
+
+func (s testSession) Connection() string { return "" }
+
+func (s testSession) Variables() map[string]interface{} {
+	variables := make(map[string]interface{})
+	for name, value := range s.variables {
+		variables[name] = value
+	}
+	return variables
+}
+
+func (s testSession) Bind(ctx context.Context) context.Context { return ctx }
+
+func TestApplySession(t *testing.T) {
+	session := testSession{variables: map[string]interface{}{"region": "emea", "year": 2024}}
+	tests := []struct {
+		arguments map[string]interface{}
+		exp       interface{}
+	}{
+		{map[string]interface{}{"query": "SELECT 1"}, nil},
+		{map[string]interface{}{"query": "SELECT * FROM sales WHERE region = :region"}, map[string]interface{}{"region": "emea"}},
+		{map[string]interface{}{"query": "SELECT * FROM sales WHERE region = @region AND year = :year"}, map[string]interface{}{"region": "emea", "year": 2024}},
+		{map[string]interface{}{"query": "SELECT * FROM sales WHERE region = :region", "params": map[string]interface{}{"region": "apac"}}, map[string]interface{}{"region": "apac"}},
+		{map[string]interface{}{"query": "SELECT * FROM sales WHERE region = :region AND id = :id", "params": map[string]interface{}{"id": 1}}, map[string]interface{}{"region": "emea", "id": 1}},
+		{map[string]interface{}{"query": "SELECT ':region'"}, nil},
+		{map[string]interface{}{"query": "SELECT * FROM sales WHERE region = :region", "args": []interface{}{"apac"}}, nil},
+	}
+	for i, test := range tests {
+		applySession(session, "execute_query", test.arguments)
+		if params := test.arguments["params"]; !reflect.DeepEqual(params, test.exp) {
+			t.Errorf("test %d: expected params %v, got: %v", i, test.exp, params)
+		}
+	}
+}
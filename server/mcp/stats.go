@@ -0,0 +1,75 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// QueryStats are the statistics of the executions of a query fingerprint (a
+// query with its literals replaced with ?) on a connection, over the stats
+// window.
+type QueryStats struct {
+	Fingerprint  string    `json:"fingerprint"`
+	SQL          string    `json:"sql"`
+	ConnectionID string    `json:"connection_id"`
+	Count        int64     `json:"count"`
+	Errors       int64     `json:"errors"`
+	Rows         int64     `json:"rows"`
+	TotalMS      float64   `json:"total_ms"`
+	MeanMS       float64   `json:"mean_ms"`
+	P95MS        float64   `json:"p95_ms"`
+	MaxMS        float64   `json:"max_ms"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// readQueryStats returns the statistics of the query fingerprints executed
+// on the connections the principal may use, optionally of the connection_id
+// param, sorted by the sort param (total by default), up to the limit param
+// (100 by default).
+func (h *Handler) readQueryStats(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, params map[string]interface{}) error {
+	var id string
+	sort, limit := "total", 100
+	if v, ok := params["connection_id"]; ok {
+		if id, ok = v.(string); !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id must be a string")
+		}
+		id = policy.QualifyConnection(ctx, id)
+	}
+	if v, ok := params["sort"]; ok {
+		if sort, ok = v.(string); !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "sort must be a string")
+		}
+	}
+	if v, ok := params["limit"]; ok {
+		n, ok := v.(float64)
+		if !ok || n < 0 {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "limit must be a non-negative number")
+		}
+		limit = int(n)
+	}
+	stats, err := h.pool.QueryStats(ctx, id, sort, limit)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+	statsJSON, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Internal error", fmt.Sprintf("failed to format query stats: %v", err))
+	}
+
+	result := map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{
+				"uri":      "stats://queries",
+				"mimeType": "application/json",
+				"text":     string(statsJSON),
+			},
+		},
+	}
+
+	return h.sendSuccessResponse(w, req.ID, result)
+}
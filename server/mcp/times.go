@@ -0,0 +1,51 @@
+package mcp
+
+import (
+	"fmt"
+
+	"github.com/xo/usql/server/timefmt"
+)
+
+// timeFormatProperty is the input schema of the time_format argument.
+var timeFormatProperty = map[string]interface{}{
+	"type":        "string",
+	"enum":        []string{timefmt.RFC3339, timefmt.EpochMillis, timefmt.Native},
+	"description": "Optional representation of date and time values: RFC 3339 strings in the time zone (rfc3339), milliseconds since the Unix epoch (epoch_millis), or RFC 3339 strings in the zone returned by the database (native). Defaults to the server's time format",
+}
+
+// timeZoneProperty is the input schema of the time_zone argument.
+var timeZoneProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "Optional IANA time zone (e.g. UTC or Europe/Berlin) of date and time values in the rfc3339 format. Defaults to the server's time zone",
+}
+
+// parseTimes returns the format of time values, overriding the handler's
+// default with the time_format and time_zone arguments, if provided.
+func (h *Handler) parseTimes(args map[string]interface{}) (*timefmt.Format, error) {
+	var names [2]string
+	for i, key := range []string{"time_format", "time_zone"} {
+		if v, exists := args[key]; exists {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a string", key)
+			}
+			names[i] = s
+		}
+	}
+	return h.times.With(names[0], names[1])
+}
+
+// formatTimes returns a copy of the result, and its further result sets,
+// with its time values formatted.
+func formatTimes(f *timefmt.Format, result *QueryResult) *QueryResult {
+	if f == nil || result == nil {
+		return result
+	}
+	formatted := *result
+	formatted.Rows = f.Rows(result.Rows)
+	formatted.MoreResultSets = make([]*QueryResult, len(result.MoreResultSets))
+	for i, set := range result.MoreResultSets {
+		formatted.MoreResultSets[i] = formatTimes(f, set)
+	}
+	return &formatted
+}
@@ -2,13 +2,74 @@ package mcp
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
+
+	"github.com/xo/usql/server/policy"
 )
 
 // handleToolsList handles requests to list available tools.
 func (h *Handler) handleToolsList(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest) error {
+	var tools []Tool
+	for _, tool := range append(builtinTools(), h.savedQueryTools()...) {
+		if policy.AllowsTool(ctx, tool.Name, h.toolClass(tool.Name)) {
+			tools = append(tools, tool)
+		}
+	}
+
+	result := map[string]interface{}{
+		"tools": h.deprecateTools(tools, time.Now()),
+	}
+
+	return h.sendSuccessResponse(w, req.ID, result)
+}
+
+// toolClasses are the classes of the built-in tools managing connections or
+// executing statements, by name. Other tools are of the query class.
+var toolClasses = map[string]string{
+	"create_connection": policy.ToolAdmin,
+	"close_connection":  policy.ToolAdmin,
+	"rename_connection": policy.ToolAdmin,
+	"alias_connection":  policy.ToolAdmin,
+	"invalidate_cache":  policy.ToolAdmin,
+	"execute_statement": policy.ToolStatement,
+	"call_procedure":    policy.ToolStatement,
+}
+
+// toolClass returns the class of the tool with the name: that of saved
+// queries depends on whether they are statements.
+func (h *Handler) toolClass(name string) string {
+	if class, ok := toolClasses[name]; ok {
+		return class
+	}
+	if q, ok := h.savedQuery(name); ok && q.Statement {
+		return policy.ToolStatement
+	}
+	return policy.ToolQuery
+}
+
+// qualifyArguments qualifies the connection IDs and aliases of the
+// arguments in the namespace of the principal's tenant, if any.
+func qualifyArguments(ctx context.Context, arguments map[string]interface{}) {
+	for _, key := range []string{"connection_id", "new_connection_id", "alias"} {
+		if id, ok := arguments[key].(string); ok && id != "" {
+			arguments[key] = policy.QualifyConnection(ctx, id)
+		}
+	}
+	if aliases, ok := arguments["aliases"].([]interface{}); ok {
+		for i, v := range aliases {
+			if alias, ok := v.(string); ok {
+				aliases[i] = policy.QualifyConnection(ctx, alias)
+			}
+		}
+	}
+}
+
+// builtinTools returns the built-in tools.
+func builtinTools() []Tool {
 	tools := []Tool{
 		{
 			Name:        "execute_query",
@@ -18,21 +79,50 @@ func (h *Handler) handleToolsList(ctx context.Context, w http.ResponseWriter, re
 				"properties": map[string]interface{}{
 					"connection_id": map[string]interface{}{
 						"type":        "string",
-						"description": "The ID of the database connection to use",
+						"description": "The ID of the database connection to use, or of a connection group, routing the query to one of its members",
 					},
 					"query": map[string]interface{}{
 						"type":        "string",
 						"description": "The SQL query to execute",
 					},
-					"args": map[string]interface{}{
-						"type":        "array",
-						"description": "Optional query arguments for parameterized queries",
-						"items": map[string]interface{}{
-							"type": "string",
-						},
+					"args":        argsProperty,
+					"approval_id": approvalProperty,
+					"params": map[string]interface{}{
+						"type":        "object",
+						"description": "Optional named parameter values for queries using :name or @name parameters (instead of args)",
+					},
+					"filter":        filterProperty,
+					"time_format":   timeFormatProperty,
+					"time_zone":     timeZoneProperty,
+					"nulls":         nullsProperty,
+					"null_sentinel": nullSentinelProperty,
+					"row_format":    rowFormatProperty,
+					"format":        formatProperty,
+					"pset":          psetProperty,
+					"chart":         chartProperty,
+					"pretty":        prettyProperty,
+					"max_rows": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum number of rows to return (defaults to, and is capped at, the server's row cap). When more rows remain, the result includes a continuation_token",
+						"minimum":     1,
 					},
+					"max_result_rows": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum number of rows to read into an unpaginated result (capped at the server's maximum). When rows are left unread, the result is marked truncated",
+						"minimum":     1,
+					},
+					"max_result_bytes": map[string]interface{}{
+						"type":        "integer",
+						"description": "Maximum approximate size in bytes of the rows read into the result (capped at the server's maximum). An unpaginated result is marked truncated when rows are left unread, and a page ends early",
+						"minimum":     1,
+					},
+					"continuation_token": map[string]interface{}{
+						"type":        "string",
+						"description": "The continuation_token of a previous result, to fetch its next page of rows (instead of query)",
+					},
+					"dry_run": dryRunProperty,
 				},
-				"required": []string{"connection_id", "query"},
+				"required": []string{"connection_id"},
 			},
 		},
 		{
@@ -47,10 +137,90 @@ func (h *Handler) handleToolsList(ctx context.Context, w http.ResponseWriter, re
 					},
 					"dsn": map[string]interface{}{
 						"type":        "string",
-						"description": "The database connection string (DSN)",
+						"description": "The database connection string (DSN). Alternatively, the DSN is composed from the driver, host, port, user, password_ref, database and params fields, which need no URL escaping",
+					},
+					"driver": map[string]interface{}{
+						"type":        "string",
+						"description": "The driver (scheme) of the composed DSN, such as postgres, mysql, sqlserver or sqlite3",
+					},
+					"host": map[string]interface{}{
+						"type":        "string",
+						"description": "The database host of the composed DSN. Without a host, the database is a file path",
+					},
+					"port": map[string]interface{}{
+						"type":        "integer",
+						"description": "The database port of the composed DSN, the driver's default when not given",
+					},
+					"user": map[string]interface{}{
+						"type":        "string",
+						"description": "The user of the composed DSN",
+					},
+					"password_ref": map[string]interface{}{
+						"type":        "string",
+						"description": "A secret reference (e.g. ${env:DB_PASSWORD}) to the password of the composed DSN, of a kind allowed in client DSNs",
+					},
+					"database": map[string]interface{}{
+						"type":        "string",
+						"description": "The database name, or file path without a host, of the composed DSN",
+					},
+					"params": map[string]interface{}{
+						"type":        "object",
+						"description": "The driver parameters of the composed DSN (e.g. {\"sslmode\": \"require\"})",
+					},
+					"replicas": map[string]interface{}{
+						"type":        "array",
+						"description": "Optional DSNs of further instances of the database (e.g. read replicas), across which read queries are load balanced",
+						"items":       map[string]interface{}{"type": "string"},
+					},
+					"failover": map[string]interface{}{
+						"type":        "array",
+						"description": "Optional DSNs the connection fails over to, in order, once its database keeps failing health checks, keeping the connection's ID",
+						"items":       map[string]interface{}{"type": "string"},
+					},
+					"aliases": map[string]interface{}{
+						"type":        "array",
+						"description": "Optional further names of the connection, usable in place of its ID",
+						"items":       map[string]interface{}{"type": "string"},
+					},
+					"tags": map[string]interface{}{
+						"type":                 "object",
+						"description":          "Optional key/value tags of the connection (e.g. {\"env\": \"prod\", \"team\": \"payments\"}), by which the connections://list resource filters connections",
+						"additionalProperties": map[string]interface{}{"type": "string"},
+					},
+					"connect": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Connect to the database now (default true). When false, the DSN is only registered, and the database is connected to on the connection's first use",
+					},
+					"tls": map[string]interface{}{
+						"type":        "object",
+						"description": "Optional TLS settings of the database connections, for postgres, mysql and sqlserver DSNs. Certificates and keys are PEM, or secret references (e.g. ${vault:...}) resolving to PEM",
+						"properties": map[string]interface{}{
+							"mode": map[string]interface{}{
+								"type":        "string",
+								"enum":        []string{"disable", "require", "verify-ca", "verify-full"},
+								"description": "How the server's certificate is verified (default verify-full)",
+							},
+							"ca": map[string]interface{}{
+								"type":        "string",
+								"description": "The CA bundle verifying the server's certificate, instead of the system's",
+							},
+							"cert": map[string]interface{}{
+								"type":        "string",
+								"description": "The client certificate",
+							},
+							"key": map[string]interface{}{
+								"type":        "string",
+								"description": "The client certificate's private key",
+							},
+							"server_name": map[string]interface{}{
+								"type":        "string",
+								"description": "The name the server's certificate is verified against, instead of the DSN's host",
+							},
+						},
+						"additionalProperties": false,
 					},
 				},
-				"required": []string{"connection_id", "dsn"},
+				"required": []string{"connection_id"},
 			},
 		},
 		{
@@ -67,6 +237,46 @@ func (h *Handler) handleToolsList(ctx context.Context, w http.ResponseWriter, re
 				"required": []string{"connection_id"},
 			},
 		},
+		{
+			Name:        "rename_connection",
+			Description: "Change the ID of a database connection, keeping its aliases",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID (or an alias) of the connection to rename",
+					},
+					"new_connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The new ID of the connection",
+					},
+				},
+				"required": []string{"connection_id", "new_connection_id"},
+			},
+		},
+		{
+			Name:        "alias_connection",
+			Description: "Add (or remove) an alias of a database connection, usable in place of its ID",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": map[string]interface{}{
+						"type":        "string",
+						"description": "The ID (or an alias) of the connection",
+					},
+					"alias": map[string]interface{}{
+						"type":        "string",
+						"description": "The alias",
+					},
+					"remove": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Remove the alias instead of adding it",
+					},
+				},
+				"required": []string{"alias"},
+			},
+		},
 		{
 			Name:        "execute_statement",
 			Description: "Execute a SQL statement (INSERT, UPDATE, DELETE, etc.)",
@@ -81,24 +291,29 @@ func (h *Handler) handleToolsList(ctx context.Context, w http.ResponseWriter, re
 						"type":        "string",
 						"description": "The SQL statement to execute",
 					},
-					"args": map[string]interface{}{
-						"type":        "array",
-						"description": "Optional statement arguments for parameterized statements",
-						"items": map[string]interface{}{
-							"type": "string",
-						},
+					"args":        argsProperty,
+					"approval_id": approvalProperty,
+					"params": map[string]interface{}{
+						"type":        "object",
+						"description": "Optional named parameter values for statements using :name or @name parameters (instead of args)",
 					},
+					"dry_run": dryRunProperty,
 				},
 				"required": []string{"connection_id", "statement"},
 			},
 		},
 	}
 
-	result := map[string]interface{}{
-		"tools": tools,
-	}
-
-	return h.sendSuccessResponse(w, req.ID, result)
+	tools = append(tools, cursorTools()...)
+	tools = append(tools, jobTools()...)
+	tools = append(tools, advisorTools()...)
+	tools = append(tools, procedureTools()...)
+	tools = append(tools, exportTools()...)
+	tools = append(tools, cacheTools()...)
+	tools = append(tools, sessionTools()...)
+	tools = append(tools, transactionTools()...)
+	tools = append(tools, dsnTools()...)
+	return tools
 }
 
 // handleToolsCall handles tool invocation requests.
@@ -119,6 +334,38 @@ func (h *Handler) handleToolsCall(ctx context.Context, w http.ResponseWriter, re
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "arguments is required")
 	}
 
+	if d, ok := h.deprecations[name]; ok {
+		d.SetHeaders(w.Header())
+		if d.Removed(time.Now()) {
+			return h.sendErrorResponse(w, req.ID, -32601, fmt.Sprintf("Tool %s was removed", name), d)
+		}
+	}
+
+	// Tools and connections are restricted by the principal's scopes, and
+	// connections are referred to in its tenant's namespace
+	if !policy.AllowsTool(ctx, name, h.toolClass(name)) {
+		return h.sendErrorResponse(w, req.ID, -32603, "Forbidden", fmt.Sprintf("tool %s is not granted", name))
+	}
+	qualifyArguments(ctx, arguments)
+	if session := sessionFrom(ctx); session != nil {
+		applySession(session, name, arguments)
+		ctx = session.Bind(ctx)
+	}
+	for _, key := range []string{"connection_id", "new_connection_id"} {
+		if id, ok := arguments[key].(string); ok && !policy.AllowsConnection(ctx, id) {
+			return h.sendErrorResponse(w, req.ID, -32603, "Forbidden", fmt.Sprintf("connection %s is not granted", id))
+		}
+	}
+
+	// Statements requiring approval run once approved
+	if v, exists := arguments["approval_id"]; exists {
+		id, ok := v.(string)
+		if !ok || id == "" {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "approval_id must be a string")
+		}
+		ctx = policy.WithApproval(ctx, id)
+	}
+
 	// Route to appropriate tool handler
 	switch name {
 	case "execute_query":
@@ -127,9 +374,54 @@ func (h *Handler) handleToolsCall(ctx context.Context, w http.ResponseWriter, re
 		return h.toolCreateConnection(ctx, w, req, arguments)
 	case "close_connection":
 		return h.toolCloseConnection(ctx, w, req, arguments)
+	case "rename_connection":
+		return h.toolRenameConnection(ctx, w, req, arguments)
+	case "alias_connection":
+		return h.toolAliasConnection(ctx, w, req, arguments)
 	case "execute_statement":
 		return h.toolExecuteStatement(ctx, w, req, arguments)
+	case "open_cursor":
+		return h.toolOpenCursor(ctx, w, req, arguments)
+	case "fetch":
+		return h.toolFetch(ctx, w, req, arguments)
+	case "close_cursor":
+		return h.toolCloseCursor(ctx, w, req, arguments)
+	case "submit_query":
+		return h.toolSubmitQuery(ctx, w, req, arguments)
+	case "job_status":
+		return h.toolJobStatus(ctx, w, req, arguments)
+	case "job_result":
+		return h.toolJobResult(ctx, w, req, arguments)
+	case "cancel_job":
+		return h.toolCancelJob(ctx, w, req, arguments)
+	case "advise_indexes":
+		return h.toolAdviseIndexes(ctx, w, req, arguments)
+	case "call_procedure":
+		return h.toolCallProcedure(ctx, w, req, arguments)
+	case "export_xlsx":
+		return h.toolExport(ctx, w, req, "xlsx", arguments)
+	case "export_parquet":
+		return h.toolExport(ctx, w, req, "parquet", arguments)
+	case "invalidate_cache":
+		return h.toolInvalidateCache(ctx, w, req, arguments)
+	case "validate_dsn":
+		return h.toolValidateDSN(ctx, w, req, arguments)
+	case "use_connection":
+		return h.toolUseConnection(ctx, w, req, arguments)
+	case "set_variable":
+		return h.toolSetVariable(ctx, w, req, arguments)
+	case "session_info":
+		return h.toolSessionInfo(ctx, w, req, arguments)
+	case "begin_transaction":
+		return h.toolBeginTransaction(ctx, w, req, arguments)
+	case "commit_transaction":
+		return h.toolEndTransaction(ctx, w, req, arguments, true)
+	case "rollback_transaction":
+		return h.toolEndTransaction(ctx, w, req, arguments, false)
 	default:
+		if query, ok := h.savedQuery(name); ok {
+			return h.toolSavedQuery(ctx, w, req, query, arguments)
+		}
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("unknown tool: %s", name))
 	}
 }
@@ -141,47 +433,140 @@ func (h *Handler) toolExecuteQuery(ctx context.Context, w http.ResponseWriter, r
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
 	}
 
+	token, _ := args["continuation_token"].(string)
 	query, ok := args["query"].(string)
-	if !ok {
+	if !ok && token == "" {
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "query is required")
 	}
 
-	// Get connection
-	conn, err := h.pool.GetConnection(connectionID)
+	maxRows := 0
+	if v, exists := args["max_rows"]; exists {
+		n, ok := v.(float64)
+		if !ok || n < 1 {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "max_rows must be a positive integer")
+		}
+		maxRows = int(n)
+	}
+
+	limits, err := parseLimits(args)
 	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	times, err := h.parseTimes(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	nullFormat, err := h.parseNulls(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	objects, err := parseRowFormat(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	// Get connection, unless querying a group
+	if _, err := h.pool.GetConnection(connectionID); err != nil && !h.pool.IsGroup(connectionID) {
 		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("connection not found: %s", connectionID))
 	}
 
 	// Parse query arguments if provided
-	var queryArgs []interface{}
-	if argsInterface, exists := args["args"]; exists {
-		if argSlice, ok := argsInterface.([]interface{}); ok {
-			queryArgs = argSlice
-		}
+	queryArgs, err := parseArgs(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	// Validate the query without executing it
+	switch dryRun, err := parseDryRun(args); {
+	case err != nil:
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	case dryRun && token != "":
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "dry_run cannot be used with continuation_token")
+	case dryRun:
+		return h.dryRun(ctx, w, req, connectionID, query, false, queryArgs)
 	}
 
-	// Execute query
-	result, err := conn.ExecuteQuery(ctx, query, queryArgs...)
+	filter, err := parseFilter(args)
 	if err != nil {
-		return h.sendErrorResponse(w, req.ID, -32603, "Query execution failed", err.Error())
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	format, pset, err := parseFormat(args)
+	switch {
+	case err != nil:
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	case format != "json" && filter != nil:
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "filter can only be used with the json format")
+	case format != "json" && objects:
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "row_format can only be used with the json format")
+	}
+	if _, ok := pset["null"]; !ok && nullFormat.Text() != "" {
+		pset["null"] = nullFormat.Text()
 	}
 
-	// Format result as JSON
-	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	// Execute query, or fetch its next page
+	var result *QueryResult
+	if token != "" {
+		result, err = h.pool.ContinueQuery(ctx, connectionID, token, maxRows, limits)
+	} else {
+		result, err = h.pool.QueryPage(ctx, connectionID, query, maxRows, limits, queryArgs...)
+	}
 	if err != nil {
-		return h.sendErrorResponse(w, req.ID, -32603, "Internal error", err.Error())
+		return h.sendErrorResponse(w, req.ID, -32603, "Query execution failed", errorData(err))
 	}
 
-	response := map[string]interface{}{
-		"content": []map[string]interface{}{
-			{
-				"type": "text",
-				"text": string(resultJSON),
-			},
-		},
+	var content []map[string]interface{}
+	if chart, _ := args["chart"].(bool); chart {
+		if c := chartContent(result); c != nil {
+			content = append(content, c)
+		}
 	}
 
-	return h.sendSuccessResponse(w, req.ID, response)
+	formatted := formatTimes(times, result)
+	if format == "json" {
+		v, err := applyFilter(filter, formatNulls(nullFormat, formatted, objects))
+		if err != nil {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+		}
+		pretty, _ := args["pretty"].(bool)
+		return h.sendToolJSON(w, req.ID, v, pretty, content...)
+	}
+
+	text, err := renderResult(formatted, format, pset)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+	texts := []string{text}
+	if result.ContinuationToken != "" {
+		texts = append(texts, fmt.Sprintf(`{"continuation_token": %q}`, result.ContinuationToken))
+	}
+	if result.Truncated {
+		texts = append(texts, `{"truncated": true}`)
+	}
+	return h.sendToolContent(w, req.ID, append(textContent(texts...), content...))
+}
+
+// parseLimits parses the max_result_rows and max_result_bytes arguments.
+func parseLimits(args map[string]interface{}) (ResultLimits, error) {
+	var limits ResultLimits
+	if v, exists := args["max_result_rows"]; exists {
+		n, ok := v.(float64)
+		if !ok || n < 1 {
+			return limits, fmt.Errorf("max_result_rows must be a positive integer")
+		}
+		limits.Rows = int(n)
+	}
+	if v, exists := args["max_result_bytes"]; exists {
+		n, ok := v.(float64)
+		if !ok || n < 1 {
+			return limits, fmt.Errorf("max_result_bytes must be a positive integer")
+		}
+		limits.Bytes = int64(n)
+	}
+	return limits, nil
 }
 
 // toolCreateConnection implements the create_connection tool.
@@ -192,21 +577,163 @@ func (h *Handler) toolCreateConnection(ctx context.Context, w http.ResponseWrite
 	}
 
 	dsn, ok := args["dsn"].(string)
-	if !ok {
-		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "dsn is required")
+	if ok {
+		for _, name := range dsnFields {
+			if _, exists := args[name]; exists {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("dsn cannot be given with %s", name))
+			}
+		}
+	} else {
+		built, err := buildDSN(args)
+		switch {
+		case err != nil:
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+		case built == "":
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "dsn, or the driver and further DSN fields, is required")
+		}
+		dsn = built
 	}
 
-	// Create connection
-	_, err := h.pool.CreateConnection(ctx, connectionID, dsn)
-	if err != nil {
-		return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", err.Error())
+	var replicas []string
+	if v, exists := args["replicas"]; exists {
+		list, ok := v.([]interface{})
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "replicas must be an array of DSNs")
+		}
+		for _, item := range list {
+			replica, ok := item.(string)
+			if !ok {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "replicas must be an array of DSNs")
+			}
+			replicas = append(replicas, replica)
+		}
+	}
+
+	var failover []string
+	if v, exists := args["failover"]; exists {
+		list, ok := v.([]interface{})
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "failover must be an array of DSNs")
+		}
+		for _, item := range list {
+			dsn, ok := item.(string)
+			if !ok {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "failover must be an array of DSNs")
+			}
+			failover = append(failover, dsn)
+		}
+	}
+
+	var aliases []string
+	if v, exists := args["aliases"]; exists {
+		list, ok := v.([]interface{})
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "aliases must be an array of strings")
+		}
+		for _, item := range list {
+			alias, ok := item.(string)
+			if !ok {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "aliases must be an array of strings")
+			}
+			aliases = append(aliases, alias)
+		}
+	}
+
+	var tags map[string]string
+	if v, exists := args["tags"]; exists {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "tags must be an object of strings")
+		}
+		tags = make(map[string]string, len(m))
+		for k, v := range m {
+			if tags[k], ok = v.(string); !ok {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "tags must be an object of strings")
+			}
+		}
+	}
+
+	var tls *ConnectionTLS
+	if v, exists := args["tls"]; exists {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "tls must be an object")
+		}
+		tls = new(ConnectionTLS)
+		for k, v := range m {
+			s, ok := v.(string)
+			if !ok {
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("tls %s must be a string", k))
+			}
+			switch k {
+			case "mode":
+				tls.Mode = s
+			case "ca":
+				tls.CA = s
+			case "cert":
+				tls.Cert = s
+			case "key":
+				tls.Key = s
+			case "server_name":
+				tls.ServerName = s
+			default:
+				return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", fmt.Sprintf("unknown tls setting %s", k))
+			}
+		}
+	}
+
+	connect := true
+	if v, exists := args["connect"]; exists {
+		if connect, ok = v.(bool); !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connect must be a boolean")
+		}
+	}
+	if !connect && len(replicas) != 0 {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "replicas cannot be given without connecting")
+	}
+
+	// Create connection, or only register it
+	text := fmt.Sprintf("Successfully created connection: %s", connectionID)
+	if connect {
+		if _, err := h.pool.CreateConnection(ctx, connectionID, dsn, tls); err != nil {
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", err.Error())
+		}
+	} else {
+		if err := h.pool.RegisterConnection(ctx, connectionID, dsn, tls); err != nil {
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", err.Error())
+		}
+		text = fmt.Sprintf("Successfully registered connection: %s (connecting on first use)", connectionID)
+	}
+	for i, replica := range replicas {
+		if err := h.pool.AddReplica(ctx, connectionID, replica); err != nil {
+			h.pool.CloseConnection(connectionID)
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", fmt.Sprintf("replica %d: %v", i+1, err))
+		}
+	}
+	for _, alias := range aliases {
+		if err := h.pool.AddAlias(connectionID, alias); err != nil {
+			h.pool.CloseConnection(connectionID)
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", err.Error())
+		}
+	}
+	if len(tags) != 0 {
+		if err := h.pool.SetTags(connectionID, tags); err != nil {
+			h.pool.CloseConnection(connectionID)
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", err.Error())
+		}
+	}
+	if len(failover) != 0 {
+		if err := h.pool.SetFailover(connectionID, failover); err != nil {
+			h.pool.CloseConnection(connectionID)
+			return h.sendErrorResponse(w, req.ID, -32603, "Connection creation failed", err.Error())
+		}
 	}
 
 	response := map[string]interface{}{
 		"content": []map[string]interface{}{
 			{
 				"type": "text",
-				"text": fmt.Sprintf("Successfully created connection: %s", connectionID),
+				"text": text,
 			},
 		},
 	}
@@ -239,6 +766,70 @@ func (h *Handler) toolCloseConnection(ctx context.Context, w http.ResponseWriter
 	return h.sendSuccessResponse(w, req.ID, response)
 }
 
+// toolRenameConnection implements the rename_connection tool.
+func (h *Handler) toolRenameConnection(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	connectionID, ok := args["connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+	newID, ok := args["new_connection_id"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "new_connection_id is required")
+	}
+
+	if err := h.pool.RenameConnection(connectionID, newID); err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Connection rename failed", err.Error())
+	}
+
+	response := map[string]interface{}{
+		"content": []map[string]interface{}{
+			{
+				"type": "text",
+				"text": fmt.Sprintf("Successfully renamed connection %s to %s", connectionID, newID),
+			},
+		},
+	}
+
+	return h.sendSuccessResponse(w, req.ID, response)
+}
+
+// toolAliasConnection implements the alias_connection tool.
+func (h *Handler) toolAliasConnection(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	alias, ok := args["alias"].(string)
+	if !ok {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "alias is required")
+	}
+	remove, _ := args["remove"].(bool)
+
+	var text string
+	if remove {
+		if err := h.pool.RemoveAlias(alias); err != nil {
+			return h.sendErrorResponse(w, req.ID, -32603, "Alias removal failed", err.Error())
+		}
+		text = fmt.Sprintf("Successfully removed alias: %s", alias)
+	} else {
+		connectionID, ok := args["connection_id"].(string)
+		if !ok {
+			return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+		}
+		if err := h.pool.AddAlias(connectionID, alias); err != nil {
+			return h.sendErrorResponse(w, req.ID, -32603, "Alias creation failed", err.Error())
+		}
+		text = fmt.Sprintf("Successfully added alias %s of connection %s", alias, connectionID)
+	}
+
+	response := map[string]interface{}{
+		"content": []map[string]interface{}{
+			{
+				"type": "text",
+				"text": text,
+			},
+		},
+	}
+
+	return h.sendSuccessResponse(w, req.ID, response)
+}
+
 // toolExecuteStatement implements the execute_statement tool.
 func (h *Handler) toolExecuteStatement(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
 	connectionID, ok := args["connection_id"].(string)
@@ -258,35 +849,124 @@ func (h *Handler) toolExecuteStatement(ctx context.Context, w http.ResponseWrite
 	}
 
 	// Parse statement arguments if provided
-	var stmtArgs []interface{}
-	if argsInterface, exists := args["args"]; exists {
-		if argSlice, ok := argsInterface.([]interface{}); ok {
-			stmtArgs = argSlice
-		}
+	stmtArgs, err := parseArgs(args)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	}
+
+	// Validate the statement without executing it
+	switch dryRun, err := parseDryRun(args); {
+	case err != nil:
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", err.Error())
+	case dryRun:
+		return h.dryRun(ctx, w, req, connectionID, statement, true, stmtArgs)
 	}
 
 	// Execute statement
 	result, err := conn.ExecuteStatement(ctx, statement, stmtArgs...)
 	if err != nil {
-		return h.sendErrorResponse(w, req.ID, -32603, "Statement execution failed", err.Error())
+		return h.sendErrorResponse(w, req.ID, -32603, "Statement execution failed", errorData(err))
 	}
 
-	// Format result as JSON
-	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	return h.sendToolResult(w, req.ID, result)
+}
+
+// argsProperty is the input schema of positional query arguments.
+var argsProperty = map[string]interface{}{
+	"type":        "array",
+	"description": `Optional query arguments for parameterized queries. Arguments can be any JSON value, or an object {"value": ..., "type": ...} giving the value's type (string, integer, number, decimal, boolean, null, timestamp, date, bytes as base64, or json)`,
+}
+
+// dryRunProperty is the input schema of the dry_run argument.
+var dryRunProperty = map[string]interface{}{
+	"type":        "boolean",
+	"description": "Validate the SQL by preparing it, without executing it, returning its parameter count and, for queries, its result columns",
+}
+
+// parseDryRun parses the dry_run argument of a tool call.
+func parseDryRun(args map[string]interface{}) (bool, error) {
+	v, exists := args["dry_run"]
+	if !exists {
+		return false, nil
+	}
+	dryRun, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("dry_run must be a boolean")
+	}
+	return dryRun, nil
+}
+
+// dryRun validates the query or statement without executing it, sending the
+// result of the validation.
+func (h *Handler) dryRun(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, connectionID, query string, statement bool, args []interface{}) error {
+	result, err := h.pool.DryRun(ctx, connectionID, query, statement, args...)
 	if err != nil {
-		return h.sendErrorResponse(w, req.ID, -32603, "Internal error", err.Error())
+		return h.sendErrorResponse(w, req.ID, -32603, "Dry run failed", errorData(err))
 	}
+	return h.sendToolResult(w, req.ID, result)
+}
 
-	response := map[string]interface{}{
-		"content": []map[string]interface{}{
-			{
-				"type": "text",
-				"text": string(resultJSON),
-			},
-		},
+// approvalProperty is the input schema of the ID of the approval of a
+// statement requiring approval.
+var approvalProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "The ID of the approved approval of a statement requiring approval by the statement policies, when running it again",
+}
+
+// parseArgs parses the positional args or named params of a tool call into
+// query arguments. Named params are passed as sql.NamedArg, and are bound to
+// the driver's placeholders by the pool.
+func parseArgs(args map[string]interface{}) ([]interface{}, error) {
+	var queryArgs []interface{}
+	if argsInterface, exists := args["args"]; exists {
+		if argSlice, ok := argsInterface.([]interface{}); ok {
+			queryArgs = argSlice
+		}
 	}
 
-	return h.sendSuccessResponse(w, req.ID, response)
+	params, exists := args["params"]
+	if !exists || params == nil {
+		return queryArgs, nil
+	}
+	m, ok := params.(map[string]interface{})
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("params must be an object")
+	case len(queryArgs) != 0:
+		return nil, fmt.Errorf("args and params cannot both be specified")
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		queryArgs = append(queryArgs, sql.Named(name, m[name]))
+	}
+	return queryArgs, nil
+}
+
+// sendToolResult sends v formatted as compact JSON text content as a tool
+// result.
+func (h *Handler) sendToolResult(w http.ResponseWriter, id interface{}, v interface{}) error {
+	return h.sendToolJSON(w, id, v, false)
+}
+
+// sendToolContent sends a tool result of the content blocks.
+func (h *Handler) sendToolContent(w http.ResponseWriter, id interface{}, content []map[string]interface{}) error {
+	return h.sendSuccessResponse(w, id, map[string]interface{}{"content": content})
+}
+
+// textContent returns text content blocks of the texts.
+func textContent(texts ...string) []map[string]interface{} {
+	content := make([]map[string]interface{}, len(texts))
+	for i, text := range texts {
+		content[i] = map[string]interface{}{
+			"type": "text",
+			"text": text,
+		}
+	}
+	return content
 }
 
 // Tool represents an MCP tool.
@@ -294,4 +974,4 @@ type Tool struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description,omitempty"`
 	InputSchema interface{} `json:"inputSchema,omitempty"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,106 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TransactionInfo describes a transaction held open by a session.
+type TransactionInfo struct {
+	ConnectionID string    `json:"connection_id"`
+	Isolation    string    `json:"isolation,omitempty"`
+	ReadOnly     bool      `json:"read_only,omitempty"`
+	Started      time.Time `json:"started"`
+	LastUsed     time.Time `json:"last_used"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Statements   int64     `json:"statements"`
+	Outcome      string    `json:"outcome,omitempty"`
+}
+
+// transactionTools returns the tools for holding transactions open across
+// the tool calls of a session.
+func transactionTools() []Tool {
+	connectionID := map[string]interface{}{
+		"type":        "string",
+		"description": "The ID of the database connection of the transaction (defaults to the session's connection)",
+	}
+	return []Tool{
+		{
+			Name:        "begin_transaction",
+			Description: "Begin a transaction on a connection, held open by the session until committed or rolled back, or rolled back once idle beyond the server's transaction timeout. The session's execute_query and execute_statement calls on the connection run in the transaction, and are not visible to other sessions until committed",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": connectionID,
+					"isolation_level": map[string]interface{}{
+						"type":        "string",
+						"description": "The isolation level of the transaction, if supported by the database (defaults to the database's)",
+						"enum":        []string{"read_uncommitted", "read_committed", "write_committed", "repeatable_read", "snapshot", "serializable", "linearizable"},
+					},
+					"read_only": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Begin a read-only transaction, if supported by the database",
+					},
+				},
+			},
+		},
+		{
+			Name:        "commit_transaction",
+			Description: "Commit the session's transaction on a connection",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": connectionID,
+				},
+			},
+		},
+		{
+			Name:        "rollback_transaction",
+			Description: "Roll back the session's transaction on a connection",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"connection_id": connectionID,
+				},
+			},
+		},
+	}
+}
+
+// toolBeginTransaction implements the begin_transaction tool.
+func (h *Handler) toolBeginTransaction(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}) error {
+	if sessionFrom(ctx) == nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", errNoSession)
+	}
+	connectionID, ok := args["connection_id"].(string)
+	if !ok || connectionID == "" {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+	isolation, _ := args["isolation_level"].(string)
+	readOnly, _ := args["read_only"].(bool)
+
+	info, err := h.pool.BeginTransaction(ctx, connectionID, isolation, readOnly)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Transaction begin failed", errorData(err))
+	}
+	return h.sendToolResult(w, req.ID, info)
+}
+
+// toolEndTransaction implements the commit_transaction and
+// rollback_transaction tools.
+func (h *Handler) toolEndTransaction(ctx context.Context, w http.ResponseWriter, req *JSONRPCRequest, args map[string]interface{}, commit bool) error {
+	if sessionFrom(ctx) == nil {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", errNoSession)
+	}
+	connectionID, ok := args["connection_id"].(string)
+	if !ok || connectionID == "" {
+		return h.sendErrorResponse(w, req.ID, -32602, "Invalid params", "connection_id is required")
+	}
+
+	info, err := h.pool.EndTransaction(ctx, connectionID, commit)
+	if err != nil {
+		return h.sendErrorResponse(w, req.ID, -32603, "Transaction end failed", errorData(err))
+	}
+	return h.sendToolResult(w, req.ID, info)
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsPath is the path metrics are served at by default.
+const defaultMetricsPath = "/metrics"
+
+// durationBuckets are the upper bounds of the request duration histograms,
+// in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// requestKey identifies the requests of a method and tool.
+type requestKey struct {
+	method string
+	tool   string
+}
+
+// requestStats are the counts and duration histogram of requests.
+type requestStats struct {
+	count   uint64
+	errors  uint64
+	sum     float64
+	buckets []uint64
+}
+
+// requestMetrics counts the MCP requests handled, and their durations, by
+// method and tool, for the metrics served at path.
+type requestMetrics struct {
+	path    string
+	started time.Time
+
+	mu       sync.Mutex
+	requests map[requestKey]*requestStats
+}
+
+// newRequestMetrics creates the request metrics of the metrics served at
+// path, or the default metrics path when empty.
+func newRequestMetrics(path string) *requestMetrics {
+	if path == "" {
+		path = defaultMetricsPath
+	}
+	return &requestMetrics{
+		path:     path,
+		started:  time.Now(),
+		requests: make(map[requestKey]*requestStats),
+	}
+}
+
+// observe records a request of the method and tool, satisfying
+// mcp.Observer.
+func (m *requestMetrics) observe(method, tool string, d time.Duration, failed bool) {
+	key, secs := requestKey{method, tool}, d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.requests[key]
+	if !ok {
+		stats = &requestStats{buckets: make([]uint64, len(durationBuckets))}
+		m.requests[key] = stats
+	}
+	stats.count++
+	if failed {
+		stats.errors++
+	}
+	stats.sum += secs
+	for i, bound := range durationBuckets {
+		if secs <= bound {
+			stats.buckets[i]++
+		}
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+// family writes the help and type of the metric family.
+func (w *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample of the metric, with the labels given as name and
+// value pairs.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) != 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i != 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+// labelEscaper escapes the backslashes, quotes and newlines of label
+// values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// write writes the request metrics.
+func (m *requestMetrics) write(w *metricsWriter) {
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	stats := make(map[requestKey]requestStats, len(m.requests))
+	for key, s := range m.requests {
+		keys = append(keys, key)
+		stats[key] = requestStats{s.count, s.errors, s.sum, append([]uint64(nil), s.buckets...)}
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].tool < keys[j].tool
+	})
+
+	w.family("usqlr_mcp_requests_total", "counter", "MCP requests handled, by method and tool.")
+	for _, key := range keys {
+		w.sample("usqlr_mcp_requests_total", float64(stats[key].count), "method", key.method, "tool", key.tool)
+	}
+	w.family("usqlr_mcp_request_errors_total", "counter", "MCP requests failing with a JSON-RPC error, by method and tool.")
+	for _, key := range keys {
+		w.sample("usqlr_mcp_request_errors_total", float64(stats[key].errors), "method", key.method, "tool", key.tool)
+	}
+	w.family("usqlr_mcp_request_duration_seconds", "histogram", "Duration of MCP requests, by method and tool.")
+	for _, key := range keys {
+		s := stats[key]
+		for i, bound := range durationBuckets {
+			w.sample("usqlr_mcp_request_duration_seconds_bucket", float64(s.buckets[i]), "method", key.method, "tool", key.tool, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		w.sample("usqlr_mcp_request_duration_seconds_bucket", float64(s.count), "method", key.method, "tool", key.tool, "le", "+Inf")
+		w.sample("usqlr_mcp_request_duration_seconds_sum", s.sum, "method", key.method, "tool", key.tool)
+		w.sample("usqlr_mcp_request_duration_seconds_count", float64(s.count), "method", key.method, "tool", key.tool)
+	}
+}
+
+// writeMetrics writes the metrics of the pool: its connections, their queries
+// and database connections, and the open sessions, transactions and
+// cursors.
+func (cp *ConnectionPool) writeMetrics(w *metricsWriter) {
+	cp.mu.RLock()
+	conns := make([]*Connection, 0, len(cp.connections))
+	for _, conn := range cp.connections {
+		conns = append(conns, conn)
+	}
+	cp.mu.RUnlock()
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+
+	w.family("usqlr_connections", "gauge", "Connections in the pool.")
+	w.sample("usqlr_connections", float64(len(conns)))
+	w.family("usqlr_connection_queries_total", "counter", "Queries and statements executed, by connection.")
+	for _, conn := range conns {
+		w.sample("usqlr_connection_queries_total", float64(conn.queries.Load()), "connection", conn.ID)
+	}
+	w.family("usqlr_connection_query_errors_total", "counter", "Queries and statements failed or denied, by connection.")
+	for _, conn := range conns {
+		w.sample("usqlr_connection_query_errors_total", float64(conn.queryErrors.Load()), "connection", conn.ID)
+	}
+	w.family("usqlr_connection_active_queries", "gauge", "Queries and statements executing, by connection.")
+	for _, conn := range conns {
+		w.sample("usqlr_connection_active_queries", float64(conn.active.Load()), "connection", conn.ID)
+	}
+	w.family("usqlr_db_connections", "gauge", "Open database connections, by connection and state (in_use or idle).")
+	for _, conn := range conns {
+		// registered connections are yet to open their database
+		if conn.pending.Load() {
+			continue
+		}
+		stats := conn.DB.Stats()
+		w.sample("usqlr_db_connections", float64(stats.InUse), "connection", conn.ID, "state", "in_use")
+		w.sample("usqlr_db_connections", float64(stats.Idle), "connection", conn.ID, "state", "idle")
+	}
+
+	sessions, transactions := cp.sessions.counts()
+	w.family("usqlr_sessions", "gauge", "Open client sessions.")
+	w.sample("usqlr_sessions", float64(sessions))
+	w.family("usqlr_transactions_active", "gauge", "Transactions held open by sessions.")
+	w.sample("usqlr_transactions_active", float64(transactions))
+	w.family("usqlr_cursors_open", "gauge", "Open cursors.")
+	w.sample("usqlr_cursors_open", float64(cp.cursors.size()))
+}
+
+// writeRuntimeMetrics writes the Go runtime metrics, and the process start
+// time.
+func writeRuntimeMetrics(w *metricsWriter, started time.Time) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.family("go_info", "gauge", "Information about the Go environment.")
+	w.sample("go_info", 1, "version", runtime.Version())
+	w.family("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	w.sample("go_goroutines", float64(runtime.NumGoroutine()))
+	w.family("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	w.sample("go_memstats_alloc_bytes", float64(mem.Alloc))
+	w.family("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.")
+	w.sample("go_memstats_heap_inuse_bytes", float64(mem.HeapInuse))
+	w.family("go_memstats_heap_objects", "gauge", "Number of allocated objects.")
+	w.sample("go_memstats_heap_objects", float64(mem.HeapObjects))
+	w.family("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from the system.")
+	w.sample("go_memstats_sys_bytes", float64(mem.Sys))
+	w.family("go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	w.sample("go_gc_cycles_total", float64(mem.NumGC))
+	w.family("go_gc_pause_seconds_total", "counter", "Total time the world was stopped for GC.")
+	w.sample("go_gc_pause_seconds_total", time.Duration(mem.PauseTotalNs).Seconds())
+	w.family("process_start_time_seconds", "gauge", "Start time of the process since the epoch, in seconds.")
+	w.sample("process_start_time_seconds", float64(started.UnixNano())/1e9)
+}
+
+// handleMetrics handles scraping the metrics, in the Prometheus text
+// exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var mw metricsWriter
+	s.metrics.write(&mw)
+	s.pool.writeMetrics(&mw)
+	writeRuntimeMetrics(&mw, s.metrics.started)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(mw.Bytes())
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	m := newRequestMetrics("")
+	m.observe("tools/call", "execute_query", 20*time.Millisecond, false)
+	m.observe("tools/call", "execute_query", 3*time.Second, true)
+	m.observe("tools/list", "", time.Millisecond, false)
+	var w metricsWriter
+	m.write(&w)
+	out := w.String()
+	for _, line := range []string{
+		"# TYPE usqlr_mcp_requests_total counter\n",
+		`usqlr_mcp_requests_total{method="tools/call",tool="execute_query"} 2` + "\n",
+		`usqlr_mcp_request_errors_total{method="tools/call",tool="execute_query"} 1` + "\n",
+		`usqlr_mcp_request_duration_seconds_bucket{method="tools/call",tool="execute_query",le="0.025"} 1` + "\n",
+		`usqlr_mcp_request_duration_seconds_bucket{method="tools/call",tool="execute_query",le="5"} 2` + "\n",
+		`usqlr_mcp_request_duration_seconds_bucket{method="tools/list",tool="",le="+Inf"} 1` + "\n",
+		`usqlr_mcp_request_duration_seconds_sum{method="tools/call",tool="execute_query"} 3.02` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", line, out)
+		}
+	}
+	if m.path != defaultMetricsPath {
+		t.Errorf("expected the default metrics path, got: %q", m.path)
+	}
+
+	w.Reset()
+	w.sample("m", 1, "label", "a\"b\\c\nd")
+	if s := w.String(); s != `m{label="a\"b\\c\nd"} 1`+"\n" {
+		t.Errorf("expected the label value escaped, got: %q", s)
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	policies, err := policy.Parse(strings.NewReader("name: no-drop\nrules:\n  - action: deny\n    statements: [drop]\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	engine, _ := policy.NewEngine(policies, policy.Allow)
+	cp := NewConnectionPool(&Config{}, engine, nil)
+	defer cp.Close()
+	u, _ := dburl.Parse("postgres://localhost/db")
+	conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), policy: engine}
+	cp.connections["c"] = conn
+
+	ctx := context.Background()
+	if _, err := conn.ExecuteStatement(ctx, "UPDATE t SET a = 1"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := conn.ExecuteStatement(ctx, "DROP TABLE t"); err == nil {
+		t.Fatalf("expected the statement to be denied")
+	}
+	cp.sessions.Create(ctx)
+
+	var w metricsWriter
+	cp.writeMetrics(&w)
+	out := w.String()
+	for _, line := range []string{
+		"usqlr_connections 1\n",
+		`usqlr_connection_queries_total{connection="c"} 2` + "\n",
+		`usqlr_connection_query_errors_total{connection="c"} 1` + "\n",
+		`usqlr_connection_active_queries{connection="c"} 0` + "\n",
+		`usqlr_db_connections{connection="c",state="idle"} 1` + "\n",
+		"usqlr_sessions 1\n",
+		"usqlr_transactions_active 0\n",
+		"usqlr_cursors_open 0\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package nulls represents the SQL NULL values of query results as chosen by
+// the operator or client: as JSON null, as a sentinel string, or by omitting
+// the field of rows returned as objects.
+package nulls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NULL representations.
+const (
+	// Null represents NULL as JSON null.
+	Null = "null"
+	// Sentinel represents NULL as the format's sentinel string.
+	Sentinel = "sentinel"
+	// Omit omits the fields of NULL values from rows returned as objects,
+	// and represents them as JSON null in rows returned as arrays.
+	Omit = "omit"
+)
+
+// DefaultSentinel is the default sentinel string.
+const DefaultSentinel = "NULL"
+
+// Format is a representation of NULL values. A nil Format represents them as
+// JSON null.
+type Format struct {
+	Name     string
+	Sentinel string
+}
+
+// Parse returns the format with the representation name, and the sentinel
+// string (defaulting to DefaultSentinel), defaulting to JSON null.
+func Parse(name, sentinel string) (*Format, error) {
+	f := &Format{Name: strings.ToLower(name), Sentinel: sentinel}
+	switch f.Name {
+	case "":
+		f.Name = Null
+	case Null, Sentinel, Omit:
+	default:
+		return nil, fmt.Errorf("invalid NULL format %q: expected %s, %s or %s", name, Null, Sentinel, Omit)
+	}
+	if f.Sentinel == "" {
+		f.Sentinel = DefaultSentinel
+	}
+	return f, nil
+}
+
+// With returns the format overridden with the representation name and
+// sentinel, when not empty.
+func (f *Format) With(name, sentinel string) (*Format, error) {
+	switch {
+	case f == nil && name == "" && sentinel == "":
+		return nil, nil
+	case f == nil:
+		return Parse(name, sentinel)
+	}
+	g := *f
+	if name != "" {
+		g.Name = name
+	}
+	if sentinel != "" {
+		g.Sentinel = sentinel
+	}
+	return Parse(g.Name, g.Sentinel)
+}
+
+// Text returns the text of NULL values in rendered (e.g. CSV) results, the
+// sentinel when represented by it, or an empty string.
+func (f *Format) Text() string {
+	if f == nil || f.Name != Sentinel {
+		return ""
+	}
+	return f.Sentinel
+}
+
+// Row returns the row with its NULL values represented by the format,
+// copied when they are replaced.
+func (f *Format) Row(row []interface{}) []interface{} {
+	if f == nil || f.Name != Sentinel {
+		return row
+	}
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		if v == nil {
+			v = f.Sentinel
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// Rows returns the rows with their NULL values represented by the format.
+func (f *Format) Rows(rows [][]interface{}) [][]interface{} {
+	if f == nil || f.Name != Sentinel {
+		return rows
+	}
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = f.Row(row)
+	}
+	return values
+}
+
+// Object returns the row as an object keyed by column name, with its NULL
+// values represented by the format. Later columns replace earlier columns of
+// the same name.
+func (f *Format) Object(columns []string, row []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(row))
+	for i, v := range row {
+		switch {
+		case i >= len(columns):
+			continue
+		case v == nil && f != nil && f.Name == Omit:
+			continue
+		case v == nil && f != nil && f.Name == Sentinel:
+			v = f.Sentinel
+		}
+		m[columns[i]] = v
+	}
+	return m
+}
+
+// Objects returns the rows as objects keyed by column name.
+func (f *Format) Objects(columns []string, rows [][]interface{}) []map[string]interface{} {
+	objects := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		objects[i] = f.Object(columns, row)
+	}
+	return objects
+}
@@ -0,0 +1,58 @@
+package nulls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse("", "")
+	if err != nil || f.Name != Null || f.Sentinel != DefaultSentinel {
+		t.Errorf("expected null with the default sentinel, got: %v %v", f, err)
+	}
+	if _, err := Parse("empty", ""); err == nil {
+		t.Errorf("expected error")
+	}
+	var g *Format
+	if h, err := g.With("", ""); err != nil || h != nil {
+		t.Errorf("expected a nil format, got: %v %v", h, err)
+	}
+	f, _ = Parse(Sentinel, "N/A")
+	h, err := f.With("", "-")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case h.Name != Sentinel || h.Sentinel != "-" || h.Text() != "-":
+		t.Errorf("expected the - sentinel, got: %v", h)
+	case f.Sentinel != "N/A":
+		t.Errorf("expected the format to be unchanged, got: %s", f.Sentinel)
+	}
+}
+
+func TestRows(t *testing.T) {
+	rows := [][]interface{}{{int64(1), "", nil}}
+	tests := []struct {
+		name    string
+		rows    [][]interface{}
+		objects []map[string]interface{}
+	}{
+		{Null, rows, []map[string]interface{}{{"id": int64(1), "name": "", "note": nil}}},
+		{Sentinel, [][]interface{}{{int64(1), "", "NULL"}}, []map[string]interface{}{{"id": int64(1), "name": "", "note": "NULL"}}},
+		{Omit, rows, []map[string]interface{}{{"id": int64(1), "name": ""}}},
+	}
+	for _, test := range tests {
+		f, err := Parse(test.name, "")
+		if err != nil {
+			t.Fatalf("%s expected no error, got: %v", test.name, err)
+		}
+		if v := f.Rows(rows); !reflect.DeepEqual(v, test.rows) {
+			t.Errorf("%s expected rows %v, got: %v", test.name, test.rows, v)
+		}
+		if v := f.Objects([]string{"id", "name", "note"}, rows); !reflect.DeepEqual(v, test.objects) {
+			t.Errorf("%s expected objects %v, got: %v", test.name, test.objects, v)
+		}
+	}
+	if rows[0][2] != nil {
+		t.Errorf("expected the rows to be unchanged, got: %v", rows)
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth endpoints, served when OAuth is enabled.
+const (
+	resourceMetadataPath = "/.well-known/oauth-protected-resource"
+	authServerMetadata   = "/.well-known/oauth-authorization-server"
+	registrationPath     = "/register"
+)
+
+// maxRegistrationBytes limits the size of client registration requests and
+// responses passed through to the authorization server.
+const maxRegistrationBytes = 64 << 10
+
+// oauthProvider authorizes requests with access tokens issued by an OIDC
+// issuer, discovered from its metadata once a token is first verified.
+type oauthProvider struct {
+	config OAuthConfig
+	client *http.Client
+
+	mu         sync.Mutex
+	metadata   map[string]interface{}
+	verifier   *jwtVerifier
+	discovered time.Time
+}
+
+// newOAuthProvider creates the provider of the configured issuer.
+func newOAuthProvider(config OAuthConfig) (*oauthProvider, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("an issuer is required")
+	}
+	if config.ResourceURL != "" {
+		if u, err := url.Parse(config.ResourceURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid resource URL %q", config.ResourceURL)
+		}
+	}
+	if config.EnableRegistration && config.RegistrationTokenEnv != "" && os.Getenv(config.RegistrationTokenEnv) == "" {
+		return nil, fmt.Errorf("a registration token is required in $%s", config.RegistrationTokenEnv)
+	}
+	return &oauthProvider{config: config, client: &http.Client{Timeout: jwksTimeout}}, nil
+}
+
+// discover returns the issuer's metadata and the verifier of its tokens,
+// fetching the metadata when it was not yet, at most once per
+// jwksMinRefresh.
+func (o *oauthProvider) discover(ctx context.Context) (map[string]interface{}, *jwtVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.verifier != nil {
+		return o.metadata, o.verifier, nil
+	}
+	if time.Since(o.discovered) < jwksMinRefresh {
+		return nil, nil, fmt.Errorf("issuer %s is not available", o.config.Issuer)
+	}
+	o.discovered = time.Now()
+
+	metadata, err := o.fetchMetadata(ctx)
+	if err != nil {
+		authLog.Error("failed to discover OIDC issuer", "issuer", o.config.Issuer, "error", err)
+		return nil, nil, fmt.Errorf("issuer %s is not available", o.config.Issuer)
+	}
+	jwksURL, _ := metadata["jwks_uri"].(string)
+	verifier, err := newJWTVerifier(JWTConfig{
+		JWKSURL:        jwksURL,
+		Issuer:         o.config.Issuer,
+		Audience:       o.config.Audience,
+		PrincipalClaim: o.config.PrincipalClaim,
+		ScopesClaim:    o.config.ScopesClaim,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	o.metadata, o.verifier = metadata, verifier
+	return metadata, verifier, nil
+}
+
+// fetchMetadata fetches the issuer's OpenID configuration.
+func (o *oauthProvider) fetchMetadata(ctx context.Context) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(o.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	var metadata map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	switch issuer, _ := metadata["issuer"].(string); {
+	case issuer != o.config.Issuer:
+		return nil, fmt.Errorf("metadata is of issuer %q", issuer)
+	case metadata["jwks_uri"] == nil:
+		return nil, errors.New("metadata has no jwks_uri")
+	}
+	return metadata, nil
+}
+
+// verify verifies the access token, returning the principal of its claims.
+func (o *oauthProvider) verify(ctx context.Context, token string) (*principal, error) {
+	_, verifier, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return verifier.verify(ctx, token)
+}
+
+// baseURL returns the public URL of the server, from the resource URL when
+// configured, or the request.
+func (o *oauthProvider) baseURL(r *http.Request) string {
+	if o.config.ResourceURL != "" {
+		u, _ := url.Parse(o.config.ResourceURL)
+		return u.Scheme + "://" + u.Host
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// challenge returns the WWW-Authenticate challenge of unauthorized requests,
+// pointing clients at the resource metadata.
+func (o *oauthProvider) challenge(r *http.Request, params string) string {
+	challenge := fmt.Sprintf("Bearer resource_metadata=%q", o.baseURL(r)+resourceMetadataPath)
+	if params != "" {
+		challenge += ", " + params
+	}
+	return challenge
+}
+
+// public reports whether the request is to an OAuth endpoint, served
+// without authentication.
+func (o *oauthProvider) public(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/.well-known/") || (o.config.EnableRegistration && r.URL.Path == registrationPath)
+}
+
+// handleResourceMetadata handles requests for the protected resource
+// metadata (RFC 9728) of the MCP endpoint, naming the authorization server:
+// the issuer, or the server itself when passing client registration through.
+func (s *Server) handleResourceMetadata(w http.ResponseWriter, r *http.Request) {
+	base := s.oauth.baseURL(r)
+	resource := s.oauth.config.ResourceURL
+	if resource == "" {
+		resource = base + "/mcp"
+	}
+	server := s.oauth.config.Issuer
+	if s.oauth.config.EnableRegistration {
+		server = base
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resource":                 resource,
+		"authorization_servers":    []string{server},
+		"scopes_supported":         s.oauth.config.Scopes,
+		"bearer_methods_supported": []string{"header"},
+		"resource_name":            "usqlr",
+	})
+}
+
+// handleAuthServerMetadata handles requests for the authorization server
+// metadata (RFC 8414) when passing client registration through: the
+// issuer's metadata, with the server's registration endpoint.
+func (s *Server) handleAuthServerMetadata(w http.ResponseWriter, r *http.Request) {
+	metadata, _, err := s.oauth.discover(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	mirrored := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		mirrored[k] = v
+	}
+	mirrored["registration_endpoint"] = s.oauth.baseURL(r) + registrationPath
+	writeJSON(w, http.StatusOK, mirrored)
+}
+
+// handleRegister handles dynamic client registration (RFC 7591) requests,
+// passing them through to the registration endpoint configured or
+// advertised by the issuer, authenticated with the initial access token,
+// when configured.
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	endpoint := s.oauth.config.RegistrationURL
+	if endpoint == "" {
+		metadata, _, err := s.oauth.discover(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		endpoint, _ = metadata["registration_endpoint"].(string)
+	}
+	if endpoint == "" {
+		writeError(w, http.StatusNotImplemented, errors.New("issuer does not support client registration"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRegistrationBytes+1))
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case len(body) > maxRegistrationBytes:
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("registration request is too large"))
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if env := s.oauth.config.RegistrationTokenEnv; env != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(env))
+	}
+	res, err := s.oauth.client.Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("client registration failed: %w", err))
+		return
+	}
+	defer res.Body.Close()
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxRegistrationBytes))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("client registration failed: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(res.StatusCode)
+	w.Write(buf)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestOAuth(t *testing.T) {
+	t.Setenv("USQLR_TEST_REGISTRATION_TOKEN", "initial")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registration string
+	idp := httptest.NewServer(http.NewServeMux())
+	defer idp.Close()
+	mux := idp.Config.Handler.(*http.ServeMux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"registration_endpoint":  idp.URL + "/clients",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := io.ReadAll(r.Body)
+		registration = r.Header.Get("Authorization") + " " + string(buf)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"client_id":"c1"}`))
+	})
+
+	oauth, err := newOAuthProvider(OAuthConfig{
+		Issuer:               idp.URL,
+		Audience:             "https://usqlr.example.com/mcp",
+		ResourceURL:          "https://usqlr.example.com/mcp",
+		Scopes:               []string{ScopeQuery},
+		PrincipalClaim:       "sub",
+		ScopesClaim:          "scope",
+		EnableRegistration:   true,
+		RegistrationTokenEnv: "USQLR_TEST_REGISTRATION_TOKEN",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	s := &Server{oauth: oauth}
+	s.conf.Store(&Config{})
+	routes := http.NewServeMux()
+	routes.HandleFunc("GET "+resourceMetadataPath, s.handleResourceMetadata)
+	routes.HandleFunc("GET "+authServerMetadata, s.handleAuthServerMetadata)
+	routes.HandleFunc("POST "+registrationPath, s.handleRegister)
+	var principal string
+	routes.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		principal = policy.PrincipalFrom(r.Context())
+	})
+	handler := s.authMiddleware(routes)
+
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	sign := func(scope, aud string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"sub":   "alice",
+			"iss":   idp.URL,
+			"aud":   aud,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": scope,
+		})
+		token.Header["kid"] = "k1"
+		s, _ := token.SignedString(key)
+		return s
+	}
+
+	// unauthorized requests are challenged with the resource metadata
+	w := serve(http.MethodPost, "/mcp", "", "")
+	if exp := `Bearer resource_metadata="https://usqlr.example.com/.well-known/oauth-protected-resource"`; w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != exp {
+		t.Errorf("expected a challenge, got: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	w = serve(http.MethodGet, resourceMetadataPath, "", "")
+	var metadata map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&metadata)
+	if metadata["resource"] != "https://usqlr.example.com/mcp" || metadata["authorization_servers"].([]interface{})[0] != "https://usqlr.example.com" {
+		t.Errorf("expected the resource metadata, got: %v", metadata)
+	}
+	w = serve(http.MethodGet, authServerMetadata, "", "")
+	json.NewDecoder(w.Body).Decode(&metadata)
+	if metadata["registration_endpoint"] != "https://usqlr.example.com/register" || metadata["token_endpoint"] != idp.URL+"/token" {
+		t.Errorf("expected the issuer's metadata with the registration endpoint, got: %v", metadata)
+	}
+
+	// access tokens are verified with the issuer's keys
+	if w := serve(http.MethodPost, "/mcp", sign("query", "https://usqlr.example.com/mcp"), ""); w.Code != http.StatusOK || principal != "alice" {
+		t.Errorf("expected alice to be authorized, got: %d %q", w.Code, principal)
+	}
+	if w := serve(http.MethodPost, "/mcp", sign("query", "https://other.example.com"), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a token of another audience to be unauthorized, got: %d", w.Code)
+	}
+	w = serve(http.MethodPost, "/mcp", sign("openid", "https://usqlr.example.com/mcp"), "")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="insufficient_scope", scope="query"`) {
+		t.Errorf("expected an insufficient scope challenge, got: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// client registration is passed through with the initial access token
+	w = serve(http.MethodPost, registrationPath, "", `{"client_name":"inspector"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), "c1") {
+		t.Errorf("expected the registered client, got: %d %s", w.Code, w.Body.String())
+	}
+	if exp := `Bearer initial {"client_name":"inspector"}`; registration != exp {
+		t.Errorf("expected %q, got: %q", exp, registration)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// QueryPage executes a SQL query on the specified connection, or a member of
+// the specified group, returning at most maxRows rows. When more rows remain, they are held open server-side
+// as a cursor, and the result carries a continuation token from which the
+// next page is fetched with ContinueQuery.
+//
+// A maxRows of 0 uses the server's default row cap, and maxRows is capped at
+// it. When there is no row cap, all rows of all result sets are returned,
+// truncated at the limits (bounded by the server's result caps). Paginated
+// queries only return their first result set, with pages ending early once
+// they reach the bytes limit. Results of read-only queries fitting in a
+// single page are cached.
+func (cp *ConnectionPool) QueryPage(ctx context.Context, id, query string, maxRows int, limits ResultLimits, args ...interface{}) (*QueryResult, error) {
+	cp.mu.RLock()
+	conn, exists := cp.route(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("connection with ID %s not found", id)
+	}
+
+	limit := cp.rowCap(maxRows)
+	if limit == 0 {
+		return conn.executeQuery(ctx, limits, cp.spill, query, args...)
+	}
+	if conn.transaction(ctx) != nil {
+		// the rows of a transaction's query are not held open across
+		// requests, so they are truncated at the page
+		limits.Rows = limit
+		return conn.executeQuery(ctx, limits, nil, query, args...)
+	}
+
+	return conn.cached(ctx, query, args, limit, limits, func() (*QueryResult, error) {
+		cursor, err := cp.cursors.Open(ctx, conn, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return cp.page(ctx, cursor, limit, limits)
+	})
+}
+
+// ContinueQuery fetches the next page of at most maxRows rows of a query
+// executed with QueryPage on the specified connection or group.
+func (cp *ConnectionPool) ContinueQuery(ctx context.Context, id, token string, maxRows int, limits ResultLimits) (*QueryResult, error) {
+	cursor, ok := cp.cursors.get(token)
+	if !ok || (cursor.ConnectionID != cp.Resolve(id) && !cp.inGroup(id, cursor.ConnectionID)) {
+		return nil, fmt.Errorf("continuation token %s is invalid or has expired", token)
+	}
+	return cp.page(ctx, cursor, cp.rowCap(maxRows), limits)
+}
+
+// page fetches a page of limit rows from the cursor, up to the bytes limit,
+// setting the result's continuation token when more rows remain.
+func (cp *ConnectionPool) page(ctx context.Context, cursor *Cursor, limit int, limits ResultLimits) (*QueryResult, error) {
+	p, err := cp.cursors.fetch(ctx, cursor.ID, limit, limits)
+	if err != nil {
+		return nil, err
+	}
+	result := &QueryResult{
+		Columns:     cursor.Columns,
+		ColumnTypes: cursor.ColumnTypes,
+		JSONTypes:   cursor.JSONTypes,
+		Rows:        p.Rows,
+		Provenance:  cursor.Provenance,
+		Risk:        policy.AssessRisk(cursor.query),
+		PII:         p.PII,
+	}
+	if err := cursor.conn.postResult(ctx, cursor.query, result); err != nil {
+		return nil, err
+	}
+	if !p.Done {
+		result.ContinuationToken = cursor.ID
+	}
+	return result, nil
+}
+
+// rowCap returns the number of rows to return in a page, given the requested
+// maximum.
+func (cp *ConnectionPool) rowCap(maxRows int) int {
+	limit := cp.config().Server.MaxRows
+	if maxRows > 0 && (limit == 0 || maxRows < limit) {
+		return maxRows
+	}
+	return limit
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// maxPanicRecords is the maximum number of panic records kept per
+// connection.
+const maxPanicRecords = 10
+
+// PanicError is returned when a database driver panics while executing a
+// query or statement.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error satisfies the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("driver panic: %v", e.Value)
+}
+
+// PanicRecord contains diagnostics for a recovered driver panic.
+type PanicRecord struct {
+	Time  time.Time `json:"time"`
+	Query string    `json:"query"`
+	Value string    `json:"value"`
+	Stack string    `json:"stack"`
+}
+
+// recoverPanic recovers from a driver panic, storing it as a *PanicError in
+// err and marking the connection as suspect. It must be deferred directly.
+func (conn *Connection) recoverPanic(query string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	perr := &PanicError{Value: v, Stack: debug.Stack()}
+	poolLog.Error("recovered driver panic", "connection", conn.ID, "panic", v, "stack", string(perr.Stack))
+
+	conn.diagMu.Lock()
+	conn.suspect = true
+	conn.panics = append(conn.panics, PanicRecord{
+		Time:  time.Now(),
+		Query: query,
+		Value: fmt.Sprint(v),
+		Stack: string(perr.Stack),
+	})
+	if len(conn.panics) > maxPanicRecords {
+		conn.panics = conn.panics[len(conn.panics)-maxPanicRecords:]
+	}
+	conn.diagMu.Unlock()
+
+	*err = perr
+}
+
+// Diagnostics returns whether the connection is suspect and the recorded
+// driver panics.
+func (conn *Connection) Diagnostics() (bool, []PanicRecord) {
+	conn.diagMu.Lock()
+	defer conn.diagMu.Unlock()
+	return conn.suspect, append([]PanicRecord(nil), conn.panics...)
+}
+
+// ClearSuspect clears the connection's suspect flag and panic records.
+func (conn *Connection) ClearSuspect() {
+	conn.diagMu.Lock()
+	defer conn.diagMu.Unlock()
+	conn.suspect, conn.panics = false, nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	u, _ := dburl.Parse("sqlite3:panicky.db")
+	conn := &Connection{ID: "panicky", URL: u, DB: sql.OpenDB(panicConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+
+	_, err := conn.ExecuteQuery(context.Background(), "SELECT 1")
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *PanicError, got: %v", err)
+	}
+	if perr.Value != "boom" {
+		t.Errorf("expected panic value %q, got: %v", "boom", perr.Value)
+	}
+	if _, err := conn.ExecuteStatement(context.Background(), "DELETE FROM t"); !errors.As(err, &perr) {
+		t.Fatalf("expected *PanicError, got: %v", err)
+	}
+
+	suspect, panics := conn.Diagnostics()
+	if !suspect {
+		t.Errorf("expected connection to be marked suspect")
+	}
+	if len(panics) != 2 || panics[0].Query != "SELECT 1" {
+		t.Errorf("expected 2 panic records starting with %q, got: %v", "SELECT 1", panics)
+	}
+
+	conn.ClearSuspect()
+	if suspect, panics := conn.Diagnostics(); suspect || len(panics) != 0 {
+		t.Errorf("expected diagnostics to be cleared, got: %t %v", suspect, panics)
+	}
+}
+
+// panicConnector is a driver connector whose connections panic when used.
+type panicConnector struct{}
+
+func (panicConnector) Connect(context.Context) (driver.Conn, error) { return panicConn{}, nil }
+func (panicConnector) Driver() driver.Driver                        { return nil }
+
+type panicConn struct{}
+
+func (panicConn) Prepare(string) (driver.Stmt, error) { panic("boom") }
+func (panicConn) Close() error                        { return nil }
+func (panicConn) Begin() (driver.Tx, error)           { panic("boom") }
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// placeholders are the positional placeholder styles of drivers not using
+// '?'.
+var placeholders = map[string]func(int) string{
+	"postgres":  func(n int) string { return "$" + strconv.Itoa(n) },
+	"pgx":       func(n int) string { return "$" + strconv.Itoa(n) },
+	"ql":        func(n int) string { return "$" + strconv.Itoa(n) },
+	"ramsql":    func(n int) string { return "$" + strconv.Itoa(n) },
+	"godror":    func(n int) string { return ":" + strconv.Itoa(n) },
+	"oracle":    func(n int) string { return ":" + strconv.Itoa(n) },
+	"sqlserver": func(n int) string { return "@p" + strconv.Itoa(n) },
+	"azuresql":  func(n int) string { return "@p" + strconv.Itoa(n) },
+	"spanner":   func(n int) string { return "@p" + strconv.Itoa(n) },
+}
+
+// bindNamed rewrites named parameters (:name, @name) in query to the
+// driver's positional placeholders ($1, ?, :1, @p1), when args are all
+// sql.NamedArg. Other args are returned unchanged.
+func bindNamed(u *dburl.URL, query string, args []interface{}) (string, []interface{}, error) {
+	named := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		if v, ok := arg.(sql.NamedArg); ok {
+			named[v.Name] = v.Value
+		}
+	}
+	switch {
+	case len(named) == 0:
+		return query, args, nil
+	case len(named) != len(args):
+		return "", nil, fmt.Errorf("named and positional arguments cannot be mixed")
+	}
+
+	placeholder, numbered := placeholders[u.Driver], true
+	if placeholder == nil {
+		placeholder, numbered = func(int) string { return "?" }, false
+	}
+
+	var sb strings.Builder
+	var bound []interface{}
+	positions := make(map[string]int)
+	for _, t := range sqlscan.Scan(query) {
+		name := strings.TrimLeft(t.Text, ":@")
+		if t.Kind != sqlscan.Placeholder || name == t.Text || isDigits(name) {
+			sb.WriteString(t.Text)
+			continue
+		}
+		v, ok := named[name]
+		if !ok {
+			return "", nil, fmt.Errorf("missing value for parameter %s", name)
+		}
+		// numbered placeholders can be reused for repeated parameters
+		n, ok := positions[name]
+		if !ok || !numbered {
+			bound = append(bound, v)
+			n = len(bound)
+			positions[name] = n
+		}
+		sb.WriteString(placeholder(n))
+	}
+	// named args no placeholder refers to are likely misspelled
+	for _, arg := range args {
+		name := arg.(sql.NamedArg).Name
+		if _, ok := positions[name]; !ok {
+			return "", nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	return sb.String(), bound, nil
+}
+
+// isDigits reports whether s consists only of ASCII digits.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestBindNamed(t *testing.T) {
+	id, both := []interface{}{sql.Named("id", 1)}, []interface{}{sql.Named("id", 1), sql.Named("since", "2024-01-01")}
+	tests := []struct {
+		dsn   string
+		query string
+		named []interface{}
+		exp   string
+		args  []interface{}
+	}{
+		{"postgres://localhost/db", "SELECT * FROM t WHERE id = :id AND a > @since OR b = :id", both, "SELECT * FROM t WHERE id = $1 AND a > $2 OR b = $1", []interface{}{1, "2024-01-01"}},
+		{"mysql://localhost/db", "SELECT * FROM t WHERE id = :id AND a > @since OR b = :id", both, "SELECT * FROM t WHERE id = ? AND a > ? OR b = ?", []interface{}{1, "2024-01-01", 1}},
+		{"sqlserver://localhost/db", "SELECT * FROM t WHERE id = :id", id, "SELECT * FROM t WHERE id = @p1", []interface{}{1}},
+		{"oracle://localhost/db", "SELECT * FROM t WHERE id = :id", id, "SELECT * FROM t WHERE id = :1", []interface{}{1}},
+		{"postgres://localhost/db", "SELECT ':id', a::text, @@x FROM t WHERE id = :id", id, "SELECT ':id', a::text, @@x FROM t WHERE id = $1", []interface{}{1}},
+	}
+	for _, test := range tests {
+		u, err := dburl.Parse(test.dsn)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		query, bound, err := bindNamed(u, test.query, test.named)
+		if err != nil {
+			t.Fatalf("%s expected no error, got: %v", test.dsn, err)
+		}
+		if query != test.exp {
+			t.Errorf("%s expected %q, got: %q", test.dsn, test.exp, query)
+		}
+		if !reflect.DeepEqual(bound, test.args) {
+			t.Errorf("%s expected args %v, got: %v", test.dsn, test.args, bound)
+		}
+	}
+}
+
+func TestBindNamedErrors(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	if _, _, err := bindNamed(u, "SELECT :missing", []interface{}{sql.Named("id", 1)}); err == nil {
+		t.Errorf("expected error for missing parameter")
+	}
+	if _, _, err := bindNamed(u, "SELECT :id, $2", []interface{}{sql.Named("id", 1), 2}); err == nil {
+		t.Errorf("expected error for mixed arguments")
+	}
+	if _, _, err := bindNamed(u, "SELECT :id", []interface{}{sql.Named("id", 1), sql.Named("idd", 2)}); err == nil || err.Error() != `unknown parameter "idd"` {
+		t.Errorf("expected error for unknown parameter, got: %v", err)
+	}
+	query, args, err := bindNamed(u, "SELECT $1", []interface{}{1})
+	if err != nil || query != "SELECT $1" || len(args) != 1 {
+		t.Errorf("expected positional arguments to be unchanged, got: %q %v %v", query, args, err)
+	}
+}
+
+func TestValidateProcedureName(t *testing.T) {
+	for _, name := range []string{"proc", "dbo.proc", `"My Schema"."proc"`, "pkg.sub.proc"} {
+		if err := validateProcedureName(name); err != nil {
+			t.Errorf("%q expected no error, got: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "proc()", "a;drop table t", "a.", ".a", "a b"} {
+		if err := validateProcedureName(name); err == nil {
+			t.Errorf("%q expected error", name)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+// Package parquet writes tabular data as Apache Parquet files.
+//
+// Columns are mapped from their database type names (or, when the type is
+// unknown, their values) to Parquet types: integers as INT64, floating point
+// numbers as DOUBLE, booleans as BOOLEAN, dates as DATE, timestamps as UTC
+// TIMESTAMP (microseconds), JSON as JSON, binary data as BYTE_ARRAY, and all
+// other values, including DECIMAL, as STRING. All columns are optional, with
+// NULL values stored as nulls.
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// ContentType is the media type of Parquet files.
+const ContentType = "application/vnd.apache.parquet"
+
+// rowGroupRows is the number of rows in each row group.
+const rowGroupRows = 65536
+
+// magic is the magic number at the start and end of Parquet files.
+const magic = "PAR1"
+
+// Column is a column of a Parquet file, with its Parquet physical and
+// logical types.
+type Column struct {
+	Name         string `json:"name"`
+	PhysicalType string `json:"physical_type"`
+	LogicalType  string `json:"logical_type,omitempty"`
+}
+
+// kind is the kind of values of a column.
+type kind int
+
+// Column kinds.
+const (
+	kindString kind = iota
+	kindBytes
+	kindJSON
+	kindInt64
+	kindDouble
+	kindBoolean
+	kindDate
+	kindTimestamp
+)
+
+// Parquet Thrift enum values.
+const (
+	typeBoolean        = 0
+	typeInt32          = 1
+	typeInt64          = 2
+	typeDouble         = 5
+	typeByteArray      = 6
+	repetitionOptional = 1
+	convertedUTF8      = 0
+	convertedDate      = 6
+	convertedTsMicros  = 10
+	convertedInt64     = 18
+	convertedJSON      = 19
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecSnappy        = 1
+	pageData           = 0
+)
+
+// timeLayouts are the accepted formats of timestamps scanned as strings.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Schema returns the Parquet columns the columns of the rows are written as.
+// The database type names are used to determine the column types.
+func Schema(columns, types []string, rows [][]interface{}) []Column {
+	names, kinds := columnNames(columns), columnKinds(len(columns), types, rows)
+	schema := make([]Column, len(columns))
+	for i, k := range kinds {
+		physical, logical := k.types()
+		schema[i] = Column{Name: names[i], PhysicalType: physical, LogicalType: logical}
+	}
+	return schema
+}
+
+// Write writes the rows of the columns as a Parquet file, with snappy
+// compressed column chunks. The database type names are used to determine
+// the column types.
+func Write(w io.Writer, columns, types []string, rows [][]interface{}) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("parquet files must have at least one column")
+	}
+	names, kinds := columnNames(columns), columnKinds(len(columns), types, rows)
+	cw := &countingWriter{w: w}
+	if _, err := io.WriteString(cw, magic); err != nil {
+		return err
+	}
+	var groups []rowGroup
+	for start := 0; start < len(rows); start += rowGroupRows {
+		group, err := writeRowGroup(cw, names, kinds, rows[start:min(start+rowGroupRows, len(rows))])
+		if err != nil {
+			return err
+		}
+		groups = append(groups, group)
+	}
+	footer := fileMetaData(names, kinds, groups, len(rows))
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, magic...)
+	_, err := cw.Write(footer)
+	return err
+}
+
+// rowGroup is a row group written to a file.
+type rowGroup struct {
+	rows   int
+	chunks []columnChunk
+}
+
+// columnChunk is a column chunk of a row group, of a single data page.
+type columnChunk struct {
+	offset       int64
+	compressed   int64
+	uncompressed int64
+}
+
+// writeRowGroup writes the rows as a row group.
+func writeRowGroup(cw *countingWriter, names []string, kinds []kind, rows [][]interface{}) (rowGroup, error) {
+	group := rowGroup{rows: len(rows), chunks: make([]columnChunk, len(names))}
+	for i, k := range kinds {
+		page := encodeColumn(k, rows, i)
+		data := snappy.Encode(nil, page)
+		var header compact
+		header.fields(func() {
+			header.i32(1, pageData)
+			header.i32(2, int32(len(page)))
+			header.i32(3, int32(len(data)))
+			header.strct(5, func() {
+				header.i32(1, int32(len(rows)))
+				header.i32(2, encodingPlain)
+				header.i32(3, encodingRLE)
+				header.i32(4, encodingRLE)
+			})
+		})
+		group.chunks[i] = columnChunk{
+			offset:       cw.n,
+			compressed:   int64(len(header.buf) + len(data)),
+			uncompressed: int64(len(header.buf) + len(page)),
+		}
+		if _, err := cw.Write(header.buf); err != nil {
+			return rowGroup{}, err
+		}
+		if _, err := cw.Write(data); err != nil {
+			return rowGroup{}, err
+		}
+	}
+	return group, nil
+}
+
+// encodeColumn encodes the values of column i of the rows as a data page of
+// definition levels followed by the PLAIN encoded non-null values.
+func encodeColumn(k kind, rows [][]interface{}, i int) []byte {
+	levels := make([]bool, len(rows))
+	var values []byte
+	var bits, nbits int
+	for j, row := range rows {
+		v := row[i]
+		if v == nil {
+			continue
+		}
+		levels[j] = true
+		switch k {
+		case kindInt64:
+			n, _ := toInt64(v)
+			values = binary.LittleEndian.AppendUint64(values, uint64(n))
+		case kindDouble:
+			f, _ := toFloat64(v)
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(f))
+		case kindBoolean:
+			if b, _ := toBool(v); b {
+				bits |= 1 << (nbits % 8)
+			}
+			if nbits++; nbits%8 == 0 {
+				values, bits = append(values, byte(bits)), 0
+			}
+		case kindDate:
+			t, _ := toTime(v)
+			days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+			values = binary.LittleEndian.AppendUint32(values, uint32(int32(days)))
+		case kindTimestamp:
+			t, _ := toTime(v)
+			values = binary.LittleEndian.AppendUint64(values, uint64(t.UnixMicro()))
+		default:
+			s := toString(v)
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(s)))
+			values = append(values, s...)
+		}
+	}
+	if nbits%8 != 0 {
+		values = append(values, byte(bits))
+	}
+	defs := encodeLevels(levels)
+	page := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(defs)+len(values)), uint32(len(defs)))
+	page = append(page, defs...)
+	return append(page, values...)
+}
+
+// encodeLevels encodes definition levels (of bit width 1) as runs of the
+// RLE/bit-packing hybrid encoding.
+func encodeLevels(levels []bool) []byte {
+	var buf []byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		if levels[i] {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		i = j
+	}
+	return buf
+}
+
+// fileMetaData encodes the file metadata of the row groups written.
+func fileMetaData(names []string, kinds []kind, groups []rowGroup, rows int) []byte {
+	var c compact
+	c.fields(func() {
+		c.i32(1, 1)
+		c.structs(2, len(names)+1, func(i int) {
+			if i == 0 {
+				c.string(4, "schema")
+				c.i32(5, int32(len(names)))
+				return
+			}
+			kinds[i-1].schemaElement(&c, names[i-1])
+		})
+		c.i64(3, int64(rows))
+		c.structs(4, len(groups), func(i int) {
+			group := groups[i]
+			var total int64
+			c.structs(1, len(group.chunks), func(j int) {
+				chunk := group.chunks[j]
+				total += chunk.uncompressed
+				c.i64(2, chunk.offset)
+				c.strct(3, func() {
+					c.i32(1, kinds[j].physical())
+					c.i32s(2, encodingPlain, encodingRLE)
+					c.strings(3, names[j])
+					c.i32(4, codecSnappy)
+					c.i64(5, int64(group.rows))
+					c.i64(6, chunk.uncompressed)
+					c.i64(7, chunk.compressed)
+					c.i64(9, chunk.offset)
+				})
+			})
+			c.i64(2, total)
+			c.i64(3, int64(group.rows))
+		})
+		c.string(6, "usqlr")
+	})
+	return c.buf
+}
+
+// schemaElement writes the schema element of a column of the kind.
+func (k kind) schemaElement(c *compact, name string) {
+	c.i32(1, k.physical())
+	c.i32(3, repetitionOptional)
+	c.string(4, name)
+	switch k {
+	case kindString:
+		c.i32(6, convertedUTF8)
+		c.strct(10, func() { c.strct(1, func() {}) })
+	case kindJSON:
+		c.i32(6, convertedJSON)
+		c.strct(10, func() { c.strct(12, func() {}) })
+	case kindDate:
+		c.i32(6, convertedDate)
+		c.strct(10, func() { c.strct(6, func() {}) })
+	case kindTimestamp:
+		c.i32(6, convertedTsMicros)
+		c.strct(10, func() {
+			c.strct(8, func() {
+				c.bool(1, true)
+				c.strct(2, func() { c.strct(2, func() {}) })
+			})
+		})
+	case kindInt64:
+		c.i32(6, convertedInt64)
+		c.strct(10, func() {
+			c.strct(10, func() {
+				c.byte(1, 64)
+				c.bool(2, true)
+			})
+		})
+	}
+}
+
+// physical returns the Parquet physical type of the kind.
+func (k kind) physical() int32 {
+	switch k {
+	case kindInt64, kindTimestamp:
+		return typeInt64
+	case kindDouble:
+		return typeDouble
+	case kindBoolean:
+		return typeBoolean
+	case kindDate:
+		return typeInt32
+	}
+	return typeByteArray
+}
+
+// types returns the names of the Parquet physical and logical types of the
+// kind.
+func (k kind) types() (string, string) {
+	switch k {
+	case kindString:
+		return "BYTE_ARRAY", "STRING"
+	case kindJSON:
+		return "BYTE_ARRAY", "JSON"
+	case kindInt64:
+		return "INT64", "INT(64, true)"
+	case kindDouble:
+		return "DOUBLE", ""
+	case kindBoolean:
+		return "BOOLEAN", ""
+	case kindDate:
+		return "INT32", "DATE"
+	case kindTimestamp:
+		return "INT64", "TIMESTAMP(MICROS, true)"
+	}
+	return "BYTE_ARRAY", ""
+}
+
+// columnNames returns unique, non-empty names for the columns.
+func columnNames(columns []string) []string {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool)
+	for i, name := range columns {
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		for n := 2; seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", columns[i], n)
+		}
+		seen[name], names[i] = true, name
+	}
+	return names
+}
+
+// columnKinds returns the kinds of the columns, from their database type
+// names, or their values when the type is unknown. Columns with values that
+// cannot be converted to the kind are written as strings.
+func columnKinds(n int, types []string, rows [][]interface{}) []kind {
+	kinds := make([]kind, n)
+	for i := range kinds {
+		var typ string
+		if i < len(types) {
+			typ = strings.ToUpper(types[i])
+		}
+		k, ok := kindOfType(typ)
+		if !ok {
+			k = kindOfValues(rows, i)
+		}
+		kinds[i] = checkKind(k, rows, i)
+	}
+	return kinds
+}
+
+// kindOfType returns the kind of a database type name, if known.
+func kindOfType(typ string) (kind, bool) {
+	switch {
+	case typ == "":
+		return kindString, false
+	case strings.Contains(typ, "INTERVAL"):
+		return kindString, true
+	case strings.Contains(typ, "JSON"):
+		return kindJSON, true
+	case typ == "BOOL" || typ == "BOOLEAN":
+		return kindBoolean, true
+	case strings.Contains(typ, "INT") && !strings.Contains(typ, "POINT"), strings.Contains(typ, "SERIAL"):
+		return kindInt64, true
+	case strings.Contains(typ, "FLOAT"), strings.Contains(typ, "DOUBLE"), typ == "REAL":
+		return kindDouble, true
+	case typ == "DATE":
+		return kindDate, true
+	case strings.Contains(typ, "TIMESTAMP"), strings.Contains(typ, "DATETIME"):
+		return kindTimestamp, true
+	case strings.HasPrefix(typ, "TIME"):
+		return kindString, true
+	case strings.Contains(typ, "BLOB"), strings.Contains(typ, "BINARY"), typ == "BYTEA", typ == "IMAGE", typ == "RAW":
+		return kindBytes, true
+	case strings.Contains(typ, "CHAR"), strings.Contains(typ, "TEXT"), strings.Contains(typ, "DECIMAL"),
+		strings.Contains(typ, "NUMERIC"), strings.Contains(typ, "NUMBER"), strings.Contains(typ, "MONEY"):
+		return kindString, true
+	}
+	return kindString, false
+}
+
+// kindOfValues returns the kind of the values of column i of the rows.
+func kindOfValues(rows [][]interface{}, i int) kind {
+	k, found := kindString, false
+	for _, row := range rows {
+		var vk kind
+		switch row[i].(type) {
+		case nil:
+			continue
+		case int64, int, int32, int16, int8:
+			vk = kindInt64
+		case float64, float32:
+			vk = kindDouble
+		case bool:
+			vk = kindBoolean
+		case time.Time:
+			vk = kindTimestamp
+		default:
+			return kindString
+		}
+		switch {
+		case !found:
+			k, found = vk, true
+		case k == kindInt64 && vk == kindDouble, k == kindDouble && vk == kindInt64:
+			k = kindDouble
+		case k != vk:
+			return kindString
+		}
+	}
+	return k
+}
+
+// checkKind returns the kind if all values of column i of the rows can be
+// converted to it, DATE columns with times of day as timestamps, and
+// otherwise, strings.
+func checkKind(k kind, rows [][]interface{}, i int) kind {
+	for _, row := range rows {
+		v := row[i]
+		if v == nil {
+			continue
+		}
+		var ok bool
+		switch k {
+		case kindInt64:
+			_, ok = toInt64(v)
+		case kindDouble:
+			_, ok = toFloat64(v)
+		case kindBoolean:
+			_, ok = toBool(v)
+		case kindDate:
+			var t time.Time
+			if t, ok = toTime(v); ok {
+				if h, m, s := t.Clock(); h != 0 || m != 0 || s != 0 || t.Nanosecond() != 0 {
+					return checkKind(kindTimestamp, rows, i)
+				}
+			}
+		case kindTimestamp:
+			_, ok = toTime(v)
+		default:
+			ok = true
+		}
+		if !ok {
+			return kindString
+		}
+	}
+	return k
+}
+
+// toInt64 converts v to an int64.
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return x, true
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		return int64(x), x <= math.MaxInt64
+	case float64:
+		return int64(x), x == math.Trunc(x) && math.Abs(x) < 1<<63
+	case string:
+		n, err := strconv.ParseInt(x, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// toFloat64 converts v to a float64.
+func toFloat64(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	n, ok := toInt64(v)
+	return float64(n), ok
+}
+
+// toBool converts v to a bool.
+func toBool(v interface{}) (bool, bool) {
+	switch x := v.(type) {
+	case bool:
+		return x, true
+	case string:
+		b, err := strconv.ParseBool(x)
+		return b, err == nil
+	}
+	if n, ok := toInt64(v); ok && (n == 0 || n == 1) {
+		return n == 1, true
+	}
+	return false, false
+}
+
+// toTime converts v to a time.Time, parsing strings without a time zone as
+// UTC.
+func toTime(v interface{}) (time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, x); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// toString converts v to a string.
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// countingWriter counts the bytes written to a writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write satisfies the io.Writer interface.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
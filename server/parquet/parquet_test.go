@@ -0,0 +1,112 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+func TestSchema(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	columns := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "a"}
+	types := []string{"INT4", "", "BOOL", "DATE", "DATE", "TIMESTAMPTZ", "NUMERIC", "JSONB", "BYTEA", "INTEGER", "", ""}
+	rows := [][]interface{}{
+		{int64(1), 1.5, true, day, ts, ts, "1.50", `{"a":1}`, "\x00\x01", int64(1), "x", nil},
+		{nil, int64(2), false, "2024-01-03", nil, "2024-01-02 03:04:05", nil, nil, nil, "two", nil, ts},
+	}
+	exp := []Column{
+		{"a", "INT64", "INT(64, true)"},
+		{"b", "DOUBLE", ""},
+		{"c", "BOOLEAN", ""},
+		{"d", "INT32", "DATE"},
+		{"e", "INT64", "TIMESTAMP(MICROS, true)"},
+		{"f", "INT64", "TIMESTAMP(MICROS, true)"},
+		{"g", "BYTE_ARRAY", "STRING"},
+		{"h", "BYTE_ARRAY", "JSON"},
+		{"i", "BYTE_ARRAY", ""},
+		{"j", "BYTE_ARRAY", "STRING"},
+		{"k", "BYTE_ARRAY", "STRING"},
+		{"a_2", "INT64", "TIMESTAMP(MICROS, true)"},
+	}
+	if schema := Schema(columns, types, rows); !reflect.DeepEqual(schema, exp) {
+		t.Errorf("expected %v, got: %v", exp, schema)
+	}
+}
+
+func TestEncodeLevels(t *testing.T) {
+	levels := []bool{true, true, true, false, true}
+	exp := []byte{3 << 1, 1, 1 << 1, 0, 1 << 1, 1}
+	if b := encodeLevels(levels); !bytes.Equal(b, exp) {
+		t.Errorf("expected %v, got: %v", exp, b)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	if err := Write(new(bytes.Buffer), nil, nil, nil); err == nil {
+		t.Errorf("expected error writing no columns")
+	}
+
+	var buf bytes.Buffer
+	rows := [][]interface{}{{int64(1), "a"}, {nil, "b"}, {int64(3), nil}}
+	if err := Write(&buf, []string{"id", "name"}, []string{"INT8", "TEXT"}, rows); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	b := buf.Bytes()
+	if string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatalf("expected magic numbers, got: %q ... %q", b[:4], b[len(b)-4:])
+	}
+	footer := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	if footer <= 0 || footer > len(b)-12 {
+		t.Fatalf("invalid footer length %d", footer)
+	}
+
+	// the first column chunk, a data page of the definition levels and the
+	// non-null values, follows the magic number
+	defs := []byte{6, 0, 0, 0, 1 << 1, 1, 1 << 1, 0, 1 << 1, 1}
+	page := binary.LittleEndian.AppendUint64(binary.LittleEndian.AppendUint64(defs, 1), 3)
+	data := snappy.Encode(nil, page)
+	var header compact
+	header.fields(func() {
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(data)))
+		header.strct(5, func() {
+			header.i32(1, 3)
+			header.i32(2, encodingPlain)
+			header.i32(3, encodingRLE)
+			header.i32(4, encodingRLE)
+		})
+	})
+	if exp := append(header.buf, data...); !bytes.HasPrefix(b[4:], exp) {
+		t.Errorf("expected column chunk %x, got: %x", exp, b[4:4+len(exp)])
+	}
+}
+
+func TestCompact(t *testing.T) {
+	var c compact
+	c.fields(func() {
+		c.i32(1, -1)
+		c.string(2, "ab")
+		c.bool(3, true)
+		c.i64(20, 300)
+		c.strct(21, func() { c.byte(1, 64) })
+		c.i32s(22, 1, 2)
+	})
+	exp := []byte{
+		0x15, 0x01, // field 1 i32, zigzag -1
+		0x18, 0x02, 'a', 'b', // field 2 binary
+		0x11,                   // field 3 true
+		0x06, 0x28, 0xd8, 0x04, // field 20 i64 (long form), zigzag 300
+		0x1c, 0x13, 0x40, 0x00, // field 21 struct, field 1 byte
+		0x19, 0x25, 0x02, 0x04, // field 22 list of 2 i32
+		0x00,
+	}
+	if !bytes.Equal(c.buf, exp) {
+		t.Errorf("expected %x, got: %x", exp, c.buf)
+	}
+}
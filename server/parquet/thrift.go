@@ -0,0 +1,132 @@
+package parquet
+
+import "encoding/binary"
+
+// The Parquet file metadata and page headers are encoded as Thrift structs,
+// using the compact protocol. As only the few structs written are needed,
+// they are encoded with the minimal encoder below rather than generated code.
+
+// Thrift compact protocol field types.
+const (
+	tTrue   = 1
+	tFalse  = 2
+	tByte   = 3
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// compact is a Thrift compact protocol encoder.
+type compact struct {
+	buf  []byte
+	last int16
+}
+
+// field writes a field header, with the field ID as a delta from the last
+// field of the struct when possible.
+func (c *compact) field(id int16, typ byte) {
+	if delta := id - c.last; delta > 0 && delta <= 15 {
+		c.buf = append(c.buf, byte(delta)<<4|typ)
+	} else {
+		c.buf = append(c.buf, typ)
+		c.varint(zigzag(int64(id)))
+	}
+	c.last = id
+}
+
+// varint writes an unsigned varint.
+func (c *compact) varint(v uint64) {
+	c.buf = binary.AppendUvarint(c.buf, v)
+}
+
+// i32 writes an i32 field.
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, tI32)
+	c.varint(zigzag(int64(v)))
+}
+
+// i64 writes an i64 field.
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, tI64)
+	c.varint(zigzag(v))
+}
+
+// byte writes a byte (i8) field.
+func (c *compact) byte(id int16, v int8) {
+	c.field(id, tByte)
+	c.buf = append(c.buf, byte(v))
+}
+
+// bool writes a bool field, its value encoded in the field type.
+func (c *compact) bool(id int16, v bool) {
+	if v {
+		c.field(id, tTrue)
+	} else {
+		c.field(id, tFalse)
+	}
+}
+
+// string writes a binary field.
+func (c *compact) string(id int16, s string) {
+	c.field(id, tBinary)
+	c.varint(uint64(len(s)))
+	c.buf = append(c.buf, s...)
+}
+
+// list writes a list field header.
+func (c *compact) list(id int16, elem byte, n int) {
+	c.field(id, tList)
+	if n < 15 {
+		c.buf = append(c.buf, byte(n)<<4|elem)
+	} else {
+		c.buf = append(c.buf, 0xf0|elem)
+		c.varint(uint64(n))
+	}
+}
+
+// i32s writes a list of i32 field.
+func (c *compact) i32s(id int16, vs ...int32) {
+	c.list(id, tI32, len(vs))
+	for _, v := range vs {
+		c.varint(zigzag(int64(v)))
+	}
+}
+
+// strings writes a list of binary field.
+func (c *compact) strings(id int16, vs ...string) {
+	c.list(id, tBinary, len(vs))
+	for _, v := range vs {
+		c.varint(uint64(len(v)))
+		c.buf = append(c.buf, v...)
+	}
+}
+
+// strct writes a struct field, its fields written by f.
+func (c *compact) strct(id int16, f func()) {
+	c.field(id, tStruct)
+	c.fields(f)
+}
+
+// structs writes a list of n structs field, the fields of each written by f.
+func (c *compact) structs(id int16, n int, f func(int)) {
+	c.list(id, tStruct, n)
+	for i := 0; i < n; i++ {
+		c.fields(func() { f(i) })
+	}
+}
+
+// fields writes the fields of a struct written by f, and the stop field.
+func (c *compact) fields(f func()) {
+	last := c.last
+	c.last = 0
+	f()
+	c.buf = append(c.buf, 0)
+	c.last = last
+}
+
+// zigzag zigzag encodes a signed integer.
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
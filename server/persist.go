@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistVersion is the version of the persisted connections format.
+const persistVersion = 1
+
+// persistedConnections is the file dynamically created connections are
+// persisted to, their definitions encrypted as they contain credentials.
+type persistedConnections struct {
+	Version     int            `json:"version"`
+	SavedAt     time.Time      `json:"saved_at"`
+	Connections *EncryptedData `json:"connections"`
+}
+
+// connectionStore persists the definitions of a pool's dynamically created
+// connections to a file, from which they are restored when the server
+// starts. Definitions are saved in the background once they changed.
+type connectionStore struct {
+	path       string
+	passphrase string
+	pool       *ConnectionPool
+
+	dirty chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newConnectionStore creates a store persisting the connections of the pool,
+// or returns nil when connections are not persisted.
+func newConnectionStore(config PersistenceConfig, pool *ConnectionPool) (*connectionStore, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	passphrase := os.Getenv(config.PassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required in $%s to persist connections", config.PassphraseEnv)
+	}
+	return &connectionStore{
+		path:       config.File,
+		passphrase: passphrase,
+		pool:       pool,
+		dirty:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}, nil
+}
+
+// load reads the persisted connection definitions, returning none when
+// nothing was persisted yet.
+func (st *connectionStore) load() ([]ConnectionDefinition, error) {
+	buf, err := os.ReadFile(st.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var file persistedConnections
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return nil, fmt.Errorf("invalid persisted connections: %w", err)
+	}
+	if file.Version != persistVersion {
+		return nil, fmt.Errorf("unsupported persisted connections version %d", file.Version)
+	}
+	if file.Connections == nil {
+		return nil, nil
+	}
+	if buf, err = decrypt(file.Connections, st.passphrase); err != nil {
+		return nil, err
+	}
+	var defs []ConnectionDefinition
+	if err := json.Unmarshal(buf, &defs); err != nil {
+		return nil, fmt.Errorf("invalid connection definitions: %w", err)
+	}
+	return defs, nil
+}
+
+// save writes the definitions of the pool's connections, other than the
+// predefined connections, replacing the file once written.
+func (st *connectionStore) save() error {
+	defs := []ConnectionDefinition{}
+	for _, def := range st.pool.Definitions() {
+		if def.predefined == nil {
+			defs = append(defs, def)
+		}
+	}
+	buf, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	file := persistedConnections{Version: persistVersion, SavedAt: time.Now().UTC()}
+	if file.Connections, err = encrypt(buf, st.passphrase); err != nil {
+		return fmt.Errorf("failed to encrypt connections: %w", err)
+	}
+	if buf, err = json.Marshal(file); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
+
+// start saves the definitions once they changed, until the store is closed.
+func (st *connectionStore) start() {
+	st.done = make(chan struct{})
+	st.pool.OnRedefined(st.mark)
+	go func() {
+		defer close(st.done)
+		for {
+			select {
+			case <-st.dirty:
+				if err := st.save(); err != nil {
+					poolLog.Error("failed to persist connections", "error", err)
+				}
+			case <-st.stop:
+				return
+			}
+		}
+	}()
+}
+
+// mark marks the definitions as changed, to be saved.
+func (st *connectionStore) mark() {
+	select {
+	case st.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// close stops saving the definitions, saving them a last time when they
+// changed since last saved.
+func (st *connectionStore) close() error {
+	if st.done == nil {
+		return nil
+	}
+	st.pool.OnRedefined(nil)
+	close(st.stop)
+	<-st.done
+	select {
+	case <-st.dirty:
+		return st.save()
+	default:
+		return nil
+	}
+}
+
+// RestoreConnections restores the connections persisted when the server last
+// ran, and persists connections from then on, when persistence is enabled.
+// Connections are restored after the predefined connections, and those that
+// can't be restored are logged and dropped.
+func (s *Server) RestoreConnections(ctx context.Context) error {
+	if s.persisted == nil {
+		return nil
+	}
+	defs, err := s.persisted.load()
+	if err != nil {
+		return fmt.Errorf("failed to load persisted connections: %w", err)
+	}
+	restored := 0
+	for _, def := range defs {
+		if _, err := s.pool.GetConnection(def.ID); err == nil {
+			poolLog.Error("failed to restore connection: connection already exists", "connection", def.ID)
+			continue
+		}
+		if err := s.restoreConnection(ctx, def); err != nil {
+			poolLog.Error("failed to restore connection", "connection", def.ID, "error", err)
+			continue
+		}
+		restored++
+	}
+	if len(defs) != 0 {
+		poolLog.Info("restored persisted connections", "restored", restored, "persisted", len(defs))
+	}
+	s.persisted.start()
+	// saved once started, dropping the connections that weren't restored
+	s.persisted.mark()
+	return nil
+}
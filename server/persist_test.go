@@ -0,0 +1,54 @@
+package server
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestConnectionStore(t *testing.T) {
+	t.Setenv("USQLR_TEST_PASSPHRASE", "secret")
+	config := PersistenceConfig{File: filepath.Join(t.TempDir(), "connections.json"), PassphraseEnv: "USQLR_TEST_PASSPHRASE"}
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	st, err := newConnectionStore(config, cp)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if defs, err := st.load(); err != nil || defs != nil {
+		t.Fatalf("expected no definitions, got: %v %v", defs, err)
+	}
+
+	u, _ := dburl.Parse("postgres://localhost/db")
+	cp.connections["dynamic"] = &Connection{ID: "dynamic", URL: u, DB: sql.OpenDB(multiConnector{}), dsn: "postgres://localhost/db", lazy: true}
+	cp.connections["config"] = &Connection{ID: "config", URL: u, DB: sql.OpenDB(multiConnector{}), dsn: "postgres://localhost/db", predefined: &ConnectionConfig{ID: "config"}}
+
+	// changes are saved once marked, and when closed
+	st.start()
+	if err := cp.AddAlias("dynamic", "primary"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := st.close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defs, err := st.load()
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case len(defs) != 1 || defs[0].ID != "dynamic":
+		t.Fatalf("expected only the dynamic connection, got: %+v", defs)
+	case !defs[0].Lazy || len(defs[0].Aliases) != 1 || defs[0].Aliases[0] != "primary":
+		t.Errorf("expected the connection's definition, got: %+v", defs[0])
+	}
+
+	st.passphrase = "wrong"
+	if _, err := st.load(); err == nil {
+		t.Errorf("expected an error with the wrong passphrase")
+	}
+	t.Setenv("USQLR_TEST_PASSPHRASE", "")
+	if _, err := newConnectionStore(config, cp); err == nil {
+		t.Errorf("expected an error without a passphrase")
+	}
+}
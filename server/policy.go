@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// NewPolicyEngine creates the statement policy engine, loading the policy
+// documents from the configured directory. Without a policy directory, all
+// statements are allowed.
+func NewPolicyEngine(config PolicyConfig) (*policy.Engine, error) {
+	var policies []*policy.Policy
+	if config.Dir != "" {
+		var err error
+		if policies, err = policy.Load(config.Dir); err != nil {
+			return nil, err
+		}
+	}
+	return policy.NewEngine(policies, policy.Action(config.Default))
+}
+
+// restrict applies the row filters and column rules of the context's
+// principal on the connection to the SQL, returning the SQL to execute, and
+// a description of the rewrite when tables were restricted.
+func (conn *Connection) restrict(ctx context.Context, query string) (string, string, error) {
+	sql, applied, err := conn.policy.Restrict(ctx, conn.ID, query)
+	if err != nil || len(applied) == 0 {
+		return query, "", err
+	}
+	return sql, "tables restricted by policy " + strings.Join(applied, ", "), nil
+}
+
+// masker returns the masker of the connection's results for the context's
+// principal, or nil when nothing is masked.
+func (conn *Connection) masker(ctx context.Context) *policy.Masker {
+	return conn.policy.Masker(conn.ID, policy.PrincipalFrom(ctx))
+}
+
+// handleApprovals handles listing the approvals of statements requiring
+// approval by the statement policies, pending or approved.
+func (s *Server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.policy.Approvals())
+}
+
+// approveRequest is the admin API request to approve a statement.
+type approveRequest struct {
+	Approver string `json:"approver"`
+}
+
+// handleApprove handles approving a statement requiring approval, which can
+// then be run once by passing the approval's ID. Authenticated requests
+// approve as their principal.
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	var req approveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if principal := policy.PrincipalFrom(r.Context()); principal != "" {
+		req.Approver = principal
+	}
+	approval, err := s.pool.policy.Approve(r.PathValue("id"), req.Approver)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	policyLog.Info("approval approved", "approval", approval.ID, "statement", approval.Decision.Type, "connection", approval.ConnectionID)
+	writeJSON(w, http.StatusOK, approval)
+}
+
+// handleReject handles rejecting a statement requiring approval.
+func (s *Server) handleReject(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.pool.policy.Reject(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	policyLog.Info("approval rejected", "approval", id)
+	writeJSON(w, http.StatusOK, map[string]string{"rejected": id})
+}
@@ -0,0 +1,166 @@
+package policy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ApprovalTTL is how long an approval is kept, pending or approved but not
+// used, after it was requested.
+const ApprovalTTL = time.Hour
+
+// Approval states.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+)
+
+// Approval is the approval a statement requiring approval awaits. Once
+// approved, the same SQL can be executed once on the connection by the same
+// principal, passing the approval's ID.
+type Approval struct {
+	ID           string    `json:"id"`
+	State        string    `json:"state"`
+	ConnectionID string    `json:"connection_id"`
+	Principal    string    `json:"principal,omitempty"`
+	SQL          string    `json:"sql"`
+	Decision     Decision  `json:"decision"`
+	RequestedAt  time.Time `json:"requested_at"`
+	ApprovedAt   time.Time `json:"approved_at,omitzero"`
+	ApprovedBy   string    `json:"approved_by,omitempty"`
+}
+
+// contextKey is the type of the policy package's context keys.
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	approvalKey
+	attributesKey
+	scopesKey
+	accessKey
+	tenantKey
+)
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal
+// the statements are checked for.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFrom returns the principal of ctx, or "" when there is none.
+func PrincipalFrom(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey).(string)
+	return principal
+}
+
+// WithApproval returns a copy of ctx carrying the ID of the approval of the
+// statements executed with it.
+func WithApproval(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, approvalKey, id)
+}
+
+// approvalFrom returns the approval ID of ctx, or "" when there is none.
+func approvalFrom(ctx context.Context) string {
+	id, _ := ctx.Value(approvalKey).(string)
+	return id
+}
+
+// request returns the ID of the pending approval of sql on the connection by
+// the principal, requesting one when there is none.
+func (e *Engine) request(connectionID, principal, sql string, d Decision) string {
+	e.approvalsMu.Lock()
+	defer e.approvalsMu.Unlock()
+	e.expire()
+	for _, a := range e.approvals {
+		if a.State == ApprovalPending && a.ConnectionID == connectionID && a.Principal == principal && a.SQL == sql {
+			return a.ID
+		}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	a := &Approval{
+		ID:           hex.EncodeToString(b),
+		State:        ApprovalPending,
+		ConnectionID: connectionID,
+		Principal:    principal,
+		SQL:          sql,
+		Decision:     d,
+		RequestedAt:  time.Now(),
+	}
+	e.approvals[a.ID] = a
+	return a.ID
+}
+
+// useApproval reports whether the approval with the ID approves sql on the
+// connection by the principal, removing it when it does.
+func (e *Engine) useApproval(id, connectionID, principal, sql string) bool {
+	if id == "" {
+		return false
+	}
+	e.approvalsMu.Lock()
+	defer e.approvalsMu.Unlock()
+	e.expire()
+	a, ok := e.approvals[id]
+	if !ok || a.State != ApprovalApproved || a.ConnectionID != connectionID || a.Principal != principal || a.SQL != sql {
+		return false
+	}
+	delete(e.approvals, id)
+	return true
+}
+
+// Approvals returns the pending and approved approvals, oldest first.
+func (e *Engine) Approvals() []Approval {
+	e.approvalsMu.Lock()
+	defer e.approvalsMu.Unlock()
+	e.expire()
+	approvals := make([]Approval, 0, len(e.approvals))
+	for _, a := range e.approvals {
+		approvals = append(approvals, *a)
+	}
+	slices.SortFunc(approvals, func(a, b Approval) int {
+		return a.RequestedAt.Compare(b.RequestedAt)
+	})
+	return approvals
+}
+
+// Approve approves the pending approval with the ID, by the approver.
+func (e *Engine) Approve(id, approver string) (Approval, error) {
+	e.approvalsMu.Lock()
+	defer e.approvalsMu.Unlock()
+	e.expire()
+	a, ok := e.approvals[id]
+	switch {
+	case !ok:
+		return Approval{}, fmt.Errorf("approval %s not found", id)
+	case a.State != ApprovalPending:
+		return Approval{}, fmt.Errorf("approval %s is already %s", id, a.State)
+	}
+	a.State, a.ApprovedAt, a.ApprovedBy = ApprovalApproved, time.Now(), approver
+	return *a, nil
+}
+
+// Reject removes the approval with the ID, pending or approved.
+func (e *Engine) Reject(id string) error {
+	e.approvalsMu.Lock()
+	defer e.approvalsMu.Unlock()
+	if _, ok := e.approvals[id]; !ok {
+		return fmt.Errorf("approval %s not found", id)
+	}
+	delete(e.approvals, id)
+	return nil
+}
+
+// expire removes the approvals requested more than ApprovalTTL ago. The
+// caller holds approvalsMu.
+func (e *Engine) expire() {
+	for id, a := range e.approvals {
+		if time.Since(a.RequestedAt) > ApprovalTTL {
+			delete(e.approvals, id)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"strings"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// Statement categories.
+const (
+	CategoryRead  = "read"
+	CategoryDML   = "dml"
+	CategoryDDL   = "ddl"
+	CategoryDCL   = "dcl"
+	CategoryTCL   = "tcl"
+	CategoryAdmin = "admin"
+)
+
+// categories maps statement types to their category. Statement types not
+// listed are in CategoryAdmin.
+var categories = map[string]string{
+	"SELECT":    CategoryRead,
+	"SHOW":      CategoryRead,
+	"EXPLAIN":   CategoryRead,
+	"DESCRIBE":  CategoryRead,
+	"DESC":      CategoryRead,
+	"VALUES":    CategoryRead,
+	"TABLE":     CategoryRead,
+	"INSERT":    CategoryDML,
+	"UPDATE":    CategoryDML,
+	"DELETE":    CategoryDML,
+	"MERGE":     CategoryDML,
+	"UPSERT":    CategoryDML,
+	"REPLACE":   CategoryDML,
+	"COPY":      CategoryDML,
+	"CREATE":    CategoryDDL,
+	"ALTER":     CategoryDDL,
+	"DROP":      CategoryDDL,
+	"TRUNCATE":  CategoryDDL,
+	"RENAME":    CategoryDDL,
+	"COMMENT":   CategoryDDL,
+	"GRANT":     CategoryDCL,
+	"REVOKE":    CategoryDCL,
+	"DENY":      CategoryDCL,
+	"BEGIN":     CategoryTCL,
+	"START":     CategoryTCL,
+	"COMMIT":    CategoryTCL,
+	"END":       CategoryTCL,
+	"ROLLBACK":  CategoryTCL,
+	"SAVEPOINT": CategoryTCL,
+	"RELEASE":   CategoryTCL,
+}
+
+// Classify returns the type (its leading keyword, such as SELECT or DROP)
+// and category of a single SQL statement.
+//
+// Common table expressions are classified by their main statement, so that
+// "WITH x AS (...) DELETE ..." is DML, and "EXPLAIN ANALYZE" is classified
+// by the statement it executes.
+func Classify(stmt string) (string, string) {
+	words := sqlscan.Words(stmt)
+	// skip leading parentheses, as in "(SELECT ...) UNION (SELECT ...)"
+	for len(words) != 0 && words[0].Text == "(" {
+		words = words[1:]
+	}
+	if len(words) == 0 || words[0].Kind != sqlscan.Word {
+		return "", CategoryAdmin
+	}
+
+	typ := strings.ToUpper(words[0].Text)
+	switch typ {
+	case "WITH":
+		depth := 0
+		for _, t := range words[1:] {
+			switch {
+			case t.Text == "(":
+				depth++
+			case t.Text == ")":
+				depth--
+			case depth == 0 && t.Kind == sqlscan.Word:
+				if s := strings.ToUpper(t.Text); s == "SELECT" || s == "VALUES" || categories[s] == CategoryDML {
+					return s, categories[s]
+				}
+			}
+		}
+		return typ, CategoryRead
+	case "EXPLAIN":
+		analyze := false
+		for _, t := range words[1:] {
+			if t.Kind != sqlscan.Word {
+				continue
+			}
+			s := strings.ToUpper(t.Text)
+			if s == "ANALYZE" {
+				analyze = true
+			} else if category, ok := categories[s]; ok {
+				if analyze && category != CategoryRead {
+					return typ, category
+				}
+				break
+			}
+		}
+	}
+	if category, ok := categories[typ]; ok {
+		return typ, category
+	}
+	return typ, CategoryAdmin
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// ColumnRule is a column permission rule of a policy, restricting the
+// columns of the tables matching any of its case insensitive glob patterns
+// (as row filters' tables). Tables are rewritten into subqueries of their
+// allowed columns, when any are listed, so that only they can be selected,
+// and SQL referencing any of the denied columns, or selecting * from the
+// tables, is rejected.
+type ColumnRule struct {
+	Tables []string `yaml:"tables" json:"tables"`
+	Allow  []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+	Deny   []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+}
+
+// validate validates the column rule.
+func (r ColumnRule) validate() error {
+	if len(r.Tables) == 0 {
+		return errors.New("tables are required")
+	}
+	for _, pattern := range r.Tables {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid table pattern %q: %w", pattern, err)
+		}
+	}
+	if len(r.Allow) == 0 && len(r.Deny) == 0 {
+		return errors.New("allowed or denied columns are required")
+	}
+	for _, column := range append(slices.Clone(r.Allow), r.Deny...) {
+		if tokens := sqlscan.Words(column); len(tokens) != 1 || (tokens[0].Kind != sqlscan.Word && tokens[0].Kind != sqlscan.QuotedIdent) {
+			return fmt.Errorf("invalid column %q", column)
+		}
+	}
+	return nil
+}
+
+// policyColumns is a column rule of a policy.
+type policyColumns struct {
+	ColumnRule
+	policy string
+}
+
+// projectColumns returns the columns of the table that can be selected by
+// the SQL's tokens under the column rules: * when all can, or the columns
+// allowed by all rules matching the table, with the names of the policies of
+// the rules. SQL referencing denied columns, or modifying the table when
+// target is set, fails with a *FilterError.
+func projectColumns(rules []policyColumns, table []string, tokens []sqlscan.Token, target bool) (string, []string, error) {
+	name := strings.Join(table, ".")
+	var allowed, policies []string
+	for _, r := range rules {
+		if !matchTable(r.Tables, table) {
+			continue
+		}
+		if target {
+			return "", nil, &FilterError{Table: name, Message: "columns are restricted, and cannot be modified"}
+		}
+		for _, column := range r.Deny {
+			if referencesColumn(tokens, column) {
+				return "", nil, &FilterError{Table: name, Message: fmt.Sprintf("column %s is denied", column)}
+			}
+		}
+		if len(r.Deny) != 0 && selectsAll(tokens) {
+			return "", nil, &FilterError{Table: name, Message: "columns are denied, so * cannot be selected"}
+		}
+		if len(r.Allow) == 0 {
+			continue
+		}
+		if policies == nil {
+			allowed = slices.Clone(r.Allow)
+		} else {
+			allowed = slices.DeleteFunc(allowed, func(column string) bool {
+				return !slices.ContainsFunc(r.Allow, func(s string) bool { return strings.EqualFold(s, column) })
+			})
+		}
+		policies = append(policies, r.policy)
+	}
+	switch {
+	case policies == nil:
+		return "*", nil, nil
+	case len(allowed) == 0:
+		return "", nil, &FilterError{Table: name, Message: "no columns are allowed"}
+	}
+	return strings.Join(allowed, ", "), policies, nil
+}
+
+// referencesColumn reports whether any of the tokens is the column's name,
+// compared case-insensitively. References are not resolved to their table,
+// so columns of other tables of the same name are references too.
+func referencesColumn(tokens []sqlscan.Token, column string) bool {
+	column = strings.Trim(column, "\"`")
+	for _, t := range tokens {
+		switch t.Kind {
+		case sqlscan.Word:
+			if strings.EqualFold(t.Text, column) {
+				return true
+			}
+		case sqlscan.QuotedIdent:
+			if len(t.Text) > 1 && strings.EqualFold(t.Text[1:len(t.Text)-1], column) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectsAll reports whether the tokens select * (or t.*), rather than
+// using it in count(*) or as multiplication.
+func selectsAll(tokens []sqlscan.Token) bool {
+	prev := ""
+	for _, t := range tokens {
+		if t.Kind == sqlscan.Space || t.Kind == sqlscan.Comment {
+			continue
+		}
+		if t.Text == "*" {
+			switch strings.ToUpper(prev) {
+			case "SELECT", "DISTINCT", "ALL", ",", ".":
+				return true
+			}
+		}
+		prev = t.Text
+	}
+	return false
+}
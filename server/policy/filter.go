@@ -0,0 +1,315 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// attributePrefix is the prefix of the placeholders of principal attributes
+// in filter predicates, such as :token.tenant.
+const attributePrefix = ":token"
+
+// Filter is a row filter of a policy, restricting the rows of the tables
+// matching any of its case insensitive glob patterns to those matching its
+// predicate. Table patterns match qualified table names (such as
+// sales.orders) or their unqualified name.
+//
+// The predicate's :token.<name> placeholders are replaced by the attributes
+// of the principal, quoted as strings.
+type Filter struct {
+	Tables    []string `yaml:"tables" json:"tables"`
+	Predicate string   `yaml:"predicate" json:"predicate"`
+}
+
+// validate validates the filter.
+func (f Filter) validate() error {
+	if len(f.Tables) == 0 {
+		return errors.New("tables are required")
+	}
+	for _, pattern := range f.Tables {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid table pattern %q: %w", pattern, err)
+		}
+	}
+	if strings.TrimSpace(f.Predicate) == "" {
+		return errors.New("predicate is required")
+	}
+	return nil
+}
+
+// predicate returns the filter's predicate with the attributes substituted.
+func (f Filter) predicate(attrs map[string]string) (string, error) {
+	tokens := sqlscan.Scan(f.Predicate)
+	var sb strings.Builder
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.Kind != sqlscan.Placeholder || t.Text != attributePrefix || i+2 >= len(tokens) ||
+			tokens[i+1].Text != "." || tokens[i+2].Kind != sqlscan.Word {
+			sb.WriteString(t.Text)
+			continue
+		}
+		name := tokens[i+2].Text
+		v, ok := attrs[name]
+		if !ok {
+			return "", fmt.Errorf("principal has no %s attribute", name)
+		}
+		sb.WriteString("'" + strings.ReplaceAll(v, "'", "''") + "'")
+		i += 2
+	}
+	return sb.String(), nil
+}
+
+// WithAttributes returns a copy of ctx carrying the attributes of the
+// authenticated principal, substituted in row filter predicates.
+func WithAttributes(ctx context.Context, attrs map[string]string) context.Context {
+	return context.WithValue(ctx, attributesKey, attrs)
+}
+
+// AttributesFrom returns the principal attributes of ctx, or nil when there
+// are none.
+func AttributesFrom(ctx context.Context) map[string]string {
+	attrs, _ := ctx.Value(attributesKey).(map[string]string)
+	return attrs
+}
+
+// FilterError is the error returned when SQL cannot be restricted, such as
+// when it modifies a filtered table, or references a denied column.
+type FilterError struct {
+	Table   string `json:"table"`
+	Message string `json:"message"`
+}
+
+// Error satisfies the error interface.
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("table %s: %s", e.Table, e.Message)
+}
+
+// filterKeywords are the keywords followed by the tables rows are read
+// from, and targetKeywords those followed by the tables statements modify.
+var (
+	filterKeywords = []string{"FROM", "JOIN"}
+	targetKeywords = []string{"UPDATE", "INTO", "TABLE"}
+	// notTableKeywords are the keywords following FROM in expressions, such
+	// as IS DISTINCT FROM
+	notTableKeywords = []string{"DISTINCT"}
+	// exprFunctions are the functions whose arguments use FROM
+	exprFunctions = []string{"EXTRACT", "SUBSTRING", "TRIM", "OVERLAY", "POSITION"}
+)
+
+// Restrict restricts the tables of sql for the context's principal on the
+// connection: tables with row filters or allowed columns are rewritten into
+// subqueries of their filtered rows and allowed columns, aliased as the
+// table, and SQL referencing denied columns is rejected. Returns the SQL to
+// execute, and the names of the policies whose restrictions rewrote it. SQL
+// modifying restricted tables fails with a *FilterError. A nil engine
+// restricts nothing.
+func (e *Engine) Restrict(ctx context.Context, connectionID, sql string) (string, []string, error) {
+	if e == nil {
+		return sql, nil, nil
+	}
+	var filters []policyFilter
+	var columns []policyColumns
+	e.mu.RLock()
+	for _, p := range e.policies {
+		if !p.appliesTo(connectionID, PrincipalFrom(ctx)) {
+			continue
+		}
+		for _, f := range p.Filters {
+			filters = append(filters, policyFilter{f, p.Name})
+		}
+		for _, c := range p.Columns {
+			columns = append(columns, policyColumns{c, p.Name})
+		}
+	}
+	e.mu.RUnlock()
+	if len(filters) == 0 && len(columns) == 0 {
+		return sql, nil, nil
+	}
+
+	tokens := sqlscan.Scan(sql)
+	var sb strings.Builder
+	var applied []string
+	// parens holds the word preceding each open parenthesis
+	var parens []string
+	prev, prevPrev := "", ""
+	last := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.Kind == sqlscan.Space || t.Kind == sqlscan.Comment {
+			continue
+		}
+		word := ""
+		if t.Kind == sqlscan.Word {
+			word = strings.ToUpper(t.Text)
+		}
+		switch {
+		case t.Text == "(":
+			parens = append(parens, prev)
+		case t.Text == ")" && len(parens) != 0:
+			parens = parens[:len(parens)-1]
+		}
+		prevPrev, prev = prev, word
+		target := slices.Contains(targetKeywords, word) || (word == "FROM" && prevPrev == "DELETE")
+		switch {
+		case !target && !slices.Contains(filterKeywords, word):
+			continue
+		case word == "FROM" && slices.Contains(notTableKeywords, prevPrev):
+			continue
+		case len(parens) != 0 && slices.Contains(exprFunctions, parens[len(parens)-1]):
+			continue
+		}
+		for {
+			start, end, name := tableName(tokens, i+1)
+			if name == nil {
+				break
+			}
+			table := strings.Join(name, ".")
+			var predicates []string
+			var policies []string
+			for _, f := range filters {
+				if !matchTable(f.Tables, name) {
+					continue
+				}
+				if target {
+					return "", nil, &FilterError{Table: table, Message: "rows are filtered, and cannot be modified"}
+				}
+				pred, err := f.predicate(AttributesFrom(ctx))
+				if err != nil {
+					return "", nil, &FilterError{Table: table, Message: err.Error()}
+				}
+				predicates, policies = append(predicates, "("+pred+")"), append(policies, f.policy)
+			}
+			projection, projected, err := projectColumns(columns, name, tokens, target)
+			if err != nil {
+				return "", nil, err
+			}
+			policies = append(policies, projected...)
+			i = end - 1
+			if len(predicates) != 0 || len(projected) != 0 {
+				text := sql[tokens[start].Pos : tokens[end-1].Pos+len(tokens[end-1].Text)]
+				sb.WriteString(sql[last:tokens[start].Pos])
+				fmt.Fprintf(&sb, "(SELECT %s FROM %s", projection, text)
+				if len(predicates) != 0 {
+					sb.WriteString(" WHERE " + strings.Join(predicates, " AND "))
+				}
+				sb.WriteString(")")
+				if !hasAlias(tokens, end) {
+					sb.WriteString(" " + tokens[end-1].Text)
+				}
+				last = tokens[end-1].Pos + len(tokens[end-1].Text)
+				for _, p := range policies {
+					if !slices.Contains(applied, p) {
+						applied = append(applied, p)
+					}
+				}
+			}
+			// FROM a, b lists more tables after their aliases
+			j := skipAlias(tokens, end)
+			if target || j >= len(tokens) || tokens[j].Text != "," {
+				break
+			}
+			i = j
+		}
+		prev = ""
+	}
+	if len(applied) == 0 {
+		return sql, nil, nil
+	}
+	sb.WriteString(sql[last:])
+	return sb.String(), applied, nil
+}
+
+// policyFilter is a row filter of a policy.
+type policyFilter struct {
+	Filter
+	policy string
+}
+
+// matchTable reports whether the table, the lower case unquoted parts of a
+// table name, matches any of the case insensitive glob patterns, qualified
+// or unqualified.
+func matchTable(patterns []string, table []string) bool {
+	qualified, name := strings.Join(table, "."), table[len(table)-1]
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, qualified); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tableName returns the token range of the table name starting at the first
+// significant token from i, and the lower case unquoted parts of the name,
+// or a nil name when there is no table name, such as before a subquery.
+func tableName(tokens []sqlscan.Token, i int) (int, int, []string) {
+	i = skipSpace(tokens, i)
+	start := i
+	var name []string
+	for i < len(tokens) {
+		t := tokens[i]
+		switch {
+		case t.Kind == sqlscan.Word && !isClauseKeyword(t):
+			name = append(name, strings.ToLower(t.Text))
+		case t.Kind == sqlscan.QuotedIdent && len(t.Text) > 1:
+			name = append(name, strings.ToLower(t.Text[1:len(t.Text)-1]))
+		default:
+			return 0, 0, nil
+		}
+		i++
+		if i >= len(tokens) || tokens[i].Text != "." {
+			return start, i, name
+		}
+		i++
+	}
+	return 0, 0, nil
+}
+
+// hasAlias reports whether the table name ending before i has an alias.
+func hasAlias(tokens []sqlscan.Token, i int) bool {
+	return skipAlias(tokens, i) != skipSpace(tokens, i)
+}
+
+// skipAlias returns the index of the first significant token after the
+// alias of the table name ending before i, if any.
+func skipAlias(tokens []sqlscan.Token, i int) int {
+	i = skipSpace(tokens, i)
+	if i < len(tokens) && tokens[i].Is("AS") {
+		i = skipSpace(tokens, i+1)
+	}
+	if i < len(tokens) && ((tokens[i].Kind == sqlscan.Word && !isClauseKeyword(tokens[i])) || tokens[i].Kind == sqlscan.QuotedIdent) {
+		i = skipSpace(tokens, i+1)
+	}
+	return i
+}
+
+// skipSpace returns the index of the first significant token from i.
+func skipSpace(tokens []sqlscan.Token, i int) int {
+	for i < len(tokens) && (tokens[i].Kind == sqlscan.Space || tokens[i].Kind == sqlscan.Comment) {
+		i++
+	}
+	return i
+}
+
+// clauseKeywords are the keywords that may follow a table name, and so are
+// not aliases.
+var clauseKeywords = []string{
+	"WHERE", "GROUP", "ORDER", "HAVING", "LIMIT", "OFFSET", "FETCH", "UNION",
+	"INTERSECT", "EXCEPT", "MINUS", "JOIN", "INNER", "LEFT", "RIGHT", "FULL",
+	"CROSS", "NATURAL", "OUTER", "ON", "USING", "WINDOW", "FOR", "SET",
+	"VALUES", "SELECT", "RETURNING", "LATERAL", "TABLESAMPLE", "WITH",
+}
+
+// isClauseKeyword reports whether the token is a clause keyword.
+func isClauseKeyword(t sqlscan.Token) bool {
+	return t.Kind == sqlscan.Word && slices.Contains(clauseKeywords, strings.ToUpper(t.Text))
+}
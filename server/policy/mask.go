@@ -0,0 +1,251 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Masking methods, from the least to the most strict.
+const (
+	// MaskPartial replaces all but the last 4 characters of values with
+	// asterisks, or all characters of values of up to 4 characters.
+	MaskPartial = "partial"
+	// MaskHash replaces values with the hex encoded SHA-256 hash of their
+	// text, keeping equal values equal, such as for joins and grouping.
+	MaskHash = "hash"
+	// MaskNull replaces values with NULL.
+	MaskNull = "null"
+)
+
+// maskMethods are the masking methods, from the least to the most strict.
+var maskMethods = []string{MaskPartial, MaskHash, MaskNull}
+
+// partialVisible is the number of trailing characters partial masking keeps.
+const partialVisible = 4
+
+// Mask is a column masking rule of a policy, masking the values of the
+// result columns whose name matches any of its case insensitive glob
+// patterns, or any of its regular expression patterns.
+type Mask struct {
+	Columns  []string `yaml:"columns,omitempty" json:"columns,omitempty"`
+	Patterns []string `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+	Method   string   `yaml:"method" json:"method"`
+
+	// patterns are the compiled patterns
+	patterns []*regexp.Regexp
+}
+
+// validate validates the mask, compiling its patterns.
+func (m *Mask) validate() error {
+	if !slices.Contains(maskMethods, m.Method) {
+		return fmt.Errorf("invalid masking method %q", m.Method)
+	}
+	if len(m.Columns) == 0 && len(m.Patterns) == 0 {
+		return fmt.Errorf("columns or patterns are required")
+	}
+	for _, pattern := range m.Columns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid column pattern %q: %w", pattern, err)
+		}
+	}
+	m.patterns = make([]*regexp.Regexp, len(m.Patterns))
+	for i, pattern := range m.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		m.patterns[i] = re
+	}
+	return nil
+}
+
+// matches reports whether the mask applies to the column.
+func (m Mask) matches(column string) bool {
+	for _, pattern := range m.Columns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(column)); ok {
+			return true
+		}
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(column) {
+			return true
+		}
+	}
+	return false
+}
+
+// Masker masks result columns on a connection for a principal, and detects
+// the PII in them. A nil masker masks nothing.
+type Masker struct {
+	connectionID string
+	principal    string
+	masks        []Mask
+	pii          piiActions
+}
+
+// Masker returns the masker of results on the connection for the principal,
+// or nil when no policy applying to them has masks or PII detection.
+func (e *Engine) Masker(connectionID, principal string) *Masker {
+	if e == nil {
+		return nil
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	m := &Masker{connectionID: connectionID, principal: principal}
+	for _, p := range e.policies {
+		if !p.appliesTo(connectionID, principal) {
+			continue
+		}
+		m.masks = append(m.masks, p.Masks...)
+		if p.PII != nil {
+			if m.pii == nil {
+				m.pii = make(piiActions)
+			}
+			m.pii.add(p.PII)
+		}
+	}
+	if len(m.masks) == 0 && m.pii == nil {
+		return nil
+	}
+	return m
+}
+
+// Columns returns the masking of the columns, the strictest method of the
+// masks matching each column, or nil when no column is masked and there is
+// no PII detection.
+func (m *Masker) Columns(columns []string) *ColumnMasks {
+	if m == nil {
+		return nil
+	}
+	var methods []string
+	for i, column := range columns {
+		for _, mask := range m.masks {
+			if !mask.matches(column) {
+				continue
+			}
+			if methods == nil {
+				methods = make([]string, len(columns))
+			}
+			if slices.Index(maskMethods, mask.Method) > slices.Index(maskMethods, methods[i]) {
+				methods[i] = mask.Method
+			}
+		}
+	}
+	if methods == nil && m.pii == nil {
+		return nil
+	}
+	return &ColumnMasks{masker: m, columns: columns, methods: methods}
+}
+
+// ColumnMasks are the masking of the columns of a result set. Nil column
+// masks mask nothing.
+type ColumnMasks struct {
+	masker  *Masker
+	columns []string
+	// methods are the masking methods of the columns, empty for columns
+	// left unmasked
+	methods  []string
+	findings []PIIFinding
+}
+
+// Row masks the values of the row in place, and scans the string values of
+// unmasked columns for PII, redacting the types of PII redacted.
+func (cm *ColumnMasks) Row(row []interface{}) {
+	if cm == nil {
+		return
+	}
+	for i, method := range cm.methods {
+		if i < len(row) && method != "" {
+			row[i] = maskValue(method, row[i])
+		}
+	}
+	pii := cm.masker.pii
+	if pii == nil {
+		return
+	}
+	for i, v := range row {
+		s, ok := v.(string)
+		if !ok || i >= len(cm.columns) || (cm.methods != nil && cm.methods[i] != "") {
+			continue
+		}
+		redacted, counts := pii.scan(s)
+		if counts == nil {
+			continue
+		}
+		row[i] = redacted
+		for _, typ := range piiTypes {
+			if counts[typ] != 0 {
+				cm.found(cm.columns[i], typ, counts[typ], pii[typ] == PIIRedact)
+			}
+		}
+	}
+}
+
+// found records PII found in the column.
+func (cm *ColumnMasks) found(column, typ string, count int, redacted bool) {
+	for i, f := range cm.findings {
+		if f.Column == column && f.Type == typ {
+			cm.findings[i].Count += count
+			return
+		}
+	}
+	cm.findings = append(cm.findings, PIIFinding{Column: column, Type: typ, Count: count, Redacted: redacted})
+}
+
+// Findings returns the PII found in the rows since the last call, logging
+// it without the values.
+func (cm *ColumnMasks) Findings() []PIIFinding {
+	if cm == nil || len(cm.findings) == 0 {
+		return nil
+	}
+	findings := cm.findings
+	cm.findings = nil
+	logPII(cm.masker.connectionID, cm.masker.principal, findings)
+	return findings
+}
+
+// JSONTypes returns the JSON types of the columns once masked: masking
+// replaces values with strings, except for NULL.
+func (cm *ColumnMasks) JSONTypes(types []string) []string {
+	if cm == nil || cm.methods == nil || types == nil {
+		return types
+	}
+	masked := slices.Clone(types)
+	for i, method := range cm.methods {
+		if i < len(masked) && (method == MaskPartial || method == MaskHash) {
+			masked[i] = "string"
+		}
+	}
+	return masked
+}
+
+// maskValue masks the value with the method. NULL values stay NULL.
+func maskValue(method string, v interface{}) interface{} {
+	if v == nil || method == MaskNull {
+		return nil
+	}
+	var s string
+	switch x := v.(type) {
+	case string:
+		s = x
+	case []byte:
+		s = string(x)
+	default:
+		s = fmt.Sprint(x)
+	}
+	if method == MaskHash {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	r := []rune(s)
+	visible := 0
+	if len(r) > partialVisible {
+		visible = partialVisible
+	}
+	return strings.Repeat("*", len(r)-visible) + string(r[len(r)-visible:])
+}
@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// PII types.
+const (
+	PIIEmail      = "email"
+	PIICreditCard = "credit_card"
+	// PIINationalID is US social security numbers and UK national insurance
+	// numbers.
+	PIINationalID = "national_id"
+)
+
+// PII detection actions.
+const (
+	// PIIFlag reports the PII found in results, leaving it as is.
+	PIIFlag = "flag"
+	// PIIRedact replaces the PII found in results, and reports it.
+	PIIRedact = "redact"
+)
+
+// piiTypes are the PII types, in the order values are scanned for them.
+var piiTypes = []string{PIIEmail, PIICreditCard, PIINationalID}
+
+// piiPatterns are the patterns of likely PII, by type, further validated by
+// piiValid.
+var piiPatterns = map[string]*regexp.Regexp{
+	PIIEmail:      regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	PIICreditCard: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+	PIINationalID: regexp.MustCompile(`\b(?:\d{3}-\d{2}-\d{4}|[A-CEGHJ-PR-TW-Z]{2} ?\d{2} ?\d{2} ?\d{2} ?[A-D])\b`),
+}
+
+// PIIDetection is the PII detection of a policy, scanning the string values
+// of results for likely PII of its types (all when none are listed).
+type PIIDetection struct {
+	Types  []string `yaml:"types,omitempty" json:"types,omitempty"`
+	Action string   `yaml:"action" json:"action"`
+}
+
+// validate validates the PII detection.
+func (d *PIIDetection) validate() error {
+	if d.Action != PIIFlag && d.Action != PIIRedact {
+		return fmt.Errorf("invalid PII action %q", d.Action)
+	}
+	for _, typ := range d.Types {
+		if !slices.Contains(piiTypes, typ) {
+			return fmt.Errorf("invalid PII type %q", typ)
+		}
+	}
+	return nil
+}
+
+// piiActions are the actions of PII types, merged from the PII detections
+// of policies: a type is redacted when any of them redacts it.
+type piiActions map[string]string
+
+// add adds the types of the PII detection.
+func (a piiActions) add(d *PIIDetection) {
+	types := d.Types
+	if len(types) == 0 {
+		types = piiTypes
+	}
+	for _, typ := range types {
+		if a[typ] != PIIRedact {
+			a[typ] = d.Action
+		}
+	}
+}
+
+// scan returns the value with the PII found in it redacted, for the types
+// redacted, and the number of values found of each type.
+func (a piiActions) scan(s string) (string, map[string]int) {
+	var counts map[string]int
+	for _, typ := range piiTypes {
+		action, ok := a[typ]
+		if !ok {
+			continue
+		}
+		s = piiPatterns[typ].ReplaceAllStringFunc(s, func(m string) string {
+			if !piiValid(typ, m) {
+				return m
+			}
+			if counts == nil {
+				counts = make(map[string]int)
+			}
+			counts[typ]++
+			if action == PIIRedact {
+				return "[redacted " + typ + "]"
+			}
+			return m
+		})
+	}
+	return s, counts
+}
+
+// piiValid reports whether the match of the type's pattern is likely PII:
+// credit card numbers pass the Luhn check, and social security numbers have
+// a valid area, group and serial.
+func piiValid(typ, m string) bool {
+	switch typ {
+	case PIICreditCard:
+		var digits []int
+		for _, c := range m {
+			if '0' <= c && c <= '9' {
+				digits = append(digits, int(c-'0'))
+			}
+		}
+		sum := 0
+		for i := range digits {
+			d := digits[len(digits)-1-i]
+			if i%2 == 1 {
+				if d *= 2; d > 9 {
+					d -= 9
+				}
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	case PIINationalID:
+		if len(m) == 11 && m[3] == '-' {
+			area := m[:3]
+			return area != "000" && area != "666" && area[0] != '9' && m[4:6] != "00" && m[7:] != "0000"
+		}
+	}
+	return true
+}
+
+// PIIFinding is PII found in the values of a result column.
+type PIIFinding struct {
+	Column   string `json:"column"`
+	Type     string `json:"type"`
+	Count    int    `json:"count"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// logPII logs the PII found in results on the connection for the
+// principal, without the values.
+func logPII(connectionID, principal string, findings []PIIFinding) {
+	found := make([]string, len(findings))
+	for i, f := range findings {
+		found[i] = fmt.Sprintf("%s in column %s (%d)", f.Type, f.Column, f.Count)
+		if f.Redacted {
+			found[i] += " redacted"
+		}
+	}
+	logger.Warn("PII found in results", "connection", connectionID, "principal", principal, "findings", found)
+}
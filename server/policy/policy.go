@@ -0,0 +1,437 @@
+// Package policy implements declarative SQL statement policies, loaded from
+// YAML policy documents.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/xo/usql/server/logging"
+	"github.com/xo/usql/server/sqlscan"
+	"gopkg.in/yaml.v3"
+)
+
+// logger is the logger of the policy subsystem.
+var logger = logging.Logger(logging.Policy)
+
+// Action is a policy rule action.
+type Action string
+
+// Actions. Statements requiring approval are denied until an admin approves
+// them, and then allowed once.
+const (
+	Allow           Action = "allow"
+	Deny            Action = "deny"
+	RequireApproval Action = "require_approval"
+)
+
+// Policy is a policy document.
+//
+// A policy applies to the connections matching any of its connection glob
+// patterns, or to all connections when none are specified, and likewise to
+// the principals (the authenticated callers) matching its principal glob
+// patterns.
+//
+// A policy's masks mask the values of result columns, its PII detection
+// detects PII in them, its filters filter the rows of tables, and its column
+// rules restrict the columns of tables, on the connections and for the
+// principals the policy applies to.
+type Policy struct {
+	Name        string        `yaml:"name" json:"name"`
+	Description string        `yaml:"description,omitempty" json:"description,omitempty"`
+	Connections []string      `yaml:"connections,omitempty" json:"connections,omitempty"`
+	Principals  []string      `yaml:"principals,omitempty" json:"principals,omitempty"`
+	Rules       []Rule        `yaml:"rules" json:"rules"`
+	Masks       []Mask        `yaml:"masks,omitempty" json:"masks,omitempty"`
+	Filters     []Filter      `yaml:"filters,omitempty" json:"filters,omitempty"`
+	Columns     []ColumnRule  `yaml:"columns,omitempty" json:"columns,omitempty"`
+	PII         *PIIDetection `yaml:"pii,omitempty" json:"pii,omitempty"`
+
+	// File is the file the policy was loaded from.
+	File string `yaml:"-" json:"file,omitempty"`
+}
+
+// Rule is a policy rule.
+//
+// A rule matches statements whose category (read, dml, ddl, dcl, tcl,
+// admin) or type (leading keyword, such as DROP) is listed in its
+// statements, or all statements when none are listed, and that match any of
+// its regular expression patterns, when it has any.
+type Rule struct {
+	Action     Action   `yaml:"action" json:"action"`
+	Statements []string `yaml:"statements,omitempty" json:"statements,omitempty"`
+	Patterns   []string `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+	Message    string   `yaml:"message,omitempty" json:"message,omitempty"`
+
+	// patterns are the compiled patterns
+	patterns []*regexp.Regexp
+}
+
+// validate validates the policy.
+func (p *Policy) validate() error {
+	if p.Name == "" {
+		return errors.New("policy name is required")
+	}
+	for _, pattern := range p.Connections {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("policy %s: invalid connection pattern %q: %w", p.Name, pattern, err)
+		}
+	}
+	for _, pattern := range p.Principals {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("policy %s: invalid principal pattern %q: %w", p.Name, pattern, err)
+		}
+	}
+	for i, rule := range p.Rules {
+		if rule.Action != Allow && rule.Action != Deny && rule.Action != RequireApproval {
+			return fmt.Errorf("policy %s: rule %d: invalid action %q", p.Name, i+1, rule.Action)
+		}
+		patterns := make([]*regexp.Regexp, len(rule.Patterns))
+		for j, pattern := range rule.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("policy %s: rule %d: invalid pattern %q: %w", p.Name, i+1, pattern, err)
+			}
+			patterns[j] = re
+		}
+		p.Rules[i].patterns = patterns
+	}
+	for i := range p.Masks {
+		if err := p.Masks[i].validate(); err != nil {
+			return fmt.Errorf("policy %s: mask %d: %w", p.Name, i+1, err)
+		}
+	}
+	for i, f := range p.Filters {
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("policy %s: filter %d: %w", p.Name, i+1, err)
+		}
+	}
+	for i, c := range p.Columns {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("policy %s: column rule %d: %w", p.Name, i+1, err)
+		}
+	}
+	if p.PII != nil {
+		if err := p.PII.validate(); err != nil {
+			return fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the policy applies to the connection and
+// principal.
+func (p *Policy) appliesTo(connectionID, principal string) bool {
+	return matchAny(p.Connections, connectionID) && matchAny(p.Principals, principal)
+}
+
+// matchAny reports whether the name matches any of the glob patterns, or
+// whether there are no patterns. An empty name matches no pattern.
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule matches the statement of the type and
+// category.
+func (r Rule) matches(stmt, typ, category string) bool {
+	if !r.matchesType(typ, category) {
+		return false
+	}
+	if len(r.patterns) == 0 {
+		return true
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesType reports whether the rule matches statements of the type and
+// category.
+func (r Rule) matchesType(typ, category string) bool {
+	if len(r.Statements) == 0 {
+		return true
+	}
+	for _, s := range r.Statements {
+		if strings.EqualFold(s, category) || strings.EqualFold(s, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses the policy documents in r. Multiple documents may be
+// separated by "---".
+func Parse(r io.Reader) ([]*Policy, error) {
+	var policies []*Policy
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	for {
+		p := new(Policy)
+		switch err := dec.Decode(p); {
+		case errors.Is(err, io.EOF):
+			return policies, nil
+		case err != nil:
+			return nil, err
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+}
+
+// Load loads the policy documents from the .yaml and .yml files in dir, in
+// lexical order.
+func Load(dir string) ([]*Policy, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy directory: %w", err)
+	}
+
+	var policies []*Policy
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		name := filepath.Join(dir, entry.Name())
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open policy file: %w", err)
+		}
+		v, err := Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse policy file %s: %w", name, err)
+		}
+		for _, p := range v {
+			p.File = name
+		}
+		policies = append(policies, v...)
+	}
+	return policies, nil
+}
+
+// Decision is the result of evaluating a statement against the policies.
+type Decision struct {
+	Statement string `json:"statement"`
+	Type      string `json:"type"`
+	Category  string `json:"category"`
+	Principal string `json:"principal,omitempty"`
+	Action    Action `json:"action"`
+	Policy    string `json:"policy,omitempty"`
+	Rule      int    `json:"rule,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Allowed reports whether the statement is allowed without approval.
+func (d Decision) Allowed() bool {
+	return d.Action == Allow
+}
+
+// Violation is the error returned when a statement is denied by a policy,
+// or requires approval.
+type Violation struct {
+	Decision
+	// ApprovalID is the ID of the approval the statement awaits, when it
+	// requires approval.
+	ApprovalID string `json:"approval_id,omitempty"`
+}
+
+// Error satisfies the error interface.
+func (v *Violation) Error() string {
+	var sb strings.Builder
+	if v.Action == RequireApproval {
+		fmt.Fprintf(&sb, "%s statement requires approval", v.Type)
+	} else {
+		fmt.Fprintf(&sb, "%s statement denied", v.Type)
+	}
+	if v.Policy != "" {
+		fmt.Fprintf(&sb, " by policy %s", v.Policy)
+	}
+	if v.Message != "" {
+		sb.WriteString(": " + v.Message)
+	}
+	if v.ApprovalID != "" {
+		fmt.Fprintf(&sb, " (approval %s)", v.ApprovalID)
+	}
+	return sb.String()
+}
+
+// ErrorData returns the violation's details for clients.
+func (v *Violation) ErrorData() map[string]interface{} {
+	return map[string]interface{}{"policy_violation": v}
+}
+
+// Engine evaluates statements against a set of policies.
+//
+// Within a policy, the first rule matching a statement decides. A statement
+// is denied when any applicable policy denies it, otherwise it requires
+// approval when any applicable policy requires it, and otherwise it is
+// allowed when any applicable policy allows it. Statements matching no rule
+// get the default action.
+type Engine struct {
+	mu            sync.RWMutex
+	policies      []*Policy
+	defaultAction Action
+
+	approvalsMu sync.Mutex
+	approvals   map[string]*Approval
+}
+
+// NewEngine creates a new policy engine. An empty default action is allow.
+func NewEngine(policies []*Policy, defaultAction Action) (*Engine, error) {
+	switch defaultAction {
+	case "":
+		defaultAction = Allow
+	case Allow, Deny:
+	default:
+		return nil, fmt.Errorf("invalid default policy action %q", defaultAction)
+	}
+	return &Engine{policies: policies, defaultAction: defaultAction, approvals: make(map[string]*Approval)}, nil
+}
+
+// Policies returns the engine's policies.
+func (e *Engine) Policies() []*Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]*Policy(nil), e.policies...)
+}
+
+// SetPolicies replaces the engine's policies.
+func (e *Engine) SetPolicies(policies []*Policy) error {
+	for _, p := range policies {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policies = policies
+	return nil
+}
+
+// Evaluate evaluates a single statement executed on the connection by the
+// principal, which is empty when the caller is not authenticated.
+func (e *Engine) Evaluate(connectionID, principal, stmt string) Decision {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	typ, category := Classify(stmt)
+	d := Decision{
+		Statement: stmt,
+		Type:      typ,
+		Category:  category,
+		Principal: principal,
+		Action:    e.defaultAction,
+	}
+	var allow, approve *Decision
+	for _, p := range e.policies {
+		if !p.appliesTo(connectionID, principal) {
+			continue
+		}
+		for i, rule := range p.Rules {
+			if !rule.matches(stmt, typ, category) {
+				continue
+			}
+			match := d
+			match.Action, match.Policy, match.Rule, match.Message = rule.Action, p.Name, i+1, rule.Message
+			switch {
+			case rule.Action == Deny:
+				return match
+			case rule.Action == RequireApproval && approve == nil:
+				approve = &match
+			case rule.Action == Allow && allow == nil:
+				allow = &match
+			}
+			break
+		}
+	}
+	switch {
+	case approve != nil:
+		return *approve
+	case allow != nil:
+		return *allow
+	}
+	return d
+}
+
+// EvaluateAll splits sql into statements and evaluates each of them.
+func (e *Engine) EvaluateAll(connectionID, principal, sql string) []Decision {
+	var decisions []Decision
+	for _, stmt := range sqlscan.Split(sql) {
+		decisions = append(decisions, e.Evaluate(connectionID, principal, stmt))
+	}
+	return decisions
+}
+
+// Check checks that all statements in sql are allowed on the connection for
+// the context's principal, returning a *Violation for the first denied
+// statement. Statements requiring approval are allowed when the context
+// carries the ID of the approved approval of sql, which is used up, and
+// otherwise get a pending approval. A nil engine allows everything.
+func (e *Engine) Check(ctx context.Context, connectionID, sql string) error {
+	return e.check(ctx, connectionID, sql, true)
+}
+
+// CheckDenied checks that no statement in sql is denied on the connection for
+// the context's principal, as Check, though leaving statements requiring
+// approval to be checked when they are executed.
+func (e *Engine) CheckDenied(ctx context.Context, connectionID, sql string) error {
+	return e.check(ctx, connectionID, sql, false)
+}
+
+// check checks the statements in sql, and those requiring approval when
+// approvals is set.
+func (e *Engine) check(ctx context.Context, connectionID, sql string, approvals bool) error {
+	if e == nil {
+		return nil
+	}
+	principal := PrincipalFrom(ctx)
+	var approve *Decision
+	for _, d := range e.EvaluateAll(connectionID, principal, sql) {
+		switch d.Action {
+		case Deny:
+			logBlocked(connectionID, d)
+			return &Violation{Decision: d}
+		case RequireApproval:
+			if approve == nil {
+				approve = &d
+			}
+		}
+	}
+	if approve == nil || !approvals {
+		return nil
+	}
+	if e.useApproval(approvalFrom(ctx), connectionID, principal, sql) {
+		return nil
+	}
+	logBlocked(connectionID, *approve)
+	return &Violation{Decision: *approve, ApprovalID: e.request(connectionID, principal, sql, *approve)}
+}
+
+// logBlocked logs the statement blocked on the connection by the decision.
+func logBlocked(connectionID string, d Decision) {
+	source := "the default action"
+	if d.Policy != "" {
+		source = fmt.Sprintf("policy %s rule %d", d.Policy, d.Rule)
+	}
+	logger.Warn("statement blocked", "statement", d.Type, "connection", connectionID, "principal", d.Principal, "action", d.Action, "by", source)
+}
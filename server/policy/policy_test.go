@@ -0,0 +1,549 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		stmt     string
+		typ      string
+		category string
+	}{
+		{"SELECT 1", "SELECT", CategoryRead},
+		{"/* hint */ select * from t", "SELECT", CategoryRead},
+		{"(SELECT 1) UNION (SELECT 2)", "SELECT", CategoryRead},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", "SELECT", CategoryRead},
+		{"WITH x AS (SELECT 1) DELETE FROM t", "DELETE", CategoryDML},
+		{"insert into t values (1)", "INSERT", CategoryDML},
+		{"DROP TABLE t", "DROP", CategoryDDL},
+		{"GRANT SELECT ON t TO u", "GRANT", CategoryDCL},
+		{"BEGIN", "BEGIN", CategoryTCL},
+		{"EXPLAIN SELECT 1", "EXPLAIN", CategoryRead},
+		{"EXPLAIN ANALYZE DELETE FROM t", "EXPLAIN", CategoryDML},
+		{"VACUUM", "VACUUM", CategoryAdmin},
+	}
+	for _, test := range tests {
+		typ, category := Classify(test.stmt)
+		if typ != test.typ || category != test.category {
+			t.Errorf("%q expected %s/%s, got: %s/%s", test.stmt, test.typ, test.category, typ, category)
+		}
+	}
+}
+
+const testPolicies = `
+name: readonly
+connections: ["prod-*"]
+rules:
+  - action: allow
+    statements: [read]
+  - action: deny
+    message: read only
+---
+name: no-drop
+rules:
+  - action: deny
+    statements: [drop]
+`
+
+func TestEngine(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		connection string
+		sql        string
+		policy     string
+	}{
+		{"prod-eu", "SELECT 1", ""},
+		{"prod-eu", "SELECT 1; UPDATE t SET a = 1", "readonly"},
+		{"prod-eu", "DROP TABLE t", "readonly"},
+		{"dev", "UPDATE t SET a = 1", ""},
+		{"dev", "drop table t", "no-drop"},
+	}
+	for _, test := range tests {
+		err := e.Check(context.Background(), test.connection, test.sql)
+		var v *Violation
+		switch {
+		case test.policy == "" && err != nil:
+			t.Errorf("%s %q expected no error, got: %v", test.connection, test.sql, err)
+		case test.policy != "" && !errors.As(err, &v):
+			t.Errorf("%s %q expected *Violation, got: %v", test.connection, test.sql, err)
+		case test.policy != "" && v.Policy != test.policy:
+			t.Errorf("%s %q expected policy %s, got: %s", test.connection, test.sql, test.policy, v.Policy)
+		}
+	}
+}
+
+const testApprovalPolicies = `
+name: analysts
+principals: ["analyst-*"]
+rules:
+  - action: allow
+    statements: [read, dml]
+  - action: deny
+    statements: [ddl]
+---
+name: destructive
+rules:
+  - action: require_approval
+    statements: [truncate, drop]
+`
+
+func TestApprovals(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testApprovalPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	analyst := WithPrincipal(context.Background(), "analyst-1")
+
+	// policies with principals apply to their principals only
+	var v *Violation
+	if err := e.Check(analyst, "db", "DROP TABLE t"); !errors.As(err, &v) || v.Action != Deny || v.Policy != "analysts" {
+		t.Errorf("expected the analysts policy to deny, got: %v", err)
+	}
+	if err := e.Check(analyst, "db", "DELETE FROM t"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	// statements requiring approval get a pending approval, reused until
+	// approved
+	err = e.Check(context.Background(), "db", "TRUNCATE t")
+	if !errors.As(err, &v) || v.Action != RequireApproval || v.ApprovalID == "" {
+		t.Fatalf("expected a pending approval, got: %v", err)
+	}
+	id := v.ApprovalID
+	if err := e.Check(context.Background(), "db", "TRUNCATE t"); !errors.As(err, &v) || v.ApprovalID != id {
+		t.Errorf("expected the pending approval %s, got: %v", id, err)
+	}
+	ctx := WithApproval(context.Background(), id)
+	if err := e.Check(ctx, "db", "TRUNCATE t"); err == nil {
+		t.Errorf("expected an error before the approval is approved")
+	}
+	if _, err := e.Approve(id, "admin"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the approval is of the SQL, connection and principal it was requested
+	// for, and used up once the SQL runs
+	for _, test := range []struct {
+		connection, sql string
+	}{
+		{"db", "TRUNCATE u"},
+		{"other", "TRUNCATE t"},
+	} {
+		if err := e.Check(ctx, test.connection, test.sql); err == nil {
+			t.Errorf("%s %q expected an error", test.connection, test.sql)
+		}
+	}
+	if err := e.Check(WithPrincipal(ctx, "bob"), "db", "TRUNCATE t"); err == nil {
+		t.Errorf("expected an error for another principal")
+	}
+	if err := e.Check(ctx, "db", "TRUNCATE t"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if err := e.Check(ctx, "db", "TRUNCATE t"); err == nil {
+		t.Errorf("expected the approval to be used up")
+	}
+	if _, err := e.Approve(id, "admin"); err == nil {
+		t.Errorf("expected an error approving a used approval")
+	}
+}
+
+const testPatternPolicies = `
+name: no-sleep
+rules:
+  - action: deny
+    patterns: ["(?i)pg_sleep"]
+---
+name: reporting-only
+connections: [reports]
+rules:
+  - action: allow
+    statements: [read]
+    patterns: ["(?i)\\breporting\\."]
+  - action: deny
+    message: only the reporting schema can be queried
+`
+
+func TestPatterns(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testPatternPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := []struct {
+		connection string
+		sql        string
+		policy     string
+	}{
+		{"db", "SELECT 1", ""},
+		{"db", "SELECT PG_SLEEP(10)", "no-sleep"},
+		{"reports", "SELECT * FROM reporting.sales", ""},
+		{"reports", "SELECT * FROM public.users", "reporting-only"},
+		{"reports", "DELETE FROM reporting.sales", "reporting-only"},
+		{"reports", "SELECT pg_sleep(1) FROM reporting.sales", "no-sleep"},
+	}
+	for _, test := range tests {
+		err := e.Check(context.Background(), test.connection, test.sql)
+		var v *Violation
+		switch {
+		case test.policy == "" && err != nil:
+			t.Errorf("%s %q expected no error, got: %v", test.connection, test.sql, err)
+		case test.policy != "" && !errors.As(err, &v):
+			t.Errorf("%s %q expected *Violation, got: %v", test.connection, test.sql, err)
+		case test.policy != "" && v.Policy != test.policy:
+			t.Errorf("%s %q expected policy %s, got: %s", test.connection, test.sql, test.policy, v.Policy)
+		}
+	}
+}
+
+func TestAssessRisk(t *testing.T) {
+	tests := []struct {
+		sql      string
+		level    string
+		scope    string
+		warnings int
+	}{
+		{"SELECT 1", RiskReadOnly, ScopeRows, 0},
+		{"SELECT * FROM t", RiskReadOnly, ScopeTable, 0},
+		{"SELECT * FROM t WHERE a IN (SELECT b FROM u)", RiskReadOnly, ScopeRows, 0},
+		{"UPDATE t SET a = 1 WHERE b = 2", RiskRowMutating, ScopeRows, 0},
+		{"DELETE FROM t WHERE a IN (SELECT b FROM u)", RiskRowMutating, ScopeRows, 0},
+		{"WITH x AS (SELECT b FROM u WHERE c) DELETE FROM t", RiskRowMutating, ScopeTable, 1},
+		{"SELECT 1; TRUNCATE t", RiskSchemaMutating, ScopeTable, 1},
+		{"DROP SCHEMA app CASCADE", RiskSchemaMutating, ScopeDatabase, 1},
+		{"CREATE INDEX i ON t (a)", RiskSchemaMutating, ScopeTable, 0},
+		{"GRANT SELECT ON t TO u", RiskServerAdmin, ScopeServer, 0},
+		{"VACUUM", RiskServerAdmin, ScopeServer, 0},
+	}
+	for _, test := range tests {
+		risk := AssessRisk(test.sql)
+		if risk.Level != test.level || risk.Scope != test.scope || len(risk.Warnings) != test.warnings {
+			t.Errorf("%q expected %s/%s with %d warnings, got: %+v", test.sql, test.level, test.scope, test.warnings, risk)
+		}
+	}
+}
+
+const testMaskPolicies = `
+name: pii
+rules: []
+masks:
+  - columns: ["*_ssn"]
+    method: partial
+  - patterns: ["(?i)^e-?mail$"]
+    method: hash
+---
+name: contractors
+principals: ["contractor-*"]
+rules: []
+masks:
+  - columns: [Customer_SSN]
+    method: "null"
+`
+
+func TestMasks(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testMaskPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if (*Engine)(nil).Masker("db", "") != nil {
+		t.Errorf("expected a nil engine to mask nothing")
+	}
+
+	columns := []string{"id", "customer_ssn", "EMAIL"}
+	row := func() []interface{} { return []interface{}{int64(1), "123-45-6789", "a@example.com"} }
+	tests := []struct {
+		principal string
+		exp       []interface{}
+	}{
+		{"", []interface{}{int64(1), "*******6789", "08168cd80dfd534ab0f10af10f1303fe00af2d43ab5c1432360d137f8197e17a"}},
+		{"contractor-1", []interface{}{int64(1), nil, "08168cd80dfd534ab0f10af10f1303fe00af2d43ab5c1432360d137f8197e17a"}},
+	}
+	for _, test := range tests {
+		masks := e.Masker("db", test.principal).Columns(columns)
+		v := row()
+		masks.Row(v)
+		if !reflect.DeepEqual(v, test.exp) {
+			t.Errorf("%q expected %v, got: %v", test.principal, test.exp, v)
+		}
+		if types := masks.JSONTypes([]string{"integer", "string", "string"}); types[0] != "integer" {
+			t.Errorf("%q expected unmasked columns to keep their type, got: %v", test.principal, types)
+		}
+	}
+	if masks := e.Masker("db", "").Columns([]string{"id", "name"}); masks != nil {
+		t.Errorf("expected no masks, got: %v", masks)
+	}
+	if v := maskValue(MaskPartial, "abc"); v != "***" {
+		t.Errorf("expected short values to be masked entirely, got: %v", v)
+	}
+}
+
+const testFilterPolicies = `
+name: tenants
+principals: ["tenant-*"]
+rules: []
+filters:
+  - tables: [orders, "sales.*"]
+    predicate: "tenant_id = :token.tenant"
+  - tables: [orders]
+    predicate: "NOT deleted"
+`
+
+func TestRestrictRows(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testFilterPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	ctx := WithAttributes(WithPrincipal(context.Background(), "tenant-1"), map[string]string{"tenant": "o'k"})
+	const orders = "(SELECT * FROM orders WHERE (tenant_id = 'o''k') AND (NOT deleted))"
+	tests := []struct {
+		sql, exp string
+	}{
+		{"SELECT * FROM users", "SELECT * FROM users"},
+		{"SELECT * FROM orders", "SELECT * FROM " + orders + " orders"},
+		{"SELECT o.id FROM orders AS o WHERE o.id = ?", "SELECT o.id FROM " + orders + " AS o WHERE o.id = ?"},
+		{"SELECT * FROM users u JOIN Orders ON u.id = orders.user_id", "SELECT * FROM users u JOIN (SELECT * FROM Orders WHERE (tenant_id = 'o''k') AND (NOT deleted)) Orders ON u.id = orders.user_id"},
+		{"SELECT * FROM users, orders o", "SELECT * FROM users, " + orders + " o"},
+		{"SELECT * FROM sales.items", "SELECT * FROM (SELECT * FROM sales.items WHERE (tenant_id = 'o''k')) items"},
+		{"SELECT * FROM (SELECT id FROM orders) x", "SELECT * FROM (SELECT id FROM " + orders + " orders) x"},
+		{"SELECT EXTRACT(YEAR FROM orders) FROM t", "SELECT EXTRACT(YEAR FROM orders) FROM t"},
+		{"SELECT 'FROM orders'", "SELECT 'FROM orders'"},
+		{"INSERT INTO archive SELECT * FROM orders", "INSERT INTO archive SELECT * FROM " + orders + " orders"},
+	}
+	for _, test := range tests {
+		sql, applied, err := e.Restrict(ctx, "db", test.sql)
+		switch {
+		case err != nil:
+			t.Errorf("%q expected no error, got: %v", test.sql, err)
+		case sql != test.exp:
+			t.Errorf("%q expected %q, got: %q", test.sql, test.exp, sql)
+		case (sql != test.sql) != (len(applied) == 1):
+			t.Errorf("%q expected the applied policies, got: %v", test.sql, applied)
+		}
+	}
+
+	// modifying filtered tables, or filtering without the attributes, fails
+	for _, sql := range []string{"DELETE FROM orders", "UPDATE orders SET a = 1", "INSERT INTO sales.items VALUES (1)"} {
+		var fe *FilterError
+		if _, _, err := e.Restrict(ctx, "db", sql); !errors.As(err, &fe) {
+			t.Errorf("%q expected *FilterError, got: %v", sql, err)
+		}
+	}
+	if _, _, err := e.Restrict(WithPrincipal(context.Background(), "tenant-2"), "db", "SELECT * FROM orders"); err == nil {
+		t.Errorf("expected an error without the tenant attribute")
+	}
+	if sql, _, err := e.Restrict(context.Background(), "db", "DELETE FROM orders"); err != nil || sql != "DELETE FROM orders" {
+		t.Errorf("expected no filters for other principals, got: %q %v", sql, err)
+	}
+}
+
+const testColumnPolicies = `
+name: support
+principals: ["support-*"]
+rules: []
+columns:
+  - tables: [users]
+    allow: [id, name, email, plan]
+  - tables: ["users"]
+    allow: [id, Name, email]
+  - tables: [payments]
+    deny: [card_number]
+`
+
+func TestRestrictColumns(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testColumnPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	ctx := WithPrincipal(context.Background(), "support-1")
+	tests := []struct {
+		sql, exp string
+		err      bool
+	}{
+		{"SELECT * FROM users", "SELECT * FROM (SELECT id, name, email FROM users) users", false},
+		{"SELECT u.plan FROM app.users u", "SELECT u.plan FROM (SELECT id, name, email FROM app.users) u", false},
+		{"SELECT id, amount, count(*) FROM payments GROUP BY id", "SELECT id, amount, count(*) FROM payments GROUP BY id", false},
+		{"SELECT p.card_number FROM payments p", "", true},
+		{"SELECT p.* FROM payments p", "", true},
+		{"UPDATE users SET name = 'x'", "", true},
+	}
+	for _, test := range tests {
+		sql, _, err := e.Restrict(ctx, "db", test.sql)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("%q expected error", test.sql)
+		case !test.err && err != nil:
+			t.Errorf("%q expected no error, got: %v", test.sql, err)
+		case sql != test.exp:
+			t.Errorf("%q expected %q, got: %q", test.sql, test.exp, sql)
+		}
+	}
+	if sql, _, err := e.Restrict(context.Background(), "db", "SELECT card_number FROM payments"); err != nil || sql != "SELECT card_number FROM payments" {
+		t.Errorf("expected no restrictions for other principals, got: %q %v", sql, err)
+	}
+}
+
+const testPIIPolicies = `
+name: flag-pii
+rules: []
+pii:
+  action: flag
+---
+name: redact-cards
+connections: [payments]
+rules: []
+pii:
+  types: [credit_card]
+  action: redact
+`
+
+func TestPII(t *testing.T) {
+	policies, err := Parse(strings.NewReader(testPIIPolicies))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	e, err := NewEngine(policies, Allow)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	columns := []string{"id", "note"}
+	rows := func() [][]interface{} {
+		return [][]interface{}{
+			{int64(1), "mail a@example.com or b@example.org"},
+			{int64(4111111111111111), "card 4111 1111 1111 1111, ssn 123-45-6789"},
+			{int64(2), "order 4111 1111 1111 1112, ssn 666-45-6789"},
+		}
+	}
+
+	// PII is flagged, and left as is
+	masks := e.Masker("db", "").Columns(columns)
+	v := rows()
+	for _, row := range v {
+		masks.Row(row)
+	}
+	exp := []PIIFinding{{"note", PIIEmail, 2, false}, {"note", PIICreditCard, 1, false}, {"note", PIINationalID, 1, false}}
+	if findings := masks.Findings(); !reflect.DeepEqual(findings, exp) {
+		t.Errorf("expected findings %v, got: %v", exp, findings)
+	}
+	if !reflect.DeepEqual(v, rows()) {
+		t.Errorf("expected flagged values to be left as is, got: %v", v)
+	}
+	if findings := masks.Findings(); findings != nil {
+		t.Errorf("expected findings to be reported once, got: %v", findings)
+	}
+
+	// types are redacted when any applicable policy redacts them
+	masks = e.Masker("payments", "").Columns(columns)
+	v = rows()
+	masks.Row(v[1])
+	if exp := "card [redacted credit_card], ssn 123-45-6789"; v[1][1] != exp {
+		t.Errorf("expected %q, got: %q", exp, v[1][1])
+	}
+	exp = []PIIFinding{{"note", PIICreditCard, 1, true}, {"note", PIINationalID, 1, false}}
+	if findings := masks.Findings(); !reflect.DeepEqual(findings, exp) {
+		t.Errorf("expected findings %v, got: %v", exp, findings)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	ctx := context.Background()
+	if !AllowsTool(ctx, "execute_query", ToolQuery) || !AllowsConnection(ctx, "prod") {
+		t.Errorf("expected everything to be allowed without scopes")
+	}
+	ctx = WithScopes(ctx, []string{"query", "tool:export_*", "connection:reporting-*", "connection:dev"})
+	tests := []struct {
+		tool, connection string
+		exp              bool
+	}{
+		{"export_xlsx", "", true},
+		{"execute_query", "", false},
+		{"", "reporting-eu", true},
+		{"", "dev", true},
+		{"", "prod", false},
+	}
+	for i, test := range tests {
+		if test.tool != "" && AllowsTool(ctx, test.tool, ToolQuery) != test.exp {
+			t.Errorf("test %d: expected tool %s allowed %t", i, test.tool, test.exp)
+		}
+		if test.connection != "" && AllowsConnection(ctx, test.connection) != test.exp {
+			t.Errorf("test %d: expected connection %s allowed %t", i, test.connection, test.exp)
+		}
+	}
+	// scopes restrict only what they grant
+	ctx = WithScopes(context.Background(), []string{"connection:dev"})
+	if !AllowsTool(ctx, "execute_query", ToolQuery) {
+		t.Errorf("expected all tools to be allowed")
+	}
+}
+
+func TestTenants(t *testing.T) {
+	ctx := context.Background()
+	if id := QualifyConnection(ctx, "prod"); id != "prod" || !InTenant(ctx, "payments/prod") {
+		t.Errorf("expected connections not to be namespaced without a tenant, got: %s", id)
+	}
+	ctx = WithTenant(ctx, "payments")
+	tests := []struct {
+		id, exp string
+	}{
+		{"prod", "payments/prod"},
+		{"payments/prod", "payments/prod"},
+		{"billing/prod", "payments/billing/prod"},
+	}
+	for _, test := range tests {
+		if id := QualifyConnection(ctx, test.id); id != test.exp {
+			t.Errorf("expected %s to be qualified as %s, got: %s", test.id, test.exp, id)
+		}
+	}
+	if !InTenant(ctx, "payments/prod") || InTenant(ctx, "billing/prod") || InTenant(ctx, "prod") {
+		t.Errorf("expected only the tenant's connections to be in the tenant")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		"rules: []",
+		"name: x\nrules:\n  - action: maybe",
+		"name: x\nunknown: 1",
+		"name: x\nprincipals: [\"[\"]\nrules: []",
+		"name: x\nrules:\n  - action: deny\n    patterns: [\"(\"]",
+		"name: x\nrules: []\nmasks:\n  - columns: [a]\n    method: scramble",
+		"name: x\nrules: []\nmasks:\n  - method: hash",
+		"name: x\nrules: []\nfilters:\n  - tables: [t]",
+		"name: x\nrules: []\ncolumns:\n  - tables: [t]",
+		"name: x\nrules: []\npii:\n  action: hide",
+		"name: x\nrules: []\npii:\n  types: [phone]\n  action: flag",
+		"name: x\nrules: []\ncolumns:\n  - tables: [t]\n    allow: [\"a, b\"]",
+	} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("%q expected error", s)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// Risk levels, from the least to the most risky.
+const (
+	RiskReadOnly       = "read-only"
+	RiskRowMutating    = "row-mutating"
+	RiskSchemaMutating = "schema-mutating"
+	RiskServerAdmin    = "server-admin"
+)
+
+// Risk scopes, from the narrowest to the widest.
+const (
+	// ScopeRows is the rows selected by the statement's filter.
+	ScopeRows = "rows"
+	// ScopeTable is all rows of the statement's tables, or the tables
+	// themselves.
+	ScopeTable = "table"
+	// ScopeDatabase is whole schemas or databases.
+	ScopeDatabase = "database"
+	// ScopeServer is the database server, such as its users and settings.
+	ScopeServer = "server"
+)
+
+var (
+	riskLevels  = []string{RiskReadOnly, RiskRowMutating, RiskSchemaMutating, RiskServerAdmin}
+	riskScopes  = []string{ScopeRows, ScopeTable, ScopeDatabase, ScopeServer}
+	riskObjects = map[string]string{"SCHEMA": ScopeDatabase, "DATABASE": ScopeDatabase}
+)
+
+// Risk is the risk classification of SQL, for clients to warn about risky
+// statements consistently. The level and scope are those of the riskiest
+// of its statements.
+type Risk struct {
+	Level      string   `json:"level"`
+	Scope      string   `json:"scope"`
+	Statements int      `json:"statements"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// AssessRisk classifies the risk of the statements in sql, from their
+// category, and estimates their scope from whether they are filtered.
+func AssessRisk(sql string) *Risk {
+	risk := &Risk{Level: RiskReadOnly, Scope: ScopeRows}
+	for _, stmt := range sqlscan.Split(sql) {
+		level, scope, warning := assessStatement(stmt)
+		if slices.Index(riskLevels, level) > slices.Index(riskLevels, risk.Level) {
+			risk.Level = level
+		}
+		if slices.Index(riskScopes, scope) > slices.Index(riskScopes, risk.Scope) {
+			risk.Scope = scope
+		}
+		if warning != "" && !slices.Contains(risk.Warnings, warning) {
+			risk.Warnings = append(risk.Warnings, warning)
+		}
+		risk.Statements++
+	}
+	return risk
+}
+
+// assessStatement returns the risk level and scope of a single statement,
+// and a warning about it, if any.
+func assessStatement(stmt string) (string, string, string) {
+	typ, category := Classify(stmt)
+	words := sqlscan.Words(stmt)
+	switch category {
+	case CategoryRead, CategoryTCL:
+		if has(words, "FROM") && !has(words, "WHERE", "LIMIT", "TOP", "FETCH") {
+			return RiskReadOnly, ScopeTable, ""
+		}
+		return RiskReadOnly, ScopeRows, ""
+	case CategoryDML:
+		if (typ == "UPDATE" || typ == "DELETE") && !has(words, "WHERE") {
+			return RiskRowMutating, ScopeTable, typ + " without a WHERE clause affects every row"
+		}
+		return RiskRowMutating, ScopeRows, ""
+	case CategoryDDL:
+		scope := ScopeTable
+		for _, t := range words[1:] {
+			if s, ok := riskObjects[strings.ToUpper(t.Text)]; ok && t.Kind == sqlscan.Word {
+				scope = s
+				break
+			}
+		}
+		switch typ {
+		case "DROP":
+			return RiskSchemaMutating, scope, "DROP cannot be undone"
+		case "TRUNCATE":
+			return RiskSchemaMutating, scope, "TRUNCATE removes every row, and cannot be undone"
+		}
+		return RiskSchemaMutating, scope, ""
+	}
+	return RiskServerAdmin, ScopeServer, ""
+}
+
+// has reports whether any of the keywords is a word of the statement, outside
+// parentheses.
+func has(words []sqlscan.Token, keywords ...string) bool {
+	depth := 0
+	for _, t := range words {
+		switch {
+		case t.Text == "(":
+			depth++
+		case t.Text == ")":
+			depth--
+		case depth == 0 && t.Kind == sqlscan.Word:
+			for _, keyword := range keywords {
+				if t.Is(keyword) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+// Scope prefixes of the scopes granting an authenticated principal tools and
+// connections, such as tool:execute_query or connection:reporting-*.
+const (
+	ToolScopePrefix       = "tool:"
+	ConnectionScopePrefix = "connection:"
+)
+
+// Tool classes, by what tools do with connections.
+const (
+	// ToolQuery is the class of the tools reading data, such as
+	// execute_query, cursors and exports.
+	ToolQuery = "query"
+	// ToolStatement is the class of the tools executing statements, such as
+	// execute_statement and call_procedure.
+	ToolStatement = "statement"
+	// ToolAdmin is the class of the tools managing connections, such as
+	// create_connection.
+	ToolAdmin = "admin"
+)
+
+// Access is the tools and connections a principal may use, such as by its
+// roles.
+type Access interface {
+	// AllowsTool reports whether the tool of the class may be called.
+	AllowsTool(name, class string) bool
+	// AllowsConnection reports whether the connection (or connection group)
+	// with the ID may be used.
+	AllowsConnection(id string) bool
+}
+
+// WithAccess returns a copy of ctx carrying the access of the authenticated
+// principal, further restricting the tools and connections its scopes
+// grant.
+func WithAccess(ctx context.Context, access Access) context.Context {
+	return context.WithValue(ctx, accessKey, access)
+}
+
+// WithScopes returns a copy of ctx carrying the scopes of the authenticated
+// principal, restricting the tools and connections it can use.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// ScopesFrom returns the principal scopes of ctx, or nil when there are
+// none.
+func ScopesFrom(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesKey).([]string)
+	return scopes
+}
+
+// AllowsTool reports whether the principal of ctx may call the tool of the
+// class: any tool its access allows, unless its scopes grant tools by glob
+// patterns, such as tool:export_*.
+func AllowsTool(ctx context.Context, name, class string) bool {
+	if access, ok := ctx.Value(accessKey).(Access); ok && !access.AllowsTool(name, class) {
+		return false
+	}
+	return granted(ScopesFrom(ctx), ToolScopePrefix, name)
+}
+
+// AllowsConnection reports whether the principal of ctx may use the
+// connection (or connection group) with the ID: any connection its access
+// allows, unless its scopes grant connections by glob patterns, such as
+// connection:reporting-*.
+func AllowsConnection(ctx context.Context, id string) bool {
+	if access, ok := ctx.Value(accessKey).(Access); ok && !access.AllowsConnection(id) {
+		return false
+	}
+	return granted(ScopesFrom(ctx), ConnectionScopePrefix, id)
+}
+
+// granted reports whether the scopes with the prefix match the name, or
+// there are none.
+func granted(scopes []string, prefix, name string) bool {
+	restricted := false
+	for _, scope := range scopes {
+		pattern, ok := strings.CutPrefix(scope, prefix)
+		if !ok {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		restricted = true
+	}
+	return !restricted
+}
@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"context"
+	"strings"
+)
+
+// TenantSeparator separates the tenant of a tenant's connection ID from the
+// ID the tenant refers to it by, as in payments/reporting.
+const TenantSeparator = "/"
+
+// WithTenant returns a copy of ctx carrying the tenant of the authenticated
+// principal, whose connections are isolated from those of other tenants.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// TenantFrom returns the tenant of ctx, or "" when there is none.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
+
+// QualifyConnection returns the ID of the connection the principal of ctx
+// refers to by the ID: the ID in its tenant's namespace, prefixed by the
+// tenant, unless already prefixed. Without a tenant, the ID is returned
+// unchanged.
+func QualifyConnection(ctx context.Context, id string) string {
+	tenant := TenantFrom(ctx)
+	if tenant == "" || strings.HasPrefix(id, tenant+TenantSeparator) {
+		return id
+	}
+	return tenant + TenantSeparator + id
+}
+
+// InTenant reports whether the connection with the ID is in the namespace
+// of the tenant of ctx, or there is no tenant.
+func InTenant(ctx context.Context, id string) bool {
+	tenant := TenantFrom(ctx)
+	return tenant == "" || strings.HasPrefix(id, tenant+TenantSeparator)
+}
@@ -3,16 +3,29 @@ package server
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xo/dburl"
 	"github.com/xo/usql/drivers"
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/hooks"
+	"github.com/xo/usql/server/policy"
 )
 
 // ConnectionInterface defines the interface for database connections.
+//
+// QueryRows iterates over a query's rows as they are read from the
+// database, for consumers writing them out incrementally, while ExecuteQuery
+// returns the whole result, truncated at the server's result caps.
 type ConnectionInterface interface {
+	QueryRows(ctx context.Context, query string, args ...interface{}) (*RowIterator, error)
 	ExecuteQuery(ctx context.Context, query string, args ...interface{}) (*QueryResult, error)
 	ExecuteStatement(ctx context.Context, query string, args ...interface{}) (*StatementResult, error)
 }
@@ -21,8 +34,43 @@ type ConnectionInterface interface {
 type ConnectionPool struct {
 	mu          sync.RWMutex
 	connections map[string]*Connection
-	maxConns    int
-	config      *Config
+	aliases     map[string]string
+	conf        atomic.Pointer[Config]
+	faults      *FaultInjector
+	cursors     *CursorManager
+	sessions    *SessionManager
+	spill       *spiller
+	jobs        *JobManager
+	throttle    *Throttle
+	redis       *redisStore
+	policy      *policy.Engine
+	cost        *CostGuard
+	quota       *QuotaGuard
+	hooks       *hooks.Engine
+	cache       *ResultCache
+	secrets     *Secrets
+	audit       *audit.Logger
+	slow        *slowLog
+	history     *queryHistory
+	stats       *queryStats
+
+	// rotations are the counters of the groups routing round-robin, by
+	// group ID
+	rotations sync.Map
+
+	// stop stops the reaper
+	stop chan struct{}
+	// reaper holds the statistics of the reaper
+	reaper reaperStats
+
+	// listChanged is called once connections were added or removed
+	listChanged func()
+
+	// redefine is called once the definition of connections changed
+	redefine func()
+
+	// failedOver is called once a connection failed over to another DSN
+	failedOver func(FailoverEvent)
 }
 
 // Connection represents a database connection with its associated handler.
@@ -33,64 +81,321 @@ type Connection struct {
 	Created  time.Time
 	LastUsed time.Time
 	mu       sync.RWMutex
+	tags     map[string]string
+	active   atomic.Int64
+	faults   *FaultInjector
+	throttle *Throttle
+	policy   *policy.Engine
+	cost     *CostGuard
+	quota    *QuotaGuard
+	hooks    *hooks.Engine
+	cache    *ResultCache
+	audit    *audit.Logger
+	slow     *slowLog
+	history  *queryHistory
+	stats    *queryStats
+	stmts    *stmtCache
+	dsn      string
+
+	serverVersion string
+
+	// maxStmts is the number of prepared statements cached
+	maxStmts int
+
+	// lazy is whether the connection was registered without connecting
+	lazy bool
+
+	// predefined is the config the connection is defined by, if defined in
+	// the config, in which case it is never reaped or evicted
+	predefined *ConnectionConfig
+
+	// settings are the settings of the connection's database pool
+	settings PoolSettings
+
+	// tls are the TLS settings the connection was created with, if any
+	tls *DatabaseTLS
+
+	// failover are the DSNs the connection fails over to, in order, and
+	// dsnIndex the index of the DSN it is connected to, 0 being its own DSN
+	failover []string
+	dsnIndex int
+
+	// secrets releases the leases on the secrets resolved in the DSN
+	secrets func()
+
+	// auth generates the tokens the connection authenticates with, when
+	// authenticating with short-lived tokens rather than a password
+	auth TokenSource
+
+	// route dials the database when it is reached through a tunnel rather
+	// than directly, such as Cloud SQL instances or databases behind an SSH
+	// jump host, and tunnel is the tunnel of the open database
+	route  dialer
+	tunnel *tunnel
+
+	// pending is whether the database is yet to be opened, on first use,
+	// guarded by dialMu while it is opened
+	pending atomic.Bool
+	dialMu  sync.Mutex
+	closed  bool
+
+	// timeouts is whether query deadlines are set as database-side timeouts
+	timeouts bool
+
+	// limits caps the rows and bytes read into query results
+	limits ResultLimits
+
+	// queries and queryErrors count the queries and statements executed,
+	// and those failed or denied
+	queries     atomic.Int64
+	queryErrors atomic.Int64
+
+	// replicas are further instances of the connection, across which read
+	// queries are balanced
+	replicaMu sync.RWMutex
+	replicas  []*Connection
+	health    instanceHealth
+
+	// monitor is the state of the connection's periodic health checks
+	monitor connHealth
+
+	diagMu  sync.Mutex
+	suspect bool
+	panics  []PanicRecord
 }
 
-// NewConnectionPool creates a new connection pool.
-func NewConnectionPool(config *Config) *ConnectionPool {
-	return &ConnectionPool{
+// NewConnectionPool creates a new connection pool, enforcing the statement
+// policies of the engine, and running the hooks of the hook engine. A nil
+// engine allows all statements, and a nil hook engine runs no hooks.
+func NewConnectionPool(config *Config, engine *policy.Engine, hookEngine *hooks.Engine) *ConnectionPool {
+	cluster, err := newRedisStore(config.Redis)
+	if err != nil {
+		poolLog.Error("invalid Redis URL, limiting and counting queries per server", "error", err)
+	}
+	cursors := NewCursorManager(config.Server.CursorTTL, config.Server.MaxCursors)
+	jobs := NewJobManager(config.Jobs)
+	cp := &ConnectionPool{
 		connections: make(map[string]*Connection),
-		maxConns:    config.Server.MaxConnections,
-		config:      config,
+		aliases:     make(map[string]string),
+		faults:      NewFaultInjector(config.Faults),
+		cursors:     cursors,
+		sessions:    NewSessionManager(config.Server, cursors, jobs),
+		spill:       newSpiller(config.Server, cursors),
+		jobs:        jobs,
+		throttle:    newThrottle(config.Server, cluster),
+		redis:       cluster,
+		policy:      engine,
+		cost:        NewCostGuard(config.Cost),
+		quota:       newQuotaGuard(config.Quotas, newQuotaStore(cluster)),
+		hooks:       hookEngine,
+		cache:       NewResultCache(config.Cache),
+		stats:       newQueryStats(config.Server),
+		secrets:     newSecrets(config.Secrets),
+		stop:        make(chan struct{}),
+	}
+	cp.conf.Store(config)
+	cp.slow = &slowLog{config: cp.config}
+	go cp.reap()
+	if config.Server.HealthCheckInterval > 0 {
+		go cp.monitor()
+	}
+	return cp
+}
+
+// config returns the pool's current configuration, which is replaced rather
+// than modified when reloaded.
+func (cp *ConnectionPool) config() *Config {
+	return cp.conf.Load()
+}
+
+// CreateConnection creates a new database connection and adds it to the pool,
+// with the TLS settings, if any.
+func (cp *ConnectionPool) CreateConnection(ctx context.Context, id, dsn string, tls *DatabaseTLS) (ConnectionInterface, error) {
+	conn, err := cp.create(ctx, id, dsn, connOptions{tls: tls})
+	if err != nil {
+		return nil, err
 	}
+	cp.changed()
+	return conn, nil
+}
+
+// RegisterConnection adds a connection to the database at the DSN to the
+// pool without connecting to the database, which is opened when the
+// connection is first used.
+func (cp *ConnectionPool) RegisterConnection(ctx context.Context, id, dsn string, tls *DatabaseTLS) error {
+	if _, err := cp.create(ctx, id, dsn, connOptions{lazy: true, tls: tls}); err != nil {
+		return err
+	}
+	cp.changed()
+	return nil
 }
 
-// CreateConnection creates a new database connection and adds it to the pool.
-func (cp *ConnectionPool) CreateConnection(ctx context.Context, id, dsn string) (ConnectionInterface, error) {
+// connOptions are the options a connection is created with.
+type connOptions struct {
+	// lazy registers the connection without connecting
+	lazy bool
+	// predefined is the config defining the connection, if any
+	predefined *ConnectionConfig
+	settings   PoolSettings
+	// tls are the TLS settings of the database connections, if any
+	tls *DatabaseTLS
+	// failover are the DSNs the connection fails over to, if any
+	failover []string
+}
+
+// create opens a database connection, or only registers it when lazy, and
+// adds it to the pool.
+func (cp *ConnectionPool) create(ctx context.Context, id, dsn string, opts connOptions) (conn *Connection, err error) {
+	started := time.Now()
+	defer func() {
+		detail := "connected"
+		if opts.lazy {
+			detail = "registered"
+		}
+		cp.auditConnection(ctx, audit.ConnectionOpen, id, started, err, detail)
+	}()
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
 
 	// Check if connection already exists
-	if _, exists := cp.connections[id]; exists {
+	if cp.taken(id) {
 		return nil, fmt.Errorf("connection with ID %s already exists", id)
 	}
 
-	// Check pool size limit
-	if len(cp.connections) >= cp.maxConns {
-		return nil, fmt.Errorf("connection pool limit reached (max: %d)", cp.maxConns)
+	// Check pool size limit, making room when evicting
+	config := cp.config()
+	if maxConns := config.Server.MaxConnections; len(cp.connections) >= maxConns {
+		if config.Server.PoolLimitPolicy != PoolLimitEvictLRU {
+			return nil, fmt.Errorf("connection pool limit reached (max: %d)", maxConns)
+		}
+		evicted, ok := cp.evictLRU()
+		if !ok {
+			return nil, fmt.Errorf("connection pool limit reached (max: %d), and no connection is idle", maxConns)
+		}
+		poolLog.Info("evicted least recently used connection", "connection", evicted, "for", id)
 	}
 
-	// Parse DSN
-	u, err := dburl.Parse(dsn)
+	open := cp.open
+	if opts.lazy {
+		open = cp.register
+	}
+	conn, err = open(ctx, id, dsn, opts)
+	// a connection failing to connect connects to its failover DSNs instead
+	for i := 0; err != nil && !opts.lazy && i < len(opts.failover); i++ {
+		var ferr error
+		if conn, ferr = open(ctx, id, opts.failover[i], opts); ferr == nil {
+			poolLog.Warn("connection failed to connect, connected to failover DSN", "connection", id, "dsn", i+2, "error", err)
+			conn.dsn, conn.dsnIndex, err = dsn, i+1, nil
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse DSN: %w", err)
+		return nil, err
 	}
+	conn.lazy, conn.predefined = opts.lazy, opts.predefined
+	conn.pending.Store(opts.lazy)
 
-	// Open database connection using drivers directly
-	db, err := drivers.Open(ctx, u, nil, nil)
+	// Add to pool
+	cp.connections[id] = conn
+
+	return conn, nil
+}
+
+// open opens a database connection with the ID.
+func (cp *ConnectionPool) open(ctx context.Context, id, dsn string, opts connOptions) (*Connection, error) {
+	conn, err := cp.register(ctx, id, dsn, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database connection: %w", err)
+		return nil, err
+	}
+	if err := conn.connect(ctx); err != nil {
+		conn.releaseSecrets()
+		return nil, err
 	}
+	return conn, nil
+}
 
-	// Test connection
-	if err := db.PingContext(ctx); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+// register creates a connection with the ID to the database at the DSN,
+// without connecting to the database. Secret references in the DSN are
+// resolved, though the connection keeps the DSN as given.
+func (cp *ConnectionPool) register(ctx context.Context, id, dsn string, opts connOptions) (*Connection, error) {
+	resolved, release, err := cp.secrets.resolve(ctx, dsn, cp.config().Secrets.ClientRefs, opts.predefined != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse DSN
+	var auth TokenSource
+	var route dialer
+	u, err := dburl.Parse(resolved)
+	switch {
+	case err != nil && resolved != dsn:
+		// the error may contain the resolved secrets
+		err = errors.New("failed to parse DSN with its secret references resolved")
+	case err != nil:
+		err = fmt.Errorf("failed to parse DSN: %w", err)
+	case !drivers.Registered(u.Driver):
+		err = fmt.Errorf("driver %s is not available", u.Driver)
+	default:
+		var jump *SSHConfig
+		proxy := cp.config().Proxy
+		if opts.predefined != nil {
+			jump = opts.predefined.SSH
+			// a connection's own proxy is used for all its hosts
+			if opts.predefined.Proxy != "" {
+				proxy = ProxyConfig{URL: opts.predefined.Proxy}
+			}
+		}
+		if u, route, err = parseRoute(ctx, u, jump, proxy); err == nil {
+			if u, auth, err = parseAuth(ctx, u); err == nil {
+				var cleanup func()
+				if u, cleanup, err = cp.applyTLS(ctx, u, opts.tls, opts.predefined != nil); err == nil {
+					// the TLS files are removed with the secrets' leases
+					secrets := release
+					release = func() {
+						cleanup()
+						secrets()
+					}
+					err = connectionCreate(ctx, cp.hooks, id, u)
+				}
+			}
+		}
+	}
+	if err != nil {
+		release()
+		return nil, err
 	}
 
 	// Create connection object
-	conn := &Connection{
+	return &Connection{
 		ID:       id,
 		URL:      u,
-		DB:       db,
 		Created:  time.Now(),
 		LastUsed: time.Now(),
-	}
-
-
-	// Add to pool
-	cp.connections[id] = conn
+		faults:   cp.faults,
+		throttle: cp.throttle,
+		policy:   cp.policy,
+		cost:     cp.cost,
+		quota:    cp.quota,
+		hooks:    cp.hooks,
+		cache:    cp.cache,
+		audit:    cp.audit,
+		slow:     cp.slow,
+		history:  cp.history,
+		stats:    cp.stats,
+		dsn:      dsn,
+		settings: opts.settings,
+		secrets:  release,
+		auth:     auth,
+		route:    route,
+		tls:      opts.tls,
+		failover: opts.failover,
 
-	return conn, nil
+		maxStmts: cp.config().Server.MaxPreparedStatements,
+		timeouts: cp.config().Server.PropagateTimeouts,
+		limits: ResultLimits{
+			Rows:  cp.config().Server.MaxResultRows,
+			Bytes: cp.config().Server.MaxResultBytes,
+		},
+	}, nil
 }
 
 // GetConnection retrieves a connection from the pool.
@@ -98,7 +403,7 @@ func (cp *ConnectionPool) GetConnection(id string) (ConnectionInterface, error)
 	cp.mu.RLock()
 	defer cp.mu.RUnlock()
 
-	conn, exists := cp.connections[id]
+	conn, exists := cp.lookup(id)
 	if !exists {
 		return nil, fmt.Errorf("connection with ID %s not found", id)
 	}
@@ -114,23 +419,74 @@ func (cp *ConnectionPool) GetConnection(id string) (ConnectionInterface, error)
 // CloseConnection closes and removes a connection from the pool.
 func (cp *ConnectionPool) CloseConnection(id string) error {
 	cp.mu.Lock()
-	defer cp.mu.Unlock()
+	conn, exists := cp.lookup(id)
+	if exists {
+		cp.remove(conn.ID, conn, "closed")
+	}
+	cp.mu.Unlock()
 
-	conn, exists := cp.connections[id]
 	if !exists {
 		return fmt.Errorf("connection with ID %s not found", id)
 	}
+	cp.changed()
+	return nil
+}
+
+// remove closes the connection, with its open cursors, and removes it from
+// the pool, recording the reason in the audit log. The lock must be held.
+func (cp *ConnectionPool) remove(id string, conn *Connection, reason string) {
+	// Close open cursors and database connection
+	cp.cursors.CloseConnection(id)
+	conn.closeReplicas()
+	conn.closeDB()
 
-	// Close database connection
-	if conn.DB != nil {
-		conn.DB.Close()
+	// Remove from pool, with its aliases
+	delete(cp.connections, id)
+	for _, alias := range cp.aliasesOf(id) {
+		delete(cp.aliases, alias)
 	}
+	cp.faults.Reset(id)
+	cp.cache.Invalidate(id)
+	cp.auditConnection(context.Background(), audit.ConnectionClose, id, time.Now(), nil, reason)
+}
 
+// OnListChanged sets f to be called once connections were added to or
+// removed from the pool, including when idle or expired connections are
+// closed.
+func (cp *ConnectionPool) OnListChanged(f func()) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.listChanged = f
+}
 
-	// Remove from pool
-	delete(cp.connections, id)
+// changed calls the list changed func, if set, and the redefine func.
+func (cp *ConnectionPool) changed() {
+	cp.mu.RLock()
+	f := cp.listChanged
+	cp.mu.RUnlock()
+	if f != nil {
+		f()
+	}
+	cp.redefined()
+}
 
-	return nil
+// OnRedefined sets f to be called once the definitions of the pool's
+// connections changed, as connections were added, removed or renamed, or
+// their aliases, tags or replicas changed.
+func (cp *ConnectionPool) OnRedefined(f func()) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.redefine = f
+}
+
+// redefined calls the redefine func, if set.
+func (cp *ConnectionPool) redefined() {
+	cp.mu.RLock()
+	f := cp.redefine
+	cp.mu.RUnlock()
+	if f != nil {
+		f()
+	}
 }
 
 // ListConnections returns a list of all connection IDs and their basic info.
@@ -140,6 +496,7 @@ func (cp *ConnectionPool) ListConnections() map[string]ConnectionInfo {
 
 	result := make(map[string]ConnectionInfo, len(cp.connections))
 	for id, conn := range cp.connections {
+		suspect, _ := conn.Diagnostics()
 		conn.mu.RLock()
 		result[id] = ConnectionInfo{
 			ID:       conn.ID,
@@ -148,6 +505,11 @@ func (cp *ConnectionPool) ListConnections() map[string]ConnectionInfo {
 			Database: conn.URL.Path,
 			Created:  conn.Created,
 			LastUsed: conn.LastUsed,
+			Suspect:  suspect,
+			Aliases:  cp.aliasesOf(id),
+			Tags:     maps.Clone(conn.tags),
+			Pending:  conn.pending.Load(),
+			Health:   conn.Health(),
 		}
 		conn.mu.RUnlock()
 	}
@@ -155,45 +517,239 @@ func (cp *ConnectionPool) ListConnections() map[string]ConnectionInfo {
 	return result
 }
 
+// Definitions returns the definitions of the pool's connections, sorted by
+// ID.
+func (cp *ConnectionPool) Definitions() []ConnectionDefinition {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+
+	defs := make([]ConnectionDefinition, 0, len(cp.connections))
+	for id, conn := range cp.connections {
+		conn.mu.RLock()
+		def := ConnectionDefinition{ID: id, DSN: conn.dsn, Aliases: cp.aliasesOf(id), Tags: maps.Clone(conn.tags), Lazy: conn.lazy, TLS: conn.tls, Failover: conn.failover}
+		def.predefined = conn.predefined
+		if conn.settings != (PoolSettings{}) {
+			settings := conn.settings
+			def.Pool = &settings
+		}
+		conn.mu.RUnlock()
+		for _, replica := range conn.replicaList() {
+			def.Replicas = append(def.Replicas, replica.dsn)
+		}
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].ID < defs[j].ID
+	})
+	return defs
+}
+
+// ConnectionDefinition is the definition a connection was created from.
+type ConnectionDefinition struct {
+	ID       string            `json:"id"`
+	DSN      string            `json:"dsn"`
+	Replicas []string          `json:"replicas,omitempty"`
+	Failover []string          `json:"failover,omitempty"`
+	Aliases  []string          `json:"aliases,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+
+	// Lazy is whether the connection is registered without connecting.
+	Lazy bool `json:"lazy,omitempty"`
+
+	// Pool are the settings of the connection's database pool.
+	Pool *PoolSettings `json:"pool,omitempty"`
+
+	// TLS are the TLS settings of the connection's database connections.
+	TLS *DatabaseTLS `json:"tls,omitempty"`
+
+	// predefined is the config defining the connection, if any.
+	predefined *ConnectionConfig
+}
+
 // ConnectionInfo provides basic information about a connection.
 type ConnectionInfo struct {
-	ID       string    `json:"id"`
-	Driver   string    `json:"driver"`
-	Host     string    `json:"host"`
-	Database string    `json:"database"`
-	Created  time.Time `json:"created"`
-	LastUsed time.Time `json:"last_used"`
+	ID       string            `json:"id"`
+	Driver   string            `json:"driver"`
+	Host     string            `json:"host"`
+	Database string            `json:"database"`
+	Created  time.Time         `json:"created"`
+	LastUsed time.Time         `json:"last_used"`
+	Suspect  bool              `json:"suspect"`
+	Aliases  []string          `json:"aliases,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Pending  bool              `json:"pending,omitempty"`
+	Health   *HealthInfo       `json:"health,omitempty"`
+}
+
+// Cost returns the cost guard enforcing the connections' bytes scanned
+// budgets.
+func (cp *ConnectionPool) Cost() *CostGuard {
+	return cp.cost
+}
+
+// Quotas returns the quota guard enforcing the principals' usage quotas.
+func (cp *ConnectionPool) Quotas() *QuotaGuard {
+	return cp.quota
+}
+
+// Cache returns the result cache shared by the pool's connections.
+func (cp *ConnectionPool) Cache() *ResultCache {
+	return cp.cache
 }
 
 // CheckConnection tests if a connection is still alive.
 func (cp *ConnectionPool) CheckConnection(ctx context.Context, id string) error {
 	cp.mu.RLock()
-	conn, exists := cp.connections[id]
+	conn, exists := cp.lookup(id)
 	cp.mu.RUnlock()
-	
+
 	if !exists {
 		return fmt.Errorf("connection with ID %s not found", id)
 	}
 
+	if err := conn.dial(ctx); err != nil {
+		return err
+	}
 	return conn.DB.PingContext(ctx)
 }
 
 // Close closes all connections in the pool.
 func (cp *ConnectionPool) Close() error {
+	close(cp.stop)
+	cp.sessions.Shutdown()
+	cp.cursors.Shutdown()
+	cp.jobs.Shutdown()
+
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
 
 	var lastErr error
 	for id, conn := range cp.connections {
-		if err := conn.DB.Close(); err != nil {
+		conn.closeReplicas()
+		if err := conn.closeDB(); err != nil {
 			lastErr = err
 		}
 		delete(cp.connections, id)
+		cp.auditConnection(context.Background(), audit.ConnectionClose, id, time.Now(), nil, "shutdown")
+	}
+	if err := cp.audit.Close(); err != nil {
+		lastErr = err
+	}
+	if err := cp.redis.close(); err != nil {
+		lastErr = err
 	}
 
 	return lastErr
 }
 
+// OpenCursor executes a query on the specified connection, holding the rows
+// open server-side as a cursor.
+func (cp *ConnectionPool) OpenCursor(ctx context.Context, id, query string, args ...interface{}) (*Cursor, error) {
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("connection with ID %s not found", id)
+	}
+
+	return cp.cursors.Open(ctx, conn, query, args...)
+}
+
+// FetchCursor fetches up to count rows from a cursor on a connection the
+// principal of ctx may use.
+func (cp *ConnectionPool) FetchCursor(ctx context.Context, id string, count int) (*CursorPage, error) {
+	if cursor, ok := cp.cursors.get(id); !ok || !usable(ctx, cursor.ConnectionID) {
+		return nil, fmt.Errorf("cursor with ID %s not found", id)
+	}
+	return cp.cursors.Fetch(ctx, id, count)
+}
+
+// CloseCursor closes a cursor on a connection the principal of ctx may use.
+func (cp *ConnectionPool) CloseCursor(ctx context.Context, id string) error {
+	if cursor, ok := cp.cursors.get(id); !ok || !usable(ctx, cursor.ConnectionID) {
+		return fmt.Errorf("cursor with ID %s not found", id)
+	}
+	return cp.cursors.Close(id)
+}
+
+// SubmitJob queues a SQL query for asynchronous execution on the specified
+// connection.
+func (cp *ConnectionPool) SubmitJob(ctx context.Context, id, query string, args ...interface{}) (*JobInfo, error) {
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("connection with ID %s not found", id)
+	}
+
+	info, err := cp.jobs.Submit(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if s := sessionFrom(ctx); s != nil {
+		s.addJob(info.ID)
+	}
+	return info, nil
+}
+
+// JobStatus returns the status of a job on a connection the principal of ctx
+// may use, waiting up to wait for it to finish.
+func (cp *ConnectionPool) JobStatus(ctx context.Context, id string, wait time.Duration) (*JobInfo, error) {
+	if err := cp.usableJob(ctx, id); err != nil {
+		return nil, err
+	}
+	return cp.jobs.Status(ctx, id, wait)
+}
+
+// JobResult returns the result of a finished job on a connection the
+// principal of ctx may use.
+func (cp *ConnectionPool) JobResult(ctx context.Context, id string) (*JobInfo, *QueryResult, error) {
+	if err := cp.usableJob(ctx, id); err != nil {
+		return nil, nil, err
+	}
+	return cp.jobs.Result(id)
+}
+
+// CancelJob cancels a queued or running job on a connection the principal of
+// ctx may use.
+func (cp *ConnectionPool) CancelJob(ctx context.Context, id string) (*JobInfo, error) {
+	if err := cp.usableJob(ctx, id); err != nil {
+		return nil, err
+	}
+	return cp.jobs.Cancel(id)
+}
+
+// usableJob returns an error unless the job with the ID is on a connection
+// the principal of ctx may use, as if other principals' jobs did not exist.
+func (cp *ConnectionPool) usableJob(ctx context.Context, id string) error {
+	job, err := cp.jobs.get(id)
+	if err != nil {
+		return err
+	}
+	if !usable(ctx, job.ConnectionID) {
+		return fmt.Errorf("job with ID %s not found", id)
+	}
+	return nil
+}
+
+// usable reports whether the principal of ctx may use the connection with
+// the ID: the connection is in its tenant, and its scopes and roles allow it.
+func usable(ctx context.Context, id string) bool {
+	return policy.InTenant(ctx, id) && policy.AllowsConnection(ctx, id)
+}
+
+// Jobs returns the pool's job manager.
+func (cp *ConnectionPool) Jobs() *JobManager {
+	return cp.jobs
+}
+
+// Faults returns the fault injector shared by the pool's connections.
+func (cp *ConnectionPool) Faults() *FaultInjector {
+	return cp.faults
+}
+
 // Size returns the current number of connections in the pool.
 func (cp *ConnectionPool) Size() int {
 	cp.mu.RLock()
@@ -201,83 +757,410 @@ func (cp *ConnectionPool) Size() int {
 	return len(cp.connections)
 }
 
-// ExecuteQuery executes a SQL query on the specified connection.
+// ExecuteQuery executes a SQL query on the specified connection. The result
+// is truncated at the server's result caps.
 func (conn *Connection) ExecuteQuery(ctx context.Context, query string, args ...interface{}) (*QueryResult, error) {
-	conn.mu.Lock()
-	defer conn.mu.Unlock()
+	return conn.executeQuery(ctx, ResultLimits{}, nil, query, args...)
+}
 
-	conn.LastUsed = time.Now()
+// executeQuery executes a SQL query, truncating the result at the requested
+// limits, bounded by the server's result caps. Rows of the first result set
+// beyond the spiller's threshold are spilled to disk, when the spiller is not
+// nil. Results of read-only queries are cached, unless executed in a
+// session's transaction.
+func (conn *Connection) executeQuery(ctx context.Context, limits ResultLimits, spill *spiller, query string, args ...interface{}) (*QueryResult, error) {
+	limits = conn.limits.bound(limits)
+	if conn.transaction(ctx) != nil {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		conn.LastUsed = time.Now()
+		result, _, err := conn.query(ctx, limits, nil, query, args...)
+		return result, err
+	}
+	return conn.cached(ctx, query, args, 0, limits, func() (*QueryResult, error) {
+		inst := conn.instance(query)
 
-	// Execute query directly on database
-	rows, err := conn.DB.QueryContext(ctx, query, args...)
+		inst.mu.Lock()
+		defer inst.mu.Unlock()
+
+		inst.LastUsed = time.Now()
+
+		result, _, err := inst.query(ctx, limits, spill, query, args...)
+		return result, err
+	})
+}
+
+// cached returns the cached result of the query, when the result cache holds
+// it, or runs the query with run, caching its result unless more rows
+// remain to be fetched. Cached results are still subject to the statement
+// policies, and count against the quotas.
+func (conn *Connection) cached(ctx context.Context, query string, args []interface{}, maxRows int, limits ResultLimits, run func() (*QueryResult, error)) (*QueryResult, error) {
+	key, ok := conn.cache.key(conn.ID, policy.PrincipalFrom(ctx), query, args, maxRows, limits)
+	if !ok {
+		return run()
+	}
+	if result := conn.cache.Get(key); result != nil {
+		if err := conn.policy.Check(ctx, conn.ID, query); err != nil {
+			return nil, err
+		}
+		// cached results count against the quotas as the query would
+		done, err := conn.quota.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		done()
+		conn.quota.AddRows(ctx, int(resultRows(result)))
+		conn.touch()
+		return result, nil
+	}
+	result, err := run()
+	if err == nil && result.ContinuationToken == "" {
+		conn.cache.Put(conn.ID, key, result)
+	}
+	return result, err
+}
+
+// query executes a SQL query, reading rows up to the limits, and spilling
+// rows beyond the spiller's threshold. Reports whether rows were left unread
+// due to the limits.
+func (conn *Connection) query(ctx context.Context, limits ResultLimits, spill *spiller, query string, args ...interface{}) (result *QueryResult, _ bool, err error) {
+	timer := newQueryTimer(query)
+	defer func(query string, args []interface{}, started time.Time) {
+		rows := resultRows(result)
+		conn.checkSlow(ctx, timer, rows, err)
+		conn.recordQuery(ctx, audit.Query, query, args, started, rows, err)
+	}(query, args, time.Now())
+	defer conn.recoverPanic(query, &err)
+
+	query, hookRewrite, err := conn.preQuery(ctx, query, false)
 	if err != nil {
-		return nil, fmt.Errorf("query execution failed: %w", err)
+		return nil, false, err
 	}
-	defer rows.Close()
 
-	// Get column information
-	columns, err := rows.Columns()
+	if err := conn.policy.Check(ctx, conn.ID, query); err != nil {
+		return nil, false, err
+	}
+	query, restrictRewrite, err := conn.restrict(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get columns: %w", err)
+		return nil, false, err
+	}
+
+	if err := conn.dial(ctx); err != nil {
+		return nil, false, err
 	}
 
-	columnTypes, err := rows.ColumnTypes()
+	query, args, rewrites, err := bindArgs(conn.URL, query, args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get column types: %w", err)
+		return nil, false, err
+	}
+	if hookRewrite != "" {
+		rewrites = append(rewrites, hookRewrite)
+	}
+	if restrictRewrite != "" {
+		rewrites = append(rewrites, restrictRewrite)
 	}
 
-	// Prepare result structure
-	result := &QueryResult{
-		Columns:     columns,
-		ColumnTypes: make([]string, len(columnTypes)),
-		Rows:        [][]interface{}{},
+	timer.enter(phaseQueue)
+	release, err := conn.acquire(ctx)
+	timer.enter(phasePrepare)
+	if err != nil {
+		return nil, false, fmt.Errorf("query execution failed: %w", err)
 	}
+	defer conn.use(release)()
 
-	for i, ct := range columnTypes {
-		result.ColumnTypes[i] = ct.DatabaseTypeName()
+	if err := conn.faults.Inject(ctx, conn.ID); err != nil {
+		return nil, false, fmt.Errorf("query execution failed: %w", err)
 	}
 
-	// Read all rows
-	for rows.Next() {
-		// Create slice of interface{} to hold row values
-		values := make([]interface{}, len(columns))
-		scanArgs := make([]interface{}, len(columns))
-		for i := range values {
-			scanArgs[i] = &values[i]
-		}
+	if err := conn.cost.Check(ctx, conn, query, args); err != nil {
+		return nil, false, err
+	}
 
-		if err := rows.Scan(scanArgs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
+	dq, err := conn.withDeadline(ctx, query)
+	if err != nil {
+		return nil, false, err
+	}
+	defer dq.release()
+	if dq.rewrite != "" {
+		rewrites = append(rewrites, dq.rewrite)
+	}
+
+	// Execute query directly on database
+	timer.enter(phaseExecute)
+	executedAt := time.Now()
+	rows, err := dq.stmts(conn).QueryContext(ctx, dq.db, dq.query, args...)
+	conn.health.observe(executedAt, err)
+	timer.enter(phaseFetch)
+	if err != nil {
+		return nil, false, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	provenance := conn.provenance(query, executedAt, rewrites)
+	sets, truncated, err := conn.readResultSets(rows, limits, spill.forQuery(conn, query, provenance), conn.masker(ctx))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, set := range sets {
+		conn.quota.AddRows(ctx, len(set.Rows))
+	}
+	if len(sets) == 0 {
+		sets = append(sets, &QueryResult{
+			Columns:     []string{},
+			ColumnTypes: []string{},
+			Rows:        [][]interface{}{},
+		})
+	}
+
+	result = sets[0]
+	if len(sets) > 1 {
+		result.MoreResultSets = sets[1:]
+	}
+	if err := conn.postResult(ctx, query, result); err != nil {
+		return nil, false, err
+	}
+	result.Truncated = truncated
+	result.Provenance = provenance
+	result.Risk = policy.AssessRisk(query)
+	conn.executed(query)
+	return result, truncated, nil
+}
 
-		// Convert byte arrays to strings for JSON serialization
-		for i, v := range values {
-			if b, ok := v.([]byte); ok {
-				values[i] = string(b)
+// readResultSets reads the result sets of rows, closing it. Result sets
+// without columns or rows (e.g. from statements in a batch) are skipped.
+// Rows are read up to the limits across all result sets, reporting whether
+// rows were left unread due to the limits.
+//
+// Once the rows of the first result set read reach the spill's threshold,
+// its remaining rows are spilled, and the result set's continuation token
+// set to the cursor they are fetched from. Further result sets are not read.
+//
+// Rows are masked by the masker as they are read, before being spilled.
+func (conn *Connection) readResultSets(rows *sql.Rows, limits ResultLimits, spill *resultSpill, mask *policy.Masker) ([]*QueryResult, bool, error) {
+	defer rows.Close()
+	dc := newDriverConverter(conn.URL)
+	buf := getScanBuffer()
+	defer buf.release()
+	var alloc rowAllocator
+	var sets []*QueryResult
+	// the PII found in each set is reported once it was read
+	var setMasks []*policy.ColumnMasks
+	defer func() {
+		for i, masks := range setMasks {
+			sets[i].PII = masks.Findings()
+		}
+	}()
+	n, size := 0, int64(0)
+	for {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get columns: %w", err)
+		}
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get column types: %w", err)
+		}
+		// values are scanned as their column's JSON type, and the JSON types
+		// of masked columns may differ
+		scanTypes, masks := jsonTypes(columnTypes), mask.Columns(columns)
+		set := &QueryResult{
+			Columns:     columns,
+			ColumnTypes: make([]string, len(columnTypes)),
+			JSONTypes:   masks.JSONTypes(scanTypes),
+			Rows:        [][]interface{}{},
+		}
+		for i, ct := range columnTypes {
+			set.ColumnTypes[i] = ct.DatabaseTypeName()
+		}
+		if len(columns) != 0 {
+			sets, setMasks = append(sets, set), append(setMasks, masks)
+		}
+		for rows.Next() {
+			if limits.Rows > 0 && n == limits.Rows {
+				return sets, true, nil
+			}
+			values, err := buf.scan(rows, alloc.values(len(scanTypes)), scanTypes, dc)
+			if err != nil {
+				return nil, false, err
 			}
+			masks.Row(values)
+			rs := rowSize(values)
+			if limits.Bytes > 0 && size+rs > limits.Bytes {
+				return sets, true, nil
+			}
+			set.Rows = append(set.Rows, values)
+			n, size = n+1, size+rs
+			if len(sets) == 1 && (limits.Rows == 0 || n < limits.Rows) && spill.exceeded(size) {
+				maxRows := 0
+				if limits.Rows > 0 {
+					maxRows = limits.Rows - n
+				}
+				token, truncated, err := spill.spill(rows, set, scanTypes, masks, dc, maxRows)
+				if err != nil {
+					return nil, false, err
+				}
+				set.ContinuationToken = token
+				return sets, truncated, nil
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, false, fmt.Errorf("row iteration error: %w", err)
 		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("row iteration error: %w", err)
+	}
+	return sets, false, nil
+}
+
+// touch updates the connection's last used time.
+func (conn *Connection) touch() {
+	conn.mu.Lock()
+	conn.LastUsed = time.Now()
+	conn.mu.Unlock()
+}
+
+// scanBuffer holds the pointers rows are scanned through, reused across the
+// rows of a query rather than allocated per row, and pooled across queries.
+type scanBuffer struct {
+	args []interface{}
+}
+
+// scanBuffers is the pool of scan buffers.
+var scanBuffers = sync.Pool{
+	New: func() interface{} { return new(scanBuffer) },
+}
+
+// getScanBuffer returns a scan buffer from the pool.
+func getScanBuffer() *scanBuffer {
+	return scanBuffers.Get().(*scanBuffer)
+}
+
+// release returns the buffer to the pool.
+func (b *scanBuffer) release() {
+	clear(b.args)
+	scanBuffers.Put(b)
+}
 
-		result.Rows = append(result.Rows, values)
+// scan scans the current row of rows into values suitable for JSON
+// serialization, converted with the driver converter and to the JSON types
+// of the columns.
+func (b *scanBuffer) scan(rows *sql.Rows, values []interface{}, types []string, dc *driverConverter) ([]interface{}, error) {
+	b.args = slices.Grow(b.args[:0], len(values))[:len(values)]
+	for i := range values {
+		b.args[i] = &values[i]
+	}
+	if err := rows.Scan(b.args...); err != nil {
+		return nil, fmt.Errorf("failed to scan row: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+	for i, v := range values {
+		v, err := dc.convert(types[i], v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert value of column %d: %w", i+1, err)
+		}
+		values[i] = convertColumn(types[i], v)
 	}
 
-	return result, nil
+	return values, nil
+}
+
+// rowChunk is the number of rows whose values a rowAllocator allocates at
+// once.
+const rowChunk = 64
+
+// rowAllocator allocates the values of rows read into a result in chunks of
+// rows, rather than a slice per row.
+type rowAllocator struct {
+	chunk []interface{}
+}
+
+// values returns a slice of n values for a row.
+func (a *rowAllocator) values(n int) []interface{} {
+	if len(a.chunk) < n {
+		a.chunk = make([]interface{}, n*rowChunk)
+	}
+	values := a.chunk[:n:n]
+	a.chunk = a.chunk[n:]
+	return values
 }
 
 // ExecuteStatement executes a non-query SQL statement (INSERT, UPDATE, DELETE, etc.).
-func (conn *Connection) ExecuteStatement(ctx context.Context, statement string, args ...interface{}) (*StatementResult, error) {
+func (conn *Connection) ExecuteStatement(ctx context.Context, statement string, args ...interface{}) (res *StatementResult, err error) {
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
+	timer := newQueryTimer(statement)
+	defer func(statement string, args []interface{}, started time.Time) {
+		var rows int64
+		if res != nil {
+			rows = max(res.RowsAffected, 0)
+		}
+		conn.checkSlow(ctx, timer, rows, err)
+		conn.recordQuery(ctx, audit.Statement, statement, args, started, rows, err)
+	}(statement, args, time.Now())
+	defer conn.recoverPanic(statement, &err)
 
 	conn.LastUsed = time.Now()
 
-	result, err := conn.DB.ExecContext(ctx, statement, args...)
+	statement, hookRewrite, err := conn.preQuery(ctx, statement, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.policy.Check(ctx, conn.ID, statement); err != nil {
+		return nil, err
+	}
+	statement, restrictRewrite, err := conn.restrict(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.dial(ctx); err != nil {
+		return nil, err
+	}
+
+	statement, args, rewrites, err := bindArgs(conn.URL, statement, args)
+	if err != nil {
+		return nil, err
+	}
+	if hookRewrite != "" {
+		rewrites = append(rewrites, hookRewrite)
+	}
+	if restrictRewrite != "" {
+		rewrites = append(rewrites, restrictRewrite)
+	}
+
+	timer.enter(phaseQueue)
+	release, err := conn.acquire(ctx)
+	timer.enter(phasePrepare)
 	if err != nil {
 		return nil, fmt.Errorf("statement execution failed: %w", err)
 	}
+	defer conn.use(release)()
+
+	if err := conn.faults.Inject(ctx, conn.ID); err != nil {
+		return nil, fmt.Errorf("statement execution failed: %w", err)
+	}
+
+	dq, err := conn.withDeadline(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer dq.release()
+	if dq.rewrite != "" {
+		rewrites = append(rewrites, dq.rewrite)
+	}
+
+	timer.enter(phaseExecute)
+	executedAt := time.Now()
+	result, err := dq.stmts(conn).ExecContext(ctx, dq.db, dq.query, args...)
+	timer.stop()
+	if err != nil {
+		return nil, fmt.Errorf("statement execution failed: %w", err)
+	}
+	conn.executed(statement)
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
@@ -294,18 +1177,49 @@ func (conn *Connection) ExecuteStatement(ctx context.Context, statement string,
 	return &StatementResult{
 		RowsAffected: rowsAffected,
 		LastInsertId: lastInsertId,
+		Provenance:   conn.provenance(statement, executedAt, rewrites),
+		Risk:         policy.AssessRisk(statement),
 	}, nil
 }
 
 // QueryResult represents the result of a SQL query.
+//
+// Queries returning multiple result sets (e.g. SQL Server batches or MySQL
+// multi-statements) return the first result set, with the rest in
+// MoreResultSets.
 type QueryResult struct {
-	Columns     []string        `json:"columns"`
-	ColumnTypes []string        `json:"column_types"`
-	Rows        [][]interface{} `json:"rows"`
+	Columns        []string        `json:"columns"`
+	ColumnTypes    []string        `json:"column_types"`
+	Rows           [][]interface{} `json:"rows"`
+	MoreResultSets []*QueryResult  `json:"more_result_sets,omitempty"`
+	Provenance     *Provenance     `json:"provenance,omitempty"`
+
+	// Risk is the risk classification of the query.
+	Risk *policy.Risk `json:"risk,omitempty"`
+	// PII is the PII found in the rows, when PII detection applies.
+	PII []policy.PIIFinding `json:"pii,omitempty"`
+
+	// JSONTypes are the JSON types of the columns' values: integer, number,
+	// boolean, date-time (ISO 8601 strings), json (documents as strings) or
+	// string, or empty when not reported by the driver.
+	JSONTypes []string `json:"json_types,omitempty"`
+
+	// ContinuationToken is set on a page of a paginated query when more
+	// rows remain.
+	ContinuationToken string `json:"continuation_token,omitempty"`
+
+	// Truncated is set when rows were left unread due to the result caps.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Cached is set when the result was returned from the result cache,
+	// rather than the database.
+	Cached bool `json:"cached,omitempty"`
 }
 
 // StatementResult represents the result of a SQL statement execution.
 type StatementResult struct {
-	RowsAffected int64 `json:"rows_affected"`
-	LastInsertId int64 `json:"last_insert_id"`
-}
\ No newline at end of file
+	RowsAffected int64        `json:"rows_affected"`
+	LastInsertId int64        `json:"last_insert_id"`
+	Provenance   *Provenance  `json:"provenance,omitempty"`
+	Risk         *policy.Risk `json:"risk,omitempty"`
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestTenantCursorsAndJobs(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	conn := &Connection{ID: "acme/reporting", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	cp.connections[conn.ID] = conn
+
+	acme := policy.WithTenant(context.Background(), "acme")
+	other := policy.WithTenant(context.Background(), "payments")
+	denied := policy.WithScopes(acme, []string{policy.ConnectionScopePrefix + "acme/billing"})
+	cursor, err := cp.OpenCursor(acme, conn.ID, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	job, err := cp.SubmitJob(acme, conn.ID, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// cursors and jobs are not found by principals who may not use their
+	// connection
+	for _, ctx := range []context.Context{other, denied} {
+		if _, err := cp.FetchCursor(ctx, cursor.ID, 1); err == nil {
+			t.Errorf("expected an error fetching the cursor of another tenant")
+		}
+		if err := cp.CloseCursor(ctx, cursor.ID); err == nil {
+			t.Errorf("expected an error closing the cursor of another tenant")
+		}
+		if _, err := cp.JobStatus(ctx, job.ID, 0); err == nil {
+			t.Errorf("expected an error getting the status of another tenant's job")
+		}
+		if _, _, err := cp.JobResult(ctx, job.ID); err == nil {
+			t.Errorf("expected an error getting the result of another tenant's job")
+		}
+		if _, err := cp.CancelJob(ctx, job.ID); err == nil {
+			t.Errorf("expected an error canceling another tenant's job")
+		}
+	}
+
+	if page, err := cp.FetchCursor(acme, cursor.ID, 1); err != nil || len(page.Rows) != 1 {
+		t.Errorf("expected a row, got: %v %v", page, err)
+	}
+	if err := cp.CloseCursor(acme, cursor.ID); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if info, err := cp.JobStatus(acme, job.ID, time.Second); err != nil || info.State != JobSucceeded {
+		t.Errorf("expected the job to succeed, got: %+v %v", info, err)
+	}
+	if _, result, err := cp.JobResult(acme, job.ID); err != nil || len(result.Rows) == 0 {
+		t.Errorf("expected the job's rows, got: %v %v", result, err)
+	}
+}
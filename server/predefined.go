@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// ConnectPredefined creates the connections predefined in the config. A
+// connection failing to connect is registered to connect on its first use
+// instead, and a connection that can't be created at all is logged and
+// skipped, so that an unavailable database doesn't prevent the server from
+// starting.
+func (s *Server) ConnectPredefined(ctx context.Context) {
+	for _, c := range s.config().Connections {
+		def, err := c.definition()
+		if err == nil {
+			err = s.restoreConnection(ctx, def)
+		}
+		if err != nil {
+			poolLog.Error("failed to create predefined connection", "connection", c.ID, "error", err)
+		}
+	}
+}
+
+// restoreConnection creates a connection from its definition when the
+// server starts, registering it to connect on its first use when it fails to
+// connect.
+func (s *Server) restoreConnection(ctx context.Context, def ConnectionDefinition) error {
+	err := s.createConnection(ctx, def)
+	if err != nil && !def.Lazy && len(def.Replicas) == 0 {
+		poolLog.Warn("failed to connect connection, connecting on first use", "connection", def.ID, "error", err)
+		def.Lazy = true
+		err = s.createConnection(ctx, def)
+	}
+	return err
+}
+
+// definition returns the definition of the predefined connection, reading
+// its DSN from the environment when DSNEnv is set.
+func (c ConnectionConfig) definition() (ConnectionDefinition, error) {
+	dsn := c.DSN
+	if c.DSNEnv != "" {
+		var ok bool
+		if dsn, ok = os.LookupEnv(c.DSNEnv); !ok || dsn == "" {
+			return ConnectionDefinition{}, fmt.Errorf("environment variable %s is not set", c.DSNEnv)
+		}
+	}
+	def := ConnectionDefinition{
+		ID:         c.ID,
+		DSN:        dsn,
+		Replicas:   c.Replicas,
+		Aliases:    c.Aliases,
+		Tags:       c.Tags,
+		Lazy:       c.Lazy,
+		TLS:        c.TLS,
+		Failover:   c.Failover,
+		predefined: &c,
+	}
+	if c.Pool != (PoolSettings{}) {
+		def.Pool = &c.Pool
+	}
+	return def, nil
+}
+
+// validateConnections validates the predefined connections, whose IDs and
+// aliases must be unique, which have either a DSN or an environment variable
+// to read it from, and whose SSH jump host, proxy and TLS settings, if any,
+// are valid.
+func validateConnections(connections []ConnectionConfig) error {
+	names := make(map[string]bool)
+	for i, c := range connections {
+		switch {
+		case c.ID == "":
+			return fmt.Errorf("connection %d: id is empty", i+1)
+		case (c.DSN == "") == (c.DSNEnv == ""):
+			return fmt.Errorf("connection %s: exactly one of dsn or dsn_env is required", c.ID)
+		case c.Pool.MaxOpen < 0 || c.Pool.MaxIdle < 0 || c.Pool.MaxLifetime < 0 || c.Pool.MaxIdleTime < 0:
+			return fmt.Errorf("connection %s: pool settings can't be negative", c.ID)
+		}
+		if c.SSH != nil {
+			if err := c.SSH.validate(); err != nil {
+				return fmt.Errorf("connection %s: %w", c.ID, err)
+			}
+		}
+		if c.Proxy != "" && c.Proxy != directProxy {
+			if _, err := newProxyDial(c.Proxy); err != nil {
+				return fmt.Errorf("connection %s: %w", c.ID, err)
+			}
+		}
+		if c.TLS != nil {
+			if err := c.TLS.validate(); err != nil {
+				return fmt.Errorf("connection %s: %w", c.ID, err)
+			}
+		}
+		for _, name := range append([]string{c.ID}, c.Aliases...) {
+			if names[name] {
+				return fmt.Errorf("connection %s: %s is defined more than once", c.ID, name)
+			}
+			names[name] = true
+		}
+	}
+	return nil
+}
+
+// apply sets the settings on the database.
+func (s PoolSettings) apply(db *sql.DB) {
+	if s.MaxOpen > 0 {
+		db.SetMaxOpenConns(s.MaxOpen)
+	}
+	db.SetMaxIdleConns(s.maxIdle())
+	if s.MaxLifetime > 0 {
+		db.SetConnMaxLifetime(s.MaxLifetime)
+	}
+	if s.MaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(s.MaxIdleTime)
+	}
+}
+
+// maxIdle returns the number of idle database connections kept.
+func (s PoolSettings) maxIdle() int {
+	if s.MaxIdle > 0 {
+		return s.MaxIdle
+	}
+	return defaultMaxIdleConns
+}
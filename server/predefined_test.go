@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateConnections(t *testing.T) {
+	valid := []ConnectionConfig{
+		{ID: "main", DSN: "postgres://localhost/db", Aliases: []string{"primary"}},
+		{ID: "reports", DSNEnv: "REPORTS_DSN", Lazy: true, Pool: PoolSettings{MaxOpen: 4, MaxLifetime: time.Hour}},
+	}
+	if err := validateConnections(valid); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tests := [][]ConnectionConfig{
+		{{DSN: "postgres://localhost/db"}},
+		{{ID: "main"}},
+		{{ID: "main", DSN: "postgres://localhost/db", DSNEnv: "MAIN_DSN"}},
+		{{ID: "main", DSN: "postgres://localhost/db", Pool: PoolSettings{MaxIdle: -1}}},
+		{{ID: "main", DSN: "postgres://localhost/db"}, {ID: "main", DSN: "postgres://localhost/other"}},
+		{{ID: "main", DSN: "postgres://localhost/db"}, {ID: "other", DSN: "postgres://localhost/other", Aliases: []string{"main"}}},
+	}
+	for i, test := range tests {
+		if err := validateConnections(test); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+}
+
+func TestConnectionConfigDefinition(t *testing.T) {
+	c := ConnectionConfig{ID: "reports", DSNEnv: "USQLR_TEST_REPORTS_DSN", Pool: PoolSettings{MaxOpen: 4}}
+	if _, err := c.definition(); err == nil {
+		t.Errorf("expected an error when the environment variable is not set")
+	}
+	t.Setenv("USQLR_TEST_REPORTS_DSN", "postgres://localhost/reports")
+	def, err := c.definition()
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case def.DSN != "postgres://localhost/reports":
+		t.Errorf("expected the DSN from the environment, got: %q", def.DSN)
+	case def.predefined == nil || def.Pool == nil || def.Pool.MaxOpen != 4:
+		t.Errorf("expected a predefined connection with its pool settings, got: %+v", def)
+	}
+}
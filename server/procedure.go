@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// Procedure parameter modes.
+const (
+	ParamIn    = "in"
+	ParamOut   = "out"
+	ParamInOut = "inout"
+)
+
+// ProcedureParam is a stored procedure parameter.
+//
+// Type is the type of OUT parameter values (string, integer, number,
+// boolean, bytes or time), used by drivers that bind OUT parameters. It
+// defaults to the type of Value, or string.
+type ProcedureParam struct {
+	Name  string      `json:"name,omitempty"`
+	Mode  string      `json:"mode,omitempty"`
+	Type  string      `json:"type,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ProcedureResult is the result of a stored procedure call.
+type ProcedureResult struct {
+	ResultSets []*QueryResult         `json:"result_sets"`
+	Out        map[string]interface{} `json:"out,omitempty"`
+	Provenance *Provenance            `json:"provenance,omitempty"`
+}
+
+// CallProcedure calls a stored procedure, returning its result sets and
+// OUT/INOUT parameter values, keyed by parameter name (or 1-based position
+// when unnamed).
+//
+// The call is made as appropriate for the driver: an RPC call for SQL
+// Server, an anonymous PL/SQL block for Oracle, CALL with session variables
+// for MySQL, and CALL elsewhere.
+func (conn *Connection) CallProcedure(ctx context.Context, name string, params []ProcedureParam) (_ *ProcedureResult, err error) {
+	if err := validateProcedureName(name); err != nil {
+		return nil, err
+	}
+	for i, p := range params {
+		switch p.Mode {
+		case "":
+			params[i].Mode = ParamIn
+		case ParamIn, ParamOut, ParamInOut:
+		default:
+			return nil, fmt.Errorf("parameter %d: invalid mode %q", i+1, p.Mode)
+		}
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.LastUsed = time.Now()
+
+	if err := conn.dial(ctx); err != nil {
+		return nil, err
+	}
+
+	var call func(context.Context, string, []ProcedureParam) (*ProcedureResult, error)
+	var stmt string
+	switch conn.URL.Driver {
+	case "sqlserver", "azuresql":
+		call, stmt = conn.callBound, name
+	case "godror", "oracle":
+		call, stmt = conn.callBound, fmt.Sprintf("BEGIN %s(%s); END;", name, placeholderList(conn, len(params)))
+	case "mysql":
+		call, stmt = conn.callMySQL, name
+	case "postgres", "pgx":
+		call, stmt = conn.callPostgres, fmt.Sprintf("CALL %s(%s)", name, placeholderList(conn, len(params)))
+	default:
+		call, stmt = conn.callBound, fmt.Sprintf("CALL %s(%s)", name, placeholderList(conn, len(params)))
+	}
+
+	defer conn.recoverPanic(stmt, &err)
+
+	if err := conn.policy.Check(ctx, conn.ID, "CALL "+name); err != nil {
+		return nil, err
+	}
+
+	release, err := conn.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("procedure call failed: %w", err)
+	}
+	defer release()
+
+	if err := conn.faults.Inject(ctx, conn.ID); err != nil {
+		return nil, fmt.Errorf("procedure call failed: %w", err)
+	}
+
+	executedAt := time.Now()
+	result, err := call(ctx, stmt, params)
+	if err != nil {
+		return nil, fmt.Errorf("procedure call failed: %w", err)
+	}
+	for _, set := range result.ResultSets {
+		conn.quota.AddRows(ctx, len(set.Rows))
+	}
+	result.Provenance = conn.provenance(stmt, executedAt, nil)
+	return result, nil
+}
+
+// callBound calls a procedure binding OUT parameters with sql.Out, for
+// drivers supporting it (SQL Server, Oracle).
+func (conn *Connection) callBound(ctx context.Context, stmt string, params []ProcedureParam) (*ProcedureResult, error) {
+	args := make([]interface{}, len(params))
+	dests := make(map[int]interface{})
+	for i, p := range params {
+		var arg interface{} = p.Value
+		if p.Mode != ParamIn {
+			dest, err := outDest(p)
+			if err != nil {
+				return nil, err
+			}
+			dests[i] = dest
+			arg = sql.Out{Dest: dest, In: p.Mode == ParamInOut}
+		}
+		if p.Name != "" {
+			arg = sql.Named(p.Name, arg)
+		}
+		args[i] = arg
+	}
+
+	rows, err := conn.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	sets, _, err := conn.readResultSets(rows, ResultLimits{}, nil, conn.masker(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// OUT parameters are only set once all results have been read
+	result := &ProcedureResult{ResultSets: sets, Out: make(map[string]interface{}, len(dests))}
+	for i, dest := range dests {
+		result.Out[paramKey(params, i)] = convertValue(derefDest(dest))
+	}
+	return result, nil
+}
+
+// callMySQL calls a MySQL procedure, passing OUT parameters as session
+// variables read back after the call.
+func (conn *Connection) callMySQL(ctx context.Context, name string, params []ProcedureParam) (*ProcedureResult, error) {
+	// session variables require all statements to run on the same connection
+	c, err := conn.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var args []interface{}
+	placeholders := make([]string, len(params))
+	var vars []string
+	for i, p := range params {
+		if p.Mode == ParamIn {
+			placeholders[i] = "?"
+			args = append(args, p.Value)
+			continue
+		}
+		v := fmt.Sprintf("@_usqlr_out_%d", i+1)
+		if _, err := c.ExecContext(ctx, "SET "+v+" = ?", p.Value); err != nil {
+			return nil, err
+		}
+		placeholders[i] = v
+		vars = append(vars, v)
+	}
+
+	rows, err := c.QueryContext(ctx, fmt.Sprintf("CALL %s(%s)", name, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	sets, _, err := conn.readResultSets(rows, ResultLimits{}, nil, conn.masker(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProcedureResult{ResultSets: sets}
+	if len(vars) == 0 {
+		return result, nil
+	}
+	values := make([]interface{}, len(vars))
+	dests := make([]interface{}, len(vars))
+	for i := range values {
+		dests[i] = &values[i]
+	}
+	if err := c.QueryRowContext(ctx, "SELECT "+strings.Join(vars, ", ")).Scan(dests...); err != nil {
+		return nil, fmt.Errorf("failed to read OUT parameters: %w", err)
+	}
+	result.Out = make(map[string]interface{}, len(vars))
+	j := 0
+	for i, p := range params {
+		if p.Mode != ParamIn {
+			result.Out[paramKey(params, i)] = convertValue(values[j])
+			j++
+		}
+	}
+	return result, nil
+}
+
+// callPostgres calls a PostgreSQL procedure. OUT arguments are passed as
+// NULL, and the procedure returns its OUT and INOUT values as a row.
+func (conn *Connection) callPostgres(ctx context.Context, stmt string, params []ProcedureParam) (*ProcedureResult, error) {
+	args := make([]interface{}, len(params))
+	out := false
+	for i, p := range params {
+		if p.Mode != ParamOut {
+			args[i] = p.Value
+		}
+		out = out || p.Mode != ParamIn
+	}
+
+	rows, err := conn.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	sets, _, err := conn.readResultSets(rows, ResultLimits{}, nil, conn.masker(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProcedureResult{ResultSets: sets}
+	if !out || len(sets) == 0 || len(sets[0].Rows) != 1 {
+		return result, nil
+	}
+	// the returned columns are named after the procedure's parameters
+	set := sets[0]
+	result.Out = make(map[string]interface{}, len(set.Columns))
+	for i, col := range set.Columns {
+		result.Out[col] = set.Rows[0][i]
+	}
+	result.ResultSets = sets[1:]
+	return result, nil
+}
+
+// validateProcedureName checks that name is a possibly qualified identifier,
+// as it is interpolated into the call statement.
+func validateProcedureName(name string) error {
+	tokens := sqlscan.Scan(name)
+	for i, t := range tokens {
+		if (i%2 == 0 && t.Kind != sqlscan.Word && t.Kind != sqlscan.QuotedIdent) || (i%2 == 1 && t.Text != ".") {
+			return fmt.Errorf("invalid procedure name %q", name)
+		}
+	}
+	if len(tokens) == 0 || len(tokens)%2 == 0 {
+		return fmt.Errorf("invalid procedure name %q", name)
+	}
+	return nil
+}
+
+// placeholderList returns a comma separated list of n of the connection's
+// positional placeholders.
+func placeholderList(conn *Connection, n int) string {
+	placeholder := placeholders[conn.URL.Driver]
+	v := make([]string, n)
+	for i := range v {
+		v[i] = "?"
+		if placeholder != nil {
+			v[i] = placeholder(i + 1)
+		}
+	}
+	return strings.Join(v, ", ")
+}
+
+// outDest returns a pointer to receive the OUT parameter's value.
+func outDest(p ProcedureParam) (interface{}, error) {
+	typ := p.Type
+	if typ == "" {
+		switch p.Value.(type) {
+		case float64:
+			typ = "number"
+		case bool:
+			typ = "boolean"
+		default:
+			typ = "string"
+		}
+	}
+	switch typ {
+	case "string":
+		s, _ := p.Value.(string)
+		return &s, nil
+	case "integer":
+		f, _ := p.Value.(float64)
+		i := int64(f)
+		return &i, nil
+	case "number":
+		f, _ := p.Value.(float64)
+		return &f, nil
+	case "boolean":
+		b, _ := p.Value.(bool)
+		return &b, nil
+	case "bytes":
+		var b []byte
+		return &b, nil
+	case "time":
+		var t time.Time
+		return &t, nil
+	}
+	return nil, fmt.Errorf("parameter %s: invalid type %q", p.Name, typ)
+}
+
+// derefDest returns the value pointed to by an OUT parameter destination.
+func derefDest(dest interface{}) interface{} {
+	switch d := dest.(type) {
+	case *string:
+		return *d
+	case *int64:
+		return *d
+	case *float64:
+		return *d
+	case *bool:
+		return *d
+	case *[]byte:
+		return *d
+	case *time.Time:
+		return *d
+	}
+	return nil
+}
+
+// convertValue converts a value for JSON serialization, keeping a nil []byte
+// (NULL) distinct from an empty string.
+func convertValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		if b == nil {
+			return nil
+		}
+		return string(b)
+	}
+	return v
+}
+
+// paramKey returns the key of the i'th parameter in OUT parameter values.
+func paramKey(params []ProcedureParam, i int) string {
+	if params[i].Name != "" {
+		return params[i].Name
+	}
+	return strconv.Itoa(i + 1)
+}
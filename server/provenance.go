@@ -0,0 +1,40 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/xo/usql/text"
+)
+
+// Provenance describes where and how a result was produced, so consumers
+// storing results can trace their origin.
+type Provenance struct {
+	ConnectionID  string    `json:"connection_id"`
+	Driver        string    `json:"driver"`
+	Host          string    `json:"host,omitempty"`
+	Database      string    `json:"database,omitempty"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	ExecutedAt    time.Time `json:"executed_at"`
+	SQLHash       string    `json:"sql_hash"`
+	UsqlrVersion  string    `json:"usqlr_version"`
+	Rewrites      []string  `json:"rewrites,omitempty"`
+}
+
+// provenance returns the provenance of a result of the SQL executed on the
+// connection at the time, after the rewrites were applied to it.
+func (conn *Connection) provenance(sql string, executedAt time.Time, rewrites []string) *Provenance {
+	hash := sha256.Sum256([]byte(sql))
+	return &Provenance{
+		ConnectionID:  conn.ID,
+		Driver:        conn.URL.Driver,
+		Host:          conn.URL.Host,
+		Database:      conn.URL.Path,
+		ServerVersion: conn.serverVersion,
+		ExecutedAt:    executedAt.UTC(),
+		SQLHash:       "sha256:" + hex.EncodeToString(hash[:]),
+		UsqlrVersion:  text.CommandVersion,
+		Rewrites:      rewrites,
+	}
+}
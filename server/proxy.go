@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// directProxy is the per-connection proxy of connections dialed directly,
+// regardless of the global proxy.
+const directProxy = "direct"
+
+// netDialFunc dials a network connection.
+type netDialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// directDial dials a network connection directly.
+func directDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// newProxyDial returns the func dialing network connections through the
+// proxy at the URL, either a SOCKS5 (socks5 or socks5h) or HTTP CONNECT
+// (http or https) proxy, authenticating with the URL's user and password.
+func newProxyDial(raw string) (netDialFunc, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: host is empty", raw)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer).DialContext, nil
+	case "http", "https":
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return httpConnect(ctx, u, addr)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q: must be socks5, socks5h, http or https", u.Scheme)
+}
+
+// httpConnect dials the address through the HTTP proxy with a CONNECT
+// request.
+func httpConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	host := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	c, err := directDial(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial proxy %s: %w", host, err)
+	}
+	if proxyURL.Scheme == "https" {
+		tc := tls.Client(c, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy %s failed: %w", host, err)
+		}
+		c = tc
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// the response is read a byte at a time, so that no bytes of the
+	// tunneled connection are buffered
+	res, err := http.ReadResponse(bufio.NewReaderSize(byteReader{c}, 1), req)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to read proxy response: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", addr, res.Status)
+	}
+	return c, nil
+}
+
+// byteReader reads a byte at a time.
+type byteReader struct {
+	c net.Conn
+}
+
+// Read satisfies the io.Reader interface.
+func (r byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return r.c.Read(p)
+}
+
+// dialFor returns the func dialing the host through the proxy, or directly
+// when there is no proxy, or the host is one of NoProxy.
+func (c ProxyConfig) dialFor(host string) (netDialFunc, error) {
+	if c.URL == "" || c.URL == directProxy || c.bypass(host) {
+		return nil, nil
+	}
+	return newProxyDial(c.URL)
+}
+
+// bypass returns whether the host is dialed directly: when it is one of
+// NoProxy, a subdomain of a .domain of NoProxy, within one of its CIDR
+// ranges, or NoProxy is *.
+func (c ProxyConfig) bypass(host string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range c.NoProxy {
+		switch {
+		case entry == "*" || strings.EqualFold(entry, host):
+			return true
+		case strings.HasPrefix(entry, ".") && strings.HasSuffix(strings.ToLower(host), strings.ToLower(entry)):
+			return true
+		case strings.HasPrefix(entry, "*.") && strings.HasSuffix(strings.ToLower(host), strings.ToLower(entry[1:])):
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil && ip != nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyDialer dials a database through a proxy.
+type proxyDialer struct {
+	target  string
+	netDial netDialFunc
+}
+
+// dial satisfies the dialer interface.
+func (d *proxyDialer) dial(ctx context.Context) (net.Conn, error) {
+	c, err := d.netDial(ctx, "tcp", d.target)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: failed to dial %s: %w", d.target, err)
+	}
+	return c, nil
+}
+
+// close satisfies the dialer interface.
+func (d *proxyDialer) close() {}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestProxy(t *testing.T) {
+	db := testEchoServer(t)
+	defer db.Close()
+	httpProxy := testProxyServer(t, testHTTPConnect)
+	defer httpProxy.Close()
+	socksProxy := testProxyServer(t, testSOCKS5)
+	defer socksProxy.Close()
+
+	u, _ := dburl.Parse("postgres://app@" + db.Addr().String() + "/app")
+	for _, proxyURL := range []string{
+		"http://user:secret@" + httpProxy.Addr().String(),
+		"socks5://" + socksProxy.Addr().String(),
+	} {
+		_, d, err := parseRoute(t.Context(), u, nil, ProxyConfig{URL: proxyURL})
+		if err != nil || d == nil {
+			t.Fatalf("expected a proxy dialer, got: %v %v", d, err)
+		}
+		c, err := d.dial(t.Context())
+		if err != nil {
+			t.Fatalf("expected no error dialing through %s, got: %v", proxyURL, err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 4)
+		c.Write([]byte("ping"))
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("expected the database to echo ping through %s, got: %q %v", proxyURL, buf, err)
+		}
+		c.Close()
+	}
+
+	// the proxy refuses unauthenticated clients
+	_, d, _ := parseRoute(t.Context(), u, nil, ProxyConfig{URL: "http://" + httpProxy.Addr().String()})
+	if _, err := d.dial(t.Context()); err == nil {
+		t.Errorf("expected the proxy to refuse to connect")
+	}
+
+	// hosts of no_proxy are dialed directly
+	if _, d, err := parseRoute(t.Context(), u, nil, ProxyConfig{URL: "socks5://proxy:1080", NoProxy: []string{"127.0.0.0/8"}}); d != nil || err != nil {
+		t.Errorf("expected no dialer, got: %v %v", d, err)
+	}
+	file, _ := dburl.Parse("sqlite3:/var/lib/app.db")
+	if _, d, err := parseRoute(t.Context(), file, nil, ProxyConfig{URL: "socks5://proxy:1080"}); d != nil || err != nil {
+		t.Errorf("expected no dialer for a file, got: %v %v", d, err)
+	}
+	config := ProxyConfig{NoProxy: []string{"localhost", ".internal", "*.corp.example.com", "10.0.0.0/8"}}
+	for host, exp := range map[string]bool{
+		"localhost":           true,
+		"db.internal":         true,
+		"pg.corp.example.com": true,
+		"10.1.2.3":            true,
+		"db.example.com":      false,
+		"11.1.2.3":            false,
+	} {
+		if config.bypass(host) != exp {
+			t.Errorf("expected bypass of %s to be %t", host, exp)
+		}
+	}
+	for _, proxyURL := range []string{"ftp://proxy:21", "socks5://", "direct"} {
+		if _, err := newProxyDial(proxyURL); err == nil {
+			t.Errorf("expected an error for %s", proxyURL)
+		}
+	}
+}
+
+// testEchoServer starts a server echoing what it reads.
+func testEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln
+}
+
+// testProxyServer starts a proxy server, whose handshake returns the
+// address clients connect to, or "" to close the connection.
+func testProxyServer(t *testing.T, handshake func(c net.Conn, r *bufio.Reader) string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				addr := handshake(c, r)
+				if addr == "" {
+					return
+				}
+				remote, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				go io.Copy(remote, r)
+				io.Copy(c, remote)
+			}()
+		}
+	}()
+	return ln
+}
+
+// testHTTPConnect is the handshake of an HTTP CONNECT proxy requiring
+// basic auth.
+func testHTTPConnect(c net.Conn, r *bufio.Reader) string {
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+	if user, password, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || user != "user" || password != "secret" {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return ""
+	}
+	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return req.Host
+}
+
+// testSOCKS5 is the handshake of a SOCKS5 proxy without auth, for IPv4
+// addresses.
+func testSOCKS5(c net.Conn, r *bufio.Reader) string {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return ""
+	}
+	if _, err := io.ReadFull(r, make([]byte, greeting[1])); err != nil {
+		return ""
+	}
+	c.Write([]byte{5, 0})
+	req := make([]byte, 10)
+	if _, err := io.ReadFull(r, req); err != nil || req[3] != 1 {
+		return ""
+	}
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(req[8:]))))
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// QuotaLimits are usage limits of a principal or tenant. A limit of 0 means
+// unlimited.
+type QuotaLimits struct {
+	QueriesPerMinute  int   `mapstructure:"queries_per_minute" yaml:"queries_per_minute" json:"queries_per_minute"`
+	QueriesPerDay     int   `mapstructure:"queries_per_day" yaml:"queries_per_day" json:"queries_per_day"`
+	RowsPerDay        int64 `mapstructure:"rows_per_day" yaml:"rows_per_day" json:"rows_per_day"`
+	ConcurrentQueries int   `mapstructure:"concurrent_queries" yaml:"concurrent_queries" json:"concurrent_queries"`
+}
+
+// Quotas, as named in quota errors.
+const (
+	QuotaQueriesPerMinute  = "queries_per_minute"
+	QuotaQueriesPerDay     = "queries_per_day"
+	QuotaRowsPerDay        = "rows_per_day"
+	QuotaConcurrentQueries = "concurrent_queries"
+)
+
+// QuotaError is returned when a principal, or its tenant, exceeded a quota:
+// a tenant's quota when Principal is not set. Quotas other than concurrent
+// queries reset at ResetAt.
+type QuotaError struct {
+	Principal string    `json:"principal,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Quota     string    `json:"quota"`
+	Limit     int64     `json:"limit"`
+	ResetAt   time.Time `json:"reset_at,omitzero"`
+}
+
+// Error satisfies the error interface.
+func (e *QuotaError) Error() string {
+	subject := "principal " + e.Principal
+	switch {
+	case e.Principal == "":
+		subject = "tenant " + e.Tenant
+	case e.Tenant != "":
+		subject += " of tenant " + e.Tenant
+	}
+	msg := fmt.Sprintf("%s exceeded its quota of %d %s", subject, e.Limit, strings.ReplaceAll(e.Quota, "_", " "))
+	if !e.ResetAt.IsZero() {
+		msg += ", until " + e.ResetAt.Format(time.RFC3339)
+	}
+	return msg
+}
+
+// ErrorData returns the quota's details for clients.
+func (e *QuotaError) ErrorData() map[string]interface{} {
+	return map[string]interface{}{"quota": e}
+}
+
+// QuotaUsage is the usage of a principal, in its tenant if any, or of a
+// tenant's principals when Principal is not set, for the current minute and
+// UTC day.
+type QuotaUsage struct {
+	Principal         string      `json:"principal,omitempty"`
+	Tenant            string      `json:"tenant,omitempty"`
+	Day               string      `json:"day"`
+	QueriesToday      int         `json:"queries_today"`
+	RowsToday         int64       `json:"rows_today"`
+	Minute            time.Time   `json:"minute"`
+	QueriesThisMinute int         `json:"queries_this_minute"`
+	ConcurrentQueries int         `json:"concurrent_queries"`
+	Limits            QuotaLimits `json:"limits"`
+}
+
+// QuotaGuard enforces the usage quotas of authenticated principals, and of
+// the tenants of principals in a tenant, which count against both. Queries
+// are counted as they are executed on databases, and rows as they are read
+// from them, as are results served from the result cache. Usage is counted
+// by the guard's store, in the process, or in Redis to be shared by the
+// servers of a cluster.
+type QuotaGuard struct {
+	config atomic.Pointer[QuotaConfig]
+	store  quotaStore
+}
+
+// NewQuotaGuard creates a new quota guard, counting usage in the process.
+func NewQuotaGuard(config QuotaConfig) *QuotaGuard {
+	return newQuotaGuard(config, newMemoryQuotas())
+}
+
+// newQuotaGuard creates a new quota guard counting usage in the store.
+func newQuotaGuard(config QuotaConfig, store quotaStore) *QuotaGuard {
+	g := &QuotaGuard{store: store}
+	g.config.Store(&config)
+	return g
+}
+
+// SetConfig replaces the quotas, applied from the next query on.
+func (g *QuotaGuard) SetConfig(config QuotaConfig) {
+	g.config.Store(&config)
+}
+
+// limits returns the quotas of the principal, in the tenant if any, or of
+// the tenant when the principal is empty. Principals in a tenant share its
+// quotas, so only have the quotas set for them, by their name qualified by
+// the tenant (as payments/agent) or by name.
+func (g *QuotaGuard) limits(principal, tenant string) QuotaLimits {
+	config := g.config.Load()
+	switch {
+	case principal == "":
+		if limits, ok := config.Tenants[tenant]; ok {
+			return limits
+		}
+	case tenant != "":
+		if limits, ok := config.Principals[tenant+policy.TenantSeparator+principal]; ok {
+			return limits
+		}
+		return config.Principals[principal]
+	default:
+		if limits, ok := config.Principals[principal]; ok {
+			return limits
+		}
+	}
+	return config.QuotaLimits
+}
+
+// subjects returns ctx's principal and its tenant, if any, with their
+// quotas, or nil when there is no principal.
+func (g *QuotaGuard) subjects(ctx context.Context) []quotaSubject {
+	principal, tenant := policy.PrincipalFrom(ctx), policy.TenantFrom(ctx)
+	var subjects []quotaSubject
+	if principal != "" {
+		subjects = append(subjects, quotaSubject{principal, tenant, g.limits(principal, tenant)})
+	}
+	if tenant != "" {
+		subjects = append(subjects, quotaSubject{"", tenant, g.limits("", tenant)})
+	}
+	return subjects
+}
+
+// Acquire counts a query of ctx's principal, and of its tenant, returning a
+// *QuotaError for the first quota of either it would exceed, and otherwise a
+// func to call once the query finished. Queries of unauthenticated requests
+// are not limited.
+func (g *QuotaGuard) Acquire(ctx context.Context) (func(), error) {
+	if g == nil {
+		return func() {}, nil
+	}
+	subjects := g.subjects(ctx)
+	if subjects == nil {
+		return func() {}, nil
+	}
+	return g.store.acquire(ctx, subjects, time.Now())
+}
+
+// CheckRows returns a *QuotaError when ctx's principal, or its tenant, read
+// the rows of its quota for the day, such as before fetching more rows of a
+// cursor.
+func (g *QuotaGuard) CheckRows(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	subjects := g.subjects(ctx)
+	if subjects == nil {
+		return nil
+	}
+	return g.store.checkRows(ctx, subjects, time.Now())
+}
+
+// AddRows adds rows read by ctx's principal to its usage, and its tenant's.
+func (g *QuotaGuard) AddRows(ctx context.Context, n int) {
+	if g == nil || n == 0 {
+		return
+	}
+	if subjects := g.subjects(ctx); subjects != nil {
+		g.store.addRows(ctx, subjects, n, time.Now())
+	}
+}
+
+// Usage returns the usage of the principals and tenants, ordered by tenant
+// and principal.
+func (g *QuotaGuard) Usage(ctx context.Context) []QuotaUsage {
+	usage := g.store.usage(ctx, time.Now())
+	for i, u := range usage {
+		usage[i].Limits = g.limits(u.Principal, u.Tenant)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Tenant != usage[j].Tenant {
+			return usage[i].Tenant < usage[j].Tenant
+		}
+		return usage[i].Principal < usage[j].Principal
+	})
+	return usage
+}
+
+// quotaSubject is a principal, in its tenant if any, or a tenant when
+// Principal is empty, whose usage is counted against its quotas.
+type quotaSubject struct {
+	Principal string
+	Tenant    string
+	Limits    QuotaLimits
+}
+
+// key returns the key the subject's usage is counted under.
+func (s quotaSubject) key() string {
+	return s.Tenant + policy.TenantSeparator + s.Principal
+}
+
+// reached returns the quota of the subject a query would exceed with the
+// usage, if any.
+func (s quotaSubject) reached(u *QuotaUsage) string {
+	switch l := s.Limits; {
+	case l.ConcurrentQueries > 0 && u.ConcurrentQueries >= l.ConcurrentQueries:
+		return QuotaConcurrentQueries
+	case l.QueriesPerMinute > 0 && u.QueriesThisMinute >= l.QueriesPerMinute:
+		return QuotaQueriesPerMinute
+	case l.QueriesPerDay > 0 && u.QueriesToday >= l.QueriesPerDay:
+		return QuotaQueriesPerDay
+	case l.RowsPerDay > 0 && u.RowsToday >= l.RowsPerDay:
+		return QuotaRowsPerDay
+	}
+	return ""
+}
+
+// exceeded returns the error of the subject's quota being exceeded at now.
+func (s quotaSubject) exceeded(quota string, now time.Time) *QuotaError {
+	e := &QuotaError{Principal: s.Principal, Tenant: s.Tenant, Quota: quota}
+	switch quota {
+	case QuotaConcurrentQueries:
+		e.Limit = int64(s.Limits.ConcurrentQueries)
+	case QuotaQueriesPerMinute:
+		e.Limit, e.ResetAt = int64(s.Limits.QueriesPerMinute), now.Truncate(time.Minute).Add(time.Minute)
+	case QuotaQueriesPerDay:
+		e.Limit, e.ResetAt = int64(s.Limits.QueriesPerDay), nextDay(now)
+	case QuotaRowsPerDay:
+		e.Limit, e.ResetAt = s.Limits.RowsPerDay, nextDay(now)
+	}
+	return e
+}
+
+// nextDay returns the start of the UTC day after now's.
+func nextDay(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// quotaStore counts the usage of principals and tenants against their
+// quotas.
+type quotaStore interface {
+	// acquire counts a query of the subjects, unless it would exceed one of
+	// their quotas, returning the error of the first, and otherwise a func
+	// to call once the query finished.
+	acquire(ctx context.Context, subjects []quotaSubject, now time.Time) (func(), error)
+	// checkRows returns the error of the first rows quota of the subjects
+	// reached.
+	checkRows(ctx context.Context, subjects []quotaSubject, now time.Time) error
+	// addRows adds rows read to the usage of the subjects.
+	addRows(ctx context.Context, subjects []quotaSubject, n int, now time.Time)
+	// usage returns the usage of the principals and tenants for the current
+	// minute and day, without their limits.
+	usage(ctx context.Context, now time.Time) []QuotaUsage
+}
+
+// memoryQuotas counts usage in the process.
+type memoryQuotas struct {
+	mu       sync.Mutex
+	counters map[string]*QuotaUsage
+}
+
+// newMemoryQuotas creates a new store counting usage in the process.
+func newMemoryQuotas() *memoryQuotas {
+	return &memoryQuotas{counters: make(map[string]*QuotaUsage)}
+}
+
+// counter returns the usage of the subject for the current minute and day,
+// resetting the counters of past ones. The lock must be held.
+func (m *memoryQuotas) counter(s quotaSubject, now time.Time) *QuotaUsage {
+	u, ok := m.counters[s.key()]
+	if !ok {
+		u = &QuotaUsage{Principal: s.Principal, Tenant: s.Tenant}
+		m.counters[s.key()] = u
+	}
+	if day := now.UTC().Format(time.DateOnly); u.Day != day {
+		u.Day, u.QueriesToday, u.RowsToday = day, 0, 0
+	}
+	if minute := now.Truncate(time.Minute); !u.Minute.Equal(minute) {
+		u.Minute, u.QueriesThisMinute = minute, 0
+	}
+	return u
+}
+
+// acquire satisfies the quotaStore interface.
+func (m *memoryQuotas) acquire(_ context.Context, subjects []quotaSubject, now time.Time) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := make([]*QuotaUsage, len(subjects))
+	for i, s := range subjects {
+		usage[i] = m.counter(s, now)
+		if quota := s.reached(usage[i]); quota != "" {
+			return nil, s.exceeded(quota, now)
+		}
+	}
+	for _, u := range usage {
+		u.QueriesThisMinute++
+		u.QueriesToday++
+		u.ConcurrentQueries++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			for _, u := range usage {
+				u.ConcurrentQueries--
+			}
+			m.mu.Unlock()
+		})
+	}, nil
+}
+
+// checkRows satisfies the quotaStore interface.
+func (m *memoryQuotas) checkRows(_ context.Context, subjects []quotaSubject, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range subjects {
+		if u := m.counter(s, now); s.Limits.RowsPerDay > 0 && u.RowsToday >= s.Limits.RowsPerDay {
+			return s.exceeded(QuotaRowsPerDay, now)
+		}
+	}
+	return nil
+}
+
+// addRows satisfies the quotaStore interface.
+func (m *memoryQuotas) addRows(_ context.Context, subjects []quotaSubject, n int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range subjects {
+		m.counter(s, now).RowsToday += int64(n)
+	}
+}
+
+// usage satisfies the quotaStore interface.
+func (m *memoryQuotas) usage(_ context.Context, now time.Time) []QuotaUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	day, minute := now.UTC().Format(time.DateOnly), now.Truncate(time.Minute)
+	usage := make([]QuotaUsage, 0, len(m.counters))
+	for _, u := range m.counters {
+		v := *u
+		if v.Day != day {
+			v.Day, v.QueriesToday, v.RowsToday = day, 0, 0
+		}
+		if !v.Minute.Equal(minute) {
+			v.Minute, v.QueriesThisMinute = minute, 0
+		}
+		usage = append(usage, v)
+	}
+	return usage
+}
+
+// handleQuotas handles listing the usage of the principals and tenants
+// against their quotas.
+func (s *Server) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.Quotas().Usage(r.Context()))
+}
+
+// acquire waits for a query slot on the connection's host, once the quotas
+// of ctx's principal allow the query, returning a func to release the slot.
+func (conn *Connection) acquire(ctx context.Context) (func(), error) {
+	done, err := conn.quota.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release, err := conn.throttle.Acquire(ctx, hostKey(conn.URL))
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return func() {
+		release()
+		done()
+	}, nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xo/usql/server/policy"
+)
+
+// quotaLeaseTTL is how long a query counts against the concurrent queries
+// quotas in Redis, unless it finishes before, so that the queries of a
+// server that stopped without ending them do not count forever.
+const quotaLeaseTTL = time.Hour
+
+// quotaKeyTTL is how long the counters of a day are kept in Redis, so they
+// can be listed until the day ends in every time zone.
+const quotaKeyTTL = 48 * time.Hour
+
+// acquireScript counts a query against the quotas of subjects, unless one of
+// them is reached, returning the index of the subject and the quota reached,
+// or -1. KEYS are the set of the day's subjects, followed by the minute, day
+// and concurrent queries counters of each subject; ARGV the time, the
+// query's lease and its expiry, and the TTL of the day's keys, followed by
+// the queries per minute, queries and rows per day, and concurrent queries
+// limits of each subject, then the names of the subjects.
+var acquireScript = redis.NewScript(`
+local now, lease, expiry = tonumber(ARGV[1]), ARGV[2], tonumber(ARGV[3])
+local n = (#KEYS - 1) / 3
+for i = 0, n - 1 do
+	local minute, day, concurrent = KEYS[2 + i*3], KEYS[3 + i*3], KEYS[4 + i*3]
+	local qpm, qpd, rpd, cq = tonumber(ARGV[5 + i*4]), tonumber(ARGV[6 + i*4]), tonumber(ARGV[7 + i*4]), tonumber(ARGV[8 + i*4])
+	redis.call('ZREMRANGEBYSCORE', concurrent, '-inf', now)
+	if cq > 0 and redis.call('ZCARD', concurrent) >= cq then
+		return {i, 'concurrent_queries'}
+	end
+	if qpm > 0 and tonumber(redis.call('GET', minute) or 0) >= qpm then
+		return {i, 'queries_per_minute'}
+	end
+	local counts = redis.call('HMGET', day, 'queries', 'rows')
+	if qpd > 0 and tonumber(counts[1] or 0) >= qpd then
+		return {i, 'queries_per_day'}
+	end
+	if rpd > 0 and tonumber(counts[2] or 0) >= rpd then
+		return {i, 'rows_per_day'}
+	end
+end
+for i = 0, n - 1 do
+	local minute, day, concurrent = KEYS[2 + i*3], KEYS[3 + i*3], KEYS[4 + i*3]
+	redis.call('INCR', minute)
+	redis.call('PEXPIRE', minute, 120000)
+	redis.call('HINCRBY', day, 'queries', 1)
+	redis.call('PEXPIRE', day, ARGV[4])
+	redis.call('ZADD', concurrent, expiry, lease)
+	redis.call('PEXPIRE', concurrent, expiry - now)
+	redis.call('SADD', KEYS[1], ARGV[5 + n*4 + i])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {-1, ''}
+`)
+
+// redisQuotas counts usage in the Redis store, shared by the servers using
+// it. While Redis is unreachable, usage is not counted, unless the store fails
+// closed, when queries fail instead.
+type redisQuotas struct {
+	store *redisStore
+}
+
+// newQuotaStore returns the store usage is counted in: the Redis store, when
+// set, and otherwise the process.
+func newQuotaStore(store *redisStore) quotaStore {
+	if store == nil {
+		return newMemoryQuotas()
+	}
+	return &redisQuotas{store: store}
+}
+
+// key returns the key of the subject's counter with the suffix.
+func (r *redisQuotas) key(s quotaSubject, suffix string) string {
+	return r.store.key("quota:" + s.key() + ":" + suffix)
+}
+
+// subjectsKey returns the key of the set of subjects counted on the day of
+// now.
+func (r *redisQuotas) subjectsKey(now time.Time) string {
+	return r.store.key("quota:subjects:" + now.UTC().Format(time.DateOnly))
+}
+
+// counterKeys returns the keys of the subject's minute, day and concurrent
+// queries counters at now.
+func (r *redisQuotas) counterKeys(s quotaSubject, now time.Time) (string, string, string) {
+	return r.key(s, "minute:"+strconv.FormatInt(now.Unix()/60, 10)),
+		r.key(s, "day:"+now.UTC().Format(time.DateOnly)),
+		r.key(s, "concurrent")
+}
+
+// acquire satisfies the quotaStore interface.
+func (r *redisQuotas) acquire(ctx context.Context, subjects []quotaSubject, now time.Time) (func(), error) {
+	lease, expiry := newID(), now.Add(quotaLeaseTTL).UnixMilli()
+	keys := []string{r.subjectsKey(now)}
+	args := []interface{}{now.UnixMilli(), lease, expiry, quotaKeyTTL.Milliseconds()}
+	var concurrent []string
+	for _, s := range subjects {
+		minute, day, c := r.counterKeys(s, now)
+		keys, concurrent = append(keys, minute, day, c), append(concurrent, c)
+		l := s.Limits
+		args = append(args, l.QueriesPerMinute, l.QueriesPerDay, l.RowsPerDay, l.ConcurrentQueries)
+	}
+	for _, s := range subjects {
+		args = append(args, s.key())
+	}
+	res, err := acquireScript.Run(ctx, r.store.client, keys, args...).Slice()
+	if err != nil {
+		if err := r.store.failed("counting query against quotas", err); err != nil {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+	r.store.succeeded()
+	if i, _ := res[0].(int64); i >= 0 && int(i) < len(subjects) {
+		quota, _ := res[1].(string)
+		return nil, subjects[i].exceeded(quota, now)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			pipe := r.store.client.Pipeline()
+			for _, key := range concurrent {
+				pipe.ZRem(context.WithoutCancel(ctx), key, lease)
+			}
+			if _, err := pipe.Exec(context.WithoutCancel(ctx)); err != nil {
+				r.store.failed("ending query counted against quotas", err)
+			}
+		})
+	}, nil
+}
+
+// checkRows satisfies the quotaStore interface.
+func (r *redisQuotas) checkRows(ctx context.Context, subjects []quotaSubject, now time.Time) error {
+	pipe := r.store.client.Pipeline()
+	rows := make([]*redis.StringCmd, len(subjects))
+	for i, s := range subjects {
+		_, day, _ := r.counterKeys(s, now)
+		rows[i] = pipe.HGet(ctx, day, "rows")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return r.store.failed("checking rows against quotas", err)
+	}
+	r.store.succeeded()
+	for i, s := range subjects {
+		if n, _ := rows[i].Int64(); s.Limits.RowsPerDay > 0 && n >= s.Limits.RowsPerDay {
+			return s.exceeded(QuotaRowsPerDay, now)
+		}
+	}
+	return nil
+}
+
+// addRows satisfies the quotaStore interface.
+func (r *redisQuotas) addRows(ctx context.Context, subjects []quotaSubject, n int, now time.Time) {
+	pipe := r.store.client.Pipeline()
+	subjectsKey := r.subjectsKey(now)
+	for _, s := range subjects {
+		_, day, _ := r.counterKeys(s, now)
+		pipe.HIncrBy(ctx, day, "rows", int64(n))
+		pipe.PExpire(ctx, day, quotaKeyTTL)
+		pipe.SAdd(ctx, subjectsKey, s.key())
+	}
+	pipe.PExpire(ctx, subjectsKey, quotaKeyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		r.store.failed("counting rows against quotas", err)
+		return
+	}
+	r.store.succeeded()
+}
+
+// usage satisfies the quotaStore interface.
+func (r *redisQuotas) usage(ctx context.Context, now time.Time) []QuotaUsage {
+	members, err := r.store.client.SMembers(ctx, r.subjectsKey(now)).Result()
+	if err != nil {
+		r.store.failed("listing quota usage", err)
+		return []QuotaUsage{}
+	}
+	type counters struct {
+		minute     *redis.StringCmd
+		day        *redis.SliceCmd
+		concurrent *redis.IntCmd
+	}
+	pipe := r.store.client.Pipeline()
+	subjects, cmds := make([]quotaSubject, len(members)), make([]counters, len(members))
+	for i, member := range members {
+		tenant, principal, _ := strings.Cut(member, policy.TenantSeparator)
+		subjects[i] = quotaSubject{Principal: principal, Tenant: tenant}
+		minute, day, concurrent := r.counterKeys(subjects[i], now)
+		cmds[i] = counters{
+			minute:     pipe.Get(ctx, minute),
+			day:        pipe.HMGet(ctx, day, "queries", "rows"),
+			concurrent: pipe.ZCount(ctx, concurrent, strconv.FormatInt(now.UnixMilli(), 10), "+inf"),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		r.store.failed("listing quota usage", err)
+		return []QuotaUsage{}
+	}
+	r.store.succeeded()
+	usage := make([]QuotaUsage, len(members))
+	for i, s := range subjects {
+		u := QuotaUsage{
+			Principal: s.Principal,
+			Tenant:    s.Tenant,
+			Day:       now.UTC().Format(time.DateOnly),
+			Minute:    now.Truncate(time.Minute),
+		}
+		u.QueriesThisMinute, _ = cmds[i].minute.Int()
+		if counts := cmds[i].day.Val(); len(counts) == 2 {
+			u.QueriesToday, _ = strconv.Atoi(stringOf(counts[0]))
+			u.RowsToday, _ = strconv.ParseInt(stringOf(counts[1]), 10, 64)
+		}
+		u.ConcurrentQueries = int(cmds[i].concurrent.Val())
+		usage[i] = u
+	}
+	return usage
+}
+
+// stringOf returns the string value of a Redis reply, or "" for nil.
+func stringOf(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestRedisQuotas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	config := QuotaConfig{
+		QuotaLimits: QuotaLimits{QueriesPerMinute: 3, ConcurrentQueries: 1},
+		Principals: map[string]QuotaLimits{
+			"payments/dave": {ConcurrentQueries: 1},
+		},
+		Tenants: map[string]QuotaLimits{
+			"payments": {QueriesPerMinute: 3, RowsPerDay: 10},
+		},
+	}
+	// two servers sharing Redis
+	redisConfig := RedisConfig{URL: "redis://" + mr.Addr(), Prefix: "usqlr:"}
+	ra, err := newRedisStore(redisConfig)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer ra.close()
+	rb, _ := newRedisStore(redisConfig)
+	defer rb.close()
+	a, b := newQuotaGuard(config, newQuotaStore(ra)), newQuotaGuard(config, newQuotaStore(rb))
+	alice := policy.WithPrincipal(context.Background(), "alice")
+
+	var qerr *QuotaError
+	done, err := a.Acquire(alice)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := b.Acquire(alice); !errors.As(err, &qerr) || qerr.Quota != QuotaConcurrentQueries || qerr.Limit != 1 {
+		t.Errorf("expected the concurrent queries quota to be exceeded on the other server, got: %v", err)
+	}
+	done()
+	done()
+	for _, g := range []*QuotaGuard{b, a} {
+		if done, err = g.Acquire(alice); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		done()
+	}
+	if _, err := b.Acquire(alice); !errors.As(err, &qerr) || qerr.Quota != QuotaQueriesPerMinute || qerr.Principal != "alice" || qerr.ResetAt.IsZero() {
+		t.Errorf("expected the queries per minute quota to be exceeded, got: %v", err)
+	}
+
+	// principals of a tenant count against its quotas and theirs
+	dave := policy.WithTenant(policy.WithPrincipal(context.Background(), "dave"), "payments")
+	carol := policy.WithTenant(policy.WithPrincipal(context.Background(), "carol"), "payments")
+	done, err = a.Acquire(dave)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := b.Acquire(dave); !errors.As(err, &qerr) || qerr.Quota != QuotaConcurrentQueries || qerr.Principal != "dave" || qerr.Tenant != "payments" {
+		t.Errorf("expected the concurrent queries quota of dave to be exceeded, got: %v", err)
+	}
+	a.AddRows(dave, 6)
+	done()
+	if err := b.CheckRows(carol); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	b.AddRows(carol, 4)
+	if err := a.CheckRows(carol); !errors.As(err, &qerr) || qerr.Quota != QuotaRowsPerDay || qerr.Principal != "" || qerr.Tenant != "payments" {
+		t.Errorf("expected the rows per day quota of payments to be exceeded, got: %v", err)
+	}
+	if _, err := b.Acquire(carol); !errors.As(err, &qerr) || qerr.Quota != QuotaRowsPerDay {
+		t.Errorf("expected the rows per day quota to be exceeded, got: %v", err)
+	}
+
+	var names []string
+	for _, u := range b.Usage(context.Background()) {
+		names = append(names, u.Tenant+"/"+u.Principal)
+		switch {
+		case u.Principal == "alice" && (u.QueriesToday != 3 || u.QueriesThisMinute != 3 || u.ConcurrentQueries != 0 || u.Limits.QueriesPerMinute != 3):
+			t.Errorf("expected 3 queries of alice, got: %+v", u)
+		case u.Tenant == "payments" && u.Principal == "" && (u.QueriesToday != 1 || u.RowsToday != 10 || u.Limits.RowsPerDay != 10):
+			t.Errorf("expected a query and 10 rows of payments, got: %+v", u)
+		case u.Principal == "carol" && u.RowsToday != 4:
+			t.Errorf("expected 4 rows of carol, got: %+v", u)
+		}
+	}
+	if exp := []string{"/alice", "payments/", "payments/carol", "payments/dave"}; !slices.Equal(names, exp) {
+		t.Errorf("expected the usage of %v, got: %v", exp, names)
+	}
+
+	// queries are not counted while Redis is unreachable
+	mr.Close()
+	for i := 0; i < 3; i++ {
+		done, err := a.Acquire(alice)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		done()
+	}
+	if err := a.CheckRows(carol); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if h := ra.health(); h.Status != "unavailable" {
+		t.Errorf("expected Redis to be unavailable, got: %+v", h)
+	}
+	// unless failing closed
+	redisConfig.FailClosed = true
+	rc, _ := newRedisStore(redisConfig)
+	defer rc.close()
+	c := newQuotaGuard(config, newQuotaStore(rc))
+	if _, err := c.Acquire(alice); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("expected Redis to be unavailable, got: %v", err)
+	}
+	if err := c.CheckRows(carol); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("expected Redis to be unavailable, got: %v", err)
+	}
+}
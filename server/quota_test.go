@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+func TestQuotaGuard(t *testing.T) {
+	g := NewQuotaGuard(QuotaConfig{
+		QuotaLimits: QuotaLimits{QueriesPerMinute: 2, ConcurrentQueries: 1},
+		Principals: map[string]QuotaLimits{
+			"payments/dave": {QueriesPerMinute: 1},
+			"erin":          {RowsPerDay: 5},
+		},
+		Tenants: map[string]QuotaLimits{
+			"payments": {RowsPerDay: 10},
+		},
+	})
+	alice := policy.WithPrincipal(context.Background(), "alice")
+
+	var qerr *QuotaError
+	done, err := g.Acquire(alice)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := g.Acquire(alice); !errors.As(err, &qerr) || qerr.Quota != QuotaConcurrentQueries || !qerr.ResetAt.IsZero() {
+		t.Errorf("expected the concurrent queries quota to be exceeded, got: %v", err)
+	}
+	done()
+	done()
+	if done, err = g.Acquire(alice); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	done()
+	if _, err := g.Acquire(alice); !errors.As(err, &qerr) || qerr.Quota != QuotaQueriesPerMinute || qerr.Principal != "alice" || qerr.ResetAt.IsZero() {
+		t.Errorf("expected the queries per minute quota to be exceeded, got: %v", err)
+	}
+
+	// principals of a tenant share its quotas
+	bob := policy.WithTenant(policy.WithPrincipal(context.Background(), "bob"), "payments")
+	carol := policy.WithTenant(policy.WithPrincipal(context.Background(), "carol"), "payments")
+	done, err = g.Acquire(bob)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	g.AddRows(bob, 10)
+	done()
+	if err := g.CheckRows(carol); !errors.As(err, &qerr) || qerr.Quota != QuotaRowsPerDay || qerr.Tenant != "payments" {
+		t.Errorf("expected the rows per day quota to be exceeded, got: %v", err)
+	}
+	if _, err := g.Acquire(carol); !errors.As(err, &qerr) || qerr.Quota != QuotaRowsPerDay {
+		t.Errorf("expected the rows per day quota to be exceeded, got: %v", err)
+	}
+
+	// unauthenticated queries are not limited
+	for i := 0; i < 3; i++ {
+		if _, err := g.Acquire(context.Background()); err != nil {
+			t.Errorf("expected no error, got: %v", err)
+		}
+	}
+
+	// principals in a tenant also have the quotas set for them, by their
+	// qualified name or name
+	dave := policy.WithTenant(policy.WithPrincipal(context.Background(), "dave"), "billing")
+	if done, err = g.Acquire(dave); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	done()
+	if done, err = g.Acquire(dave); err != nil {
+		t.Errorf("expected the quota of payments/dave not to apply in billing, got: %v", err)
+	}
+	done()
+	dave = policy.WithTenant(policy.WithPrincipal(context.Background(), "dave"), "payments")
+	// without the default quotas, payments having run a query this minute
+	g.SetConfig(QuotaConfig{Principals: map[string]QuotaLimits{"payments/dave": {QueriesPerMinute: 1}, "erin": {RowsPerDay: 5}}})
+	if done, err = g.Acquire(dave); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	done()
+	if _, err := g.Acquire(dave); !errors.As(err, &qerr) || qerr.Quota != QuotaQueriesPerMinute || qerr.Principal != "dave" || qerr.Tenant != "payments" {
+		t.Errorf("expected the queries per minute quota of dave to be exceeded, got: %v", err)
+	}
+	erin := policy.WithTenant(policy.WithPrincipal(context.Background(), "erin"), "billing")
+	g.AddRows(erin, 5)
+	if err := g.CheckRows(erin); !errors.As(err, &qerr) || qerr.Principal != "erin" || qerr.Error() != "principal erin of tenant billing exceeded its quota of 5 rows per day, until "+qerr.ResetAt.Format(time.RFC3339) {
+		t.Errorf("expected the rows per day quota of erin to be exceeded, got: %v", err)
+	}
+
+	var names []string
+	for _, u := range g.Usage(context.Background()) {
+		names = append(names, u.Tenant+"/"+u.Principal)
+		switch {
+		case u.Principal == "alice" && u.QueriesToday != 2:
+			t.Errorf("expected 2 queries of alice, got: %+v", u)
+		case u.Tenant == "payments" && u.Principal == "" && u.RowsToday != 10:
+			t.Errorf("expected 10 rows of payments, got: %+v", u)
+		case u.Tenant == "payments" && u.Principal == "bob" && u.RowsToday != 10:
+			t.Errorf("expected 10 rows of bob, got: %+v", u)
+		}
+	}
+	if exp := []string{"/alice", "billing/", "billing/dave", "billing/erin", "payments/", "payments/bob", "payments/carol", "payments/dave"}; !slices.Equal(names, exp) {
+		t.Errorf("expected the usage of %v, got: %v", exp, names)
+	}
+}
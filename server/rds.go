@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/xo/dburl"
+)
+
+// rdsTokenExpiry is how long RDS IAM auth tokens are valid to open a
+// database connection.
+const rdsTokenExpiry = 15 * time.Minute
+
+// emptyPayloadHash is the SHA-256 hash of an empty request payload.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// rdsPorts are the default ports of the drivers RDS IAM auth supports.
+var rdsPorts = map[string]string{
+	"postgres": "5432",
+	"pgx":      "5432",
+	"mysql":    "3306",
+}
+
+// rdsTokenSource generates RDS and Aurora IAM auth tokens, presigned with
+// the AWS credentials of the server's environment (environment variables,
+// shared config, or the instance or task role).
+type rdsTokenSource struct {
+	endpoint    string
+	user        string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+}
+
+// newRDSTokenSource creates an RDS IAM auth token source for the DSN, whose
+// region is the aws_region parameter, the region of the RDS endpoint, or the
+// environment's default region.
+func newRDSTokenSource(ctx context.Context, u *dburl.URL) (TokenSource, error) {
+	port, ok := rdsPorts[u.Driver]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("driver %s is not supported", u.Driver)
+	case u.User.Username() == "":
+		return nil, errors.New("a database user is required")
+	case u.Hostname() == "":
+		return nil, errors.New("a host is required")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	region := u.Query().Get("aws_region")
+	if region == "" {
+		region = rdsRegion(u.Hostname())
+	}
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("unable to determine the AWS region: set aws_region")
+	}
+	return &rdsTokenSource{
+		endpoint:    net.JoinHostPort(u.Hostname(), port),
+		user:        u.User.Username(),
+		region:      cfg.Region,
+		credentials: cfg.Credentials,
+		signer:      v4.NewSigner(),
+	}, nil
+}
+
+// Token satisfies the TokenSource interface.
+func (s *rdsTokenSource) Token(ctx context.Context) (string, error) {
+	creds, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	query := url.Values{
+		"Action":        {"connect"},
+		"DBUser":        {s.user},
+		"X-Amz-Expires": {fmt.Sprint(int(rdsTokenExpiry / time.Second))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+s.endpoint+"/?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	signed, _, err := s.signer.PresignHTTP(ctx, creds, req, emptyPayloadHash, "rds-db", s.region, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+// rdsRegion returns the region of an RDS endpoint, such as
+// db.123456789012.us-east-1.rds.amazonaws.com, or "" for other hosts.
+func rdsRegion(host string) string {
+	parts := strings.Split(host, ".")
+	if n := len(parts); n >= 5 && strings.Join(parts[n-3:], ".") == "rds.amazonaws.com" {
+		return parts[n-4]
+	}
+	return ""
+}
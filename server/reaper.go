@@ -0,0 +1,202 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Collected counts the resources collected by the reaper.
+type Collected struct {
+	Sessions     int64 `json:"sessions"`
+	Transactions int64 `json:"transactions"`
+	Cursors      int64 `json:"cursors"`
+	Jobs         int64 `json:"jobs"`
+	Connections  int64 `json:"connections"`
+}
+
+// add adds the counts of other to c.
+func (c *Collected) add(other Collected) {
+	c.Sessions += other.Sessions
+	c.Transactions += other.Transactions
+	c.Cursors += other.Cursors
+	c.Jobs += other.Jobs
+	c.Connections += other.Connections
+}
+
+// ReaperStats describes the runs of the reaper, and what it collected.
+type ReaperStats struct {
+	Interval string    `json:"interval"`
+	Runs     int64     `json:"runs"`
+	LastRun  time.Time `json:"last_run,omitzero"`
+
+	// Last is what the last run collected, and Total what all runs did
+	Last  Collected `json:"last"`
+	Total Collected `json:"total"`
+}
+
+// reaperStats are the statistics of the reaper's runs.
+type reaperStats struct {
+	mu sync.Mutex
+	ReaperStats
+}
+
+// reap periodically collects expired resources, every reap interval, until
+// the pool is closed.
+func (cp *ConnectionPool) reap() {
+	timer := time.NewTimer(cp.reapInterval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-cp.stop:
+			return
+		case now := <-timer.C:
+			cp.collect(now)
+			timer.Reset(cp.reapInterval())
+		}
+	}
+}
+
+// reapInterval returns the configured reap interval, or else half the
+// shortest of the timeouts of the collected resources, between a second and
+// a minute.
+func (cp *ConnectionPool) reapInterval() time.Duration {
+	config := cp.config().Server
+	if config.ReapInterval > 0 {
+		return config.ReapInterval
+	}
+	interval := time.Minute
+	for _, d := range []time.Duration{
+		cp.sessions.ttl, cp.sessions.txTTL, cp.cursors.ttl, cp.jobs.config.ResultTTL,
+		config.ConnectionIdleTimeout, config.ConnectionMaxLifetime,
+	} {
+		if d > 0 && d/2 < interval {
+			interval = d / 2
+		}
+	}
+	return max(interval, time.Second)
+}
+
+// collect closes the sessions idle beyond the session idle timeout at now
+// (with their transactions, cursors and jobs), rolls back the transactions
+// idle beyond the transaction timeout, closes the expired cursors, removes
+// the expired job results, and closes the connections idle beyond the idle
+// timeout or open beyond the max lifetime, returning what was collected.
+func (cp *ConnectionPool) collect(now time.Time) Collected {
+	c := cp.sessions.expire(now)
+	c.Cursors += int64(cp.cursors.expire(now))
+	c.Jobs += int64(cp.jobs.expire(now))
+	if c != (Collected{}) {
+		poolLog.Info("reaper collected idle resources", "sessions", c.Sessions, "transactions", c.Transactions, "cursors", c.Cursors, "jobs", c.Jobs)
+	}
+	if ids := cp.reapConnections(now); len(ids) != 0 {
+		poolLog.Info("closed idle or expired connections", "connections", ids)
+		c.Connections = int64(len(ids))
+		cp.changed()
+	}
+
+	cp.reaper.mu.Lock()
+	defer cp.reaper.mu.Unlock()
+	cp.reaper.Runs++
+	cp.reaper.LastRun = now
+	cp.reaper.Last = c
+	cp.reaper.Total.add(c)
+	return c
+}
+
+// ReaperStats returns the statistics of the reaper's runs.
+func (cp *ConnectionPool) ReaperStats() ReaperStats {
+	cp.reaper.mu.Lock()
+	defer cp.reaper.mu.Unlock()
+	stats := cp.reaper.ReaperStats
+	stats.Interval = cp.reapInterval().String()
+	return stats
+}
+
+// handleReaper handles showing the statistics of the reaper.
+func (s *Server) handleReaper(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.ReaperStats())
+}
+
+// handleRunReaper handles running the reaper, responding with what it
+// collected.
+func (s *Server) handleRunReaper(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.collect(time.Now()))
+}
+
+// reapConnections closes and removes the connections that were idle beyond
+// the idle timeout or open beyond the max lifetime at now, returning their
+// IDs. Connections running a query or holding open cursors are left open,
+// as are connections not yet dialed, which hold no database connections,
+// and predefined connections.
+func (cp *ConnectionPool) reapConnections(now time.Time) []string {
+	config := cp.config().Server
+	idle, lifetime := config.ConnectionIdleTimeout, config.ConnectionMaxLifetime
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	var ids []string
+	for id, conn := range cp.connections {
+		conn.mu.RLock()
+		created, lastUsed := conn.Created, conn.LastUsed
+		conn.mu.RUnlock()
+		expired := (idle > 0 && now.Sub(lastUsed) >= idle) || (lifetime > 0 && now.Sub(created) >= lifetime)
+		if !expired || conn.predefined != nil || conn.pending.Load() || conn.busy() || cp.cursors.count(id) != 0 {
+			continue
+		}
+		cp.remove(id, conn, "idle or expired")
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// evictLRU closes and removes the least recently used idle connection,
+// returning its ID, or false when no connection is idle. Connections running
+// a query or holding open cursors are not idle, and predefined connections
+// are never evicted. The lock must be held.
+func (cp *ConnectionPool) evictLRU() (string, bool) {
+	var lru *Connection
+	var lruUsed time.Time
+	for id, conn := range cp.connections {
+		if conn.predefined != nil || conn.busy() || cp.cursors.count(id) != 0 {
+			continue
+		}
+		conn.mu.RLock()
+		lastUsed := conn.LastUsed
+		conn.mu.RUnlock()
+		if lru == nil || lastUsed.Before(lruUsed) {
+			lru, lruUsed = conn, lastUsed
+		}
+	}
+	if lru == nil {
+		return "", false
+	}
+	id := lru.ID
+	cp.remove(id, lru, "evicted")
+	return id, true
+}
+
+// use marks the connection as in use until the returned func is called,
+// which also calls release.
+func (conn *Connection) use(release func()) func() {
+	conn.active.Add(1)
+	return func() {
+		conn.active.Add(-1)
+		release()
+	}
+}
+
+// busy returns whether the connection or any of its replicas is running a
+// query.
+func (conn *Connection) busy() bool {
+	if conn.active.Load() != 0 {
+		return true
+	}
+	for _, replica := range conn.replicaList() {
+		if replica.active.Load() != 0 {
+			return true
+		}
+	}
+	return false
+}
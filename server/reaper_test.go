@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestReapConnections(t *testing.T) {
+	cp := NewConnectionPool(&Config{Server: ServerConfig{ConnectionIdleTimeout: time.Minute, ConnectionMaxLifetime: time.Hour}}, nil, nil)
+	defer cp.Close()
+	changed := 0
+	cp.OnListChanged(func() { changed++ })
+
+	now := time.Now()
+	for id, times := range map[string][2]time.Time{
+		"fresh": {now.Add(-time.Minute / 2), now.Add(-time.Minute / 2)},
+		"idle":  {now.Add(-2 * time.Minute), now.Add(-2 * time.Minute)},
+		"old":   {now.Add(-2 * time.Hour), now},
+		"busy":  {now.Add(-2 * time.Minute), now.Add(-2 * time.Minute)},
+	} {
+		cp.connections[id] = &Connection{ID: id, DB: sql.OpenDB(multiConnector{}), Created: times[0], LastUsed: times[1]}
+	}
+
+	// busy is in use until released
+	release := cp.connections["busy"].use(func() {})
+	if ids, exp := cp.reapConnections(now), []string{"idle", "old"}; !slices.Equal(ids, exp) {
+		t.Errorf("expected %v to be reaped, got: %v", exp, ids)
+	}
+	release()
+	if ids, exp := cp.reapConnections(now), []string{"busy"}; !slices.Equal(ids, exp) {
+		t.Errorf("expected %v to be reaped, got: %v", exp, ids)
+	}
+	if _, ok := cp.connections["fresh"]; !ok || len(cp.connections) != 1 {
+		t.Errorf("expected only fresh to remain, got: %d connections", len(cp.connections))
+	}
+
+	if err := cp.CloseConnection("fresh"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("expected the list changed func to be called once, got: %d", changed)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	cp := NewConnectionPool(&Config{Server: ServerConfig{SessionIdleTimeout: time.Minute, CursorTTL: time.Minute}, Jobs: JobConfig{MaxQueued: 2, ResultTTL: time.Minute}}, nil, nil)
+	defer cp.Close()
+	cp.connections["multi"] = &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+
+	// the cursor and job of the idle session are collected with it, and
+	// the others once expired
+	ctx := context.Background()
+	idle, active := cp.sessions.Create(ctx), cp.sessions.Create(ctx)
+	var jobs []string
+	for _, ctx := range []context.Context{idle.Bind(ctx), ctx} {
+		cursor, err := cp.OpenCursor(ctx, "multi", "SELECT a")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if sessionFrom(ctx) == idle {
+			idle.AddCursor(cursor.ID)
+		}
+		job, err := cp.SubmitJob(ctx, "multi", "SELECT a")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		jobs = append(jobs, job.ID)
+	}
+	for _, id := range jobs {
+		if _, err := cp.jobs.Status(ctx, id, time.Second); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	later := time.Now().Add(90 * time.Second)
+	active.touch(later)
+
+	if c, exp := cp.collect(later), (Collected{Sessions: 1, Cursors: 2, Jobs: 2}); c != exp {
+		t.Errorf("expected %+v to be collected, got: %+v", exp, c)
+	}
+	if c := cp.collect(later); c != (Collected{}) {
+		t.Errorf("expected nothing left to collect, got: %+v", c)
+	}
+	if stats := cp.ReaperStats(); stats.Runs != 2 || stats.Total.Sessions != 1 || stats.Total.Jobs != 2 || stats.Last != (Collected{}) {
+		t.Errorf("expected the statistics of both runs, got: %+v", stats)
+	}
+	if _, ok := cp.sessions.Get(ctx, active.ID); !ok {
+		t.Errorf("expected the active session to remain")
+	}
+}
+
+func TestEvictLRU(t *testing.T) {
+	cp := NewConnectionPool(&Config{Server: ServerConfig{MaxConnections: 3, PoolLimitPolicy: PoolLimitEvictLRU}}, nil, nil)
+	defer cp.Close()
+
+	now := time.Now()
+	for id, lastUsed := range map[string]time.Time{
+		"busy":   now.Add(-time.Hour),
+		"lru":    now.Add(-time.Minute),
+		"recent": now,
+	} {
+		cp.connections[id] = &Connection{ID: id, DB: sql.OpenDB(multiConnector{}), LastUsed: lastUsed}
+	}
+	release := cp.connections["busy"].use(func() {})
+	defer release()
+
+	if id, ok := cp.evictLRU(); !ok || id != "lru" {
+		t.Errorf("expected lru to be evicted, got: %q %t", id, ok)
+	}
+	if id, ok := cp.evictLRU(); !ok || id != "recent" {
+		t.Errorf("expected recent to be evicted, got: %q %t", id, ok)
+	}
+	// busy connections are never evicted
+	if id, ok := cp.evictLRU(); ok {
+		t.Errorf("expected no connection to be evicted, got: %q", id)
+	}
+
+	// the config is replaced rather than modified, as the reaper reads it
+	config := *cp.config()
+	config.Server.MaxConnections = 1
+	cp.conf.Store(&config)
+	if err := cp.RegisterConnection(context.Background(), "new", "postgres://localhost/db", nil); err == nil || !strings.Contains(err.Error(), "no connection is idle") {
+		t.Errorf("expected an error, got: %v", err)
+	}
+	strict := config
+	strict.Server.PoolLimitPolicy = PoolLimitStrict
+	cp.conf.Store(&strict)
+	if err := cp.RegisterConnection(context.Background(), "new", "postgres://localhost/db", nil); err == nil || !strings.Contains(err.Error(), "pool limit reached") {
+		t.Errorf("expected an error, got: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRedisUnavailable is returned for queries limited in Redis while it is
+// unreachable, when configured to fail closed.
+var ErrRedisUnavailable = errors.New("redis unavailable")
+
+// leaseTTL is how long a lease on a slot is held in Redis, unless released
+// before, so that the slots of a server that stopped without releasing them
+// are not held forever.
+const leaseTTL = time.Hour
+
+// leaseScript takes a lease on one of the slots of a limit, unless all are
+// leased, returning 1 when taken. KEYS[1] is the sorted set of the leases,
+// scored by their expiry; ARGV the time, the limit, the lease and its expiry.
+var leaseScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[4], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4] - ARGV[1])
+return 1
+`)
+
+// RedisHealth is the status of the Redis server limits are counted in:
+// "ok", or "unavailable" when its last command failed.
+type RedisHealth struct {
+	Status      string    `json:"status"`
+	FailClosed  bool      `json:"fail_closed"`
+	Errors      int64     `json:"errors"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+}
+
+// redisStore is the Redis server shared by the servers of a cluster, which
+// limits are counted in under keys with the prefix. A nil store counts
+// nothing.
+type redisStore struct {
+	client     *redis.Client
+	prefix     string
+	failClosed bool
+
+	mu        sync.Mutex
+	down      bool
+	errors    int64
+	lastErr   error
+	lastErrAt time.Time
+}
+
+// newRedisStore returns the Redis store of the config, or nil when no URL is
+// set.
+func newRedisStore(config RedisConfig) (*redisStore, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	opts, err := redis.ParseURL(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisStore{
+		client:     redis.NewClient(opts),
+		prefix:     config.Prefix,
+		failClosed: config.FailClosed,
+	}, nil
+}
+
+// key returns the key of the name, with the store's prefix.
+func (r *redisStore) key(name string) string {
+	return r.prefix + name
+}
+
+// failed records a failed command, returning the error queries fail with
+// when failing closed, or nil when they are not limited while Redis is
+// unreachable.
+func (r *redisStore) failed(op string, err error) error {
+	r.mu.Lock()
+	r.down, r.lastErr, r.lastErrAt = true, err, time.Now()
+	r.errors++
+	r.mu.Unlock()
+	poolLog.Error("Redis error", "op", op, "error", err)
+	if r.failClosed {
+		return fmt.Errorf("%s: %w: %w", op, ErrRedisUnavailable, err)
+	}
+	return nil
+}
+
+// succeeded records a successful command.
+func (r *redisStore) succeeded() {
+	r.mu.Lock()
+	r.down = false
+	r.mu.Unlock()
+}
+
+// health returns the store's status, or nil for a nil store.
+func (r *redisStore) health() *RedisHealth {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := &RedisHealth{
+		Status:      "ok",
+		FailClosed:  r.failClosed,
+		Errors:      r.errors,
+		LastErrorAt: r.lastErrAt,
+	}
+	if r.down {
+		h.Status = "unavailable"
+	}
+	if r.lastErr != nil {
+		h.LastError = r.lastErr.Error()
+	}
+	return h
+}
+
+// lease waits for a lease on one of the limit slots of the name, shared by
+// the servers using the store, returning a func to release it. A nil store
+// or a limit of 0 is unlimited.
+func (r *redisStore) lease(ctx context.Context, name string, limit int) (func(), error) {
+	if r == nil || limit <= 0 {
+		return func() {}, nil
+	}
+	key, id := r.key(name), newID()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for wait := 10 * time.Millisecond; ; wait = min(wait*2, time.Second) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		now := time.Now()
+		ok, err := leaseScript.Run(ctx, r.client, []string{key}, now.UnixMilli(), limit, id, now.Add(leaseTTL).UnixMilli()).Bool()
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			if err := r.failed("leasing "+name, err); err != nil {
+				return nil, err
+			}
+			return func() {}, nil
+		case ok:
+			r.succeeded()
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					if err := r.client.ZRem(context.WithoutCancel(ctx), key, id).Err(); err != nil {
+						r.failed("releasing "+name, err)
+					}
+				})
+			}, nil
+		}
+		r.succeeded()
+		timer.Reset(wait)
+	}
+}
+
+// close closes the store's client.
+func (r *redisStore) close() error {
+	if r == nil {
+		return nil
+	}
+	return r.client.Close()
+}
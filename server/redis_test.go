@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisThrottle(t *testing.T) {
+	mr := miniredis.RunT(t)
+	config := RedisConfig{URL: "redis://" + mr.Addr(), Prefix: "usqlr:"}
+	// two servers sharing Redis
+	a, err := newRedisStore(config)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer a.close()
+	b, _ := newRedisStore(config)
+	defer b.close()
+	ta := newThrottle(ServerConfig{MaxConcurrentPerHost: 1}, a)
+	tb := newThrottle(ServerConfig{MaxConcurrentPerHost: 1}, b)
+
+	release, err := ta.Acquire(context.Background(), "db:5432")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !mr.Exists("usqlr:throttle:host:db:5432") {
+		t.Errorf("expected the host's slots to be leased under the prefix")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tb.Acquire(ctx, "db:5432"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected to wait for the host's slot held by the other server, got: %v", err)
+	}
+	other, err := tb.Acquire(context.Background(), "other:5432")
+	if err != nil {
+		t.Fatalf("expected no error acquiring a slot on another host, got: %v", err)
+	}
+	other()
+
+	// the slot is acquired once the other server released it
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		release, err := tb.Acquire(ctx, "db:5432")
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	release()
+	if err := <-done; err != nil {
+		t.Errorf("expected the slot to be acquired once released, got: %v", err)
+	}
+	if h := a.health(); h.Status != "ok" || h.Errors != 0 {
+		t.Errorf("expected Redis to be healthy, got: %+v", h)
+	}
+
+	// queries are not limited while Redis is unreachable, unless failing
+	// closed
+	mr.Close()
+	release, err = ta.Acquire(context.Background(), "db:5432")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	release()
+	if h := a.health(); h.Status != "unavailable" || h.Errors == 0 || h.LastError == "" || h.LastErrorAt.IsZero() {
+		t.Errorf("expected Redis to be unavailable, got: %+v", h)
+	}
+	config.FailClosed = true
+	c, _ := newRedisStore(config)
+	defer c.close()
+	if _, err := newThrottle(ServerConfig{MaxConcurrentPerHost: 1}, c).Acquire(context.Background(), "db:5432"); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("expected Redis to be unavailable, got: %v", err)
+	}
+	// unlimited hosts are not leased in Redis
+	if _, err := newThrottle(ServerConfig{}, c).Acquire(context.Background(), "db:5432"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestRedisHealth(t *testing.T) {
+	if _, err := New(&Config{Redis: RedisConfig{URL: "http://localhost"}}); err == nil {
+		t.Errorf("expected an error for an invalid Redis URL")
+	}
+	mr := miniredis.RunT(t)
+	s, err := New(&Config{Server: ServerConfig{MaxConcurrentPerHost: 1}, Redis: RedisConfig{URL: "redis://" + mr.Addr()}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+
+	for _, exp := range []string{"healthy", "degraded"} {
+		release, err := s.pool.throttle.Acquire(context.Background(), "db:5432")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		release()
+		w := httptest.NewRecorder()
+		s.handleHealth(w, httptest.NewRequest("GET", "/health", nil))
+		var health struct {
+			Status string      `json:"status"`
+			Redis  RedisHealth `json:"redis"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil || health.Status != exp {
+			t.Errorf("expected %s, got: %s", exp, w.Body.String())
+		}
+		mr.Close()
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xo/usql/server/logging"
+)
+
+// Reload applies a reloaded configuration to the running server, without
+// closing connections in use or interrupting queries. The server's limits
+// (such as the pool size and limit policy, row caps, request timeout and
+// idle and expired connection timeouts), cost limits and quotas apply from
+// the next request on, and predefined connections are created, replaced or closed
+// following their definitions, API keys are read again from the keys file,
+// and the log levels are set, replacing those set through the admin API.
+// Other settings only apply once the server restarts.
+func (s *Server) Reload(ctx context.Context, config *Config) error {
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+	ips, err := newIPFilter(config.Server)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.certs == nil && config.Server.TLS.enabled(), s.certs != nil && !config.Server.TLS.enabled():
+		serverLog.Warn("enabling or disabling TLS takes effect on restart")
+	case s.certs != nil:
+		if err := s.certs.reload(config.Server.TLS); err != nil {
+			return err
+		}
+	}
+	if !reflect.DeepEqual(config.Server.Listeners, s.config().Server.Listeners) {
+		serverLog.Warn("changing listeners takes effect on restart")
+	}
+	if err := logging.SetLevels(config.Logging); err != nil {
+		return err
+	}
+	s.conf.Store(config)
+	s.ips.Store(ips)
+	s.pool.reconfigure(config)
+	s.reloadConnections(ctx, config.Connections)
+	if s.keys != nil {
+		if err := s.keys.Reload(); err != nil {
+			authLog.Error("failed to reload API keys", "error", err)
+		}
+	}
+	return nil
+}
+
+// validateConfig validates the settings of the config that are not
+// validated when used.
+func validateConfig(config *Config) error {
+	switch config.Server.PoolLimitPolicy {
+	case "", PoolLimitStrict, PoolLimitEvictLRU:
+	default:
+		return fmt.Errorf("invalid pool limit policy %q: must be %s or %s", config.Server.PoolLimitPolicy, PoolLimitStrict, PoolLimitEvictLRU)
+	}
+	if err := validateConnections(config.Connections); err != nil {
+		return fmt.Errorf("invalid connections: %w", err)
+	}
+	if err := validateGroups(config.Groups, config.Connections); err != nil {
+		return fmt.Errorf("invalid groups: %w", err)
+	}
+	if err := validateRoles(config.Roles); err != nil {
+		return fmt.Errorf("invalid roles: %w", err)
+	}
+	if path := config.Metrics.Path; path != "" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid metrics path %q: must start with /", path)
+	}
+	if config.Server.TLS.enabled() {
+		if _, err := config.Server.TLS.tlsConfig(); err != nil {
+			return fmt.Errorf("invalid TLS: %w", err)
+		}
+	}
+	if err := validateListeners(config.Server.Listeners, config.Server.TLS.enabled()); err != nil {
+		return fmt.Errorf("invalid listeners: %w", err)
+	}
+	if config.Proxy.URL != "" {
+		if _, err := newProxyDial(config.Proxy.URL); err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+	}
+	if config.Redis.URL != "" {
+		if _, err := redis.ParseURL(config.Redis.URL); err != nil {
+			return fmt.Errorf("invalid redis: %w", err)
+		}
+	}
+	return validateSecrets(config.Secrets)
+}
+
+// reloadConnections creates the predefined connections that are not in the
+// pool, replaces those whose definition changed, and closes those no longer
+// defined. Connections in use are left as is, and are replaced or closed on
+// a later reload.
+func (s *Server) reloadConnections(ctx context.Context, connections []ConnectionConfig) {
+	defined := make(map[string]bool, len(connections))
+	for _, c := range connections {
+		defined[c.ID] = true
+		prev, exists := s.pool.definedBy(c.ID)
+		switch {
+		case exists && prev == nil:
+			poolLog.Error("failed to create predefined connection: a connection with the ID already exists", "connection", c.ID)
+			continue
+		case exists && reflect.DeepEqual(*prev, c):
+			continue
+		case exists && !s.pool.closePredefined(c.ID):
+			poolLog.Info("predefined connection is in use, and is replaced on a later reload", "connection", c.ID)
+			continue
+		}
+		def, err := c.definition()
+		if err == nil {
+			err = s.restoreConnection(ctx, def)
+		}
+		if err != nil {
+			poolLog.Error("failed to create predefined connection", "connection", c.ID, "error", err)
+		}
+	}
+	for _, id := range s.pool.predefinedIDs() {
+		if !defined[id] && !s.pool.closePredefined(id) {
+			poolLog.Info("predefined connection is in use, and is closed on a later reload", "connection", id)
+		}
+	}
+}
+
+// reconfigure replaces the pool's configuration. Connections keep the
+// prepared statement and result limits they were created with.
+func (cp *ConnectionPool) reconfigure(config *Config) {
+	cp.conf.Store(config)
+	cp.cost.SetConfig(config.Cost)
+	cp.quota.SetConfig(config.Quotas)
+}
+
+// definedBy returns the config defining the connection with the ID, nil
+// for a connection not defined in the config, and whether the connection is
+// in the pool.
+func (cp *ConnectionPool) definedBy(id string) (*ConnectionConfig, bool) {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	conn, ok := cp.connections[id]
+	if !ok {
+		return nil, false
+	}
+	return conn.predefined, true
+}
+
+// predefinedIDs returns the IDs of the predefined connections in the pool.
+func (cp *ConnectionPool) predefinedIDs() []string {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	var ids []string
+	for id, conn := range cp.connections {
+		if conn.predefined != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// closePredefined closes and removes the predefined connection with the ID,
+// unless it is running a query or holding open cursors, returning whether it
+// is no longer in the pool.
+func (cp *ConnectionPool) closePredefined(id string) bool {
+	cp.mu.Lock()
+	conn, ok := cp.connections[id]
+	switch {
+	case !ok || conn.predefined == nil:
+		cp.mu.Unlock()
+		return !ok
+	case conn.busy() || cp.cursors.count(id) != 0:
+		cp.mu.Unlock()
+		return false
+	}
+	cp.remove(id, conn, "removed from the config")
+	cp.mu.Unlock()
+
+	cp.changed()
+	return true
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestReload(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	cp := NewConnectionPool(&Config{Server: ServerConfig{MaxConnections: 10}}, nil, nil)
+	defer cp.Close()
+	s := &Server{pool: cp}
+	s.conf.Store(cp.config())
+
+	main := ConnectionConfig{ID: "main", DSN: "postgres://localhost/db"}
+	for _, id := range []string{"main", "busy", "removed", "dynamic"} {
+		conn := &Connection{ID: id, URL: u, DB: sql.OpenDB(multiConnector{})}
+		if id != "dynamic" {
+			conn.predefined = &ConnectionConfig{ID: id, DSN: "postgres://localhost/db"}
+		}
+		cp.connections[id] = conn
+	}
+	release := cp.connections["busy"].use(func() {})
+	defer release()
+
+	// invalid configs are not applied
+	if err := s.Reload(context.Background(), &Config{Server: ServerConfig{PoolLimitPolicy: "random"}}); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// connections no longer defined are closed, unless in use
+	config := &Config{Server: ServerConfig{MaxConnections: 2}, Connections: []ConnectionConfig{main}}
+	if err := s.Reload(context.Background(), config); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for id, exp := range map[string]bool{"main": true, "busy": true, "removed": false, "dynamic": true} {
+		if _, ok := cp.connections[id]; ok != exp {
+			t.Errorf("expected connection %s in the pool to be %t", id, exp)
+		}
+	}
+	release()
+	if err := s.Reload(context.Background(), config); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, ok := cp.connections["busy"]; ok {
+		t.Errorf("expected connection busy to be closed once idle")
+	}
+
+	// limits apply from the next request on
+	if s.config().Server.MaxConnections != 2 {
+		t.Errorf("expected the config to be replaced")
+	}
+	if err := cp.RegisterConnection(context.Background(), "new", "postgres://localhost/db", nil); err == nil || !strings.Contains(err.Error(), "max: 2") {
+		t.Errorf("expected the new pool limit, got: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestMultipleResultSets(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+
+	result, err := conn.ExecuteQuery(context.Background(), "SELECT a; UPDATE t; SELECT b")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(result.Columns, []string{"a"}) || len(result.Rows) != 2 {
+		t.Errorf("expected first result set with column a and 2 rows, got: %v %v", result.Columns, result.Rows)
+	}
+	if len(result.MoreResultSets) != 1 {
+		t.Fatalf("expected 1 more result set, got: %d", len(result.MoreResultSets))
+	}
+	if set := result.MoreResultSets[0]; !reflect.DeepEqual(set.Columns, []string{"b"}) || len(set.Rows) != 1 {
+		t.Errorf("expected second result set with column b and 1 row, got: %v %v", set.Columns, set.Rows)
+	}
+
+	result, truncated, err := conn.query(context.Background(), ResultLimits{Rows: 2}, nil, "SELECT a; UPDATE t; SELECT b")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !truncated:
+		t.Errorf("expected result to be truncated")
+	case len(result.Rows) != 2 || len(result.MoreResultSets) != 1 || len(result.MoreResultSets[0].Rows) != 0:
+		t.Errorf("expected the limit to apply across result sets, got: %v %v", result.Rows, result.MoreResultSets[0].Rows)
+	}
+}
+
+func TestResultLimits(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), limits: ResultLimits{Bytes: 16}}
+	defer conn.DB.Close()
+
+	// the second row of a fits, but not the row of b
+	result, err := conn.ExecuteQuery(context.Background(), "SELECT a; UPDATE t; SELECT b")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !result.Truncated || len(result.Rows) != 2 || len(result.MoreResultSets[0].Rows) != 0:
+		t.Errorf("expected result truncated after 2 rows, got: %t %v", result.Truncated, result.Rows)
+	}
+	result, err = conn.executeQuery(context.Background(), ResultLimits{Bytes: 100}, nil, "SELECT a")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !result.Truncated || len(result.Rows) != 2:
+		t.Errorf("expected requested limit bounded by the server's, got: %t %v", result.Truncated, result.Rows)
+	}
+
+	tests := []struct {
+		max, req, exp ResultLimits
+	}{
+		{ResultLimits{}, ResultLimits{}, ResultLimits{}},
+		{ResultLimits{}, ResultLimits{Rows: 5, Bytes: 10}, ResultLimits{Rows: 5, Bytes: 10}},
+		{ResultLimits{Rows: 3, Bytes: 100}, ResultLimits{}, ResultLimits{Rows: 3, Bytes: 100}},
+		{ResultLimits{Rows: 3, Bytes: 100}, ResultLimits{Rows: 5, Bytes: 10}, ResultLimits{Rows: 3, Bytes: 10}},
+	}
+	for i, test := range tests {
+		if l := test.max.bound(test.req); l != test.exp {
+			t.Errorf("test %d: expected %v, got: %v", i, test.exp, l)
+		}
+	}
+}
+
+func TestRowIterator(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+
+	it, err := conn.QueryRows(context.Background(), "SELECT a; UPDATE t; SELECT b")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer it.Close()
+
+	var sets [][]string
+	var rows [][]interface{}
+	for {
+		sets = append(sets, it.Columns)
+		for it.Next() {
+			rows = append(rows, it.Row())
+		}
+		if !it.NextResultSet() {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if exp := [][]string{{"a"}, {"b"}}; !reflect.DeepEqual(sets, exp) {
+		t.Errorf("expected result sets %v, got: %v", exp, sets)
+	}
+	if exp := [][]interface{}{{int64(1)}, {int64(2)}, {"x"}}; !reflect.DeepEqual(rows, exp) {
+		t.Errorf("expected rows %v, got: %v", exp, rows)
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	cp := NewConnectionPool(&Config{Server: ServerConfig{MaxRows: 5}}, nil, nil)
+	defer cp.cursors.Shutdown()
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	cp.connections[conn.ID] = conn
+
+	result, err := cp.QueryPage(context.Background(), "multi", "SELECT a", 0, ResultLimits{Bytes: 8})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case len(result.Rows) != 1 || result.ContinuationToken == "" || result.Truncated:
+		t.Fatalf("expected page to end at the bytes limit, got: %v %q", result.Rows, result.ContinuationToken)
+	}
+	cp.cursors.Close(result.ContinuationToken)
+
+	result, err = cp.QueryPage(context.Background(), "multi", "SELECT a", 1, ResultLimits{})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case len(result.Rows) != 1 || result.ContinuationToken == "":
+		t.Fatalf("expected 1 row and a continuation token, got: %v %q", result.Rows, result.ContinuationToken)
+	}
+	if _, err := cp.ContinueQuery(context.Background(), "other", result.ContinuationToken, 1, ResultLimits{}); err == nil {
+		t.Errorf("expected error continuing on another connection")
+	}
+
+	result, err = cp.ContinueQuery(context.Background(), "multi", result.ContinuationToken, 0, ResultLimits{})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !reflect.DeepEqual(result.Rows, [][]interface{}{{int64(2)}}) || result.ContinuationToken != "":
+		t.Errorf("expected last row without a continuation token, got: %v %q", result.Rows, result.ContinuationToken)
+	case !reflect.DeepEqual(result.Columns, []string{"a"}):
+		t.Errorf("expected columns [a], got: %v", result.Columns)
+	}
+
+	tests := []struct {
+		max, maxRows, exp int
+	}{
+		{0, 0, 0},
+		{0, 7, 7},
+		{5, 0, 5},
+		{5, 3, 3},
+		{5, 7, 5},
+	}
+	for i, test := range tests {
+		// the config is replaced rather than modified, as the reaper reads it
+		config := *cp.config()
+		config.Server.MaxRows = test.max
+		cp.conf.Store(&config)
+		if n := cp.rowCap(test.maxRows); n != test.exp {
+			t.Errorf("test %d: expected %d, got: %d", i, test.exp, n)
+		}
+	}
+}
+
+func TestResultMasking(t *testing.T) {
+	policies, err := policy.Parse(strings.NewReader("name: mask\nrules: []\nmasks:\n  - columns: [a]\n    method: partial\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	engine, _ := policy.NewEngine(policies, policy.Allow)
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), policy: engine}
+	defer conn.DB.Close()
+
+	result, err := conn.ExecuteQuery(context.Background(), "SELECT a; UPDATE t; SELECT b")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !reflect.DeepEqual(result.Rows, [][]interface{}{{"*"}, {"*"}}) || result.JSONTypes[0] != jsonString:
+		t.Errorf("expected column a to be masked, got: %v %v", result.Rows, result.JSONTypes)
+	case !reflect.DeepEqual(result.MoreResultSets[0].Rows, [][]interface{}{{"x"}}):
+		t.Errorf("expected column b to be unmasked, got: %v", result.MoreResultSets[0].Rows)
+	}
+
+	it, err := conn.QueryRows(context.Background(), "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer it.Close()
+	if !it.Next() || it.Row()[0] != "*" {
+		t.Errorf("expected streamed rows to be masked, got: %v %v", it.Row(), it.Err())
+	}
+
+	cm := NewCursorManager(time.Minute, 0)
+	defer cm.Shutdown()
+	cursor, err := cm.Open(context.Background(), conn, "SELECT a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if page, err := cm.Fetch(context.Background(), cursor.ID, 1); err != nil || !reflect.DeepEqual(page.Rows, [][]interface{}{{"*"}}) {
+		t.Errorf("expected cursor rows to be masked, got: %v %v", page, err)
+	}
+}
+
+// multiConnector is a driver connector whose queries return three result
+// sets: two rows of column a, an empty set without columns, and one row of
+// column b.
+type multiConnector struct{}
+
+func (multiConnector) Connect(context.Context) (driver.Conn, error) { return multiConn{}, nil }
+func (multiConnector) Driver() driver.Driver                        { return nil }
+
+type multiConn struct{}
+
+func (multiConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (multiConn) Close() error                        { return nil }
+func (multiConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (multiConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &multiRows{sets: []multiSet{
+		{[]string{"a"}, [][]driver.Value{{int64(1)}, {int64(2)}}},
+		{nil, nil},
+		{[]string{"b"}, [][]driver.Value{{"x"}}},
+	}}, nil
+}
+
+type multiSet struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+type multiRows struct {
+	sets []multiSet
+	set  int
+	row  int
+}
+
+func (r *multiRows) Columns() []string { return r.sets[r.set].columns }
+func (r *multiRows) Close() error      { return nil }
+
+func (r *multiRows) Next(dest []driver.Value) error {
+	if r.row == len(r.sets[r.set].rows) {
+		return io.EOF
+	}
+	copy(dest, r.sets[r.set].rows[r.row])
+	r.row++
+	return nil
+}
+
+func (r *multiRows) HasNextResultSet() bool { return r.set < len(r.sets)-1 }
+
+func (r *multiRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set, r.row = r.set+1, 0
+	return nil
+}
+
+func BenchmarkReadResultSets(b *testing.B) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "bench", URL: u, DB: sql.OpenDB(benchConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.ExecuteQuery(ctx, "SELECT id, name, price, paid"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRowIterator(b *testing.B) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	conn := &Connection{ID: "bench", URL: u, DB: sql.OpenDB(benchConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		it, err := conn.QueryRows(ctx, "SELECT id, name, price, paid")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for it.Next() {
+		}
+		if err := it.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchConnector is a driver connector whose queries return the 1000 rows
+// of four columns of benchRows.
+type benchConnector struct{}
+
+func (benchConnector) Connect(context.Context) (driver.Conn, error) { return benchConn{}, nil }
+func (benchConnector) Driver() driver.Driver                        { return nil }
+
+type benchConn struct{ multiConn }
+
+func (benchConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &multiRows{sets: []multiSet{{[]string{"id", "name", "price", "paid"}, benchRows}}}, nil
+}
+
+var benchRows = func() [][]driver.Value {
+	rows := make([][]driver.Value, 1000)
+	for i := range rows {
+		rows[i] = []driver.Value{int64(i), "name", 1.5, true}
+	}
+	return rows
+}()
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"slices"
+)
+
+// roleScopePrefix is the prefix of the scopes granting principals roles,
+// such as role:analyst.
+const roleScopePrefix = "role:"
+
+// RoleConfig is a role, granting the principals matching any of its glob
+// patterns (or granted the role by a role:<name> scope) the tools and
+// connections it lists. Connections are listed by glob patterns of their
+// IDs, or by tags, selecting the connections with all of the tags. Tools are
+// listed by class (query, statement or admin) or by glob patterns of their
+// names.
+type RoleConfig struct {
+	Name        string            `mapstructure:"name" yaml:"name" json:"name"`
+	Principals  []string          `mapstructure:"principals" yaml:"principals,omitempty" json:"principals,omitempty"`
+	Connections []string          `mapstructure:"connections" yaml:"connections,omitempty" json:"connections,omitempty"`
+	Tags        map[string]string `mapstructure:"tags" yaml:"tags,omitempty" json:"tags,omitempty"`
+	Tools       []string          `mapstructure:"tools" yaml:"tools" json:"tools"`
+}
+
+// validateRoles validates the roles.
+func validateRoles(roles []RoleConfig) error {
+	names := make(map[string]bool, len(roles))
+	for i, role := range roles {
+		if role.Name == "" {
+			return fmt.Errorf("role %d: name is required", i)
+		}
+		if names[role.Name] {
+			return fmt.Errorf("role %s: defined more than once", role.Name)
+		}
+		names[role.Name] = true
+		if len(role.Connections) == 0 && len(role.Tags) == 0 {
+			return fmt.Errorf("role %s: connections or tags are required", role.Name)
+		}
+		if len(role.Tools) == 0 {
+			return fmt.Errorf("role %s: tools are required", role.Name)
+		}
+		for _, pattern := range slices.Concat(role.Principals, role.Connections, role.Tools) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("role %s: invalid pattern %q", role.Name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// roleAccess is the access of a principal granted by its roles, allowing
+// the tools and connections any of them lists.
+type roleAccess struct {
+	pool  *ConnectionPool
+	roles []RoleConfig
+}
+
+// access returns the access the roles grant the principal.
+func (s *Server) access(p *principal, roles []RoleConfig) *roleAccess {
+	access := &roleAccess{pool: s.pool}
+	for _, role := range roles {
+		if slices.Contains(p.Scopes, roleScopePrefix+role.Name) || matchAny(role.Principals, p.Name) {
+			access.roles = append(access.roles, role)
+		}
+	}
+	return access
+}
+
+// AllowsTool satisfies the policy.Access interface.
+func (a *roleAccess) AllowsTool(name, class string) bool {
+	for _, role := range a.roles {
+		if slices.Contains(role.Tools, class) || matchAny(role.Tools, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsConnection satisfies the policy.Access interface. Aliases are
+// allowed as the connection they refer to.
+func (a *roleAccess) AllowsConnection(id string) bool {
+	if len(a.roles) == 0 {
+		return false
+	}
+	resolved := a.pool.Resolve(id)
+	tags := a.pool.Tags(resolved)
+	for _, role := range a.roles {
+		if matchAny(role.Connections, id) || matchAny(role.Connections, resolved) {
+			return true
+		}
+		if len(role.Tags) != 0 && matchTags(role.Tags, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether the name matches any of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTags reports whether the tags have all of the selector's tags.
+func matchTags(selector, tags map[string]string) bool {
+	for k, v := range selector {
+		if tag, ok := tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestRoles(t *testing.T) {
+	roles := []RoleConfig{
+		{Name: "analyst", Principals: []string{"analyst-*"}, Tags: map[string]string{"env": "prod"}, Tools: []string{policy.ToolQuery}},
+		{Name: "dba", Connections: []string{"*"}, Tools: []string{policy.ToolQuery, policy.ToolStatement, "create_connection"}},
+	}
+	if err := validateRoles(roles); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for _, invalid := range [][]RoleConfig{
+		{{Connections: []string{"*"}, Tools: []string{policy.ToolQuery}}},
+		{{Name: "r", Tools: []string{policy.ToolQuery}}},
+		{{Name: "r", Connections: []string{"*"}}},
+		{{Name: "r", Connections: []string{"["}, Tools: []string{policy.ToolQuery}}},
+		{roles[0], roles[0]},
+	} {
+		if err := validateRoles(invalid); err == nil {
+			t.Errorf("expected an error validating %v", invalid)
+		}
+	}
+
+	u, _ := dburl.Parse("postgres://localhost/db")
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	for _, id := range []string{"prod", "dev"} {
+		cp.connections[id] = &Connection{ID: id, URL: u, DB: sql.OpenDB(multiConnector{})}
+		if err := cp.SetTags(id, map[string]string{"env": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cp.AddAlias("prod", "primary"); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{pool: cp}
+
+	tests := []struct {
+		principal  *principal
+		tool       string
+		class      string
+		connection string
+		exp        bool
+	}{
+		// roles are granted by principal patterns, selecting connections by tags
+		{&principal{Name: "analyst-1"}, "execute_query", policy.ToolQuery, "prod", true},
+		{&principal{Name: "analyst-1"}, "execute_query", policy.ToolQuery, "primary", true},
+		{&principal{Name: "analyst-1"}, "execute_query", policy.ToolQuery, "dev", false},
+		{&principal{Name: "analyst-1"}, "execute_statement", policy.ToolStatement, "prod", false},
+		// or by scopes, allowing tools by class or name
+		{&principal{Name: "bob", Scopes: []string{"role:dba"}}, "execute_statement", policy.ToolStatement, "dev", true},
+		{&principal{Name: "bob", Scopes: []string{"role:dba"}}, "create_connection", policy.ToolAdmin, "dev", true},
+		{&principal{Name: "bob", Scopes: []string{"role:dba"}}, "close_connection", policy.ToolAdmin, "dev", false},
+		// principals without roles are denied everything
+		{&principal{Name: "eve"}, "execute_query", policy.ToolQuery, "dev", false},
+	}
+	for _, test := range tests {
+		ctx := policy.WithAccess(context.Background(), s.access(test.principal, roles))
+		if allowed := policy.AllowsTool(ctx, test.tool, test.class) && policy.AllowsConnection(ctx, test.connection); allowed != test.exp {
+			t.Errorf("expected %s calling %s on %s to be allowed: %t, got: %t", test.principal.Name, test.tool, test.connection, test.exp, allowed)
+		}
+	}
+}
+
+func TestRoleEndpoints(t *testing.T) {
+	s, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	u, _ := dburl.Parse("postgres://localhost/db")
+	s.pool.connections["prod"] = &Connection{ID: "prod", URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	roles := []RoleConfig{
+		{Name: "analyst", Connections: []string{"*"}, Tools: []string{policy.ToolQuery}},
+		{Name: "writer", Connections: []string{"*"}, Tools: []string{policy.ToolStatement}},
+		{Name: "reporter", Connections: []string{"*"}, Tools: []string{"export_xlsx"}},
+	}
+
+	// the streaming and export endpoints are granted as the query and export
+	// tools
+	tests := []struct {
+		role   string
+		path   string
+		format string
+		exp    int
+	}{
+		{"analyst", "/query/stream", "", http.StatusOK},
+		{"analyst", "/export", "csv", http.StatusOK},
+		{"analyst", "/export", "xlsx", http.StatusOK},
+		{"writer", "/query/stream", "", http.StatusForbidden},
+		{"writer", "/export", "csv", http.StatusForbidden},
+		{"writer", "/export", "xlsx", http.StatusForbidden},
+		{"reporter", "/query/stream", "", http.StatusForbidden},
+		{"reporter", "/export", "csv", http.StatusForbidden},
+		{"reporter", "/export", "xlsx", http.StatusOK},
+	}
+	for _, test := range tests {
+		access := s.access(&principal{Name: test.role, Scopes: []string{"role:" + test.role}}, roles)
+		body := fmt.Sprintf(`{"query": "SELECT v", "format": %q}`, test.format)
+		r := httptest.NewRequest(http.MethodPost, "/v1/connections/prod"+test.path, strings.NewReader(body))
+		r = r.WithContext(policy.WithAccess(r.Context(), access))
+		r.SetPathValue("id", "prod")
+		w := httptest.NewRecorder()
+		if test.path == "/export" {
+			s.handleExport(w, r)
+		} else {
+			s.handleQueryStream(w, r)
+		}
+		if w.Code != test.exp {
+			t.Errorf("%s %s %s: expected %d, got: %d %s", test.role, test.path, test.format, test.exp, w.Code, w.Body.String())
+		}
+	}
+}
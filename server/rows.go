@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/policy"
+)
+
+// RowIterator iterates over the rows of a query result without buffering
+// them, for streaming large results.
+//
+// Columns, ColumnTypes and JSONTypes describe the current result set. The
+// iterator must be closed to release the rows.
+type RowIterator struct {
+	Columns     []string
+	ColumnTypes []string
+	JSONTypes   []string
+	Provenance  *Provenance
+
+	conn    *Connection
+	convert *driverConverter
+	query   string
+	audit   func(rows int64, err error)
+	rows    *sql.Rows
+	buf     *scanBuffer
+	release func()
+	addRows func(int)
+	count   int
+	values  []interface{}
+	err     error
+
+	// the rows are scanned as the scan types, and masked by the masks of
+	// the current result set's columns
+	mask      *policy.Masker
+	masks     *policy.ColumnMasks
+	scanTypes []string
+}
+
+// QueryRows executes a SQL query on the connection, returning an iterator
+// over the resulting rows.
+//
+// The query counts against the connection's host concurrency limit until
+// the iterator is closed.
+func (conn *Connection) QueryRows(ctx context.Context, query string, args ...interface{}) (_ *RowIterator, err error) {
+	if inst := conn.instance(query); inst != conn {
+		return inst.QueryRows(ctx, query, args...)
+	}
+	// the stream is audited once closed, with the rows read
+	started, auditQuery, auditArgs := time.Now(), query, args
+	audited := func(rows int64, err error) {
+		conn.recordQuery(ctx, audit.Stream, auditQuery, auditArgs, started, rows, err)
+	}
+	defer func() {
+		if err != nil {
+			audited(0, err)
+		}
+	}()
+	defer conn.recoverPanic(query, &err)
+
+	conn.touch()
+
+	query, hookRewrite, err := conn.preQuery(ctx, query, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.policy.Check(ctx, conn.ID, query); err != nil {
+		return nil, err
+	}
+	query, restrictRewrite, err := conn.restrict(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.dial(ctx); err != nil {
+		return nil, err
+	}
+
+	query, args, rewrites, err := bindArgs(conn.URL, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if hookRewrite != "" {
+		rewrites = append(rewrites, hookRewrite)
+	}
+	if restrictRewrite != "" {
+		rewrites = append(rewrites, restrictRewrite)
+	}
+
+	release, err := conn.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	// the connection is in use until the iterator is closed
+	release = conn.use(release)
+
+	if err := conn.faults.Inject(ctx, conn.ID); err != nil {
+		release()
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	if err := conn.cost.Check(ctx, conn, query, args); err != nil {
+		release()
+		return nil, err
+	}
+
+	dq, err := conn.withDeadline(ctx, query)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// the rows are read on the deadline's handle, released with the
+	// connection once the iterator is closed
+	releaseConn := release
+	release = func() {
+		dq.release()
+		releaseConn()
+	}
+	if dq.rewrite != "" {
+		rewrites = append(rewrites, dq.rewrite)
+	}
+
+	executedAt := time.Now()
+	rows, err := dq.stmts(conn).QueryContext(ctx, dq.db, dq.query, args...)
+	conn.health.observe(executedAt, err)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	conn.executed(query)
+
+	it := &RowIterator{
+		Provenance: conn.provenance(query, executedAt, rewrites),
+		conn:       conn,
+		convert:    newDriverConverter(conn.URL),
+		query:      query,
+		mask:       conn.masker(ctx),
+		rows:       rows,
+		buf:        getScanBuffer(),
+		release:    release,
+		addRows:    func(n int) { conn.quota.AddRows(ctx, n) },
+		audit:      audited,
+	}
+	if err := it.readColumns(); err != nil {
+		// audited as failed below, rather than once closed
+		it.audit = func(int64, error) {}
+		it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// readColumns reads the columns of the current result set.
+func (it *RowIterator) readColumns() error {
+	columns, err := it.rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to get columns: %w", err)
+	}
+	columnTypes, err := it.rows.ColumnTypes()
+	if err != nil {
+		return fmt.Errorf("failed to get column types: %w", err)
+	}
+	// the PII found in the previous result set is only logged
+	it.masks.Findings()
+	it.scanTypes, it.masks = jsonTypes(columnTypes), it.mask.Columns(columns)
+	it.Columns, it.ColumnTypes, it.JSONTypes = columns, make([]string, len(columnTypes)), it.masks.JSONTypes(it.scanTypes)
+	for i, ct := range columnTypes {
+		it.ColumnTypes[i] = ct.DatabaseTypeName()
+	}
+	return nil
+}
+
+// Next advances to the next row of the current result set, returning false
+// when there are no more rows or an error occurred.
+func (it *RowIterator) Next() (ok bool) {
+	if it.err != nil {
+		return false
+	}
+	defer func() {
+		if it.err != nil {
+			ok = false
+		}
+	}()
+	defer it.conn.recoverPanic(it.query, &it.err)
+
+	if !it.rows.Next() {
+		if err := it.rows.Err(); err != nil {
+			it.err = fmt.Errorf("row iteration error: %w", err)
+		}
+		return false
+	}
+	if it.values, it.err = it.buf.scan(it.rows, make([]interface{}, len(it.scanTypes)), it.scanTypes, it.convert); it.err != nil {
+		return false
+	}
+	it.masks.Row(it.values)
+	it.count++
+	return true
+}
+
+// Row returns the values of the current row.
+func (it *RowIterator) Row() []interface{} {
+	return it.values
+}
+
+// Scan copies the values of the current row into dest, which must be
+// pointers to empty interfaces.
+func (it *RowIterator) Scan(dest ...interface{}) error {
+	if len(dest) != len(it.values) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(it.values), len(dest))
+	}
+	for i, v := range it.values {
+		d, ok := dest[i].(*interface{})
+		if !ok {
+			return fmt.Errorf("unsupported Scan destination %T", dest[i])
+		}
+		*d = v
+	}
+	return nil
+}
+
+// NextResultSet advances to the next result set, skipping result sets
+// without columns, and returning false when there are no more.
+func (it *RowIterator) NextResultSet() bool {
+	for it.err == nil && it.rows.NextResultSet() {
+		if it.err = it.readColumns(); it.err != nil {
+			return false
+		}
+		if len(it.Columns) != 0 {
+			return true
+		}
+	}
+	if it.err == nil {
+		if err := it.rows.Err(); err != nil {
+			it.err = fmt.Errorf("row iteration error: %w", err)
+		}
+	}
+	return false
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *RowIterator) Err() error {
+	return it.err
+}
+
+// Close closes the rows, releasing the connection's concurrency slot.
+func (it *RowIterator) Close() error {
+	err := it.rows.Close()
+	it.masks.Findings()
+	if it.buf != nil {
+		it.buf.release()
+		it.buf = nil
+	}
+	if it.release != nil {
+		it.addRows(it.count)
+		it.release()
+		it.release = nil
+		it.audit(int64(it.count), it.err)
+	}
+	return err
+}
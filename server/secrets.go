@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExecTimeout bounds the commands run to resolve exec references when
+// no timeout is configured.
+const defaultExecTimeout = 10 * time.Second
+
+// secretKinds are the kinds of secret references.
+var secretKinds = []string{"env", "file", "exec", "vault"}
+
+// secretRef matches a secret reference in a DSN, such as ${env:PG_PASSWORD}.
+var secretRef = regexp.MustCompile(`\$\{([a-z]+):([^}]*)\}`)
+
+// SecretBackend resolves the secret references of a kind. Resolving a
+// secret may acquire a lease on it, such as on dynamic database credentials,
+// which is kept until release is called. Release is nil when there is no
+// lease.
+type SecretBackend interface {
+	Resolve(ctx context.Context, ref string) (value string, release func(), err error)
+}
+
+// secretFunc is a secret backend resolving secrets without leases.
+type secretFunc func(context.Context, string) (string, error)
+
+// Resolve satisfies the SecretBackend interface.
+func (f secretFunc) Resolve(ctx context.Context, ref string) (string, func(), error) {
+	value, err := f(ctx, ref)
+	return value, nil, err
+}
+
+// Secrets resolves the secret references in DSNs, with the backend of each
+// kind of reference.
+type Secrets struct {
+	backends map[string]SecretBackend
+}
+
+// newSecrets creates the secrets resolver, with the env, file and exec
+// backends.
+func newSecrets(config SecretsConfig) *Secrets {
+	timeout := config.ExecTimeout
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	return &Secrets{
+		backends: map[string]SecretBackend{
+			"env":  secretFunc(resolveEnv),
+			"file": secretFunc(resolveFile),
+			"exec": secretFunc(func(ctx context.Context, command string) (string, error) {
+				return resolveExec(ctx, command, timeout)
+			}),
+		},
+	}
+}
+
+// resolve replaces the secret references in the DSN with their values,
+// returning a func releasing their leases. A reference making up the whole
+// DSN is replaced as is, while the values of references within a DSN are
+// percent-encoded, so that a password may contain any character. References
+// in untrusted DSNs, given by clients, are only resolved for the kinds in
+// clientRefs.
+func (s *Secrets) resolve(ctx context.Context, dsn string, clientRefs []string, trusted bool) (string, func(), error) {
+	matches := secretRef.FindAllStringSubmatchIndex(dsn, -1)
+	if len(matches) == 0 {
+		return dsn, func() {}, nil
+	}
+	var releases []func()
+	release := sync.OnceFunc(func() {
+		for _, f := range releases {
+			f()
+		}
+	})
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		kind, ref := dsn[m[2]:m[3]], dsn[m[4]:m[5]]
+		backend, ok := s.backends[kind]
+		switch {
+		case !ok && slices.Contains(secretKinds, kind):
+			release()
+			return "", nil, fmt.Errorf("%s secret references are not configured", kind)
+		case !ok:
+			release()
+			return "", nil, fmt.Errorf("unknown secret reference kind %q", kind)
+		case !trusted && !slices.Contains(clientRefs, kind):
+			release()
+			return "", nil, fmt.Errorf("%s secret references are not allowed in client DSNs", kind)
+		}
+		value, f, err := backend.Resolve(ctx, ref)
+		if err != nil {
+			release()
+			return "", nil, fmt.Errorf("failed to resolve secret reference ${%s:%s}: %w", kind, ref, err)
+		}
+		if f != nil {
+			releases = append(releases, f)
+		}
+		if m[0] == 0 && m[1] == len(dsn) {
+			return value, release, nil
+		}
+		b.WriteString(dsn[last:m[0]])
+		b.WriteString(escapeSecret(value))
+		last = m[1]
+	}
+	b.WriteString(dsn[last:])
+	return b.String(), release, nil
+}
+
+// resolveEnv resolves a reference to an environment variable.
+func resolveEnv(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("environment variable is not set")
+	}
+	return value, nil
+}
+
+// resolveFile resolves a reference to a file, such as a mounted secret,
+// without its trailing newline.
+func resolveFile(_ context.Context, path string) (string, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// resolveExec resolves a reference to the output of a command, such as a
+// password manager's CLI, without its trailing newline. The command is run
+// without a shell, its arguments split on spaces.
+func resolveExec(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", errors.New("command is empty")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// escapeSecret percent-encodes all but the unreserved characters of the
+// value, so it can be placed in any part of a URL.
+func escapeSecret(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// validateSecrets validates the kinds of secret references allowed in
+// client DSNs.
+func validateSecrets(config SecretsConfig) error {
+	for _, kind := range config.ClientRefs {
+		if !slices.Contains(secretKinds, kind) {
+			return fmt.Errorf("invalid secret reference kind %q: must be one of %s", kind, strings.Join(secretKinds, ", "))
+		}
+	}
+	return nil
+}
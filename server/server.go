@@ -4,117 +4,481 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/logstore"
 	"github.com/xo/usql/server/mcp"
+	"github.com/xo/usql/server/nulls"
+	"github.com/xo/usql/server/timefmt"
 )
 
 // Server represents the usqlr HTTP server.
 type Server struct {
 	pool       *ConnectionPool
-	config     *Config
+	conf       atomic.Pointer[Config]
 	httpServer *http.Server
 	mcpHandler *mcp.Handler
+
+	// servers serve the listeners other than the HTTP listener, by name:
+	// those configured, the runtime diagnostics on the admin listener, when
+	// its address is set, and the ACME HTTP challenges, when their address is
+	servers map[string]*http.Server
+
+	// store persists dynamically created connections, when enabled
+	persisted *connectionStore
+
+	deprecations *endpointDeprecations
+
+	// metrics counts the MCP requests, when metrics are enabled
+	metrics *requestMetrics
+
+	// keys, jwt and oauth authenticate requests, when API keys, JWTs and
+	// OAuth are enabled
+	keys  *KeyStore
+	jwt   *jwtVerifier
+	oauth *oauthProvider
+
+	// certs serves the listener over TLS, when configured
+	certs *certificates
+
+	// ips restricts the client IPs requests are served to, when configured
+	ips atomic.Pointer[ipFilter]
+
+	// build is the build info of the server.
+	build BuildInfo
+
+	// times is the default format of time values in results.
+	times *timefmt.Format
+
+	// nulls is the default representation of NULL values in results.
+	nulls *nulls.Format
+
+	// draining is set once the server shuts down, refusing new work, and
+	// inflight is the number of requests being served
+	draining atomic.Bool
+	inflight atomic.Int64
+
+	// upgrading is set once the server starts a process to take over its
+	// listeners, and handedOver once the process took over
+	upgrading  atomic.Bool
+	handedOver atomic.Bool
+
+	mu        sync.Mutex
+	listeners []namedListener
+	queries   []SavedQuery
+	stores    map[string]*logstore.Store
 }
 
 // New creates a new server instance.
 func New(config *Config) (*Server, error) {
-	pool := NewConnectionPool(config)
+	engine, err := NewPolicyEngine(config.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policies: %w", err)
+	}
+
+	deprecations, tools, err := newDeprecations(config.Deprecations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deprecations: %w", err)
+	}
+
+	hookEngine, err := NewHookEngine(config.Hooks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load hooks: %w", err)
+	}
+
+	times, err := timefmt.Parse(config.Server.TimeFormat, config.Server.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	nullFormat, err := nulls.Parse(config.Server.Nulls, config.Server.NullSentinel)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	ips, err := newIPFilter(config.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs *certificates
+	if config.Server.TLS.enabled() {
+		if certs, err = newCertificates(config.Server.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	pool := NewConnectionPool(config, engine, hookEngine)
+	if config.Secrets.Vault.Address != "" {
+		vault, err := newVaultBackend(config.Secrets.Vault)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.secrets.backends["vault"] = vault
+	}
+	if pool.audit, err = audit.New(config.Audit); err != nil {
+		pool.Close()
+		return nil, err
+	}
 	adapter := NewPoolAdapter(pool)
-	
-	mcpHandler, err := mcp.New(adapter)
+
+	store, err := newConnectionStore(config.Persistence, pool)
 	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	var keys *KeyStore
+	if config.Auth.EnableAPIKey {
+		if config.Auth.KeysFile == "" {
+			pool.Close()
+			return nil, fmt.Errorf("auth.keys_file is required to enable API keys")
+		}
+		if keys, err = OpenKeyStore(config.Auth.KeysFile); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to load API keys: %w", err)
+		}
+	}
+
+	var verifier *jwtVerifier
+	if config.Auth.EnableJWT {
+		if verifier, err = newJWTVerifier(config.Auth.JWT); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to configure JWT authentication: %w", err)
+		}
+	}
+
+	var oauth *oauthProvider
+	if config.Auth.EnableOAuth {
+		if oauth, err = newOAuthProvider(config.Auth.OAuth); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to configure OAuth: %w", err)
+		}
+	}
+
+	mcpHandler, err := mcp.New(adapter, convertSavedQueries(config.Queries), tools, times, nullFormat)
+	if err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("failed to create MCP handler: %w", err)
 	}
 
-	return &Server{
-		pool:       pool,
-		config:     config,
-		mcpHandler: mcpHandler,
-	}, nil
+	s := &Server{
+		pool:         pool,
+		mcpHandler:   mcpHandler,
+		persisted:    store,
+		deprecations: deprecations,
+		keys:         keys,
+		jwt:          verifier,
+		oauth:        oauth,
+		certs:        certs,
+		times:        times,
+		nulls:        nullFormat,
+		queries:      config.Queries,
+		stores:       make(map[string]*logstore.Store),
+	}
+	s.conf.Store(config)
+	s.ips.Store(ips)
+	pool.OnFailover(s.recordFailover)
+	pool.OnSlowQuery(s.recordSlowQuery)
+	s.SetBuildInfo(ReadBuildInfo())
+	if config.Metrics.Enabled {
+		s.metrics = newRequestMetrics(config.Metrics.Path)
+		mcpHandler.SetObserver(s.metrics.observe)
+	}
+	if err := s.openHistory(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// config returns the server's current configuration, which is replaced
+// rather than modified when reloaded.
+func (s *Server) config() *Config {
+	return s.conf.Load()
 }
 
 // Listen starts the HTTP server on the specified address.
 func (s *Server) Listen(ctx context.Context, addr string) error {
-	mux := http.NewServeMux()
+	main, others := s.listenerConfigs(addr)
 
-	// Health check endpoint
-	mux.HandleFunc("/health", s.handleHealth)
-
-	// MCP endpoint (JSON-RPC 2.0)
-	if s.config.Server.EnableMCP {
-		mux.HandleFunc("/mcp", s.handleMCP)
+	// Listen, on the listeners inherited when taking over from a running
+	// server
+	names := []string{listenerHTTP, listenerAdmin, listenerACME}
+	for _, l := range others {
+		if !slices.Contains(names, l.Name) {
+			names = append(names, l.Name)
+		}
 	}
-
-	// CORS middleware
-	var handler http.Handler = mux
-	if s.config.Server.EnableCORS {
-		handler = s.corsMiddleware(handler)
+	h, err := inherit(names)
+	if err != nil {
+		return err
+	}
+	ln, err := s.listen(h, main.Name, main.Address)
+	if err != nil {
+		return err
+	}
+	if main.TLS {
+		ln = s.certs.listener(ln)
+	}
+	s.servers = make(map[string]*http.Server)
+	secure := make(map[string]bool)
+	for _, l := range others {
+		s.servers[l.Name] = &http.Server{
+			Addr:    l.Address,
+			Handler: s.middleware(s.routes(l), l),
+		}
+		secure[l.Name] = l.TLS
+	}
+	if s.certs != nil {
+		if handler, addr := s.certs.challengeHandler(); handler != nil {
+			s.servers[listenerACME] = &http.Server{Addr: addr, Handler: handler}
+		}
+	}
+	listeners := make(map[string]net.Listener, len(s.servers))
+	for name, srv := range s.servers {
+		l, err := s.listen(h, name, srv.Addr)
+		if err != nil {
+			ln.Close()
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("%s listener: %w", name, err)
+		}
+		if secure[name] {
+			l = s.certs.listener(l)
+		}
+		listeners[name] = l
+	}
+	if h != nil {
+		h.closeUnused()
+		if h.ready != nil {
+			s.takeOver(ctx, h)
+		}
+	}
+	if err := notify("READY=1"); err != nil {
+		serverLog.Error("failed to notify systemd of readiness", "error", err)
 	}
 
 	s.httpServer = &http.Server{
 		Addr:    addr,
-		Handler: handler,
+		Handler: s.middleware(s.routes(main), main),
 	}
 
-	// Start server in a goroutine
-	errChan := make(chan error, 1)
+	// Start server in a goroutine, the listeners closing once handed over
+	errChan := make(chan error, 1+len(s.servers))
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed && !s.handedOver.Load() {
 			errChan <- err
 		}
 	}()
+	for name, srv := range s.servers {
+		go func() {
+			if err := srv.Serve(listeners[name]); err != nil && err != http.ErrServerClosed && !s.handedOver.Load() {
+				errChan <- fmt.Errorf("%s listener: %w", name, err)
+			}
+		}()
+	}
 
 	// Wait for context cancellation or server error
 	select {
 	case <-ctx.Done():
+		for _, srv := range s.servers {
+			srv.Shutdown(context.Background())
+		}
 		return s.httpServer.Shutdown(context.Background())
 	case err := <-errChan:
+		for _, srv := range s.servers {
+			srv.Close()
+		}
+		s.httpServer.Close()
 		return err
 	}
 }
 
-// Shutdown gracefully shuts down the server.
+// middleware wraps the listener's handler with the server's middlewares:
+// deprecation notices, authentication, unless the listener requires none,
+// compression, CORS and IP filtering, unless on a Unix socket.
+func (s *Server) middleware(mux *http.ServeMux, l ListenerConfig) http.Handler {
+	// Deprecation middleware
+	var handler http.Handler = mux
+	if len(s.deprecations.notices) != 0 {
+		handler = s.deprecationMiddleware(handler)
+	}
+
+	// Authentication middleware
+	if (s.keys != nil || s.jwt != nil || s.oauth != nil) && l.Auth != AuthNone {
+		handler = s.authMiddleware(handler)
+	}
+
+	// Compression middleware
+	if s.config().Server.Compression.Enabled {
+		handler = s.compressMiddleware(handler)
+	}
+
+	// CORS middleware
+	if s.config().Server.EnableCORS {
+		handler = s.corsMiddleware(handler)
+	}
+
+	// Drain middleware, refusing new work once shutting down
+	handler = s.drainMiddleware(handler)
+
+	// IP filter middleware, applying the IP filter of reloaded configs, to
+	// clients with an IP
+	if l.unix() {
+		return handler
+	}
+	return s.ipFilterMiddleware(handler)
+}
+
+// CreateConnection creates a connection to the database at the DSN, such as
+// one the server is started with.
+func (s *Server) CreateConnection(ctx context.Context, id, dsn string) error {
+	_, err := s.pool.CreateConnection(ctx, id, dsn, nil)
+	return err
+}
+
+// Shutdown gracefully shuts down the server. New work is refused, and the
+// requests, queries and jobs in progress, and the transactions held open by
+// sessions, are waited for up to the drain timeout, or until ctx is done.
+// The HTTP servers are then shut down, the transactions left are rolled
+// back, and the pool is closed.
 func (s *Server) Shutdown(ctx context.Context) error {
-	// Close connection pool
-	if err := s.pool.Close(); err != nil {
-		log.Printf("Error closing connection pool: %v", err)
+	// Notify systemd, unless handed over to a new process taking over as the
+	// service's main process
+	if !s.handedOver.Load() {
+		if err := notify("STOPPING=1"); err != nil {
+			serverLog.Error("failed to notify systemd of stopping", "error", err)
+		}
+	}
+
+	// Drain the work in progress
+	drainCtx, cancel := context.WithTimeout(ctx, s.config().Server.DrainTimeout)
+	start := time.Now()
+	work := s.drain(drainCtx)
+	cancel()
+	if work != (Work{}) {
+		serverLog.Warn("shutting down with work in progress", "drained_for", time.Since(start), "requests", work.Requests, "queries", work.Queries, "jobs", work.Jobs, "transactions", work.Transactions)
+	} else {
+		serverLog.Info("drained work in progress", "drained_for", time.Since(start))
 	}
 
-	// Shutdown HTTP server
+	// Shutdown HTTP servers
+	var err error
+	for name, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			serverLog.Error("failed to shut down listener", "listener", name, "error", err)
+		}
+	}
 	if s.httpServer != nil {
-		return s.httpServer.Shutdown(ctx)
+		// connections still active once ctx is done are closed
+		if err = s.httpServer.Shutdown(ctx); err != nil {
+			s.httpServer.Close()
+		}
 	}
 
-	return nil
+	// Stop persisting connections, before they're closed
+	if s.persisted != nil {
+		if err := s.persisted.close(); err != nil {
+			serverLog.Error("failed to persist connections", "error", err)
+		}
+	}
+
+	// Close connection pool, rolling back the transactions left
+	if err := s.pool.Close(); err != nil {
+		serverLog.Error("failed to close connection pool", "error", err)
+	}
+
+	// Close stores, moving their in-memory records to disk
+	s.mu.Lock()
+	for name, store := range s.stores {
+		if err := store.Close(); err != nil {
+			serverLog.Error("failed to close store", "store", name, "error", err)
+		}
+	}
+	s.mu.Unlock()
+
+	return err
+}
+
+// healthReport is the response to health check requests, with the load of
+// the pool and the status of its connections in detail mode.
+type healthReport struct {
+	Status      string             `json:"status"`
+	Connections int                `json:"connections"`
+	Redis       *RedisHealth       `json:"redis,omitempty"`
+	Timestamp   string             `json:"timestamp"`
+	Pool        *PoolHealth        `json:"pool,omitempty"`
+	Details     []ConnectionStatus `json:"details,omitempty"`
+}
+
+// healthDetail reports whether the health check request asks for the detail
+// mode.
+func healthDetail(r *http.Request) bool {
+	detail, _ := strconv.ParseBool(r.URL.Query().Get("detail"))
+	return detail
 }
 
-// handleHealth handles health check requests.
+// handleHealth handles health check requests. In detail mode, the
+// connections are pinged, and the server is reported degraded, with a 503
+// status, when any fails. Once shutting down, the server is reported
+// draining, with a 503 status, so that load balancers stop routing to it.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	health := struct {
-		Status      string `json:"status"`
-		Connections int    `json:"connections"`
-		Timestamp   string `json:"timestamp"`
-	}{
+	health := healthReport{
 		Status:      "healthy",
 		Connections: s.pool.Size(),
+		Redis:       s.pool.redis.health(),
 		Timestamp:   time.Now().UTC().Format(time.RFC3339),
 	}
+	status := http.StatusOK
+	// queries are not limited across the cluster, or fail, while Redis is
+	// unreachable
+	if health.Redis != nil && health.Redis.Status != "ok" {
+		health.Status = "degraded"
+	}
+	if s.draining.Load() {
+		health.Status, status = "draining", http.StatusServiceUnavailable
+	}
+	if healthDetail(r) {
+		pool, details := s.pool.HealthDetails(r.Context())
+		health.Pool, health.Details, health.Connections = &pool, details, pool.Connections
+		for _, d := range details {
+			if d.Status == StatusUnhealthy && status == http.StatusOK {
+				health.Status, status = "degraded", http.StatusServiceUnavailable
+			}
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(health)
 }
 
 // handleMCP handles MCP (JSON-RPC 2.0) requests.
 func (s *Server) handleMCP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleEndSession(w, r)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -124,13 +488,13 @@ func (s *Server) handleMCP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Create request context with timeout
-	ctx, cancel := context.WithTimeout(r.Context(), s.config.Server.RequestTimeout)
+	ctx, cancel := context.WithTimeout(r.Context(), s.config().Server.RequestTimeout)
 	defer cancel()
 
 	// Handle the MCP request
 	if err := s.mcpHandler.ServeHTTP(ctx, w, r); err != nil {
-		log.Printf("MCP handler error: %v", err)
-		
+		mcpLog.Error("MCP handler error", "error", err)
+
 		// Send JSON-RPC error response
 		errorResp := map[string]interface{}{
 			"jsonrpc": "2.0",
@@ -150,7 +514,8 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Mcp-Session-Id")
+		w.Header().Set("Access-Control-Expose-Headers", "WWW-Authenticate, Mcp-Session-Id")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		// Handle preflight requests
@@ -173,10 +538,10 @@ type JSONRPCRequest struct {
 
 // JSONRPCResponse represents a JSON-RPC 2.0 response.
 type JSONRPCResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Result  interface{} `json:"result,omitempty"`
+	JSONRPC string        `json:"jsonrpc"`
+	Result  interface{}   `json:"result,omitempty"`
 	Error   *JSONRPCError `json:"error,omitempty"`
-	ID      interface{} `json:"id,omitempty"`
+	ID      interface{}   `json:"id,omitempty"`
 }
 
 // JSONRPCError represents a JSON-RPC 2.0 error.
@@ -191,7 +556,7 @@ func validateJSONRPCRequest(req *JSONRPCRequest) error {
 	if req.JSONRPC != "2.0" {
 		return fmt.Errorf("invalid JSON-RPC version: %s", req.JSONRPC)
 	}
-	
+
 	if req.Method == "" {
 		return fmt.Errorf("missing method")
 	}
@@ -201,4 +566,4 @@ func validateJSONRPCRequest(req *JSONRPCRequest) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
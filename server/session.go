@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xo/usql/server/mcp"
+	"github.com/xo/usql/server/policy"
+)
+
+// Session is the state a client holds on the server across requests: the
+// default connection of its queries, its variables, the transactions it
+// holds open, and the cursors and jobs it opened and submitted. Sessions
+// belong to the principal that created them.
+type Session struct {
+	ID        string
+	Principal string
+	Tenant    string
+	Created   time.Time
+
+	lastUsed atomic.Int64
+
+	mu         sync.Mutex
+	connection string
+	variables  map[string]interface{}
+	cursors    map[string]bool
+	jobs       map[string]bool
+
+	// transactions are the session's open transactions, by connection ID
+	transactions map[string]*sessionTx
+}
+
+// SessionInfo describes a session.
+type SessionInfo struct {
+	ID         string                 `json:"id"`
+	Principal  string                 `json:"principal,omitempty"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Created    time.Time              `json:"created"`
+	LastUsed   time.Time              `json:"last_used"`
+	ExpiresAt  time.Time              `json:"expires_at"`
+	Connection string                 `json:"connection,omitempty"`
+	Variables  map[string]interface{} `json:"variables,omitempty"`
+	Cursors    []string               `json:"cursors,omitempty"`
+	Jobs       []string               `json:"jobs,omitempty"`
+
+	Transactions []TransactionInfo `json:"transactions,omitempty"`
+}
+
+// Connection returns the session's default connection, if any.
+func (s *Session) Connection() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connection
+}
+
+// SetConnection sets the session's default connection, or clears it when
+// id is empty.
+func (s *Session) SetConnection(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connection = id
+}
+
+// Variables returns a copy of the session's variables.
+func (s *Session) Variables() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	variables := make(map[string]interface{}, len(s.variables))
+	for name, value := range s.variables {
+		variables[name] = value
+	}
+	return variables
+}
+
+// SetVariable sets a variable of the session, or unsets it when value is
+// nil.
+func (s *Session) SetVariable(name string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == nil {
+		delete(s.variables, name)
+		return
+	}
+	s.variables[name] = value
+}
+
+// AddCursor records a cursor opened in the session, closed with it.
+func (s *Session) AddCursor(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursors[id] = true
+}
+
+// RemoveCursor forgets a cursor closed by the client.
+func (s *Session) RemoveCursor(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cursors, id)
+}
+
+// addJob records a job submitted in the session, discarded with it.
+func (s *Session) addJob(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id] = true
+}
+
+// LastUsed returns when the session was last used.
+func (s *Session) LastUsed() time.Time {
+	return time.Unix(0, s.lastUsed.Load())
+}
+
+// touch marks the session as used at now.
+func (s *Session) touch(now time.Time) {
+	s.lastUsed.Store(now.UnixNano())
+}
+
+// SessionManager tracks client sessions, closed with their transactions,
+// cursors and jobs once idle beyond the idle timeout by the pool's reaper.
+// Transactions idle beyond the transaction timeout are rolled back on their
+// own.
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	ttl      time.Duration
+	txTTL    time.Duration
+	max      int
+	cursors  *CursorManager
+	jobs     *JobManager
+}
+
+// NewSessionManager creates a new session manager, closing sessions that
+// have not been used within the config's session idle timeout, and the
+// least recently used session when creating more than its max sessions (0
+// for no limit).
+func NewSessionManager(config ServerConfig, cursors *CursorManager, jobs *JobManager) *SessionManager {
+	ttl, txTTL := config.SessionIdleTimeout, config.TransactionTimeout
+	if ttl <= 0 {
+		ttl = 30 * time.Minute
+	}
+	if txTTL <= 0 {
+		txTTL = 5 * time.Minute
+	}
+	return &SessionManager{
+		sessions: make(map[string]*Session),
+		ttl:      ttl,
+		txTTL:    txTTL,
+		max:      config.MaxSessions,
+		cursors:  cursors,
+		jobs:     jobs,
+	}
+}
+
+// expire closes the sessions idle beyond the idle timeout at now, and rolls
+// back the transactions of the others idle beyond the transaction timeout,
+// returning what was collected.
+func (sm *SessionManager) expire(now time.Time) Collected {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	var c Collected
+	for id, session := range sm.sessions {
+		if now.Sub(session.LastUsed()) >= sm.ttl {
+			c.add(sm.remove(id, session))
+			c.Sessions++
+			continue
+		}
+		c.Transactions += int64(session.rollbackIdle(now.Add(-sm.txTTL)))
+	}
+	return c
+}
+
+// Create creates a new session of ctx's principal, closing the least
+// recently used session when the limit of sessions is reached.
+func (sm *SessionManager) Create(ctx context.Context) *Session {
+	now := time.Now()
+	session := &Session{
+		ID:        newID(),
+		Principal: policy.PrincipalFrom(ctx),
+		Tenant:    policy.TenantFrom(ctx),
+		Created:   now,
+		variables: make(map[string]interface{}),
+		cursors:   make(map[string]bool),
+		jobs:      make(map[string]bool),
+
+		transactions: make(map[string]*sessionTx),
+	}
+	session.touch(now)
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.max > 0 && len(sm.sessions) >= sm.max {
+		var lru *Session
+		for _, s := range sm.sessions {
+			if lru == nil || s.LastUsed().Before(lru.LastUsed()) {
+				lru = s
+			}
+		}
+		sm.remove(lru.ID, lru)
+	}
+	sm.sessions[session.ID] = session
+	return session
+}
+
+// Get returns the session with the ID, marking it used, when it is one of
+// ctx's principal.
+func (sm *SessionManager) Get(ctx context.Context, id string) (*Session, bool) {
+	sm.mu.Lock()
+	session, ok := sm.sessions[id]
+	sm.mu.Unlock()
+	if !ok || session.Principal != policy.PrincipalFrom(ctx) || session.Tenant != policy.TenantFrom(ctx) {
+		return nil, false
+	}
+	session.touch(time.Now())
+	return session, true
+}
+
+// Close closes the session with the ID, rolling back its transactions,
+// closing its cursors and discarding its jobs.
+func (sm *SessionManager) Close(id string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	session, ok := sm.sessions[id]
+	if !ok {
+		return fmt.Errorf("session with ID %s not found", id)
+	}
+	sm.remove(id, session)
+	return nil
+}
+
+// remove removes the session, rolling back its transactions, closing its
+// cursors and discarding its jobs, returning what was collected. The lock
+// must be held.
+func (sm *SessionManager) remove(id string, session *Session) Collected {
+	delete(sm.sessions, id)
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	var c Collected
+	for connectionID, stx := range session.transactions {
+		delete(session.transactions, connectionID)
+		stx.end(false)
+		c.Transactions++
+	}
+	for cursorID := range session.cursors {
+		// cursors may have expired, or been closed with their connection
+		if sm.cursors.Close(cursorID) == nil {
+			c.Cursors++
+		}
+	}
+	for jobID := range session.jobs {
+		// jobs may have expired, their results collected
+		if sm.jobs.discard(jobID) {
+			c.Jobs++
+		}
+	}
+	return c
+}
+
+// List returns the open sessions, ordered by creation.
+func (sm *SessionManager) List() []SessionInfo {
+	sm.mu.Lock()
+	sessions := make([]*Session, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		sessions = append(sessions, session)
+	}
+	sm.mu.Unlock()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		lastUsed := session.LastUsed()
+		info := SessionInfo{
+			ID:         session.ID,
+			Principal:  session.Principal,
+			Tenant:     session.Tenant,
+			Created:    session.Created,
+			LastUsed:   lastUsed,
+			ExpiresAt:  lastUsed.Add(sm.ttl),
+			Connection: session.Connection(),
+			Variables:  session.Variables(),
+
+			Transactions: session.Transactions(sm.txTTL),
+		}
+		session.mu.Lock()
+		for id := range session.cursors {
+			if sm.cursors.exists(id) {
+				info.Cursors = append(info.Cursors, id)
+			}
+		}
+		for id := range session.jobs {
+			if sm.jobs.exists(id) {
+				info.Jobs = append(info.Jobs, id)
+			}
+		}
+		session.mu.Unlock()
+		sort.Strings(info.Cursors)
+		sort.Strings(info.Jobs)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+	return infos
+}
+
+// sessionState is the state of a session handed over to another process:
+// its identity, default connection and variables. Its transactions, cursors
+// and jobs are not.
+type sessionState struct {
+	ID         string                 `json:"id"`
+	Principal  string                 `json:"principal,omitempty"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Created    time.Time              `json:"created"`
+	Connection string                 `json:"connection,omitempty"`
+	Variables  map[string]interface{} `json:"variables,omitempty"`
+}
+
+// states returns the state of the open sessions.
+func (sm *SessionManager) states() []sessionState {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	states := make([]sessionState, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		session.mu.Lock()
+		states = append(states, sessionState{
+			ID:         session.ID,
+			Principal:  session.Principal,
+			Tenant:     session.Tenant,
+			Created:    session.Created,
+			Connection: session.connection,
+			Variables:  maps.Clone(session.variables),
+		})
+		session.mu.Unlock()
+	}
+	return states
+}
+
+// restore opens sessions with the states, other than those already open.
+func (sm *SessionManager) restore(states []sessionState) {
+	now := time.Now()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, state := range states {
+		if _, ok := sm.sessions[state.ID]; ok {
+			continue
+		}
+		session := &Session{
+			ID:         state.ID,
+			Principal:  state.Principal,
+			Tenant:     state.Tenant,
+			Created:    state.Created,
+			connection: state.Connection,
+			variables:  state.Variables,
+			cursors:    make(map[string]bool),
+			jobs:       make(map[string]bool),
+
+			transactions: make(map[string]*sessionTx),
+		}
+		if session.variables == nil {
+			session.variables = make(map[string]interface{})
+		}
+		session.touch(now)
+		sm.sessions[session.ID] = session
+	}
+}
+
+// counts returns the number of open sessions, and of the transactions they
+// hold open.
+func (sm *SessionManager) counts() (sessions, transactions int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, session := range sm.sessions {
+		session.mu.Lock()
+		transactions += len(session.transactions)
+		session.mu.Unlock()
+	}
+	return len(sm.sessions), transactions
+}
+
+// holdsTransactions reports whether the session with the ID holds
+// transactions open.
+func (sm *SessionManager) holdsTransactions(id string) bool {
+	if id == "" {
+		return false
+	}
+	sm.mu.Lock()
+	session, ok := sm.sessions[id]
+	sm.mu.Unlock()
+	if !ok {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return len(session.transactions) != 0
+}
+
+// transactionsByConnection returns the number of transactions sessions hold
+// open, by connection ID.
+func (sm *SessionManager) transactionsByConnection() map[string]int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	n := make(map[string]int)
+	for _, session := range sm.sessions {
+		session.mu.Lock()
+		for _, stx := range session.transactions {
+			n[stx.conn.ID]++
+		}
+		session.mu.Unlock()
+	}
+	return n
+}
+
+// Shutdown closes all sessions.
+func (sm *SessionManager) Shutdown() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for id, session := range sm.sessions {
+		sm.remove(id, session)
+	}
+}
+
+// handleEndSession handles a client ending its session, by deleting it with
+// its ID in the Mcp-Session-Id header.
+func (s *Server) handleEndSession(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(mcp.SessionHeader)
+	if id == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s header is required", mcp.SessionHeader))
+		return
+	}
+	if _, ok := s.pool.sessions.Get(r.Context(), id); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("session with ID %s not found", id))
+		return
+	}
+	s.pool.sessions.Close(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionContext returns the request's context, bound to the session with
+// the ID in its Mcp-Session-Id header, if any, so that its queries run in the
+// session's transactions. It returns an error when the principal has no such
+// session.
+func (s *Server) sessionContext(r *http.Request) (context.Context, error) {
+	ctx, id := r.Context(), r.Header.Get(mcp.SessionHeader)
+	if id == "" {
+		return ctx, nil
+	}
+	session, ok := s.pool.sessions.Get(ctx, id)
+	if !ok {
+		return nil, fmt.Errorf("session with ID %s not found", id)
+	}
+	return session.Bind(ctx), nil
+}
+
+// handleSessions handles listing the open client sessions.
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.sessions.List())
+}
+
+// handleCloseSession handles closing a client session.
+func (s *Server) handleCloseSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.pool.sessions.Close(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"closed": id})
+}
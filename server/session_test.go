@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+func TestSessionManager(t *testing.T) {
+	cursors := NewCursorManager(time.Minute, 0)
+	defer cursors.Shutdown()
+	jobs := NewJobManager(JobConfig{})
+	defer jobs.Shutdown()
+	sm := NewSessionManager(ServerConfig{SessionIdleTimeout: time.Minute, MaxSessions: 2}, cursors, jobs)
+	defer sm.Shutdown()
+
+	alice := policy.WithPrincipal(context.Background(), "alice")
+	a := sm.Create(alice)
+	a.SetConnection("reporting")
+	a.SetVariable("region", "emea")
+	a.AddCursor("gone")
+
+	// sessions belong to their principal
+	if s, ok := sm.Get(alice, a.ID); !ok || s != a {
+		t.Fatalf("expected the session of alice, got: %v %t", s, ok)
+	}
+	if _, ok := sm.Get(policy.WithPrincipal(context.Background(), "bob"), a.ID); ok {
+		t.Errorf("expected the session of alice not to be found for bob")
+	}
+	if _, ok := sm.Get(policy.WithTenant(alice, "payments"), a.ID); ok {
+		t.Errorf("expected the session of alice not to be found in a tenant")
+	}
+
+	infos := sm.List()
+	if len(infos) != 1 || infos[0].Principal != "alice" || infos[0].Connection != "reporting" || infos[0].Variables["region"] != "emea" || len(infos[0].Cursors) != 0 {
+		t.Errorf("expected the session of alice, without closed cursors, got: %+v", infos)
+	}
+
+	// the least recently used session is closed to make room
+	a.touch(time.Now().Add(-time.Second))
+	b := sm.Create(alice)
+	c := sm.Create(alice)
+	if _, ok := sm.Get(alice, a.ID); ok {
+		t.Errorf("expected the least recently used session to be closed")
+	}
+
+	// idle sessions expire
+	b.touch(time.Now().Add(-2 * time.Minute))
+	if c := sm.expire(time.Now()); c.Sessions != 1 {
+		t.Errorf("expected 1 session to expire, got: %d", c.Sessions)
+	}
+	if infos := sm.List(); len(infos) != 1 || infos[0].ID != c.ID {
+		t.Errorf("expected only the last session to remain, got: %+v", infos)
+	}
+
+	if err := sm.Close(c.ID); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if err := sm.Close(c.ID); err == nil {
+		t.Errorf("expected an error closing a closed session")
+	}
+}
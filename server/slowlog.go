@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+// SlowQuery records a query taking longer than its connection's slow query
+// threshold.
+type SlowQuery struct {
+	Time         time.Time   `json:"time"`
+	ConnectionID string      `json:"connection_id"`
+	Principal    string      `json:"principal,omitempty"`
+	Tenant       string      `json:"tenant,omitempty"`
+	Session      string      `json:"session,omitempty"`
+	SQL          string      `json:"sql"`
+	Rows         int64       `json:"rows"`
+	DurationMS   float64     `json:"duration_ms"`
+	ThresholdMS  float64     `json:"threshold_ms"`
+	Timing       QueryTiming `json:"timing"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// QueryTiming is the breakdown of a query's duration: preparing it (hooks,
+// policies, connecting and binding its arguments), waiting for a query slot,
+// executing it until its first rows are available, and fetching its rows.
+// The rows of cursors are timed up to their first page.
+type QueryTiming struct {
+	PrepareMS float64 `json:"prepare_ms"`
+	QueueMS   float64 `json:"queue_ms"`
+	ExecuteMS float64 `json:"execute_ms"`
+	FetchMS   float64 `json:"fetch_ms"`
+}
+
+// Phases of a query timed by a query timer.
+const (
+	phasePrepare = iota
+	phaseQueue
+	phaseExecute
+	phaseFetch
+)
+
+// queryTimer times the phases of a query's execution, attributing the time
+// elapsed to the phase it is in until stopped.
+type queryTimer struct {
+	query   string
+	started time.Time
+	last    time.Time
+	phase   int
+	stopped bool
+	phases  [4]time.Duration
+}
+
+// newQueryTimer starts timing the query, in its prepare phase.
+func newQueryTimer(query string) *queryTimer {
+	now := time.Now()
+	return &queryTimer{query: query, started: now, last: now}
+}
+
+// enter attributes the time elapsed to the current phase, and enters the
+// phase, resuming the timer when stopped.
+func (t *queryTimer) enter(phase int) {
+	now := time.Now()
+	if !t.stopped {
+		t.phases[t.phase] += now.Sub(t.last)
+	}
+	t.last, t.phase, t.stopped = now, phase, false
+}
+
+// stop attributes the time elapsed to the current phase, and stops timing.
+func (t *queryTimer) stop() {
+	if !t.stopped {
+		t.phases[t.phase] += time.Since(t.last)
+		t.stopped = true
+	}
+}
+
+// total returns the time spent in all phases.
+func (t *queryTimer) total() time.Duration {
+	var d time.Duration
+	for _, p := range t.phases {
+		d += p
+	}
+	return d
+}
+
+// timing returns the breakdown of the time spent in each phase.
+func (t *queryTimer) timing() QueryTiming {
+	return QueryTiming{
+		PrepareMS: ms(t.phases[phasePrepare]),
+		QueueMS:   ms(t.phases[phaseQueue]),
+		ExecuteMS: ms(t.phases[phaseExecute]),
+		FetchMS:   ms(t.phases[phaseFetch]),
+	}
+}
+
+// ms returns the duration in fractional milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// slowLog reports the queries of the pool's connections exceeding the slow
+// query threshold.
+type slowLog struct {
+	config func() *Config
+
+	mu sync.RWMutex
+	f  func(SlowQuery)
+}
+
+// OnSlowQuery sets f to be called once a query took longer than its
+// connection's slow query threshold.
+func (cp *ConnectionPool) OnSlowQuery(f func(SlowQuery)) {
+	cp.slow.mu.Lock()
+	defer cp.slow.mu.Unlock()
+	cp.slow.f = f
+}
+
+// slowQueryThreshold returns the connection's slow query threshold, its own
+// when predefined with one, or the server's.
+func (conn *Connection) slowQueryThreshold() time.Duration {
+	if conn.predefined != nil && conn.predefined.SlowQueryThreshold > 0 {
+		return conn.predefined.SlowQueryThreshold
+	}
+	return conn.slow.config().Server.SlowQueryThreshold
+}
+
+// checkSlow stops the timer, and logs its query as slow when it took longer
+// than the connection's slow query threshold, with the rows it read or
+// affected.
+func (conn *Connection) checkSlow(ctx context.Context, t *queryTimer, rows int64, err error) {
+	if t == nil || conn.slow == nil {
+		return
+	}
+	t.stop()
+	threshold, d := conn.slowQueryThreshold(), t.total()
+	if threshold <= 0 || d < threshold {
+		return
+	}
+	q := SlowQuery{
+		Time:         t.started.UTC(),
+		ConnectionID: conn.ID,
+		Principal:    policy.PrincipalFrom(ctx),
+		Tenant:       policy.TenantFrom(ctx),
+		SQL:          normalizeSQL(t.query),
+		Rows:         rows,
+		DurationMS:   ms(d),
+		ThresholdMS:  ms(threshold),
+		Timing:       t.timing(),
+	}
+	if s := sessionFrom(ctx); s != nil {
+		q.Session = s.ID
+	}
+	if err != nil {
+		q.Error = err.Error()
+	}
+	poolLog.Warn("slow query", "connection", q.ConnectionID, "duration_ms", q.DurationMS, "threshold_ms", q.ThresholdMS, "sql", q.SQL)
+	conn.slow.mu.RLock()
+	f := conn.slow.f
+	conn.slow.mu.RUnlock()
+	if f != nil {
+		f(q)
+	}
+}
+
+// recordSlowQuery records a slow query in the slow queries store.
+func (s *Server) recordSlowQuery(q SlowQuery) {
+	store, err := s.openStore("slow_queries")
+	if err == nil {
+		err = store.Append(q)
+	}
+	if err != nil {
+		storageLog.Error("failed to record slow query", "connection", q.ConnectionID, "error", err)
+	}
+}
+
+// handleSlowQueries handles listing the latest slow queries, oldest first,
+// optionally of a connection, since a time, and up to a limit.
+func (s *Server) handleSlowQueries(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+			return
+		}
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+	}
+	res := []SlowQuery{}
+	store, ok := s.store("slow_queries")
+	if !ok {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	records, err := store.Records(since, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	id := r.URL.Query().Get("connection_id")
+	for i := len(records) - 1; i >= 0 && (limit == 0 || len(res) < limit); i-- {
+		var q SlowQuery
+		if err := json.Unmarshal(records[i].Data, &q); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if id == "" || q.ConnectionID == id {
+			res = append(res, q)
+		}
+	}
+	slices.Reverse(res)
+	writeJSON(w, http.StatusOK, res)
+}
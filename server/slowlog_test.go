@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/policy"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	cp := NewConnectionPool(&Config{Server: ServerConfig{SlowQueryThreshold: time.Nanosecond}}, nil, nil)
+	defer cp.Close()
+	var slow []SlowQuery
+	cp.OnSlowQuery(func(q SlowQuery) {
+		slow = append(slow, q)
+	})
+	u, _ := dburl.Parse("postgres://localhost/db")
+	conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), slow: cp.slow}
+
+	ctx := policy.WithPrincipal(context.Background(), "alice")
+	if _, err := conn.ExecuteStatement(ctx, "UPDATE t  -- all of them\n SET a = $1", 5); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(slow) != 1 {
+		t.Fatalf("expected the statement to be logged as slow, got: %+v", slow)
+	}
+	q := slow[0]
+	switch {
+	case q.ConnectionID != "c" || q.Principal != "alice" || q.SQL != "UPDATE t SET a = $1" || q.Rows != 1:
+		t.Errorf("expected the normalized statement, got: %+v", q)
+	case q.DurationMS <= 0 || q.Timing.ExecuteMS <= 0 || q.Timing.FetchMS != 0:
+		t.Errorf("expected the statement's duration broken down, got: %+v", q)
+	}
+	total := q.Timing.PrepareMS + q.Timing.QueueMS + q.Timing.ExecuteMS + q.Timing.FetchMS
+	if d := total - q.DurationMS; d > 0.001 || d < -0.001 {
+		t.Errorf("expected the timing to add up to %f, got: %f", q.DurationMS, total)
+	}
+
+	// predefined connections override the threshold
+	conn.predefined = &ConnectionConfig{ID: "c", SlowQueryThreshold: time.Hour}
+	if _, err := conn.ExecuteStatement(ctx, "UPDATE t SET a = 1"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(slow) != 1 {
+		t.Errorf("expected the statement under the connection's threshold not to be logged, got: %+v", slow[1:])
+	}
+}
+
+func TestQueryTimer(t *testing.T) {
+	timer := newQueryTimer("SELECT 1")
+	time.Sleep(time.Millisecond)
+	timer.enter(phaseExecute)
+	time.Sleep(2 * time.Millisecond)
+	timer.stop()
+	// stopped time is not attributed
+	time.Sleep(5 * time.Millisecond)
+	timer.enter(phaseFetch)
+	time.Sleep(time.Millisecond)
+	timer.stop()
+
+	timing := timer.timing()
+	switch {
+	case timing.PrepareMS < 1 || timing.ExecuteMS < 2 || timing.FetchMS < 1 || timing.QueueMS != 0:
+		t.Errorf("expected each phase timed, got: %+v", timing)
+	case timer.total() >= time.Since(timer.started)-4*time.Millisecond:
+		t.Errorf("expected the time stopped not to be timed, got: %v", timer.total())
+	}
+}
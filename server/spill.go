@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+)
+
+func init() {
+	// the types of values held in rows, as converted when scanned
+	gob.Register(json.Number(""))
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+	gob.Register(spillBytes{})
+}
+
+// spiller spills the rows of oversized results to temporary files on disk
+// once the rows read into memory reach its threshold, serving the spilled
+// rows as cursors, so a huge result doesn't exhaust the server's memory. A
+// nil spiller spills nothing.
+type spiller struct {
+	threshold int64
+	maxBytes  int64
+	dir       string
+	cursors   *CursorManager
+}
+
+// newSpiller creates a new spiller serving spilled rows from the cursors,
+// or returns nil when the configured threshold is not set.
+func newSpiller(config ServerConfig, cursors *CursorManager) *spiller {
+	if config.SpillThreshold <= 0 {
+		return nil
+	}
+	return &spiller{
+		threshold: config.SpillThreshold,
+		maxBytes:  config.SpillMaxBytes,
+		dir:       config.SpillDir,
+		cursors:   cursors,
+	}
+}
+
+// forQuery returns the spilling of the result of a query executed on the
+// connection, or nil when the spiller is nil.
+func (s *spiller) forQuery(conn *Connection, query string, provenance *Provenance) *resultSpill {
+	if s == nil {
+		return nil
+	}
+	return &resultSpill{spiller: s, conn: conn, query: query, provenance: provenance}
+}
+
+// resultSpill is the spilling of a query's result. A nil spill spills
+// nothing.
+type resultSpill struct {
+	*spiller
+	conn       *Connection
+	query      string
+	provenance *Provenance
+}
+
+// exceeded returns whether size bytes of rows read into memory reach the
+// threshold.
+func (rs *resultSpill) exceeded(size int64) bool {
+	return rs != nil && size >= rs.threshold
+}
+
+// spill writes the remaining rows of the result set to a temporary file, up
+// to maxRows rows when greater than 0 and the spiller's bytes bound,
+// returning the ID of the cursor the rows are fetched from, and whether rows
+// were left unread due to the bounds. Rows are scanned as the scan types,
+// and masked before they are written.
+func (rs *resultSpill) spill(rows *sql.Rows, set *QueryResult, scanTypes []string, masks *policy.ColumnMasks, dc *driverConverter, maxRows int) (_ string, truncated bool, err error) {
+	f, err := os.CreateTemp(rs.dir, "usqlr-spill-*")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	sf := &spillFile{f: f, threshold: rs.threshold}
+	defer func() {
+		if err != nil {
+			sf.close()
+		}
+	}()
+	if runtime.GOOS != "windows" {
+		// unlinked while open, so it is removed even when the server exits
+		// before the cursor is closed
+		os.Remove(f.Name())
+	}
+
+	buf := getScanBuffer()
+	defer buf.release()
+	bw := bufio.NewWriter(f)
+	enc := gob.NewEncoder(bw)
+	n, size := 0, int64(0)
+	for rows.Next() {
+		if maxRows > 0 && n == maxRows {
+			truncated = true
+			break
+		}
+		values, err := buf.scan(rows, make([]interface{}, len(scanTypes)), scanTypes, dc)
+		if err != nil {
+			return "", false, err
+		}
+		masks.Row(values)
+		size += rowSize(values)
+		if rs.maxBytes > 0 && size > rs.maxBytes {
+			truncated = true
+			break
+		}
+		if err := enc.Encode(spillRow(values)); err != nil {
+			return "", false, fmt.Errorf("failed to write spill file: %w", err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return "", false, fmt.Errorf("row iteration error: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return "", false, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", false, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	sf.dec = gob.NewDecoder(bufio.NewReader(f))
+
+	cursor := &Cursor{
+		ID:           newID(),
+		ConnectionID: rs.conn.ID,
+		Columns:      set.Columns,
+		ColumnTypes:  set.ColumnTypes,
+		JSONTypes:    set.JSONTypes,
+		Provenance:   rs.provenance,
+		conn:         rs.conn,
+		query:        rs.query,
+		spill:        sf,
+		cancel:       func() {},
+	}
+	if err := rs.cursors.add(cursor); err != nil {
+		return "", false, err
+	}
+	return cursor.ID, truncated, nil
+}
+
+// spillFile is a temporary file of spilled rows, read by a cursor.
+type spillFile struct {
+	f   *os.File
+	dec *gob.Decoder
+
+	// threshold bounds the bytes of rows fetched in a page
+	threshold int64
+}
+
+// next reads the next row, returning false at the end of the file.
+func (sf *spillFile) next() ([]interface{}, bool, error) {
+	var values []interface{}
+	switch err := sf.dec.Decode(&values); {
+	case err == io.EOF:
+		return nil, false, nil
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	for i, v := range values {
+		if b, ok := v.(spillBytes); ok {
+			values[i] = append([]byte{}, b...)
+		}
+	}
+	return values, true, nil
+}
+
+// close closes and removes the file.
+func (sf *spillFile) close() error {
+	err := sf.f.Close()
+	os.Remove(sf.f.Name())
+	return err
+}
+
+// spillBytes is binary data in a spill file, distinguishing empty data from
+// NULL, as gob decodes empty []byte values as nil.
+type spillBytes []byte
+
+// spillRow returns the row's values as written to a spill file.
+func spillRow(values []interface{}) []interface{} {
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			values[i] = spillBytes(b)
+		}
+	}
+	return values
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestSpill(t *testing.T) {
+	u, _ := dburl.Parse("sqlserver://localhost/db")
+	dir := t.TempDir()
+	cp := NewConnectionPool(&Config{Server: ServerConfig{SpillThreshold: 8, SpillDir: dir}}, nil, nil)
+	defer cp.cursors.Shutdown()
+	conn := &Connection{ID: "multi", URL: u, DB: sql.OpenDB(multiConnector{}), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	defer conn.DB.Close()
+	cp.connections[conn.ID] = conn
+
+	// the first row of a reaches the threshold, so the second is spilled
+	result, err := cp.QueryPage(context.Background(), "multi", "SELECT a; UPDATE t; SELECT b", 0, ResultLimits{})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case len(result.Rows) != 1 || result.ContinuationToken == "" || len(result.MoreResultSets) != 0:
+		t.Fatalf("expected 1 row and a continuation token, got: %v %q", result.Rows, result.ContinuationToken)
+	}
+
+	var rows [][]interface{}
+	for token := result.ContinuationToken; token != ""; token = result.ContinuationToken {
+		if result, err = cp.ContinueQuery(context.Background(), "multi", token, 0, ResultLimits{}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		rows = append(rows, result.Rows...)
+	}
+	switch {
+	case !reflect.DeepEqual(rows, [][]interface{}{{int64(2)}}):
+		t.Errorf("expected the spilled row, got: %v", rows)
+	case result.Provenance == nil || result.Provenance.ConnectionID != "multi":
+		t.Errorf("expected the result's provenance, got: %v", result.Provenance)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the spill file to be removed, got: %v", entries)
+	}
+
+	// rows beyond the row cap are not spilled
+	result, err = cp.QueryPage(context.Background(), "multi", "SELECT a", 0, ResultLimits{Rows: 1})
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case !result.Truncated || result.ContinuationToken != "":
+		t.Errorf("expected a truncated result without spilled rows, got: %t %q", result.Truncated, result.ContinuationToken)
+	}
+}
+
+func TestSpillValues(t *testing.T) {
+	values := []interface{}{nil, int64(1), 1.5, "a", true, []byte{}, []byte("b"), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), json.Number("1.50"), map[string]interface{}{"a": float64(1)}, []interface{}{"x", nil}}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(spillRow(append([]interface{}(nil), values...))); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	sf := &spillFile{dec: gob.NewDecoder(&buf)}
+	row, ok, err := sf.next()
+	switch {
+	case err != nil || !ok:
+		t.Fatalf("expected a row, got: %t %v", ok, err)
+	case !reflect.DeepEqual(row, values):
+		t.Errorf("expected %#v, got: %#v", values, row)
+	}
+	if _, ok, err := sf.next(); ok || err != nil {
+		t.Errorf("expected the end of the file, got: %t %v", ok, err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package sqlscan provides a lightweight, dialect-tolerant SQL lexer used to
+// split, classify and rewrite statements without fully parsing them.
+package sqlscan
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is a token kind.
+type Kind int
+
+// Token kinds.
+const (
+	Space Kind = iota
+	Comment
+	Word
+	QuotedIdent
+	String
+	Number
+	Placeholder
+	Punct
+)
+
+// String satisfies the fmt.Stringer interface.
+func (k Kind) String() string {
+	switch k {
+	case Space:
+		return "space"
+	case Comment:
+		return "comment"
+	case Word:
+		return "word"
+	case QuotedIdent:
+		return "quoted identifier"
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case Placeholder:
+		return "placeholder"
+	}
+	return "punct"
+}
+
+// Token is a lexical token, with its byte offset in the scanned string.
+type Token struct {
+	Kind Kind
+	Text string
+	Pos  int
+}
+
+// Is reports whether the token is the word w, compared case-insensitively.
+func (t Token) Is(w string) bool {
+	return t.Kind == Word && strings.EqualFold(t.Text, w)
+}
+
+// Scan splits s into tokens. Concatenating the text of all tokens returns s.
+//
+// Recognized placeholders are '?', '$1', ':1', ':name' and '@name'. The
+// PostgreSQL '::' cast operator and MySQL '@@' system variables are not
+// treated as placeholders.
+func Scan(s string) []Token {
+	var tokens []Token
+	for i := 0; i < len(s); {
+		kind, n := next(s[i:])
+		tokens = append(tokens, Token{Kind: kind, Text: s[i : i+n], Pos: i})
+		i += n
+	}
+	return tokens
+}
+
+// next returns the kind and length of the token at the start of s.
+func next(s string) (Kind, int) {
+	c, size := utf8.DecodeRuneInString(s)
+	switch {
+	case unicode.IsSpace(c):
+		return Space, span(s, unicode.IsSpace)
+	case strings.HasPrefix(s, "--"):
+		if i := strings.IndexByte(s, '\n'); i != -1 {
+			return Comment, i + 1
+		}
+		return Comment, len(s)
+	case strings.HasPrefix(s, "/*"):
+		if i := strings.Index(s[2:], "*/"); i != -1 {
+			return Comment, i + 4
+		}
+		return Comment, len(s)
+	case c == '\'':
+		return String, quoted(s, '\'', false)
+	case (c == 'E' || c == 'e') && len(s) > 1 && s[1] == '\'':
+		return String, 1 + quoted(s[1:], '\'', true)
+	case (c == 'N' || c == 'n' || c == 'X' || c == 'x' || c == 'B' || c == 'b') && len(s) > 1 && s[1] == '\'':
+		return String, 1 + quoted(s[1:], '\'', false)
+	case c == '"':
+		return QuotedIdent, quoted(s, '"', false)
+	case c == '`':
+		return QuotedIdent, quoted(s, '`', false)
+	case c == '$':
+		if n := span(s[1:], isDigit); n != 0 {
+			return Placeholder, n + 1
+		}
+		if tag, ok := dollarTag(s); ok {
+			if i := strings.Index(s[len(tag):], tag); i != -1 {
+				return String, len(tag) + i + len(tag)
+			}
+			return String, len(s)
+		}
+	case c == '?':
+		return Placeholder, 1
+	case c == ':' && len(s) > 1 && s[1] == ':':
+		return Punct, 2
+	case c == ':' && len(s) > 1:
+		if n := span(s[1:], isWordRune); n != 0 {
+			return Placeholder, n + 1
+		}
+	case c == '@' && len(s) > 1 && s[1] == '@':
+		return Word, 2 + span(s[2:], isWordRune)
+	case c == '@' && len(s) > 1:
+		if n := span(s[1:], isWordRune); n != 0 {
+			return Placeholder, n + 1
+		}
+	case isDigit(c), c == '.' && len(s) > 1 && isDigit(rune(s[1])):
+		return Number, number(s)
+	case isWordRune(c):
+		return Word, span(s, func(r rune) bool { return isWordRune(r) || r == '$' })
+	}
+	return Punct, size
+}
+
+// span returns the length of the prefix of s whose runes satisfy f.
+func span(s string, f func(rune) bool) int {
+	for i, r := range s {
+		if !f(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// quoted returns the length of the quoted string at the start of s,
+// including quotes. Doubled quotes are treated as escaped, as are backslash
+// escapes when backslash is true.
+func quoted(s string, quote byte, backslash bool) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case backslash && s[i] == '\\':
+			i++
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarTag returns the PostgreSQL dollar quote tag ($$ or $tag$) at the
+// start of s.
+func dollarTag(s string) (string, bool) {
+	n := span(s[1:], isWordRune)
+	if 1+n < len(s) && s[1+n] == '$' && (n == 0 || !isDigit(rune(s[1]))) {
+		return s[:n+2], true
+	}
+	return "", false
+}
+
+// number returns the length of the numeric literal at the start of s.
+func number(s string) int {
+	i := span(s, isDigit)
+	if i < len(s) && s[i] == '.' {
+		i += 1 + span(s[i+1:], isDigit)
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if n := span(s[j:], isDigit); n != 0 {
+			i = j + n
+		}
+	}
+	return i
+}
+
+// isDigit reports whether r is an ASCII digit.
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+// isWordRune reports whether r can be part of an identifier or keyword.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Split splits s into statements on semicolons outside of strings, quoted
+// identifiers and comments. Empty statements are omitted.
+func Split(s string) []string {
+	var stmts []string
+	start := 0
+	tokens := Scan(s)
+	for _, t := range tokens {
+		if t.Kind == Punct && t.Text == ";" {
+			if !isEmpty(tokens, start, t.Pos) {
+				stmts = append(stmts, strings.TrimSpace(s[start:t.Pos]))
+			}
+			start = t.Pos + 1
+		}
+	}
+	if !isEmpty(tokens, start, len(s)) {
+		stmts = append(stmts, strings.TrimSpace(s[start:]))
+	}
+	return stmts
+}
+
+// isEmpty reports whether the tokens between start and end are only space
+// and comments.
+func isEmpty(tokens []Token, start, end int) bool {
+	for _, t := range tokens {
+		if t.Pos >= start && t.Pos < end && t.Kind != Space && t.Kind != Comment {
+			return false
+		}
+	}
+	return true
+}
+
+// Words returns the significant tokens of s, skipping space and comments.
+func Words(s string) []Token {
+	var tokens []Token
+	for _, t := range Scan(s) {
+		if t.Kind != Space && t.Kind != Comment {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
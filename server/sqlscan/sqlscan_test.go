@@ -0,0 +1,55 @@
+package sqlscan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp []Kind
+	}{
+		{"select 1", []Kind{Word, Space, Number}},
+		{"a = ?", []Kind{Word, Space, Punct, Space, Placeholder}},
+		{"$1::int", []Kind{Placeholder, Punct, Word}},
+		{":name @id @@version", []Kind{Placeholder, Space, Placeholder, Space, Word}},
+		{"'it''s' E'\\'' $$a;b$$ $x$;$x$", []Kind{String, Space, String, Space, String, Space, String}},
+		{`"a;b" ` + "`c`", []Kind{QuotedIdent, Space, QuotedIdent}},
+		{"-- c;\n/* d; */1.5e3", []Kind{Comment, Comment, Number}},
+	}
+	for i, test := range tests {
+		tokens := Scan(test.s)
+		var kinds []Kind
+		var sb strings.Builder
+		for _, tok := range tokens {
+			kinds = append(kinds, tok.Kind)
+			sb.WriteString(tok.Text)
+		}
+		if !reflect.DeepEqual(kinds, test.exp) {
+			t.Errorf("test %d expected %v, got: %v", i, test.exp, kinds)
+		}
+		if sb.String() != test.s {
+			t.Errorf("test %d expected tokens to concatenate to %q, got: %q", i, test.s, sb.String())
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp []string
+	}{
+		{"select 1", []string{"select 1"}},
+		{"select 1; select 2;", []string{"select 1", "select 2"}},
+		{"select ';'; ; -- only a comment;", []string{"select ';'"}},
+		{"create function f() as $$ begin; end; $$; select 1", []string{"create function f() as $$ begin; end; $$", "select 1"}},
+		{"  ", nil},
+	}
+	for i, test := range tests {
+		if stmts := Split(test.s); !reflect.DeepEqual(stmts, test.exp) {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, stmts)
+		}
+	}
+}
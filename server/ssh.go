@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xo/dburl"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKeepAlive is how often SSH jump hosts are sent keepalives, so that
+// lost connections are noticed and dialed again.
+const sshKeepAlive = 30 * time.Second
+
+// sshDialer dials a database through an SSH jump host, sharing an SSH
+// connection dialed on first use, and dialed again once lost.
+type sshDialer struct {
+	addr    string
+	target  string
+	config  *ssh.ClientConfig
+	agent   bool
+	netDial netDialFunc
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHDialer creates a dialer of the database at the DSN through the SSH
+// jump host of the config, dialing the jump host with netDial.
+func newSSHDialer(config SSHConfig, u *dburl.URL, netDial netDialFunc) (*sshDialer, error) {
+	target, err := dbAddr(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		signer, err := config.signer()
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	hostKey, err := config.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	addr := config.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return &sshDialer{
+		addr:    addr,
+		target:  target,
+		netDial: netDial,
+		config: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            auth,
+			HostKeyCallback: hostKey,
+			Timeout:         tunnelDialTimeout,
+		},
+		agent: config.Agent,
+	}, nil
+}
+
+// signer returns the signer of the config's private key.
+func (c SSHConfig) signer() (ssh.Signer, error) {
+	buf, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	var signer ssh.Signer
+	if c.PassphraseEnv != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(buf, []byte(os.Getenv(c.PassphraseEnv)))
+	} else {
+		signer, err = ssh.ParsePrivateKey(buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key %s: %w", c.KeyFile, err)
+	}
+	return signer, nil
+}
+
+// hostKeyCallback returns the callback verifying the jump host's key, which
+// is the config's host key, or a key of the host in its known hosts file.
+func (c SSHConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if c.HostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH host key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+	file := c.KnownHosts
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH known hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// validate validates the SSH config.
+func (c SSHConfig) validate() error {
+	switch {
+	case c.Host == "":
+		return errors.New("ssh host is empty")
+	case c.User == "":
+		return errors.New("ssh user is empty")
+	case c.KeyFile == "" && !c.Agent:
+		return errors.New("ssh requires a key_file or agent")
+	}
+	return nil
+}
+
+// dial dials the database through the jump host, dialing the jump host again
+// when its connection was lost.
+func (d *sshDialer) dial(ctx context.Context) (net.Conn, error) {
+	client, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.DialContext(ctx, "tcp", d.target)
+	if err != nil && ctx.Err() == nil {
+		d.reset(client)
+		if client, err = d.connect(ctx); err != nil {
+			return nil, err
+		}
+		c, err = client.DialContext(ctx, "tcp", d.target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ssh: failed to dial %s through %s: %w", d.target, d.addr, err)
+	}
+	return c, nil
+}
+
+// connect returns the SSH connection to the jump host, dialing it when not
+// connected.
+func (d *sshDialer) connect(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+	config := *d.config
+	if d.agent {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, errors.New("ssh: agent requested, but SSH_AUTH_SOCK is not set")
+		}
+		ac, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: failed to connect to agent: %w", err)
+		}
+		// the agent is only used while authenticating
+		defer ac.Close()
+		config.Auth = append(config.Auth[:len(config.Auth):len(config.Auth)], ssh.PublicKeysCallback(agent.NewClient(ac).Signers))
+	}
+
+	c, err := d.netDial(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: failed to dial %s: %w", d.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(c, d.addr, &config)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("ssh: failed to connect to %s: %w", strings.TrimSuffix(d.addr, ":22"), err)
+	}
+	c.SetDeadline(time.Time{})
+	d.client = ssh.NewClient(conn, chans, reqs)
+	go d.keepAlive(d.client)
+	return d.client, nil
+}
+
+// keepAlive sends keepalives to the jump host until its connection is
+// closed or lost.
+func (d *sshDialer) keepAlive(client *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(sshKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			d.reset(client)
+			return
+		case <-ticker.C:
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				d.reset(client)
+				return
+			}
+		}
+	}
+}
+
+// reset closes the SSH connection, when it is still the dialer's, so that
+// the jump host is dialed again.
+func (d *sshDialer) reset(client *ssh.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == client {
+		d.client.Close()
+		d.client = nil
+	}
+}
+
+// close satisfies the dialer interface.
+func (d *sshDialer) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		d.client.Close()
+		d.client = nil
+	}
+}
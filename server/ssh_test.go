@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHDialer(t *testing.T) {
+	db := testEchoServer(t)
+	defer db.Close()
+
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jump, hostKey, logins := testSSHServer(t, userKey.Public())
+	defer jump.Close()
+
+	u, _ := dburl.Parse("postgres://app@" + db.Addr().String() + "/app")
+	config := SSHConfig{
+		Host:    jump.Addr().String(),
+		User:    "bastion",
+		KeyFile: keyFile,
+		HostKey: string(ssh.MarshalAuthorizedKey(hostKey)),
+	}
+	_, d, err := parseRoute(t.Context(), u, &config, ProxyConfig{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tun, err := startTunnel(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := func() {
+		t.Helper()
+		c, err := net.Dial("tcp", tun.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 4)
+		c.Write([]byte("ping"))
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("expected the database to echo ping, got: %q %v", buf, err)
+		}
+	}
+	echo()
+	echo()
+	if n := logins.Load(); n != 1 {
+		t.Errorf("expected the SSH connection to be shared, got %d logins", n)
+	}
+
+	// a lost SSH connection is dialed again
+	d.(*sshDialer).client.Close()
+	echo()
+	if n := logins.Load(); n != 2 {
+		t.Errorf("expected the jump host to be dialed again, got %d logins", n)
+	}
+	tun.Close()
+	if d.(*sshDialer).client != nil {
+		t.Errorf("expected the SSH connection to be closed with the tunnel")
+	}
+
+	// the host key must match
+	other, _ := ssh.NewPublicKey(userKey.Public())
+	config.HostKey = string(ssh.MarshalAuthorizedKey(other))
+	_, d, _ = parseRoute(t.Context(), u, &config, ProxyConfig{})
+	if _, err := d.dial(t.Context()); err == nil || !strings.Contains(err.Error(), "host key") {
+		t.Errorf("expected a host key mismatch, got: %v", err)
+	}
+
+	for _, c := range []SSHConfig{
+		{User: "bastion", Agent: true},
+		{Host: "bastion"},
+		{Host: "bastion", User: "bastion"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+	noPort, _ := dburl.Parse("sqlite3:/tmp/app.db")
+	if _, err := newSSHDialer(config, noPort, directDial); err == nil {
+		t.Errorf("expected an error for a database without a host")
+	}
+}
+
+// testSSHServer starts an SSH server accepting the user key, and forwarding
+// direct-tcpip channels, returning its listener, its host key, and the count
+// of logins.
+func testSSHServer(t *testing.T, userKey interface{}) (net.Listener, ssh.PublicKey, *atomic.Int32) {
+	t.Helper()
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logins := new(atomic.Int32)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, io.EOF
+			}
+			logins.Add(1)
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if ch.ChannelType() != "direct-tcpip" || ssh.Unmarshal(ch.ExtraData(), &target) != nil {
+						ch.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						ch.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, creqs, err := ch.Accept()
+					if err != nil {
+						remote.Close()
+						continue
+					}
+					go ssh.DiscardRequests(creqs)
+					go func() {
+						io.Copy(channel, remote)
+						channel.Close()
+					}()
+					go func() {
+						io.Copy(remote, channel)
+						remote.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return ln, signer.PublicKey(), logins
+}
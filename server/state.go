@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xo/usql/server/policy"
+	"golang.org/x/crypto/scrypt"
+)
+
+// stateVersion is the version of the state bundle format.
+const stateVersion = 1
+
+// StateBundle is a portable export of a server's state, used to migrate or
+// restore an instance.
+//
+// Connection definitions contain credentials, so they are only included
+// encrypted with a passphrase.
+type StateBundle struct {
+	Version     int              `json:"version"`
+	ExportedAt  time.Time        `json:"exported_at"`
+	Connections *EncryptedData   `json:"connections,omitempty"`
+	Queries     []SavedQuery     `json:"queries"`
+	Policies    []*policy.Policy `json:"policies"`
+}
+
+// EncryptedData is data encrypted with AES-256-GCM using a key derived from
+// a passphrase with scrypt.
+type EncryptedData struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ImportReport is the result of importing a state bundle.
+type ImportReport struct {
+	Created  []string          `json:"created"`
+	Skipped  []string          `json:"skipped"`
+	Failed   map[string]string `json:"failed,omitempty"`
+	Queries  int               `json:"queries"`
+	Policies int               `json:"policies"`
+}
+
+// ExportState exports the server's connection definitions, saved queries
+// and policies. Connections are only exported when a passphrase is given.
+func (s *Server) ExportState(passphrase string) (*StateBundle, error) {
+	s.mu.Lock()
+	queries := s.queries
+	s.mu.Unlock()
+
+	bundle := &StateBundle{
+		Version:    stateVersion,
+		ExportedAt: time.Now().UTC(),
+		Queries:    append([]SavedQuery{}, queries...),
+		Policies:   append([]*policy.Policy{}, s.pool.policy.Policies()...),
+	}
+	if passphrase != "" {
+		buf, err := json.Marshal(s.pool.Definitions())
+		if err != nil {
+			return nil, err
+		}
+		if bundle.Connections, err = encrypt(buf, passphrase); err != nil {
+			return nil, fmt.Errorf("failed to encrypt connections: %w", err)
+		}
+	}
+	return bundle, nil
+}
+
+// ImportState imports a state bundle, replacing the saved queries and
+// policies and creating the bundle's connections. Connections that already
+// exist are left as is.
+func (s *Server) ImportState(ctx context.Context, bundle *StateBundle, passphrase string) (*ImportReport, error) {
+	if bundle.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state bundle version %d", bundle.Version)
+	}
+	var defs []ConnectionDefinition
+	if bundle.Connections != nil {
+		if passphrase == "" {
+			return nil, errors.New("a passphrase is required to import connections")
+		}
+		buf, err := decrypt(bundle.Connections, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(buf, &defs); err != nil {
+			return nil, fmt.Errorf("invalid connection definitions: %w", err)
+		}
+	}
+
+	previous := s.pool.policy.Policies()
+	if err := s.pool.policy.SetPolicies(bundle.Policies); err != nil {
+		return nil, fmt.Errorf("invalid policies: %w", err)
+	}
+	if err := s.mcpHandler.SetSavedQueries(convertSavedQueries(bundle.Queries)); err != nil {
+		s.pool.policy.SetPolicies(previous)
+		return nil, fmt.Errorf("invalid saved queries: %w", err)
+	}
+	s.mu.Lock()
+	s.queries = bundle.Queries
+	s.mu.Unlock()
+
+	report := &ImportReport{
+		Created:  []string{},
+		Skipped:  []string{},
+		Queries:  len(bundle.Queries),
+		Policies: len(bundle.Policies),
+	}
+	for _, def := range defs {
+		if _, err := s.pool.GetConnection(def.ID); err == nil {
+			report.Skipped = append(report.Skipped, def.ID)
+			continue
+		}
+		if err := s.createConnection(ctx, def); err != nil {
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[def.ID] = err.Error()
+			continue
+		}
+		report.Created = append(report.Created, def.ID)
+	}
+	return report, nil
+}
+
+// createConnection creates a connection, and its replicas, from its
+// definition, registering lazy connections without connecting.
+func (s *Server) createConnection(ctx context.Context, def ConnectionDefinition) error {
+	opts := connOptions{
+		lazy:       def.Lazy && len(def.Replicas) == 0,
+		predefined: def.predefined,
+		tls:        def.TLS,
+		failover:   def.Failover,
+	}
+	if def.Pool != nil {
+		opts.settings = *def.Pool
+	}
+	if _, err := s.pool.create(ctx, def.ID, def.DSN, opts); err != nil {
+		return err
+	}
+	s.pool.changed()
+	for i, dsn := range def.Replicas {
+		if err := s.pool.AddReplica(ctx, def.ID, dsn); err != nil {
+			s.pool.CloseConnection(def.ID)
+			return fmt.Errorf("replica %d: %w", i+1, err)
+		}
+	}
+	for _, alias := range def.Aliases {
+		if err := s.pool.AddAlias(def.ID, alias); err != nil {
+			s.pool.CloseConnection(def.ID)
+			return err
+		}
+	}
+	if len(def.Tags) != 0 {
+		return s.pool.SetTags(def.ID, def.Tags)
+	}
+	return nil
+}
+
+// deriveKey derives an AES-256 key from the passphrase.
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// encrypt encrypts data with the passphrase.
+func encrypt(data []byte, passphrase string) (*EncryptedData, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &EncryptedData{
+		KDF:        "scrypt",
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, data, nil),
+	}, nil
+}
+
+// decrypt decrypts data encrypted with the passphrase.
+func decrypt(data *EncryptedData, passphrase string) ([]byte, error) {
+	if data.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function %q", data.KDF)
+	}
+	key, err := deriveKey(passphrase, data.Salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	buf, err := gcm.Open(nil, data.Nonce, data.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt connections: wrong passphrase or corrupt bundle")
+	}
+	return buf, nil
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// Fingerprint returns the fingerprint of the query: the query without
+// comments, with runs of whitespace collapsed, words lowercased, and its
+// literals and placeholders replaced with ?, lists of which are collapsed to
+// a single ?, so executions of a query differing only in their values share
+// a fingerprint.
+func Fingerprint(query string) string {
+	type token struct {
+		text  string
+		space bool
+	}
+	var tokens []token
+	space := false
+	for _, t := range sqlscan.Scan(query) {
+		text := t.Text
+		switch t.Kind {
+		case sqlscan.Space, sqlscan.Comment:
+			space = len(tokens) != 0
+			continue
+		case sqlscan.Word:
+			text = strings.ToLower(text)
+		case sqlscan.String, sqlscan.Number, sqlscan.Placeholder:
+			// ?, ? collapses to ?
+			if n := len(tokens); n >= 2 && tokens[n-1].text == "," && tokens[n-2].text == "?" {
+				tokens, space = tokens[:n-1], false
+				continue
+			}
+			text = "?"
+		}
+		tokens = append(tokens, token{text, space})
+		space = false
+	}
+	var b strings.Builder
+	for _, t := range tokens {
+		if t.space {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// fingerprintID returns the short hash identifying the fingerprint.
+func fingerprintID(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:8])
+}
+
+// QueryStats are the statistics of the executions of a query fingerprint on
+// a connection, over the stats window.
+type QueryStats struct {
+	Fingerprint  string    `json:"fingerprint"`
+	SQL          string    `json:"sql"`
+	ConnectionID string    `json:"connection_id"`
+	Count        int64     `json:"count"`
+	Errors       int64     `json:"errors"`
+	Rows         int64     `json:"rows"`
+	TotalMS      float64   `json:"total_ms"`
+	MeanMS       float64   `json:"mean_ms"`
+	P95MS        float64   `json:"p95_ms"`
+	MaxMS        float64   `json:"max_ms"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Sort orders of query stats, from the highest.
+var statsSorts = map[string]func(*QueryStats) float64{
+	"total":  func(s *QueryStats) float64 { return s.TotalMS },
+	"count":  func(s *QueryStats) float64 { return float64(s.Count) },
+	"mean":   func(s *QueryStats) float64 { return s.MeanMS },
+	"p95":    func(s *QueryStats) float64 { return s.P95MS },
+	"errors": func(s *QueryStats) float64 { return float64(s.Errors) },
+	"rows":   func(s *QueryStats) float64 { return float64(s.Rows) },
+}
+
+const (
+	// statsBuckets is the number of intervals the stats window is divided
+	// in, the oldest of which is dropped as the window rolls over.
+	statsBuckets = 12
+	// latencyBuckets is the number of buckets of the latency histograms,
+	// each bounded by sqrt(2) times the previous bound, from 0.1ms.
+	latencyBuckets = 48
+)
+
+// latencyBucket returns the latency histogram bucket of the duration.
+func latencyBucket(d time.Duration) int {
+	ms := float64(d) / float64(time.Millisecond)
+	if ms <= 0.1 {
+		return 0
+	}
+	return min(int(math.Ceil(2*math.Log2(ms/0.1))), latencyBuckets-1)
+}
+
+// latencyBound returns the upper bound of the latency histogram bucket, in
+// milliseconds.
+func latencyBound(i int) float64 {
+	return 0.1 * math.Pow(2, float64(i)/2)
+}
+
+// statsBucket holds the statistics of the executions of an interval.
+type statsBucket struct {
+	// interval is the number of the interval since the epoch
+	interval  int64
+	count     int64
+	errors    int64
+	rows      int64
+	total     time.Duration
+	max       time.Duration
+	latencies [latencyBuckets]uint32
+}
+
+// statsEntry holds the statistics of a fingerprint on a connection, in a
+// ring of buckets by interval.
+type statsEntry struct {
+	sql      string
+	lastSeen time.Time
+	buckets  [statsBuckets]statsBucket
+}
+
+// statsKey identifies the statistics of a fingerprint on a connection.
+type statsKey struct {
+	fingerprint string
+	connection  string
+}
+
+// queryStats keeps rolling statistics of the executions of query
+// fingerprints per connection, over a window, for up to max fingerprints
+// and connections, dropping the least recently executed beyond it. A nil
+// query stats keeps none.
+type queryStats struct {
+	width time.Duration
+	max   int
+
+	mu      sync.Mutex
+	entries map[statsKey]*statsEntry
+}
+
+// newQueryStats creates the query stats of the server's stats window and
+// maximum fingerprints, or returns nil when the window is 0.
+func newQueryStats(config ServerConfig) *queryStats {
+	if config.QueryStatsWindow <= 0 {
+		return nil
+	}
+	return &queryStats{
+		width:   max(config.QueryStatsWindow/statsBuckets, time.Millisecond),
+		max:     config.MaxQueryFingerprints,
+		entries: make(map[statsKey]*statsEntry),
+	}
+}
+
+// record records the execution of the audit event's query.
+func (qs *queryStats) record(event audit.Event) {
+	if qs == nil || event.SQL == "" {
+		return
+	}
+	sql := Fingerprint(event.SQL)
+	key := statsKey{fingerprintID(sql), event.ConnectionID}
+	d := time.Duration(event.DurationMS * float64(time.Millisecond))
+	now := time.Now()
+	interval := now.UnixNano() / int64(qs.width)
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	e, ok := qs.entries[key]
+	if !ok {
+		if qs.max > 0 && len(qs.entries) >= qs.max {
+			qs.evict()
+		}
+		e = &statsEntry{sql: sql}
+		qs.entries[key] = e
+	}
+	e.lastSeen = now
+	b := &e.buckets[interval%statsBuckets]
+	if b.interval != interval {
+		*b = statsBucket{interval: interval}
+	}
+	b.count++
+	if event.Outcome != audit.Success {
+		b.errors++
+	}
+	b.rows += event.Rows
+	b.total += d
+	b.max = max(b.max, d)
+	b.latencies[latencyBucket(d)]++
+}
+
+// evict drops the least recently executed entry.
+func (qs *queryStats) evict() {
+	var oldest statsKey
+	var last time.Time
+	for key, e := range qs.entries {
+		if last.IsZero() || e.lastSeen.Before(last) {
+			oldest, last = key, e.lastSeen
+		}
+	}
+	delete(qs.entries, oldest)
+}
+
+// snapshot returns the statistics over the window of the fingerprints
+// executed on the connection with the ID, or on all connections when
+// empty, sorted by the sort order, up to the limit (unlimited when 0).
+// Entries not executed within the window are dropped.
+func (qs *queryStats) snapshot(id, sort string, limit int) ([]QueryStats, error) {
+	by, ok := statsSorts[sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort %q: must be total, count, mean, p95, errors or rows", sort)
+	}
+	stats := []QueryStats{}
+	if qs == nil {
+		return stats, nil
+	}
+	oldest := time.Now().UnixNano()/int64(qs.width) - statsBuckets + 1
+
+	qs.mu.Lock()
+	for key, e := range qs.entries {
+		if e.lastSeen.UnixNano()/int64(qs.width) < oldest {
+			delete(qs.entries, key)
+			continue
+		}
+		if id != "" && key.connection != id {
+			continue
+		}
+		s := QueryStats{Fingerprint: key.fingerprint, SQL: e.sql, ConnectionID: key.connection, LastSeen: e.lastSeen.UTC()}
+		var total, maxd time.Duration
+		var latencies [latencyBuckets]int64
+		for _, b := range e.buckets {
+			if b.interval < oldest {
+				continue
+			}
+			s.Count, s.Errors, s.Rows = s.Count+b.count, s.Errors+b.errors, s.Rows+b.rows
+			total, maxd = total+b.total, max(maxd, b.max)
+			for i, n := range b.latencies {
+				latencies[i] += int64(n)
+			}
+		}
+		if s.Count == 0 {
+			continue
+		}
+		s.TotalMS, s.MaxMS = ms(total), ms(maxd)
+		s.MeanMS = s.TotalMS / float64(s.Count)
+		// the p95 is the bound of the bucket holding it, at most the max
+		target, seen := int64(math.Ceil(0.95*float64(s.Count))), int64(0)
+		for i, n := range latencies {
+			if seen += n; seen >= target {
+				s.P95MS = min(latencyBound(i), s.MaxMS)
+				break
+			}
+		}
+		stats = append(stats, s)
+	}
+	qs.mu.Unlock()
+
+	slices.SortFunc(stats, func(a, b QueryStats) int {
+		switch x, y := by(&a), by(&b); {
+		case x > y:
+			return -1
+		case x < y:
+			return 1
+		}
+		return strings.Compare(a.Fingerprint, b.Fingerprint)
+	})
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats, nil
+}
+
+// QueryStats returns the statistics of the query fingerprints executed on
+// the connection with the ID, or on all connections when empty, over the
+// stats window, sorted by total, count, mean, p95, errors or rows, from the
+// highest, up to the limit (unlimited when 0).
+func (cp *ConnectionPool) QueryStats(id, sort string, limit int) ([]QueryStats, error) {
+	if id != "" {
+		id = cp.Resolve(id)
+	}
+	return cp.stats.snapshot(id, sort, limit)
+}
+
+// handleQueryStats handles listing the statistics of the query fingerprints,
+// optionally of a connection, sorted by sort (total by default), up to a
+// limit (100 by default).
+func (s *Server) handleQueryStats(w http.ResponseWriter, r *http.Request) {
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "total"
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+	}
+	stats, err := s.pool.QueryStats(r.URL.Query().Get("connection_id"), sort, limit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xo/usql/server/audit"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query, exp string
+	}{
+		{"SELECT * FROM t WHERE id = 1", "select * from t where id = ?"},
+		{"select *\n  from T -- all\n where ID = 42", "select * from t where id = ?"},
+		{"SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'a'", "select * from t where id in (?) and name = ?"},
+		{"SELECT * FROM t WHERE id IN ($1,$2)", "select * from t where id in (?)"},
+		{"UPDATE t SET a = 1, b = 'x' WHERE c = -2.5", "update t set a = ?, b = ? where c = -?"},
+		{`SELECT "Name" FROM t`, `select "Name" from t`},
+	}
+	for i, test := range tests {
+		if s := Fingerprint(test.query); s != test.exp {
+			t.Errorf("test %d expected %q, got: %q", i, test.exp, s)
+		}
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	qs := newQueryStats(ServerConfig{QueryStatsWindow: time.Hour, MaxQueryFingerprints: 2})
+	qs.record(audit.Event{ConnectionID: "a", SQL: "DROP TABLE t", DurationMS: 1, Outcome: audit.Denied})
+	for i := 1; i <= 100; i++ {
+		qs.record(audit.Event{ConnectionID: "a", SQL: "SELECT * FROM t WHERE id = 1", Rows: 2, DurationMS: float64(i), Outcome: audit.Success})
+	}
+	qs.record(audit.Event{ConnectionID: "b", SQL: "select * from t where id = 7", DurationMS: 500, Outcome: audit.Failure})
+
+	stats, err := qs.snapshot("", "total", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the drop was the least recently executed of the 3
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 fingerprints, got: %+v", stats)
+	}
+	s := stats[0]
+	switch {
+	case s.ConnectionID != "a" || s.SQL != "select * from t where id = ?" || s.Count != 100 || s.Errors != 0 || s.Rows != 200:
+		t.Errorf("expected the select's stats on a first, got: %+v", s)
+	case s.MeanMS != 50.5 || s.MaxMS != 100 || s.P95MS < 95 || s.P95MS > 100:
+		t.Errorf("expected a mean of 50.5ms and a p95 of 95-100ms, got: %+v", s)
+	case stats[1].ConnectionID != "b" || stats[1].Errors != 1 || stats[1].Fingerprint != s.Fingerprint:
+		t.Errorf("expected the select's stats on b second, got: %+v", stats[1])
+	}
+
+	stats, _ = qs.snapshot("b", "errors", 1)
+	if len(stats) != 1 || stats[0].ConnectionID != "b" {
+		t.Errorf("expected the stats of b, got: %+v", stats)
+	}
+	if _, err := qs.snapshot("", "nope", 0); err == nil {
+		t.Errorf("expected an error with an invalid sort")
+	}
+	var nilStats *queryStats
+	nilStats.record(audit.Event{SQL: "SELECT 1"})
+	if stats, err := nilStats.snapshot("", "total", 0); err != nil || len(stats) != 0 {
+		t.Errorf("expected no stats when disabled, got: %v %v", stats, err)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/xo/usql/server/mcp"
+)
+
+// ServeStdio serves MCP over stdio, reading newline delimited JSON-RPC
+// messages from r and writing a response for each request to w, until r is
+// closed or the context is done. Notifications (messages without an id) are
+// handled without a response. The client is notified when the list of
+// resources changes, as connections are created or closed.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	sw := &stdioWriter{w: w}
+	s.mcpHandler.SetListChanged(true)
+	s.pool.OnListChanged(func() {
+		if err := sw.write([]byte(listChangedNotification)); err != nil {
+			mcpLog.Error("failed to send notification", "error", err)
+		}
+	})
+	defer s.pool.OnListChanged(nil)
+	defer s.mcpHandler.SetListChanged(false)
+
+	// the process serves a single client, whose session is kept until stdio
+	// is closed
+	var session string
+	defer func() {
+		if session != "" {
+			s.pool.sessions.Close(session)
+		}
+	}()
+
+	lines, errc := make(chan []byte), make(chan error, 1)
+	go func() {
+		defer close(lines)
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) != 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					errc <- err
+				}
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				select {
+				case err := <-errc:
+					return fmt.Errorf("failed to read request: %w", err)
+				default:
+					return nil
+				}
+			}
+			if err := s.serveStdioMessage(ctx, line, sw, &session); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// listChangedNotification is the notification sent over stdio when the list
+// of resources changes.
+const listChangedNotification = `{"jsonrpc":"2.0","method":"notifications/resources/list_changed"}` + "\n"
+
+// serveStdioMessage handles a JSON-RPC message read from stdio, as part of
+// the session, writing the response on a single line. The session is the
+// one issued when the message initialized one.
+func (s *Server) serveStdioMessage(ctx context.Context, msg []byte, w *stdioWriter, session *string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/mcp", bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	if *session != "" {
+		req.Header.Set(mcp.SessionHeader, *session)
+	}
+	res := &stdioResponse{header: make(http.Header)}
+	s.handleMCP(res, req)
+	if id := res.header.Get(mcp.SessionHeader); id != "" && id != *session {
+		if *session != "" {
+			s.pool.sessions.Close(*session)
+		}
+		*session = id
+	}
+
+	var m struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(msg, &m) == nil && m.ID == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, res.buf.Bytes()); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	buf.WriteByte('\n')
+	return w.write(buf.Bytes())
+}
+
+// stdioWriter writes whole messages to stdio, so that notifications sent
+// while a request is handled are not interleaved with its response.
+type stdioWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// write writes the message.
+func (sw *stdioWriter) write(msg []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	_, err := sw.w.Write(msg)
+	return err
+}
+
+// stdioResponse is a response writer buffering an MCP response written to
+// stdio.
+type stdioResponse struct {
+	header http.Header
+	buf    bytes.Buffer
+}
+
+// Header satisfies the http.ResponseWriter interface.
+func (r *stdioResponse) Header() http.Header {
+	return r.header
+}
+
+// Write satisfies the io.Writer interface.
+func (r *stdioResponse) Write(p []byte) (int, error) {
+	return r.buf.Write(p)
+}
+
+// WriteHeader satisfies the http.ResponseWriter interface.
+func (r *stdioResponse) WriteHeader(int) {}
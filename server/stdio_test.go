@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeStdio(t *testing.T) {
+	s, err := New(&Config{Server: ServerConfig{EnableMCP: true, RequestTimeout: time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		``,
+		`{"jsonrpc":"2.0","id":"b","method":"tools/list","params":{}}`,
+		`not json`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 responses, got: %q", out.String())
+	}
+	for i, exp := range []string{`1`, `"b"`, `null`} {
+		var res struct {
+			ID    json.RawMessage `json:"id"`
+			Error *struct {
+				Code int `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &res); err != nil {
+			t.Fatalf("response %d: expected no error, got: %v", i, err)
+		}
+		if id := string(res.ID); id != exp && !(exp == "null" && id == "") {
+			t.Errorf("response %d: expected id %s, got: %s", i, exp, id)
+		}
+		if (res.Error != nil) != (i == 2) {
+			t.Errorf("response %d: unexpected error %v", i, res.Error)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// stmtCache is a connection's LRU cache of prepared statements, so repeated
+// parameterized queries reuse their statements rather than preparing them on
+// each execution. A nil cache prepares nothing.
+type stmtCache struct {
+	db  *sql.DB
+	max int
+
+	mu    sync.Mutex
+	stmts map[string]*list.Element
+	// lru holds the statements, most recently used first
+	lru    *list.List
+	hits   int64
+	misses int64
+}
+
+// cachedStmt is a cached prepared statement, closed once evicted and no
+// longer in use.
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+// StatementCacheStats describes the contents and hit rate of a connection's
+// prepared statement cache.
+type StatementCacheStats struct {
+	Statements int     `json:"statements"`
+	Capacity   int     `json:"capacity"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// newStmtCache creates a new cache of up to max prepared statements on the
+// database, or returns nil when max is not positive.
+func newStmtCache(db *sql.DB, max int) *stmtCache {
+	if max <= 0 {
+		return nil
+	}
+	return &stmtCache{
+		db:    db,
+		max:   max,
+		stmts: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// QueryContext executes the query on db, as a cached prepared statement
+// when it is a single statement with arguments run on the cache's database.
+func (c *stmtCache) QueryContext(ctx context.Context, db queryer, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, release := c.prepare(ctx, db, query, args)
+	if stmt == nil {
+		return db.QueryContext(ctx, query, args...)
+	}
+	defer release()
+	return stmt.QueryContext(ctx, args...)
+}
+
+// ExecContext executes the statement on db, as a cached prepared statement
+// when it is a single statement with arguments run on the cache's database.
+func (c *stmtCache) ExecContext(ctx context.Context, db queryer, query string, args ...interface{}) (sql.Result, error) {
+	stmt, release := c.prepare(ctx, db, query, args)
+	if stmt == nil {
+		return db.ExecContext(ctx, query, args...)
+	}
+	defer release()
+	return stmt.ExecContext(ctx, args...)
+}
+
+// prepare returns the cached prepared statement of the query, preparing it
+// when not cached, and a func releasing it once executed. Returns nil when
+// the query is not to be prepared, or cannot be.
+func (c *stmtCache) prepare(ctx context.Context, db queryer, query string, args []interface{}) (*sql.Stmt, func()) {
+	if c == nil || len(args) == 0 || db != queryer(c.db) || len(sqlscan.Split(query)) != 1 {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	elem, ok := c.stmts[query]
+	if ok {
+		c.hits++
+		c.lru.MoveToFront(elem)
+		cs := elem.Value.(*cachedStmt)
+		cs.refs++
+		c.mu.Unlock()
+		return cs.stmt, func() { c.release(cs) }
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		// queries the driver cannot prepare are executed directly
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.stmts[query]; ok {
+		// prepared concurrently
+		stmt.Close()
+		cs := elem.Value.(*cachedStmt)
+		cs.refs++
+		return cs.stmt, func() { c.release(cs) }
+	}
+	cs := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	c.stmts[query] = c.lru.PushFront(cs)
+	for c.lru.Len() > c.max {
+		c.evict(c.lru.Back())
+	}
+	return stmt, func() { c.release(cs) }
+}
+
+// release releases a use of the statement, closing it when it was evicted
+// and is no longer in use.
+func (c *stmtCache) release(cs *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cs.refs--; cs.evicted && cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+// evict removes the statement, closing it when not in use. The lock must be
+// held.
+func (c *stmtCache) evict(elem *list.Element) {
+	cs := c.lru.Remove(elem).(*cachedStmt)
+	delete(c.stmts, cs.query)
+	if cs.evicted = true; cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+// Reset evicts all statements, such as when a schema change could
+// invalidate them.
+func (c *stmtCache) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() != 0 {
+		c.evict(c.lru.Front())
+	}
+}
+
+// Stats returns the cache's statistics.
+func (c *stmtCache) Stats() StatementCacheStats {
+	if c == nil {
+		return StatementCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := StatementCacheStats{
+		Statements: c.lru.Len(),
+		Capacity:   c.max,
+		Hits:       c.hits,
+		Misses:     c.misses,
+	}
+	if n := c.hits + c.misses; n != 0 {
+		stats.HitRate = float64(c.hits) / float64(n)
+	}
+	return stats
+}
+
+// StatementCacheStats returns the statistics of the prepared statement
+// caches of the connection and its replicas.
+func (conn *Connection) StatementCacheStats() StatementCacheStats {
+	var stats StatementCacheStats
+	if !conn.pending.Load() {
+		stats = conn.stmts.Stats()
+	}
+	for _, replica := range conn.replicaList() {
+		s := replica.stmts.Stats()
+		stats.Statements += s.Statements
+		stats.Capacity += s.Capacity
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+	}
+	if n := stats.Hits + stats.Misses; n != 0 {
+		stats.HitRate = float64(stats.Hits) / float64(n)
+	}
+	return stats
+}
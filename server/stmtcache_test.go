@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestStmtCache(t *testing.T) {
+	pc := new(prepareConnector)
+	db := sql.OpenDB(pc)
+	defer db.Close()
+	c := newStmtCache(db, 2)
+
+	ctx := context.Background()
+	for _, query := range []string{"SELECT ?", "SELECT ?", "SELECT 1", "SELECT ? + 1", "SELECT ?", "SELECT ? + 2", "SELECT ?; SELECT ?"} {
+		var args []interface{}
+		if query != "SELECT 1" {
+			args = []interface{}{int64(1)}
+		}
+		rows, err := c.QueryContext(ctx, db, query, args...)
+		if err != nil {
+			t.Fatalf("%s expected no error, got: %v", query, err)
+		}
+		rows.Close()
+	}
+	// SELECT ? is prepared, reused, kept as the most recently used when
+	// SELECT ? + 2 evicts SELECT ? + 1, and batches are not prepared
+	if exp := []string{"SELECT ?", "SELECT ? + 1", "SELECT ? + 2"}; !slices.Equal(pc.prepared(), exp) {
+		t.Errorf("expected prepared %v, got: %v", exp, pc.prepared())
+	}
+	if exp := 1; pc.closed() != exp {
+		t.Errorf("expected %d closed statement, got: %d", exp, pc.closed())
+	}
+	if stats := c.Stats(); stats.Statements != 2 || stats.Hits != 2 || stats.Misses != 3 || stats.HitRate != 0.4 {
+		t.Errorf("expected 2 statements with 2 hits and 3 misses, got: %+v", stats)
+	}
+
+	c.Reset()
+	if stats := c.Stats(); stats.Statements != 0 || pc.closed() != 3 {
+		t.Errorf("expected all statements to be closed, got: %+v %d", stats, pc.closed())
+	}
+	if newStmtCache(db, 0) != nil {
+		t.Errorf("expected a nil cache")
+	}
+}
+
+// prepareConnector is a driver connector recording the statements prepared
+// and closed, whose queries return a single row.
+type prepareConnector struct {
+	mu    sync.Mutex
+	stmts []string
+	n     int
+}
+
+func (pc *prepareConnector) Connect(context.Context) (driver.Conn, error) {
+	return prepareConn{pc}, nil
+}
+func (pc *prepareConnector) Driver() driver.Driver { return nil }
+
+func (pc *prepareConnector) prepared() []string {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return append([]string(nil), pc.stmts...)
+}
+
+func (pc *prepareConnector) closed() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.n
+}
+
+type prepareConn struct{ pc *prepareConnector }
+
+func (c prepareConn) Prepare(query string) (driver.Stmt, error) {
+	c.pc.mu.Lock()
+	defer c.pc.mu.Unlock()
+	c.pc.stmts = append(c.pc.stmts, query)
+	return prepareStmt{c.pc}, nil
+}
+func (prepareConn) Close() error              { return nil }
+func (prepareConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+func (prepareConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &prepareRows{}, nil
+}
+
+type prepareStmt struct{ pc *prepareConnector }
+
+func (s prepareStmt) Close() error {
+	s.pc.mu.Lock()
+	defer s.pc.mu.Unlock()
+	s.pc.n++
+	return nil
+}
+func (prepareStmt) NumInput() int                              { return -1 }
+func (prepareStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (prepareStmt) Query([]driver.Value) (driver.Rows, error)  { return &prepareRows{}, nil }
+
+type prepareRows struct{ done bool }
+
+func (*prepareRows) Columns() []string { return []string{"a"} }
+func (*prepareRows) Close() error      { return nil }
+
+func (r *prepareRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	dest[0], r.done = int64(1), true
+	return nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xo/usql/server/logstore"
+)
+
+// openStore opens the named tiered record store, with the storage
+// configuration, closing it on shutdown.
+func (s *Server) openStore(name string) (*logstore.Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.stores[name]; ok {
+		return store, nil
+	}
+	store, err := logstore.Open(name, s.config().Storage)
+	if err != nil {
+		return nil, err
+	}
+	s.stores[name] = store
+	return store, nil
+}
+
+// store returns the named record store.
+func (s *Server) store(name string) (*logstore.Store, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, ok := s.stores[name]
+	return store, ok
+}
+
+// handleStorage handles listing the record stores and their tier sizes.
+func (s *Server) handleStorage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	stores := make([]*logstore.Store, 0, len(s.stores))
+	for _, store := range s.stores {
+		stores = append(stores, store)
+	}
+	s.mu.Unlock()
+
+	res := make(map[string]logstore.Stats, len(stores))
+	for _, store := range stores {
+		stats, err := store.Stats()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("%s: %w", store.Name(), err))
+			return
+		}
+		res[store.Name()] = stats
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleStorageRecords handles listing a record store's latest records,
+// optionally since a time and up to a limit.
+func (s *Server) handleStorageRecords(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.store(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("store %s not found", r.PathValue("name")))
+		return
+	}
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+			return
+		}
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+	}
+	records, err := store.Records(since, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// recordFailover records a connection failing over in the events store.
+func (s *Server) recordFailover(event FailoverEvent) {
+	store, err := s.openStore("events")
+	if err == nil {
+		err = store.Append(event)
+	}
+	if err != nil {
+		storageLog.Error("failed to record failover", "connection", event.ConnectionID, "error", err)
+	}
+}
+
+// purgeRequest is the admin API request to purge a record store.
+type purgeRequest struct {
+	Before time.Time `json:"before"`
+}
+
+// handleStoragePurge handles deleting a record store's records before a
+// time, or all of its records when no time is given.
+func (s *Server) handleStoragePurge(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.store(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("store %s not found", r.PathValue("name")))
+		return
+	}
+	var req purgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	n, err := store.Purge(req.Before)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+}
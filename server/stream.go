@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xo/usql/server/arrowipc"
+	"github.com/xo/usql/server/policy"
+)
+
+// streamFlushRows is the number of rows written between flushes of a
+// streamed response.
+const streamFlushRows = 100
+
+// arrowBatchRows is the number of rows per record batch of a streamed Arrow
+// response.
+const arrowBatchRows = 4096
+
+// streamHeader is the NDJSON line starting a result set in a streamed
+// response.
+type streamHeader struct {
+	ResultSet   int         `json:"result_set"`
+	Columns     []string    `json:"columns"`
+	ColumnTypes []string    `json:"column_types"`
+	JSONTypes   []string    `json:"json_types,omitempty"`
+	Provenance  *Provenance `json:"provenance,omitempty"`
+}
+
+// streamTrailer is the NDJSON line ending a streamed response.
+type streamTrailer struct {
+	Done     bool   `json:"done"`
+	RowCount int    `json:"row_count"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleQueryStream handles running a query and streaming its rows as
+// newline delimited JSON, or as Arrow IPC streams when the arrow format is
+// requested (in the request, or the Accept header).
+//
+// Each result set starts with a header line of its columns (with the
+// result's provenance in the first), followed by a line per row (a JSON
+// array of values). The response ends with a trailer line with the row
+// count, and the error if the query failed while the rows were being
+// streamed. Queries of requests with the ID of a session in the
+// Mcp-Session-Id header run in the session's transaction, if any.
+func (s *Server) handleQueryStream(w http.ResponseWriter, r *http.Request) {
+	id := pathConnection(r)
+	if !allowConnection(w, r, id) || !allowTool(w, r, "execute_query", policy.ToolQuery) {
+		return
+	}
+	c, err := s.pool.GetConnection(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var req queryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query is required"))
+		return
+	}
+	if req.Format == "" && r.Header.Get("Accept") == arrowipc.ContentType {
+		req.Format = "arrow"
+	}
+	if req.Format != "" && req.Format != "ndjson" && req.Format != "arrow" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported stream format %q", req.Format))
+		return
+	}
+	args, err := req.arguments()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	times, err := s.times.With(req.TimeFormat, req.TimeZone)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	nullFormat, err := s.nulls.With(req.Nulls, req.NullSentinel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	objects := req.RowFormat == "object"
+	if req.RowFormat != "" && req.RowFormat != "array" && !objects {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported row format %q", req.RowFormat))
+		return
+	}
+
+	ctx, err := s.sessionContext(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	it, err := c.QueryRows(req.context(ctx), req.Query, args...)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	defer it.Close()
+
+	if req.Format == "arrow" {
+		writeArrowStream(w, it)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	trailer := streamTrailer{}
+	for set := 1; ; set++ {
+		header := streamHeader{ResultSet: set, Columns: it.Columns, ColumnTypes: it.ColumnTypes, JSONTypes: it.JSONTypes}
+		if set == 1 {
+			header.Provenance = it.Provenance
+		}
+		if err := enc.Encode(header); err != nil {
+			serverLog.Error("stream error", "error", err)
+			return
+		}
+		rc.Flush()
+		for it.Next() {
+			row := times.Row(it.Row())
+			var v interface{} = nullFormat.Row(row)
+			if objects {
+				v = nullFormat.Object(it.Columns, row)
+			}
+			if err := enc.Encode(v); err != nil {
+				// the client went away
+				return
+			}
+			if trailer.RowCount++; trailer.RowCount%streamFlushRows == 0 {
+				rc.Flush()
+			}
+		}
+		if !it.NextResultSet() {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		trailer.Error = err.Error()
+	}
+	trailer.Done = trailer.Error == ""
+	enc.Encode(trailer)
+	rc.Flush()
+}
+
+// writeArrowStream writes the rows of each result set as an Arrow IPC
+// stream, one after another, in record batches of arrowBatchRows rows.
+//
+// Arrow streams have no way to report errors, so the response is aborted if
+// the query fails while the rows are being streamed.
+func writeArrowStream(w http.ResponseWriter, it *RowIterator) {
+	w.Header().Set("Content-Type", arrowipc.ContentType)
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	for {
+		aw := arrowipc.NewWriter(w, it.Columns, it.ColumnTypes)
+		batch := make([][]interface{}, 0, arrowBatchRows)
+		for {
+			more := it.Next()
+			if more {
+				batch = append(batch, it.Row())
+			}
+			if len(batch) == arrowBatchRows || (!more && len(batch) != 0) {
+				if err := aw.WriteBatch(batch); err != nil {
+					serverLog.Error("stream error", "error", err)
+					panic(http.ErrAbortHandler)
+				}
+				rc.Flush()
+				batch = batch[:0]
+			}
+			if !more {
+				break
+			}
+		}
+		if err := it.Err(); err != nil {
+			serverLog.Error("stream error", "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		if err := aw.Close(); err != nil {
+			return
+		}
+		if !it.NextResultSet() {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		serverLog.Error("stream error", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	rc.Flush()
+}
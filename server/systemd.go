@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// systemdListeners returns the listeners systemd passed the process on
+// socket activation, by name, or nil when it was not socket activated.
+// Sockets are matched to the server's listeners with the names by their
+// FileDescriptorName, those named otherwise being taken for the HTTP
+// listener. The environment variables of the activation are unset, so they
+// are not passed on.
+func systemdListeners(listenerNames []string) (map[string]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	fdnames := os.Getenv("LISTEN_FDNAMES")
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	listeners := make(map[string]net.Listener, n)
+	for i, name := range activationNames(n, fdnames, listenerNames) {
+		f := os.NewFile(uintptr(3+i), name)
+		if name == "" {
+			serverLog.Warn("closing socket passed by systemd matching no listener", "fd", 3+i)
+			f.Close()
+			continue
+		}
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("failed to inherit %s socket from systemd: %w", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// activationNames returns the names of the listeners with the listener
+// names the n sockets passed by systemd with the names in fdnames are for,
+// empty for sockets not used: the first socket named after none of the
+// listeners is the HTTP listener's, unless a socket is named http. Sockets
+// left are not used.
+func activationNames(n int, fdnames string, listenerNames []string) []string {
+	names := make([]string, n)
+	var given []string
+	if fdnames != "" {
+		given = strings.Split(fdnames, ":")
+	}
+	taken := make(map[string]bool)
+	for i := range names {
+		if i < len(given) && slices.Contains(listenerNames, given[i]) && !taken[given[i]] {
+			names[i], taken[given[i]] = given[i], true
+		}
+	}
+	for i := range names {
+		if names[i] == "" && !taken["http"] && (i >= len(given) || !slices.Contains(listenerNames, given[i])) {
+			names[i], taken["http"] = "http", true
+		}
+	}
+	return names
+}
+
+// notify sends the state to the service manager, when the process is run by
+// systemd as a notify service, as with sd_notify.
+func notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestActivationNames(t *testing.T) {
+	for _, test := range []struct {
+		n       int
+		fdnames string
+		exp     []string
+	}{
+		{1, "", []string{"http"}},
+		{1, "usqlr.socket", []string{"http"}},
+		{2, "admin:usqlr.socket", []string{"admin", "http"}},
+		{3, "web:http:admin", []string{"", "http", "admin"}},
+		{2, "acme:acme", []string{"acme", ""}},
+		{2, "", []string{"http", ""}},
+		{2, "local:web", []string{"local", "http"}},
+	} {
+		if names := activationNames(test.n, test.fdnames, []string{"http", "admin", "acme", "local"}); !slices.Equal(names, test.exp) {
+			t.Errorf("%d %q: expected %q, got: %q", test.n, test.fdnames, test.exp, names)
+		}
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	// sockets passed to another process are not inherited
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := systemdListeners([]string{"http"})
+	if err != nil || listeners != nil {
+		t.Errorf("expected no listeners, got: %v %v", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("expected the activation variables unset")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	if err := notify("READY=1"); err != nil {
+		t.Errorf("expected no error without a notify socket, got: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err := notify("READY=1"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("expected READY=1, got: %q %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+)
+
+// SetTags replaces the key/value tags of the connection with the ID (or
+// alias), such as env=prod or team=payments, by which connections are
+// filtered when listed.
+func (cp *ConnectionPool) SetTags(id string, tags map[string]string) error {
+	for k := range tags {
+		if k == "" {
+			return fmt.Errorf("tag key is empty")
+		}
+	}
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("connection with ID %s not found", id)
+	}
+
+	conn.mu.Lock()
+	conn.tags = maps.Clone(tags)
+	conn.mu.Unlock()
+
+	cp.redefined()
+	return nil
+}
+
+// Tags returns the tags of the connection with the ID (or alias), or nil
+// when there is no such connection.
+func (cp *ConnectionPool) Tags(id string) map[string]string {
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.tags
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"database/sql"
+	"maps"
+	"testing"
+
+	"github.com/xo/dburl"
+)
+
+func TestSetTags(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	cp.connections["a"] = &Connection{ID: "a", URL: u, DB: sql.OpenDB(multiConnector{})}
+
+	tags := map[string]string{"env": "prod", "team": "payments"}
+	if err := cp.SetTags("a", tags); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the tags are copied
+	tags["env"] = "dev"
+	if info := cp.ListConnections()["a"]; !maps.Equal(info.Tags, map[string]string{"env": "prod", "team": "payments"}) {
+		t.Errorf("expected the tags, got: %v", info.Tags)
+	}
+	if defs := cp.Definitions(); len(defs) != 1 || defs[0].Tags["env"] != "prod" {
+		t.Errorf("expected the tags in the definition, got: %+v", defs)
+	}
+	if err := cp.SetTags("a", map[string]string{"": "x"}); err == nil {
+		t.Errorf("expected an error for an empty key")
+	}
+	if err := cp.SetTags("b", tags); err == nil {
+		t.Errorf("expected an error for a missing connection")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/xo/dburl"
+)
+
+// Throttle limits the number of queries executing concurrently, both across
+// the whole server and per upstream database host. Multiple connections
+// pointing at the same host share the host's limit, as do the servers of a
+// cluster sharing a Redis store.
+type Throttle struct {
+	global  chan struct{}
+	perHost int
+	limits  map[string]int
+	cluster *redisStore
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// NewThrottle creates a new throttle. A limit of 0 means unlimited.
+func NewThrottle(config ServerConfig) *Throttle {
+	return newThrottle(config, nil)
+}
+
+// newThrottle creates a new throttle, sharing the per-host limits with the
+// servers using the Redis store, if not nil.
+func newThrottle(config ServerConfig, cluster *redisStore) *Throttle {
+	t := &Throttle{
+		perHost: config.MaxConcurrentPerHost,
+		limits:  make(map[string]int, len(config.HostLimits)),
+		cluster: cluster,
+		hosts:   make(map[string]chan struct{}),
+	}
+	if config.MaxConcurrentQueries > 0 {
+		t.global = make(chan struct{}, config.MaxConcurrentQueries)
+	}
+	for host, limit := range config.HostLimits {
+		t.limits[strings.ToLower(host)] = limit
+	}
+	return t
+}
+
+// Acquire waits for a query slot for the host, returning a func to release
+// the slot.
+func (t *Throttle) Acquire(ctx context.Context, host string) (func(), error) {
+	hostSem, limit := t.host(host)
+	if err := acquire(ctx, t.global); err != nil {
+		return nil, fmt.Errorf("waiting for query slot: %w", err)
+	}
+	if err := acquire(ctx, hostSem); err != nil {
+		release(t.global)
+		return nil, fmt.Errorf("waiting for query slot on %s: %w", host, err)
+	}
+	leased, err := t.cluster.lease(ctx, "throttle:host:"+host, limit)
+	if err != nil {
+		release(hostSem)
+		release(t.global)
+		return nil, fmt.Errorf("waiting for query slot on %s: %w", host, err)
+	}
+	return func() {
+		leased()
+		release(hostSem)
+		release(t.global)
+	}, nil
+}
+
+// host returns the semaphore for the host and its limit, or nil if it is
+// unlimited.
+func (t *Throttle) host(host string) (chan struct{}, int) {
+	limit, ok := t.limits[host]
+	if !ok {
+		limit = t.perHost
+	}
+	if limit <= 0 {
+		return nil, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sem, ok := t.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		t.hosts[host] = sem
+	}
+	return sem, limit
+}
+
+// acquire acquires a slot on the semaphore, waiting until ctx is done. A nil
+// semaphore is unlimited.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	if sem == nil {
+		return nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases a slot on the semaphore.
+func release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// hostKey returns the key identifying the physical database a URL points
+// to, used to share limits between connections to the same host.
+func hostKey(u *dburl.URL) string {
+	if u.Host != "" {
+		return strings.ToLower(u.Host)
+	}
+	// File based databases (SQLite, DuckDB, ...) have no host
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Path
+}
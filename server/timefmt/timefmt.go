@@ -0,0 +1,109 @@
+// Package timefmt formats the time values of query results in a time zone
+// and representation chosen by the operator or client, since drivers return
+// times in inconsistent zones.
+package timefmt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Time value representations.
+const (
+	// RFC3339 represents times as RFC 3339 strings, in the format's time
+	// zone.
+	RFC3339 = "rfc3339"
+	// EpochMillis represents times as the number of milliseconds since the
+	// Unix epoch.
+	EpochMillis = "epoch_millis"
+	// Native represents times as RFC 3339 strings, in the time zone the
+	// driver returned them in.
+	Native = "native"
+)
+
+// Format is a representation of time values. A nil Format leaves values as
+// they are.
+type Format struct {
+	Name     string
+	Location *time.Location
+}
+
+// Parse returns the format with the representation name and the IANA time
+// zone (e.g. UTC, Europe/Berlin, or Local), defaulting to RFC 3339 in UTC.
+func Parse(name, zone string) (*Format, error) {
+	f := &Format{Name: strings.ToLower(name), Location: time.UTC}
+	switch f.Name {
+	case "":
+		f.Name = RFC3339
+	case RFC3339, EpochMillis, Native:
+	default:
+		return nil, fmt.Errorf("invalid time format %q: expected %s, %s or %s", name, RFC3339, EpochMillis, Native)
+	}
+	if zone != "" {
+		var err error
+		if f.Location, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", zone, err)
+		}
+	}
+	return f, nil
+}
+
+// With returns the format overridden with the representation name and time
+// zone, when not empty.
+func (f *Format) With(name, zone string) (*Format, error) {
+	switch {
+	case f == nil && name == "" && zone == "":
+		return nil, nil
+	case f == nil:
+		return Parse(name, zone)
+	}
+	g := *f
+	if name != "" {
+		g.Name = name
+	}
+	loc := g.Location.String()
+	if zone != "" {
+		loc = zone
+	}
+	return Parse(g.Name, loc)
+}
+
+// Value returns the representation of v when it is a time, or v.
+func (f *Format) Value(v interface{}) interface{} {
+	t, ok := v.(time.Time)
+	if !ok || f == nil {
+		return v
+	}
+	switch f.Name {
+	case EpochMillis:
+		return t.UnixMilli()
+	case Native:
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.In(f.Location).Format(time.RFC3339Nano)
+}
+
+// Row returns a copy of the row with its time values formatted.
+func (f *Format) Row(row []interface{}) []interface{} {
+	if f == nil {
+		return row
+	}
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		values[i] = f.Value(v)
+	}
+	return values
+}
+
+// Rows returns a copy of the rows with their time values formatted.
+func (f *Format) Rows(rows [][]interface{}) [][]interface{} {
+	if f == nil {
+		return rows
+	}
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = f.Row(row)
+	}
+	return values
+}
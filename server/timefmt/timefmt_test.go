@@ -0,0 +1,68 @@
+package timefmt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6000000, berlin)
+	tests := []struct {
+		name, zone string
+		exp        interface{}
+	}{
+		{"", "", "2024-01-02T02:04:05.006Z"},
+		{"rfc3339", "Asia/Tokyo", "2024-01-02T11:04:05.006+09:00"},
+		{"EPOCH_MILLIS", "", ts.UnixMilli()},
+		{"native", "UTC", "2024-01-02T03:04:05.006+01:00"},
+	}
+	for _, test := range tests {
+		f, err := Parse(test.name, test.zone)
+		if err != nil {
+			t.Fatalf("%s/%s expected no error, got: %v", test.name, test.zone, err)
+		}
+		if v := f.Value(ts); v != test.exp {
+			t.Errorf("%s/%s expected %v, got: %v", test.name, test.zone, test.exp, v)
+		}
+	}
+	for _, test := range [][2]string{{"iso", ""}, {"", "Mars/Olympus"}} {
+		if _, err := Parse(test[0], test[1]); err == nil {
+			t.Errorf("%q/%q expected error", test[0], test[1])
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	var f *Format
+	if g, err := f.With("", ""); err != nil || g != nil {
+		t.Errorf("expected a nil format, got: %v %v", g, err)
+	}
+	f, _ = Parse(EpochMillis, "Asia/Tokyo")
+	g, err := f.With(RFC3339, "")
+	switch {
+	case err != nil:
+		t.Fatalf("expected no error, got: %v", err)
+	case g.Name != RFC3339 || g.Location.String() != "Asia/Tokyo":
+		t.Errorf("expected rfc3339 in Asia/Tokyo, got: %s %s", g.Name, g.Location)
+	case f.Name != EpochMillis:
+		t.Errorf("expected the format to be unchanged, got: %s", f.Name)
+	}
+}
+
+func TestRows(t *testing.T) {
+	f, _ := Parse(EpochMillis, "")
+	ts := time.UnixMilli(1700000000000)
+	rows := [][]interface{}{{int64(1), ts, nil}}
+	formatted := f.Rows(rows)
+	if exp := [][]interface{}{{int64(1), int64(1700000000000), nil}}; !reflect.DeepEqual(formatted, exp) {
+		t.Errorf("expected %v, got: %v", exp, formatted)
+	}
+	if rows[0][1] != ts {
+		t.Errorf("expected the rows to be unchanged, got: %v", rows[0][1])
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/xo/usql/server/sqlscan"
+)
+
+// queryer executes queries, on a database or a single connection to it.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// deadlineQuery is a query prepared to be stopped by the database at a
+// deadline, with the handle to execute it on.
+type deadlineQuery struct {
+	db    queryer
+	query string
+
+	// rewrite describes the rewrite applied to the query, if any.
+	rewrite string
+
+	// release releases the handle, and must be called once the query's rows
+	// are read.
+	release func()
+}
+
+// withDeadline prepares the query to be executed so that the database itself
+// stops executing it at the context's deadline, rather than continuing after
+// the client has given up.
+//
+// PostgreSQL (and compatible) queries are executed on a connection with its
+// statement_timeout set, reset once released. MySQL SELECT queries are given
+// a MAX_EXECUTION_TIME hint, and MariaDB statements a max_statement_time.
+// Other databases rely on the driver cancelling the query.
+//
+// Queries of a transaction held open by the session ctx is bound to are
+// executed in the transaction, relying on the driver cancelling them.
+func (conn *Connection) withDeadline(ctx context.Context, query string) (*deadlineQuery, error) {
+	if stx := conn.transaction(ctx); stx != nil {
+		return stx.inTransaction(query), nil
+	}
+	dq := &deadlineQuery{db: conn.DB, query: query, release: func() {}}
+	deadline, ok := ctx.Deadline()
+	if !ok || !conn.timeouts {
+		return dq, nil
+	}
+	ms := int64(math.Ceil(float64(time.Until(deadline)) / float64(time.Millisecond)))
+	if ms < 1 {
+		ms = 1
+	}
+
+	switch conn.URL.Driver {
+	case "postgres", "pgx":
+		c, err := conn.DB.Conn(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get connection: %w", err)
+		}
+		if _, err := c.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d", ms)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to set statement timeout: %w", err)
+		}
+		dq.db, dq.release = c, func() {
+			if _, err := c.ExecContext(context.Background(), "RESET statement_timeout"); err != nil {
+				// discard the connection rather than reuse it with the timeout
+				c.Raw(func(interface{}) error { return driver.ErrBadConn })
+			}
+			c.Close()
+		}
+	case "mysql":
+		if strings.Contains(conn.serverVersion, "MariaDB") {
+			dq.query = fmt.Sprintf("SET STATEMENT max_statement_time=%.3f FOR %s", float64(ms)/1000, query)
+			dq.rewrite = "max_statement_time set to the request deadline"
+		} else if words := sqlscan.Words(query); len(words) != 0 && words[0].Is("SELECT") {
+			i := words[0].Pos + len(words[0].Text)
+			dq.query = fmt.Sprintf("%s /*+ MAX_EXECUTION_TIME(%d) */%s", query[:i], ms, query[i:])
+			dq.rewrite = "MAX_EXECUTION_TIME hint set to the request deadline"
+		}
+	}
+	return dq, nil
+}
+
+// stmts returns the prepared statement cache to execute the query with, nil
+// when the query was rewritten with its deadline, as it then differs on each
+// execution.
+func (dq *deadlineQuery) stmts(conn *Connection) *stmtCache {
+	if dq.rewrite != "" {
+		return nil
+	}
+	return conn.stmts
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+func TestWithDeadline(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		version string
+		query   string
+		exp     []string
+	}{
+		{"postgres://localhost/db", "", "SELECT 1", []string{"SET statement_timeout = N", "SELECT 1", "RESET statement_timeout"}},
+		{"mysql://localhost/db", "8.0.36", " select 1", []string{" select /*+ MAX_EXECUTION_TIME(N) */ 1"}},
+		{"mysql://localhost/db", "8.0.36", "UPDATE t SET a = 1", []string{"UPDATE t SET a = 1"}},
+		{"mysql://localhost/db", "10.11.2-MariaDB", "UPDATE t SET a = 1", []string{"SET STATEMENT max_statement_time=N FOR UPDATE t SET a = 1"}},
+		{"sqlserver://localhost/db", "", "SELECT 1", []string{"SELECT 1"}},
+	}
+	for i, test := range tests {
+		u, _ := dburl.Parse(test.dsn)
+		rec := new(recordingConnector)
+		conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(rec), serverVersion: test.version, timeouts: true}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		dq, err := conn.withDeadline(ctx, test.query)
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		if _, err := dq.db.ExecContext(ctx, dq.query); err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		dq.release()
+		cancel()
+		conn.DB.Close()
+
+		if queries := rec.statements(); !reflect.DeepEqual(queries, test.exp) {
+			t.Errorf("test %d: expected %q, got: %q", i, test.exp, queries)
+		}
+	}
+
+	// without a deadline, queries are unchanged
+	u, _ := dburl.Parse("postgres://localhost/db")
+	rec := new(recordingConnector)
+	conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(rec), timeouts: true}
+	defer conn.DB.Close()
+	dq, err := conn.withDeadline(context.Background(), "SELECT 1")
+	if err != nil || dq.db != queryer(conn.DB) || dq.query != "SELECT 1" {
+		t.Errorf("expected the query to be unchanged without a deadline, got: %q (%v)", dq.query, err)
+	}
+}
+
+func TestDeadlineRows(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	rec := new(recordingConnector)
+	conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(rec), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{}), timeouts: true}
+	defer conn.DB.Close()
+	cm := NewCursorManager(time.Minute, 0)
+	defer cm.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// the statement timeout is reset once the rows are closed
+	it, err := conn.QueryRows(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	exp := []string{"SET statement_timeout = N", "SELECT 1"}
+	if queries := rec.statements(); !reflect.DeepEqual(queries, exp) {
+		t.Errorf("expected %q, got: %q", exp, queries)
+	}
+	it.Close()
+	exp = append(exp, "RESET statement_timeout")
+	if queries := rec.statements(); !reflect.DeepEqual(queries, exp) {
+		t.Errorf("expected %q once the iterator is closed, got: %q", exp, queries)
+	}
+
+	cursor, err := cm.Open(ctx, conn, "SELECT 1")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	exp = append(exp, "SET statement_timeout = N", "SELECT 1")
+	if queries := rec.statements(); !reflect.DeepEqual(queries, exp) {
+		t.Errorf("expected %q, got: %q", exp, queries)
+	}
+	cm.Close(cursor.ID)
+	exp = append(exp, "RESET statement_timeout")
+	if queries := rec.statements(); !reflect.DeepEqual(queries, exp) {
+		t.Errorf("expected %q once the cursor is closed, got: %q", exp, queries)
+	}
+}
+
+// recordingConnector is a driver connector recording the statements
+// executed.
+type recordingConnector struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+// statements returns the statements executed, with their numbers other than
+// 1 replaced by N.
+func (c *recordingConnector) statements() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := regexp.MustCompile(`\d+(\.\d+)?`)
+	var queries []string
+	for _, q := range c.queries {
+		queries = append(queries, n.ReplaceAllStringFunc(q, func(s string) string {
+			if s == "1" {
+				return s
+			}
+			return "N"
+		}))
+	}
+	return queries
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return recordingConn{c}, nil
+}
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+type recordingConn struct{ c *recordingConnector }
+
+func (recordingConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (recordingConn) Close() error                        { return nil }
+func (recordingConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (rc recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	rc.c.mu.Lock()
+	defer rc.c.mu.Unlock()
+	rc.c.queries = append(rc.c.queries, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (rc recordingConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	rc.c.mu.Lock()
+	defer rc.c.mu.Unlock()
+	rc.c.queries = append(rc.c.queries, query)
+	return recordingRows{}, nil
+}
+
+// recordingRows are the empty rows of a recorded query.
+type recordingRows struct{}
+
+func (recordingRows) Columns() []string              { return []string{"a"} }
+func (recordingRows) Close() error                   { return nil }
+func (recordingRows) Next(dest []driver.Value) error { return io.EOF }
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Cipher policies of the HTTP listener.
+const (
+	// CipherPolicyDefault uses Go's default cipher suites.
+	CipherPolicyDefault = "default"
+	// CipherPolicyIntermediate restricts TLS 1.2 to ECDHE suites with AEAD
+	// ciphers.
+	CipherPolicyIntermediate = "intermediate"
+	// CipherPolicyModern only allows TLS 1.3.
+	CipherPolicyModern = "modern"
+)
+
+// Client authentication modes of the HTTP listener, when a client CA is
+// set.
+const (
+	// ClientAuthOptional verifies the client certificates presented.
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire requires clients to present a verified certificate.
+	ClientAuthRequire = "require"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, on handshakes.
+const certCheckInterval = 10 * time.Second
+
+// intermediateCiphers are the TLS 1.2 cipher suites of the intermediate
+// policy.
+var intermediateCiphers = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// ServerTLS contains the TLS configuration of the HTTP listener, served
+// over TLS when Cert and Key are set, or ACME is enabled. MinVersion is 1.2 (the default) or
+// 1.3. CipherSuites, when set, are the names of the TLS 1.2 cipher suites
+// allowed, overriding the cipher policy. Clients presenting certificates
+// are verified against ClientCA when set, and must present one when
+// ClientAuth is require.
+type ServerTLS struct {
+	Cert         string   `mapstructure:"cert" yaml:"cert" json:"cert"`
+	Key          string   `mapstructure:"key" yaml:"key" json:"key"`
+	MinVersion   string   `mapstructure:"min_version" yaml:"min_version" json:"min_version"`
+	CipherPolicy string   `mapstructure:"cipher_policy" yaml:"cipher_policy" json:"cipher_policy"`
+	CipherSuites []string `mapstructure:"cipher_suites" yaml:"cipher_suites" json:"cipher_suites"`
+	ClientCA     string   `mapstructure:"client_ca" yaml:"client_ca" json:"client_ca"`
+	ClientAuth   string   `mapstructure:"client_auth" yaml:"client_auth" json:"client_auth"`
+
+	ACME ACMEConfig `mapstructure:"acme" yaml:"acme" json:"acme"`
+}
+
+// ACMEConfig contains the configuration of certificates obtained from an
+// ACME CA (Let's Encrypt by default, or the CA at DirectoryURL) for the
+// Domains, cached in CacheDir, and renewed before they expire. The CA
+// validates domains on the HTTP listener when it serves port 443, or on an
+// HTTP listener at HTTPAddress (":80"). The static certificate, when set,
+// is served when no certificate could be obtained.
+type ACMEConfig struct {
+	Enabled      bool     `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	Domains      []string `mapstructure:"domains" yaml:"domains" json:"domains"`
+	CacheDir     string   `mapstructure:"cache_dir" yaml:"cache_dir" json:"cache_dir"`
+	Email        string   `mapstructure:"email" yaml:"email" json:"email"`
+	DirectoryURL string   `mapstructure:"directory_url" yaml:"directory_url" json:"directory_url"`
+	HTTPAddress  string   `mapstructure:"http_address" yaml:"http_address" json:"http_address"`
+}
+
+// enabled reports whether the listener is served over TLS.
+func (c ServerTLS) enabled() bool {
+	return c.Cert != "" || c.Key != "" || c.ACME.Enabled
+}
+
+// manager returns the manager of the ACME certificates.
+func (c ACMEConfig) manager() (*autocert.Manager, error) {
+	switch {
+	case len(c.Domains) == 0:
+		return nil, errors.New("ACME requires domains")
+	case c.CacheDir == "":
+		return nil, errors.New("ACME requires a cache dir")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return m, nil
+}
+
+// tlsConfig returns the TLS config of the settings, without certificates,
+// validating them.
+func (c ServerTLS) tlsConfig() (*tls.Config, error) {
+	if (c.Cert == "") != (c.Key == "") {
+		return nil, errors.New("a TLS certificate requires its key, and a key its certificate")
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	switch c.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS min version %q: must be 1.2 or 1.3", c.MinVersion)
+	}
+	switch c.CipherPolicy {
+	case "", CipherPolicyDefault:
+	case CipherPolicyIntermediate:
+		config.CipherSuites = intermediateCiphers
+	case CipherPolicyModern:
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS cipher policy %q: must be %s, %s or %s", c.CipherPolicy, CipherPolicyDefault, CipherPolicyIntermediate, CipherPolicyModern)
+	}
+	if len(c.CipherSuites) != 0 {
+		config.CipherSuites = nil
+		for _, name := range c.CipherSuites {
+			i := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool {
+				return suite.Name == name
+			})
+			if i == -1 {
+				return nil, fmt.Errorf("invalid TLS cipher suite %q", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, tls.CipherSuites()[i].ID)
+		}
+	}
+	switch c.ClientAuth {
+	case "", ClientAuthOptional:
+		if c.ClientCA != "" {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	case ClientAuthRequire:
+		if c.ClientCA == "" {
+			return nil, errors.New("TLS client authentication requires a client CA")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid TLS client auth %q: must be %s or %s", c.ClientAuth, ClientAuthOptional, ClientAuthRequire)
+	}
+	if c.ClientCA != "" {
+		buf, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls client ca: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(buf) {
+			return nil, errors.New("no certificates found in the TLS client CA")
+		}
+	}
+	if c.ACME.Enabled {
+		if _, err := c.ACME.manager(); err != nil {
+			return nil, err
+		}
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	}
+	return config, nil
+}
+
+// certificates serves the HTTP listener's TLS config, its certificates
+// obtained with ACME or its static certificate, reloaded once its files
+// changed, and the whole config on reloads.
+type certificates struct {
+	mu      sync.Mutex
+	conf    ServerTLS
+	config  *tls.Config
+	acme    *autocert.Manager
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertificates loads the certificate and TLS config of the settings.
+func newCertificates(c ServerTLS) (*certificates, error) {
+	certs := new(certificates)
+	if err := certs.reload(c); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// reload loads the certificate and TLS config of the settings, keeping
+// those loaded on errors.
+func (certs *certificates) reload(c ServerTLS) error {
+	config, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	var modTime time.Time
+	if c.Cert != "" {
+		if cert, modTime, err = loadCertificate(c); err != nil {
+			return err
+		}
+	}
+	certs.mu.Lock()
+	defer certs.mu.Unlock()
+	// the manager is kept while its config is, as it holds the certificates
+	// obtained
+	m := certs.acme
+	if !c.ACME.Enabled {
+		m = nil
+	} else if m == nil || !reflect.DeepEqual(c.ACME, certs.conf.ACME) {
+		if m, err = c.ACME.manager(); err != nil {
+			return err
+		}
+	}
+	config.GetCertificate = certs.getCertificate
+	certs.conf, certs.config, certs.acme, certs.cert = c, config, m, cert
+	certs.modTime, certs.checked = modTime, time.Now()
+	return nil
+}
+
+// configForClient returns the TLS config of handshakes.
+func (certs *certificates) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	certs.mu.Lock()
+	defer certs.mu.Unlock()
+	return certs.config, nil
+}
+
+// getCertificate returns the certificate obtained with ACME, or the static
+// certificate when none could be.
+func (certs *certificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs.mu.Lock()
+	m := certs.acme
+	certs.mu.Unlock()
+	if m == nil {
+		return certs.staticCertificate(), nil
+	}
+	cert, err := m.GetCertificate(hello)
+	if err == nil {
+		return cert, nil
+	}
+	if static := certs.staticCertificate(); static != nil {
+		serverLog.Debug("serving the static TLS certificate", "server_name", hello.ServerName, "error", err)
+		return static, nil
+	}
+	return nil, err
+}
+
+// staticCertificate returns the static certificate, reloading it when its
+// files changed since last checked, and keeping the one loaded when the
+// files cannot be loaded, as they may be being replaced.
+func (certs *certificates) staticCertificate() *tls.Certificate {
+	certs.mu.Lock()
+	defer certs.mu.Unlock()
+	if certs.cert == nil || time.Since(certs.checked) < certCheckInterval {
+		return certs.cert
+	}
+	certs.checked = time.Now()
+	if modTime, err := certModTime(certs.conf); err != nil || !modTime.After(certs.modTime) {
+		return certs.cert
+	}
+	cert, modTime, err := loadCertificate(certs.conf)
+	if err != nil {
+		serverLog.Error("failed to reload TLS certificate", "cert", certs.conf.Cert, "error", err)
+		return certs.cert
+	}
+	serverLog.Info("reloaded TLS certificate", "cert", certs.conf.Cert)
+	certs.cert, certs.modTime = cert, modTime
+	return certs.cert
+}
+
+// challengeHandler returns the handler of the ACME HTTP challenges, when
+// served on an HTTP listener, and the listener's address.
+func (certs *certificates) challengeHandler() (http.Handler, string) {
+	certs.mu.Lock()
+	defer certs.mu.Unlock()
+	if certs.acme == nil || certs.conf.ACME.HTTPAddress == "" {
+		return nil, ""
+	}
+	return certs.acme.HTTPHandler(nil), certs.conf.ACME.HTTPAddress
+}
+
+// listener returns the listener serving TLS connections on ln.
+func (certs *certificates) listener(ln net.Listener) net.Listener {
+	return tls.NewListener(ln, &tls.Config{GetConfigForClient: certs.configForClient})
+}
+
+// loadCertificate loads the certificate and key of the settings, returning
+// the last time their files were modified.
+func loadCertificate(c ServerTLS) (*tls.Certificate, time.Time, error) {
+	modTime, err := certModTime(c)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid TLS certificate: %w", err)
+	}
+	return &cert, modTime, nil
+}
+
+// certModTime returns the last time the certificate or key files were
+// modified.
+func certModTime(c ServerTLS) (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{c.Cert, c.Key} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tls: %w", err)
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := testCert(t, nil, nil, "ca", nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf := ServerTLS{
+		Cert:       filepath.Join(dir, "cert.pem"),
+		Key:        filepath.Join(dir, "key.pem"),
+		ClientCA:   caFile,
+		ClientAuth: ClientAuthRequire,
+	}
+	writeTestCert(t, conf, ca, caKey, "first")
+	certs, err := newCertificates(conf)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = certs.listener(ln)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	client, clientKey := testCert(t, ca, caKey, "client", nil)
+	clientCert := tls.Certificate{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}
+	dial := func(config *tls.Config) (string, error) {
+		config.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", ln.Addr().String(), config)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		// with TLS 1.3, client certificates are refused once the client reads
+		if _, err := conn.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+	if cn, err := dial(&tls.Config{Certificates: []tls.Certificate{clientCert}}); err != nil || cn != "first" {
+		t.Errorf("expected the first certificate, got: %q %v", cn, err)
+	}
+	if _, err := dial(&tls.Config{}); err == nil {
+		t.Errorf("expected an error without a client certificate")
+	}
+
+	// the certificate is reloaded once its files changed
+	writeTestCert(t, conf, ca, caKey, "second")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(conf.Cert, future, future)
+	certs.checked = time.Time{}
+	if cn, err := dial(&tls.Config{Certificates: []tls.Certificate{clientCert}}); err != nil || cn != "second" {
+		t.Errorf("expected the second certificate, got: %q %v", cn, err)
+	}
+
+	// the config is replaced on reloads
+	conf.MinVersion, conf.ClientAuth = "1.3", ""
+	if err := certs.reload(conf); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := dial(&tls.Config{MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Errorf("expected an error with TLS 1.2")
+	}
+	if _, err := dial(&tls.Config{}); err != nil {
+		t.Errorf("expected no error without a client certificate, got: %v", err)
+	}
+
+	for _, c := range []ServerTLS{
+		{Cert: conf.Cert},
+		{Cert: conf.Cert, Key: conf.Key, MinVersion: "1.1"},
+		{Cert: conf.Cert, Key: conf.Key, CipherPolicy: "old"},
+		{Cert: conf.Cert, Key: conf.Key, CipherSuites: []string{"TLS_RSA_WITH_NULL"}},
+		{Cert: conf.Cert, Key: conf.Key, ClientAuth: ClientAuthRequire},
+		{Cert: conf.Cert, Key: caFile},
+	} {
+		if err := certs.reload(c); err == nil {
+			t.Errorf("expected an error with %+v", c)
+		}
+	}
+}
+
+// writeTestCert writes a certificate with the common name, signed by the
+// CA, and its key to the files of the settings.
+func writeTestCert(t *testing.T, c ServerTLS, ca *x509.Certificate, caKey *rsa.PrivateKey, cn string) {
+	t.Helper()
+	cert, key := testCert(t, ca, caKey, cn, nil)
+	if err := os.WriteFile(c.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.Key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACMEFallback(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := testCert(t, nil, nil, "ca", nil)
+	conf := ServerTLS{
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+		ACME: ACMEConfig{Enabled: true, Domains: []string{"usqlr.example.com"}, CacheDir: filepath.Join(dir, "acme"), HTTPAddress: ":80"},
+	}
+	writeTestCert(t, conf, ca, caKey, "static")
+	certs, err := newCertificates(conf)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if config, _ := certs.configForClient(nil); !slices.Contains(config.NextProtos, acme.ALPNProto) {
+		t.Errorf("expected the ACME TLS-ALPN protocol, got: %v", config.NextProtos)
+	}
+	if handler, addr := certs.challengeHandler(); handler == nil || addr != ":80" {
+		t.Errorf("expected the ACME HTTP challenge handler on :80, got: %v %q", handler, addr)
+	}
+
+	// the static certificate is served for hosts certificates cannot be
+	// obtained for
+	hello := &tls.ClientHelloInfo{ServerName: "other.example.com"}
+	cert, err := certs.getCertificate(hello)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil || leaf.Subject.CommonName != "static" {
+		t.Errorf("expected the static certificate, got: %v", err)
+	}
+	m := certs.acme
+	conf.Cert, conf.Key = "", ""
+	if err := certs.reload(conf); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if certs.acme != m {
+		t.Errorf("expected the ACME manager kept while its config is")
+	}
+	if _, err := certs.getCertificate(hello); err == nil {
+		t.Errorf("expected an error without a static certificate")
+	}
+
+	for _, c := range []ACMEConfig{
+		{Enabled: true, CacheDir: dir},
+		{Enabled: true, Domains: []string{"usqlr.example.com"}},
+	} {
+		if err := certs.reload(ServerTLS{ACME: c}); err == nil {
+			t.Errorf("expected an error with %+v", c)
+		}
+	}
+}
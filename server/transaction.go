@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionTx is a transaction a session holds open on a connection across
+// its requests. Statements of the transaction are executed one at a time.
+type sessionTx struct {
+	tx         *sql.Tx
+	conn       *Connection
+	opts       sql.TxOptions
+	started    time.Time
+	lastUsed   atomic.Int64
+	statements atomic.Int64
+
+	// release marks the connection as no longer in use by the transaction
+	release func()
+
+	mu sync.Mutex
+}
+
+// TransactionInfo describes a session's transaction.
+type TransactionInfo struct {
+	ConnectionID string    `json:"connection_id"`
+	Isolation    string    `json:"isolation,omitempty"`
+	ReadOnly     bool      `json:"read_only,omitempty"`
+	Started      time.Time `json:"started"`
+	LastUsed     time.Time `json:"last_used"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Statements   int64     `json:"statements"`
+
+	// Outcome is committed or rolled back, once the transaction ended.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Transaction outcomes.
+const (
+	TxCommitted  = "committed"
+	TxRolledBack = "rolled back"
+)
+
+// ParseIsolation parses an isolation level, as its name in any case with
+// words separated by spaces, underscores or dashes (e.g. repeatable_read).
+// An empty level is the driver's default.
+func ParseIsolation(level string) (sql.IsolationLevel, error) {
+	name := strings.NewReplacer("_", " ", "-", " ").Replace(strings.ToLower(level))
+	for l := sql.LevelDefault; l <= sql.LevelLinearizable; l++ {
+		if name == "" || name == strings.ToLower(l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid isolation level %q", level)
+}
+
+// info returns the description of the transaction, expiring after ttl.
+func (stx *sessionTx) info(ttl time.Duration) TransactionInfo {
+	lastUsed := time.Unix(0, stx.lastUsed.Load())
+	info := TransactionInfo{
+		ConnectionID: stx.conn.ID,
+		ReadOnly:     stx.opts.ReadOnly,
+		Started:      stx.started,
+		LastUsed:     lastUsed,
+		ExpiresAt:    lastUsed.Add(ttl),
+		Statements:   stx.statements.Load(),
+	}
+	if stx.opts.Isolation != sql.LevelDefault {
+		info.Isolation = stx.opts.Isolation.String()
+	}
+	return info
+}
+
+// end commits the transaction, once its running statement finished, or
+// rolls it back, interrupting its running statement.
+func (stx *sessionTx) end(commit bool) error {
+	defer stx.release()
+	if !commit {
+		return stx.tx.Rollback()
+	}
+	stx.mu.Lock()
+	defer stx.mu.Unlock()
+	return stx.tx.Commit()
+}
+
+// sessionKey is the context key of the session a request is bound to.
+type sessionKey struct{}
+
+// Bind returns a copy of ctx bound to the session, so that queries and
+// statements executed with it run in the session's transactions.
+func (s *Session) Bind(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// sessionFrom returns the session ctx is bound to, or nil.
+func sessionFrom(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Transactions returns the session's open transactions, expiring after ttl,
+// ordered by connection.
+func (s *Session) Transactions(ttl time.Duration) []TransactionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]TransactionInfo, 0, len(s.transactions))
+	for _, stx := range s.transactions {
+		infos = append(infos, stx.info(ttl))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectionID < infos[j].ConnectionID
+	})
+	return infos
+}
+
+// rollbackIdle rolls back the session's transactions idle since before the
+// cutoff, returning their number.
+func (s *Session) rollbackIdle(cutoff time.Time) int {
+	s.mu.Lock()
+	var idle []*sessionTx
+	for id, stx := range s.transactions {
+		if time.Unix(0, stx.lastUsed.Load()).Before(cutoff) {
+			delete(s.transactions, id)
+			idle = append(idle, stx)
+		}
+	}
+	s.mu.Unlock()
+	for _, stx := range idle {
+		stx.end(false)
+	}
+	return len(idle)
+}
+
+// transaction returns the transaction the session of ctx holds open on the
+// connection, if any.
+func (conn *Connection) transaction(ctx context.Context) *sessionTx {
+	s := sessionFrom(ctx)
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactions[conn.ID]
+}
+
+// inTransaction prepares the query to be executed in the transaction,
+// executing the transaction's statements one at a time.
+func (stx *sessionTx) inTransaction(query string) *deadlineQuery {
+	stx.mu.Lock()
+	stx.lastUsed.Store(time.Now().UnixNano())
+	stx.statements.Add(1)
+	return &deadlineQuery{db: stx.tx, query: query, release: stx.mu.Unlock}
+}
+
+// BeginTransaction begins a transaction on the connection with the ID (or
+// alias), held open by the session ctx is bound to across its requests,
+// until committed, rolled back, or idle beyond the transaction timeout.
+// Only queries and statements of the session run in the transaction.
+func (cp *ConnectionPool) BeginTransaction(ctx context.Context, id string, opts sql.TxOptions) (*TransactionInfo, error) {
+	s := sessionFrom(ctx)
+	if s == nil {
+		return nil, fmt.Errorf("transactions are only held open in sessions")
+	}
+	if cp.IsGroup(id) {
+		return nil, fmt.Errorf("transactions cannot be opened on connection group %s", id)
+	}
+	cp.mu.RLock()
+	conn, exists := cp.lookup(id)
+	cp.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("connection with ID %s not found", id)
+	}
+	if conn.transaction(ctx) != nil {
+		return nil, fmt.Errorf("a transaction is already open on connection %s", conn.ID)
+	}
+
+	conn.touch()
+	if err := conn.dial(ctx); err != nil {
+		return nil, err
+	}
+	// The transaction outlives the request, so it cannot be bound to its
+	// context
+	tx, err := conn.DB.BeginTx(context.Background(), &opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	now := time.Now()
+	stx := &sessionTx{
+		tx:      tx,
+		conn:    conn,
+		opts:    opts,
+		started: now,
+		release: conn.use(func() {}),
+	}
+	stx.lastUsed.Store(now.UnixNano())
+
+	s.mu.Lock()
+	if _, ok := s.transactions[conn.ID]; ok {
+		s.mu.Unlock()
+		stx.end(false)
+		return nil, fmt.Errorf("a transaction is already open on connection %s", conn.ID)
+	}
+	s.transactions[conn.ID] = stx
+	s.mu.Unlock()
+	info := stx.info(cp.sessions.txTTL)
+	return &info, nil
+}
+
+// EndTransaction commits, or rolls back, the transaction the session ctx is
+// bound to holds open on the connection with the ID (or alias).
+func (cp *ConnectionPool) EndTransaction(ctx context.Context, id string, commit bool) (*TransactionInfo, error) {
+	s := sessionFrom(ctx)
+	if s == nil {
+		return nil, fmt.Errorf("transactions are only held open in sessions")
+	}
+	id = cp.Resolve(id)
+	s.mu.Lock()
+	stx, ok := s.transactions[id]
+	delete(s.transactions, id)
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no transaction is open on connection %s", id)
+	}
+	info := stx.info(cp.sessions.txTTL)
+	info.Outcome = TxRolledBack
+	if commit {
+		info.Outcome = TxCommitted
+	}
+	if err := stx.end(commit); err != nil {
+		return nil, fmt.Errorf("failed to end transaction: %w", err)
+	}
+	if commit && info.Statements != 0 {
+		// results cached while the transaction was open are stale
+		cp.cache.Invalidate(stx.conn.ID)
+	}
+	return &info, nil
+}
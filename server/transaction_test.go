@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xo/dburl"
+	"github.com/xo/usql/server/mcp"
+)
+
+func TestSessionTransactions(t *testing.T) {
+	u, _ := dburl.Parse("postgres://localhost/db")
+	rec := new(txConnector)
+	cp := NewConnectionPool(&Config{}, nil, nil)
+	defer cp.Close()
+	cp.connections["c"] = &Connection{ID: "c", URL: u, DB: sql.OpenDB(rec), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+
+	if _, err := cp.BeginTransaction(context.Background(), "c", sql.TxOptions{}); err == nil {
+		t.Errorf("expected an error beginning a transaction outside a session")
+	}
+	a, b := cp.sessions.Create(context.Background()), cp.sessions.Create(context.Background())
+	ctxA, ctxB := a.Bind(context.Background()), b.Bind(context.Background())
+	if _, err := cp.BeginTransaction(ctxA, "c", sql.TxOptions{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := cp.BeginTransaction(ctxA, "c", sql.TxOptions{}); err == nil {
+		t.Errorf("expected an error beginning a second transaction on the connection")
+	}
+	if !cp.connections["c"].busy() {
+		t.Errorf("expected the connection to be in use while the transaction is open")
+	}
+
+	// only statements of the session run in its transaction
+	conn := cp.connections["c"]
+	for _, test := range []struct {
+		ctx   context.Context
+		query string
+	}{
+		{ctxA, "UPDATE a"},
+		{ctxB, "UPDATE b"},
+		{context.Background(), "UPDATE none"},
+	} {
+		if _, err := conn.ExecuteStatement(test.ctx, test.query); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if _, err := cp.EndTransaction(ctxB, "c", true); err == nil {
+		t.Errorf("expected an error committing the transaction of another session")
+	}
+	info, err := cp.EndTransaction(ctxA, "c", true)
+	if err != nil || info.Outcome != TxCommitted || info.Statements != 1 {
+		t.Fatalf("expected the transaction committed after 1 statement, got: %+v %v", info, err)
+	}
+	if exp := []string{"BEGIN", "tx: UPDATE a", "UPDATE b", "UPDATE none", "COMMIT"}; !slices.Equal(rec.statements(), exp) {
+		t.Errorf("expected %v, got: %v", exp, rec.statements())
+	}
+
+	// idle transactions are rolled back, as are those of closed sessions
+	for _, s := range []*Session{a, b} {
+		if _, err := cp.BeginTransaction(s.Bind(context.Background()), "c", sql.TxOptions{}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if n := a.rollbackIdle(time.Now().Add(time.Second)); n != 1 {
+		t.Errorf("expected 1 idle transaction rolled back, got: %d", n)
+	}
+	if err := cp.sessions.Close(b.ID); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if exp := []string{"BEGIN", "BEGIN", "ROLLBACK", "ROLLBACK"}; !slices.Equal(rec.statements()[5:], exp) {
+		t.Errorf("expected %v, got: %v", exp, rec.statements()[5:])
+	}
+	if conn.busy() {
+		t.Errorf("expected the connection not to be in use once the transactions ended")
+	}
+}
+
+func TestSessionTransactionRows(t *testing.T) {
+	s, err := New(&Config{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	u, _ := dburl.Parse("postgres://localhost/db")
+	conn := &Connection{ID: "c", URL: u, DB: sql.OpenDB(new(txConnector)), faults: NewFaultInjector(FaultConfig{}), throttle: NewThrottle(ServerConfig{})}
+	s.pool.connections["c"] = conn
+	session := s.pool.sessions.Create(context.Background())
+	ctx := session.Bind(context.Background())
+	if _, err := s.pool.BeginTransaction(ctx, "c", sql.TxOptions{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := conn.ExecuteStatement(ctx, "INSERT a"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// the row inserted in the transaction is read back in it, by rows,
+	// cursors and streams, and only in it
+	for _, test := range []struct {
+		ctx     context.Context
+		session string
+		exp     []string
+	}{
+		{ctx, session.ID, []string{"a"}},
+		{context.Background(), "", nil},
+	} {
+		it, err := conn.QueryRows(test.ctx, "SELECT v")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		var rows []string
+		for it.Next() {
+			rows = append(rows, fmt.Sprint(it.Row()...))
+		}
+		it.Close()
+		if !slices.Equal(rows, test.exp) {
+			t.Errorf("expected the rows %v, got: %v", test.exp, rows)
+		}
+
+		cursor, err := s.pool.OpenCursor(test.ctx, "c", "SELECT v")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		page, err := s.pool.cursors.Fetch(test.ctx, cursor.ID, 0)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		rows = nil
+		for _, row := range page.Rows {
+			rows = append(rows, fmt.Sprint(row...))
+		}
+		if !slices.Equal(rows, test.exp) {
+			t.Errorf("expected the cursor's rows %v, got: %v", test.exp, rows)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/connections/c/query/stream", strings.NewReader(`{"query": "SELECT v"}`))
+		r.SetPathValue("id", "c")
+		if test.session != "" {
+			r.Header.Set(mcp.SessionHeader, test.session)
+		}
+		w := httptest.NewRecorder()
+		s.handleQueryStream(w, r)
+		if n := strings.Count(w.Body.String(), `["a"]`); n != len(test.exp) {
+			t.Errorf("expected the streamed rows %v, got: %s", test.exp, w.Body.String())
+		}
+	}
+
+	// streams of unknown sessions fail
+	r := httptest.NewRequest(http.MethodPost, "/v1/connections/c/query/stream", strings.NewReader(`{"query": "SELECT v"}`))
+	r.SetPathValue("id", "c")
+	r.Header.Set(mcp.SessionHeader, "unknown")
+	w := httptest.NewRecorder()
+	s.handleQueryStream(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got: %d", http.StatusNotFound, w.Code)
+	}
+
+	// the row is read outside the transaction once committed
+	if _, err := s.pool.EndTransaction(ctx, "c", true); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if result, err := conn.ExecuteQuery(context.Background(), "SELECT v"); err != nil || len(result.Rows) != 1 {
+		t.Errorf("expected the committed row, got: %+v %v", result, err)
+	}
+}
+
+func TestParseIsolation(t *testing.T) {
+	for level, exp := range map[string]sql.IsolationLevel{
+		"":                sql.LevelDefault,
+		"repeatable_read": sql.LevelRepeatableRead,
+		"Read Committed":  sql.LevelReadCommitted,
+		"serializable":    sql.LevelSerializable,
+	} {
+		if l, err := ParseIsolation(level); err != nil || l != exp {
+			t.Errorf("expected %q to parse as %v, got: %v %v", level, exp, l, err)
+		}
+	}
+	if _, err := ParseIsolation("eventual"); err == nil {
+		t.Errorf("expected an error parsing an invalid isolation level")
+	}
+}
+
+// txConnector is a driver connector recording the statements executed, and
+// the transactions begun and ended, prefixing statements executed in a
+// transaction with tx. The values of INSERT statements are the rows of
+// queries, once committed.
+type txConnector struct {
+	mu   sync.Mutex
+	stmt []string
+	rows []string
+}
+
+func (c *txConnector) Connect(context.Context) (driver.Conn, error) {
+	return &txConn{c: c}, nil
+}
+func (c *txConnector) Driver() driver.Driver { return nil }
+
+func (c *txConnector) record(stmt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stmt = append(c.stmt, stmt)
+}
+
+func (c *txConnector) statements() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.stmt)
+}
+
+type txConn struct {
+	c  *txConnector
+	tx bool
+
+	// inserted are the values inserted by the transaction
+	inserted []string
+}
+
+func (*txConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (*txConn) Close() error                        { return nil }
+func (tc *txConn) Begin() (driver.Tx, error) {
+	tc.tx = true
+	tc.c.record("BEGIN")
+	return tc, nil
+}
+
+func (tc *txConn) Commit() error {
+	tc.tx = false
+	tc.c.record("COMMIT")
+	tc.c.mu.Lock()
+	tc.c.rows = append(tc.c.rows, tc.inserted...)
+	tc.c.mu.Unlock()
+	tc.inserted = nil
+	return nil
+}
+
+func (tc *txConn) Rollback() error {
+	tc.tx, tc.inserted = false, nil
+	tc.c.record("ROLLBACK")
+	return nil
+}
+
+func (tc *txConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if v, ok := strings.CutPrefix(query, "INSERT "); ok && tc.tx {
+		tc.inserted = append(tc.inserted, v)
+	} else if ok {
+		tc.c.mu.Lock()
+		tc.c.rows = append(tc.c.rows, v)
+		tc.c.mu.Unlock()
+	}
+	if tc.tx {
+		query = "tx: " + query
+	}
+	tc.c.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (tc *txConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	tc.c.mu.Lock()
+	defer tc.c.mu.Unlock()
+	rows := slices.Concat(tc.c.rows, tc.inserted)
+	return &txRows{rows: rows}, nil
+}
+
+// txRows are the rows of a column v of the values inserted.
+type txRows struct {
+	rows []string
+}
+
+func (*txRows) Columns() []string { return []string{"v"} }
+func (*txRows) Close() error      { return nil }
+func (r *txRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	dest[0], r.rows = r.rows[0], r.rows[1:]
+	return nil
+}
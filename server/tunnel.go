@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xo/dburl"
+)
+
+// tunnelDialTimeout bounds dialing the database through a tunnel.
+const tunnelDialTimeout = 30 * time.Second
+
+// dialer dials connections to a database reached other than directly, such
+// as through a cloud connector or an SSH jump host.
+type dialer interface {
+	dial(ctx context.Context) (net.Conn, error)
+	// close releases the dialer's resources once its tunnel is closed,
+	// leaving it usable by a later tunnel
+	close()
+}
+
+// tunnel forwards the connections accepted on a loopback listener to the
+// database reached by its dialer, so that drivers reach the database by
+// connecting to the listener.
+type tunnel struct {
+	ln     net.Listener
+	dialer dialer
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// defaultPorts are the default ports of drivers, for databases reached
+// through a tunnel without a port in their DSN.
+var defaultPorts = map[string]string{
+	"postgres":   "5432",
+	"pgx":        "5432",
+	"mysql":      "3306",
+	"sqlserver":  "1433",
+	"oracle":     "1521",
+	"godror":     "1521",
+	"clickhouse": "9000",
+	"redshift":   "5439",
+	"cockroach":  "26257",
+	"vertica":    "5433",
+}
+
+// parseRoute returns the DSN without the parameters of its route, and the
+// dialer of the database when it is reached through a tunnel: a Cloud SQL
+// instance, a database behind the SSH jump host, or a database dialed
+// through the proxy. The first hop (the jump host, or else the database) is
+// dialed through the proxy unless it is one of its NoProxy hosts. The dialer
+// is nil when the database is dialed directly.
+func parseRoute(ctx context.Context, u *dburl.URL, jump *SSHConfig, proxy ProxyConfig) (*dburl.URL, dialer, error) {
+	hop := u.Hostname()
+	if jump != nil {
+		hop, _, _ = net.SplitHostPort(jump.Host)
+		if hop == "" {
+			hop = jump.Host
+		}
+	}
+	netDial, err := proxy.dialFor(hop)
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy: %w", err)
+	}
+	proxied := netDial != nil
+	if !proxied {
+		netDial = directDial
+	}
+
+	u, d, err := parseCloudSQL(ctx, u, netDial)
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case d != nil && jump != nil:
+		return nil, nil, errors.New("cloud sql instances can't be reached through an SSH jump host")
+	case d != nil:
+		return u, d, nil
+	case jump != nil:
+		if d, err = newSSHDialer(*jump, u, netDial); err != nil {
+			return nil, nil, fmt.Errorf("ssh: %w", err)
+		}
+		return u, d, nil
+	case proxied && u.Hostname() != "":
+		// databases without a host, such as files, aren't dialed
+		target, err := dbAddr(u)
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxy: %w", err)
+		}
+		return u, &proxyDialer{target: target, netDial: netDial}, nil
+	}
+	return u, nil, nil
+}
+
+// dbAddr returns the host and port of the database at the DSN, with the
+// driver's default port when the DSN has none.
+func dbAddr(u *dburl.URL) (string, error) {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = defaultPorts[u.Driver]
+	}
+	switch {
+	case host == "":
+		return "", errors.New("a database host is required")
+	case port == "":
+		return "", fmt.Errorf("a database port is required for driver %s", u.Driver)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// startTunnel starts a tunnel to the database reached by the dialer.
+func startTunnel(d dialer) (*tunnel, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &tunnel{
+		ln:     ln,
+		dialer: d,
+		ctx:    ctx,
+		cancel: cancel,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	t.wg.Add(1)
+	go t.serve()
+	return t, nil
+}
+
+// url returns the DSN connecting to the database through the tunnel.
+func (t *tunnel) url(u *dburl.URL) (*dburl.URL, error) {
+	v := u.URL
+	v.Host = t.ln.Addr().String()
+	return dburl.Parse(v.String())
+}
+
+// serve accepts and forwards connections until the tunnel is closed.
+func (t *tunnel) serve() {
+	defer t.wg.Done()
+	for {
+		c, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+		t.wg.Add(1)
+		go t.forward(c)
+	}
+}
+
+// forward forwards an accepted connection to the database.
+func (t *tunnel) forward(c net.Conn) {
+	defer t.wg.Done()
+	ctx, cancel := context.WithTimeout(t.ctx, tunnelDialTimeout)
+	remote, err := t.dialer.dial(ctx)
+	cancel()
+	if err != nil {
+		poolLog.Error("tunnel error", "error", err)
+		c.Close()
+		return
+	}
+	if !t.track(c, remote) {
+		return
+	}
+	defer t.untrack(c, remote)
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(remote, c)
+	go pipe(c, remote)
+	// either side closing ends the connection
+	<-done
+	c.Close()
+	remote.Close()
+	<-done
+}
+
+// track records the connections as open, closing them instead when the
+// tunnel is closed.
+func (t *tunnel) track(conns ...net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx.Err() != nil {
+		for _, c := range conns {
+			c.Close()
+		}
+		return false
+	}
+	for _, c := range conns {
+		t.conns[c] = struct{}{}
+	}
+	return true
+}
+
+// untrack removes the connections from the open connections.
+func (t *tunnel) untrack(conns ...net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range conns {
+		delete(t.conns, c)
+	}
+}
+
+// Close stops the tunnel, closing its open connections.
+func (t *tunnel) Close() error {
+	err := t.ln.Close()
+	t.mu.Lock()
+	t.cancel()
+	for c := range t.conns {
+		c.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	t.dialer.close()
+	return err
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// upgradeEnv is the environment variable set for a process started to take
+// over the listeners of a running server, naming the listeners passed as
+// the files from fd 3, which are followed by the pipes signaling the process
+// is ready and carrying the state handed over.
+const upgradeEnv = "USQLR_UPGRADE"
+
+// namedListener is a listener of the server, named after what it serves.
+type namedListener struct {
+	name string
+	net.Listener
+}
+
+// handoverState is the state handed over to the process taking over the
+// listeners: the connections, and the sessions, so clients keep theirs.
+type handoverState struct {
+	Connections []ConnectionDefinition `json:"connections"`
+	Sessions    []sessionState         `json:"sessions"`
+}
+
+// handover is what a process started to take over the listeners of a
+// running server inherits from it, or the listeners systemd passes it on
+// socket activation, without pipes.
+type handover struct {
+	listeners map[string]net.Listener
+	ready     *os.File
+	state     *os.File
+}
+
+// inherit returns what the process inherits from the server it takes over
+// from, or from systemd, its sockets matched to the listeners with the
+// names, or nil when it inherits nothing.
+func inherit(listenerNames []string) (*handover, error) {
+	names := os.Getenv(upgradeEnv)
+	if names == "" {
+		listeners, err := systemdListeners(listenerNames)
+		if err != nil || listeners == nil {
+			return nil, err
+		}
+		return &handover{listeners: listeners}, nil
+	}
+	os.Unsetenv(upgradeEnv)
+	h := &handover{listeners: make(map[string]net.Listener)}
+	fd := uintptr(3)
+	for _, name := range strings.Split(names, ",") {
+		f := os.NewFile(fd, name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit %s listener: %w", name, err)
+		}
+		// the socket files are removed on shutdown, as by the server taken
+		// over from
+		if l, ok := ln.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(true)
+		}
+		h.listeners[name] = ln
+		fd++
+	}
+	h.ready, h.state = os.NewFile(fd, "ready"), os.NewFile(fd+1, "state")
+	return h, nil
+}
+
+// listen returns the listener with the name inherited, or listens on the
+// address, tracking the listener to hand it over on upgrades.
+func (s *Server) listen(h *handover, name, addr string) (net.Listener, error) {
+	ln, ok := h.take(name)
+	if !ok {
+		var err error
+		if ln, err = listenAddress(addr); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, namedListener{name, ln})
+	s.mu.Unlock()
+	return ln, nil
+}
+
+// take removes and returns the inherited listener with the name.
+func (h *handover) take(name string) (net.Listener, bool) {
+	if h == nil {
+		return nil, false
+	}
+	ln, ok := h.listeners[name]
+	delete(h.listeners, name)
+	return ln, ok
+}
+
+// closeUnused closes the inherited listeners not taken.
+func (h *handover) closeUnused() {
+	for name, ln := range h.listeners {
+		serverLog.Warn("closing inherited listener not configured", "listener", name)
+		ln.Close()
+	}
+}
+
+// takeOver signals the server taken over from that the process is ready,
+// and restores the state it hands over. The process is first made the main
+// process of the service when run by systemd, as the server exits once
+// taken over from.
+func (s *Server) takeOver(ctx context.Context, h *handover) {
+	defer h.state.Close()
+	if err := notify(fmt.Sprintf("MAINPID=%d", os.Getpid())); err != nil {
+		serverLog.Error("failed to notify systemd of the new main process", "error", err)
+	}
+	_, err := h.ready.Write([]byte{1})
+	h.ready.Close()
+	if err != nil {
+		serverLog.Error("failed to signal readiness to the previous process", "error", err)
+		return
+	}
+	var state handoverState
+	if err := json.NewDecoder(h.state).Decode(&state); err != nil {
+		serverLog.Error("failed to read the state handed over", "error", err)
+		return
+	}
+	var created int
+	for _, def := range state.Connections {
+		if _, err := s.pool.GetConnection(def.ID); err == nil {
+			continue
+		}
+		if err := s.createConnection(ctx, def); err != nil {
+			serverLog.Error("failed to create connection handed over", "connection", def.ID, "error", err)
+			continue
+		}
+		created++
+	}
+	s.pool.sessions.restore(state.Sessions)
+	serverLog.Info("took over listeners", "connections", created, "sessions", len(state.Sessions))
+}
+
+// Upgrade starts the server's executable anew, with the same arguments, to
+// take over its listeners. Once the new process is ready, the server stops
+// accepting connections and refuses new work, and hands over its connections
+// and sessions, so clients keep them. The server is then to be shut down,
+// draining its work in progress.
+//
+// The new process is killed when ctx is done before it is ready, the server
+// then serving on.
+func (s *Server) Upgrade(ctx context.Context) (err error) {
+	if s.draining.Load() {
+		return errDraining
+	}
+	if !s.upgrading.CompareAndSwap(false, true) {
+		return errors.New("an upgrade is in progress")
+	}
+	defer func() {
+		if err != nil {
+			s.upgrading.Store(false)
+		}
+	}()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// pass the listeners, followed by the pipes
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range listeners {
+		l, ok := ln.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%s listener cannot be handed over", ln.name)
+		}
+		f, err := l.File()
+		if err != nil {
+			return fmt.Errorf("%s listener: %w", ln.name, err)
+		}
+		names, files = append(names, ln.name), append(files, f)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+	stateR, stateW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stateW.Close()
+	files = append(files, stateR)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", exe, err)
+	}
+	go cmd.Wait()
+	serverLog.Info("started process to take over listeners", "pid", cmd.Process.Pid)
+	for _, f := range files[:len(listeners)] {
+		if err := setNonblock(f); err != nil {
+			serverLog.Error("failed to set listener non-blocking", "error", err)
+		}
+	}
+
+	// wait for the process to be ready, reading failing once it exits with
+	// the files passed closed
+	for _, f := range files {
+		f.Close()
+	}
+	files = nil
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("process %d failed to take over: %w", cmd.Process.Pid, err)
+	}
+
+	// stop accepting connections, and hand over
+	s.handedOver.Store(true)
+	s.draining.Store(true)
+	for _, ln := range listeners {
+		// the socket files are the new process's
+		if l, ok := ln.Listener.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+		ln.Close()
+	}
+	state := handoverState{
+		Connections: s.pool.Definitions(),
+		Sessions:    s.pool.sessions.states(),
+	}
+	if err := json.NewEncoder(stateW).Encode(state); err != nil {
+		serverLog.Error("failed to hand over state", "error", err)
+	}
+	serverLog.Info("handed over listeners", "pid", cmd.Process.Pid, "connections", len(state.Connections), "sessions", len(state.Sessions))
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "os"
+
+// setNonblock does nothing, as listeners are not passed to processes.
+func setNonblock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTakeOver(t *testing.T) {
+	from, err := New(&Config{Server: ServerConfig{RequestTimeout: time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer from.Shutdown(context.Background())
+	session := from.pool.sessions.Create(context.Background())
+	session.connection = "c"
+	session.variables["limit"] = "10"
+
+	s, err := New(&Config{Server: ServerConfig{RequestTimeout: time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer readyR.Close()
+	stateR, stateW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	h := &handover{listeners: map[string]net.Listener{"admin": unused}, ready: readyW, state: stateR}
+
+	h.closeUnused()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.takeOver(context.Background(), h)
+	}()
+	if _, err := readyR.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("expected the process to signal it is ready, got: %v", err)
+	}
+	if err := json.NewEncoder(stateW).Encode(handoverState{Sessions: from.pool.sessions.states()}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	stateW.Close()
+	<-done
+
+	got, ok := s.pool.sessions.Get(context.Background(), session.ID)
+	if !ok {
+		t.Fatalf("expected the session handed over")
+	}
+	if got.connection != "c" || got.variables["limit"] != "10" || !got.Created.Equal(session.Created) {
+		t.Errorf("expected the session's state handed over, got: %q %v %v", got.connection, got.variables, got.Created)
+	}
+	if _, err := unused.Accept(); err == nil {
+		t.Errorf("expected the inherited listener left unused to be closed")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// setNonblock puts the file back in non-blocking mode, which passing it to a
+// process clears, as it is shared with the listener the file was duplicated
+// from, whose accepts would otherwise block.
+func setNonblock(f *os.File) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		err = syscall.SetNonblock(int(fd), true)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultTimeout bounds each request to Vault.
+const vaultTimeout = 10 * time.Second
+
+// vaultBackend resolves ${vault:path#field} references to the field of a
+// secret read from HashiCorp Vault, either a static secret of a KV secrets
+// engine (version 1 or 2), or leased dynamic credentials, such as those of
+// the database secrets engine. Leases are shared by the references to the
+// same path, renewed until released by all of them, and then revoked.
+type vaultBackend struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+
+	mu     sync.Mutex
+	leases map[string]*vaultLease
+}
+
+// vaultLease is a lease on a secret read from Vault.
+type vaultLease struct {
+	id      string
+	path    string
+	data    map[string]interface{}
+	refs    int
+	expires time.Time
+	stop    chan struct{}
+}
+
+// vaultSecret is a secret read from Vault, or the response to a lease
+// renewal.
+type vaultSecret struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// newVaultBackend creates a Vault backend, authenticating with the token in
+// the environment variable TokenEnv.
+func newVaultBackend(config VaultConfig) (*vaultBackend, error) {
+	token := os.Getenv(config.TokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("a Vault token is required in $%s", config.TokenEnv)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CACert != "" {
+		buf, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &vaultBackend{
+		address:   strings.TrimRight(config.Address, "/"),
+		token:     token,
+		namespace: config.Namespace,
+		client:    &http.Client{Transport: transport, Timeout: vaultTimeout},
+		leases:    make(map[string]*vaultLease),
+	}, nil
+}
+
+// Resolve satisfies the SecretBackend interface.
+func (v *vaultBackend) Resolve(ctx context.Context, ref string) (string, func(), error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", nil, errors.New("reference must be a path and field, such as secret/data/db#password")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if l, ok := v.leases[path]; ok && time.Now().Before(l.expires) {
+		value, err := secretField(l.data, field)
+		if err != nil {
+			return "", nil, err
+		}
+		l.refs++
+		return value, v.releaser(l), nil
+	}
+
+	secret, err := v.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	data := secret.Data
+	// KV version 2 secrets are nested, with their metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	value, err := secretField(data, field)
+	if err != nil || secret.LeaseID == "" {
+		return value, nil, err
+	}
+
+	ttl := time.Duration(secret.LeaseDuration) * time.Second
+	l := &vaultLease{
+		id:      secret.LeaseID,
+		path:    path,
+		data:    data,
+		refs:    1,
+		expires: time.Now().Add(ttl),
+		stop:    make(chan struct{}),
+	}
+	v.leases[path] = l
+	if secret.Renewable && ttl > 0 {
+		go v.renew(l, ttl)
+	}
+	return value, v.releaser(l), nil
+}
+
+// releaser returns a func releasing a reference to the lease, which is
+// revoked once no longer referenced.
+func (v *vaultBackend) releaser(l *vaultLease) func() {
+	return sync.OnceFunc(func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if l.refs--; l.refs != 0 {
+			return
+		}
+		if v.leases[l.path] == l {
+			delete(v.leases, l.path)
+		}
+		close(l.stop)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+			defer cancel()
+			if _, err := v.request(ctx, http.MethodPut, "sys/leases/revoke", map[string]interface{}{"lease_id": l.id}); err != nil {
+				authLog.Error("failed to revoke Vault lease", "path", l.path, "error", err)
+			}
+		}()
+	})
+}
+
+// renew renews the lease once two thirds of its TTL passed, until released
+// or no longer renewable. Credentials reaching their max TTL are no longer
+// renewed, and connections using them need to be created again.
+func (v *vaultBackend) renew(l *vaultLease, ttl time.Duration) {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(ttl * 2 / 3):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+		secret, err := v.request(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{
+			"lease_id":  l.id,
+			"increment": int(ttl / time.Second),
+		})
+		cancel()
+		if err != nil {
+			authLog.Error("failed to renew Vault lease", "path", l.path, "error", err)
+			continue
+		}
+		ttl = time.Duration(secret.LeaseDuration) * time.Second
+		v.mu.Lock()
+		l.expires = time.Now().Add(ttl)
+		v.mu.Unlock()
+		if !secret.Renewable || ttl <= 0 {
+			authLog.Warn("Vault lease is no longer renewable", "path", l.path, "expires_in", ttl)
+			return
+		}
+	}
+}
+
+// request sends a request to the Vault API.
+func (v *vaultBackend) request(ctx context.Context, method, path string, body interface{}) (*vaultSecret, error) {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+strings.TrimLeft(path, "/"), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var secret vaultSecret
+	if res.StatusCode == http.StatusNoContent {
+		return &secret, nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		if len(secret.Errors) != 0 {
+			return nil, fmt.Errorf("Vault responded %d: %s", res.StatusCode, strings.Join(secret.Errors, "; "))
+		}
+		return nil, fmt.Errorf("Vault responded %d", res.StatusCode)
+	}
+	return &secret, nil
+}
+
+// secretField returns the field of a secret's data.
+func secretField(data map[string]interface{}, field string) (string, error) {
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %s not found", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"github.com/xo/usql/drivers"
+	"github.com/xo/usql/server/mcp"
+)
+
+// BuildInfo describes the build of the server: its version, the VCS commit
+// and date it was built from, the Go version, the build profile, and the
+// drivers built in.
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"build_date,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Profile   string   `json:"profile,omitempty"`
+	Drivers   []string `json:"drivers"`
+}
+
+// ReadBuildInfo returns the build info embedded by Go in the binary: the
+// module's version (dev when not built from a tagged module), and the
+// commit and its time when built in a VCS checkout, with the drivers built
+// in.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   "dev",
+		GoVersion: runtime.Version(),
+		Drivers:   []string{},
+	}
+	for name := range drivers.Available() {
+		info.Drivers = append(info.Drivers, name)
+	}
+	sort.Strings(info.Drivers)
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		info.Version = v
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.BuildDate = s.Value
+		}
+	}
+	return info
+}
+
+// SetBuildInfo sets the build info reported at /version, and to MCP clients
+// as the server info. It must be set before requests are served.
+func (s *Server) SetBuildInfo(info BuildInfo) {
+	s.build = info
+	s.mcpHandler.SetServerInfo(mcp.ServerInfo{
+		Name:      "usqlr",
+		Version:   info.Version,
+		Commit:    info.Commit,
+		BuildDate: info.BuildDate,
+		GoVersion: info.GoVersion,
+		Drivers:   info.Drivers,
+	})
+}
+
+// handleVersion handles reporting the build info.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.build)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildInfo(t *testing.T) {
+	s, err := New(&Config{Server: ServerConfig{EnableMCP: true, RequestTimeout: time.Minute}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	if info := s.build; info.Version == "" || info.GoVersion != runtime.Version() || info.Drivers == nil {
+		t.Errorf("expected the build info read by default, got: %+v", info)
+	}
+	s.SetBuildInfo(BuildInfo{Version: "v1.2.3", Commit: "abc", GoVersion: "go1.99", Drivers: []string{"sqlite3"}})
+
+	w := httptest.NewRecorder()
+	s.handleVersion(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info BuildInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if info.Version != "v1.2.3" || info.Commit != "abc" || len(info.Drivers) != 1 {
+		t.Errorf("expected the build info set, got: %+v", info)
+	}
+
+	var out bytes.Buffer
+	in := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}` + "\n"
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	var res struct {
+		Result struct {
+			ServerInfo map[string]interface{} `json:"serverInfo"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if si := res.Result.ServerInfo; si["name"] != "usqlr" || si["version"] != "v1.2.3" || si["commit"] != "abc" || si["goVersion"] != "go1.99" {
+		t.Errorf("expected the server info of the build, got: %v", si)
+	}
+}
@@ -0,0 +1,354 @@
+// Package xlsx writes tabular data as Excel (Office Open XML) workbooks.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Sheet is a worksheet of a workbook.
+//
+// Types are the database type names of the columns, used to store numeric
+// values scanned as strings (e.g. DECIMAL) as numbers.
+type Sheet struct {
+	Name    string
+	Columns []string
+	Types   []string
+	Rows    [][]interface{}
+}
+
+// Column widths, in characters.
+const (
+	minWidth = 8
+	maxWidth = 60
+)
+
+// Cell styles, indexes of the cellXfs in the stylesheet.
+const (
+	styleDefault = iota
+	styleHeader
+	styleDateTime
+	styleDate
+)
+
+// epoch is the Excel (1900 date system) epoch, accounting for Excel treating
+// 1900 as a leap year.
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Write writes the sheets as a workbook, with a bold header row of column
+// names, typed cells and columns sized to their content.
+func Write(w io.Writer, sheets []Sheet) error {
+	if len(sheets) == 0 {
+		return fmt.Errorf("workbook must have at least one sheet")
+	}
+	names := make([]string, len(sheets))
+	seen := make(map[string]bool)
+	for i, sheet := range sheets {
+		names[i] = sheetName(sheet.Name, i, seen)
+	}
+
+	z := zip.NewWriter(w)
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypes(len(sheets))},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", workbook(names)},
+		{"xl/_rels/workbook.xml.rels", workbookRels(len(sheets))},
+		{"xl/styles.xml", styles},
+	}
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+	for i, sheet := range sheets {
+		fw, err := z.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		if err := writeSheet(fw, sheet); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// writeSheet writes the worksheet XML for the sheet.
+func writeSheet(w io.Writer, sheet Sheet) error {
+	bw := bufio.NewWriter(w)
+	widths := make([]int, len(sheet.Columns))
+	for i, col := range sheet.Columns {
+		widths[i] = utf8.RuneCountInString(col)
+	}
+	for _, row := range sheet.Rows {
+		for i, v := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], utf8.RuneCountInString(display(v)))
+			}
+		}
+	}
+
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	bw.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	if len(widths) != 0 {
+		bw.WriteString("<cols>")
+		for i, width := range widths {
+			fmt.Fprintf(bw, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, min(max(width, minWidth), maxWidth)+2)
+		}
+		bw.WriteString("</cols>")
+	}
+	bw.WriteString("<sheetData>")
+	bw.WriteString(`<row r="1">`)
+	for i, col := range sheet.Columns {
+		writeCell(bw, cellRef(i, 1), col, "", styleHeader)
+	}
+	bw.WriteString("</row>")
+	for r, row := range sheet.Rows {
+		fmt.Fprintf(bw, `<row r="%d">`, r+2)
+		for i, v := range row {
+			var typ string
+			if i < len(sheet.Types) {
+				typ = sheet.Types[i]
+			}
+			writeCell(bw, cellRef(i, r+2), v, typ, styleDefault)
+		}
+		bw.WriteString("</row>")
+	}
+	bw.WriteString("</sheetData></worksheet>")
+	return bw.Flush()
+}
+
+// writeCell writes a cell holding the value v of a column with the database
+// type typ.
+func writeCell(w *bufio.Writer, ref string, v interface{}, typ string, style int) {
+	switch x := v.(type) {
+	case nil:
+		return
+	case bool:
+		b := 0
+		if x {
+			b = 1
+		}
+		fmt.Fprintf(w, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		return
+	case time.Time:
+		style := styleDateTime
+		if strings.Contains(strings.ToUpper(typ), "DATE") && !strings.Contains(strings.ToUpper(typ), "TIME") {
+			style = styleDate
+		}
+		// Excel has no time zones, so the serial is of the wall clock time
+		wall := time.Date(x.Year(), x.Month(), x.Day(), x.Hour(), x.Minute(), x.Second(), x.Nanosecond(), time.UTC)
+		serial := float64(wall.Sub(epoch)) / float64(24*time.Hour)
+		fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(serial, 'f', -1, 64))
+		return
+	case string:
+		if isNumericType(typ) {
+			if f, err := strconv.ParseFloat(x, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+				fmt.Fprintf(w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(f, 'f', -1, 64))
+				return
+			}
+		}
+	}
+	if f, ok := number(v); ok {
+		fmt.Fprintf(w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(f, 'f', -1, 64))
+		return
+	}
+	fmt.Fprintf(w, `<c r="%s" t="inlineStr"`, ref)
+	if style != styleDefault {
+		fmt.Fprintf(w, ` s="%d"`, style)
+	}
+	w.WriteString(`><is><t xml:space="preserve">`)
+	xml.EscapeText(w, []byte(sanitize(display(v))))
+	w.WriteString("</t></is></c>")
+}
+
+// number returns v as a float64 if it is a finite number.
+func number(v interface{}) (float64, bool) {
+	var f float64
+	switch x := v.(type) {
+	case int:
+		f = float64(x)
+	case int8:
+		f = float64(x)
+	case int16:
+		f = float64(x)
+	case int32:
+		f = float64(x)
+	case int64:
+		f = float64(x)
+	case uint:
+		f = float64(x)
+	case uint8:
+		f = float64(x)
+	case uint16:
+		f = float64(x)
+	case uint32:
+		f = float64(x)
+	case uint64:
+		f = float64(x)
+	case float32:
+		f = float64(x)
+	case float64:
+		f = x
+	case json.Number:
+		var err error
+		if f, err = x.Float64(); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	return f, !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// isNumericType reports whether the database type name is a numeric type.
+func isNumericType(typ string) bool {
+	typ = strings.ToUpper(typ)
+	for _, s := range []string{"INT", "DECIMAL", "NUMERIC", "NUMBER", "FLOAT", "DOUBLE", "REAL", "MONEY"} {
+		if strings.Contains(typ, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// display returns the text of the value used for sizing columns and for
+// string cells.
+func display(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// sanitize removes characters not allowed in XML documents.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r <= 0xd7ff) || (r >= 0xe000 && r <= 0xfffd) || (r >= 0x10000 && r <= 0x10ffff) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// cellRef returns the A1 reference of the cell in the 0-based column and
+// 1-based row.
+func cellRef(col, row int) string {
+	var name []byte
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = append([]byte{byte('A' + (col-1)%26)}, name...)
+	}
+	return string(name) + strconv.Itoa(row)
+}
+
+// sheetName returns a valid, unique sheet name for the i'th sheet.
+func sheetName(name string, i int, seen map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, strings.Trim(name, "'"))
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", i+1)
+	}
+	if utf8.RuneCountInString(name) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	for base, n := name, 2; seen[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		name = string([]rune(base)[:min(utf8.RuneCountInString(base), 31-len(suffix))]) + suffix
+	}
+	seen[strings.ToLower(name)] = true
+	return name
+}
+
+// contentTypes returns the package content types.
+func contentTypes(sheets int) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+// workbook returns the workbook part listing the sheets.
+func workbook(names []string) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, name := range names {
+		b.WriteString(`<sheet name="`)
+		xml.EscapeText(&b, []byte(name))
+		fmt.Fprintf(&b, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.String()
+}
+
+// workbookRels returns the workbook relationships. The sheets are rId1 to
+// rIdN, followed by the stylesheet.
+func workbookRels(sheets int) string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+// rootRels is the package relationships.
+const rootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// styles is the stylesheet, defining the default, header, date/time and
+// date cell styles.
+const styles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="4">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -0,0 +1,103 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCellRef(t *testing.T) {
+	tests := []struct {
+		col, row int
+		exp      string
+	}{
+		{0, 1, "A1"},
+		{25, 2, "Z2"},
+		{26, 3, "AA3"},
+		{701, 4, "ZZ4"},
+		{702, 5, "AAA5"},
+	}
+	for _, test := range tests {
+		if s := cellRef(test.col, test.row); s != test.exp {
+			t.Errorf("cellRef(%d, %d): expected %q, got: %q", test.col, test.row, test.exp, s)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	seen := make(map[string]bool)
+	tests := []struct {
+		name, exp string
+	}{
+		{"users", "users"},
+		{"Users", "Users (2)"},
+		{"", "Sheet3"},
+		{"a/b:c", "a_b_c"},
+		{strings.Repeat("x", 40), strings.Repeat("x", 31)},
+	}
+	for i, test := range tests {
+		if s := sheetName(test.name, i, seen); s != test.exp {
+			t.Errorf("sheetName(%q): expected %q, got: %q", test.name, test.exp, s)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	sheets := []Sheet{
+		{
+			Name:    "Result 1",
+			Columns: []string{"id", "price", "active", "created", "note"},
+			Types:   []string{"INTEGER", "DECIMAL", "BOOLEAN", "TIMESTAMP", "TEXT"},
+			Rows: [][]interface{}{
+				{int64(1), "12.50", true, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), "a < b"},
+				{int64(2), nil, false, nil, "x"},
+			},
+		},
+		{Name: "Result 2", Columns: []string{"n"}, Rows: [][]interface{}{{3.5}}},
+	}
+	if err := Write(&buf, sheets); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("expected a valid zip archive, got: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		b, _ := io.ReadAll(r)
+		files[f.Name] = string(b)
+	}
+
+	if s := files["xl/workbook.xml"]; !strings.Contains(s, `name="Result 1"`) || !strings.Contains(s, `name="Result 2"`) {
+		t.Errorf("expected workbook to list both sheets, got: %s", s)
+	}
+	sheet := files["xl/worksheets/sheet1.xml"]
+	for _, exp := range []string{
+		`<c r="A1" t="inlineStr" s="1"><is><t xml:space="preserve">id</t></is></c>`,
+		`<c r="A2"><v>1</v></c>`,
+		`<c r="B2"><v>12.5</v></c>`,
+		`<c r="C2" t="b"><v>1</v></c>`,
+		`<c r="D2" s="2"><v>45293.5</v></c>`,
+		`<c r="E2" t="inlineStr"><is><t xml:space="preserve">a &lt; b</t></is></c>`,
+		`<c r="C3" t="b"><v>0</v></c>`,
+	} {
+		if !strings.Contains(sheet, exp) {
+			t.Errorf("expected sheet to contain %s, got: %s", exp, sheet)
+		}
+	}
+	if strings.Contains(sheet, `r="B3"`) {
+		t.Errorf("expected NULL cells to be omitted")
+	}
+	if s := files["xl/worksheets/sheet2.xml"]; !strings.Contains(s, `<c r="A2"><v>3.5</v></c>`) {
+		t.Errorf("expected second sheet to contain its row, got: %s", s)
+	}
+}